@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -34,6 +35,7 @@ import (
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/tgulacsi/imapclient/v2"
 	"github.com/tgulacsi/imapclient/v2/o365"
+	"github.com/tgulacsi/imapclient/v2/store"
 )
 
 const fetchBatchLen = 1024
@@ -75,6 +77,7 @@ func Main() error {
 	FS.StringVar(&userID, "user-id", os.Getenv("USER_ID"), "Office 365 user ID. Implies Graph API")
 	flagForceTLS := FS.Bool("force-tls", false, "force use of TLS")
 	flagForbidTLS := FS.Bool("forbid-tls", false, "forbid (force no TLS)")
+	flagDryRun := FS.Bool("dry-run", false, "only log the changes, do not make them")
 
 	app := ffcli.Command{Name: "imapdump", ShortHelp: "dump/load mail through IMAP", FlagSet: FS}
 
@@ -145,6 +148,9 @@ func Main() error {
 		if err := c.Connect(ctx); err != nil {
 			return nil, err
 		}
+		if *flagDryRun {
+			c = imapclient.DryRun(c, logger)
+		}
 		return c, nil
 	}
 
@@ -440,6 +446,136 @@ func Main() error {
 	}
 	app.Subcommands = append(app.Subcommands, &loadCmd)
 
+	FS = flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	dedupeContent := FS.Bool("content", false, "group by content hash, not by Message-ID")
+	dedupeDelete := FS.Bool("delete", false, "delete the duplicates (keep the first)")
+	dedupeCmd := ffcli.Command{Name: "dedupe", ShortHelp: "find (and delete) duplicate mails", FlagSet: FS,
+		ShortUsage: "dedupe [opts] <mailboxes - INBOX by default>",
+		Exec: func(rootCtx context.Context, args []string) error {
+			if len(args) == 0 {
+				args = []string{"INBOX"}
+			}
+			// A new connection for each mailbox, as Close expunges the selected one only.
+			dedupe := func(mbox string) error {
+				c, err := prepare(rootCtx)
+				if err != nil {
+					return err
+				}
+				ctx, cancel := context.WithTimeout(rootCtx, 10*time.Minute)
+				defer cancel()
+				dups, err := imapclient.FindDuplicates(ctx, c, mbox, *dedupeContent)
+				if err != nil {
+					cClose(c)
+					return err
+				}
+				for _, d := range dups {
+					uids := make([]string, len(d.UIDs))
+					for i, u := range d.UIDs {
+						uids[i] = strconv.FormatUint(uint64(u), 10)
+					}
+					fmt.Fprintf(os.Stdout, "%s\t%s\t%s\n", mbox, d.Key, strings.Join(uids, ","))
+				}
+				if !*dedupeDelete {
+					cClose(c)
+					return nil
+				}
+				n, err := imapclient.DeleteDuplicates(ctx, c, dups)
+				logger.Info("deleted", "mbox", mbox, "count", n, "error", err)
+				if closeErr := c.Close(ctx, err == nil); closeErr != nil && err == nil {
+					err = closeErr
+				}
+				return err
+			}
+			for _, mbox := range args {
+				if err := dedupe(mbox); err != nil {
+					return fmt.Errorf("%s: %w", mbox, err)
+				}
+			}
+			return nil
+		},
+	}
+	app.Subcommands = append(app.Subcommands, &dedupeCmd)
+
+	FS = flag.NewFlagSet("backup", flag.ContinueOnError)
+	backupDir := FS.String("dir", ".", "backup directory")
+	backupCmd := ffcli.Command{Name: "backup", ShortHelp: "backup the mailboxes, with a manifest", FlagSet: FS,
+		ShortUsage: "backup [-dir=backup] <mailbox> [mailbox...]",
+		Exec: func(rootCtx context.Context, args []string) error {
+			if len(args) == 0 {
+				args = []string{"INBOX"}
+			}
+			c, err := prepare(rootCtx)
+			if err != nil {
+				return err
+			}
+			defer cClose(c)
+			m, err := imapclient.Backup(rootCtx, c, *backupDir, args)
+			if err != nil {
+				return err
+			}
+			logger.Info("backup", "dir", *backupDir, "messages", len(m.Entries))
+			return nil
+		},
+	}
+	app.Subcommands = append(app.Subcommands, &backupCmd)
+
+	FS = flag.NewFlagSet("verify", flag.ContinueOnError)
+	FS.StringVar(backupDir, "dir", ".", "backup directory")
+	verifyCmd := ffcli.Command{Name: "verify", ShortHelp: "verify the backup against the live mailboxes", FlagSet: FS,
+		ShortUsage: "verify [-dir=backup]",
+		Exec: func(rootCtx context.Context, args []string) error {
+			c, err := prepare(rootCtx)
+			if err != nil {
+				return err
+			}
+			defer cClose(c)
+			r, err := imapclient.VerifyBackup(rootCtx, c, *backupDir)
+			if err != nil {
+				return err
+			}
+			for _, x := range []struct {
+				Name    string
+				Entries []imapclient.ManifestEntry
+			}{{"missing", r.Missing}, {"changed", r.Changed}, {"corrupt", r.Corrupt}, {"new", r.New}} {
+				for _, e := range x.Entries {
+					fmt.Printf("%s\t%s\t%d\t%s\n", x.Name, e.Mailbox, e.UID, e.MessageID)
+				}
+			}
+			if !r.OK() {
+				return errors.New("backup differs from the live mailboxes")
+			}
+			return nil
+		},
+	}
+	app.Subcommands = append(app.Subcommands, &verifyCmd)
+
+	FS = flag.NewFlagSet("restore", flag.ContinueOnError)
+	FS.StringVar(backupDir, "dir", ".", "backup directory")
+	restoreOpts := imapclient.RestoreOptions{Folders: make(imapclient.FolderMap)}
+	FS.BoolVar(&restoreOpts.SkipExisting, "skip-existing", true, "skip the messages already in the target folder")
+	FS.Func("map", "map the folder (with its subfolders) as from=to, to skip it if to is empty", func(s string) error {
+		from, to, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("%q: no = in folder mapping", s)
+		}
+		restoreOpts.Folders[from] = to
+		return nil
+	})
+	restoreCmd := ffcli.Command{Name: "restore", ShortHelp: "restore the backup", FlagSet: FS,
+		ShortUsage: "restore [-dir=backup] [-map=from=to...]",
+		Exec: func(rootCtx context.Context, args []string) error {
+			c, err := prepare(rootCtx)
+			if err != nil {
+				return err
+			}
+			defer cClose(c)
+			restored, skipped, err := imapclient.Restore(rootCtx, c, *backupDir, restoreOpts)
+			logger.Info("restore", "dir", *backupDir, "restored", restored, "skipped", skipped)
+			return err
+		},
+	}
+	app.Subcommands = append(app.Subcommands, &restoreCmd)
+
 	syncCmd := ffcli.Command{Name: "sync", ShortHelp: "synchronize (push missing message)",
 		ShortUsage: "sync <source mailbox in 'imaps://host:port/mbox?user=a@b&passw=xxx' format> <destination mailbox in 'imaps://host:port/mbox?user=a@b&passw=xxx' format>",
 		Exec: func(rootCtx context.Context, args []string) error {
@@ -468,6 +604,9 @@ func Main() error {
 			if err != nil {
 				return err
 			}
+			if *flagDryRun {
+				dst = imapclient.DryRun(dst, logger)
+			}
 			if verbose > 1 {
 				dst.SetLogMask(imapclient.LogAll)
 			}
@@ -505,6 +644,15 @@ func Main() error {
 				if err = rootCtx.Err(); err != nil {
 					return err
 				}
+				ctx, cancel = context.WithTimeout(rootCtx, 1*time.Minute)
+				err = imapclient.CheckAppendSize(ctx, dst, dstM.Mailbox, int64(m.Size))
+				cancel()
+				if errors.Is(err, imapclient.ErrTooLarge) {
+					logger.Warn("skip", "messageID", m.MessageID, "error", err)
+					continue
+				} else if err != nil {
+					return err
+				}
 				buf.Reset()
 				ctx, cancel = context.WithTimeout(rootCtx, 3*time.Minute)
 				_, err = src.ReadTo(ctx, &buf, m.UID)
@@ -528,6 +676,105 @@ func Main() error {
 		},
 	}
 	app.Subcommands = append(app.Subcommands, &syncCmd)
+
+	FS = flag.NewFlagSet("flagsync", flag.ContinueOnError)
+	flagSyncInterval := FS.Duration("interval", 0, "synchronize at every interval (once if zero)")
+	flagSyncDB := FS.String("db", "", "state database (SQLite) - the state is kept in memory if empty")
+	flagSyncCmd := ffcli.Command{Name: "flagsync", ShortHelp: "synchronize the \\Seen and \\Flagged flags in both directions", FlagSet: FS,
+		ShortUsage: "flagsync [-interval=5m] [-db=state.db] <mailbox A in 'imaps://host:port/mbox?user=a@b&passw=xxx' format> <mailbox B>",
+		Exec: func(rootCtx context.Context, args []string) error {
+			if len(args) != 2 {
+				return errors.New("two mailboxes are needed")
+			}
+			var fs imapclient.FlagSync
+			for i, arg := range args {
+				m, err := imapclient.ParseMailbox(arg)
+				if err != nil {
+					return err
+				}
+				ctx, cancel := context.WithTimeout(rootCtx, 1*time.Minute)
+				c, err := m.Connect(ctx)
+				cancel()
+				if err != nil {
+					return err
+				}
+				defer cClose(c)
+				if *flagDryRun {
+					c = imapclient.DryRun(c, logger)
+				}
+				if i == 0 {
+					fs.A, fs.MailboxA = c, m.Mailbox
+				} else {
+					fs.B, fs.MailboxB = c, m.Mailbox
+				}
+			}
+			fs.Logger = logger
+			if *flagSyncDB != "" {
+				db, err := store.Open(rootCtx, *flagSyncDB)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+				fs.Store = db
+			}
+			if *flagSyncInterval > 0 {
+				return fs.Run(rootCtx, *flagSyncInterval)
+			}
+			stats, err := fs.Sync(rootCtx)
+			logger.Info("flagsync", "matched", stats.Matched, "toA", stats.ToA, "toB", stats.ToB)
+			return err
+		},
+	}
+	app.Subcommands = append(app.Subcommands, &flagSyncCmd)
+
+	FS = flag.NewFlagSet("migrate", flag.ContinueOnError)
+	migrateDB := FS.String("db", "migrate.db", "state database (SQLite), for resuming")
+	migrateCmd := ffcli.Command{Name: "migrate", ShortHelp: "copy all the folders (resumable)", FlagSet: FS,
+		ShortUsage: "migrate [-db=migrate.db] <source in 'imaps://host:port/?user=a@b&passw=xxx' format> <destination> [source folders...]",
+		Exec: func(rootCtx context.Context, args []string) error {
+			if len(args) < 2 {
+				return errors.New("source and destination are needed")
+			}
+			m := imapclient.Migration{Folders: args[2:], FolderMap: imapclient.FolderMap{}, Logger: logger, DryRun: *flagDryRun}
+			for i, arg := range args[:2] {
+				mb, err := imapclient.ParseMailbox(arg)
+				if err != nil {
+					return err
+				}
+				ctx, cancel := context.WithTimeout(rootCtx, 1*time.Minute)
+				c, err := mb.Connect(ctx)
+				cancel()
+				if err != nil {
+					return err
+				}
+				defer cClose(c)
+				if i == 0 {
+					m.Src = c
+				} else {
+					m.Dst = c
+				}
+			}
+			db, err := store.Open(rootCtx, *migrateDB)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			m.State = db
+			var lastReport time.Time
+			m.Progress = func(p imapclient.MigrationProgress) {
+				if time.Since(lastReport) < 10*time.Second && p.Copied+p.Skipped != p.Messages {
+					return
+				}
+				lastReport = time.Now()
+				logger.Info("migrate", "folder", p.Folder, "folders", fmt.Sprintf("%d/%d", p.FoldersDone, p.Folders),
+					"messages", fmt.Sprintf("%d/%d", p.Copied+p.Skipped, p.Messages),
+					"bytes", fmt.Sprintf("%d/%d", p.Bytes, p.TotalBytes), "eta", p.ETA.Round(time.Second))
+			}
+			_, err = m.Run(rootCtx)
+			return err
+		},
+	}
+	app.Subcommands = append(app.Subcommands, &migrateCmd)
 	if err := app.Parse(os.Args[1:]); err != nil {
 		return err
 	}
@@ -0,0 +1,95 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// ProcessedAtHeader is added to the annotated archive copies, with the time of the delivery.
+	ProcessedAtHeader = "X-Processed-At"
+	// DeliveryIDHeader is added to the annotated archive copies, with the ID of the delivery.
+	DeliveryIDHeader = "X-Delivery-ID"
+)
+
+// annotator is implemented by the Clients which can move a message with added header fields.
+type annotator interface {
+	Annotate(ctx context.Context, msgID uint32, mbox string, headers [][2]string) error
+}
+
+var _ annotator = (*imapClient)(nil)
+
+// WithAnnotatedArchive stores an annotated copy of the delivered messages in the outbox
+// (or the Mailbox of the Result) instead of moving them, so the archive carries the provenance:
+// the ProcessedAtHeader and DeliveryIDHeader (deliveryID, or the hash of the message if nil) are added.
+//
+// On IMAP the copy is appended and the original deleted, on o365 the header fields are set
+// as internet header extended properties. Other Clients just move the message.
+func WithAnnotatedArchive(deliveryID func(uid uint32, hsh HashArray) string) LoopOption {
+	if deliveryID == nil {
+		deliveryID = func(_ uint32, hsh HashArray) string { return hsh.String() }
+	}
+	return func(o *loopOptions) { o.deliveryID = deliveryID }
+}
+
+// annotated adds the archive header fields to the Result of the delivered messages.
+func (deliver readDeliverer) annotated(deliveryID func(uid uint32, hsh HashArray) string) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		readErr, err := deliver(ctx, c, uid, hsh)
+		if readErr != nil {
+			return readErr, err
+		}
+		res := resultOf(err)
+		if res.Action != Delivered {
+			return nil, err
+		}
+		res.headers = [][2]string{
+			{ProcessedAtHeader, time.Now().Format(time.RFC1123Z)},
+			{DeliveryIDHeader, deliveryID(uid, hsh.Array())},
+		}
+		return nil, &res
+	}
+}
+
+// archive moves the delivered message to mbox, annotated with the headers if the Client can do it.
+func archive(ctx context.Context, c Client, uid uint32, mbox string, headers [][2]string) error {
+	if a, ok := As[annotator](c); ok && len(headers) != 0 {
+		return a.Annotate(ctx, uid, mbox, headers)
+	}
+	return c.Move(ctx, uid, mbox)
+}
+
+// Annotate appends a Seen copy of the message with the headers prepended to mbox, and deletes the original.
+func (c *imapClient) Annotate(ctx context.Context, msgID uint32, mbox string, headers [][2]string) error {
+	if c.readOnly {
+		return fmt.Errorf("annotate %d: %w", msgID, ErrReadOnly)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for _, kv := range headers {
+		buf.WriteString(kv[0] + ": " + kv[1] + "\r\n")
+	}
+	if _, err := c.Peek(ctx, buf, msgID, ""); err != nil {
+		return fmt.Errorf("read %d: %w", msgID, err)
+	}
+	date := time.Now()
+	if m, err := mail.ReadMessage(bytes.NewReader(buf.Bytes())); err == nil {
+		if d, err := m.Header.Date(); err == nil {
+			date = d
+		}
+	}
+	if err := c.countCommand(time.Now(), c.c.Append(c.mailbox(ctx, mbox), []string{imap.SeenFlag}, date, literalBytes(buf.Bytes()))); err != nil {
+		return fmt.Errorf("append to %s: %w", mbox, err)
+	}
+	c.CountUploaded(int64(buf.Len()))
+	return c.Delete(ctx, msgID)
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// annotateClient is a fakeClient recording the headers of the annotated messages, by their subject.
+type annotateClient struct {
+	*fakeClient
+	annotated map[string][][2]string
+}
+
+func (c annotateClient) Annotate(ctx context.Context, msgID uint32, mbox string, headers [][2]string) error {
+	c.annotated[c.mb.subject(msgID)] = headers
+	return c.Move(ctx, msgID, mbox)
+}
+
+func TestAnnotatedArchive(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		if strings.TrimSpace(strings.TrimPrefix(line, "Subject: ")) == "bad" {
+			return errors.New("bad")
+		}
+		return nil
+	}
+	for name, opts := range map[string][]LoopOption{
+		"annotated": {WithAnnotatedArchive(func(uid uint32, _ HashArray) string { return "id-" + strconv.Itoa(int(uid)) })},
+		"plain":     nil,
+	} {
+		inbox := newFakeMailbox()
+		for uid, subject := range []string{"ok", "bad"} {
+			inbox.add(uint32(uid+1), subject)
+		}
+		c := annotateClient{
+			fakeClient: &fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}},
+			annotated:  make(map[string][][2]string),
+		}
+		if _, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "Err", logger, opts...); err != nil {
+			t.Fatal(err)
+		}
+		for mbox, want := range map[string][]string{"Done": {"ok"}, "Err": {"bad"}} {
+			if got := c.box(mbox).sortedSubjects(); !slices.Equal(got, want) {
+				t.Errorf("%s: %s: got %v, wanted %v", name, mbox, got, want)
+			}
+		}
+		if opts == nil {
+			if len(c.annotated) != 0 {
+				t.Errorf("%s: annotated %v", name, c.annotated)
+			}
+			continue
+		}
+		if _, ok := c.annotated["bad"]; ok || len(c.annotated) != 1 {
+			t.Errorf("%s: annotated %v, wanted only ok", name, c.annotated)
+		}
+		headers := c.annotated["ok"]
+		if len(headers) != 2 || headers[0][0] != ProcessedAtHeader || headers[1] != [2]string{DeliveryIDHeader, "id-1"} {
+			t.Fatalf("%s: got %q", name, headers)
+		}
+		if _, err := time.Parse(time.RFC1123Z, headers[0][1]); err != nil {
+			t.Errorf("%s: %s: %+v", name, ProcessedAtHeader, err)
+		}
+	}
+}
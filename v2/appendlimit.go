@@ -0,0 +1,83 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// TooLargeError is returned for a message larger than the limit of the server,
+// before uploading it. It is an ErrTooLarge.
+type TooLargeError struct {
+	Mailbox     string
+	Size, Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("append to %q: size %d > %d: %s", e.Mailbox, e.Size, e.Limit, ErrTooLarge)
+}
+func (e *TooLargeError) Unwrap() error { return ErrTooLarge }
+
+// AppendLimiter is implemented by the Clients which know the maximal size of the messages their server accepts.
+type AppendLimiter interface {
+	// AppendLimit returns the maximal size of a message appended to mbox, 0 if there is no (known) limit.
+	AppendLimit(ctx context.Context, mbox string) (int64, error)
+}
+
+var _ AppendLimiter = (*imapClient)(nil)
+
+// CheckAppendSize returns a *TooLargeError if a message of the given size is larger than
+// the AppendLimit of mbox - so the migrations can skip it without reading and uploading it.
+// Clients which do not know their limit accept everything.
+func CheckAppendSize(ctx context.Context, c Client, mbox string, size int64) error {
+	al, ok := As[AppendLimiter](c)
+	if !ok {
+		return nil
+	}
+	limit, err := al.AppendLimit(ctx, mbox)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && size > limit {
+		return &TooLargeError{Mailbox: mbox, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// AppendLimit returns the APPENDLIMIT (RFC 7889) of the server, or of the mailbox
+// (with STATUS, cached till the next Connect) if the server has per-mailbox limits.
+func (c *imapClient) AppendLimit(ctx context.Context, mbox string) (int64, error) {
+	var perMailbox bool
+	for _, k := range c.info.Capabilities {
+		if k == "APPENDLIMIT" {
+			perMailbox = true
+		} else if s, ok := strings.CutPrefix(k, "APPENDLIMIT="); ok {
+			return strconv.ParseInt(s, 10, 64)
+		}
+	}
+	if !perMailbox {
+		return 0, nil
+	}
+	mbox = c.mailbox(ctx, mbox)
+	if limit, ok := c.appendLimits[mbox]; ok {
+		return limit, nil
+	}
+	start := time.Now()
+	status, err := c.c.Status(mbox, []imap.StatusItem{imap.StatusAppendLimit})
+	if err = c.countCommand(start, err); err != nil {
+		return 0, fmt.Errorf("STATUS %q: %w", mbox, err)
+	}
+	if c.appendLimits == nil {
+		c.appendLimits = make(map[string]int64)
+	}
+	c.appendLimits[mbox] = int64(status.AppendLimit)
+	return int64(status.AppendLimit), nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppendLimit(t *testing.T) {
+	ctx := context.Background()
+	c := &imapClient{info: ConnectInfo{Capabilities: []string{"APPENDLIMIT=100", "IMAP4rev1"}}}
+	if err := CheckAppendSize(ctx, c, "INBOX", 100); err != nil {
+		t.Errorf("100: %+v", err)
+	}
+	err := CheckAppendSize(ctx, c, "INBOX", 101)
+	var tle *TooLargeError
+	if !errors.Is(err, ErrTooLarge) || !errors.As(err, &tle) || tle.Limit != 100 || tle.Size != 101 {
+		t.Errorf("101: got %+v", err)
+	}
+	if err = c.WriteTo(ctx, "INBOX", make([]byte, 101), time.Time{}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("WriteTo: got %+v", err)
+	}
+
+	c.info.Capabilities = []string{"IMAP4rev1"}
+	if err := CheckAppendSize(ctx, c, "INBOX", 1<<40); err != nil {
+		t.Errorf("no limit: %+v", err)
+	}
+	c.info.Capabilities, c.appendLimits = []string{"APPENDLIMIT"}, map[string]int64{"INBOX": 10}
+	if err := CheckAppendSize(ctx, c, "INBOX", 11); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("per-mailbox: got %+v", err)
+	}
+}
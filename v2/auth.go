@@ -0,0 +1,64 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthSetter is implemented by the Clients whose credentials can be rotated without restart.
+//
+// The new credentials are used by the next Connect: the current session
+// (and the operations in flight on it) continues with the old ones.
+type AuthSetter interface {
+	SetAuth(username, password string)
+}
+
+// TokenSourceSetter is implemented by the Clients authenticating with an OAuth2 token,
+// whose TokenSource can be replaced at runtime.
+//
+// The IMAP Client uses the token as the password of the next Connect;
+// the o365 Client uses it for the next request.
+type TokenSourceSetter interface {
+	SetTokenSource(oauth2.TokenSource)
+}
+
+var (
+	_ AuthSetter        = (*imapClient)(nil)
+	_ TokenSourceSetter = (*imapClient)(nil)
+)
+
+// SetAuth sets the username and password for the next login,
+// and drops the TokenSource set by SetTokenSource.
+func (c *imapClient) SetAuth(username, password string) {
+	c.authMu.Lock()
+	c.Username, c.password, c.tokenSource = username, password, nil
+	c.authMu.Unlock()
+}
+
+// SetTokenSource sets the TokenSource whose access token is used
+// as the password (for XOAUTH2 / OAUTHBEARER) of the next login.
+func (c *imapClient) SetTokenSource(ts oauth2.TokenSource) {
+	c.authMu.Lock()
+	c.tokenSource = ts
+	c.authMu.Unlock()
+}
+
+// credentials returns the username and password for login.
+func (c *imapClient) credentials() (username, password string, err error) {
+	c.authMu.Lock()
+	username, password, ts := c.Username, c.password, c.tokenSource
+	c.authMu.Unlock()
+	if ts == nil {
+		return username, password, nil
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		return username, "", fmt.Errorf("get token: %w", err)
+	}
+	return username, tok.AccessToken, nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/renameio/v2"
+)
+
+// ManifestName is the name of the manifest file in the backup directory.
+const ManifestName = "manifest.json"
+
+// Manifest lists the messages of a backup, for verifying it later.
+type Manifest struct {
+	Created time.Time `json:"created"`
+	// Delimiter is the hierarchy delimiter of the mailbox names.
+	Delimiter string          `json:"delimiter,omitempty"`
+	Entries   []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry is a message of the backup.
+type ManifestEntry struct {
+	// Date is the INTERNALDATE of the message.
+	Date      time.Time `json:"date"`
+	Mailbox   string    `json:"mailbox"`
+	MessageID string    `json:"messageID,omitempty"`
+	// SHA256 is the hex encoded SHA-256 of the message.
+	SHA256 string `json:"sha256"`
+	// Path is the file of the message, relative to the backup directory.
+	Path        string   `json:"path"`
+	Flags       []string `json:"flags,omitempty"`
+	Size        int64    `json:"size"`
+	UIDValidity uint32   `json:"uidValidity,omitempty"`
+	UID         uint32   `json:"uid"`
+}
+
+// Backup writes the messages of the mailboxes into dir (each mailbox into its own,
+// path-escaped subdirectory, as UID.eml files), and the Manifest of them into dir/ManifestName.
+//
+// The messages are read with ReadTo, so with PEEK by default.
+func Backup(ctx context.Context, c Client, dir string, mailboxes []string) (*Manifest, error) {
+	m := Manifest{Created: time.Now().UTC(), Delimiter: Delimiter(ctx, c)}
+	var buf bytes.Buffer
+	for _, mbox := range mailboxes {
+		infos, err := ListInfo(ctx, c, mbox, "", true)
+		if err != nil {
+			return nil, fmt.Errorf("list %q: %w", mbox, err)
+		}
+		var validity uint32
+		if ss, ok := As[SelectedStatuser](c); ok {
+			if st, ok := ss.SelectedStatus(); ok {
+				validity = st.UIDValidity
+			}
+		}
+		sub := url.PathEscape(mbox)
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			return nil, err
+		}
+		for _, mi := range infos {
+			buf.Reset()
+			if _, err := c.ReadTo(ctx, &buf, mi.UID); err != nil {
+				return nil, fmt.Errorf("read %q/%d: %w", mbox, mi.UID, err)
+			}
+			e := ManifestEntry{
+				Mailbox: mbox, UID: mi.UID, UIDValidity: validity, MessageID: mi.MessageID,
+				Date: mi.InternalDate, Flags: mi.Flags,
+				Path: sub + "/" + strconv.FormatUint(uint64(mi.UID), 10) + ".eml",
+				Size: int64(buf.Len()), SHA256: sha256Hex(buf.Bytes()),
+			}
+			if err := renameio.WriteFile(filepath.Join(dir, filepath.FromSlash(e.Path)), buf.Bytes(), 0640); err != nil {
+				return nil, err
+			}
+			m.Entries = append(m.Entries, e)
+		}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &m, renameio.WriteFile(filepath.Join(dir, ManifestName), b, 0640)
+}
+
+// ReadManifest reads the Manifest of the backup in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// BackupReport is the result of VerifyBackup.
+type BackupReport struct {
+	// Missing are the messages of the backup which are not in the live mailbox anymore.
+	Missing []ManifestEntry
+	// Changed are the messages whose live content differs from the backup.
+	Changed []ManifestEntry
+	// Corrupt are the messages whose backup file is missing, or differs from the manifest.
+	Corrupt []ManifestEntry
+	// New are the live messages which are not in the backup.
+	New []ManifestEntry
+}
+
+// OK reports whether the backup and the live mailboxes are the same.
+func (r BackupReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Changed) == 0 && len(r.Corrupt) == 0 && len(r.New) == 0
+}
+
+// VerifyBackup checks the files of the backup in dir against its manifest,
+// and the manifest against the live mailboxes of c.
+//
+// The live messages are matched by UID, or by Message-ID if the UIDVALIDITY of the mailbox
+// has changed since the backup. Each live message is read to compute its hash.
+func VerifyBackup(ctx context.Context, c Client, dir string) (BackupReport, error) {
+	var r BackupReport
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return r, err
+	}
+	var mailboxes []string
+	byMailbox := make(map[string][]ManifestEntry)
+	for _, e := range m.Entries {
+		if _, ok := byMailbox[e.Mailbox]; !ok {
+			mailboxes = append(mailboxes, e.Mailbox)
+		}
+		byMailbox[e.Mailbox] = append(byMailbox[e.Mailbox], e)
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(e.Path)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return r, err
+		}
+		if err != nil || int64(len(b)) != e.Size || sha256Hex(b) != e.SHA256 {
+			r.Corrupt = append(r.Corrupt, e)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, mbox := range mailboxes {
+		infos, err := ListInfo(ctx, c, mbox, "", true)
+		if err != nil {
+			return r, fmt.Errorf("list %q: %w", mbox, err)
+		}
+		entries := byMailbox[mbox]
+		var validity uint32
+		if ss, ok := As[SelectedStatuser](c); ok {
+			if st, ok := ss.SelectedStatus(); ok {
+				validity = st.UIDValidity
+			}
+		}
+		byMessageID := validity != 0 && entries[0].UIDValidity != 0 && validity != entries[0].UIDValidity
+		key := func(uid uint32, messageID string) string {
+			if byMessageID {
+				return messageID
+			}
+			return strconv.FormatUint(uint64(uid), 10)
+		}
+		backed := make(map[string]ManifestEntry, len(entries))
+		for _, e := range entries {
+			backed[key(e.UID, e.MessageID)] = e
+		}
+		for _, mi := range infos {
+			buf.Reset()
+			if _, err := c.ReadTo(ctx, &buf, mi.UID); err != nil {
+				return r, fmt.Errorf("read %q/%d: %w", mbox, mi.UID, err)
+			}
+			live := ManifestEntry{
+				Mailbox: mbox, UID: mi.UID, UIDValidity: validity, MessageID: mi.MessageID,
+				Size: int64(buf.Len()), SHA256: sha256Hex(buf.Bytes()),
+			}
+			k := key(mi.UID, mi.MessageID)
+			e, ok := backed[k]
+			if !ok {
+				r.New = append(r.New, live)
+				continue
+			}
+			delete(backed, k)
+			if e.SHA256 != live.SHA256 {
+				r.Changed = append(r.Changed, e)
+			}
+		}
+		for _, e := range entries {
+			if _, ok := backed[key(e.UID, e.MessageID)]; ok {
+				r.Missing = append(r.Missing, e)
+			}
+		}
+	}
+	return r, nil
+}
+
+func sha256Hex(b []byte) string {
+	hsh := sha256.Sum256(b)
+	return hex.EncodeToString(hsh[:])
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// contentClient serves the messages of its mailboxes, by UID.
+type contentClient struct {
+	Client
+	boxes    map[string]map[uint32]string
+	selected string
+	appended []string
+	// last is the UID of the last appended message.
+	last uint32
+}
+
+func (c *contentClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	c.selected = mbox
+	var uids []uint32
+	for uid := range c.boxes[mbox] {
+		uids = append(uids, uid)
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+func (c *contentClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		m[uid] = map[string][]string{"ENVELOPE.MESSAGE-ID": {fmt.Sprintf("<%d@%s>", uid, c.selected)}}
+	}
+	return m, nil
+}
+func (c *contentClient) Select(ctx context.Context, mbox string) error {
+	c.selected = mbox
+	return nil
+}
+func (c *contentClient) Mailboxes(ctx context.Context, root string) ([]string, error) {
+	var names []string
+	for mbox := range c.boxes {
+		names = append(names, mbox)
+	}
+	return names, nil
+}
+func (c *contentClient) CreateMailbox(ctx context.Context, mbox string) error {
+	if c.boxes[mbox] == nil {
+		c.boxes[mbox] = make(map[uint32]string)
+	}
+	return nil
+}
+func (c *contentClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	delete(c.boxes, mbox)
+	return nil
+}
+func (c *contentClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	c.boxes[newName] = c.boxes[mbox]
+	delete(c.boxes, mbox)
+	return nil
+}
+func (c *contentClient) AppendFlags(ctx context.Context, mbox string, msg []byte, date time.Time, flags []string) error {
+	c.appended = append(c.appended, fmt.Sprintf("%s %s %v", mbox, date.Format(time.DateOnly), flags))
+	c.last = uint32(len(c.boxes[mbox]) + 100)
+	c.boxes[mbox][c.last] = string(msg)
+	return nil
+}
+func (c *contentClient) WriteTo(ctx context.Context, mbox string, msg []byte, date time.Time) error {
+	return c.AppendFlags(ctx, mbox, msg, date, nil)
+}
+
+// flaggerClient is a contentClient which is not a FlagAppender, but a Flagger.
+type flaggerClient struct {
+	Client
+	cc    *contentClient
+	flags map[uint32][]string
+}
+
+func (c *flaggerClient) CreateMailbox(ctx context.Context, mbox string) error {
+	return c.cc.CreateMailbox(ctx, mbox)
+}
+func (c *flaggerClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	return c.cc.DeleteMailbox(ctx, mbox)
+}
+func (c *flaggerClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	return c.cc.RenameMailbox(ctx, mbox, newName)
+}
+func (c *flaggerClient) FindByMessageID(ctx context.Context, mbox, messageID string) ([]uint32, error) {
+	return []uint32{c.cc.last}, nil
+}
+func (c *flaggerClient) SetFlags(ctx context.Context, msgID uint32, add bool, flags ...string) error {
+	c.flags[msgID] = append(c.flags[msgID], flags...)
+	return nil
+}
+func (c *contentClient) ReadTo(ctx context.Context, w io.Writer, uid uint32) (int64, error) {
+	n, err := io.WriteString(w, c.boxes[c.selected][uid])
+	return int64(n), err
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := &contentClient{boxes: map[string]map[uint32]string{
+		"INBOX":    {1: "Subject: a\r\n\r\na\r\n", 2: "Subject: b\r\n\r\nb\r\n"},
+		"Sent/Old": {7: "Subject: c\r\n\r\nc\r\n"},
+	}}
+	m, err := Backup(ctx, c, dir, []string{"INBOX", "Sent/Old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 3 || m.Entries[2].Path != "Sent%2FOld/7.eml" || m.Entries[0].MessageID != "<1@INBOX>" {
+		t.Fatalf("got %+v", m.Entries)
+	}
+	if r, err := VerifyBackup(ctx, c, dir); err != nil || !r.OK() {
+		t.Fatalf("got %+v, %+v", r, err)
+	}
+
+	delete(c.boxes["INBOX"], 1)
+	c.boxes["INBOX"][2] = "Subject: b\r\n\r\nchanged\r\n"
+	c.boxes["INBOX"][3] = "Subject: new\r\n\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "Sent%2FOld", "7.eml"), []byte("corrupt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	r, err := VerifyBackup(ctx, c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := func(entries []ManifestEntry) []uint32 {
+		var uids []uint32
+		for _, e := range entries {
+			uids = append(uids, e.UID)
+		}
+		return uids
+	}
+	if !slices.Equal(uids(r.Missing), []uint32{1}) || !slices.Equal(uids(r.Changed), []uint32{2}) ||
+		!slices.Equal(uids(r.New), []uint32{3}) || !slices.Equal(uids(r.Corrupt), []uint32{7}) {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	date := time.Date(2019, 5, 6, 7, 8, 9, 0, time.UTC)
+	m := Manifest{Delimiter: ".", Entries: []ManifestEntry{
+		{Mailbox: "INBOX", UID: 1, MessageID: "<1@INBOX>", Path: "1.eml", Date: date, Flags: []string{`\Seen`, `\Recent`, `\Deleted`}},
+		{Mailbox: "INBOX", UID: 2, MessageID: "<2@INBOX>", Path: "2.eml", Date: date},
+		{Mailbox: "Old.2019", UID: 3, Path: "3.eml", Date: date},
+		{Mailbox: "Junk", UID: 4, Path: "4.eml", Date: date},
+	}}
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		b := []byte(fmt.Sprintf("Subject: %d\r\n\r\n", e.UID))
+		if err := os.WriteFile(filepath.Join(dir, e.Path), b, 0640); err != nil {
+			t.Fatal(err)
+		}
+		e.SHA256, e.Size = sha256Hex(b), int64(len(b))
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, ManifestName), b, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// <2@INBOX> is already there.
+	c := &contentClient{boxes: map[string]map[uint32]string{"INBOX": {2: "Subject: 2\r\n\r\n"}}}
+	restored, skipped, err := Restore(ctx, c, dir, RestoreOptions{
+		Folders:      FolderMap{"Old": "Archive/Old", "Junk": ""},
+		SkipExisting: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 2 || skipped != 2 {
+		t.Errorf("got %d restored, %d skipped", restored, skipped)
+	}
+	if want := []string{`INBOX 2019-05-06 [\Seen]`, "Archive/Old/2019 2019-05-06 []"}; !slices.Equal(c.appended, want) {
+		t.Errorf("got %q, wanted %q", c.appended, want)
+	}
+
+	// The flags are set after the append.
+	cc := &contentClient{boxes: map[string]map[uint32]string{"INBOX": {}}}
+	fc := &flaggerClient{Client: cc, cc: cc, flags: make(map[uint32][]string)}
+	if restored, _, err = Restore(ctx, fc, dir, RestoreOptions{Folders: FolderMap{"Old": "", "Junk": ""}}); err != nil {
+		t.Fatal(err)
+	} else if restored != 2 {
+		t.Errorf("flagger: got %d restored", restored)
+	}
+	if got := fc.flags[100]; !slices.Equal(got, []string{`\Seen`}) || len(fc.flags) != 1 {
+		t.Errorf("flagger: got %q", fc.flags)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "3.eml"), []byte("corrupt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Restore(ctx, c, dir, RestoreOptions{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("got %+v, wanted ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"golang.org/x/oauth2"
+
+	"github.com/tgulacsi/imapclient/v2"
+	"github.com/tgulacsi/imapclient/v2/o365"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// pipeListener is a net.Listener serving the given connections.
+type pipeListener chan net.Conn
+
+func (l pipeListener) Accept() (net.Conn, error) {
+	conn, ok := <-l
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+func (l pipeListener) Close() error   { return nil }
+func (l pipeListener) Addr() net.Addr { return &net.UnixAddr{Name: "pipe", Net: "unix"} }
+
+// message returns a message of about size bytes.
+func message(i, size int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: sender@example.com\r\nTo: rcpt@example.com\r\nSubject: message %d\r\n"+
+		"Message-ID: <%d@example.com>\r\nDate: %s\r\n\r\n", i, i, time.Now().Format(time.RFC1123Z))
+	line := []byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor.\r\n")
+	for buf.Len() < size {
+		buf.Write(line)
+	}
+	return buf.Bytes()
+}
+
+// newIMAP returns a connected Client of an in-memory IMAP server,
+// whose INBOX has n more messages of size bytes.
+func newIMAP(tb testing.TB, n, size int) imapclient.Client {
+	tb.Helper()
+	be := memory.New()
+	u, err := be.Login(nil, "username", "password")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	mbox, err := u.GetMailbox("INBOX")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := range n {
+		if err := mbox.CreateMessage(nil, time.Now(), bytes.NewBuffer(message(i, size))); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	srv := server.New(be)
+	srv.AllowInsecureAuth = true
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	l := make(pipeListener, 1)
+	go srv.Serve(l)
+	tb.Cleanup(func() { srv.Close() })
+
+	cConn, sConn := net.Pipe()
+	l <- sConn
+	close(l)
+	c := imapclient.NewClientConn(cConn, "username", "password")
+	c.SetLogger(slog.Default())
+	c.SetLogMask(imapclient.LogMask(false))
+	ctx := context.Background()
+	if err := c.Connect(ctx); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Close(ctx, false) })
+	return c
+}
+
+func BenchmarkList10k(b *testing.B) {
+	c := newIMAP(b, 10_000, 256)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		uids, err := c.List(ctx, "INBOX", "", true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(uids) < 10_000 {
+			b.Fatalf("got %d uids", len(uids))
+		}
+	}
+}
+
+func BenchmarkFetch1kSmall(b *testing.B) {
+	c := newIMAP(b, 1_000, 2<<10)
+	ctx := context.Background()
+	uids, err := c.List(ctx, "INBOX", "", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		var size int64
+		for _, uid := range uids {
+			n, err := c.ReadTo(ctx, io.Discard, uid)
+			if err != nil {
+				b.Fatal(err)
+			}
+			size += n
+		}
+		b.SetBytes(size)
+	}
+}
+
+func BenchmarkFetch50MB(b *testing.B) {
+	c := newIMAP(b, 1, 50<<20)
+	ctx := context.Background()
+	uids, err := c.List(ctx, "INBOX", "", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	uid := uids[len(uids)-1]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		n, err := c.ReadTo(ctx, io.Discard, uid)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(n)
+	}
+}
+
+// newO365 returns an o365 client of a fake REST API serving total messages,
+// paged with $top and $skip.
+func newO365(tb testing.TB, total int) interface {
+	ListParallel(context.Context, string, string, bool, o365.Parallel, func(o365.Message) error, ...o365.ListOption) error
+} {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		top, _ := strconv.Atoi(q.Get("$top"))
+		skip, _ := strconv.Atoi(q.Get("$skip"))
+		if top <= 0 {
+			top = 10
+		}
+		w.Header().Set("Content-Type", "application/json")
+		var buf bytes.Buffer
+		buf.WriteString(`{"value":[`)
+		for i := skip; i < min(skip+top, total); i++ {
+			if i != skip {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(&buf, `{"Id":"AAMkAD%010d","Subject":"message %d","Sender":{"EmailAddress":{"Name":"Sender","Address":"sender@example.com"}}}`, i, i)
+		}
+		buf.WriteString(`]}`)
+		w.Write(buf.Bytes())
+	}))
+	tb.Cleanup(srv.Close)
+	srvURL, _ := url.Parse(srv.URL)
+
+	c := o365.NewClient("clientID", "clientSecret", "",
+		o365.TokensFile(tb.TempDir()+"/tokens.json"),
+		o365.WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+			return o365.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req = req.Clone(req.Context())
+				req.URL.Scheme, req.URL.Host = srvURL.Scheme, srvURL.Host
+				return next.RoundTrip(req)
+			})
+		}))
+	c.SetTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}))
+	return c
+}
+
+func BenchmarkO365ListPages(b *testing.B) {
+	const total = 10_000
+	c := newO365(b, total)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		var n int
+		if err := c.ListParallel(ctx, "", "", true, o365.Parallel{PageSize: 100}, func(o365.Message) error {
+			n++
+			return nil
+		}); err != nil {
+			b.Fatal(err)
+		}
+		if n != total {
+			b.Fatalf("got %d messages, wanted %d", n, total)
+		}
+	}
+}
+
+// TestAllocsFetchLarge guards against allocations proportional to the size of the message.
+func TestAllocsFetchLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	c := newIMAP(t, 1, 50<<20)
+	ctx := context.Background()
+	uids, err := c.List(ctx, "INBOX", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := uids[len(uids)-1]
+	const limit = 500
+	if allocs := testing.AllocsPerRun(2, func() {
+		if _, err := c.ReadTo(ctx, io.Discard, uid); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs > limit {
+		t.Errorf("ReadTo of 50MiB: %.0f allocs, wanted at most %d", allocs, limit)
+	}
+}
+
+// TestAllocsO365List guards the allocations per listed message.
+func TestAllocsO365List(t *testing.T) {
+	const total, limit = 1_000, 12
+	c := newO365(t, total)
+	ctx := context.Background()
+	if allocs := testing.AllocsPerRun(2, func() {
+		if err := c.ListParallel(ctx, "", "", true, o365.Parallel{PageSize: 100}, func(o365.Message) error {
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}) / total; allocs > limit {
+		t.Errorf("ListParallel: %.1f allocs per message, wanted at most %d", allocs, limit)
+	}
+}
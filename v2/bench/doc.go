@@ -0,0 +1,16 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bench holds the benchmarks of imapclient against local fakes:
+// an in-memory IMAP server and a fake Office 365 REST API.
+//
+// Run them with
+//
+//	go test -run '^$' -bench . -benchmem -count 6 ./v2/bench | tee new.txt
+//
+// and compare with the results of the base revision with benchstat.
+//
+// The Test functions are the regression gates: they fail if the allocations
+// of the hot paths grow above their limits.
+package bench
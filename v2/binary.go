@@ -0,0 +1,390 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emersion/go-imap"
+)
+
+// PartReader is implemented by the Clients which can read the MIME parts of the messages.
+type PartReader interface {
+	// ReadPart writes the content of the MIME part (such as "1" or "2.1") of the message to w,
+	// decoded from its Content-Transfer-Encoding.
+	//
+	// It uses BINARY (RFC 3516) if the server supports it, so the server decodes the part,
+	// and the base64 inflation is not transferred; BODY[part] decoded by the client otherwise.
+	ReadPart(ctx context.Context, w io.Writer, msgID uint32, part string) (int64, error)
+}
+
+var _ PartReader = (*imapClient)(nil)
+
+// ReadPart writes the decoded content of the MIME part of the message to w,
+// fetched with BINARY if possible.
+func (c *imapClient) ReadPart(ctx context.Context, w io.Writer, msgID uint32, part string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.binary() {
+		item := "BINARY.PEEK[" + part + "]"
+		if c.noPeek {
+			item = "BINARY[" + part + "]"
+		}
+		c.lit8.arm()
+		msg, err := c.fetchOne(ctx, msgID, imap.FetchItem(item))
+		c.lit8.disarm()
+		if err == nil {
+			var n int64
+			if lit, ok := msg.Items[imap.FetchItem("BINARY["+part+"]")].(imap.Literal); ok {
+				n, err = io.Copy(w, lit)
+			}
+			c.CountFetched(n)
+			return n, err
+		}
+		if c.c.State() != imap.SelectedState {
+			return 0, err
+		}
+		// Such as NO [UNKNOWN-CTE]: the server cannot decode the part, but the client may.
+		c.logger.Warn("BINARY fetch failed, fall back to BODY", "msgID", msgID, "part", part, "error", err)
+	}
+
+	mime := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.MIMESpecifier, Path: partPath(part)}, Peek: true}
+	body := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: partPath(part)}, Peek: !c.noPeek}
+	msg, err := c.fetchOne(ctx, msgID, mime.FetchItem(), body.FetchItem())
+	if err != nil {
+		return 0, err
+	}
+	var cte string
+	if lit := msg.GetBody(mime); lit != nil {
+		hdr, err := textproto.NewReader(bufio.NewReader(lit)).ReadMIMEHeader()
+		if err != nil && len(hdr) == 0 {
+			return 0, fmt.Errorf("parse MIME header of %d[%s]: %w", msgID, part, err)
+		}
+		cte = hdr.Get("Content-Transfer-Encoding")
+	}
+	lit := msg.GetBody(body)
+	if lit == nil {
+		return 0, nil
+	}
+	c.CountFetched(int64(lit.Len()))
+	r, err := decodeTransfer(lit, cte)
+	if err != nil {
+		return 0, fmt.Errorf("%d[%s]: %w", msgID, part, err)
+	}
+	return io.Copy(w, r)
+}
+
+// fetchOne fetches the items of the message.
+func (c *imapClient) fetchOne(ctx context.Context, msgID uint32, items ...imap.FetchItem) (*imap.Message, error) {
+	set := &imap.SeqSet{}
+	set.AddNum(msgID)
+	ch := make(chan *imap.Message, 1)
+	err := c.withTimeout(ctx, func() error { return c.c.UidFetch(set, items, ch) })
+	msg := <-ch
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("%d: %w", msgID, io.EOF)
+	}
+	return msg, nil
+}
+
+// binary reports whether the BINARY extension can be used: the server supports it,
+// and the literal8 responses can be converted for go-imap (connected).
+func (c *imapClient) binary() bool {
+	if c.lit8 == nil {
+		return false
+	}
+	ok, _ := c.c.Support("BINARY")
+	return ok
+}
+
+// partPath returns the path of the part, such as [2 1] for "2.1".
+func partPath(part string) []int {
+	var path []int
+	for _, s := range strings.Split(part, ".") {
+		if i, err := strconv.Atoi(s); err == nil {
+			path = append(path, i)
+		}
+	}
+	return path
+}
+
+// decodeTransfer returns the reader of r decoded from the Content-Transfer-Encoding.
+func decodeTransfer(r io.Reader, cte string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r), nil
+	case "quoted-printable":
+		return quotedprintable.NewReader(r), nil
+	case "", "7bit", "8bit", "binary":
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown Content-Transfer-Encoding %q", cte)
+}
+
+// appendBinary reports whether the message must be appended as literal8: it contains NUL,
+// and the server supports BINARY - it arms the conversion of the literal for the next APPEND.
+func (c *imapClient) appendBinary(msg []byte) bool {
+	if c.lit8 == nil || bytes.IndexByte(msg, 0) < 0 {
+		return false
+	}
+	if ok, _ := c.c.Support("BINARY"); !ok {
+		return false
+	}
+	c.lit8.armAppend(len(msg))
+	return true
+}
+
+// literal8Conn converts the literal8 (~{n}) of the responses to literal ({n}), as go-imap does
+// not know them, and the literal of the next APPEND to literal8, if armed.
+//
+// The responses are tracked only while armed - during a BINARY fetch -, from a line boundary.
+type literal8Conn struct {
+	net.Conn
+	// appendHeader is the literal header of the message to be appended as literal8.
+	appendHeader atomic.Pointer[[]byte]
+	// held is the written start of a possible literal header, line the start of the
+	// current line: the header may be split between Writes.
+	held, line []byte
+	mu, wmu    sync.Mutex
+	lit8State
+}
+
+// lit8State is the state of the response tracking of literal8Conn.
+type lit8State struct {
+	// skip is the remaining length of the current literal.
+	skip  int64
+	num   int64
+	armed bool
+	state uint8
+	prev  byte
+	// pending is set when a '~' has been withheld, at the end of the previous Read.
+	pending bool
+}
+
+const (
+	l8Line = uint8(iota)
+	l8Quoted
+	l8Escape
+	l8Brace
+	l8BraceEnd
+	l8LF
+)
+
+func (lc *literal8Conn) arm() {
+	lc.mu.Lock()
+	lc.lit8State = lit8State{armed: true, prev: '\n'}
+	lc.mu.Unlock()
+}
+
+func (lc *literal8Conn) disarm() {
+	lc.mu.Lock()
+	lc.armed = false
+	lc.mu.Unlock()
+}
+
+func (lc *literal8Conn) armAppend(length int) {
+	b := []byte(" {" + strconv.Itoa(length))
+	lc.wmu.Lock()
+	lc.line = lc.line[:0]
+	lc.wmu.Unlock()
+	lc.appendHeader.Store(&b)
+}
+
+func (lc *literal8Conn) Read(p []byte) (int, error) {
+	lc.mu.Lock()
+	off := 0
+	if lc.pending {
+		if len(p) < 2 {
+			lc.mu.Unlock()
+			return 0, io.ErrShortBuffer
+		}
+		off, lc.pending = 1, false
+	}
+	lc.mu.Unlock()
+
+	n, err := lc.Conn.Read(p[off:])
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if off != 0 {
+		if n != 0 && p[1] == '{' {
+			copy(p, p[1:1+n])
+			off = 0
+		} else {
+			p[0], lc.prev = '~', '~'
+		}
+	}
+	if n == 0 || !lc.armed {
+		return off + n, err
+	}
+	return off + lc.convert(p[off:off+n]), err
+}
+
+// convert removes the '~' of the literal8 from b, in place, and returns the length of the result.
+func (lc *literal8Conn) convert(b []byte) int {
+	w := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if lc.skip > 0 {
+			k := min(int64(len(b)-i), lc.skip)
+			w += copy(b[w:], b[i:i+int(k)])
+			i += int(k) - 1
+			lc.skip -= k
+			lc.prev = 0
+			continue
+		}
+		switch lc.state {
+		case l8Line:
+			switch {
+			case c == '"':
+				lc.state = l8Quoted
+			case c == '{':
+				lc.state, lc.num = l8Brace, 0
+			case c == '~' && (lc.prev == ' ' || lc.prev == '('):
+				if i+1 == len(b) {
+					// Decided by the next Read.
+					lc.pending = true
+					return w
+				}
+				if b[i+1] == '{' {
+					lc.prev = c
+					continue
+				}
+			}
+		case l8Quoted:
+			switch c {
+			case '\\':
+				lc.state = l8Escape
+			case '"', '\r', '\n':
+				lc.state = l8Line
+			}
+		case l8Escape:
+			lc.state = l8Quoted
+		case l8Brace:
+			switch {
+			case '0' <= c && c <= '9' && lc.num < 1<<40:
+				lc.num = lc.num*10 + int64(c-'0')
+			case c == '+':
+			case c == '}':
+				lc.state = l8BraceEnd
+			default:
+				lc.state = l8Line
+			}
+		case l8BraceEnd:
+			lc.state = l8Line
+			if c == '\r' {
+				lc.state = l8LF
+			}
+		case l8LF:
+			lc.state = l8Line
+			if c == '\n' {
+				lc.skip = lc.num
+			}
+		}
+		lc.prev = c
+		b[w] = c
+		w++
+	}
+	return w
+}
+
+func (lc *literal8Conn) Write(p []byte) (int, error) {
+	lc.wmu.Lock()
+	defer lc.wmu.Unlock()
+	hdr := lc.appendHeader.Load()
+	if hdr == nil && len(lc.held) == 0 {
+		return lc.Conn.Write(p)
+	}
+	data := p
+	if len(lc.held) != 0 {
+		data = append(lc.held, p...)
+		lc.held = nil
+	}
+	var keep []byte
+	if hdr != nil {
+		if i, more := lc.findHeader(data, *hdr); i >= 0 {
+			lc.appendHeader.Store(nil)
+			buf := make([]byte, 0, len(data)+1)
+			data = append(append(append(buf, data[:i+1]...), '~'), data[i+1:]...)
+		} else if more != 0 {
+			// The rest of the header is in the next Write.
+			data, keep = data[:len(data)-more], data[len(data)-more:]
+		}
+	}
+	lc.trackLine(data)
+	if _, err := lc.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	lc.held = bytes.Clone(keep)
+	return len(p), nil
+}
+
+// findHeader returns the index of the literal header of the APPEND command in data -
+// or the length of its start at the end of data, if it may continue in the next Write.
+func (lc *literal8Conn) findHeader(data, hdr []byte) (int, int) {
+	for off := 0; ; {
+		j := bytes.Index(data[off:], hdr)
+		if j < 0 {
+			break
+		}
+		i := off + j
+		if lc.isAppend(data, i) {
+			if e := i + len(hdr); e == len(data) {
+				return -1, len(data) - i
+			} else if data[e] == '}' || data[e] == '+' {
+				return i, 0
+			}
+		}
+		off = i + 1
+	}
+	for k := min(len(hdr)-1, len(data)); k > 0; k-- {
+		if bytes.HasSuffix(data, hdr[:k]) && lc.isAppend(data, len(data)-k) {
+			return -1, k
+		}
+	}
+	return -1, 0
+}
+
+// isAppend reports whether data[i] is in the line of an APPEND command,
+// continuing the line of the previous Writes.
+func (lc *literal8Conn) isAppend(data []byte, i int) bool {
+	line := data[:i]
+	if nl := bytes.LastIndexByte(line, '\n'); nl >= 0 {
+		line = line[nl+1:]
+	} else {
+		line = append(slices.Clip(lc.line), line...)
+	}
+	_, cmd, ok := bytes.Cut(line, []byte(" "))
+	return ok && len(cmd) >= len("APPEND ") && bytes.EqualFold(cmd[:len("APPEND ")], []byte("APPEND "))
+}
+
+// trackLine records the start of the current line, written by data.
+func (lc *literal8Conn) trackLine(data []byte) {
+	if nl := bytes.LastIndexByte(data, '\n'); nl >= 0 {
+		lc.line, data = lc.line[:0], data[nl+1:]
+	}
+	lc.line = append(lc.line, data[:min(len(data), maxLineStart-len(lc.line))]...)
+}
+
+// maxLineStart is the length of the start of the line recorded by literal8Conn,
+// enough for the tag and the command.
+const maxLineStart = 64
@@ -0,0 +1,182 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkConn returns the data in chunks of n bytes.
+type chunkConn struct {
+	net.Conn
+	data    []byte
+	written bytes.Buffer
+	n       int
+}
+
+func (cc *chunkConn) Read(p []byte) (int, error) {
+	if len(cc.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), cc.n)], cc.data)
+	cc.data = cc.data[n:]
+	return n, nil
+}
+
+func (cc *chunkConn) Write(p []byte) (int, error) { return cc.written.Write(p) }
+
+func TestLiteral8Read(t *testing.T) {
+	const (
+		in = "* 1 FETCH (UID 1 BINARY[2] ~{8}\r\n\x00 ~{3}\r\n \"q ~{\" ~x)\r\n" +
+			"* 2 FETCH (BINARY[1] {4}\r\n(~{1)\r\n" +
+			"A1 OK done ~{0}\r\n\r\n"
+		want = "* 1 FETCH (UID 1 BINARY[2] {8}\r\n\x00 ~{3}\r\n \"q ~{\" ~x)\r\n" +
+			"* 2 FETCH (BINARY[1] {4}\r\n(~{1)\r\n" +
+			"A1 OK done {0}\r\n\r\n"
+	)
+	for n := 1; n <= len(in); n++ {
+		lc := &literal8Conn{Conn: &chunkConn{data: []byte(in), n: n}}
+		lc.arm()
+		b, err := io.ReadAll(lc)
+		if err != nil {
+			t.Fatalf("%d: %+v", n, err)
+		}
+		if got := string(b); got != want {
+			t.Errorf("%d: got\n%q, wanted\n%q", n, got, want)
+		}
+	}
+
+	lc := &literal8Conn{Conn: &chunkConn{data: []byte(in), n: 7}}
+	if b, _ := io.ReadAll(lc); string(b) != in {
+		t.Errorf("not armed: got %q", b)
+	}
+}
+
+func TestLiteral8Write(t *testing.T) {
+	cc := &chunkConn{}
+	lc := &literal8Conn{Conn: cc}
+	lc.armAppend(12)
+	for _, s := range []string{"A1 LOGIN u {12}\r\n", "A2 APPEND INBOX () {123}\r\n", "A3 APPEND INBOX () {12+}\r\n", "A4 APPEND INBOX () {12}\r\n"} {
+		if n, err := lc.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("%q: %d, %+v", s, n, err)
+		}
+	}
+	want := "A1 LOGIN u {12}\r\nA2 APPEND INBOX () {123}\r\nA3 APPEND INBOX () ~{12+}\r\nA4 APPEND INBOX () {12}\r\n"
+	if got := cc.written.String(); got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+
+	// The header split between the Writes.
+	for _, split := range [][]string{
+		{"A5 APP", "END INBOX () {1", "2}\r\n"},
+		{"A5 APPEND INBOX () ", "{12", "}\r\n"},
+		{"A5 APPEND INBOX () {12", "+}\r\n"},
+		{"A5 APPEND INBOX (\\Seen", ") {", "12}\r\n"},
+		{"A4 LOGIN u {12}\r\nA5 APPEND", " INBOX () {12}\r\n"},
+		{"A5 APPEND INBOX () {12}\r", "\n"},
+		{"A5 APPEND INBOX () {1", "23}\r\n"},
+	} {
+		cc.written.Reset()
+		lc.armAppend(12)
+		for _, s := range split {
+			if n, err := lc.Write([]byte(s)); n != len(s) || err != nil {
+				t.Fatalf("%q: %d, %+v", s, n, err)
+			}
+		}
+		lc.appendHeader.Store(nil)
+		lc.Write(nil)
+		want := strings.Join(split, "")
+		if i := strings.LastIndex(want, " {12"); !strings.Contains(want, "{123}") {
+			want = want[:i+1] + "~" + want[i+1:]
+		}
+		if got := cc.written.String(); got != want {
+			t.Errorf("%q: got %q, wanted %q", split, got, want)
+		}
+	}
+}
+
+func TestReadPart(t *testing.T) {
+	const (
+		binary = "* 1 FETCH (UID 1 BINARY[2] ~{7}\r\nab\x00\r\ncd)\r\nTAG OK done\r\n"
+		body   = "* 1 FETCH (UID 1 BODY[2.MIME] {37}\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+			" BODY[2] {10}\r\nYWIAcGNk\r\n)\r\nTAG OK done\r\n"
+	)
+	for _, tc := range []struct {
+		Name, Greeting, Fetch, Want, Capability string
+		TLS                                     bool
+	}{
+		{Name: "binary", Greeting: "* OK [CAPABILITY IMAP4rev1 BINARY] ready\r\n", Fetch: binary, Want: "ab\x00\r\ncd"},
+		{Name: "starttls", Greeting: "* OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED] ready\r\n",
+			Fetch: binary, Want: "ab\x00\r\ncd", TLS: true},
+		{Name: "body", Greeting: "* OK [CAPABILITY IMAP4rev1] ready\r\n", Fetch: body, Want: "ab\x00pcd",
+			Capability: "IMAP4rev1"},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cConn, sConn := tcpPipe(t)
+			var cfg *tls.Config
+			if tc.TLS {
+				cfg = serverTLSConfig(t)
+			}
+			if tc.Capability == "" {
+				tc.Capability = "IMAP4rev1 BINARY"
+			}
+			scriptServer(sConn, cfg, tc.Greeting, map[string]string{
+				"CAPABILITY": "* CAPABILITY " + tc.Capability + "\r\nTAG OK done\r\n",
+				"SELECT":     "* 1 EXISTS\r\nTAG OK [READ-WRITE] done\r\n",
+				"UID FETCH":  tc.Fetch,
+			})
+			c := NewClientConn(cConn, "username", "password")
+			if err := c.Connect(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close(ctx, false)
+			if ci := c.(ConnectInfoReporter).ConnectInfo(); ci.TLS != tc.TLS {
+				t.Errorf("TLS: got %t, wanted %t", ci.TLS, tc.TLS)
+			}
+			if err := c.Select(ctx, "INBOX"); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			n, err := c.(PartReader).ReadPart(ctx, &buf, 1, "2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.Want || n != int64(len(tc.Want)) {
+				t.Errorf("got %q (%d), wanted %q", buf.String(), n, tc.Want)
+			}
+		})
+	}
+}
+
+func TestDecodeTransfer(t *testing.T) {
+	for _, tc := range []struct {
+		CTE, In, Want string
+	}{
+		{"base64", "aGVs\r\nbG8=\r\n", "hello"},
+		{"Quoted-Printable", "h=C3=A9llo=\r\n!", "h\xc3\xa9llo!"},
+		{"", "plain", "plain"},
+		{"binary", "\x00\x01", "\x00\x01"},
+	} {
+		r, err := decodeTransfer(strings.NewReader(tc.In), tc.CTE)
+		if err != nil {
+			t.Fatalf("%s: %+v", tc.CTE, err)
+		}
+		if b, err := io.ReadAll(r); err != nil || string(b) != tc.Want {
+			t.Errorf("%s: got %q (%+v), wanted %q", tc.CTE, b, err, tc.Want)
+		}
+	}
+	if _, err := decodeTransfer(nil, "x-uuencode"); err == nil {
+		t.Error("wanted error for x-uuencode")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/mail"
+	"net/netip"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// Feedback is a parsed abuse feedback report (ARF, RFC 5965).
+type Feedback struct {
+	// Arrival is when the original message was received.
+	Arrival time.Time
+	// OriginalHeader is the header of the reported message.
+	OriginalHeader mail.Header
+	// SourceIP is the IP address the reported message was received from.
+	SourceIP netip.Addr
+	// Type is the feedback type: abuse, fraud, virus, auth-failure, not-spam or other.
+	Type string
+	// UserAgent is the software which generated the report.
+	UserAgent string
+	// OriginalMailFrom is the envelope sender of the reported message.
+	OriginalMailFrom string
+	// OriginalMessageID is the Message-ID of the reported message.
+	OriginalMessageID string
+	// Text is the human readable part of the report.
+	Text string
+	// OriginalRcptTo are the envelope recipients of the reported message.
+	OriginalRcptTo []string
+	// ReportedDomain and ReportedURI are the domains and URIs the report is about.
+	ReportedDomain, ReportedURI []string
+	// AuthenticationResults of the reported message, as the reporter saw it.
+	AuthenticationResults []string
+	// Incidents is the number of the incidents this report represents (at least 1).
+	Incidents int
+}
+
+// ParseFeedback parses the abuse feedback report (multipart/report; report-type=feedback-report).
+// Returns ErrNotReport if the message is not such a report.
+func ParseFeedback(r io.Reader) (*Feedback, error) {
+	var fb Feedback
+	var report []byte
+	err := walkReport(r, "feedback-report", func(mediaType string, e *message.Entity) error {
+		var err error
+		switch mediaType {
+		case "text/plain":
+			if fb.Text == "" {
+				var t []byte
+				t, err = io.ReadAll(e.Body)
+				fb.Text = strings.TrimSpace(string(t))
+			}
+		case "message/feedback-report":
+			report, err = io.ReadAll(e.Body)
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers", "message/global", "message/global-headers":
+			fb.OriginalHeader, err = readHeader(e.Body)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, ErrNotReport
+	}
+	hdr, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(bytes.TrimLeft(report, "\r\n")))).ReadMIMEHeader()
+	if err != nil && len(hdr) == 0 {
+		return nil, err
+	}
+	fb.Type = strings.ToLower(strings.TrimSpace(hdr.Get("Feedback-Type")))
+	if fb.Type == "" {
+		return nil, errors.New("missing Feedback-Type")
+	}
+	fb.UserAgent = strings.TrimSpace(hdr.Get("User-Agent"))
+	fb.OriginalMailFrom = strings.TrimSpace(hdr.Get("Original-Mail-From"))
+	fb.OriginalRcptTo = trimAll(hdr.Values("Original-Rcpt-To"))
+	fb.ReportedDomain = trimAll(hdr.Values("Reported-Domain"))
+	fb.ReportedURI = trimAll(hdr.Values("Reported-Uri"))
+	fb.AuthenticationResults = trimAll(hdr.Values("Authentication-Results"))
+	if s := strings.TrimSpace(hdr.Get("Source-Ip")); s != "" {
+		fb.SourceIP, _ = netip.ParseAddr(strings.Trim(s, "[]"))
+	}
+	if s := nvl(hdr.Get("Arrival-Date"), hdr.Get("Received-Date")); s != "" {
+		fb.Arrival, _ = mail.ParseDate(strings.TrimSpace(s))
+	}
+	fb.Incidents = 1
+	if s := strings.TrimSpace(hdr.Get("Incidents")); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			fb.Incidents = n
+		}
+	}
+	if fb.OriginalHeader != nil {
+		fb.OriginalMessageID = imapclient.NormalizeMessageID(fb.OriginalHeader.Get("Message-Id"))
+	}
+	return &fb, nil
+}
+
+// FilterFeedback returns a DeliverFunc which calls handle with the abuse feedback reports,
+// and next with the other messages (which are left as is if next is nil).
+func FilterFeedback(handle func(ctx context.Context, fb *Feedback, uid uint32) error, next imapclient.DeliverFunc) imapclient.DeliverFunc {
+	return filter(ParseFeedback, handle, next)
+}
+
+func trimAll(ss []string) []string {
+	for i, s := range ss {
+		ss[i] = strings.TrimSpace(s)
+	}
+	return ss
+}
+
+func nvl(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
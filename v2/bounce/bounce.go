@@ -0,0 +1,211 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bounce parses the delivery status notifications (RFC 3464)
+// and the abuse feedback reports (ARF, RFC 5965),
+// for the services handling the bounces and the complaints of the sent mail.
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// ErrNotReport is returned by Parse for the messages which are not a report of the expected type.
+var ErrNotReport = errors.New("not a report")
+
+// Bounce is a parsed delivery status notification.
+type Bounce struct {
+	// ArrivalDate is when the original message arrived at the ReportingMTA.
+	ArrivalDate time.Time
+	// OriginalHeader is the header of the returned original message, if any.
+	OriginalHeader mail.Header
+	// ReportingMTA is the MTA which attempted the delivery.
+	ReportingMTA string
+	// OriginalMessageID is the Message-ID of the original message.
+	OriginalMessageID string
+	// Text is the human readable explanation.
+	Text       string
+	Recipients []Recipient
+}
+
+// Recipient is the delivery status of a recipient.
+type Recipient struct {
+	// FinalRecipient is the address the delivery was attempted to,
+	// OriginalRecipient the address given by the sender, if it is different.
+	FinalRecipient, OriginalRecipient string
+	// Action is failed, delayed, delivered, relayed or expanded.
+	Action string
+	// Status is the RFC 3463 status code, such as 5.1.1.
+	Status string
+	// DiagnosticCode is the diagnostic of the remote MTA, such as "550 5.1.1 User unknown".
+	DiagnosticCode string
+	RemoteMTA      string
+}
+
+// Failed reports whether the delivery to the recipient failed.
+func (r Recipient) Failed() bool { return strings.EqualFold(r.Action, "failed") }
+
+// Permanent reports whether the failure is permanent (5.x.x status).
+func (r Recipient) Permanent() bool { return strings.HasPrefix(r.Status, "5") }
+
+// Failed returns the recipients the delivery failed to.
+func (b *Bounce) Failed() []Recipient {
+	var rr []Recipient
+	for _, r := range b.Recipients {
+		if r.Failed() {
+			rr = append(rr, r)
+		}
+	}
+	return rr
+}
+
+// Parse the delivery status notification (multipart/report; report-type=delivery-status).
+// Returns ErrNotReport if the message is not a DSN.
+func Parse(r io.Reader) (*Bounce, error) {
+	var b Bounce
+	var status []byte
+	err := walkReport(r, "delivery-status", func(mediaType string, e *message.Entity) error {
+		switch mediaType {
+		case "text/plain":
+			if b.Text == "" {
+				t, err := io.ReadAll(e.Body)
+				b.Text = strings.TrimSpace(string(t))
+				return err
+			}
+		case "message/delivery-status", "message/global-delivery-status":
+			var err error
+			status, err = io.ReadAll(e.Body)
+			return err
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers", "message/global", "message/global-headers":
+			var err error
+			b.OriginalHeader, err = readHeader(e.Body)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, ErrNotReport
+	}
+	if err = b.parseStatus(status); err != nil {
+		return &b, err
+	}
+	if b.OriginalMessageID == "" && b.OriginalHeader != nil {
+		b.OriginalMessageID = imapclient.NormalizeMessageID(b.OriginalHeader.Get("Message-Id"))
+	}
+	return &b, nil
+}
+
+// parseStatus parses the per-message and the per-recipient fields of the delivery-status.
+func (b *Bounce) parseStatus(status []byte) error {
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(bytes.TrimLeft(status, "\r\n"))))
+	perMessage, err := tr.ReadMIMEHeader()
+	if err != nil && len(perMessage) == 0 {
+		return err
+	}
+	b.ReportingMTA = typedValue(perMessage.Get("Reporting-Mta"))
+	if s := perMessage.Get("Arrival-Date"); s != "" {
+		b.ArrivalDate, _ = mail.ParseDate(s)
+	}
+	if s := perMessage.Get("X-Original-Message-Id"); s != "" {
+		b.OriginalMessageID = imapclient.NormalizeMessageID(s)
+	}
+	for err == nil {
+		var hdr textproto.MIMEHeader
+		if hdr, err = tr.ReadMIMEHeader(); len(hdr) == 0 {
+			continue
+		}
+		b.Recipients = append(b.Recipients, Recipient{
+			FinalRecipient:    typedValue(hdr.Get("Final-Recipient")),
+			OriginalRecipient: typedValue(hdr.Get("Original-Recipient")),
+			Action:            strings.ToLower(strings.TrimSpace(hdr.Get("Action"))),
+			Status:            strings.TrimSpace(strings.SplitN(hdr.Get("Status"), " ", 2)[0]),
+			DiagnosticCode:    typedValue(hdr.Get("Diagnostic-Code")),
+			RemoteMTA:         typedValue(hdr.Get("Remote-Mta")),
+		})
+	}
+	if len(b.Recipients) == 0 {
+		return errors.New("no recipients in the delivery-status")
+	}
+	return nil
+}
+
+// typedValue returns the value of the "type; value" fields, such as "rfc822; joe@example.com".
+func typedValue(s string) string {
+	if _, v, ok := strings.Cut(s, ";"); ok {
+		s = v
+	}
+	return strings.TrimSpace(s)
+}
+
+// readHeader reads the header of the (original) message.
+func readHeader(r io.Reader) (mail.Header, error) {
+	br := bufio.NewReader(r)
+	hdr, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil && len(hdr) == 0 {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return mail.Header(hdr), nil
+}
+
+// walkReport calls f with the parts of the multipart/report of the given report-type,
+// returning ErrNotReport if the message is not such a report.
+func walkReport(r io.Reader, reportType string, f func(mediaType string, e *message.Entity) error) error {
+	m, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return err
+	}
+	if mediaType, params, _ := m.Header.ContentType(); mediaType != "multipart/report" ||
+		!strings.EqualFold(params["report-type"], reportType) {
+		return ErrNotReport
+	}
+	return m.Walk(func(_ []int, e *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+			return err
+		}
+		mediaType, _, _ := e.Header.ContentType()
+		return f(strings.ToLower(mediaType), e)
+	})
+}
+
+// Filter returns a DeliverFunc which calls handle with the bounces,
+// and next with the other messages (which are left as is if next is nil).
+func Filter(handle func(ctx context.Context, b *Bounce, uid uint32) error, next imapclient.DeliverFunc) imapclient.DeliverFunc {
+	return filter(Parse, handle, next)
+}
+
+func filter[T any](parse func(io.Reader) (T, error), handle func(context.Context, T, uint32) error, next imapclient.DeliverFunc) imapclient.DeliverFunc {
+	return func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh imapclient.HashArray) error {
+		report, err := parse(r)
+		if err == nil {
+			return handle(ctx, report, uid)
+		}
+		if !errors.Is(err, ErrNotReport) {
+			return err
+		}
+		if next == nil {
+			return imapclient.ErrSkip
+		}
+		if _, err = r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return next(ctx, r, uid, hsh)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bounce
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const dsn = `From: MAILER-DAEMON@mx.example.com
+To: sender@example.org
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="B"
+
+--B
+Content-Type: text/plain
+
+I'm sorry to have to inform you that your message could not be delivered.
+
+--B
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+Arrival-Date: Mon, 1 Jan 2024 12:00:00 +0100
+
+Final-Recipient: rfc822; nobody@example.com
+Original-Recipient: rfc822; Nobody@example.com
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; mail.example.com
+Diagnostic-Code: smtp; 550 5.1.1 <nobody@example.com>: Recipient address rejected
+
+--B
+Content-Type: text/rfc822-headers
+
+From: sender@example.org
+To: nobody@example.com
+Subject: hello
+Message-ID: <1234@example.org>
+
+--B--
+`
+
+func TestParse(t *testing.T) {
+	b, err := Parse(strings.NewReader(strings.ReplaceAll(dsn, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", b)
+	if b.ReportingMTA != "mx.example.com" || b.OriginalMessageID != "<1234@example.org>" || b.ArrivalDate.IsZero() {
+		t.Errorf("got %+v", b)
+	}
+	failed := b.Failed()
+	if len(failed) != 1 {
+		t.Fatalf("failed: got %+v", b.Recipients)
+	}
+	if r := failed[0]; r.FinalRecipient != "nobody@example.com" || !r.Permanent() ||
+		!strings.HasPrefix(r.DiagnosticCode, "550 5.1.1") || r.RemoteMTA != "mail.example.com" {
+		t.Errorf("recipient: got %+v", r)
+	}
+	if !strings.HasPrefix(b.Text, "I'm sorry") {
+		t.Errorf("text: got %q", b.Text)
+	}
+
+	if _, err = Parse(strings.NewReader("Subject: hi\r\n\r\nHello\r\n")); !errors.Is(err, ErrNotReport) {
+		t.Errorf("plain message: got %v, wanted ErrNotReport", err)
+	}
+}
+
+const arf = `From: abuse@isp.example
+To: fbl@example.org
+Subject: FW: Earn money
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report; boundary="part1"
+
+--part1
+Content-Type: text/plain; charset="US-ASCII"
+
+This is an email abuse report for an email message received from IP 192.0.2.1.
+
+--part1
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: SomeGenerator/1.0
+Version: 1
+Original-Mail-From: <somespammer@example.net>
+Original-Rcpt-To: <user@example.com>
+Arrival-Date: Thu, 8 Mar 2005 14:00:00 EDT
+Source-IP: 192.0.2.1
+Reported-Domain: example.net
+
+--part1
+Content-Type: message/rfc822
+
+From: <somespammer@example.net>
+To: <user@example.com>
+Subject: Earn money
+Message-ID: <8787KJKJ3K4J3K4J3K4J3.mail@example.net>
+
+Spam Spam Spam
+--part1--
+`
+
+func TestParseFeedback(t *testing.T) {
+	fb, err := ParseFeedback(strings.NewReader(strings.ReplaceAll(arf, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", fb)
+	if fb.Type != "abuse" || fb.SourceIP.String() != "192.0.2.1" || fb.Incidents != 1 ||
+		fb.OriginalMessageID != "<8787KJKJ3K4J3K4J3K4J3.mail@example.net>" ||
+		len(fb.OriginalRcptTo) != 1 || fb.OriginalRcptTo[0] != "<user@example.com>" {
+		t.Errorf("got %+v", fb)
+	}
+	if _, err = ParseFeedback(strings.NewReader(strings.ReplaceAll(dsn, "\n", "\r\n"))); !errors.Is(err, ErrNotReport) {
+		t.Errorf("DSN: got %v, wanted ErrNotReport", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the Connect of an open CircuitBreaker, without trying to connect.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState uint8
+
+const (
+	// BreakerClosed lets the connections through.
+	BreakerClosed = BreakerState(iota)
+	// BreakerOpen refuses to connect, till the cool-down passes.
+	BreakerOpen
+	// BreakerHalfOpen lets one connection through after the cool-down: its failure opens the breaker again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", uint8(s))
+}
+
+// BreakerEvent is a state change of a CircuitBreaker.
+type BreakerEvent struct {
+	Time time.Time
+	// Err is the last failure, nil when the breaker closes.
+	Err      error
+	From, To BreakerState
+	// Failures is the number of the consecutive failures.
+	Failures int
+}
+
+// CircuitBreaker is a Client which stops connecting after Threshold consecutive failures of Connect
+// (connection or authentication errors) for Cooldown, returning ErrCircuitOpen instead -
+// so an aggressive reconnect storm does not get the account locked out.
+//
+// The optional interfaces of the wrapped Client are reached through it with As.
+type CircuitBreaker struct {
+	Client
+	openedAt time.Time
+	// OnChange is called (synchronously) on each state change.
+	OnChange func(BreakerEvent)
+	// Trip tells whether the error of Connect is a failure - all errors except the cancellation of the context, if nil.
+	Trip      func(error) bool
+	Cooldown  time.Duration
+	Threshold int
+	failures  int
+	mu        sync.Mutex
+	state     BreakerState
+}
+
+// NewCircuitBreaker returns a CircuitBreaker for c, opening after threshold failures for cooldown.
+func NewCircuitBreaker(c Client, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Client: c, Threshold: threshold, Cooldown: cooldown}
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// OpenUntil returns the end of the cool-down of an open breaker, the zero time otherwise.
+func (cb *CircuitBreaker) OpenUntil() time.Time {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != BreakerOpen {
+		return time.Time{}
+	}
+	return cb.openedAt.Add(cb.Cooldown)
+}
+
+// Connect connects with the wrapped Client, unless the breaker is open.
+func (cb *CircuitBreaker) Connect(ctx context.Context) error {
+	cb.mu.Lock()
+	if cb.state == BreakerOpen {
+		if until := cb.openedAt.Add(cb.Cooldown); time.Now().Before(until) {
+			cb.mu.Unlock()
+			return fmt.Errorf("%w till %s", ErrCircuitOpen, until.Format(time.RFC3339))
+		}
+		cb.setState(BreakerHalfOpen, nil)
+	}
+	cb.mu.Unlock()
+
+	err := cb.Client.Connect(ctx)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err == nil {
+		cb.failures = 0
+		cb.setState(BreakerClosed, nil)
+		return nil
+	}
+	if !cb.trips(err) {
+		return err
+	}
+	cb.failures++
+	if cb.state == BreakerHalfOpen || cb.failures >= max(1, cb.Threshold) {
+		cb.openedAt = time.Now()
+		cb.setState(BreakerOpen, err)
+	}
+	return err
+}
+
+func (cb *CircuitBreaker) trips(err error) bool {
+	if cb.Trip != nil {
+		return cb.Trip(err)
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// setState changes the state and calls OnChange - with cb.mu held.
+func (cb *CircuitBreaker) setState(state BreakerState, err error) {
+	if cb.state == state {
+		return
+	}
+	ev := BreakerEvent{Time: time.Now(), From: cb.state, To: state, Err: err, Failures: cb.failures}
+	cb.state = state
+	if cb.OnChange != nil {
+		cb.OnChange(ev)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// connectClient fails to connect while err is set.
+type connectClient struct {
+	Client
+	err      error
+	connects int
+}
+
+func (c *connectClient) Connect(context.Context) error { c.connects++; return c.err }
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	c := &connectClient{err: errors.New("connection refused")}
+	cb := NewCircuitBreaker(c, 2, time.Hour)
+	var events []BreakerEvent
+	cb.OnChange = func(ev BreakerEvent) { events = append(events, ev) }
+
+	for i := 0; i < 5; i++ {
+		if err := cb.Connect(ctx); err == nil {
+			t.Fatalf("%d. no error", i)
+		}
+	}
+	if c.connects != 2 || cb.State() != BreakerOpen || cb.OpenUntil().IsZero() {
+		t.Errorf("got %d connects in %s", c.connects, cb.State())
+	}
+	if err := cb.Connect(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got %+v, wanted ErrCircuitOpen", err)
+	}
+
+	// The cool-down passes: the first failure opens again, the first success closes.
+	cb.mu.Lock()
+	cb.openedAt = time.Now().Add(-2 * time.Hour)
+	cb.mu.Unlock()
+	if err := cb.Connect(ctx); err == nil || cb.State() != BreakerOpen || c.connects != 3 {
+		t.Errorf("half-open failure: got %+v, %s, %d connects", err, cb.State(), c.connects)
+	}
+	cb.mu.Lock()
+	cb.openedAt = time.Now().Add(-2 * time.Hour)
+	cb.mu.Unlock()
+	c.err = nil
+	if err := cb.Connect(ctx); err != nil || cb.State() != BreakerClosed {
+		t.Errorf("half-open success: got %+v, %s", err, cb.State())
+	}
+
+	var states []BreakerState
+	for _, ev := range events {
+		states = append(states, ev.To)
+	}
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(states) != len(want) {
+		t.Fatalf("got %v, wanted %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("%d. got %s, wanted %s", i, states[i], want[i])
+		}
+	}
+	if events[0].Failures != 2 || events[0].Err == nil {
+		t.Errorf("open event: got %+v", events[0])
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+// CacheTTLSetter is implemented by the Clients which can keep the metadata of the server across Connects.
+type CacheTTLSetter interface {
+	// SetCacheTTL keeps the capabilities and the folder list (the SPECIAL-USE names, the APPENDLIMITs)
+	// for d, across the Connects (the rounds of the DeliveryLoop), instead of asking the server each time.
+	// The folder list is dropped on the errors which may mean a changed folder structure
+	// (a failed SELECT, MOVE or APPEND), and when a mailbox is deleted or renamed.
+	//
+	// 0 (the default) disables the cache.
+	SetCacheTTL(d time.Duration)
+}
+
+var _ CacheTTLSetter = (*imapClient)(nil)
+
+// SetCacheTTL sets the time the metadata of the server is cached for - it applies from the next Connect.
+func (c *imapClient) SetCacheTTL(d time.Duration) {
+	c.cacheTTL = d
+	if d <= 0 {
+		c.resetCache()
+	}
+}
+
+// cacheFresh reports whether the cached metadata is still valid.
+func (c *imapClient) cacheFresh() bool {
+	return c.cacheTTL > 0 && !c.cachedAt.IsZero() && time.Since(c.cachedAt) < c.cacheTTL
+}
+
+// resetCache drops all the cached metadata.
+func (c *imapClient) resetCache() {
+	c.dropFolders()
+	c.caps, c.cachedAt = nil, time.Time{}
+}
+
+// dropFolders drops the cached folder list, as the folder structure may have changed.
+func (c *imapClient) dropFolders() {
+	c.special, c.mailboxNames, c.appendLimits = nil, nil, nil
+}
+
+// cachedCapabilities sets the capabilities of the ConnectInfo from the cache, if it is fresh.
+func (c *imapClient) cachedCapabilities() bool {
+	if !c.cacheFresh() || len(c.caps) == 0 {
+		return false
+	}
+	c.info.Capabilities = slices.Clone(c.caps)
+	c.info.Server, c.info.Quirks = lookupQuirks(c.info)
+	return true
+}
+
+// loginCapabilities gives the cached capabilities to go-imap too, so its Support (used by Move, Idle
+// and the others) does not ask the server either: they are added to the tagged OK of the login command
+// as a CAPABILITY response code - if the server has not sent one, which go-imap would use anyway.
+type loginCapabilities struct {
+	code atomic.Pointer[[]imapparse.Value]
+}
+
+// arm adds the cached capabilities (if they are fresh) to the next tagged response, if it is an OK.
+// It must be called right before sending the login command.
+func (lc *loginCapabilities) arm(c *imapClient) {
+	if !c.cacheFresh() || len(c.caps) == 0 {
+		return
+	}
+	code := make([]imapparse.Value, 0, 1+len(c.caps))
+	code = append(code, imapparse.Value{Kind: imapparse.Atom, Text: "CAPABILITY"})
+	for _, s := range c.caps {
+		code = append(code, imapparse.Value{Kind: imapparse.Atom, Text: s})
+	}
+	lc.code.Store(&code)
+}
+
+// disarm stops adding the capabilities.
+func (lc *loginCapabilities) disarm() { lc.code.Store(nil) }
+
+// filter is the guardConn filter adding the capabilities.
+func (lc *loginCapabilities) filter(resp *imapparse.Response) {
+	if resp.Tag == "*" || resp.Tag == "+" {
+		return
+	}
+	if code := lc.code.Swap(nil); code != nil && resp.Status == "OK" && len(resp.Code) == 0 {
+		resp.Code = *code
+	}
+}
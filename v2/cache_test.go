@@ -0,0 +1,80 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestCacheTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	l := make(pipeListener)
+	go srv.Serve(l)
+	defer srv.Close()
+	// wire records the commands sent by the client of the last dial.
+	var wire *recordConn
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		cConn, sConn := net.Pipe()
+		wire = &recordConn{Conn: sConn}
+		l <- wire
+		return cConn, nil
+	}
+
+	// commands returns the number of commands of a round: Connect and the resolution of SentItems,
+	// and the number of the CAPABILITY commands on the wire - including the ones of go-imap's Support.
+	var capCommands int
+	commands := func(c Client) uint64 {
+		ic := c.(*imapClient)
+		before := ic.Stats().Commands
+		if err := c.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(ctx, false)
+		ic.mailbox(ctx, SentItems)
+		if _, err := ic.c.Support("MOVE"); err != nil {
+			t.Fatal(err)
+		}
+		capCommands = wire.count(" CAPABILITY\r\n")
+		return ic.Stats().Commands - before
+	}
+
+	sa := ServerAddress{Host: "localhost", Username: "username", password: "password", TLSPolicy: NoTLS}
+	plain := FromDialer(sa, dial)
+	first := commands(plain)
+	if second := commands(plain); second != first {
+		t.Errorf("without cache: %d then %d commands", first, second)
+	}
+
+	cached := FromDialer(sa, dial)
+	cached.(CacheTTLSetter).SetCacheTTL(time.Hour)
+	if got := commands(cached); got != first {
+		t.Errorf("first cached round: got %d commands, wanted %d", got, first)
+	}
+	// CAPABILITY and LIST are saved.
+	if got := commands(cached); got != first-2 {
+		t.Errorf("second cached round: got %d commands, wanted %d", got, first-2)
+	}
+	if capCommands != 0 {
+		t.Errorf("second cached round: %d CAPABILITY commands sent", capCommands)
+	}
+	ic := cached.(*imapClient)
+	if ic.ConnectInfo().Has("IMAP4rev1") == false {
+		t.Errorf("cached capabilities: got %q", ic.ConnectInfo().Capabilities)
+	}
+	ic.dropFolders()
+	if got := commands(cached); got != first-1 {
+		t.Errorf("after dropping the folders: got %d commands, wanted %d", got, first-1)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"mime"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/textproto"
+)
+
+func init() {
+	// Without this, go-imap leaves the RFC 2047 encoded words in the ENVELOPE as is,
+	// for every charset except UTF-8, US-ASCII and ISO-8859-1.
+	if imap.CharsetReader == nil {
+		imap.CharsetReader = charset.Reader
+	}
+}
+
+var wordDecoder = mime.WordDecoder{CharsetReader: charset.Reader}
+
+var rEncodedWordCharset = regexp.MustCompile(`=\?([^?*]+)(?:\*[^?]*)?\?[bBqQ]\?`)
+
+// DecodeHeader decodes the RFC 2047 encoded words in s to UTF-8,
+// and returns the (lowercased) charset of the first encoded word, too.
+//
+// On error, s is returned as is.
+func DecodeHeader(s string) (decoded, charset string, err error) {
+	m := rEncodedWordCharset.FindStringSubmatch(s)
+	if m == nil {
+		return s, "", nil
+	}
+	charset = strings.ToLower(m[1])
+	if decoded, err = wordDecoder.DecodeHeader(s); err != nil {
+		return s, charset, err
+	}
+	return decoded, charset, nil
+}
+
+// envelopeFields are the header fields of the ENVELOPE which may contain encoded words.
+var envelopeFields = []string{"SUBJECT", "FROM", "SENDER", "REPLY-TO", "TO", "CC", "BCC"}
+
+// envelopeCharsets sets the charsets of the encoded words in the raw header fields
+// into m, under the "ENVELOPE.<field>.CHARSET" keys.
+func envelopeCharsets(m map[string][]string, raw string) {
+	hdr, err := textproto.ReadHeader(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil && hdr.Len() == 0 {
+		return
+	}
+	for _, k := range envelopeFields {
+		var charsets []string
+		for _, v := range hdr.Values(k) {
+			for _, sm := range rEncodedWordCharset.FindAllStringSubmatch(v, -1) {
+				if cs := strings.ToLower(sm[1]); !slices.Contains(charsets, cs) {
+					charsets = append(charsets, cs)
+				}
+			}
+		}
+		if len(charsets) != 0 {
+			m["ENVELOPE."+k+".CHARSET"] = charsets
+		}
+	}
+}
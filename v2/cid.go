@@ -0,0 +1,102 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// InlinePart is an inline part (image) of a message, referenced from the HTML body by its Content-ID.
+type InlinePart struct {
+	ContentType string
+	Data        []byte
+}
+
+// inlineHTMLer is implemented by the Clients which can return the HTML body and its inline parts themselves (o365).
+type inlineHTMLer interface {
+	InlineHTML(ctx context.Context, msgID uint32, urlFor func(contentID string) string) (string, map[string]InlinePart, error)
+}
+
+// InlineHTML returns the HTML body of the message with the cid: references resolved - see ParseInlineHTML.
+func InlineHTML(ctx context.Context, c Client, msgID uint32, urlFor func(contentID string) string) (string, map[string]InlinePart, error) {
+	if ih, ok := As[inlineHTMLer](c); ok {
+		return ih.InlineHTML(ctx, msgID, urlFor)
+	}
+	var buf bytes.Buffer
+	if _, err := c.Peek(ctx, &buf, msgID, ""); err != nil {
+		return "", nil, err
+	}
+	return ParseInlineHTML(&buf, urlFor)
+}
+
+// ParseInlineHTML returns the first text/html part of the message, with its cid: references
+// rewritten by ReplaceCIDs, and the parts with Content-ID, keyed by the Content-ID (without the angle brackets).
+func ParseInlineHTML(r io.Reader, urlFor func(contentID string) string) (string, map[string]InlinePart, error) {
+	m, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return "", nil, err
+	}
+	var html string
+	var hasHTML bool
+	parts := make(map[string]InlinePart)
+	err = m.Walk(func(_ []int, e *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) {
+			return err
+		}
+		mediaType, _, _ := e.Header.ContentType()
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return nil
+		}
+		if disp, _, _ := e.Header.ContentDisposition(); mediaType == "text/html" && !hasHTML && disp != "attachment" {
+			b, err := io.ReadAll(e.Body)
+			if err != nil {
+				return err
+			}
+			html, hasHTML = string(b), true
+			return nil
+		}
+		if cid := strings.Trim(strings.TrimSpace(e.Header.Get("Content-Id")), "<>"); cid != "" {
+			b, err := io.ReadAll(e.Body)
+			if err != nil {
+				return err
+			}
+			parts[cid] = InlinePart{ContentType: mediaType, Data: b}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return ReplaceCIDs(html, parts, urlFor), parts, nil
+}
+
+var rCID = regexp.MustCompile(`(?i)\bcid:([^"'\s)>]+)`)
+
+// ReplaceCIDs rewrites the cid: URLs (RFC 2392) of the HTML to urlFor(contentID),
+// or to data: URLs of the parts if urlFor is nil. The unknown references are left as is.
+func ReplaceCIDs(html string, parts map[string]InlinePart, urlFor func(contentID string) string) string {
+	return rCID.ReplaceAllStringFunc(html, func(ref string) string {
+		cid := ref[len("cid:"):]
+		if s, err := url.PathUnescape(cid); err == nil {
+			cid = s
+		}
+		p, ok := parts[cid]
+		if !ok {
+			return ref
+		}
+		if urlFor != nil {
+			return urlFor(cid)
+		}
+		return "data:" + nvl(p.ContentType, "application/octet-stream") + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+	})
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// ClaimKeywordPrefix is the prefix of the keywords ExactlyOnceDeliveryLoop claims the messages with.
+const ClaimKeywordPrefix = "$Delivering-"
+
+// ErrClaimed is returned (wrapped, with ErrSkip) for the messages claimed by another worker.
+var ErrClaimed = errors.New("claimed by another worker")
+
+// ExactlyOnceDeliveryLoop is like DeliveryLoop, but more workers (with distinct workerIDs)
+// can process the same inbox: each message is delivered by only one of them.
+//
+// Before delivering the message, the worker claims it by setting the ClaimKeywordPrefix+workerID
+// keyword on it with a conditional STORE (CONDSTORE, RFC 7162): the STORE fails if the message
+// has changed since the worker has seen it without a claim, so only one worker can win.
+// The claim is kept on the delivered messages, and on the failed ones, too.
+//
+// The failure modes:
+//
+//   - The server does not support CONDSTORE: no message is delivered, the errors are logged.
+//   - The worker stops between the claim and the Seen flag (crash, network error): the message
+//     stays claimed, and is delivered (again) only by a worker with the same workerID.
+//     So deliver still must tolerate a repeated delivery after a crash.
+//   - deliver returns ErrSkip, or the message cannot be read: the claim is released,
+//     any worker can retry the message.
+//   - deliver returns another error: the message keeps the claim (and moved to errbox, if set),
+//     only this worker retries it.
+func ExactlyOnceDeliveryLoop(ctx context.Context, c Client, inbox, pattern string, deliver DeliverFunc, outbox, errbox, workerID string, logger *slog.Logger, opts ...LoopOption) error {
+	o := newLoopOptions(opts)
+	return loop(ctx, o.client(c, logger), inbox, pattern, o.apply(deliver.buffered()).exactlyOnce(ClaimKeyword(workerID)), outbox, errbox, logger, o.hooks(outbox, errbox))
+}
+
+// ClaimKeyword returns the keyword for the worker: ClaimKeywordPrefix and workerID,
+// with the characters not allowed in an IMAP atom replaced by '_'.
+func ClaimKeyword(workerID string) string {
+	return ClaimKeywordPrefix + strings.Map(func(r rune) rune {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r) {
+			return '_'
+		}
+		return r
+	}, workerID)
+}
+
+// condStorer is implemented by the Clients which support conditional STORE.
+type condStorer interface {
+	// flagsModSeq returns the flags and the MODSEQ of the message, with ok=false if it does not exist.
+	flagsModSeq(ctx context.Context, uid uint32) (flags []string, modSeq uint64, ok bool, err error)
+	// storeUnchangedSince adds (or removes) the keyword, if the MODSEQ of the message is not greater
+	// than modSeq (or modSeq is 0). Returns false if the message has been modified since.
+	storeUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, keyword string, add bool) (bool, error)
+	// replaceUnchangedSince replaces the flags of the message, if the MODSEQ of the message is not greater
+	// than modSeq. Returns false if the message has been modified since.
+	replaceUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, flags []string) (bool, error)
+}
+
+// claim the message with the keyword: reports whether it is ours.
+func claim(ctx context.Context, cs condStorer, uid uint32, keyword string) (bool, error) {
+	flags, modSeq, ok, err := cs.flagsModSeq(ctx, uid)
+	if err != nil || !ok {
+		return false, err
+	}
+	for _, f := range flags {
+		if strings.EqualFold(f, keyword) {
+			return true, nil // our earlier claim
+		}
+		if len(f) > len(ClaimKeywordPrefix) && strings.EqualFold(f[:len(ClaimKeywordPrefix)], ClaimKeywordPrefix) {
+			return false, nil
+		}
+	}
+	return cs.storeUnchangedSince(ctx, uid, modSeq, keyword, true)
+}
+
+// exactlyOnce claims the message before delivering it.
+func (deliver readDeliverer) exactlyOnce(keyword string) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		cs, ok := As[condStorer](c)
+		if !ok {
+			return fmt.Errorf("%T: conditional STORE: %w", c, errors.ErrUnsupported), nil
+		}
+		if won, err := claim(ctx, cs, uid, keyword); err != nil {
+			return fmt.Errorf("claim %d: %w", uid, err), nil
+		} else if !won {
+			return nil, fmt.Errorf("%d: %w: %w", uid, ErrClaimed, ErrSkip)
+		}
+		readErr, err := deliver(ctx, c, uid, hsh)
+		if readErr != nil || errors.Is(err, ErrSkip) {
+			if _, relErr := cs.storeUnchangedSince(ctx, uid, 0, keyword, false); relErr != nil {
+				err = errors.Join(err, fmt.Errorf("release %d: %w", uid, relErr))
+			}
+		}
+		return readErr, err
+	}
+}
+
+var _ condStorer = (*imapClient)(nil)
+
+func (c *imapClient) flagsModSeq(ctx context.Context, uid uint32) ([]string, uint64, bool, error) {
+	if ok, err := c.c.Support("CONDSTORE"); err != nil {
+		return nil, 0, false, err
+	} else if !ok {
+		return nil, 0, false, fmt.Errorf("CONDSTORE: %w", errors.ErrUnsupported)
+	}
+	set := &imap.SeqSet{}
+	set.AddNum(uid)
+	const modSeqItem = imap.FetchItem("MODSEQ")
+	ch := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		var called bool
+		err := c.withTimeout(ctx, func() error {
+			called = true
+			return c.c.UidFetch(set, []imap.FetchItem{imap.FetchFlags, modSeqItem}, ch)
+		})
+		if !called { // UidFetch closes ch
+			close(ch)
+		}
+		done <- err
+	}()
+	var flags []string
+	var modSeq uint64
+	var found bool
+	for msg := range ch {
+		if msg.Uid != uid {
+			continue
+		}
+		found, flags = true, msg.Flags
+		// MODSEQ (12345)
+		if vv, ok := msg.Items[modSeqItem].([]interface{}); ok && len(vv) != 0 {
+			modSeq, _ = strconv.ParseUint(fmt.Sprintf("%v", vv[0]), 10, 64)
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, 0, false, err
+	}
+	if found && modSeq == 0 {
+		return nil, 0, false, fmt.Errorf("no MODSEQ for %d", uid)
+	}
+	return flags, modSeq, found, nil
+}
+
+func (c *imapClient) storeUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, keyword string, add bool) (bool, error) {
+	var op imap.FlagsOp = imap.AddFlags
+	if !add {
+		op = imap.RemoveFlags
+	}
+	return c.condStore(ctx, uid, modSeq, op, []string{keyword})
+}
+
+func (c *imapClient) replaceUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, flags []string) (bool, error) {
+	// \Recent is set by the server only.
+	flags = slices.DeleteFunc(slices.Clone(flags), func(f string) bool { return strings.EqualFold(f, imap.RecentFlag) })
+	return c.condStore(ctx, uid, modSeq, imap.SetFlags, flags)
+}
+
+// condStore is UID STORE (UNCHANGEDSINCE modSeq) op (flags), with modSeq=0 meaning unconditional.
+func (c *imapClient) condStore(ctx context.Context, uid uint32, modSeq uint64, op imap.FlagsOp, flags []string) (bool, error) {
+	if c.readOnly {
+		return false, fmt.Errorf("store %s: %w", strings.Join(flags, " "), ErrReadOnly)
+	}
+	set := &imap.SeqSet{}
+	set.AddNum(uid)
+	args := []interface{}{imap.RawString("STORE"), set}
+	if modSeq != 0 {
+		args = append(args, []interface{}{imap.RawString("UNCHANGEDSINCE"), imap.RawString(strconv.FormatUint(modSeq, 10))})
+	}
+	list := make([]interface{}, len(flags))
+	for i, f := range flags {
+		list[i] = imap.RawString(f)
+	}
+	args = append(args, imap.RawString(imap.FormatFlagsOp(op, true)), list)
+	var status *imap.StatusResp
+	if err := c.withTimeout(ctx, func() error {
+		var err error
+		status, err = c.c.Execute(&imap.Command{Name: "UID", Arguments: args}, nil)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	if err := status.Err(); err != nil {
+		return false, err
+	}
+	// OK [MODIFIED 7] UID STORE completed
+	return status.Code != "MODIFIED", nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeMailbox is a mailbox shared by the fakeClients, with CONDSTORE semantics.
+type fakeMailbox struct {
+	flags  map[uint32][]string
+	modSeq map[uint32]uint64
+	// subjects of the messages, their UID if not set.
+	subjects map[uint32]string
+	last     uint64
+	mu       sync.Mutex
+}
+
+func newFakeMailbox(uids ...uint32) *fakeMailbox {
+	mb := fakeMailbox{flags: make(map[uint32][]string), modSeq: make(map[uint32]uint64)}
+	for _, uid := range uids {
+		mb.last++
+		mb.flags[uid], mb.modSeq[uid] = nil, mb.last
+	}
+	return &mb
+}
+
+// add adds the message with the subject and the flags.
+func (mb *fakeMailbox) add(uid uint32, subject string, flags ...string) {
+	if mb.subjects == nil {
+		mb.subjects = make(map[uint32]string)
+	}
+	mb.last++
+	mb.flags[uid], mb.modSeq[uid], mb.subjects[uid] = flags, mb.last, subject
+}
+
+func (mb *fakeMailbox) subject(uid uint32) string {
+	if s, ok := mb.subjects[uid]; ok {
+		return s
+	}
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+// sortedSubjects returns the sorted subjects of the messages.
+func (mb *fakeMailbox) sortedSubjects() []string {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	ss := make([]string, 0, len(mb.flags))
+	for uid := range mb.flags {
+		ss = append(ss, mb.subject(uid))
+	}
+	slices.Sort(ss)
+	return ss
+}
+
+func (mb *fakeMailbox) setFlag(uid uint32, flag string, add bool) {
+	flags := slices.DeleteFunc(mb.flags[uid], func(f string) bool { return f == flag })
+	if add {
+		flags = append(flags, flag)
+	}
+	mb.last++
+	mb.flags[uid], mb.modSeq[uid] = flags, mb.last
+}
+
+// fakeClient implements the methods of Client which are used by one, and condStorer.
+//
+// It uses mb - or if boxes is not nil, the mailbox of the last List from boxes,
+// and Move moves the messages between them.
+type fakeClient struct {
+	Client
+	mb          *fakeMailbox
+	boxes       map[string]*fakeMailbox
+	beforeStore func()
+	// selected is the mailbox of the last List.
+	selected string
+	// nextUID is the last UID given to a moved message.
+	nextUID uint32
+	// window bounds List, but not listUnbounded.
+	window SearchWindow
+}
+
+func (c *fakeClient) Connect(context.Context) error     { return nil }
+func (c *fakeClient) Close(context.Context, bool) error { return nil }
+func (c *fakeClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	uids, err := c.listUnbounded(ctx, mbox, pattern, all)
+	return c.window.Filter(uids), err
+}
+func (c *fakeClient) listUnbounded(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	c.selected = mbox
+	if c.boxes != nil {
+		c.mb = c.box(mbox)
+	}
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	var uids []uint32
+	for uid, flags := range c.mb.flags {
+		if all || !slices.Contains(flags, `\Seen`) {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+func (c *fakeClient) ReadTo(ctx context.Context, w io.Writer, uid uint32) (int64, error) {
+	c.mb.mu.Lock()
+	subject := c.mb.subject(uid)
+	c.mb.mu.Unlock()
+	n, err := fmt.Fprintf(w, "Subject: %s\r\n\r\n", subject)
+	return int64(n), err
+}
+func (c *fakeClient) Peek(ctx context.Context, w io.Writer, uid uint32, what string) (int64, error) {
+	return c.ReadTo(ctx, w, uid)
+}
+func (c *fakeClient) Move(ctx context.Context, uid uint32, mbox string) error {
+	if c.boxes == nil {
+		return errors.ErrUnsupported
+	}
+	c.mb.mu.Lock()
+	flags, ok := c.mb.flags[uid]
+	subject := c.mb.subject(uid)
+	delete(c.mb.flags, uid)
+	delete(c.mb.modSeq, uid)
+	delete(c.mb.subjects, uid)
+	c.mb.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("move %d: no such message", uid)
+	}
+	dst := c.box(mbox)
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	c.nextUID++
+	dst.add(c.nextUID, subject, flags...)
+	return nil
+}
+
+// box returns the mailbox from boxes, creating it if needed.
+func (c *fakeClient) box(mbox string) *fakeMailbox {
+	mb := c.boxes[mbox]
+	if mb == nil {
+		mb = newFakeMailbox()
+		c.boxes[mbox] = mb
+	}
+	return mb
+}
+func (c *fakeClient) Mark(ctx context.Context, uid uint32, seen bool) error {
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	c.mb.setFlag(uid, `\Seen`, seen)
+	return nil
+}
+
+func (c *fakeClient) flagsModSeq(ctx context.Context, uid uint32) ([]string, uint64, bool, error) {
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	flags, ok := c.mb.flags[uid]
+	return slices.Clone(flags), c.mb.modSeq[uid], ok, nil
+}
+func (c *fakeClient) storeUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, keyword string, add bool) (bool, error) {
+	if c.beforeStore != nil {
+		c.beforeStore()
+	}
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	if modSeq != 0 && c.mb.modSeq[uid] > modSeq {
+		return false, nil
+	}
+	c.mb.setFlag(uid, keyword, add)
+	return true, nil
+}
+
+func (c *fakeClient) replaceUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, flags []string) (bool, error) {
+	if c.beforeStore != nil {
+		c.beforeStore()
+	}
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	if modSeq != 0 && c.mb.modSeq[uid] > modSeq {
+		return false, nil
+	}
+	c.mb.last++
+	c.mb.flags[uid], c.mb.modSeq[uid] = slices.Clone(flags), c.mb.last
+	return true, nil
+}
+
+func TestClaimRace(t *testing.T) {
+	ctx := context.Background()
+	mb := newFakeMailbox(1)
+	a, b := &fakeClient{mb: mb}, &fakeClient{mb: mb}
+	kwA, kwB := ClaimKeyword("a"), ClaimKeyword("b")
+	// b claims the message between the FETCH and the STORE of a.
+	a.beforeStore = func() {
+		a.beforeStore = nil
+		if won, err := claim(ctx, b, 1, kwB); err != nil || !won {
+			t.Fatalf("b: won=%t err=%+v", won, err)
+		}
+	}
+	if won, err := claim(ctx, a, 1, kwA); err != nil || won {
+		t.Fatalf("a: won=%t err=%+v", won, err)
+	}
+	if won, err := claim(ctx, a, 1, kwA); err != nil || won {
+		t.Errorf("a again: won=%t err=%+v", won, err)
+	}
+	if won, err := claim(ctx, b, 1, kwB); err != nil || !won {
+		t.Errorf("b again: won=%t err=%+v", won, err)
+	}
+}
+
+func TestExactlyOnce(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mb := newFakeMailbox(1, 2, 3)
+	var mu sync.Mutex
+	delivered := make(map[uint32][]string)
+	var skipped bool
+	deliverAs := func(worker string) DeliverFunc {
+		return func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if uid == 2 && !skipped {
+				skipped = true
+				return ErrSkip
+			}
+			delivered[uid] = append(delivered[uid], worker)
+			return nil
+		}
+	}
+
+	// b processes the whole inbox between the FETCH and the STORE of the first claim of a.
+	a, b := &fakeClient{mb: mb}, &fakeClient{mb: mb}
+	deliverA := deliverAs("a").buffered().exactlyOnce(ClaimKeyword("a"))
+	deliverB := deliverAs("b").buffered().exactlyOnce(ClaimKeyword("b"))
+	a.beforeStore = func() {
+		a.beforeStore = nil
+		if _, err := one(ctx, b, "INBOX", "", deliverB, "", "", logger, loopHooks{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := one(ctx, a, "INBOX", "", deliverA, "", "", logger, loopHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	// The ErrSkip of b has released 2, which is delivered by a; a second round delivers nothing.
+	if _, err := one(ctx, a, "INBOX", "", deliverA, "", "", logger, loopHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(delivered)
+	for _, uid := range []uint32{1, 2, 3} {
+		if got := delivered[uid]; len(got) != 1 {
+			t.Errorf("%d delivered by %q, wanted exactly once", uid, got)
+		}
+	}
+
+	// No CONDSTORE: no delivery.
+	type plainClient struct{ Client }
+	mb = newFakeMailbox(4)
+	if n, err := one(ctx, plainClient{&fakeClient{mb: mb}}, "INBOX", "", deliverA, "", "", logger, loopHooks{}); err != nil || n != 0 {
+		t.Errorf("got n=%d err=%+v", n, err)
+	}
+	if _, ok := delivered[4]; ok {
+		t.Error("4 delivered without claim")
+	}
+}
@@ -19,9 +19,12 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/tgulacsi/imapclient/v2/imapparse"
 	"github.com/tgulacsi/imapclient/xoauth2"
+	"golang.org/x/oauth2"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
@@ -71,7 +74,46 @@ type imapClient struct {
 	logger  *slog.Logger
 	status  *imap.MailboxStatus
 	created []string
-	logMask LogMask
+	// special maps the SPECIAL-USE attributes to the mailbox names,
+	// mailboxNames holds the names of the other mailboxes - see listSpecial.
+	special      map[string]string
+	mailboxNames []string
+	window       SearchWindow
+	tokenSource  oauth2.TokenSource
+	// delim is the hierarchy delimiter, see Delimiter.
+	delim string
+	StatsCounter
+	info ConnectInfo
+	dial DialFunc
+	// normalize the messages read by ReadTo.
+	normalize bool
+	// readOnly opens the mailboxes with EXAMINE.
+	readOnly bool
+	// noPeek fetches the bodies without PEEK, setting \Seen.
+	noPeek bool
+	// limits of the server responses, checked by guard.
+	limits    imapparse.Limits
+	parseMode imapparse.Mode
+	guard     *guardConn
+	// lit8 converts the literal8 of the BINARY extension.
+	lit8 *literal8Conn
+	// appendLimits caches the per-mailbox APPENDLIMITs.
+	appendLimits map[string]int64
+	// caps are the cached capabilities, cachedAt is the start of the cache, see SetCacheTTL.
+	caps     []string
+	cachedAt time.Time
+	cacheTTL time.Duration
+	authMu   sync.Mutex
+	logMask  LogMask
+	// updates relays the unilateral updates of the connection to Watch, idle and notify.
+	updates updateRelay
+	// notified are the mailboxes NOTIFY has been set for on the connection, see notify.
+	notified []string
+	// loginCaps gives the cached capabilities to go-imap.
+	loginCaps loginCapabilities
+	// compress enables COMPRESS=DEFLATE, see SetCompress; deflate is its layer of the connection.
+	compress bool
+	deflate  *deflateConn
 }
 
 // NewClient returns a new (not connected) Client, using TLS iff port == 143.
@@ -121,7 +163,23 @@ type ServerAddress struct {
 	ClientID, ClientSecret string
 	Port                   uint32
 	TLSPolicy              tlsPolicy
-}
+	// AllowPlaintextAuth allows sending the credentials over an unencrypted connection
+	// with MaybeTLS, when the server does not support STARTTLS.
+	// With NoTLS this is always allowed.
+	AllowPlaintextAuth bool
+	// RejectPreAuth refuses the PREAUTH greeting (an already authenticated connection,
+	// which cannot be upgraded with STARTTLS) on an unencrypted connection.
+	RejectPreAuth bool
+}
+
+var (
+	// ErrPlaintextAuth is returned by Connect when the credentials would be sent unencrypted.
+	ErrPlaintextAuth = errors.New("refusing to send credentials over an unencrypted connection")
+	// ErrPreAuth is returned by Connect on a refused PREAUTH greeting.
+	ErrPreAuth = errors.New("PREAUTH over an unencrypted connection")
+	// ErrAuth is returned by Connect when the server rejected all the login methods.
+	ErrAuth = errors.New("authentication failed")
+)
 
 func (m ServerAddress) WithPassword(password string) ServerAddress {
 	m.password = password
@@ -255,16 +313,20 @@ func (c *imapClient) SetLogger(lgr *slog.Logger) {
 }
 
 // Select selects the mailbox to use - it is needed before ReadTo
-// (List includes this).
+// (List includes this). It uses EXAMINE if SetReadOnly(true) was called.
 func (c *imapClient) Select(ctx context.Context, mbox string) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	mbox = c.mailbox(ctx, mbox)
 	//c.mu.Lock()
-	status, err := c.c.Select(mbox, false)
+	start := time.Now()
+	status, err := c.c.Select(mbox, c.readOnly)
 	//c.mu.Unlock()
+	c.CountCommand(start, err)
 	if err != nil {
 		c.logger.Error("Select", "mbox", mbox, "error", err)
+		c.dropFolders()
 		return fmt.Errorf("SELECT %q: %w", mbox, err)
 	}
 	c.logger.Debug("Select", "mbox", mbox, "status", status)
@@ -273,18 +335,29 @@ func (c *imapClient) Select(ctx context.Context, mbox string) error {
 }
 
 // ReadTo reads the message identified by the given msgID, into the io.Writer,
-// within the given context (deadline).
+// within the given context (deadline) - normalized, if SetNormalize(true) was called.
 func (c *imapClient) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64, error) {
 	if err := ctx.Err(); err != nil {
 		return 0, err
 	}
-	return c.Peek(ctx, w, msgID, "")
+	if !c.normalize {
+		return c.Peek(ctx, w, msgID, "")
+	}
+	nw := NewNormalizeWriter(w)
+	n, err := c.Peek(ctx, nw, msgID, "")
+	if closeErr := nw.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return n, err
 }
 
 // Peek into the message. Possible what: HEADER, TEXT, or empty (both) -
 // see http://tools.ietf.org/html/rfc3501#section-6.4.5
 func (c *imapClient) Peek(ctx context.Context, w io.Writer, msgID uint32, what string) (int64, error) {
-	section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.PartSpecifier(what)}, Peek: true}
+	return c.peekSection(ctx, w, msgID, &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.PartSpecifier(what)}, Peek: !c.noPeek})
+}
+
+func (c *imapClient) peekSection(ctx context.Context, w io.Writer, msgID uint32, section *imap.BodySectionName) (int64, error) {
 	set := &imap.SeqSet{}
 	set.AddNum(msgID)
 	ch := make(chan *imap.Message, 1)
@@ -306,13 +379,25 @@ func (c *imapClient) Peek(ctx context.Context, w io.Writer, msgID uint32, what s
 			return 0, io.EOF
 		}
 		if msg != nil {
-			return io.Copy(w, msg.GetBody(section))
+			n, err := io.Copy(w, msg.GetBody(section))
+			c.CountFetched(n)
+			// Wait for the end of the command, so the next one does not overlap it.
+			if doneErr := <-done; err == nil {
+				err = doneErr
+			}
+			return n, err
 		}
 	}
 	return 0, nil
 }
 
-// Fetch the message. Possible what: RFC3551 6.5.4 (RFC822.SIZE, ENVELOPE, ...). The default is "RFC822.SIZE ENVELOPE".
+// Fetch the message. Possible what: RFC3551 6.5.4 (RFC822.SIZE, ENVELOPE, ...). The default is "RFC822.SIZE INTERNALDATE ENVELOPE".
+//
+// The body items (RFC822, RFC822.TEXT, BODY[...]) are fetched with BODY.PEEK, so they don't set \Seen
+// (unless SetPeek(false) was called), but are returned under the requested names.
+//
+// The ENVELOPE fields are decoded to UTF-8, the charsets of the original encoded words
+// are under the ENVELOPE.<field>.CHARSET keys (for example ENVELOPE.SUBJECT.CHARSET).
 func (c *imapClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
@@ -323,12 +408,37 @@ func (c *imapClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint3
 		set.AddNum(msgID)
 	}
 	if what == "" {
-		what = "RFC822.SIZE ENVELOPE"
+		what = "RFC822.SIZE INTERNALDATE ENVELOPE"
 	}
 	ss := strings.Fields(what)
 	items := make([]imap.FetchItem, len(ss))
+	var envelope *imap.BodySectionName
+	// aliases maps the body sections of the response to the requested items, rewritten by peekItem.
+	var aliases map[string]string
 	for i, s := range ss {
+		if !c.noPeek {
+			if peek, section := peekItem(s); section != "" {
+				if section != s {
+					if aliases == nil {
+						aliases = make(map[string]string)
+					}
+					aliases[section] = s
+				}
+				s = peek
+			}
+		}
 		items[i] = imap.FetchItem(s)
+		if items[i] == imap.FetchEnvelope && envelope == nil {
+			// The raw header fields, to know the charsets of the decoded ENVELOPE fields.
+			envelope = &imap.BodySectionName{
+				BodyPartName: imap.BodyPartName{
+					Specifier: imap.HeaderSpecifier,
+					Fields:    envelopeFields,
+				},
+				Peek: true,
+			}
+			items = append(items, envelope.FetchItem())
+		}
 	}
 
 	done := make(chan error, 1)
@@ -336,17 +446,17 @@ func (c *imapClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint3
 	//c.mu.Lock()
 	//defer c.mu.Unlock()
 	go func() {
-		defer close(ch)
-		done <- c.withTimeout(ctx, func() error {
+		var called bool
+		err := c.withTimeout(ctx, func() error {
+			called = true
 			return c.c.UidFetch(set, items, ch)
 		})
+		if !called { // UidFetch closes ch
+			close(ch)
+		}
+		done <- err
 	}()
-	select {
-	case <-ctx.Done():
-		return result, ctx.Err()
-	case err := <-done:
-		return result, err
-	case msg := <-ch:
+	for msg := range ch {
 		m := make(map[string][]string)
 		result[msg.Uid] = m
 
@@ -359,8 +469,40 @@ func (c *imapClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint3
 		if !msg.InternalDate.IsZero() {
 			m[string(imap.FetchInternalDate)] = []string{msg.InternalDate.Format(time.RFC3339)}
 		}
+		if _, ok := msg.Items[imap.FetchFlags]; ok {
+			m[string(imap.FetchFlags)] = msg.Flags
+		}
+		// The items of the extensions (such as X-GM-LABELS) are only in Items.
 		for k, v := range msg.Items {
-			m[string(k)] = []string{fmt.Sprintf("%v", v)}
+			switch v := v.(type) {
+			case nil:
+			case []interface{}:
+				ss := make([]string, len(v))
+				for i, x := range v {
+					ss[i] = fmt.Sprintf("%v", x)
+				}
+				m[string(k)] = ss
+			default:
+				m[string(k)] = []string{fmt.Sprintf("%v", v)}
+			}
+		}
+		// The body sections (such as RFC822.HEADER) are not in Items.
+		var envelopeHeader string
+		for sect, lit := range msg.Body {
+			var buf strings.Builder
+			if lit != nil {
+				io.Copy(&buf, lit)
+			}
+			if envelope != nil && sect.Specifier == imap.HeaderSpecifier && !sect.NotFields &&
+				len(sect.Fields) == len(envelopeFields) {
+				envelopeHeader = buf.String()
+				continue
+			}
+			k := string(sect.FetchItem())
+			if a, ok := aliases[k]; ok {
+				k = a
+			}
+			m[k] = []string{buf.String()}
 		}
 		if b := msg.BodyStructure; b != nil {
 			m["BODY.MIME-TYPE"] = []string{b.MIMEType + "/" + b.MIMESubType}
@@ -376,7 +518,7 @@ func (c *imapClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint3
 
 		if env := msg.Envelope; env != nil {
 			m["ENVELOPE.DATE"] = []string{env.Date.Format(time.RFC3339)}
-			m["ENVELOPE.SUBJECT"] = []string{env.Subject}
+			m["ENVELOPE.SUBJECT"] = []string{decodeHeader(env.Subject)}
 			m["ENVELOPE.FROM"] = formatAddressList(nil, env.From)
 			m["ENVELOPE.SENDER"] = formatAddressList(nil, env.Sender)
 			m["ENVELOPE.REPLY-TO"] = formatAddressList(nil, env.ReplyTo)
@@ -385,8 +527,14 @@ func (c *imapClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint3
 			m["ENVELOPE.BCC"] = formatAddressList(nil, env.Bcc)
 			m["ENVELOPE.IN-REPLY-TO"] = []string{env.InReplyTo}
 			m["ENVELOPE.MESSAGE-ID"] = []string{env.MessageId}
+			if envelopeHeader != "" {
+				envelopeCharsets(m, envelopeHeader)
+			}
 		}
 	}
+	if err := <-done; err != nil {
+		return result, err
+	}
 	return result, nil
 }
 
@@ -400,49 +548,52 @@ func formatAddressList(dst []string, addrs []*imap.Address) []string {
 func formatAddress(addr *imap.Address) string {
 	s := "<" + addr.MailboxName + "@" + addr.HostName + ">"
 	if addr.PersonalName != "" {
-		return addr.PersonalName + " " + s
+		return decodeHeader(addr.PersonalName) + " " + s
 	}
 	return s
 }
 
+// decodeHeader decodes the encoded words go-imap could not,
+// due to an unknown charset.
+func decodeHeader(s string) string {
+	d, _, _ := DecodeHeader(s)
+	return d
+}
+
 // Move moves the msgid to the given mbox, within deadline.
+//
+// Uses MOVE (RFC 6851) if the server supports it, COPY otherwise:
+// both preserve the internal date of the message.
 func (c *imapClient) Move(ctx context.Context, msgID uint32, mbox string) error {
-	if err := ctx.Err(); err != nil {
-		return err
-	}
-	created := false
+	return c.MoveSet(ctx, NewSeqSet(msgID), mbox)
+}
+
+// ensureCreated creates the mailbox, if it has not been tried yet.
+func (c *imapClient) ensureCreated(mbox string) {
 	for _, k := range c.created {
 		if mbox == k {
-			created = true
-			break
+			return
 		}
 	}
-	if !created {
-		c.logger.Info("Create", "box", mbox)
-		c.created = append(c.created, mbox)
-		//c.mu.Lock()
-		err := c.c.Create(mbox)
-		//c.mu.Unlock()
-		if err != nil {
-			c.logger.Error("Create", "box", mbox, "error", err)
-		}
-	}
-
-	set := &imap.SeqSet{}
-	set.AddNum(msgID)
+	c.logger.Info("Create", "box", mbox)
+	c.created = append(c.created, mbox)
 	//c.mu.Lock()
-	err := c.c.UidCopy(set, mbox)
+	err := c.countCommand(time.Now(), c.c.Create(mbox))
 	//c.mu.Unlock()
 	if err != nil {
-		return fmt.Errorf("copy %s: %w", mbox, err)
+		c.logger.Error("Create", "box", mbox, "error", err)
 	}
-	return c.Delete(ctx, msgID)
 }
 
 // List the messages from the given mbox, matching the pattern.
 // Lists only new (UNSEEN) messages iff all is false,
-// withing the given context (deadline).
+// withing the given context (deadline), bounded by the SearchWindow.
 func (c *imapClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	return c.list(ctx, mbox, listCriteria(pattern, all, c.window), c.window)
+}
+
+// list selects mbox, and searches it with crit, applying the MinUID and Max bounds of the window.
+func (c *imapClient) list(ctx context.Context, mbox string, crit *imap.SearchCriteria, window SearchWindow) ([]uint32, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
@@ -450,18 +601,12 @@ func (c *imapClient) List(ctx context.Context, mbox, pattern string, all bool) (
 		return nil, fmt.Errorf("SELECT %q: %w", mbox, err)
 	}
 
-	crit := imap.NewSearchCriteria()
-	crit.WithoutFlags = append(crit.WithoutFlags, imap.DeletedFlag)
-	if !all {
-		crit.WithoutFlags = append(crit.WithoutFlags, imap.SeenFlag)
-	}
-	if pattern != "" {
-		crit.Header.Set("Subject", pattern)
-	}
 	//c.mu.Lock()
 	//defer c.mu.Unlock()
 	// The response contains a list of message sequence IDs
+	start := time.Now()
 	uids, err := c.c.UidSearch(crit)
+	c.CountCommand(start, err)
 	if err != nil {
 		c.logger.Error("UidSearch", "crit", crit, "error", err)
 		return uids, err
@@ -469,7 +614,28 @@ func (c *imapClient) List(ctx context.Context, mbox, pattern string, all bool) (
 	if c.logger.Enabled(ctx, slog.LevelDebug) {
 		c.logger.Debug("UidSearch", "crit", crit, "error", err)
 	}
-	return uids, err
+	// UID n:* matches the last message even if its UID is less than n.
+	return window.Filter(uids), err
+}
+
+// listCriteria returns the SEARCH criteria of List, bounded by the window.
+func listCriteria(pattern string, all bool, window SearchWindow) *imap.SearchCriteria {
+	crit := imap.NewSearchCriteria()
+	crit.WithoutFlags = append(crit.WithoutFlags, imap.DeletedFlag)
+	if !all {
+		crit.WithoutFlags = append(crit.WithoutFlags, imap.SeenFlag)
+	}
+	if pattern != "" {
+		crit.Header.Set("Subject", pattern)
+	}
+	if since := window.Start(time.Now()); !since.IsZero() {
+		crit.Since = since
+	}
+	if window.MinUID != 0 {
+		crit.Uid = new(imap.SeqSet)
+		crit.Uid.AddRange(window.MinUID+1, 0)
+	}
+	return crit
 }
 
 // Mailboxes returns the list of mailboxes under root
@@ -477,27 +643,23 @@ func (c *imapClient) Mailboxes(ctx context.Context, root string) ([]string, erro
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	ch := make(chan *imap.MailboxInfo, 1)
+	ch := make(chan *imap.MailboxInfo, 16)
 	done := make(chan error, 1)
 	//c.mu.Lock()
 	//defer c.mu.Unlock()
 	go func() {
-		done <- c.withTimeout(ctx, func() error {
-			return c.c.List(root, "*", ch)
-		})
+		var called bool
+		err := c.withTimeout(ctx, func() error { called = true; return c.c.List(root, "*", ch) })
+		if !called { // List closes ch
+			close(ch)
+		}
+		done <- err
 	}()
 	var names []string
-	select {
-	case <-ctx.Done():
-		return names, ctx.Err()
-	case err := <-done:
-		return names, err
-	case mi := <-ch:
-		if mi != nil {
-			names = append(names, mi.Name)
-		}
+	for mi := range ch {
+		names = append(names, mi.Name)
 	}
-	return names, nil
+	return names, <-done
 }
 
 // Close closes the currently selected mailbox, then logs out.
@@ -513,7 +675,7 @@ func (c *imapClient) Close(ctx context.Context, expunge bool) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	var err error
-	if expunge {
+	if expunge && !c.readOnly {
 		err = c.withTimeout(ctx, func() error { return c.c.Expunge(nil) })
 	}
 	if closeErr := c.withTimeout(ctx, func() error { return c.c.Close() }); closeErr != nil && err == nil {
@@ -528,33 +690,12 @@ func (c *imapClient) Close(ctx context.Context, expunge bool) error {
 
 // Mark marks the message seen/unseen, within the given context (deadline).
 func (c *imapClient) Mark(ctx context.Context, msgID uint32, seen bool) error {
-	if err := ctx.Err(); err != nil {
-		return err
-	}
-	set := &imap.SeqSet{}
-	set.AddNum(msgID)
-	item := imap.FormatFlagsOp(imap.AddFlags, true)
-	if !seen {
-		item = imap.FormatFlagsOp(imap.RemoveFlags, true)
-	}
-	flags := []interface{}{imap.SeenFlag}
-	//c.mu.Lock()
-	//defer c.mu.Unlock()
-	return c.c.UidStore(set, item, flags, nil)
+	return c.MarkSet(ctx, NewSeqSet(msgID), seen)
 }
 
 // Delete deletes the message, within the given context (deadline).
 func (c *imapClient) Delete(ctx context.Context, msgID uint32) error {
-	if err := ctx.Err(); err != nil {
-		return err
-	}
-	set := &imap.SeqSet{}
-	set.AddNum(msgID)
-	item := imap.FormatFlagsOp(imap.AddFlags, true)
-	flags := []interface{}{imap.DeletedFlag}
-	//c.mu.Lock()
-	//defer c.mu.Unlock()
-	return c.c.UidStore(set, item, flags, nil)
+	return c.DeleteSet(ctx, NewSeqSet(msgID))
 }
 
 // Watch the current mailbox for changes.
@@ -565,10 +706,9 @@ func (c *imapClient) Watch(ctx context.Context) ([]uint32, error) {
 	}
 	ch := make(chan client.Update, 1)
 	var uids []uint32
-	c.c.Updates = ch
+	defer c.updates.set(ch)()
 	select {
 	case <-ctx.Done():
-		c.c.Updates = nil
 		return uids, ctx.Err()
 	case upd := <-ch:
 		switch x := upd.(type) {
@@ -576,15 +716,40 @@ func (c *imapClient) Watch(ctx context.Context) ([]uint32, error) {
 			uids = append(uids, x.Message.Uid)
 		}
 	}
-	c.c.Updates = nil
 	return uids, nil
 }
 
 // WriteTo appends the message the given mailbox.
+//
+// date is sent as the INTERNALDATE of the message, if not zero -
+// otherwise the server uses the current time.
+//
+// A message containing NUL (binary parts) is sent as literal8, if the server supports BINARY.
+// A message larger than the APPENDLIMIT is refused with a *TooLargeError, without uploading it.
 func (c *imapClient) WriteTo(ctx context.Context, mbox string, msg []byte, date time.Time) error {
+	return c.AppendFlags(ctx, mbox, msg, date, nil)
+}
+
+// AppendFlags appends the message as WriteTo, with the flags (except \Recent and \Deleted) set.
+func (c *imapClient) AppendFlags(ctx context.Context, mbox string, msg []byte, date time.Time, flags []string) error {
+	if c.readOnly {
+		return fmt.Errorf("append to %q: %w", mbox, ErrReadOnly)
+	}
+	mbox = c.mailbox(ctx, mbox)
+	if err := CheckAppendSize(ctx, c, mbox, int64(len(msg))); err != nil {
+		return err
+	}
 	//c.mu.Lock()
 	//defer c.mu.Unlock()
-	return c.c.Append(mbox, nil, date, literalBytes(msg))
+	if c.appendBinary(msg) {
+		defer c.lit8.appendHeader.Store(nil)
+	}
+	if err := c.countCommand(time.Now(), c.c.Append(mbox, appendableFlags(flags), date, literalBytes(msg))); err != nil {
+		c.dropFolders()
+		return err
+	}
+	c.CountUploaded(int64(len(msg)))
+	return nil
 }
 
 // Connect connects to the server, within the given context (deadline).
@@ -592,26 +757,67 @@ func (c *imapClient) Connect(ctx context.Context) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	c.CountConnect()
 	//c.mu.Lock()
 	if c.c != nil {
 		c.c.Logout()
 		c.c = nil
 	}
+	if !c.cacheFresh() {
+		c.resetCache()
+	}
 	addr := c.Host + ":" + strconv.Itoa(int(c.Port))
-	var cl *client.Client
-	var err error
 	noTLS := c.TLSPolicy == NoTLS || c.TLSPolicy == MaybeTLS && c.Port == 143
-	if noTLS {
-		cl, err = client.Dial(addr)
-	} else {
-		cl, err = client.DialTLS(addr, &TLSConfig)
-	}
+	conn, err := c.dialConn(ctx, addr, noTLS)
 	//c.mu.Unlock()
 	if err != nil {
 		c.logger.Error("Connect", "addr", addr, "error", err)
 		return fmt.Errorf("%s: %w", addr, err)
 	}
-	c.c = cl
+	tlsOn := !noTLS
+	var greeting string
+	if noTLS {
+		// STARTTLS is negotiated below the layers, so they work over TLS, too.
+		tc, greet, started, err := c.startTLS(ctx, conn)
+		if tc == nil {
+			conn.Close()
+			c.logger.Error("Connect", "addr", addr, "error", err)
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+		if err != nil {
+			c.logger.Warn("StartTLS", "error", err)
+			if c.TLSPolicy != NoTLS {
+				tc.Close()
+				return fmt.Errorf("%s: STARTTLS: %w", addr, err)
+			}
+		}
+		conn, greeting, tlsOn = tc, greet, started
+	}
+	logger := c.logger
+	c.deflate = &deflateConn{Conn: conn}
+	c.lit8 = &literal8Conn{Conn: c.deflate}
+	noPipelining := noPipeliningFilter()
+	c.guard = newGuardConn(c.lit8, c.limits, c.parseMode, func(err error) {
+		logger.Warn("server response", "addr", addr, "error", err)
+	}, func(resp *imapparse.Response) {
+		c.loginCaps.filter(resp)
+		c.deflate.filter(resp)
+		noPipelining(resp)
+	})
+	gc := &greetingConn{Conn: c.guard}
+	cl, err := client.New(gc)
+	if err != nil {
+		c.guard.Close()
+		c.logger.Error("Connect", "addr", addr, "error", err)
+		return fmt.Errorf("%s: %w", addr, err)
+	}
+	c.c, c.notified = cl, nil
+	c.updates.start(cl)
+	if greeting == "" {
+		greeting = gc.Greeting()
+	}
+	c.info = ConnectInfo{Addr: addr, Greeting: greeting, TLS: tlsOn}
+	c.info.Server, c.info.Quirks = lookupQuirks(c.info)
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -621,14 +827,40 @@ func (c *imapClient) Connect(ctx context.Context) error {
 	//c.mu.Lock()
 	//defer c.mu.Unlock()
 	c.c.Timeout = 0
-	// Enable encryption, if supported by the server
-	if ok, _ := c.c.SupportStartTLS(); ok {
-		c.logger.Info("Starting TLS")
-		c.c.StartTLS(&TLSConfig)
+	// PREAUTH: the server has already authenticated us (by the connection), and STARTTLS is not allowed.
+	preAuth := c.c.State() == imap.AuthenticatedState
+	if preAuth && !c.info.TLS && c.RejectPreAuth {
+		return fmt.Errorf("%s: %w", addr, ErrPreAuth)
+	}
+	if !preAuth && !c.info.TLS && c.TLSPolicy != NoTLS && !c.AllowPlaintextAuth {
+		return fmt.Errorf("%s: %w", addr, ErrPlaintextAuth)
 	}
 
 	// Authenticate
-	return c.login(ctx)
+	if preAuth {
+		c.logger.Info("PREAUTH, skipping login")
+		c.info.AuthMechanism = "preauth"
+	} else if err := c.login(ctx); err != nil {
+		if guardErr := c.guard.Err(); guardErr != nil {
+			return fmt.Errorf("%s: %w", addr, guardErr)
+		}
+		return err
+	}
+	c.info.ConnectedAt = time.Now()
+	if c.cacheTTL > 0 && c.cachedAt.IsZero() {
+		c.cachedAt = c.info.ConnectedAt
+	}
+	if err := c.updateCapabilities(); err != nil {
+		c.logger.Warn("CAPABILITY", "error", err)
+	}
+	if c.compress && c.info.Has("COMPRESS=DEFLATE") {
+		if err := c.startCompress(); err != nil {
+			c.logger.Warn("COMPRESS", "error", err)
+		} else {
+			c.info.Compressed = true
+		}
+	}
+	return nil
 }
 
 var errNotLoggedIn = errors.New("not logged in")
@@ -637,16 +869,22 @@ func (c *imapClient) login(ctx context.Context) (err error) {
 	if err = ctx.Err(); err != nil {
 		return err
 	}
-	logger := c.logger.With("username", c.Username)
+	username, password, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	logger := c.logger.With("username", username)
 	order := []string{"login", "oauthbearer", "xoauth2", "cram-md5", "plain"}
-	if len(c.password) > 40 {
+	if len(password) > 40 {
 		order[0], order[1], order[2] = order[1], order[2], order[0]
 	}
 
 	oLogMask := c.logMask
 	defer func() { c.setLogMask(oLogMask) }()
 	c.setLogMask(LogAll)
+	defer c.loginCaps.disarm()
 
+	var refused error
 	for _, method := range order {
 		logger := logger.With("method", method)
 		logger.Info("try logging in")
@@ -654,52 +892,85 @@ func (c *imapClient) login(ctx context.Context) (err error) {
 
 		switch method {
 		case "login":
-			err = c.c.Login(c.Username, c.password)
+			// The server refuses LOGIN (before STARTTLS) - but may accept AUTHENTICATE.
+			if ok, _ := c.c.Support("LOGINDISABLED"); !ok {
+				c.loginCaps.arm(c)
+				err = c.c.Login(username, password)
+			}
 
 		case "oauthbearer":
 			if ok, _ := c.c.SupportAuth("OAUTHBEARER"); ok {
+				c.loginCaps.arm(c)
 				err = c.c.Authenticate(sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
-					Username: c.Username, Token: c.password,
+					Username: username, Token: password,
 				}))
 			}
 
 		case "cram-md5":
 			if ok, _ := c.c.SupportAuth("CRAM-MD5"); ok {
-				err = c.c.Authenticate(CramAuth(c.Username, c.password))
+				c.loginCaps.arm(c)
+				err = c.c.Authenticate(CramAuth(username, password))
 			}
 
 		case "plain":
 			if ok, _ := c.c.SupportAuth("PLAIN"); ok {
-				username, identity := c.Username, ""
-				if i := strings.IndexByte(username, '\\'); i >= 0 {
-					identity, username = strings.TrimPrefix(username[i+1:], "\\"), username[:i]
+				user, identity := username, ""
+				if i := strings.IndexByte(user, '\\'); i >= 0 {
+					identity, user = strings.TrimPrefix(user[i+1:], "\\"), user[:i]
 				}
 				logger = logger.With("method", method, "identity", identity)
+				c.loginCaps.arm(c)
 
-				err = c.c.Authenticate(sasl.NewPlainClient(identity, username, c.password))
+				err = c.c.Authenticate(sasl.NewPlainClient(identity, user, password))
 			}
 
 		case "xoauth2":
 			if ok, _ := c.c.SupportAuth("XOAUTH2"); ok {
+				c.loginCaps.arm(c)
 				err = c.c.Authenticate(xoauth2.NewXOAuth2Client(&xoauth2.XOAuth2Options{
-					Username: c.Username, AccessToken: c.password,
+					Username: username, AccessToken: password,
 				}))
 				if err != nil {
-					logger.Info("XOAUTH2", "password", c.password, "error", err)
+					logger.Info("XOAUTH2", "password", password, "error", err)
 				}
 			}
 		}
 
 		if err == nil || strings.Contains(err.Error(), "Already logged in") {
 			logger.Info("logged in", "method", method, "error", err)
+			c.info.AuthMechanism = method
 			return nil
 		}
 		logger.Info("login failed", "method", method, "error", err)
+		if errors.Is(err, errNotLoggedIn) {
+			continue
+		}
+		// Only the refusals of the server are authentication errors,
+		// a broken connection is not - so it does not trip a CircuitBreaker.
+		if !c.refused(err) {
+			return fmt.Errorf("login (%s): %w", method, err)
+		}
+		refused = err
 	}
-	if err != nil {
-		return err
+	if refused != nil {
+		return fmt.Errorf("%w: %w", ErrAuth, refused)
+	}
+	return fmt.Errorf("%w: %w", ErrAuth, errNotLoggedIn)
+}
+
+// refused reports whether the error of a login command is the refusal of the server (NO or BAD),
+// not a failure of the connection - go-imap returns both as plain errors.
+func (c *imapClient) refused(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	select {
+	case <-c.c.LoggedOut():
+		return false
+	default:
+		return true
 	}
-	return errNotLoggedIn
 }
 
 // withTimeout executes f within the ctx.Deadline(), then resets the timeout.
@@ -713,7 +984,7 @@ func (c *imapClient) withTimeout(ctx context.Context, f func() error) error {
 		c.logger.Info("setTimeout", "deadline", d.UTC(), "timeout", c.c.Timeout.String())
 		defer func() { c.c.Timeout = 0 }()
 	}
-	return f()
+	return c.countCommand(time.Now(), f())
 }
 
 func literalBytes(msg []byte) imap.Literal {
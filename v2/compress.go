@@ -0,0 +1,179 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"compress/flate"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+// CompressSetter is implemented by the Clients which can compress the connection.
+type CompressSetter interface {
+	// SetCompress enables COMPRESS=DEFLATE (RFC 4978) after the login, if the server offers it.
+	// It applies from the next Connect, see ConnectInfo.Compressed.
+	SetCompress(bool)
+}
+
+var _ CompressSetter = (*imapClient)(nil)
+
+// SetCompress enables COMPRESS=DEFLATE after the login, if the server offers it.
+func (c *imapClient) SetCompress(compress bool) { c.compress = compress }
+
+// startCompress negotiates COMPRESS=DEFLATE: the connection is compressed right after the tagged OK.
+func (c *imapClient) startCompress() error {
+	c.deflate.armed.Store(true)
+	defer c.deflate.armed.Store(false)
+	status, err := c.c.Execute(&imap.Command{Name: "COMPRESS", Arguments: []interface{}{imap.RawString("DEFLATE")}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// deflateConn is the COMPRESS=DEFLATE layer of the connection, below literal8Conn and guardConn.
+//
+// It is started by its guardConn filter at the tagged OK of the COMPRESS command, before anything
+// else is read. The decompression runs in its own goroutine - as the parsing of guardConn -,
+// so the timeouts of the connection are passed on without breaking the compressed stream.
+type deflateConn struct {
+	net.Conn
+	// armed starts the compression at the next tagged OK, on is set when it has started.
+	armed, on atomic.Bool
+	w         *flate.Writer
+	wmu       sync.Mutex
+	more      chan struct{}
+	out       chan guardOut
+	quit      chan struct{}
+	done      chan struct{}
+	err       error
+	cur       []byte
+	quitOnce  sync.Once
+}
+
+// filter is the guardConn filter starting the compression.
+func (dc *deflateConn) filter(resp *imapparse.Response) {
+	if resp.Tag == "*" || resp.Tag == "+" || !dc.armed.Swap(false) || resp.Status != "OK" {
+		return
+	}
+	dc.start()
+}
+
+func (dc *deflateConn) start() {
+	// Only an invalid level is an error.
+	dc.w, _ = flate.NewWriter(dc.Conn, flate.DefaultCompression)
+	dc.more, dc.out = make(chan struct{}), make(chan guardOut)
+	dc.quit, dc.done = make(chan struct{}), make(chan struct{})
+	r := flate.NewReader(readerFunc(dc.read))
+	go func() {
+		defer close(dc.done)
+		buf := make([]byte, 32<<10)
+		for {
+			select {
+			case <-dc.more:
+			case <-dc.quit:
+				return
+			}
+			n, err := r.Read(buf)
+			if n == 0 && err != nil {
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					err = io.EOF
+				}
+				dc.err = err
+				return
+			}
+			select {
+			case dc.out <- guardOut{b: buf[:n]}:
+			case <-dc.quit:
+				return
+			}
+		}
+	}()
+	dc.on.Store(true)
+}
+
+// read reads the compressed stream, passing on the timeouts, as guardConn.read.
+func (dc *deflateConn) read(p []byte) (int, error) {
+	for {
+		n, err := dc.Conn.Read(p)
+		if n != 0 || err == nil {
+			return n, nil
+		}
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			return 0, err
+		}
+		select {
+		case dc.out <- guardOut{err: err}:
+		case <-dc.quit:
+			return 0, io.EOF
+		}
+		select {
+		case <-dc.more:
+		case <-dc.quit:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (dc *deflateConn) Read(p []byte) (int, error) {
+	if !dc.on.Load() {
+		return dc.Conn.Read(p)
+	}
+	for len(dc.cur) == 0 {
+		select {
+		case dc.more <- struct{}{}:
+		case <-dc.done:
+			return 0, dc.doneErr()
+		}
+		select {
+		case o := <-dc.out:
+			if o.err != nil {
+				return 0, o.err
+			}
+			dc.cur = o.b
+		case <-dc.done:
+			return 0, dc.doneErr()
+		}
+	}
+	n := copy(p, dc.cur)
+	dc.cur = dc.cur[n:]
+	return n, nil
+}
+
+func (dc *deflateConn) doneErr() error {
+	if dc.err != nil {
+		return dc.err
+	}
+	return net.ErrClosed
+}
+
+// Write compresses p, flushing it (Z_SYNC_FLUSH), as each write is a command (or its part) to be answered.
+func (dc *deflateConn) Write(p []byte) (int, error) {
+	if !dc.on.Load() {
+		return dc.Conn.Write(p)
+	}
+	dc.wmu.Lock()
+	defer dc.wmu.Unlock()
+	n, err := dc.w.Write(p)
+	if err == nil {
+		err = dc.w.Flush()
+	}
+	return n, err
+}
+
+func (dc *deflateConn) Close() error {
+	if dc.on.Load() {
+		dc.quitOnce.Do(func() { close(dc.quit) })
+	}
+	return dc.Conn.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, compress := range []bool{false, true} {
+		cConn, sConn := net.Pipe()
+		rc := &recordConn{Conn: sConn}
+		scriptServer(rc, nil, "* OK [CAPABILITY IMAP4rev1] ready\r\n", map[string]string{
+			"CAPABILITY": "* CAPABILITY IMAP4rev1 compress=deflate\r\nTAG OK done\r\n",
+			"SELECT":     "* 1 EXISTS\r\n* OK [UIDVALIDITY 1] UIDs valid\r\nTAG OK [READ-WRITE] done\r\n",
+		})
+		c := NewClientConn(cConn, "username", "password").(*imapClient)
+		c.SetCompress(compress)
+		if err := c.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		info := c.ConnectInfo()
+		if !info.Has("COMPRESS=DEFLATE") {
+			t.Errorf("Has is case sensitive: %q", info.Capabilities)
+		}
+		if info.Compressed != compress {
+			t.Errorf("Compressed=%t, wanted %t", info.Compressed, compress)
+		}
+		if err := c.Select(ctx, "INBOX"); err != nil {
+			t.Fatalf("compress=%t: %+v", compress, err)
+		}
+		// The server reads the commands after COMPRESS through a deflateConn, so the SELECT
+		// succeeds only if it is compressed - which ends with a sync flush.
+		if n := rc.count("\x00\x00\xff\xff"); (n != 0) != compress {
+			t.Errorf("compress=%t: %d sync flushes", compress, n)
+		}
+		c.Close(ctx, false)
+	}
+}
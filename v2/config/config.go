@@ -0,0 +1,532 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package config builds the Clients from a configuration file (TOML, YAML or JSON),
+// so the accounts can be changed without recompiling.
+//
+// An example TOML configuration:
+//
+//	[[accounts]]
+//	name = "support"
+//	address = "support@example.com"  # server settings from the providers package
+//	password = "env:SUPPORT_PASSWORD"
+//	[accounts.loop]
+//	inbox = "INBOX"
+//	outbox = "Processed"
+//	errbox = "Failed"
+//	since = "720h"
+//
+//	[[accounts]]
+//	name = "sales"
+//	type = "graph"
+//	[accounts.oauth]
+//	client_id = "..."
+//	client_secret = "file:/run/secrets/graph"
+//	tenant_id = "..."
+//	user_id = "sales@example.com"
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tgulacsi/imapclient/v2"
+	"github.com/tgulacsi/imapclient/v2/decrypt"
+	"github.com/tgulacsi/imapclient/v2/o365"
+	"github.com/tgulacsi/imapclient/v2/providers"
+)
+
+// Config is the list of the accounts.
+type Config struct {
+	Accounts []AccountConfig `toml:"accounts" yaml:"accounts" json:"accounts"`
+}
+
+// AccountConfig describes an account.
+//
+// The secrets (Password, OAuth.ClientSecret) can be given as "env:NAME" to read them
+// from the environment, or as "file:/path" to read them from a file.
+type AccountConfig struct {
+	// Name of the account, for logging.
+	Name string `toml:"name" yaml:"name" json:"name"`
+	// Type is "imap" (the default), "o365" (Outlook REST API) or "graph" (Microsoft Graph).
+	Type string `toml:"type" yaml:"type" json:"type"`
+	// Address is the email address: the server settings are looked up with providers.Lookup
+	// when Host is empty, and it is the default Username.
+	Address string `toml:"address" yaml:"address" json:"address"`
+	Host    string `toml:"host" yaml:"host" json:"host"`
+	// TLS is "force", "none" or "maybe" (the default, TLS except on port 143).
+	TLS      string `toml:"tls" yaml:"tls" json:"tls"`
+	Username string `toml:"username" yaml:"username" json:"username"`
+	Password string `toml:"password" yaml:"password" json:"password"`
+	OAuth    OAuth  `toml:"oauth" yaml:"oauth" json:"oauth"`
+	Loop     Loop   `toml:"loop" yaml:"loop" json:"loop"`
+	Port     uint32 `toml:"port" yaml:"port" json:"port"`
+	// AllowPlaintextAuth and RejectPreAuth are the ServerAddress security options.
+	AllowPlaintextAuth bool `toml:"allow_plaintext_auth" yaml:"allow_plaintext_auth" json:"allow_plaintext_auth"`
+	RejectPreAuth      bool `toml:"reject_preauth" yaml:"reject_preauth" json:"reject_preauth"`
+	// Normalize the line endings of the read messages, see imapclient.NewNormalizeWriter.
+	Normalize bool `toml:"normalize" yaml:"normalize" json:"normalize"`
+	// ReadOnly opens the mailboxes with EXAMINE, see imapclient.ReadOnlySetter.
+	ReadOnly bool `toml:"read_only" yaml:"read_only" json:"read_only"`
+	// NoPeek fetches the messages without BODY.PEEK, marking them \Seen, see imapclient.PeekSetter.
+	NoPeek bool `toml:"no_peek" yaml:"no_peek" json:"no_peek"`
+	// Compress enables COMPRESS=DEFLATE on IMAP, see imapclient.CompressSetter.
+	Compress bool `toml:"compress" yaml:"compress" json:"compress"`
+	// RulesFile is the routing rules file (see RulesConfig), reloaded when modified.
+	RulesFile string `toml:"rules_file" yaml:"rules_file" json:"rules_file"`
+	// Decrypt holds the keys to decrypt the encrypted messages with.
+	Decrypt Decrypt `toml:"decrypt" yaml:"decrypt" json:"decrypt"`
+}
+
+// Decrypt holds the S/MIME and OpenPGP keys of an account.
+type Decrypt struct {
+	// SMIMECert and SMIMEKey are the PEM encoded S/MIME certificate and private key files.
+	SMIMECert string `toml:"smime_cert" yaml:"smime_cert" json:"smime_cert"`
+	SMIMEKey  string `toml:"smime_key" yaml:"smime_key" json:"smime_key"`
+	// PGPKeyring is the OpenPGP secret keyring file, PGPPassphrase its passphrase (a Secret).
+	PGPKeyring    string `toml:"pgp_keyring" yaml:"pgp_keyring" json:"pgp_keyring"`
+	PGPPassphrase string `toml:"pgp_passphrase" yaml:"pgp_passphrase" json:"pgp_passphrase"`
+}
+
+// OAuth holds the settings of the o365 and graph accounts.
+type OAuth struct {
+	ClientID     string `toml:"client_id" yaml:"client_id" json:"client_id"`
+	ClientSecret string `toml:"client_secret" yaml:"client_secret" json:"client_secret"`
+	TenantID     string `toml:"tenant_id" yaml:"tenant_id" json:"tenant_id"`
+	// Cloud is the name of the national cloud of the o365 account (such as "usgovhigh"),
+	// see o365.Clouds; the global one if empty.
+	Cloud string `toml:"cloud" yaml:"cloud" json:"cloud"`
+	// Consumer marks a personal (outlook.com) o365 account, see o365.Consumer.
+	Consumer bool `toml:"consumer" yaml:"consumer" json:"consumer"`
+	// UserID is the user (ID or email address) of the graph account.
+	UserID string `toml:"user_id" yaml:"user_id" json:"user_id"`
+	// Impersonate the user with the o365 account.
+	Impersonate string `toml:"impersonate" yaml:"impersonate" json:"impersonate"`
+	RedirectURL string `toml:"redirect_url" yaml:"redirect_url" json:"redirect_url"`
+	// BodyContentType ("text" or "html") and TimeZone are requested by the o365 account,
+	// see o365.BodyContentType and o365.TimeZone.
+	BodyContentType string `toml:"body_content_type" yaml:"body_content_type" json:"body_content_type"`
+	TimeZone        string `toml:"timezone" yaml:"timezone" json:"timezone"`
+}
+
+// Loop holds the mailboxes and options of DeliveryLoop.
+type Loop struct {
+	Inbox   string `toml:"inbox" yaml:"inbox" json:"inbox"`
+	Pattern string `toml:"pattern" yaml:"pattern" json:"pattern"`
+	Outbox  string `toml:"outbox" yaml:"outbox" json:"outbox"`
+	Errbox  string `toml:"errbox" yaml:"errbox" json:"errbox"`
+	// Since is the start of the SearchWindow: a duration before each List (such as "720h",
+	// the SearchWindow.MaxAge), or a date (2006-01-02, the SearchWindow.Since).
+	Since      string `toml:"since" yaml:"since" json:"since"`
+	MinUID     uint32 `toml:"min_uid" yaml:"min_uid" json:"min_uid"`
+	MaxResults int    `toml:"max_results" yaml:"max_results" json:"max_results"`
+	// MaxMessageSize limits the size of the delivered messages,
+	// the larger ones are handled according to Oversize: "skip" (the default), "headers" or "errbox".
+	MaxMessageSize int64  `toml:"max_message_size" yaml:"max_message_size" json:"max_message_size"`
+	Oversize       string `toml:"oversize" yaml:"oversize" json:"oversize"`
+	// Clamd ("host:port" or a unix socket path) or ICAP (icap://host/service) scans the messages,
+	// the infected ones are moved to Quarantine (the Errbox if empty).
+	Clamd      string `toml:"clamd" yaml:"clamd" json:"clamd"`
+	ICAP       string `toml:"icap" yaml:"icap" json:"icap"`
+	Quarantine string `toml:"quarantine" yaml:"quarantine" json:"quarantine"`
+	// Annotate stores annotated copies in the Outbox, see imapclient.WithAnnotatedArchive.
+	Annotate bool `toml:"annotate" yaml:"annotate" json:"annotate"`
+	// Snooze is the mailbox of the snoozed messages, see imapclient.WithSnooze.
+	Snooze string `toml:"snooze" yaml:"snooze" json:"snooze"`
+	// CreateMailboxes creates the missing Outbox and Errbox, see imapclient.WithCreateMailboxes.
+	CreateMailboxes bool `toml:"create_mailboxes" yaml:"create_mailboxes" json:"create_mailboxes"`
+	// Partition is the time layout of the date-partitioned subfolders of the Outbox ("2006/01"),
+	// see imapclient.WithPartitionedOutbox.
+	Partition string `toml:"partition" yaml:"partition" json:"partition"`
+	// Processing is the per-worker mailbox the messages are moved to before delivery,
+	// see imapclient.WithProcessingFolder.
+	Processing string `toml:"processing" yaml:"processing" json:"processing"`
+}
+
+// LoadConfig reads the configuration file, in the format given by its extension:
+// .toml, .yaml (.yml) or .json.
+func LoadConfig(fn string) (*Config, error) {
+	var cfg Config
+	if err := decodeFile(fn, &cfg); err != nil {
+		return nil, err
+	}
+	for i, a := range cfg.Accounts {
+		if a.Name == "" {
+			cfg.Accounts[i].Name = nvl(a.Address, a.Username, a.OAuth.UserID, strconv.Itoa(i))
+		}
+	}
+	return &cfg, nil
+}
+
+// decodeFile decodes the file into v, in the format given by its extension.
+func decodeFile(fn string, v any) error {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(fn)); ext {
+	case ".toml":
+		err = toml.Unmarshal(b, v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, v)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(v)
+	default:
+		return fmt.Errorf("%q: unknown config format %q", fn, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parse %q: %w", fn, err)
+	}
+	return nil
+}
+
+// RulesConfig is the format of the routing rules file:
+//
+//	[[rules]]
+//	name = "invoices"
+//	subject = "(?i)invoice"
+//	action = "move"
+//	mailbox = "Invoices"
+type RulesConfig struct {
+	Rules []imapclient.Rule `toml:"rules" yaml:"rules" json:"rules"`
+}
+
+// LoadRules reads the routing rules file, in the format given by its extension.
+func LoadRules(fn string) ([]imapclient.Rule, error) {
+	var rf RulesConfig
+	if err := decodeFile(fn, &rf); err != nil {
+		return nil, err
+	}
+	return rf.Rules, nil
+}
+
+// RulesReloadInterval is the period of checking the rules files for changes.
+var RulesReloadInterval = 30 * time.Second
+
+// Account is a configured Client, with its DeliveryLoop settings.
+type Account struct {
+	Client                         imapclient.Client
+	Name                           string
+	Inbox, Pattern, Outbox, Errbox string
+	Options                        []imapclient.LoopOption
+	// Rules are the routing rules, reloaded by Run when RulesFile changes.
+	Rules     *imapclient.Rules
+	RulesFile string
+}
+
+// Run runs the DeliveryLoop of the account.
+func (a *Account) Run(ctx context.Context, deliver imapclient.DeliverFunc, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("account", a.Name)
+	if a.Rules != nil && a.RulesFile != "" {
+		go a.watchRules(ctx, logger)
+	}
+	return imapclient.DeliveryLoop(ctx, a.Client, a.Inbox, a.Pattern, deliver, a.Outbox, a.Errbox, logger, a.Options...)
+}
+
+// watchRules reloads the Rules when the RulesFile is modified, keeping the old ones on error.
+func (a *Account) watchRules(ctx context.Context, logger *slog.Logger) {
+	var last time.Time
+	if fi, err := os.Stat(a.RulesFile); err == nil {
+		last = fi.ModTime()
+	}
+	ticker := time.NewTicker(RulesReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(a.RulesFile)
+		if err != nil || !fi.ModTime().After(last) {
+			continue
+		}
+		last = fi.ModTime()
+		rules, err := LoadRules(a.RulesFile)
+		if err == nil {
+			err = a.Rules.Set(rules)
+		}
+		if err != nil {
+			logger.Error("reload rules", "file", a.RulesFile, "error", err)
+			continue
+		}
+		logger.Info("rules reloaded", "file", a.RulesFile, "count", len(rules))
+	}
+}
+
+// Open returns the (not connected) Clients of the accounts.
+func (cfg *Config) Open(ctx context.Context) ([]*Account, error) {
+	accounts := make([]*Account, 0, len(cfg.Accounts))
+	var errs []error
+	for _, ac := range cfg.Accounts {
+		a, err := ac.Account(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ac.Name, err))
+			continue
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, errors.Join(errs...)
+}
+
+// Account returns the (not connected) Client of the account.
+func (ac AccountConfig) Account(ctx context.Context) (*Account, error) {
+	c, err := ac.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var w imapclient.SearchWindow
+	if w.Since, w.MaxAge, err = parseSince(ac.Loop.Since); err != nil {
+		return nil, err
+	}
+	w.MinUID, w.Max = ac.Loop.MinUID, ac.Loop.MaxResults
+	if !w.IsZero() {
+		ws, ok := imapclient.As[imapclient.SearchWindowSetter](c)
+		if !ok {
+			return nil, fmt.Errorf("search window: %w", errors.ErrUnsupported)
+		}
+		ws.SetSearchWindow(w)
+	}
+	if ns, ok := imapclient.As[imapclient.NormalizeSetter](c); ok && ac.Normalize {
+		ns.SetNormalize(true)
+	}
+	if rs, ok := imapclient.As[imapclient.ReadOnlySetter](c); ok && ac.ReadOnly {
+		rs.SetReadOnly(true)
+	}
+	if ps, ok := imapclient.As[imapclient.PeekSetter](c); ok && ac.NoPeek {
+		ps.SetPeek(false)
+	}
+	if cs, ok := imapclient.As[imapclient.CompressSetter](c); ok && ac.Compress {
+		cs.SetCompress(true)
+	}
+	a := Account{
+		Client: c, Name: ac.Name,
+		Inbox: ac.Loop.Inbox, Pattern: ac.Loop.Pattern,
+		Outbox: ac.Loop.Outbox, Errbox: ac.Loop.Errbox,
+	}
+	if ac.Loop.MaxMessageSize > 0 {
+		var policy imapclient.OversizePolicy
+		switch ac.Loop.Oversize {
+		case "", "skip":
+			policy = imapclient.OversizeSkip
+		case "headers":
+			policy = imapclient.OversizeHeaders
+		case "errbox":
+			policy = imapclient.OversizeErrbox
+		default:
+			return nil, fmt.Errorf("unknown oversize policy %q", ac.Loop.Oversize)
+		}
+		a.Options = append(a.Options, imapclient.WithMaxMessageSize(ac.Loop.MaxMessageSize, policy))
+	}
+	if ac.RulesFile != "" {
+		rules, err := LoadRules(ac.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		if a.Rules, err = imapclient.NewRules(rules); err != nil {
+			return nil, fmt.Errorf("%s: %w", ac.RulesFile, err)
+		}
+		a.RulesFile = ac.RulesFile
+		a.Options = append(a.Options, imapclient.WithRules(a.Rules))
+	}
+	switch {
+	case ac.Loop.Clamd != "":
+		cd := imapclient.Clamd{Addr: ac.Loop.Clamd}
+		if strings.HasPrefix(cd.Addr, "/") {
+			cd.Network = "unix"
+		}
+		a.Options = append(a.Options, imapclient.WithScanner(cd, ac.Loop.Quarantine))
+	case ac.Loop.ICAP != "":
+		a.Options = append(a.Options, imapclient.WithScanner(imapclient.ICAP{URL: ac.Loop.ICAP}, ac.Loop.Quarantine))
+	}
+	if ac.Loop.Annotate {
+		a.Options = append(a.Options, imapclient.WithAnnotatedArchive(nil))
+	}
+	if ac.Loop.Snooze != "" {
+		a.Options = append(a.Options, imapclient.WithSnooze(ac.Loop.Snooze))
+	}
+	if ac.Loop.CreateMailboxes {
+		a.Options = append(a.Options, imapclient.WithCreateMailboxes())
+	}
+	if ac.Loop.Processing != "" {
+		a.Options = append(a.Options, imapclient.WithProcessingFolder(ac.Loop.Processing))
+	}
+	if ac.Loop.Partition != "" {
+		a.Options = append(a.Options, imapclient.WithPartitionedOutbox(ac.Loop.Partition))
+	}
+	if ds, err := ac.Decrypt.decrypters(); err != nil {
+		return nil, err
+	} else if len(ds) != 0 {
+		a.Options = append(a.Options, imapclient.WithDecrypters(ds...))
+	}
+	return &a, nil
+}
+
+func (d Decrypt) decrypters() ([]imapclient.Decrypter, error) {
+	var ds []imapclient.Decrypter
+	if d.SMIMECert != "" {
+		s, err := decrypt.LoadSMIME(d.SMIMECert, d.SMIMEKey)
+		if err != nil {
+			return nil, fmt.Errorf("load S/MIME key: %w", err)
+		}
+		ds = append(ds, s)
+	}
+	if d.PGPKeyring != "" {
+		pass, err := Secret(d.PGPPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		p, err := decrypt.LoadPGP(d.PGPKeyring, []byte(pass))
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, p)
+	}
+	return ds, nil
+}
+
+func (ac AccountConfig) client(ctx context.Context) (imapclient.Client, error) {
+	switch ac.Type {
+	case "o365":
+		secret, err := Secret(ac.OAuth.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		cloud, err := o365.LookupCloud(ac.OAuth.Cloud)
+		if err != nil {
+			return nil, err
+		}
+		opts := []o365.ClientOption{
+			o365.Impersonate(ac.OAuth.Impersonate),
+			o365.TenantID(ac.OAuth.TenantID),
+			o365.WithCloud(cloud),
+			o365.Consumer(ac.OAuth.Consumer),
+			o365.BodyContentType(ac.OAuth.BodyContentType),
+			o365.TimeZone(ac.OAuth.TimeZone),
+		}
+		if err = o365.ValidateOptions(ac.OAuth.ClientID, secret, opts...); err != nil {
+			return nil, err
+		}
+		return o365.NewIMAPClient(o365.NewClient(
+			ac.OAuth.ClientID, secret, nvl(ac.OAuth.RedirectURL, "http://localhost:8123"), opts...)), nil
+	case "graph":
+		secret, err := Secret(ac.OAuth.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		c, err := o365.NewGraphMailClient(ctx, ac.OAuth.ClientID, secret, ac.OAuth.TenantID, nvl(ac.OAuth.UserID, ac.Address))
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case "", "imap":
+	default:
+		return nil, fmt.Errorf("unknown account type %q", ac.Type)
+	}
+
+	password, err := Secret(ac.Password)
+	if err != nil {
+		return nil, err
+	}
+	var sa imapclient.ServerAddress
+	if ac.Host == "" {
+		if ac.Address == "" {
+			return nil, errors.New("host or address is needed")
+		}
+		p, err := providers.Lookup(ctx, ac.Address)
+		if err != nil {
+			return nil, err
+		}
+		sa = p.Server
+	} else {
+		sa = imapclient.ServerAddress{Host: ac.Host, Port: ac.Port, TLSPolicy: imapclient.MaybeTLS}
+	}
+	if ac.Port != 0 {
+		sa.Port = ac.Port
+	}
+	switch ac.TLS {
+	case "force":
+		sa.TLSPolicy = imapclient.ForceTLS
+	case "none":
+		sa.TLSPolicy = imapclient.NoTLS
+	case "maybe":
+		sa.TLSPolicy = imapclient.MaybeTLS
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown tls %q", ac.TLS)
+	}
+	if sa.Port == 0 {
+		sa.Port = 993
+		if sa.TLSPolicy == imapclient.NoTLS {
+			sa.Port = 143
+		}
+	}
+	sa.Username = nvl(ac.Username, ac.Address)
+	sa.AllowPlaintextAuth, sa.RejectPreAuth = ac.AllowPlaintextAuth, ac.RejectPreAuth
+	return imapclient.FromServerAddress(sa.WithPassword(password)), nil
+}
+
+// Secret resolves the "env:NAME" and "file:/path" references, returns other values as is.
+func Secret(s string) (string, error) {
+	if k, ok := strings.CutPrefix(s, "env:"); ok {
+		v, ok := os.LookupEnv(k)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", k)
+		}
+		return v, nil
+	}
+	if fn, ok := strings.CutPrefix(s, "file:"); ok {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes.TrimSpace(b)), nil
+	}
+	return s, nil
+}
+
+// parseSince parses Loop.Since: a duration is returned as the SearchWindow.MaxAge,
+// to be relative to the time of each List, a date as the SearchWindow.Since.
+func parseSince(s string) (time.Time, time.Duration, error) {
+	if s == "" {
+		return time.Time{}, 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, 0, fmt.Errorf("since %q: the duration must be positive", s)
+		}
+		return time.Time{}, d, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, 0, fmt.Errorf("since %q is neither a duration nor a date: %w", s, err)
+	}
+	return t, 0, nil
+}
+
+func nvl(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
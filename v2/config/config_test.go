@@ -0,0 +1,96 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConsumerAccount(t *testing.T) {
+	ctx := context.Background()
+	for i, tc := range []struct {
+		OAuth OAuth
+		OK    bool
+	}{
+		{OAuth{ClientID: "id", ClientSecret: "secret", Consumer: true}, true},
+		{OAuth{ClientID: "id", ClientSecret: "secret", Consumer: true, Impersonate: "me"}, true},
+		{OAuth{ClientID: "id", ClientSecret: "secret", Consumer: true, Impersonate: "a@b.c"}, false},
+		{OAuth{ClientID: "id", ClientSecret: "secret", Consumer: true, Cloud: "usgovhigh"}, false},
+		{OAuth{ClientID: "id", ClientSecret: "secret", Consumer: true, TenantID: "contoso.onmicrosoft.com"}, false},
+		{OAuth{ClientID: "id", Consumer: true}, false},
+	} {
+		_, err := AccountConfig{Name: "a", Type: "o365", OAuth: tc.OAuth}.Account(ctx)
+		if (err == nil) != tc.OK {
+			t.Errorf("%d. got %+v, wanted ok=%t", i, err, tc.OK)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	for fn, content := range map[string]string{
+		"a.toml": "[[accounts]]\nname = \"a\"\n[accounts.loop]\nsince = \"720h\"\n",
+		"a.yaml": "accounts:\n  - name: a\n    loop:\n      since: 720h\n",
+		"a.json": `{"accounts":[{"name":"a","loop":{"since":"720h"}}]}`,
+	} {
+		fn = filepath.Join(dir, fn)
+		if err := os.WriteFile(fn, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(fn)
+		if err != nil {
+			t.Fatalf("%s: %+v", fn, err)
+		}
+		if len(cfg.Accounts) != 1 || cfg.Accounts[0].Name != "a" || cfg.Accounts[0].Loop.Since != "720h" {
+			t.Errorf("%s: got %+v", fn, cfg.Accounts)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	for fn, content := range map[string]string{
+		"r.toml": "[[rules]]\nname = \"invoices\"\naction = \"move\"\nmailbox = \"Invoices\"\n",
+		"r.yaml": "rules:\n  - name: invoices\n    action: move\n    mailbox: Invoices\n",
+		"r.json": `{"rules":[{"name":"invoices","action":"move","mailbox":"Invoices"}]}`,
+	} {
+		fn = filepath.Join(dir, fn)
+		if err := os.WriteFile(fn, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		rules, err := LoadRules(fn)
+		if err != nil {
+			t.Fatalf("%s: %+v", fn, err)
+		}
+		if len(rules) != 1 || rules[0].Name != "invoices" || rules[0].Mailbox != "Invoices" {
+			t.Errorf("%s: got %+v", fn, rules)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	for s, tc := range map[string]struct {
+		Since  time.Time
+		MaxAge time.Duration
+		OK     bool
+	}{
+		"":           {OK: true},
+		"720h":       {MaxAge: 720 * time.Hour, OK: true},
+		"2024-02-01": {Since: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), OK: true},
+		"-1h":        {},
+		"yesterday":  {},
+	} {
+		since, maxAge, err := parseSince(s)
+		if (err == nil) != tc.OK {
+			t.Errorf("%q: got %+v, wanted ok=%t", s, err, tc.OK)
+		} else if !since.Equal(tc.Since) || maxAge != tc.MaxAge {
+			t.Errorf("%q: got %v, %v, wanted %v, %v", s, since, maxAge, tc.Since, tc.MaxAge)
+		}
+	}
+}
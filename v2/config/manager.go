@@ -0,0 +1,152 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// Manager runs the delivery of the accounts, isolated from each other:
+// Workers goroutines take the accounts in turn (round-robin), and each turn of an account
+// is one round of delivery, of at most Quota messages -
+// so the backlog of one (slow) account does not starve the others.
+//
+// An account failing to log in AuthFailures times in a row is disabled for Cooldown,
+// then retried once - so the server does not lock it out for the reconnect storm
+// (see imapclient.CircuitBreaker).
+type Manager struct {
+	Deliver imapclient.DeliverFunc
+	Logger  *slog.Logger
+	// Accounts are the accounts to be run, see Config.Open.
+	Accounts []*Account
+	// Workers is the number of the concurrent turns, len(Accounts) if 0.
+	Workers int
+	// Quota is the maximum number of messages delivered in one turn of an account, unlimited if 0.
+	Quota int
+	// AuthFailures is the number of the consecutive authentication failures
+	// which disables the account, DefaultAuthFailures if 0.
+	AuthFailures int
+	// Cooldown is the time an account is disabled for, DefaultCooldown if 0.
+	Cooldown time.Duration
+}
+
+var (
+	// DefaultAuthFailures is the default of Manager.AuthFailures.
+	DefaultAuthFailures = 3
+	// DefaultCooldown is the default of Manager.Cooldown.
+	DefaultCooldown = 15 * time.Minute
+)
+
+// managed is the state of an account in the Manager.
+type managed struct {
+	*Account
+	logger  *slog.Logger
+	breaker *imapclient.CircuitBreaker
+	control imapclient.LoopControl
+}
+
+// Run runs the accounts till ctx is done.
+func (m *Manager) Run(ctx context.Context) error {
+	if len(m.Accounts) == 0 {
+		return nil
+	}
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	workers := m.Workers
+	if workers <= 0 {
+		workers = len(m.Accounts)
+	}
+	threshold, cooldown := m.AuthFailures, m.Cooldown
+	if threshold <= 0 {
+		threshold = DefaultAuthFailures
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultCooldown
+	}
+	// Every account is either in ready, in a turn, or waiting for its timer, so sending never blocks.
+	ready := make(chan *managed, len(m.Accounts))
+	for _, a := range m.Accounts {
+		ma := &managed{Account: a, logger: logger.With("account", a.Name)}
+		ma.breaker = imapclient.NewCircuitBreaker(a.Client, threshold, cooldown)
+		ma.breaker.Trip = func(err error) bool { return errors.Is(err, imapclient.ErrAuth) }
+		ma.breaker.OnChange = func(ev imapclient.BreakerEvent) {
+			switch ev.To {
+			case imapclient.BreakerOpen:
+				ma.logger.Warn("account disabled", "authFailures", ev.Failures, "cooldown", cooldown, "error", ev.Err)
+			case imapclient.BreakerClosed:
+				ma.logger.Info("account enabled")
+			}
+		}
+		if a.Rules != nil && a.RulesFile != "" {
+			go a.watchRules(ctx, ma.logger)
+		}
+		ready <- ma
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var ma *managed
+				select {
+				case <-ctx.Done():
+					return
+				case ma = <-ready:
+				}
+				if d := m.turn(ctx, ma); d <= 0 {
+					ready <- ma
+				} else {
+					time.AfterFunc(d, func() { ready <- ma })
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// turn runs one round of the account, and returns the time till its next turn.
+func (m *Manager) turn(ctx context.Context, ma *managed) time.Duration {
+	var n int
+	deliver, opts := m.Deliver, ma.Options
+	if m.Quota > 0 {
+		ma.control.Resume()
+		opts = append(opts[:len(opts):len(opts)], imapclient.WithControl(&ma.control))
+		deliver = func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh imapclient.HashArray) error {
+			if n++; n >= m.Quota {
+				// the rest is left for the next turn
+				ma.control.Pause()
+			}
+			return m.Deliver(ctx, r, uid, hsh)
+		}
+	}
+	delivered, err := imapclient.DeliverOne(ctx, ma.breaker, ma.Inbox, ma.Pattern, deliver, ma.Outbox, ma.Errbox, ma.logger, opts...)
+	if err != nil {
+		ma.logger.Error("turn", "delivered", delivered, "error", err)
+		if until := ma.breaker.OpenUntil(); !until.IsZero() {
+			return time.Until(until)
+		}
+		return imapclient.LongSleep
+	}
+	switch {
+	case m.Quota > 0 && n >= m.Quota:
+		return 0 // to the end of the queue
+	case delivered == 0:
+		return imapclient.LongSleep
+	default:
+		return imapclient.ShortSleep
+	}
+}
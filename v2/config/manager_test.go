@@ -0,0 +1,82 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// queueClient serves its unseen messages; with a connectErr it cannot connect.
+type queueClient struct {
+	imapclient.Client
+	connectErr error
+	unseen     []uint32
+}
+
+func (c *queueClient) Connect(context.Context) error     { return c.connectErr }
+func (c *queueClient) Close(context.Context, bool) error { return nil }
+func (c *queueClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	return slices.Clone(c.unseen), nil
+}
+func (c *queueClient) ReadTo(ctx context.Context, w io.Writer, uid uint32) (int64, error) {
+	n, err := fmt.Fprintf(w, "Subject: %d\r\n\r\n", uid)
+	return int64(n), err
+}
+func (c *queueClient) Mark(ctx context.Context, uid uint32, seen bool) error {
+	c.unseen = slices.DeleteFunc(c.unseen, func(u uint32) bool { return u == uid })
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	oldShort, oldLong := imapclient.ShortSleep, imapclient.LongSleep
+	imapclient.ShortSleep, imapclient.LongSleep = time.Hour, time.Hour
+	t.Cleanup(func() { imapclient.ShortSleep, imapclient.LongSleep = oldShort, oldLong })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	m := Manager{
+		Accounts: []*Account{
+			{Name: "locked", Client: &queueClient{connectErr: fmt.Errorf("login: %w", imapclient.ErrAuth)}},
+			{Name: "a", Client: &queueClient{unseen: []uint32{1, 2, 3, 4, 5}}},
+			{Name: "b", Client: &queueClient{unseen: []uint32{101}}},
+		},
+		Workers: 1, Quota: 2, AuthFailures: 1, Cooldown: time.Hour,
+		Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+	var delivered []uint32
+	m.Deliver = func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh imapclient.HashArray) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if delivered = append(delivered, uid); len(delivered) == 6 {
+			cancel()
+		}
+		return nil
+	}
+	if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// a yields to b after its quota.
+	if want := []uint32{1, 2, 101, 3, 4, 5}; !slices.Equal(delivered, want) {
+		t.Errorf("got %v, wanted %v", delivered, want)
+	}
+	if !strings.Contains(buf.String(), `msg="account disabled" account=locked`) {
+		t.Errorf("locked is not disabled:\n%s", buf.String())
+	}
+}
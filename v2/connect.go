@@ -0,0 +1,311 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+// ConnectInfo describes the connection established by Connect, for diagnostics
+// and for capability-driven behaviour.
+type ConnectInfo struct {
+	// ConnectedAt is the time of the login, zero if the last Connect failed.
+	ConnectedAt time.Time
+	// Addr is the address (host:port) of the server.
+	Addr string
+	// Greeting is the first line sent by the server, without the line ending,
+	// such as "* OK [CAPABILITY IMAP4rev1 ...] Dovecot ready."
+	Greeting string
+	// AuthMechanism is the login method which succeeded: login, oauthbearer, xoauth2, cram-md5 or plain;
+	// preauth if the server authenticated the connection in its greeting.
+	AuthMechanism string
+	// Capabilities are the capabilities advertised after login, sorted.
+	Capabilities []string
+	// TLS reports whether the connection is encrypted - implicit TLS or STARTTLS.
+	TLS bool
+	// Compressed reports whether COMPRESS=DEFLATE is active - it is negotiated
+	// only if enabled with SetCompress (see CompressSetter).
+	Compressed bool
+	// Server is the name of the QuirkEntry recognizing the server, such as "Dovecot".
+	Server string
+	// Quirks are the workarounds enabled for the server.
+	Quirks ServerQuirk
+}
+
+// Has reports whether the server advertised the capability - case insensitively, as the capabilities are atoms.
+func (ci ConnectInfo) Has(capability string) bool {
+	return slices.ContainsFunc(ci.Capabilities, func(s string) bool { return strings.EqualFold(s, capability) })
+}
+
+// ConnectInfoReporter is implemented by the Clients which can describe their connection.
+type ConnectInfoReporter interface {
+	// ConnectInfo returns the information of the last Connect.
+	ConnectInfo() ConnectInfo
+}
+
+var _ ConnectInfoReporter = (*imapClient)(nil)
+
+// ConnectInfo returns the information of the last Connect.
+func (c *imapClient) ConnectInfo() ConnectInfo {
+	ci := c.info
+	ci.Capabilities = slices.Clone(ci.Capabilities)
+	return ci
+}
+
+// updateCapabilities refreshes the capabilities of the ConnectInfo,
+// as the servers may advertise more after login.
+func (c *imapClient) updateCapabilities() error {
+	if c.cachedCapabilities() {
+		return nil
+	}
+	start := time.Now()
+	caps, err := c.c.Capability()
+	c.CountCommand(start, err)
+	if err != nil {
+		return err
+	}
+	c.info.Capabilities = c.info.Capabilities[:0]
+	for k, ok := range caps {
+		if ok {
+			c.info.Capabilities = append(c.info.Capabilities, k)
+		}
+	}
+	slices.Sort(c.info.Capabilities)
+	c.info.Server, c.info.Quirks = lookupQuirks(c.info)
+	if c.cacheTTL > 0 {
+		c.caps = slices.Clone(c.info.Capabilities)
+	}
+	return nil
+}
+
+// greetingConn records the first line read from the connection: the server greeting.
+type greetingConn struct {
+	net.Conn
+	greeting []byte
+	done     bool
+}
+
+func (gc *greetingConn) Read(p []byte) (int, error) {
+	n, err := gc.Conn.Read(p)
+	if !gc.done && n > 0 {
+		b := p[:n]
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b, gc.done = b[:i+1], true
+		}
+		gc.greeting = append(gc.greeting, b...)
+	}
+	return n, err
+}
+
+// Greeting returns the recorded greeting, without the line ending.
+func (gc *greetingConn) Greeting() string {
+	return string(bytes.TrimRight(gc.greeting, "\r\n"))
+}
+
+// DialFunc connects to the server, such as net.Dialer.DialContext,
+// or the Dial of an SSH tunnel or of a unix socket.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ErrConnUsed is returned by the Connects after the first of a Client created by NewClientConn.
+var ErrConnUsed = errors.New("connection already used")
+
+// FromDialer returns a new (not connected) Client, which connects to the server
+// with dial instead of dialing host:port.
+//
+// Unless TLSPolicy is NoTLS (or MaybeTLS with port 143), the connection returned
+// by dial is wrapped in TLS, verified against sa.Host.
+func FromDialer(sa ServerAddress, dial DialFunc) Client {
+	return &imapClient{ServerAddress: sa, logger: slog.Default(), dial: dial}
+}
+
+// NewClientConn returns a new (not connected) Client over the given connection,
+// without TLS: the first Connect uses conn, the next ones return ErrConnUsed.
+//
+// Use RWConn to convert an io.ReadWriteCloser (such as an in-memory pipe) to net.Conn.
+func NewClientConn(conn net.Conn, username, password string) Client {
+	var once sync.Once
+	return FromDialer(
+		ServerAddress{Host: "localhost", Username: username, password: password, TLSPolicy: NoTLS},
+		func(context.Context, string, string) (net.Conn, error) {
+			err := ErrConnUsed
+			once.Do(func() { err = nil })
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		})
+}
+
+// RWConn converts the io.ReadWriteCloser to a net.Conn: the deadlines are ignored.
+func RWConn(rwc io.ReadWriteCloser) net.Conn {
+	if conn, ok := rwc.(net.Conn); ok {
+		return conn
+	}
+	return rwConn{ReadWriteCloser: rwc}
+}
+
+type rwConn struct {
+	io.ReadWriteCloser
+}
+
+func (rwConn) LocalAddr() net.Addr              { return pipeAddr{} }
+func (rwConn) RemoteAddr() net.Addr             { return pipeAddr{} }
+func (rwConn) SetDeadline(time.Time) error      { return nil }
+func (rwConn) SetReadDeadline(time.Time) error  { return nil }
+func (rwConn) SetWriteDeadline(time.Time) error { return nil }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// dialConn connects to addr - with the DialFunc, if given - and wraps it in TLS unless noTLS.
+func (c *imapClient) dialConn(ctx context.Context, addr string, noTLS bool) (net.Conn, error) {
+	dial := c.dial
+	if dial == nil {
+		if !noTLS {
+			d := tls.Dialer{Config: &TLSConfig}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil || noTLS {
+		return conn, err
+	}
+	cfg := TLSConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = c.Host
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// startTLS upgrades the plain conn with STARTTLS, if the server offers it, before go-imap
+// reads the greeting: so the layers above (the literal8 conversion and the guard of the
+// responses) read the decrypted responses. The returned conn replays the greeting -
+// after STARTTLS without its CAPABILITY response code, as the capabilities change with
+// the encryption, else with the capabilities asked for - and started reports whether
+// the upgrade has been done; greeting is the line sent by the server.
+//
+// Without STARTTLS (also for PREAUTH), and when the server refuses it (with an error), the
+// returned conn is the plain one. After a failed handshake or an invalid response it is nil.
+func (c *imapClient) startTLS(ctx context.Context, conn net.Conn) (_ net.Conn, greeting string, started bool, _ error) {
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+		defer conn.SetDeadline(time.Time{})
+	}
+	newParser := func(r io.Reader) *imapparse.Parser {
+		p := imapparse.NewParser(r)
+		p.Limits, p.Mode = c.limits, c.parseMode
+		return p
+	}
+	br := bufio.NewReader(conn)
+	line, err := br.ReadSlice('\n')
+	line = bytes.Clone(line)
+	greeting = string(bytes.TrimRight(line, "\r\n"))
+	replay := func(greet string) net.Conn {
+		return &replayConn{Conn: conn, r: io.MultiReader(strings.NewReader(greet), br)}
+	}
+	if err != nil {
+		// Let go-imap report it.
+		return replay(string(line)), greeting, false, nil
+	}
+	greet, err := newParser(bytes.NewReader(line)).ReadResponse()
+	if err != nil || greet.Status != "OK" {
+		return replay(string(line)), greeting, false, nil
+	}
+	p := newParser(br)
+	p.Warn = func(err error) { c.logger.Warn("server response", "error", err) }
+	// command sends the command, and returns its status response.
+	command := func(tag, cmd string, untagged func(imapparse.Response)) (imapparse.Response, error) {
+		if _, err := io.WriteString(conn, tag+" "+cmd+"\r\n"); err != nil {
+			return imapparse.Response{}, err
+		}
+		for {
+			resp, err := p.ReadResponse()
+			if err != nil {
+				if errors.Is(err, imapparse.ErrSyntax) || errors.Is(err, imapparse.ErrTooLarge) {
+					err = fmt.Errorf("invalid server response: %w", err)
+				}
+				return resp, err
+			}
+			if resp.Tag == tag {
+				return resp, nil
+			}
+			if untagged != nil && resp.Tag == "*" {
+				untagged(resp)
+			}
+		}
+	}
+	isCapability := func(vv []imapparse.Value) bool {
+		return len(vv) != 0 && vv[0].Kind == imapparse.Atom && strings.EqualFold(vv[0].Text, "CAPABILITY")
+	}
+	if !isCapability(greet.Code) {
+		var caps []imapparse.Value
+		if _, err := command("T0", "CAPABILITY", func(resp imapparse.Response) {
+			if isCapability(resp.Fields) {
+				caps = resp.Fields
+			}
+		}); err != nil {
+			return nil, greeting, false, err
+		}
+		if caps != nil {
+			// go-imap would ask for them again.
+			greet.Code = caps
+			line = []byte(greet.String())
+		}
+	}
+	if !slices.ContainsFunc(greet.Code, func(v imapparse.Value) bool { return strings.EqualFold(v.Text, "STARTTLS") }) {
+		return replay(string(line)), greeting, false, nil
+	}
+	c.logger.Info("Starting TLS")
+	if resp, err := command("T1", "STARTTLS", nil); err != nil {
+		return nil, greeting, false, err
+	} else if resp.Status != "OK" {
+		return replay(string(line)), greeting, false, fmt.Errorf("%s %s", resp.Status, resp.Text)
+	}
+	// The bytes sent before the handshake would be read as if they were encrypted (CVE-2011-0411).
+	if n := br.Buffered(); n != 0 {
+		return nil, greeting, false, fmt.Errorf("STARTTLS: %d bytes after the response", n)
+	}
+	cfg := TLSConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = c.Host
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, greeting, false, fmt.Errorf("STARTTLS: %w", err)
+	}
+	greet.Code = nil
+	return &replayConn{Conn: tc, r: io.MultiReader(strings.NewReader(greet.String()), tc)}, greeting, true, nil
+}
+
+// replayConn reads from r: what has already been read from the Conn, then the Conn.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (rc *replayConn) Read(p []byte) (int, error) { return rc.r.Read(p) }
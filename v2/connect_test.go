@@ -0,0 +1,516 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+// pipeListener is a net.Listener serving the given connections.
+type pipeListener chan net.Conn
+
+func (l pipeListener) Accept() (net.Conn, error) {
+	conn, ok := <-l
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+func (l pipeListener) Close() error   { return nil }
+func (l pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+func TestClientConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	l := make(pipeListener, 1)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	cConn, sConn := net.Pipe()
+	l <- sConn
+	close(l)
+
+	c := NewClientConn(cConn, "username", "password")
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	ci := c.(ConnectInfoReporter).ConnectInfo()
+	t.Logf("ConnectInfo: %+v", ci)
+	if !strings.HasPrefix(ci.Greeting, "* OK") {
+		t.Errorf("greeting: got %q", ci.Greeting)
+	}
+	if !ci.Has("IMAP4rev1") {
+		t.Errorf("capabilities: got %q", ci.Capabilities)
+	}
+
+	uids, err := c.List(ctx, "INBOX", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 1 {
+		t.Fatalf("List: got %v, wanted one message", uids)
+	}
+	var buf strings.Builder
+	if _, err := c.ReadTo(ctx, &buf, uids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Subject: A little message, just for you") {
+		t.Errorf("ReadTo: got %q", buf.String())
+	}
+	if found, err := FindByMessageID(ctx, c, "", "0000000@localhost/"); err != nil {
+		t.Fatal(err)
+	} else if len(found) != 1 || found[0] != uids[0] {
+		t.Errorf("FindByMessageID: got %v, wanted %v", found, uids)
+	}
+
+	var searched []uint32
+	if err := SearchFunc(ctx, c, "INBOX", "", true, func(uid uint32) error {
+		searched = append(searched, uid)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if len(searched) != len(uids) || searched[0] != uids[0] {
+		t.Errorf("SearchFunc: got %v, wanted %v", searched, uids)
+	}
+
+	if err := c.Connect(ctx); !errors.Is(err, ErrConnUsed) {
+		t.Errorf("second Connect: got %v, wanted ErrConnUsed", err)
+	}
+}
+
+func TestClientConnHostile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	defer sConn.Close()
+	go func() {
+		sConn.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+		buf := make([]byte, 1024)
+		n, _ := sConn.Read(buf)
+		tag, _, _ := strings.Cut(string(buf[:n]), " ")
+		go io.Copy(io.Discard, sConn)
+		sConn.Write([]byte("* CAPABILITY IMAP4rev1 {999999999999}\r\n" + tag + " OK done\r\n"))
+	}()
+
+	c := NewClientConn(cConn, "username", "password")
+	c.(ResponseLimitsSetter).SetResponseLimits(imapparse.Limits{MaxLiteral: 1 << 20})
+	err := c.Connect(ctx)
+	if err == nil {
+		c.Close(ctx, false)
+		t.Fatal("Connect succeeded with a hostile response")
+	}
+	t.Log(err)
+	if !errors.Is(err, imapparse.ErrTooLarge) {
+		t.Errorf("got %v, wanted ErrTooLarge", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Connect hanged")
+	}
+}
+
+func TestClientConnStrict(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	defer sConn.Close()
+	go func() {
+		sConn.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+		buf := make([]byte, 1024)
+		n, _ := sConn.Read(buf)
+		tag, _, _ := strings.Cut(string(buf[:n]), " ")
+		go io.Copy(io.Discard, sConn)
+		sConn.Write([]byte("* CAPABILITY  IMAP4rev1 \r\n" + tag + " OK done\r\n"))
+	}()
+
+	c := NewClientConn(cConn, "username", "password")
+	c.(ParseModeSetter).SetParseMode(imapparse.Strict)
+	err := c.Connect(ctx)
+	if err == nil {
+		c.Close(ctx, false)
+		t.Fatal("Connect succeeded with a malformed response")
+	}
+	t.Log(err)
+	if !errors.Is(err, imapparse.ErrSyntax) {
+		t.Errorf("got %v, wanted ErrSyntax", err)
+	}
+}
+
+// scriptServer plays the server: greets, then answers the commands with the responses
+// of their names (such as "UID FETCH"), TAG replaced by their tag - or with OK.
+// An empty response closes the connection.
+// The IDLE is finished with OK on DONE.
+// STARTTLS is started with cfg, if not nil; COMPRESS with deflateConn.
+func scriptServer(conn net.Conn, cfg *tls.Config, greeting string, responses map[string]string) {
+	go func() {
+		defer func() { conn.Close() }()
+		conn.Write([]byte(greeting))
+		br := bufio.NewReader(conn)
+		var idleTag string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.TrimSpace(line) == "DONE" && idleTag != "" {
+				conn.Write([]byte(idleTag + " OK IDLE terminated\r\n"))
+				idleTag = ""
+				continue
+			}
+			f := strings.Fields(line)
+			if len(f) < 2 {
+				continue
+			}
+			name := strings.ToUpper(f[1])
+			if name == "UID" && len(f) > 2 {
+				name += " " + strings.ToUpper(f[2])
+			}
+			if name == "STARTTLS" && cfg != nil {
+				conn.Write([]byte(f[0] + " OK begin TLS\r\n"))
+				conn = tls.Server(conn, cfg)
+				br = bufio.NewReader(conn)
+				continue
+			}
+			if name == "COMPRESS" {
+				conn.Write([]byte(f[0] + " OK DEFLATE active\r\n"))
+				dc := &deflateConn{Conn: conn}
+				dc.start()
+				conn = dc
+				br = bufio.NewReader(conn)
+				continue
+			}
+			resp, ok := responses[name]
+			if !ok {
+				resp = "TAG OK done\r\n"
+			} else if resp == "" {
+				return
+			}
+			conn.Write([]byte(strings.ReplaceAll(resp, "TAG", f[0])))
+			if name == "IDLE" {
+				idleTag = f[0]
+			}
+			if name == "LOGOUT" {
+				return
+			}
+		}
+	}()
+}
+
+func TestLoginError(t *testing.T) {
+	for name, tc := range map[string]struct {
+		Login string
+		Auth  bool
+	}{
+		"refused": {"TAG NO [AUTHENTICATIONFAILED] invalid credentials\r\n", true},
+		"closed":  {"", false},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		cConn, sConn := net.Pipe()
+		scriptServer(sConn, nil, "* OK [CAPABILITY IMAP4rev1] ready\r\n", map[string]string{"LOGIN": tc.Login})
+		c := NewClientConn(cConn, "username", "password")
+		err := c.Connect(ctx)
+		cancel()
+		if err == nil || errors.Is(err, ErrAuth) != tc.Auth {
+			t.Errorf("%s: got %+v, wanted ErrAuth=%t", name, err, tc.Auth)
+		}
+	}
+}
+
+func TestClientConnLenient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	scriptServer(sConn, nil, "* OK [CAPABILITY IMAP4rev1] ready\r\n", map[string]string{
+		"SELECT": "* 1 EXISTS\r\n* OK [UIDVALIDITY 1] UIDs valid\r\nTAG OK [READ-WRITE] done\r\n",
+		// missing space, the date as an atom, an 8-bit subject, () instead of NIL,
+		// a short and an invalid address, and only 9 fields.
+		"UID FETCH": "* 1 FETCH (UID 1 ENVELOPE(Today \"Hello \xe1rv\xedz\" () " +
+			"((NIL NIL \"a\") x (\"B\" NIL \"b\" \"example.com\")) NIL NIL NIL NIL NIL))\r\nTAG OK done\r\n",
+	})
+	var warnings int
+	c := NewClientConn(cConn, "username", "password")
+	c.SetLogger(slog.New(slog.NewTextHandler(warnCounter{&warnings}, nil)))
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	if err := c.Select(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.FetchArgs(ctx, "ENVELOPE", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(m)
+	if got := m[1]["ENVELOPE.SUBJECT"]; len(got) != 1 || got[0] != "Hello \xe1rv\xedz" {
+		t.Errorf("subject: got %q", got)
+	}
+	// The address without a host is read by go-imap as the start of a group.
+	if got := m[1]["ENVELOPE.SENDER"]; len(got) != 1 || got[0] != "B <b@example.com>" {
+		t.Errorf("sender: got %q", got)
+	}
+	if warnings == 0 {
+		t.Error("no warnings")
+	}
+}
+
+type warnCounter struct{ n *int }
+
+func (w warnCounter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "level=WARN") {
+		*w.n++
+	}
+	return len(p), nil
+}
+
+// tcpPipe returns the ends of a loopback TCP connection:
+// unlike net.Pipe, the writes are buffered, so the close_notify of TLS does not wait for the reader.
+func tcpPipe(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	if client, err = net.Dial("tcp", l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if server = <-accepted; server == nil {
+		t.Fatal("accept failed")
+	}
+	return client, server
+}
+
+// serverTLSConfig returns the config of a server with a self-signed certificate.
+func serverTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestClientConnStartTLS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv := server.New(memory.New())
+	srv.TLSConfig = serverTLSConfig(t)
+	l := make(pipeListener, 1)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	cConn, sConn := tcpPipe(t)
+	l <- sConn
+	close(l)
+
+	c := NewClientConn(cConn, "username", "password")
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	ci := c.(ConnectInfoReporter).ConnectInfo()
+	t.Logf("ConnectInfo: %+v", ci)
+	if !ci.TLS {
+		t.Error("not TLS")
+	}
+	if !strings.Contains(ci.Greeting, "STARTTLS") {
+		t.Errorf("greeting: got %q", ci.Greeting)
+	}
+	if ci.Has("STARTTLS") || ci.Has("LOGINDISABLED") {
+		t.Errorf("capabilities of before STARTTLS: %q", ci.Capabilities)
+	}
+	uids, err := c.List(ctx, "INBOX", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if _, err := c.ReadTo(ctx, &buf, uids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Subject: A little message, just for you") {
+		t.Errorf("ReadTo: got %q", buf.String())
+	}
+}
+
+// scriptStartTLS plays the server: offers and starts TLS, sending afterOK after the OK,
+// then answers the first command with the response.
+func scriptStartTLS(t *testing.T, conn net.Conn, afterOK, response string) {
+	t.Helper()
+	cfg := serverTLSConfig(t)
+	go func() {
+		defer conn.Close()
+		conn.Write([]byte("* OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED] ready\r\n"))
+		br := bufio.NewReader(conn)
+		if line, err := br.ReadString('\n'); err != nil || line != "T1 STARTTLS\r\n" {
+			return
+		}
+		conn.Write([]byte("T1 OK begin TLS\r\n" + afterOK))
+		tc := tls.Server(conn, cfg)
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		line, err := bufio.NewReader(tc).ReadString('\n')
+		if err != nil {
+			return
+		}
+		go io.Copy(io.Discard, tc)
+		tag, _, _ := strings.Cut(line, " ")
+		tc.Write([]byte(strings.ReplaceAll(response, "TAG", tag)))
+	}()
+}
+
+// scriptClient returns a Client of sa, connecting to the scriptServer with greeting and responses.
+func scriptClient(sa ServerAddress, greeting string, responses map[string]string) Client {
+	cConn, sConn := net.Pipe()
+	scriptServer(sConn, nil, greeting, responses)
+	sa.Host, sa.Username, sa.password = "localhost", "username", "password"
+	return FromDialer(sa, func(context.Context, string, string) (net.Conn, error) { return cConn, nil })
+}
+
+func TestClientConnPreAuth(t *testing.T) {
+	const greeting = "* PREAUTH [CAPABILITY IMAP4rev1] logged in\r\n"
+	for name, tc := range map[string]struct {
+		Err error
+		SA  ServerAddress
+	}{
+		"preauth": {SA: ServerAddress{TLSPolicy: NoTLS}},
+		"reject":  {SA: ServerAddress{TLSPolicy: NoTLS, RejectPreAuth: true}, Err: ErrPreAuth},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		// LOGIN closes the connection
+		c := scriptClient(tc.SA, greeting, map[string]string{"LOGIN": "", "AUTHENTICATE": ""})
+		err := c.Connect(ctx)
+		cancel()
+		if tc.Err != nil {
+			if !errors.Is(err, tc.Err) {
+				t.Errorf("%s: got %+v, wanted %v", name, err, tc.Err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", name, err)
+			continue
+		}
+		if ci := c.(ConnectInfoReporter).ConnectInfo(); ci.AuthMechanism != "preauth" || ci.TLS {
+			t.Errorf("%s: got %+v", name, ci)
+		}
+		c.Close(context.Background(), false)
+	}
+}
+
+func TestClientConnPlaintextAuth(t *testing.T) {
+	const greeting = "* OK [CAPABILITY IMAP4rev1] ready\r\n"
+	for name, tc := range map[string]struct {
+		Err error
+		SA  ServerAddress
+	}{
+		"maybe":   {SA: ServerAddress{TLSPolicy: MaybeTLS, Port: 143}, Err: ErrPlaintextAuth},
+		"allowed": {SA: ServerAddress{TLSPolicy: MaybeTLS, Port: 143, AllowPlaintextAuth: true}},
+		"noTLS":   {SA: ServerAddress{TLSPolicy: NoTLS}},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		var logins int
+		c := scriptClient(tc.SA, greeting, nil)
+		c.SetLogger(slog.New(slog.NewTextHandler(loginCounter{&logins}, nil)))
+		err := c.Connect(ctx)
+		cancel()
+		if tc.Err != nil {
+			if !errors.Is(err, tc.Err) || logins != 0 {
+				t.Errorf("%s: got %+v after %d logins, wanted %v", name, err, logins, tc.Err)
+			}
+			continue
+		}
+		if err != nil || logins == 0 {
+			t.Errorf("%s: got %+v after %d logins", name, err, logins)
+			continue
+		}
+		c.Close(context.Background(), false)
+	}
+}
+
+type loginCounter struct{ n *int }
+
+func (w loginCounter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "try logging in") {
+		*w.n++
+	}
+	return len(p), nil
+}
+
+func TestClientConnStartTLSStrict(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	scriptStartTLS(t, sConn, "", "* CAPABILITY  IMAP4rev1 \r\nTAG OK done\r\n")
+	c := NewClientConn(cConn, "username", "password")
+	c.(ParseModeSetter).SetParseMode(imapparse.Strict)
+	err := c.Connect(ctx)
+	if err == nil {
+		c.Close(ctx, false)
+		t.Fatal("Connect succeeded with a malformed response")
+	}
+	t.Log(err)
+	if !errors.Is(err, imapparse.ErrSyntax) {
+		t.Errorf("got %v, wanted ErrSyntax: the responses are not checked after STARTTLS", err)
+	}
+}
+
+func TestClientConnStartTLSInjection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	scriptStartTLS(t, sConn, "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\n", "")
+	c := NewClientConn(cConn, "username", "password")
+	err := c.Connect(ctx)
+	if err == nil {
+		c.Close(ctx, false)
+		t.Fatal("Connect succeeded with a response injected before the TLS handshake")
+	}
+	t.Log(err)
+	if ctx.Err() != nil {
+		t.Fatal("Connect hanged")
+	}
+}
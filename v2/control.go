@@ -0,0 +1,135 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"sync"
+)
+
+// LoopControl pauses, resumes and drains a running DeliveryLoop (see WithControl),
+// for orderly deployments and maintenance windows. The zero value is ready to use,
+// and controls one loop.
+type LoopControl struct {
+	wake chan struct{}
+	// drains wait for the next round, running for the current one.
+	drains, running []chan error
+	mu              sync.Mutex
+	paused          bool
+}
+
+// WithControl makes the loop controllable by lc.
+func WithControl(lc *LoopControl) LoopOption {
+	return func(o *loopOptions) { o.control = lc }
+}
+
+// Pause stops picking up new messages: the message being delivered is finished,
+// the rest is left for after Resume.
+func (lc *LoopControl) Pause() {
+	lc.mu.Lock()
+	lc.paused = true
+	lc.mu.Unlock()
+}
+
+// Resume continues the paused loop, at once.
+func (lc *LoopControl) Resume() {
+	lc.mu.Lock()
+	lc.paused = false
+	lc.mu.Unlock()
+	lc.wakeUp()
+}
+
+// Paused reports whether the loop has been paused.
+func (lc *LoopControl) Paused() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.paused
+}
+
+// Drain starts a round at once (even if the loop is paused - it stays paused after),
+// to process the current backlog, and returns the error of that round, after it is finished.
+//
+// Drain waits till ctx is done if the loop is not running.
+func (lc *LoopControl) Drain(ctx context.Context) error {
+	ch := make(chan error, 1)
+	lc.mu.Lock()
+	lc.drains = append(lc.drains, ch)
+	lc.mu.Unlock()
+	lc.wakeUp()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (lc *LoopControl) wakeUp() {
+	select {
+	case lc.woken() <- struct{}{}:
+	default:
+	}
+}
+
+// woken returns the channel signaled by Resume and Drain.
+func (lc *LoopControl) woken() chan struct{} {
+	if lc == nil {
+		return nil
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.wake == nil {
+		lc.wake = make(chan struct{}, 1)
+	}
+	return lc.wake
+}
+
+// begin waits for the start of the next round: till the loop is not paused, or a Drain is requested.
+// Returns false if ctx is done.
+func (lc *LoopControl) begin(ctx context.Context) bool {
+	if lc == nil {
+		return true
+	}
+	for {
+		lc.mu.Lock()
+		if len(lc.drains) != 0 {
+			lc.running, lc.drains = append(lc.running, lc.drains...), nil
+		}
+		ok := !lc.paused || len(lc.running) != 0
+		lc.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-lc.woken():
+		}
+	}
+}
+
+// stopped reports whether the round should stop picking up messages.
+func (lc *LoopControl) stopped() bool {
+	if lc == nil {
+		return false
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.paused && len(lc.running) == 0
+}
+
+// end finishes the round, returning err to the Drains waiting for it.
+func (lc *LoopControl) end(err error) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	running := lc.running
+	lc.running = nil
+	lc.mu.Unlock()
+	for _, ch := range running {
+		ch <- err
+	}
+}
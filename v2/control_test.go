@@ -0,0 +1,79 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoopControl(t *testing.T) {
+	oldShort, oldLong := ShortSleep, LongSleep
+	ShortSleep, LongSleep = time.Hour, time.Hour
+	t.Cleanup(func() { ShortSleep, LongSleep = oldShort, oldLong })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	mb := newFakeMailbox(1, 2)
+	var lc LoopControl
+	lc.Pause()
+
+	var mu sync.Mutex
+	var delivered []uint32
+	pausedAt3 := make(chan struct{})
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		mu.Lock()
+		delivered = append(delivered, uid)
+		mu.Unlock()
+		if uid == 3 {
+			lc.Pause()
+			close(pausedAt3)
+		}
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- DeliveryLoop(ctx, &fakeClient{mb: mb}, "INBOX", "", deliver, "", "", logger, WithControl(&lc))
+	}()
+
+	// Drain processes the backlog of the paused loop.
+	if err := lc.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !lc.Paused() {
+		t.Error("not paused after Drain")
+	}
+
+	mb.mu.Lock()
+	mb.flags[3], mb.flags[4] = nil, nil
+	mb.mu.Unlock()
+	lc.Resume()
+	<-pausedAt3
+	// 4 is left by the paused round, for the Drain.
+	if err := lc.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(delivered, []uint32{1, 2, 3, 4}) {
+		t.Errorf("got %v", delivered)
+	}
+	if !strings.Contains(buf.String(), "msg=paused") {
+		t.Errorf("round was not paused:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Decrypter decrypts the encrypted messages - see the decrypt subpackage for S/MIME and OpenPGP.
+type Decrypter interface {
+	// Decrypt returns the decrypted message, with the header of the original message,
+	// or ErrNotEncrypted if the message is not encrypted with a method it understands.
+	Decrypt(ctx context.Context, msg []byte) ([]byte, error)
+}
+
+// DecryptedHeader is added to the decrypted messages, with the method (smime, pgp) as value.
+const DecryptedHeader = "X-Imapclient-Decrypted"
+
+var (
+	// ErrNotEncrypted is returned by Decrypter if the message is not encrypted.
+	ErrNotEncrypted = errors.New("not encrypted")
+	// ErrDecrypt is returned for the encrypted messages none of the Decrypters could decrypt.
+	ErrDecrypt = errors.New("decrypt")
+)
+
+// WithDecrypters decrypts the encrypted messages before delivering them,
+// trying the Decrypters in order. The messages which cannot be decrypted are rejected,
+// the not encrypted ones are delivered as is.
+func WithDecrypters(ds ...Decrypter) LoopOption {
+	return func(o *loopOptions) { o.decrypters = append(o.decrypters, ds...) }
+}
+
+// decrypted delivers the messages decrypted by the Decrypters.
+func (deliver readDeliverer) decrypted(ds []Decrypter) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		readErr, err := deliver(ctx, decrypting{Client: c, decrypters: ds}, uid, hsh)
+		if readErr != nil && errors.Is(readErr, ErrDecrypt) {
+			return nil, readErr
+		}
+		return readErr, err
+	}
+}
+
+// decrypting is a Client whose ReadTo returns the decrypted message.
+type decrypting struct {
+	Client
+	decrypters []Decrypter
+}
+
+func (d decrypting) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64, error) {
+	var buf bytes.Buffer
+	if _, err := d.Client.ReadTo(ctx, &buf, msgID); err != nil {
+		return 0, err
+	}
+	msg := buf.Bytes()
+	var errs []error
+	for _, dec := range d.decrypters {
+		b, err := dec.Decrypt(ctx, msg)
+		if err == nil {
+			msg = b
+			errs = nil
+			break
+		}
+		if !errors.Is(err, ErrNotEncrypted) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return 0, fmt.Errorf("%d: %w: %w", msgID, ErrDecrypt, errors.Join(errs...))
+	}
+	n, err := w.Write(msg)
+	return int64(n), err
+}
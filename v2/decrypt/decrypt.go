@@ -0,0 +1,66 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package decrypt provides S/MIME and OpenPGP imapclient.Decrypters,
+// to deliver the encrypted inbound mail decrypted with imapclient.WithDecrypters.
+package decrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// parse the message, returning its media type, the params of its Content-Type and the decoded body.
+func parse(msg []byte) (string, map[string]string, []byte, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		return "", nil, nil, imapclient.ErrNotEncrypted
+	}
+	var r io.Reader = m.Body
+	if strings.EqualFold(strings.TrimSpace(m.Header.Get("Content-Transfer-Encoding")), "base64") {
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	body, err := io.ReadAll(r)
+	return strings.ToLower(mediaType), params, body, err
+}
+
+// replaceContent returns the message with its Content-* header fields replaced
+// by the decrypted MIME entity (header and body), and the DecryptedHeader added.
+func replaceContent(msg, entity []byte, method string) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(msg) + len(entity))
+	buf.WriteString(imapclient.DecryptedHeader + ": " + method + "\r\n")
+	skip := false
+	for len(msg) != 0 {
+		line := msg
+		if i := bytes.IndexByte(msg, '\n'); i >= 0 {
+			line = msg[:i+1]
+		}
+		msg = msg[len(line):]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 { // end of header
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' { // not a continuation
+			k, _, _ := bytes.Cut(line, []byte(":"))
+			k = bytes.ToLower(bytes.TrimSpace(k))
+			skip = bytes.HasPrefix(k, []byte("content-")) || bytes.Equal(k, []byte("mime-version"))
+		}
+		if !skip {
+			buf.Write(line)
+		}
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.Write(entity)
+	return buf.Bytes()
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package decrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/tgulacsi/imapclient/v2"
+	"go.mozilla.org/pkcs7"
+)
+
+const (
+	header = "From: a@example.com\r\nTo: b@example.com\r\nSubject: secret\r\nMIME-Version: 1.0\r\n"
+	inner  = "Content-Type: text/plain; charset=utf-8\r\n\r\nThe secret.\r\n"
+)
+
+func checkDecrypted(t *testing.T, d imapclient.Decrypter, msg []byte, method string) {
+	t.Helper()
+	ctx := context.Background()
+	got, err := d.Decrypt(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := imapclient.DecryptedHeader + ": " + method + "\r\n" +
+		"From: a@example.com\r\nTo: b@example.com\r\nSubject: secret\r\nMIME-Version: 1.0\r\n" + inner
+	if string(got) != want {
+		t.Errorf("got\n%q\nwanted\n%q", got, want)
+	}
+	if _, err = d.Decrypt(ctx, []byte(header+inner)); err != imapclient.ErrNotEncrypted {
+		t.Errorf("plain message: got %v, wanted ErrNotEncrypted", err)
+	}
+}
+
+func TestSMIME(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "b@example.com"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := pkcs7.Encrypt([]byte(inner), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := header + "Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString(enc) + "\r\n"
+	checkDecrypted(t, SMIME{Certificate: cert, Key: key}, []byte(msg), "smime")
+}
+
+func TestPGP(t *testing.T) {
+	e, err := openpgp.NewEntity("b", "", "b@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := openpgp.Encrypt(aw, openpgp.EntityList{e}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(inner)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	msg := header + `Content-Type: multipart/encrypted; protocol="application/pgp-encrypted"; boundary="b1"` + "\r\n\r\n" +
+		"--b1\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n\r\n" +
+		"--b1\r\nContent-Type: application/octet-stream\r\n\r\n" + strings.ReplaceAll(buf.String(), "\n", "\r\n") + "\r\n--b1--\r\n"
+	checkDecrypted(t, PGP{Keyring: openpgp.EntityList{e}}, []byte(msg), "pgp")
+}
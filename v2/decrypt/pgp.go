@@ -0,0 +1,109 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package decrypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+var _ imapclient.Decrypter = PGP{}
+
+// PGP decrypts the PGP/MIME (RFC 3156 multipart/encrypted) and the inline PGP (text/plain) messages.
+type PGP struct {
+	// Keyring holds the private keys of the recipients.
+	Keyring openpgp.KeyRing
+	// Passphrase decrypts the encrypted private keys.
+	Passphrase []byte
+}
+
+// LoadPGP loads the keyring (armored or binary) from the file.
+func LoadPGP(keyringFile string, passphrase []byte) (PGP, error) {
+	b, err := os.ReadFile(keyringFile)
+	if err != nil {
+		return PGP{}, err
+	}
+	var el openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN")) {
+		el, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	} else {
+		el, err = openpgp.ReadKeyRing(bytes.NewReader(b))
+	}
+	if err != nil {
+		return PGP{}, fmt.Errorf("read keyring %q: %w", keyringFile, err)
+	}
+	return PGP{Keyring: el, Passphrase: passphrase}, nil
+}
+
+var pgpBegin = []byte("-----BEGIN PGP MESSAGE-----")
+
+// Decrypt the PGP encrypted message. It implements imapclient.Decrypter.
+func (p PGP) Decrypt(ctx context.Context, msg []byte) ([]byte, error) {
+	mediaType, params, body, err := parse(msg)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case mediaType == "multipart/encrypted" && params["protocol"] == "application/pgp-encrypted":
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				return nil, fmt.Errorf("no encrypted part: %w", err)
+			}
+			if mt, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); mt != "application/octet-stream" {
+				continue
+			}
+			entity, err := p.decrypt(part)
+			if err != nil {
+				return nil, err
+			}
+			return replaceContent(msg, entity, "pgp"), nil
+		}
+
+	case mediaType == "text/plain" && bytes.Contains(body, pgpBegin):
+		plain, err := p.decrypt(bytes.NewReader(body[bytes.Index(body, pgpBegin):]))
+		if err != nil {
+			return nil, err
+		}
+		return replaceContent(msg, append([]byte("Content-Type: text/plain; charset=utf-8\r\n\r\n"), plain...), "pgp"), nil
+	}
+	return nil, imapclient.ErrNotEncrypted
+}
+
+// decrypt the armored PGP message.
+func (p PGP) decrypt(r io.Reader) ([]byte, error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("armor: %w", err)
+	}
+	var prompted bool
+	md, err := openpgp.ReadMessage(block.Body, p.Keyring, func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if prompted || symmetric || len(p.Passphrase) == 0 {
+			return nil, errors.New("no usable passphrase")
+		}
+		prompted = true
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				_ = k.PrivateKey.Decrypt(p.Passphrase)
+			}
+		}
+		return nil, nil
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("pgp: %w", err)
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
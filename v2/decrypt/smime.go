@@ -0,0 +1,64 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package decrypt
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/tgulacsi/imapclient/v2"
+	"go.mozilla.org/pkcs7"
+)
+
+var _ imapclient.Decrypter = SMIME{}
+
+// SMIME decrypts the S/MIME (application/pkcs7-mime; smime-type=enveloped-data) messages.
+type SMIME struct {
+	// Certificate of the recipient.
+	Certificate *x509.Certificate
+	// Key is the private key of the Certificate.
+	Key crypto.PrivateKey
+}
+
+// LoadSMIME loads the certificate and the private key from the PEM encoded files.
+func LoadSMIME(certFile, keyFile string) (SMIME, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return SMIME{}, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return SMIME{}, err
+	}
+	return SMIME{Certificate: cert, Key: pair.PrivateKey}, nil
+}
+
+// Decrypt the S/MIME enveloped message. It implements imapclient.Decrypter.
+func (s SMIME) Decrypt(ctx context.Context, msg []byte) ([]byte, error) {
+	mediaType, params, body, err := parse(msg)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+	default:
+		return nil, imapclient.ErrNotEncrypted
+	}
+	if t := params["smime-type"]; t != "" && t != "enveloped-data" {
+		return nil, imapclient.ErrNotEncrypted
+	}
+	p7, err := pkcs7.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("parse pkcs7: %w", err)
+	}
+	entity, err := p7.Decrypt(s.Certificate, s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt pkcs7: %w", err)
+	}
+	return replaceContent(msg, entity, "smime"), nil
+}
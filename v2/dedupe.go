@@ -0,0 +1,157 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// DedupeBodyBytes is the number of bytes of the body hashed by FindDuplicates when grouping by content.
+var DedupeBodyBytes int64 = 64 << 10
+
+// fetchBatchLen is the number of messages fetched at once by FetchArgs.
+const fetchBatchLen = 512
+
+// contentFields are the header fields used for the content hash.
+var contentFields = []string{"From", "To", "Cc", "Date", "Subject"}
+
+// Duplicates is a group of messages considered the same.
+type Duplicates struct {
+	// Key is the Message-ID, or the content hash of the messages.
+	Key string
+	// UIDs of the messages, in ascending order - the first is the one to be kept.
+	UIDs []uint32
+}
+
+// FindDuplicates scans the mailbox and groups the messages by their Message-ID,
+// or by their content if byContent is true (or the message has no Message-ID).
+//
+// The content hash is computed from the From, To, Cc, Date and Subject header fields,
+// the size, and the first DedupeBodyBytes of the body. The body is read only for the messages
+// whose header fields and size are the same - and only its first DedupeBodyBytes,
+// if the Client is a PartialPeeker.
+//
+// Only the groups with more than one message are returned.
+func FindDuplicates(ctx context.Context, c Client, mbox string, byContent bool) ([]Duplicates, error) {
+	uids, err := c.List(ctx, mbox, "", true)
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", mbox, err)
+	}
+	slices.Sort(uids)
+
+	groups := make(map[string][]uint32)
+	add := func(key string, uid uint32) { groups[key] = append(groups[key], uid) }
+	// headerGroups holds the messages with the same header fields and size, to be hashed with their body.
+	headerGroups := make(map[string][]uint32)
+	for len(uids) != 0 {
+		n := len(uids)
+		if n > fetchBatchLen {
+			n = fetchBatchLen
+		}
+		attrs, err := c.FetchArgs(ctx, "RFC822.HEADER RFC822.SIZE", uids[:n]...)
+		if err != nil {
+			return nil, fmt.Errorf("fetch headers: %w", err)
+		}
+		for _, uid := range uids[:n] {
+			var raw string
+			if a := attrs[uid]["RFC822.HEADER"]; len(a) != 0 {
+				raw = a[0]
+			}
+			hdr, _ := textproto.ReadHeader(bufio.NewReader(strings.NewReader(raw)))
+			if msgID := strings.TrimSpace(hdr.Get("Message-Id")); msgID != "" && !byContent {
+				add(msgID, uid)
+				continue
+			}
+			hsh := NewHash()
+			for _, k := range contentFields {
+				for _, v := range hdr.Values(k) {
+					io.WriteString(hsh, k+": "+strings.Join(strings.Fields(v), " ")+"\n")
+				}
+			}
+			if a := attrs[uid]["RFC822.SIZE"]; len(a) != 0 {
+				io.WriteString(hsh, "Size: "+a[0]+"\n")
+			}
+			k := hsh.Array().String()
+			headerGroups[k] = append(headerGroups[k], uid)
+		}
+		uids = uids[n:]
+	}
+
+	pp, partial := As[PartialPeeker](c)
+	for hk, hg := range headerGroups {
+		if len(hg) < 2 {
+			continue
+		}
+		for _, uid := range hg {
+			hsh := NewHash()
+			io.WriteString(hsh, hk)
+			var err error
+			if partial {
+				_, err = pp.PeekPartial(ctx, hsh, uid, "TEXT", 0, DedupeBodyBytes)
+			} else {
+				_, err = c.Peek(ctx, &limitedWriter{W: hsh, N: DedupeBodyBytes}, uid, "TEXT")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read body of %d: %w", uid, err)
+			}
+			add(hsh.Array().String(), uid)
+		}
+	}
+
+	var dups []Duplicates
+	for k, g := range groups {
+		if len(g) > 1 {
+			slices.Sort(g)
+			dups = append(dups, Duplicates{Key: k, UIDs: g})
+		}
+	}
+	slices.SortFunc(dups, func(a, b Duplicates) int { return cmp.Compare(a.UIDs[0], b.UIDs[0]) })
+	return dups, nil
+}
+
+// DeleteDuplicates deletes all but the first message of each group,
+// and returns the number of deleted messages.
+//
+// The messages are only marked as deleted on IMAP, they're expunged by Close(ctx, true).
+func DeleteDuplicates(ctx context.Context, c Client, dups []Duplicates) (int, error) {
+	var set SeqSet
+	for _, d := range dups {
+		set.AddNum(d.UIDs[1:]...)
+	}
+	if err := DeleteSet(ctx, c, set); err != nil {
+		return 0, fmt.Errorf("delete %s: %w", set, err)
+	}
+	return set.Len(), nil
+}
+
+// limitedWriter writes at most N bytes to W, and discards the rest.
+type limitedWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if lw.N <= 0 {
+		return n, nil
+	}
+	if int64(len(p)) > lw.N {
+		p = p[:lw.N]
+	}
+	k, err := lw.W.Write(p)
+	lw.N -= int64(k)
+	if err != nil {
+		return k, err
+	}
+	return n, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dedupeClient is a contentClient serving the headers and sizes, and counting the body reads.
+type dedupeClient struct {
+	*contentClient
+	peeked []uint32
+}
+
+func (c *dedupeClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		msg := c.boxes[c.selected][uid]
+		hdr, _, _ := strings.Cut(msg, "\r\n\r\n")
+		m[uid] = map[string][]string{
+			"RFC822.HEADER": {hdr + "\r\n\r\n"},
+			"RFC822.SIZE":   {strconv.Itoa(len(msg))},
+		}
+	}
+	return m, nil
+}
+func (c *dedupeClient) Peek(ctx context.Context, w io.Writer, msgID uint32, what string) (int64, error) {
+	c.peeked = append(c.peeked, msgID)
+	_, body, _ := strings.Cut(c.boxes[c.selected][msgID], "\r\n\r\n")
+	n, err := io.WriteString(w, body)
+	return int64(n), err
+}
+
+// partialClient is a dedupeClient which is a PartialPeeker.
+type partialClient struct {
+	*dedupeClient
+	lengths []int64
+}
+
+func (c *partialClient) PeekPartial(ctx context.Context, w io.Writer, msgID uint32, what string, offset, length int64) (int64, error) {
+	c.lengths = append(c.lengths, length)
+	return c.Peek(ctx, &limitedWriter{W: w, N: length}, msgID, what)
+}
+
+func TestFindDuplicates(t *testing.T) {
+	ctx := context.Background()
+	c := &dedupeClient{contentClient: &contentClient{boxes: map[string]map[uint32]string{"INBOX": {
+		1: "Subject: a\r\n\r\nbody",
+		2: "Subject: a\r\n\r\nbody",
+		3: "Subject: a\r\n\r\nlonger body",
+		4: "Subject: b\r\n\r\nbody",
+		5: "Subject: a\r\n\r\nbodz",
+	}}}}
+	dups, err := FindDuplicates(ctx, c, "INBOX", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 || !slices.Equal(dups[0].UIDs, []uint32{1, 2}) {
+		t.Errorf("got %+v, wanted 1 and 2", dups)
+	}
+	slices.Sort(c.peeked)
+	if want := []uint32{1, 2, 5}; !slices.Equal(c.peeked, want) {
+		t.Errorf("read the bodies of %v, wanted only %v (same header fields and size)", c.peeked, want)
+	}
+
+	old := DedupeBodyBytes
+	defer func() { DedupeBodyBytes = old }()
+	DedupeBodyBytes = 3
+	pc := &partialClient{dedupeClient: &dedupeClient{contentClient: c.contentClient}}
+	if dups, err = FindDuplicates(ctx, pc, "INBOX", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 || !slices.Equal(dups[0].UIDs, []uint32{1, 2, 5}) {
+		t.Errorf("got %+v, wanted 1, 2 and 5 (same first %d bytes)", dups, DedupeBodyBytes)
+	}
+	if len(pc.lengths) != 3 || slices.ContainsFunc(pc.lengths, func(n int64) bool { return n != DedupeBodyBytes }) {
+		t.Errorf("PeekPartial lengths: %v, wanted 3x %d", pc.lengths, DedupeBodyBytes)
+	}
+}
+
+func TestPeekPartial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cConn, sConn := tcpPipe(t)
+	scriptServer(sConn, nil, "* OK [CAPABILITY IMAP4rev1] ready\r\n", map[string]string{
+		"CAPABILITY": "* CAPABILITY IMAP4rev1\r\nTAG OK done\r\n",
+		"SELECT":     "* 1 EXISTS\r\nTAG OK [READ-WRITE] done\r\n",
+		"UID FETCH":  "* 1 FETCH (UID 1 BODY[TEXT]<0> {3}\r\nabc)\r\nTAG OK done\r\n",
+	})
+	c := NewClientConn(cConn, "username", "password")
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	if err := c.Select(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := c.(PartialPeeker).PeekPartial(ctx, &buf, 1, "TEXT", 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "abc" || n != 3 {
+		t.Errorf("got %q (%d), wanted %q", buf.String(), n, "abc")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// DryRun returns a Client which reads through c, but only logs the mutating calls
+// (Move, Mark, Delete, WriteTo, SetFlags, the mailbox changes and the expunge of Close)
+// as "would ..." and returns success without touching the server.
+//
+// As nothing is moved nor marked, a DeliveryLoop delivers the same messages in each round,
+// and the ExactlyOnceDeliveryLoop cannot claim any.
+//
+// The optional interfaces of c are reached through it with As - except the ones changing
+// the mailboxes or sending messages.
+func DryRun(c Client, logger *slog.Logger) Client {
+	if _, ok := c.(*dryRunClient); ok {
+		return c
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &dryRunClient{Client: c, logger: logger.With("dryRun", true)}
+}
+
+// WithDryRun makes the loop use DryRun(c): the messages are read and delivered,
+// but not moved nor marked.
+func WithDryRun() LoopOption {
+	return func(o *loopOptions) { o.dryRun = true }
+}
+
+// client returns the Client to be used by the loop.
+func (o loopOptions) client(c Client, logger *slog.Logger) Client {
+	if o.readOnly != nil {
+		c = o.readOnly.client(c, o.snoozeBox)
+	}
+	if o.dryRun {
+		return DryRun(c, logger)
+	}
+	return c
+}
+
+type dryRunClient struct {
+	Client
+	logger *slog.Logger
+}
+
+var (
+	_ bulker       = (*dryRunClient)(nil)
+	_ Flagger      = (*dryRunClient)(nil)
+	_ FlagAppender = (*dryRunClient)(nil)
+)
+
+func (c *dryRunClient) would(ctx context.Context, what string, args ...any) error {
+	c.logger.Info("would "+what, args...)
+	return ctx.Err()
+}
+
+func (c *dryRunClient) Close(ctx context.Context, commit bool) error {
+	if commit {
+		c.would(ctx, "expunge")
+	}
+	return c.Client.Close(ctx, false)
+}
+func (c *dryRunClient) CreateMailbox(ctx context.Context, mbox string) error {
+	return c.would(ctx, "create mailbox", "mailbox", mbox)
+}
+func (c *dryRunClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	return c.would(ctx, "delete mailbox", "mailbox", mbox)
+}
+func (c *dryRunClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	return c.would(ctx, "rename mailbox", "mailbox", mbox, "newName", newName)
+}
+func (c *dryRunClient) Delete(ctx context.Context, msgID uint32) error {
+	return c.would(ctx, "delete", "uid", msgID)
+}
+func (c *dryRunClient) Move(ctx context.Context, msgID uint32, mbox string) error {
+	return c.would(ctx, "move", "uid", msgID, "mailbox", mbox)
+}
+func (c *dryRunClient) Mark(ctx context.Context, msgID uint32, seen bool) error {
+	return c.would(ctx, "mark", "uid", msgID, "seen", seen)
+}
+func (c *dryRunClient) WriteTo(ctx context.Context, mbox string, msg []byte, date time.Time) error {
+	return c.AppendFlags(ctx, mbox, msg, date, nil)
+}
+func (c *dryRunClient) AppendFlags(ctx context.Context, mbox string, msg []byte, date time.Time, flags []string) error {
+	if err := CheckAppendSize(ctx, c.Client, mbox, int64(len(msg))); err != nil {
+		return err
+	}
+	return c.would(ctx, "append", "mailbox", mbox, "size", len(msg), "date", date, "flags", flags)
+}
+func (c *dryRunClient) SetFlags(ctx context.Context, msgID uint32, add bool, flags ...string) error {
+	return c.would(ctx, "set flags", "uid", msgID, "add", add, "flags", flags)
+}
+func (c *dryRunClient) MoveSet(ctx context.Context, set SeqSet, mbox string) error {
+	return c.would(ctx, "move", "uids", set.String(), "mailbox", mbox)
+}
+func (c *dryRunClient) MarkSet(ctx context.Context, set SeqSet, seen bool) error {
+	return c.would(ctx, "mark", "uids", set.String(), "seen", seen)
+}
+func (c *dryRunClient) DeleteSet(ctx context.Context, set SeqSet) error {
+	return c.would(ctx, "delete", "uids", set.String())
+}
+
+// DryRunSender returns a Sender which only logs the messages as "would send".
+func DryRunSender(logger *slog.Logger) Sender {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return dryRunSender{logger: logger.With("dryRun", true)}
+}
+
+type dryRunSender struct{ logger *slog.Logger }
+
+func (s dryRunSender) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	s.logger.Info("would send", "from", from, "to", to, "size", len(msg))
+	return ctx.Err()
+}
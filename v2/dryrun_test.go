@@ -0,0 +1,57 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	// The methods of the wrapped Client are not implemented, so they would panic.
+	c := DryRun(&struct{ Client }{}, logger)
+	for _, err := range []error{
+		c.Move(ctx, 1, "Archive"),
+		c.Mark(ctx, 1, true),
+		c.Delete(ctx, 2),
+		c.WriteTo(ctx, "INBOX", []byte("Subject: a\r\n\r\n"), time.Time{}),
+		c.(MailboxManager).CreateMailbox(ctx, "New"),
+		MoveSet(ctx, c, NewSeqSet(3, 4, 5), "Archive"),
+		SetFlags(ctx, c, 6, true, `\Flagged`),
+		DryRunSender(logger).Send(ctx, "a@b", []string{"c@d"}, nil),
+	} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := strings.Count(buf.String(), "msg=\"would "); got != 8 {
+		t.Errorf("got %d would in\n%s", got, buf.String())
+	}
+	if DryRun(c, nil) != c {
+		t.Error("DryRun wrapped twice")
+	}
+}
+
+func TestMigrationDryRun(t *testing.T) {
+	ctx := context.Background()
+	src := &contentClient{boxes: map[string]map[uint32]string{"INBOX": {1: "Subject: 1\r\n\r\n"}, "Sent": {2: "Subject: 2\r\n\r\n"}}}
+	dst := &contentClient{boxes: map[string]map[uint32]string{"INBOX": {}}}
+	state := make(memCheckpointer)
+	m := Migration{Src: src, Dst: dst, State: state, DryRun: true, Logger: slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))}
+	p, err := m.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Copied != 2 || len(dst.appended) != 0 || len(dst.boxes) != 1 || len(state) != 0 {
+		t.Errorf("got %+v, %v, %v", p, dst.boxes, state)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// Flagger is implemented by the Clients which can set and clear the flags of a message.
+type Flagger interface {
+	// SetFlags adds (or removes, if add is false) the flags of the message.
+	SetFlags(ctx context.Context, msgID uint32, add bool, flags ...string) error
+}
+
+var _ Flagger = (*imapClient)(nil)
+
+// SetFlags adds or removes the flags of the message with UID STORE.
+func (c *imapClient) SetFlags(ctx context.Context, msgID uint32, add bool, flags ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("store %v: %w", flags, ErrReadOnly)
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	set := &imap.SeqSet{}
+	set.AddNum(msgID)
+	var op imap.FlagsOp = imap.AddFlags
+	if !add {
+		op = imap.RemoveFlags
+	}
+	args := make([]interface{}, len(flags))
+	for i, f := range flags {
+		args[i] = f
+	}
+	return c.countCommand(time.Now(), c.c.UidStore(set, imap.FormatFlagsOp(op, true), args, nil))
+}
+
+// SetFlags sets the flags of the message with the Flagger of c,
+// or with Mark for \Seen - other flags are not supported then.
+func SetFlags(ctx context.Context, c Client, msgID uint32, add bool, flags ...string) error {
+	if f, ok := As[Flagger](c); ok {
+		return f.SetFlags(ctx, msgID, add, flags...)
+	}
+	for _, f := range flags {
+		if !strings.EqualFold(f, imap.SeenFlag) {
+			return fmt.Errorf("set %s: %w", f, errors.ErrUnsupported)
+		}
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	return c.Mark(ctx, msgID, add)
+}
+
+// DefaultSyncedFlags are the flags synchronized by FlagSync if its Flags is empty.
+var DefaultSyncedFlags = []string{imap.SeenFlag, imap.FlaggedFlag}
+
+// FlagSync synchronizes the flags of the same messages (matched by their Message-ID)
+// of two mailboxes, in both directions.
+//
+// The flags seen at the last sync are kept in Store (or in memory, if it is nil),
+// so the side which has changed a flag since then can be told: its change is copied to the other.
+// At the first sync (and if both sides have changed) a flag set on either side is set on both.
+type FlagSync struct {
+	A, B               Client
+	MailboxA, MailboxB string
+	// Name identifies the state of this pair of mailboxes in the Store.
+	Name string
+	// Store keeps the state, replaced atomically if it is a MessageReplacer.
+	Store MessageStore
+	// Flags are the synchronized flags, DefaultSyncedFlags if empty.
+	Flags  []string
+	Logger *slog.Logger
+	// last is the state of the last sync by Message-ID, if Store is nil.
+	last map[string][]string
+}
+
+// FlagSyncStats are the number of the flag changes made by Sync.
+type FlagSyncStats struct {
+	// Matched is the number of the messages found on both sides.
+	Matched int
+	// ToA and ToB are the number of the flag changes made on A and B.
+	ToA, ToB int
+}
+
+// Sync synchronizes the flags once.
+func (fs *FlagSync) Sync(ctx context.Context) (FlagSyncStats, error) {
+	var stats FlagSyncStats
+	flags := fs.Flags
+	if len(flags) == 0 {
+		flags = DefaultSyncedFlags
+	}
+	as, err := messagesByID(ctx, fs.A, fs.MailboxA)
+	if err != nil {
+		return stats, err
+	}
+	bs, err := messagesByID(ctx, fs.B, fs.MailboxB)
+	if err != nil {
+		return stats, err
+	}
+	last, err := fs.lastState(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	state := make(map[string][]string, len(as))
+	var errs []error
+	for id, a := range as {
+		b, ok := bs[id]
+		if !ok {
+			continue
+		}
+		stats.Matched++
+		prev, known := last[id]
+		var now []string
+		var toA, toB []string
+		var fromA, fromB []string
+		for _, f := range flags {
+			inA, inB := hasFlag(a.Flags, f), hasFlag(b.Flags, f)
+			want := inA || inB
+			if inA != inB && known {
+				// The side which differs from the last state has changed.
+				if inPrev := hasFlag(prev, f); inA != inPrev {
+					want = inA
+				} else {
+					want = inB
+				}
+			}
+			if want {
+				now = append(now, f)
+			}
+			if inA != want {
+				if want {
+					toA = append(toA, f)
+				} else {
+					fromA = append(fromA, f)
+				}
+			}
+			if inB != want {
+				if want {
+					toB = append(toB, f)
+				} else {
+					fromB = append(fromB, f)
+				}
+			}
+		}
+		var failed bool
+		for _, x := range []struct {
+			C     Client
+			UID   uint32
+			Add   bool
+			Flags []string
+			N     *int
+		}{
+			{fs.A, a.UID, true, toA, &stats.ToA}, {fs.A, a.UID, false, fromA, &stats.ToA},
+			{fs.B, b.UID, true, toB, &stats.ToB}, {fs.B, b.UID, false, fromB, &stats.ToB},
+		} {
+			if len(x.Flags) == 0 {
+				continue
+			}
+			if err := SetFlags(ctx, x.C, x.UID, x.Add, x.Flags...); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", id, err))
+				failed = true
+				continue
+			}
+			*x.N += len(x.Flags)
+		}
+		if failed {
+			// Retry from the same state the next time.
+			if known {
+				state[id] = prev
+			}
+			continue
+		}
+		state[id] = now
+	}
+	if err := fs.saveState(ctx, state); err != nil {
+		errs = append(errs, err)
+	}
+	return stats, errors.Join(errs...)
+}
+
+// Run synchronizes the flags at every interval, till the context is canceled.
+func (fs *FlagSync) Run(ctx context.Context, interval time.Duration) error {
+	logger := fs.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	for {
+		stats, err := fs.Sync(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			logger.Error("flag sync", "a", fs.MailboxA, "b", fs.MailboxB, "error", err)
+		} else if stats.ToA != 0 || stats.ToB != 0 {
+			logger.Info("flag sync", "a", fs.MailboxA, "b", fs.MailboxB, "matched", stats.Matched, "toA", stats.ToA, "toB", stats.ToB)
+		}
+		if !sleepCtx(ctx, interval) {
+			return nil
+		}
+	}
+}
+
+// storeMailbox is the mailbox name of the state in the Store.
+func (fs *FlagSync) storeMailbox() string {
+	if fs.Name != "" {
+		return "flagsync/" + fs.Name
+	}
+	return "flagsync/" + fs.MailboxA + "|" + fs.MailboxB
+}
+
+func (fs *FlagSync) lastState(ctx context.Context) (map[string][]string, error) {
+	if fs.Store == nil {
+		return fs.last, nil
+	}
+	records, err := fs.Store.Messages(ctx, fs.storeMailbox())
+	if err != nil {
+		return nil, fmt.Errorf("read flag sync state: %w", err)
+	}
+	last := make(map[string][]string, len(records))
+	for _, r := range records {
+		last[r.MessageID] = r.Flags
+	}
+	return last, nil
+}
+
+func (fs *FlagSync) saveState(ctx context.Context, state map[string][]string) error {
+	if fs.Store == nil {
+		fs.last = state
+		return nil
+	}
+	mbox := fs.storeMailbox()
+	records := make([]MessageRecord, 0, len(state))
+	for id, flags := range state {
+		// The UID is only the key in the Store.
+		records = append(records, MessageRecord{Mailbox: mbox, MessageID: id, Flags: flags, UID: uint32(len(records) + 1)})
+	}
+	if r, ok := fs.Store.(MessageReplacer); ok {
+		if err := r.ReplaceMessages(ctx, mbox, records...); err != nil {
+			return fmt.Errorf("write flag sync state: %w", err)
+		}
+		return nil
+	}
+	// Not atomic: a failure in between loses the state.
+	if err := fs.Store.DeleteMessages(ctx, mbox); err != nil {
+		return fmt.Errorf("write flag sync state: %w", err)
+	}
+	if err := fs.Store.PutMessages(ctx, records...); err != nil {
+		return fmt.Errorf("write flag sync state: %w", err)
+	}
+	return nil
+}
+
+// messagesByID lists the messages of mbox by their Message-ID, skipping the ones without it.
+func messagesByID(ctx context.Context, c Client, mbox string) (map[string]MessageInfo, error) {
+	infos, err := ListInfo(ctx, c, mbox, "", true)
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", mbox, err)
+	}
+	m := make(map[string]MessageInfo, len(infos))
+	for _, mi := range infos {
+		if mi.MessageID == "" {
+			continue
+		}
+		if _, ok := m[mi.MessageID]; !ok {
+			m[mi.MessageID] = mi
+		}
+	}
+	return m, nil
+}
+
+func hasFlag(flags []string, flag string) bool {
+	return slices.ContainsFunc(flags, func(f string) bool { return strings.EqualFold(f, flag) })
+}
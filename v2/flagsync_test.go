@@ -0,0 +1,91 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// flagClient has the messages of one mailbox, with UID = Message-ID + offset.
+type flagClient struct {
+	Client
+	flags  map[uint32][]string
+	offset uint32
+}
+
+func (c *flagClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	var uids []uint32
+	for uid := range c.flags {
+		uids = append(uids, uid)
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+func (c *flagClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		m[uid] = map[string][]string{
+			"ENVELOPE.MESSAGE-ID": {fmt.Sprintf("<%d@x>", uid-c.offset)},
+			"FLAGS":               slices.Clone(c.flags[uid]),
+		}
+	}
+	return m, nil
+}
+func (c *flagClient) SetFlags(ctx context.Context, msgID uint32, add bool, flags ...string) error {
+	for _, f := range flags {
+		c.flags[msgID] = slices.DeleteFunc(c.flags[msgID], func(g string) bool { return g == f })
+		if add {
+			c.flags[msgID] = append(c.flags[msgID], f)
+		}
+	}
+	return nil
+}
+
+func TestFlagSync(t *testing.T) {
+	ctx := context.Background()
+	a := &flagClient{flags: map[uint32][]string{1: {`\Seen`}, 2: nil, 3: {`\Flagged`}, 4: nil}}
+	b := &flagClient{offset: 100, flags: map[uint32][]string{101: nil, 102: {`\Seen`, `\Flagged`}, 103: {`\Flagged`}}}
+	fs := FlagSync{A: a, B: b, MailboxA: "INBOX", MailboxB: "Archive"}
+
+	check := func(c *flagClient, uid uint32, want ...string) {
+		t.Helper()
+		got := slices.Clone(c.flags[uid])
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("%d: got %q, wanted %q", uid, got, want)
+		}
+	}
+
+	// The first sync unites the flags.
+	stats, err := fs.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Matched != 3 || stats.ToA != 2 || stats.ToB != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	check(a, 1, `\Seen`)
+	check(b, 101, `\Seen`)
+	check(a, 2, `\Seen`, `\Flagged`)
+	check(a, 4)
+
+	// Then the changes are propagated, both ways.
+	a.SetFlags(ctx, 1, false, `\Seen`)
+	b.SetFlags(ctx, 103, false, `\Flagged`)
+	b.SetFlags(ctx, 102, false, `\Seen`)
+	if stats, err = fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ToA != 2 || stats.ToB != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	check(b, 101)
+	check(a, 3)
+	check(a, 2, `\Flagged`)
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// PathSeparator separates the folders in the portable folder paths,
+// as "a/b/c" - translated to the hierarchy delimiter of the server by FolderPath.
+const PathSeparator = "/"
+
+// delimiterer is implemented by the Clients which know the hierarchy delimiter of their server.
+type delimiterer interface {
+	Delimiter(ctx context.Context) (string, error)
+}
+
+// folderPathEnsurer is implemented by the Clients which create the folder paths themselves.
+type folderPathEnsurer interface {
+	EnsureFolderPath(ctx context.Context, path string) (string, error)
+}
+
+var _ delimiterer = (*imapClient)(nil)
+
+// Delimiter returns the hierarchy delimiter of the Client, PathSeparator if it is unknown.
+func Delimiter(ctx context.Context, c Client) string {
+	if d, ok := As[delimiterer](c); ok {
+		if delim, err := d.Delimiter(ctx); err == nil && delim != "" {
+			return delim
+		}
+	}
+	return PathSeparator
+}
+
+// JoinFolderPath joins the folder names with the delimiter, skipping the empty ones.
+func JoinFolderPath(delim string, names ...string) string {
+	var buf strings.Builder
+	for _, nm := range names {
+		if nm == "" {
+			continue
+		}
+		if buf.Len() != 0 {
+			buf.WriteString(delim)
+		}
+		buf.WriteString(nm)
+	}
+	return buf.String()
+}
+
+// SplitFolderPath splits the path into the folder names at the delimiter, skipping the empty ones.
+func SplitFolderPath(delim, path string) []string {
+	if delim == "" {
+		return []string{path}
+	}
+	names := strings.Split(path, delim)
+	k := 0
+	for _, nm := range names {
+		if nm != "" {
+			names[k] = nm
+			k++
+		}
+	}
+	return names[:k]
+}
+
+// FolderPath returns the name of the mailbox on the server for the portable path ("a/b/c").
+func FolderPath(ctx context.Context, c Client, path string) string {
+	return JoinFolderPath(Delimiter(ctx, c), SplitFolderPath(PathSeparator, path)...)
+}
+
+// EnsureFolderPath creates the folder given by the portable path ("a/b/c")
+// with all the missing intermediate folders, and returns the mailbox name
+// usable in Move, List and WriteTo.
+func EnsureFolderPath(ctx context.Context, c Client, path string) (string, error) {
+	if e, ok := As[folderPathEnsurer](c); ok {
+		return e.EnsureFolderPath(ctx, path)
+	}
+	mm, ok := As[MailboxManager](c)
+	if !ok {
+		return "", fmt.Errorf("%T: create mailbox: %w", c, errors.ErrUnsupported)
+	}
+	delim := Delimiter(ctx, c)
+	names := SplitFolderPath(PathSeparator, path)
+	if len(names) == 0 {
+		return "", fmt.Errorf("empty path %q", path)
+	}
+	existing, err := c.Mailboxes(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("list mailboxes: %w", err)
+	}
+	var mbox string
+Loop:
+	for i := range names {
+		mbox = JoinFolderPath(delim, names[:i+1]...)
+		if i == 0 && IsWellKnownFolder(mbox) {
+			continue
+		}
+		for _, e := range existing {
+			if e == mbox {
+				continue Loop
+			}
+		}
+		if err := mm.CreateMailbox(ctx, mbox); err != nil {
+			return "", err
+		}
+	}
+	return mbox, nil
+}
+
+// Delimiter returns the hierarchy delimiter of the server, as returned by LIST "" "".
+func (c *imapClient) Delimiter(ctx context.Context) (string, error) {
+	if c.delim != "" {
+		return c.delim, nil
+	}
+	ch := make(chan *imap.MailboxInfo, 1)
+	done := make(chan error, 1)
+	go func() {
+		var called bool
+		err := c.withTimeout(ctx, func() error { called = true; return c.c.List("", "", ch) })
+		if !called { // List closes ch
+			close(ch)
+		}
+		done <- err
+	}()
+	for mi := range ch {
+		if c.delim == "" {
+			c.delim = mi.Delimiter
+		}
+	}
+	return c.delim, <-done
+}
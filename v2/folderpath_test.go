@@ -0,0 +1,31 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFolderPath(t *testing.T) {
+	for _, tc := range []struct {
+		Delim, Path string
+		Want        []string
+	}{
+		{"/", "", []string{}},
+		{"/", "a/b/c", []string{"a", "b", "c"}},
+		{"/", "/a//b/", []string{"a", "b"}},
+		{".", "INBOX.a.b", []string{"INBOX", "a", "b"}},
+		{"", "a/b", []string{"a/b"}},
+	} {
+		got := SplitFolderPath(tc.Delim, tc.Path)
+		if !slices.Equal(got, tc.Want) {
+			t.Errorf("%q %q: got %q, wanted %q", tc.Delim, tc.Path, got, tc.Want)
+		}
+	}
+	if got, want := JoinFolderPath(".", SplitFolderPath(PathSeparator, "/a/b//c")...), "a.b.c"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// Well-known folder names, usable as mbox in List, Select, Move and WriteTo of every Client.
+//
+// The Office 365 backends understand them natively,
+// on IMAP they are mapped to the SPECIAL-USE (RFC 6154) mailboxes.
+const (
+	Inbox        = "Inbox"
+	SentItems    = "SentItems"
+	DeletedItems = "DeletedItems"
+	Drafts       = "Drafts"
+	Archive      = "Archive"
+	JunkEmail    = "JunkEmail"
+)
+
+// wellKnownFolders maps the lowercased well-known folder names to their SPECIAL-USE attribute,
+// and the usual mailbox names used by servers without SPECIAL-USE support.
+var wellKnownFolders = map[string]struct {
+	Attr  string
+	Names []string
+}{
+	"inbox":        {Names: []string{"INBOX"}},
+	"sentitems":    {Attr: imap.SentAttr, Names: []string{"Sent", "Sent Items", "Sent Messages"}},
+	"deleteditems": {Attr: imap.TrashAttr, Names: []string{"Trash", "Deleted", "Deleted Items", "Deleted Messages"}},
+	"drafts":       {Attr: imap.DraftsAttr, Names: []string{"Drafts"}},
+	"archive":      {Attr: imap.ArchiveAttr, Names: []string{"Archive"}},
+	"junkemail":    {Attr: imap.JunkAttr, Names: []string{"Junk", "Spam", "Junk Email", "Junk E-mail"}},
+}
+
+// IsWellKnownFolder reports whether mbox is one of the well-known folder names (case insensitively).
+func IsWellKnownFolder(mbox string) bool {
+	_, ok := wellKnownFolders[strings.ToLower(mbox)]
+	return ok
+}
+
+// mailbox returns the name of the mailbox on the server:
+// the well-known folder names are resolved to the mailbox with the matching
+// SPECIAL-USE attribute, or with one of the usual names.
+//
+// Other names are returned as is.
+func (c *imapClient) mailbox(ctx context.Context, mbox string) string {
+	wk, ok := wellKnownFolders[strings.ToLower(mbox)]
+	if !ok {
+		return mbox
+	}
+	if wk.Attr == "" {
+		return wk.Names[0]
+	}
+	if c.special == nil {
+		if err := c.listSpecial(ctx); err != nil {
+			c.logger.Error("list special-use mailboxes", "error", err)
+		}
+	}
+	if name := c.special[wk.Attr]; name != "" {
+		return name
+	}
+	for _, nm := range wk.Names {
+		for _, name := range c.mailboxNames {
+			if strings.EqualFold(name, nm) || strings.EqualFold(name[strings.LastIndexAny(name, "./")+1:], nm) {
+				return name
+			}
+		}
+	}
+	return mbox
+}
+
+// listSpecial lists all the mailboxes, and remembers the ones with SPECIAL-USE attributes,
+// and the names of the others.
+func (c *imapClient) listSpecial(ctx context.Context) error {
+	special := make(map[string]string)
+	var other []string
+	ch := make(chan *imap.MailboxInfo, 16)
+	done := make(chan error, 1)
+	go func() {
+		var called bool
+		err := c.withTimeout(ctx, func() error { called = true; return c.c.List("", "*", ch) })
+		if !called { // List closes ch
+			close(ch)
+		}
+		done <- err
+	}()
+	for mi := range ch {
+		var found bool
+		for _, a := range mi.Attributes {
+			if _, ok := specialUseAttrs[a]; ok {
+				if _, ok := special[a]; !ok {
+					special[a] = mi.Name
+				}
+				found = true
+			}
+		}
+		if !found {
+			other = append(other, mi.Name)
+		}
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	c.special, c.mailboxNames = special, other
+	return nil
+}
+
+var specialUseAttrs = map[string]struct{}{
+	imap.ArchiveAttr: {}, imap.DraftsAttr: {}, imap.JunkAttr: {},
+	imap.SentAttr: {}, imap.TrashAttr: {},
+}
+
+// MailboxManager is implemented by the Clients which can create, delete and rename mailboxes.
+type MailboxManager interface {
+	CreateMailbox(ctx context.Context, mbox string) error
+	DeleteMailbox(ctx context.Context, mbox string) error
+	RenameMailbox(ctx context.Context, mbox, newName string) error
+}
+
+var _ MailboxManager = (*imapClient)(nil)
+
+// CreateMailbox creates the mailbox.
+func (c *imapClient) CreateMailbox(ctx context.Context, mbox string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("create %q: %w", mbox, ErrReadOnly)
+	}
+	mbox = c.mailbox(ctx, mbox)
+	if err := c.countCommand(time.Now(), c.c.Create(mbox)); err != nil {
+		return fmt.Errorf("create %q: %w", mbox, err)
+	}
+	c.created = append(c.created, mbox)
+	c.mailboxNames = append(c.mailboxNames, mbox)
+	return nil
+}
+
+// DeleteMailbox deletes the mailbox.
+func (c *imapClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("delete %q: %w", mbox, ErrReadOnly)
+	}
+	mbox = c.mailbox(ctx, mbox)
+	if err := c.countCommand(time.Now(), c.c.Delete(mbox)); err != nil {
+		return fmt.Errorf("delete %q: %w", mbox, err)
+	}
+	c.dropFolders()
+	return nil
+}
+
+// RenameMailbox renames the mailbox to newName.
+func (c *imapClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("rename %q: %w", mbox, ErrReadOnly)
+	}
+	mbox = c.mailbox(ctx, mbox)
+	if err := c.countCommand(time.Now(), c.c.Rename(mbox, newName)); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", mbox, newName, err)
+	}
+	c.dropFolders()
+	return nil
+}
+
+// WithCreateMailboxes creates the missing outbox, errbox (and the other mailboxes
+// used by the options, such as the snooze mailbox) at the start of the DeliveryLoop.
+//
+// The well-known folders (see IsWellKnownFolder) are never created.
+func WithCreateMailboxes() LoopOption {
+	return func(o *loopOptions) { o.createMailboxes = true }
+}
+
+// creator returns the roundHook which creates the missing mailboxes, till it succeeds once.
+func creator(mailboxes ...string) roundHook {
+	var done bool
+	return func(ctx context.Context, c Client, inbox string) error {
+		if done {
+			return nil
+		}
+		mm, ok := As[MailboxManager](c)
+		if !ok {
+			return fmt.Errorf("%T: create mailbox: %w", c, errors.ErrUnsupported)
+		}
+		existing, err := c.Mailboxes(ctx, "")
+		if err != nil {
+			return fmt.Errorf("list mailboxes: %w", err)
+		}
+		var errs []error
+	Loop:
+		for _, mbox := range mailboxes {
+			if mbox == "" || IsWellKnownFolder(mbox) {
+				continue
+			}
+			for _, e := range existing {
+				if strings.EqualFold(e, mbox) {
+					continue Loop
+				}
+			}
+			if err := mm.CreateMailbox(ctx, mbox); err != nil {
+				errs = append(errs, err)
+			}
+			existing = append(existing, mbox)
+		}
+		if len(errs) != 0 {
+			return errors.Join(errs...)
+		}
+		done = true
+		return nil
+	}
+}
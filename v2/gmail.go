@@ -0,0 +1,215 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// GmailCapability is the capability of the Gmail IMAP extensions,
+// see https://developers.google.com/gmail/imap/imap-extensions
+const GmailCapability = "X-GM-EXT-1"
+
+// ErrNotGmail is returned by the Gmail* functions when the server does not support the extensions.
+var ErrNotGmail = errors.New("not a Gmail server")
+
+// GmailMessage holds the Gmail attributes of a message.
+type GmailMessage struct {
+	// Labels of the message, such as \Inbox, \Important or the user defined ones.
+	Labels []string
+	// MsgID is the stable ID of the message (X-GM-MSGID), the same in all folders.
+	MsgID uint64
+	// ThreadID is the ID of the thread of the message (X-GM-THRID).
+	ThreadID uint64
+}
+
+// gmailer is implemented by the Clients supporting the Gmail extensions.
+type gmailer interface {
+	isGmail(ctx context.Context) (bool, error)
+	gmailFetch(ctx context.Context, uids ...uint32) (map[uint32]GmailMessage, error)
+	gmailStoreLabels(ctx context.Context, uid uint32, add bool, labels ...string) error
+	gmailSearch(ctx context.Context, mbox, query string) ([]uint32, error)
+}
+
+// IsGmail reports whether the (connected) Client talks to a server with the Gmail extensions.
+func IsGmail(ctx context.Context, c Client) bool {
+	g, ok := As[gmailer](c)
+	if !ok {
+		return false
+	}
+	ok, _ = g.isGmail(ctx)
+	return ok
+}
+
+func asGmailer(ctx context.Context, c Client) (gmailer, error) {
+	if g, ok := As[gmailer](c); ok {
+		if ok, err := g.isGmail(ctx); err != nil {
+			return nil, err
+		} else if ok {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", c, ErrNotGmail)
+}
+
+// GmailFetch returns the Gmail message ID, thread ID and labels of the messages
+// of the selected mailbox.
+func GmailFetch(ctx context.Context, c Client, uids ...uint32) (map[uint32]GmailMessage, error) {
+	g, err := asGmailer(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return g.gmailFetch(ctx, uids...)
+}
+
+// GmailAddLabels adds the labels to the message.
+func GmailAddLabels(ctx context.Context, c Client, uid uint32, labels ...string) error {
+	g, err := asGmailer(ctx, c)
+	if err != nil {
+		return err
+	}
+	return g.gmailStoreLabels(ctx, uid, true, labels...)
+}
+
+// GmailRemoveLabels removes the labels from the message.
+func GmailRemoveLabels(ctx context.Context, c Client, uid uint32, labels ...string) error {
+	g, err := asGmailer(ctx, c)
+	if err != nil {
+		return err
+	}
+	return g.gmailStoreLabels(ctx, uid, false, labels...)
+}
+
+// GmailSearch searches the mailbox with the Gmail search syntax (X-GM-RAW),
+// such as "label:invoices has:attachment older_than:1y".
+func GmailSearch(ctx context.Context, c Client, mbox, query string) ([]uint32, error) {
+	g, err := asGmailer(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return g.gmailSearch(ctx, mbox, query)
+}
+
+const (
+	gmailMsgID    = imap.FetchItem("X-GM-MSGID")
+	gmailThreadID = imap.FetchItem("X-GM-THRID")
+	gmailLabels   = imap.FetchItem("X-GM-LABELS")
+)
+
+var _ gmailer = (*imapClient)(nil)
+
+func (c *imapClient) isGmail(ctx context.Context) (bool, error) {
+	if c.c == nil {
+		return false, errNotLoggedIn
+	}
+	return c.c.Support(GmailCapability)
+}
+
+func (c *imapClient) gmailFetch(ctx context.Context, uids ...uint32) (map[uint32]GmailMessage, error) {
+	set := &imap.SeqSet{}
+	set.AddNum(uids...)
+	ch := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		var called bool
+		err := c.withTimeout(ctx, func() error {
+			called = true
+			return c.c.UidFetch(set, []imap.FetchItem{gmailMsgID, gmailThreadID, gmailLabels}, ch)
+		})
+		if !called { // UidFetch closes ch
+			close(ch)
+		}
+		done <- err
+	}()
+	result := make(map[uint32]GmailMessage, len(uids))
+	for msg := range ch {
+		var gm GmailMessage
+		gm.MsgID, _ = strconv.ParseUint(fmt.Sprintf("%v", msg.Items[gmailMsgID]), 10, 64)
+		gm.ThreadID, _ = strconv.ParseUint(fmt.Sprintf("%v", msg.Items[gmailThreadID]), 10, 64)
+		gm.Labels = decodeLabels(msg.Items[gmailLabels])
+		result[msg.Uid] = gm
+	}
+	return result, <-done
+}
+
+// decodeLabels decodes the X-GM-LABELS list, whose non-ASCII labels are in modified UTF-7.
+func decodeLabels(v interface{}) []string {
+	vv, _ := v.([]interface{})
+	labels := make([]string, 0, len(vv))
+	dec := utf7.Encoding.NewDecoder()
+	for _, v := range vv {
+		s := fmt.Sprintf("%v", v)
+		if d, err := dec.String(s); err == nil {
+			s = d
+		}
+		labels = append(labels, s)
+	}
+	return labels
+}
+
+func (c *imapClient) gmailStoreLabels(ctx context.Context, uid uint32, add bool, labels ...string) error {
+	if c.readOnly {
+		return fmt.Errorf("store labels: %w", ErrReadOnly)
+	}
+	set := &imap.SeqSet{}
+	set.AddNum(uid)
+	item := "+X-GM-LABELS.SILENT"
+	if !add {
+		item = "-X-GM-LABELS.SILENT"
+	}
+	enc := utf7.Encoding.NewEncoder()
+	list := make([]interface{}, 0, len(labels))
+	for _, l := range labels {
+		if strings.HasPrefix(l, `\`) { // system labels are atoms
+			list = append(list, imap.RawString(l))
+			continue
+		}
+		if e, err := enc.String(l); err == nil {
+			l = e
+		}
+		list = append(list, l)
+	}
+	return c.withTimeout(ctx, func() error {
+		status, err := c.c.Execute(&imap.Command{
+			Name:      "UID",
+			Arguments: []interface{}{imap.RawString("STORE"), set, imap.RawString(item), list},
+		}, nil)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	})
+}
+
+func (c *imapClient) gmailSearch(ctx context.Context, mbox, query string) ([]uint32, error) {
+	if err := c.Select(ctx, mbox); err != nil {
+		return nil, fmt.Errorf("SELECT %q: %w", mbox, err)
+	}
+	args := []interface{}{imap.RawString("SEARCH")}
+	for _, r := range query {
+		if r >= 0x80 {
+			args = append(args, imap.RawString("CHARSET"), imap.RawString("UTF-8"))
+			break
+		}
+	}
+	args = append(args, imap.RawString("X-GM-RAW"), query)
+	var resp responses.Search
+	err := c.withTimeout(ctx, func() error {
+		status, err := c.c.Execute(&imap.Command{Name: "UID", Arguments: args}, &resp)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	})
+	return resp.Ids, err
+}
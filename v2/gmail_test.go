@@ -0,0 +1,62 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGmail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	scriptServer(sConn, nil, "* OK [CAPABILITY IMAP4rev1 X-GM-EXT-1] Gimap ready\r\n", map[string]string{
+		"CAPABILITY": "* CAPABILITY IMAP4rev1 X-GM-EXT-1\r\nTAG OK done\r\n",
+		"SELECT":     "* 2 EXISTS\r\n* OK [UIDVALIDITY 1] UIDs valid\r\nTAG OK [READ-WRITE] done\r\n",
+		"UID FETCH": "* 1 FETCH (UID 3 X-GM-MSGID 1278455344230334865 X-GM-THRID 1266894439832287888 " +
+			"X-GM-LABELS (\\Inbox \"&AOk-t&AOk-\" work))\r\nTAG OK done\r\n",
+		"UID SEARCH": "* SEARCH 3 5\r\nTAG OK done\r\n",
+	})
+	c := NewClientConn(cConn, "username", "password")
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	if !IsGmail(ctx, c) {
+		t.Fatal("not Gmail")
+	}
+
+	uids, err := GmailSearch(ctx, c, "INBOX", "label:work older_than:1y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(uids, []uint32{3, 5}) {
+		t.Errorf("search: got %v", uids)
+	}
+	msgs, err := GmailFetch(ctx, c, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := msgs[3]
+	if gm.MsgID != 1278455344230334865 || gm.ThreadID != 1266894439832287888 {
+		t.Errorf("got %+v", gm)
+	}
+	if want := []string{`\Inbox`, "été", "work"}; !slices.Equal(gm.Labels, want) {
+		t.Errorf("labels: got %q, wanted %q", gm.Labels, want)
+	}
+	if err = GmailAddLabels(ctx, c, 3, "été", `\Starred`); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = GmailFetch(ctx, &fakeClient{}, 3); !errors.Is(err, ErrNotGmail) {
+		t.Errorf("fakeClient: got %+v, wanted ErrNotGmail", err)
+	}
+}
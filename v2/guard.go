@@ -0,0 +1,184 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+// ResponseLimitsSetter is implemented by the Clients which check the server responses.
+type ResponseLimitsSetter interface {
+	// SetResponseLimits sets the limits of the server responses - the zero value means
+	// imapparse.DefaultLimits. It applies from the next Connect.
+	SetResponseLimits(imapparse.Limits)
+}
+
+// ParseModeSetter is implemented by the Clients which can check the server responses strictly.
+type ParseModeSetter interface {
+	// SetParseMode sets how strict the checks of the server responses are: imapparse.Lenient
+	// (the default) logs the common protocol violations as warnings, imapparse.Strict fails
+	// the connection on them. It applies from the next Connect.
+	SetParseMode(imapparse.Mode)
+}
+
+var (
+	_ ResponseLimitsSetter = (*imapClient)(nil)
+	_ ParseModeSetter      = (*imapClient)(nil)
+)
+
+// SetResponseLimits sets the limits of the server responses, checked from the next Connect.
+func (c *imapClient) SetResponseLimits(limits imapparse.Limits) { c.limits = limits }
+
+// SetParseMode sets the strictness of the checks of the server responses, from the next Connect.
+func (c *imapClient) SetParseMode(mode imapparse.Mode) { c.parseMode = mode }
+
+// guardConn parses the responses read from the connection with imapparse, and passes them
+// on to go-imap re-encoded (by AppendPortable): a malformed or oversized response fails
+// the connection with an error, instead of reaching (and maybe panicking) go-imap,
+// and the violations tolerated in Lenient mode reach go-imap repaired.
+//
+// The responses are parsed in a goroutine, one per demand of Read:
+// a timeout of the connection is returned by Read without losing the parsed part.
+type guardConn struct {
+	net.Conn
+	// more asks the parser for the next response - or to go on after a timeout.
+	more chan struct{}
+	// out are the encoded responses, or the timeouts of the connection.
+	out  chan guardOut
+	quit chan struct{}
+	done chan struct{}
+	// filter may change the responses before they are encoded, see noPipeliningFilter.
+	filter func(*imapparse.Response)
+	// err is the error of the parsing, readErr the error of the connection.
+	err, readErr error
+	cur          []byte
+	quitOnce     sync.Once
+}
+
+type guardOut struct {
+	err error
+	b   []byte
+}
+
+// newGuardConn returns the guardConn of conn, warn is called with the violations tolerated in Lenient mode,
+// filter (if not nil) with each response.
+func newGuardConn(conn net.Conn, limits imapparse.Limits, mode imapparse.Mode, warn func(error), filter func(*imapparse.Response)) *guardConn {
+	gc := &guardConn{Conn: conn, more: make(chan struct{}), out: make(chan guardOut),
+		quit: make(chan struct{}), done: make(chan struct{}), filter: filter}
+	p := imapparse.NewParser(readerFunc(gc.read))
+	p.Limits = limits
+	p.Mode, p.Warn = mode, warn
+	go func() {
+		defer close(gc.done)
+		var buf []byte
+		for {
+			select {
+			case <-gc.more:
+			case <-gc.quit:
+				return
+			}
+			resp, err := p.ReadResponse()
+			if err != nil {
+				if errors.Is(err, imapparse.ErrSyntax) || errors.Is(err, imapparse.ErrTooLarge) {
+					gc.err = fmt.Errorf("invalid server response: %w", err)
+				} else {
+					gc.readErr = err
+				}
+				return
+			}
+			if gc.filter != nil {
+				gc.filter(&resp)
+			}
+			buf = resp.AppendPortable(buf[:0])
+			select {
+			case gc.out <- guardOut{b: buf}:
+			case <-gc.quit:
+				return
+			}
+		}
+	}()
+	return gc
+}
+
+// read is the Read of the parser: it returns the timeouts to Read, and goes on at the next demand.
+func (gc *guardConn) read(p []byte) (int, error) {
+	for {
+		n, err := gc.Conn.Read(p)
+		if n != 0 || err == nil {
+			return n, nil
+		}
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			return 0, err
+		}
+		select {
+		case gc.out <- guardOut{err: err}:
+		case <-gc.quit:
+			return 0, io.EOF
+		}
+		select {
+		case <-gc.more:
+		case <-gc.quit:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (gc *guardConn) Read(p []byte) (int, error) {
+	if len(gc.cur) == 0 {
+		select {
+		case gc.more <- struct{}{}:
+		case <-gc.done:
+			return 0, gc.doneErr()
+		}
+		select {
+		case o := <-gc.out:
+			if o.err != nil {
+				return 0, o.err
+			}
+			gc.cur = o.b
+		case <-gc.done:
+			return 0, gc.doneErr()
+		}
+	}
+	n := copy(p, gc.cur)
+	gc.cur = gc.cur[n:]
+	return n, nil
+}
+
+func (gc *guardConn) doneErr() error {
+	if gc.err != nil {
+		return gc.err
+	}
+	if gc.readErr != nil {
+		return gc.readErr
+	}
+	return net.ErrClosed
+}
+
+// Err returns the error of the check, if it has failed.
+func (gc *guardConn) Err() error {
+	select {
+	case <-gc.done:
+		return gc.err
+	default:
+	}
+	return nil
+}
+
+func (gc *guardConn) Close() error {
+	gc.quitOnce.Do(func() { close(gc.quit) })
+	return gc.Conn.Close()
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
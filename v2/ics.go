@@ -0,0 +1,273 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+// CalendarEvent is a VEVENT of an iCalendar (RFC 5545) object, such as a meeting invitation.
+type CalendarEvent struct {
+	// Start and End of the event; for AllDay events, the dates at midnight UTC.
+	Start, End time.Time
+	Organizer  CalendarAttendee
+	// Method of the iCalendar object: REQUEST, CANCEL, REPLY, PUBLISH...
+	Method string
+	// UID identifies the event, Sequence its revision.
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	// Status is TENTATIVE, CONFIRMED or CANCELLED.
+	Status    string
+	Attendees []CalendarAttendee
+	Sequence  int
+	AllDay    bool
+}
+
+// CalendarAttendee is an ATTENDEE or the ORGANIZER of a CalendarEvent.
+type CalendarAttendee struct {
+	Email string
+	Name  string
+	// Role is REQ-PARTICIPANT, OPT-PARTICIPANT, NON-PARTICIPANT or CHAIR.
+	Role string
+	// PartStat is the participation status: NEEDS-ACTION, ACCEPTED, DECLINED, TENTATIVE...
+	PartStat string
+	RSVP     bool
+}
+
+// calendarEventer is implemented by the Clients which know the events of the messages themselves (o365).
+type calendarEventer interface {
+	CalendarEvents(ctx context.Context, msgID uint32) ([]CalendarEvent, error)
+}
+
+// CalendarEvents returns the events of the message - see MessageCalendarEvents.
+func CalendarEvents(ctx context.Context, c Client, msgID uint32) ([]CalendarEvent, error) {
+	if ce, ok := As[calendarEventer](c); ok {
+		return ce.CalendarEvents(ctx, msgID)
+	}
+	var buf bytes.Buffer
+	if _, err := c.Peek(ctx, &buf, msgID, ""); err != nil {
+		return nil, err
+	}
+	return MessageCalendarEvents(&buf)
+}
+
+// MessageCalendarEvents returns the events of the text/calendar (and application/ics) parts of the message.
+func MessageCalendarEvents(r io.Reader) ([]CalendarEvent, error) {
+	m, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	var events []CalendarEvent
+	seen := make(map[string]bool)
+	err = m.Walk(func(_ []int, e *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) {
+			return err
+		}
+		mediaType, _, _ := e.Header.ContentType()
+		if mediaType != "text/calendar" && mediaType != "application/ics" {
+			return nil
+		}
+		evs, err := ParseICS(e.Body)
+		if err != nil {
+			return err
+		}
+		// The same invitation is often both inline and attached.
+		for _, ev := range evs {
+			k := ev.UID + "\x00" + strconv.Itoa(ev.Sequence) + "\x00" + ev.Method
+			if !seen[k] {
+				seen[k] = true
+				events = append(events, ev)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// ParseICS parses the VEVENTs of the iCalendar object.
+func ParseICS(r io.Reader) ([]CalendarEvent, error) {
+	var events []CalendarEvent
+	var method string
+	var ev *CalendarEvent
+	var depth int // of the components nested into the VEVENT, such as VALARM
+	err := icsLines(r, func(name string, params map[string]string, value string) error {
+		switch name {
+		case "BEGIN":
+			if ev != nil {
+				depth++
+			} else if strings.EqualFold(value, "VEVENT") {
+				ev = &CalendarEvent{Method: method}
+			}
+			return nil
+		case "END":
+			if ev == nil {
+				return nil
+			}
+			if depth > 0 {
+				depth--
+			} else if strings.EqualFold(value, "VEVENT") {
+				events = append(events, *ev)
+				ev = nil
+			}
+			return nil
+		case "METHOD":
+			if ev == nil {
+				method = strings.ToUpper(value)
+			}
+			return nil
+		}
+		if ev == nil || depth > 0 {
+			return nil
+		}
+		var err error
+		switch name {
+		case "UID":
+			ev.UID = value
+		case "SEQUENCE":
+			ev.Sequence, _ = strconv.Atoi(value)
+		case "SUMMARY":
+			ev.Summary = icsText(value)
+		case "DESCRIPTION":
+			ev.Description = icsText(value)
+		case "LOCATION":
+			ev.Location = icsText(value)
+		case "STATUS":
+			ev.Status = strings.ToUpper(value)
+		case "DTSTART":
+			ev.Start, ev.AllDay, err = icsTime(params, value)
+		case "DTEND":
+			ev.End, _, err = icsTime(params, value)
+		case "ORGANIZER":
+			ev.Organizer = icsAttendee(params, value)
+		case "ATTENDEE":
+			ev.Attendees = append(ev.Attendees, icsAttendee(params, value))
+		}
+		return err
+	})
+	for i, ev := range events {
+		if ev.End.IsZero() && ev.AllDay {
+			events[i].End = ev.Start.AddDate(0, 0, 1)
+		}
+	}
+	return events, err
+}
+
+// icsLines calls f with the unfolded content lines.
+func icsLines(r io.Reader, f func(name string, params map[string]string, value string) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	var line strings.Builder
+	flush := func() error {
+		s := line.String()
+		line.Reset()
+		if s == "" {
+			return nil
+		}
+		i := icsIndex(s, ':')
+		if i < 0 {
+			return nil
+		}
+		head, value := s[:i], s[i+1:]
+		parts := icsSplit(head)
+		params := make(map[string]string, len(parts)-1)
+		for _, p := range parts[1:] {
+			if k, v, ok := strings.Cut(p, "="); ok {
+				params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+		}
+		return f(strings.ToUpper(parts[0]), params, value)
+	}
+	for sc.Scan() {
+		s := strings.TrimRight(sc.Text(), "\r")
+		if strings.HasPrefix(s, " ") || strings.HasPrefix(s, "\t") {
+			line.WriteString(s[1:])
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		line.WriteString(s)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return sc.Err()
+}
+
+// icsIndex returns the index of the first c outside of the quoted strings.
+func icsIndex(s string, c byte) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case c:
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// icsSplit splits the name and the parameters at the semicolons outside of the quoted strings.
+func icsSplit(s string) []string {
+	var parts []string
+	for {
+		i := icsIndex(s, ';')
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+var icsTextReplacer = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// icsText unescapes the TEXT value.
+func icsText(s string) string { return icsTextReplacer.Replace(s) }
+
+// icsTime parses the DATE or DATE-TIME value, in the TZID time zone if given.
+func icsTime(params map[string]string, value string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := time.Local
+	if tz := params["TZID"]; tz != "" {
+		if l, err := LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+func icsAttendee(params map[string]string, value string) CalendarAttendee {
+	email := value
+	if len(email) > len("mailto:") && strings.EqualFold(email[:len("mailto:")], "mailto:") {
+		email = email[len("mailto:"):]
+	}
+	return CalendarAttendee{
+		Email: email, Name: params["CN"],
+		Role: params["ROLE"], PartStat: params["PARTSTAT"],
+		RSVP: strings.EqualFold(params["RSVP"], "TRUE"),
+	}
+}
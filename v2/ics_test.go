@@ -0,0 +1,56 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageCalendarEvents(t *testing.T) {
+	const ics = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nMETHOD:REQUEST\r\nBEGIN:VEVENT\r\n" +
+		"UID:abc-123\r\nSEQUENCE:2\r\nSUMMARY:Weekly sync\\, team\r\n" +
+		"DTSTART:20240115T090000Z\r\nDTEND:20240115T093000Z\r\n" +
+		"ORGANIZER;CN=\"Boss, The\":mailto:boss@example.com\r\n" +
+		"ATTENDEE;CN=Joe;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:joe@exa\r\n mple.com\r\n" +
+		"BEGIN:VALARM\r\nDESCRIPTION:reminder\r\nEND:VALARM\r\n" +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+	msg := "Subject: Invitation\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/calendar; method=REQUEST\r\n\r\n" + ics +
+		"--b\r\nContent-Type: application/ics; name=invite.ics\r\nContent-Disposition: attachment\r\n\r\n" + ics +
+		"--b--\r\n"
+	events, err := MessageCalendarEvents(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, wanted 1: %+v", len(events), events)
+	}
+	ev := events[0]
+	t.Logf("%+v", ev)
+	if ev.Method != "REQUEST" || ev.UID != "abc-123" || ev.Sequence != 2 || ev.Summary != "Weekly sync, team" || ev.Description != "" {
+		t.Errorf("got %+v", ev)
+	}
+	if !ev.Start.Equal(time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)) || ev.End.Sub(ev.Start) != 30*time.Minute {
+		t.Errorf("times: got %s - %s", ev.Start, ev.End)
+	}
+	if ev.Organizer.Name != "Boss, The" || ev.Organizer.Email != "boss@example.com" {
+		t.Errorf("organizer: got %+v", ev.Organizer)
+	}
+	if len(ev.Attendees) != 1 || ev.Attendees[0].Email != "joe@example.com" || !ev.Attendees[0].RSVP {
+		t.Errorf("attendees: got %+v", ev.Attendees)
+	}
+}
+
+func TestICSTimeWindowsZone(t *testing.T) {
+	got, _, err := icsTime(map[string]string{"TZID": "W. Europe Standard Time"}, "20240115T090000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+}
@@ -0,0 +1,772 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package imapparse is a defensive parser of the IMAP (RFC 3501) server responses:
+// malformed or hostile input is an error (never a panic), and the sizes of the tokens,
+// the literals and the responses are limited.
+package imapparse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrSyntax is returned for the malformed responses.
+	ErrSyntax = errors.New("syntax error")
+	// ErrTooLarge is returned for the responses exceeding the Limits.
+	ErrTooLarge = errors.New("too large")
+)
+
+// Limits of the parsed responses. The zero fields mean the DefaultLimits.
+type Limits struct {
+	// MaxLiteral is the maximal size of a literal, such as a message body.
+	MaxLiteral int64
+	// MaxToken is the maximal length of an atom or a quoted string.
+	MaxToken int
+	// MaxLine is the maximal length of a response, without the contents of its literals.
+	MaxLine int
+	// MaxDepth is the maximal nesting of the parenthesized lists.
+	MaxDepth int
+}
+
+// DefaultLimits are generous enough for the real servers:
+// huge SEARCH responses, deep BODYSTRUCTUREs and big messages.
+var DefaultLimits = Limits{MaxLiteral: 512 << 20, MaxToken: 1 << 20, MaxLine: 64 << 20, MaxDepth: 64}
+
+// orDefault returns the limits, with the zero fields replaced by the DefaultLimits.
+func (l Limits) orDefault() Limits {
+	if l.MaxLiteral <= 0 {
+		l.MaxLiteral = DefaultLimits.MaxLiteral
+	}
+	if l.MaxToken <= 0 {
+		l.MaxToken = DefaultLimits.MaxToken
+	}
+	if l.MaxLine <= 0 {
+		l.MaxLine = DefaultLimits.MaxLine
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	return l
+}
+
+// Mode is the strictness of the parsing.
+type Mode uint8
+
+const (
+	// Lenient tolerates the common violations of the servers (missing or extra spaces,
+	// bare LF line endings, invalid escapes, malformed ENVELOPEs, 8-bit characters),
+	// reporting them to Parser.Warn.
+	Lenient = Mode(iota)
+	// Strict rejects them with ErrSyntax.
+	Strict
+)
+
+func (m Mode) String() string {
+	if m == Strict {
+		return "strict"
+	}
+	return "lenient"
+}
+
+// Kind is the kind of a Value.
+type Kind uint8
+
+const (
+	Atom = Kind(iota)
+	Quoted
+	Literal
+	Nil
+	List
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Atom:
+		return "atom"
+	case Quoted:
+		return "quoted"
+	case Literal:
+		return "literal"
+	case Nil:
+		return "NIL"
+	case List:
+		return "list"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Value is an element of a response.
+type Value struct {
+	// Text is the content of an Atom, a Quoted string or a Literal (unless skipped).
+	Text string
+	// List holds the elements of a List.
+	List []Value
+	// Size is the size of a Literal, also when its content is skipped.
+	Size int64
+	Kind Kind
+}
+
+// Number returns the value of a numeric Atom.
+func (v Value) Number() (uint32, bool) {
+	if v.Kind != Atom {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v.Text, 10, 32)
+	return uint32(n), err == nil
+}
+
+// String returns the IMAP representation of the value.
+func (v Value) String() string {
+	return string(v.appendTo(nil, false))
+}
+
+// appendTo appends the IMAP representation of the value to dst - with the quoted strings
+// having 8-bit characters as literals, if portable.
+func (v Value) appendTo(dst []byte, portable bool) []byte {
+	switch v.Kind {
+	case Nil:
+		return append(dst, "NIL"...)
+	case Quoted:
+		if portable && has8Bit(v.Text) {
+			return Value{Kind: Literal, Text: v.Text, Size: int64(len(v.Text))}.appendTo(dst, false)
+		}
+		dst = append(dst, '"')
+		for i := 0; i < len(v.Text); i++ {
+			if b := v.Text[i]; b == '"' || b == '\\' {
+				dst = append(dst, '\\')
+			}
+			dst = append(dst, v.Text[i])
+		}
+		return append(dst, '"')
+	case Literal:
+		dst = append(dst, '{')
+		dst = strconv.AppendInt(dst, v.Size, 10)
+		dst = append(dst, "}\r\n"...)
+		return append(dst, v.Text...)
+	case List:
+		return appendValues(append(dst, '('), v.List, ')', portable)
+	}
+	return append(dst, v.Text...)
+}
+
+func appendValues(dst []byte, vv []Value, end byte, portable bool) []byte {
+	for i, v := range vv {
+		if i != 0 {
+			dst = append(dst, ' ')
+		}
+		dst = v.appendTo(dst, portable)
+	}
+	return append(dst, end)
+}
+
+func has8Bit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// Response is a server response.
+type Response struct {
+	// Tag is "*" for the untagged responses, "+" for the continuation requests.
+	Tag string
+	// Status is OK, NO, BAD, BYE or PREAUTH for the status responses.
+	Status string
+	// Code is the response code of a status response (between the brackets),
+	// such as [UIDVALIDITY 3857529045]; nil if there is none.
+	Code []Value
+	// Text is the human readable text of a status response or a continuation request.
+	Text string
+	// Fields are the values of the other responses, such as 12 FETCH (UID 3 FLAGS (\Seen)).
+	Fields []Value
+}
+
+// String returns the IMAP representation of the response, with the line ending.
+func (r Response) String() string {
+	return string(r.appendTo(make([]byte, 0, 64), false))
+}
+
+// AppendPortable appends the response to dst in the form which the less tolerant parsers
+// (such as go-imap's) read the same way: as String, but the quoted strings having 8-bit
+// characters are sent as literals, and a status is followed by a space even without a text.
+//
+// The responses parsed in Lenient Mode are thus passed on with their violations repaired.
+func (r Response) AppendPortable(dst []byte) []byte {
+	return r.appendTo(dst, true)
+}
+
+func (r Response) appendTo(dst []byte, portable bool) []byte {
+	dst = append(dst, r.Tag...)
+	switch {
+	case r.Tag == "+":
+		dst = append(append(dst, ' '), r.Text...)
+	case r.Status != "":
+		dst = append(append(dst, ' '), r.Status...)
+		if r.Code != nil {
+			dst = appendValues(append(dst, " ["...), r.Code, ']', portable)
+		}
+		if r.Text != "" || portable {
+			dst = append(append(dst, ' '), r.Text...)
+		}
+	default:
+		dst = appendValues(append(dst, ' '), r.Fields, ' ', portable)
+		dst = dst[:len(dst)-1]
+	}
+	return append(dst, "\r\n"...)
+}
+
+// IsStatus reports whether the status is one of the status responses.
+func IsStatus(s string) bool {
+	switch strings.ToUpper(s) {
+	case "OK", "NO", "BAD", "BYE", "PREAUTH":
+		return true
+	}
+	return false
+}
+
+// Parser reads the responses from a stream.
+type Parser struct {
+	r *bufio.Reader
+	// Limits of the responses, the zero value means DefaultLimits.
+	Limits Limits
+	limits Limits
+	// Warn is called with the violations tolerated in Lenient Mode, if not nil.
+	Warn func(error)
+	// SkipLiterals discards the contents of the literals, only their Size is kept.
+	SkipLiterals bool
+	Mode         Mode
+	// off is the number of bytes read, line the length of the current response
+	// (without the contents of the literals).
+	off  int64
+	line int
+}
+
+// NewParser returns a Parser reading from r.
+func NewParser(r io.Reader) *Parser {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Parser{r: br}
+}
+
+// Parse parses the first response of b.
+func Parse(b []byte) (Response, error) {
+	return NewParser(bytes.NewReader(b)).ReadResponse()
+}
+
+// ReadResponse reads the next response.
+//
+// It returns io.EOF only at the start of a response,
+// io.ErrUnexpectedEOF if the stream ends within one.
+func (p *Parser) ReadResponse() (Response, error) {
+	p.limits, p.line = p.Limits.orDefault(), 0
+	var resp Response
+	if _, err := p.peek(); err != nil {
+		return resp, err
+	}
+	tag, err := p.readAtom(true)
+	if err != nil {
+		return resp, err
+	}
+	resp.Tag = tag
+	if tag == "+" {
+		// Some servers send the continuation request without the space.
+		if b, err := p.peek(); err != nil {
+			return resp, p.unexpectedEOF(err)
+		} else if b == ' ' {
+			p.readByte()
+		}
+		resp.Text, err = p.readText()
+		return resp, err
+	}
+	if err = p.expect(' '); err != nil {
+		return resp, err
+	}
+	if err = p.skipSpaces(); err != nil {
+		return resp, err
+	}
+	first, err := p.readValue(false, 0)
+	if err != nil {
+		return resp, err
+	}
+	if first.Kind == Atom && IsStatus(first.Text) {
+		resp.Status = strings.ToUpper(first.Text)
+		return resp, p.readStatus(&resp)
+	}
+	if tag != "*" {
+		return resp, p.syntaxError("tagged response with %q instead of a status", first.Text)
+	}
+	resp.Fields, err = p.readValues('\n', 0, []Value{first})
+	return resp, err
+}
+
+// readStatus reads the optional response code and the text of a status response.
+func (p *Parser) readStatus(resp *Response) error {
+	b, err := p.peek()
+	if err != nil {
+		return p.unexpectedEOF(err)
+	}
+	if b != ' ' {
+		return p.readEOL()
+	}
+	p.readByte()
+	if b, err = p.peek(); err != nil {
+		return p.unexpectedEOF(err)
+	}
+	if b == '[' {
+		p.readByte()
+		if resp.Code, err = p.readValues(']', 0, []Value{}); err != nil {
+			return err
+		}
+		if b, err = p.peek(); err != nil {
+			return p.unexpectedEOF(err)
+		}
+		if b == '\r' || b == '\n' {
+			return p.readEOL()
+		}
+		if b != ' ' {
+			if err = p.violation("missing space after the response code"); err != nil {
+				return err
+			}
+		} else {
+			p.readByte()
+		}
+	}
+	resp.Text, err = p.readText()
+	return err
+}
+
+// readValues reads the space separated values after vals, till the end byte
+// ('\n' means CRLF) - which is consumed.
+func (p *Parser) readValues(end byte, depth int, vals []Value) ([]Value, error) {
+	inCode := end == ']'
+	var spaced bool
+	for {
+		b, err := p.peek()
+		if err != nil {
+			return vals, p.unexpectedEOF(err)
+		}
+		if end == '\n' && (b == '\r' || b == '\n') || b == end && end != '\n' {
+			if spaced {
+				if err = p.violation("space before the end of %q", end); err != nil {
+					return vals, err
+				}
+			}
+			if end == '\n' {
+				return vals, p.readEOL()
+			}
+			p.readByte()
+			return vals, nil
+		}
+		if b == ' ' {
+			p.readByte()
+			if len(vals) == 0 || spaced {
+				if err = p.violation("extra space"); err != nil {
+					return vals, err
+				}
+			}
+			spaced = true
+			continue
+		}
+		if len(vals) != 0 && !spaced {
+			if err = p.violation("missing space before %q", b); err != nil {
+				return vals, err
+			}
+		}
+		spaced = false
+		v, err := p.readValue(inCode, depth)
+		if err != nil {
+			return vals, err
+		}
+		if n := len(vals); n != 0 && v.Kind == List &&
+			vals[n-1].Kind == Atom && strings.EqualFold(vals[n-1].Text, "ENVELOPE") {
+			if v, err = p.checkEnvelope(v); err != nil {
+				return vals, err
+			}
+		}
+		vals = append(vals, v)
+	}
+}
+
+// checkEnvelope checks the structure of the ENVELOPE (RFC 3501 7.4.2):
+// date, subject, from, sender, reply-to, to, cc, bcc, in-reply-to and message-id,
+// the addresses are NIL or a non-empty list of (name adl mailbox host).
+//
+// In Lenient Mode it returns the envelope repaired: padded (or cut) to 10 fields,
+// the atoms of the strings quoted, the invalid strings and empty address lists NIL,
+// the addresses padded (or cut) to 4 fields, and the non-list addresses dropped.
+func (p *Parser) checkEnvelope(env Value) (Value, error) {
+	if len(env.List) != 10 {
+		if err := p.violation("ENVELOPE of %d fields", len(env.List)); err != nil {
+			return env, err
+		}
+		env.List = fitNils(env.List, 10)
+	}
+	for i, f := range env.List {
+		if i < 2 || i > 7 {
+			if !isNString(f) {
+				if err := p.violation("ENVELOPE field %d is %s", i, f.Kind); err != nil {
+					return env, err
+				}
+				env.List[i] = toNString(f)
+			}
+			continue
+		}
+		if f.Kind == Nil {
+			continue
+		}
+		if f.Kind != List {
+			if err := p.violation("ENVELOPE addresses %d is %s instead of NIL", i, f.Kind); err != nil {
+				return env, err
+			}
+			env.List[i] = Value{Kind: Nil}
+			continue
+		}
+		addrs := f.List[:0]
+		for _, a := range f.List {
+			if a.Kind == List && len(a.List) == 4 &&
+				isNString(a.List[0]) && isNString(a.List[1]) && isNString(a.List[2]) && isNString(a.List[3]) {
+				addrs = append(addrs, a)
+				continue
+			}
+			if err := p.violation("invalid ENVELOPE address %s", a); err != nil {
+				return env, err
+			}
+			if a.Kind != List {
+				continue
+			}
+			a.List = fitNils(a.List, 4)
+			for j, v := range a.List {
+				a.List[j] = toNString(v)
+			}
+			addrs = append(addrs, a)
+		}
+		if len(addrs) == 0 {
+			if len(f.List) == 0 {
+				if err := p.violation("ENVELOPE addresses %d is () instead of NIL", i); err != nil {
+					return env, err
+				}
+			}
+			env.List[i] = Value{Kind: Nil}
+			continue
+		}
+		env.List[i].List = addrs
+	}
+	return env, nil
+}
+
+// fitNils returns the first n values of vv, padded with NILs.
+func fitNils(vv []Value, n int) []Value {
+	if len(vv) >= n {
+		return vv[:n]
+	}
+	for len(vv) < n {
+		vv = append(vv, Value{Kind: Nil})
+	}
+	return vv
+}
+
+// toNString returns the nstring of v: the atoms quoted, the lists NIL.
+func toNString(v Value) Value {
+	switch v.Kind {
+	case Atom:
+		return Value{Kind: Quoted, Text: v.Text}
+	case List:
+		return Value{Kind: Nil}
+	}
+	return v
+}
+
+func isNString(v Value) bool { return v.Kind == Nil || v.Kind == Quoted || v.Kind == Literal }
+
+// skipSpaces skips the extra spaces.
+func (p *Parser) skipSpaces() error {
+	for {
+		if b, err := p.peek(); err != nil || b != ' ' {
+			return p.unexpectedEOF(err)
+		}
+		if err := p.violation("extra space"); err != nil {
+			return err
+		}
+		p.readByte()
+	}
+}
+
+// readValue reads an atom, NIL, a quoted string, a literal or a list.
+func (p *Parser) readValue(inCode bool, depth int) (Value, error) {
+	b, err := p.peek()
+	if err != nil {
+		return Value{}, p.unexpectedEOF(err)
+	}
+	switch b {
+	case '(':
+		if depth >= p.limits.MaxDepth {
+			return Value{}, p.tooLarge("lists nested deeper than %d", p.limits.MaxDepth)
+		}
+		p.readByte()
+		list, err := p.readValues(')', depth+1, nil)
+		return Value{Kind: List, List: list}, err
+	case '"':
+		s, err := p.readQuoted()
+		return Value{Kind: Quoted, Text: s}, err
+	case '{':
+		return p.readLiteral()
+	}
+	s, err := p.readAtom(inCode)
+	if err != nil {
+		return Value{}, err
+	}
+	if strings.EqualFold(s, "NIL") {
+		return Value{Kind: Nil}, nil
+	}
+	return Value{Kind: Atom, Text: s}, nil
+}
+
+// readAtom reads an atom. Out of a response code, the bracketed parts
+// (such as the section of BODY[HEADER.FIELDS (SUBJECT)]) belong to the atom.
+func (p *Parser) readAtom(inCode bool) (string, error) {
+	var buf []byte
+	var inBracket, eightBit bool
+	for {
+		b, err := p.peek()
+		if err != nil {
+			return "", p.unexpectedEOF(err)
+		}
+		if inBracket {
+			if b == '\r' || b == '\n' {
+				return "", p.syntaxError("unterminated [ in atom %q", buf)
+			}
+			inBracket = b != ']'
+		} else {
+			switch b {
+			case ' ', '(', ')', '"', '{', '\r', '\n':
+				if len(buf) == 0 {
+					return "", p.syntaxError("unexpected %q", b)
+				}
+				return string(buf), nil
+			case ']':
+				if inCode {
+					if len(buf) == 0 {
+						return "", p.syntaxError("unexpected %q", b)
+					}
+					return string(buf), nil
+				}
+			case '[':
+				inBracket = !inCode
+			}
+			if b < ' ' || b == 0x7f {
+				return "", p.syntaxError("control character %q in atom", b)
+			}
+		}
+		if b >= 0x80 && !eightBit {
+			if err = p.violation("8-bit character in atom"); err != nil {
+				return "", err
+			}
+			eightBit = true
+		}
+		if len(buf) >= p.limits.MaxToken {
+			return "", p.tooLarge("atom longer than %d", p.limits.MaxToken)
+		}
+		p.readByte()
+		buf = append(buf, b)
+	}
+}
+
+// readQuoted reads a quoted string, without the quotes and the escaping.
+func (p *Parser) readQuoted() (string, error) {
+	p.readByte() // "
+	var buf []byte
+	var eightBit bool
+	for {
+		b, err := p.readByte()
+		if err != nil {
+			return "", p.unexpectedEOF(err)
+		}
+		switch b {
+		case '"':
+			return string(buf), nil
+		case '\r', '\n':
+			return "", p.syntaxError("line ending in quoted string")
+		case '\\':
+			if b, err = p.readByte(); err != nil {
+				return "", p.unexpectedEOF(err)
+			}
+			if b != '"' && b != '\\' {
+				if err = p.violation("invalid escape %q in quoted string", b); err != nil {
+					return "", err
+				}
+				if b == '\r' || b == '\n' {
+					return "", p.syntaxError("line ending in quoted string")
+				}
+				buf = append(buf, '\\')
+			}
+		default:
+			if b >= 0x80 && !eightBit {
+				if err = p.violation("8-bit character in quoted string"); err != nil {
+					return "", err
+				}
+				eightBit = true
+			}
+		}
+		if len(buf) >= p.limits.MaxToken {
+			return "", p.tooLarge("quoted string longer than %d", p.limits.MaxToken)
+		}
+		buf = append(buf, b)
+	}
+}
+
+// readLiteral reads a {size}CRLF literal, and its content unless SkipLiterals.
+func (p *Parser) readLiteral() (Value, error) {
+	p.readByte() // {
+	var digits []byte
+	for {
+		b, err := p.readByte()
+		if err != nil {
+			return Value{}, p.unexpectedEOF(err)
+		}
+		if b == '}' || b == '+' && len(digits) != 0 {
+			if b == '+' {
+				if err = p.expect('}'); err != nil {
+					return Value{}, err
+				}
+			}
+			break
+		}
+		if b < '0' || '9' < b || len(digits) > 18 {
+			return Value{}, p.syntaxError("invalid literal size %q", append(digits, b))
+		}
+		digits = append(digits, b)
+	}
+	size, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return Value{}, p.syntaxError("invalid literal size %q", digits)
+	}
+	if size > p.limits.MaxLiteral {
+		return Value{}, p.tooLarge("literal of %d bytes, more than %d", size, p.limits.MaxLiteral)
+	}
+	if err = p.readEOL(); err != nil {
+		return Value{}, err
+	}
+	v := Value{Kind: Literal, Size: size}
+	var n int64
+	if p.SkipLiterals {
+		var k int
+		k, err = p.r.Discard(int(size))
+		n = int64(k)
+	} else {
+		var buf bytes.Buffer
+		n, err = io.CopyN(&buf, p.r, size)
+		v.Text = buf.String()
+	}
+	p.off += n
+	if err != nil {
+		return v, p.unexpectedEOF(err)
+	}
+	return v, nil
+}
+
+// readText reads the rest of the line.
+func (p *Parser) readText() (string, error) {
+	var buf []byte
+	for {
+		b, err := p.peek()
+		if err != nil {
+			return "", p.unexpectedEOF(err)
+		}
+		if b == '\r' || b == '\n' {
+			return string(buf), p.readEOL()
+		}
+		p.readByte()
+		buf = append(buf, b)
+	}
+}
+
+// readEOL reads the CRLF line ending.
+func (p *Parser) readEOL() error {
+	b, err := p.readByte()
+	if err != nil {
+		return p.unexpectedEOF(err)
+	}
+	if b == '\n' {
+		return p.violation("bare LF line ending")
+	}
+	if b != '\r' {
+		return p.syntaxError("got %q, wanted CRLF", b)
+	}
+	return p.expect('\n')
+}
+
+// expect reads the next byte, which must be want.
+func (p *Parser) expect(want byte) error {
+	b, err := p.readByte()
+	if err != nil {
+		return p.unexpectedEOF(err)
+	}
+	if b != want {
+		return p.syntaxError("got %q, wanted %q", b, want)
+	}
+	return nil
+}
+
+func (p *Parser) readByte() (byte, error) {
+	if p.line >= p.limits.MaxLine {
+		return 0, p.tooLarge("response longer than %d", p.limits.MaxLine)
+	}
+	b, err := p.r.ReadByte()
+	if err == nil {
+		p.off++
+		p.line++
+	}
+	return b, err
+}
+
+// peek returns the next byte without consuming it - or the error of the next readByte,
+// so the loops peeking then reading stop at the MaxLine limit.
+func (p *Parser) peek() (byte, error) {
+	if p.line >= p.limits.MaxLine {
+		return 0, p.tooLarge("response longer than %d", p.limits.MaxLine)
+	}
+	b, err := p.r.ReadByte()
+	if err == nil {
+		p.r.UnreadByte()
+	}
+	return b, err
+}
+
+func (p *Parser) unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// violation returns the ErrSyntax in Strict Mode, else reports it to Warn and returns nil.
+func (p *Parser) violation(format string, args ...any) error {
+	err := p.syntaxError(format, args...)
+	if p.Mode == Strict {
+		return err
+	}
+	if p.Warn != nil {
+		p.Warn(err)
+	}
+	return nil
+}
+
+func (p *Parser) syntaxError(format string, args ...any) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, p.off, fmt.Sprintf(format, args...))
+}
+
+func (p *Parser) tooLarge(format string, args ...any) error {
+	return fmt.Errorf("%w at %d: %s", ErrTooLarge, p.off, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,262 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapparse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var seeds = []string{
+	"* OK [CAPABILITY IMAP4rev1 LITERAL+ AUTH=PLAIN] Dovecot ready.\r\n",
+	"* 3 EXISTS\r\n",
+	"* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)\r\n",
+	"* OK [PERMANENTFLAGS (\\Deleted \\Seen \\*)] Limited\r\n",
+	"* LIST (\\HasNoChildren \\Sent) \"/\" \"Sent Items\"\r\n",
+	"* LIST () NIL INBOX\r\n",
+	"* SEARCH 2 84 882\r\n",
+	"* 12 FETCH (UID 3 FLAGS (\\Seen) RFC822.SIZE 44827 ENVELOPE (\"Wed, 17 Jul 1996 02:23:25 -0700 (PDT)\" " +
+		"\"IMAP4rev1 WG mtg summary\" ((\"Terry Gray\" NIL \"gray\" \"cac.washington.edu\")) NIL NIL NIL NIL NIL NIL " +
+		"\"<B27397-0100000@cac.washington.edu>\"))\r\n",
+	"* 1 FETCH (UID 1 BODY[HEADER.FIELDS (SUBJECT FROM)] {18}\r\nSubject: hello\r\n\r\n)\r\n",
+	"* 1 FETCH (BODY[]<0> {5+}\r\nhello)\r\n",
+	"A1 OK [READ-WRITE] SELECT completed\r\n",
+	"A2 NO\r\n",
+	"+ idling\r\n",
+	"+\r\n",
+	"* BYE\r\n",
+	"* 2 FETCH (X-GM-LABELS (\"\\\\Important\" \"a \\\"quoted\\\" label\"))\r\n",
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		In   string
+		Want Response
+	}{
+		{"* 3 EXISTS\r\n", Response{Tag: "*", Fields: []Value{{Kind: Atom, Text: "3"}, {Kind: Atom, Text: "EXISTS"}}}},
+		{"A1 ok [UIDVALIDITY 3857529045] UIDs valid\r\n", Response{Tag: "A1", Status: "OK",
+			Code: []Value{{Kind: Atom, Text: "UIDVALIDITY"}, {Kind: Atom, Text: "3857529045"}}, Text: "UIDs valid"}},
+		{"* LIST () NIL \"a \\\"b\\\"\"\r\n", Response{Tag: "*", Fields: []Value{
+			{Kind: Atom, Text: "LIST"}, {Kind: List}, {Kind: Nil}, {Kind: Quoted, Text: `a "b"`}}}},
+		{"* 1 FETCH (BODY[HEADER.FIELDS (SUBJECT)] {3}\r\nabc)\r\n", Response{Tag: "*", Fields: []Value{
+			{Kind: Atom, Text: "1"}, {Kind: Atom, Text: "FETCH"}, {Kind: List, List: []Value{
+				{Kind: Atom, Text: "BODY[HEADER.FIELDS (SUBJECT)]"}, {Kind: Literal, Text: "abc", Size: 3}}}}}},
+		{"+ go ahead\r\n", Response{Tag: "+", Text: "go ahead"}},
+		{"A2 OK [] done\r\n", Response{Tag: "A2", Status: "OK", Code: []Value{}, Text: "done"}},
+	} {
+		got, err := Parse([]byte(tc.In))
+		if err != nil {
+			t.Errorf("%q: %+v", tc.In, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("%q: got %#v, wanted %#v", tc.In, got, tc.Want)
+		}
+		if s := got.String(); s != tc.In && !strings.EqualFold(s, tc.In) {
+			t.Errorf("%q: String=%q", tc.In, s)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		In   string
+		Want error
+	}{
+		{"", io.EOF},
+		{"* 1 FETCH (UID 1", io.ErrUnexpectedEOF},
+		{"* 1 FETCH (BODY[] {5}\r\nab", io.ErrUnexpectedEOF},
+		{"* 1 FETCH (UID 1))\r\n", ErrSyntax},
+		{"* \"unterminated\r\n", ErrSyntax},
+		{"* \"bad \\escape\"\r\n", ErrSyntax},
+		{"* {99999999999999999999}\r\n", ErrSyntax},
+		{"* {-1}\r\n", ErrSyntax},
+		{"* {600000000}\r\n", ErrTooLarge},
+		{"A1 FETCH\r\n", ErrSyntax},
+		{"* OK [UNTERMINATED\r\n", ErrSyntax},
+		{"* 1 FETCH (BODY[HEADER\r\n", ErrSyntax},
+		{"* " + strings.Repeat("(", 100) + "\r\n", ErrTooLarge},
+		{"* " + strings.Repeat("x", 2<<20) + "\r\n", ErrTooLarge},
+		{"* OK " + strings.Repeat("x", 65<<20) + "\r\n", ErrTooLarge},
+		{"* a\x00b\r\n", ErrSyntax},
+		{"* a\n", ErrSyntax},
+		{"* 1 FETCH (UID 1)(FLAGS ())\r\n", ErrSyntax},
+		{"* 1 FETCH ( UID 1)\r\n", ErrSyntax},
+		{"* SEARCH 1 2 \r\n", ErrSyntax},
+		{"* OK [ALERT]text\r\n", ErrSyntax},
+		{"* LIST () \"/\" \"b\xe9\"\r\n", ErrSyntax},
+		{"* 1 FETCH (ENVELOPE (NIL NIL () NIL NIL NIL NIL NIL NIL NIL))\r\n", ErrSyntax},
+		{"* 1 FETCH (ENVELOPE (NIL NIL NIL NIL))\r\n", ErrSyntax},
+		{"* 1 FETCH (ENVELOPE (NIL NIL ((NIL NIL \"a\")) NIL NIL NIL NIL NIL NIL NIL))\r\n", ErrSyntax},
+	} {
+		p := NewParser(strings.NewReader(tc.In))
+		p.Mode = Strict
+		_, err := p.ReadResponse()
+		if !errors.Is(err, tc.Want) {
+			t.Errorf("%.40q: got %+v, wanted %v", tc.In, err, tc.Want)
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	for _, tc := range []struct {
+		In, Want string
+		Warnings int
+	}{
+		{"* 1 FETCH (UID 1)(FLAGS ())\r\n", "* 1 FETCH (UID 1) (FLAGS ())\r\n", 1},
+		{"* 1 FETCH (  UID 1 )\r\n", "* 1 FETCH (UID 1)\r\n", 3},
+		{"*  SEARCH 1 2 \n", "* SEARCH 1 2\r\n", 3},
+		{"* OK [ALERT]text\r\n", "* OK [ALERT] text\r\n", 1},
+		{"* LIST () \"/\" \"b\xe9 \\d\"\r\n", "* LIST () \"/\" \"b\xe9 \\\\d\"\r\n", 2},
+		{"* 1 FETCH (ENVELOPE (NIL NIL () NIL NIL NIL NIL NIL NIL NIL))\r\n",
+			"* 1 FETCH (ENVELOPE (NIL NIL NIL NIL NIL NIL NIL NIL NIL NIL))\r\n", 1},
+		{"* 1 FETCH (ENVELOPE (NIL \"s\" NIL NIL))\r\n",
+			"* 1 FETCH (ENVELOPE (NIL \"s\" NIL NIL NIL NIL NIL NIL NIL NIL))\r\n", 1},
+		{"* 1 FETCH (ENVELOPE (Today (\"s\") \"a\" NIL NIL NIL NIL NIL NIL NIL))\r\n",
+			"* 1 FETCH (ENVELOPE (\"Today\" NIL NIL NIL NIL NIL NIL NIL NIL NIL))\r\n", 3},
+		{"* 1 FETCH (ENVELOPE (NIL NIL ((NIL NIL \"a\") x (NIL NIL b \"h\")) NIL NIL NIL NIL NIL NIL NIL))\r\n",
+			"* 1 FETCH (ENVELOPE (NIL NIL ((NIL NIL \"a\" NIL) (NIL NIL \"b\" \"h\")) NIL NIL NIL NIL NIL NIL NIL))\r\n", 3},
+	} {
+		var warnings []error
+		p := NewParser(strings.NewReader(tc.In))
+		p.Warn = func(err error) { warnings = append(warnings, err) }
+		resp, err := p.ReadResponse()
+		if err != nil {
+			t.Errorf("%q: %+v", tc.In, err)
+			continue
+		}
+		if got := resp.String(); got != tc.Want {
+			t.Errorf("%q: got %q, wanted %q", tc.In, got, tc.Want)
+		}
+		if len(warnings) != tc.Warnings {
+			t.Errorf("%q: got %d warnings %q, wanted %d", tc.In, len(warnings), warnings, tc.Warnings)
+		}
+		for _, w := range warnings {
+			if !errors.Is(w, ErrSyntax) {
+				t.Errorf("%q: warning %v is not ErrSyntax", tc.In, w)
+			}
+		}
+	}
+}
+
+func TestAppendPortable(t *testing.T) {
+	for _, tc := range []struct {
+		In, Want string
+	}{
+		{"A1 OK\r\n", "A1 OK \r\n"},
+		{"A1 OK [READ-WRITE]\r\n", "A1 OK [READ-WRITE] \r\n"},
+		{"+\r\n", "+ \r\n"},
+		{"* LIST () \"/\" \"b\xe9\"\r\n", "* LIST () \"/\" {2}\r\nb\xe9\r\n"},
+		{"* 1 FETCH (ENVELOPE (NIL \"\xe1rv\xedz\" NIL NIL NIL NIL NIL NIL NIL NIL))\r\n",
+			"* 1 FETCH (ENVELOPE (NIL {5}\r\n\xe1rv\xedz NIL NIL NIL NIL NIL NIL NIL NIL))\r\n"},
+		{"* 3 EXISTS\r\n", "* 3 EXISTS\r\n"},
+	} {
+		resp, err := Parse([]byte(tc.In))
+		if err != nil {
+			t.Errorf("%q: %+v", tc.In, err)
+			continue
+		}
+		got := string(resp.AppendPortable(nil))
+		if got != tc.Want {
+			t.Errorf("%q: got %q, wanted %q", tc.In, got, tc.Want)
+		}
+		again, err := Parse([]byte(got))
+		if err != nil {
+			t.Errorf("%q: parse of %q: %+v", tc.In, got, err)
+		} else if string(again.AppendPortable(nil)) != got {
+			t.Errorf("%q: %q is not stable", tc.In, got)
+		}
+	}
+}
+
+func TestParserStream(t *testing.T) {
+	p := NewParser(strings.NewReader(strings.Join(seeds, "")))
+	p.SkipLiterals = true
+	p.Limits.MaxLine = 1 << 10
+	for i := range seeds {
+		resp, err := p.ReadResponse()
+		if err != nil {
+			t.Fatalf("%d. %q: %+v", i, seeds[i], err)
+		}
+		t.Log(resp.String())
+	}
+	if _, err := p.ReadResponse(); err != io.EOF {
+		t.Errorf("got %v, wanted EOF", err)
+	}
+}
+
+// FuzzParse checks that the parser does not panic, respects the limits,
+// and that the String of the parsed response is parsed to the same response,
+// and its AppendPortable is parsed, too.
+func FuzzParse(f *testing.F) {
+	for _, s := range seeds {
+		f.Add([]byte(s))
+	}
+	limits := Limits{MaxLiteral: 1 << 10, MaxToken: 64, MaxLine: 4 << 10, MaxDepth: 8}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var warnings int
+		p := NewParser(strings.NewReader(string(b)))
+		p.Limits = limits
+		p.Warn = func(error) { warnings++ }
+		resp, err := p.ReadResponse()
+		if err != nil {
+			return
+		}
+		// What Strict accepts, Lenient accepts the same way, without warnings.
+		p = NewParser(strings.NewReader(string(b)))
+		p.Limits, p.Mode = limits, Strict
+		if strict, err := p.ReadResponse(); err == nil {
+			if warnings != 0 {
+				t.Errorf("%q: accepted by Strict, %d warnings by Lenient", b, warnings)
+			}
+			if !reflect.DeepEqual(resp, strict) {
+				t.Errorf("%q: Strict got %#v, Lenient %#v", b, strict, resp)
+			}
+		}
+		checkLimits(t, limits, resp.Code, 0)
+		checkLimits(t, limits, resp.Fields, 0)
+
+		s := resp.String()
+		p = NewParser(strings.NewReader(s))
+		p.Limits = Limits{MaxLine: 2 * len(s)}
+		again, err := p.ReadResponse()
+		if err != nil {
+			t.Fatalf("%q: parse of %q: %+v", b, s, err)
+		}
+		if !reflect.DeepEqual(resp, again) {
+			t.Errorf("%q: got %#v, then %#v", b, resp, again)
+		}
+
+		portable := resp.AppendPortable(nil)
+		p = NewParser(strings.NewReader(string(portable)))
+		p.Limits = Limits{MaxLine: 2 * len(portable)}
+		if _, err = p.ReadResponse(); err != nil {
+			t.Fatalf("%q: parse of %q: %+v", b, portable, err)
+		}
+	})
+}
+
+func checkLimits(t *testing.T, limits Limits, vv []Value, depth int) {
+	if depth > limits.MaxDepth {
+		t.Fatalf("depth %d > %d", depth, limits.MaxDepth)
+	}
+	for _, v := range vv {
+		switch v.Kind {
+		case Atom, Quoted:
+			if len(v.Text) > limits.MaxToken {
+				t.Fatalf("%s of %d bytes > %d", v.Kind, len(v.Text), limits.MaxToken)
+			}
+		case Literal:
+			if v.Size > limits.MaxLiteral || int64(len(v.Text)) != v.Size {
+				t.Fatalf("literal of %d (%d) bytes > %d", v.Size, len(v.Text), limits.MaxLiteral)
+			}
+		case List:
+			checkLimits(t, limits, v.List, depth+1)
+		}
+	}
+}
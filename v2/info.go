@@ -0,0 +1,168 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// infoItems are the FETCH items of a MessageInfo.
+const infoItems = "UID RFC822.SIZE INTERNALDATE FLAGS ENVELOPE"
+
+// MessageInfo is the metadata of a message, without its body.
+type MessageInfo struct {
+	InternalDate time.Time
+	Date         time.Time
+	// SaveDate is the time the message was saved into its mailbox (RFC 8514 SAVEDATE,
+	// or X-SAVEDATE of Dovecot) - zero if the server does not support it.
+	SaveDate  time.Time
+	Subject   string
+	MessageID string
+	// EmailID and ThreadID are the OBJECTID (RFC 8474) of the message and its thread,
+	// empty if the server does not support it. The EmailID survives the moves of the message.
+	EmailID  string
+	ThreadID string
+	From     []string
+	To       []string
+	Flags    []string
+	Size     int64
+	UID      uint32
+	// c is the session, and mailbox is the selected mailbox the message has been fetched from, see Open.
+	c       Client
+	mailbox string
+}
+
+// ErrNoSession is returned by MessageInfo.Open for a MessageInfo not returned by FetchInfo.
+var ErrNoSession = errors.New("no session of the message")
+
+// Open returns the body of the message, fetched on demand with the Client it has been fetched by
+// (FetchInfo, ListInfo, Messages) - re-selecting its mailbox, if another one has been selected since.
+//
+// The Client must not be used for anything else till the returned ReadCloser is read to its end, or closed.
+func (mi MessageInfo) Open(ctx context.Context) (io.ReadCloser, error) {
+	if mi.c == nil {
+		return nil, ErrNoSession
+	}
+	if mi.mailbox != "" {
+		if s, ok := As[SelectedStatuser](mi.c); ok {
+			if st, ok := s.SelectedStatus(); !ok || st.Name != mi.mailbox {
+				if err := mi.c.Select(ctx, mi.mailbox); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := mi.c.ReadTo(ctx, pw, mi.UID)
+		pw.CloseWithError(err)
+	}()
+	return &bodyReader{PipeReader: pr, done: done}, nil
+}
+
+// bodyReader is the body of a message being read in the background.
+type bodyReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+// Close stops the reading, and waits for it to finish, so the session is free again.
+func (r *bodyReader) Close() error {
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
+
+// StableID returns an ID of the message which survives its moves, unlike the UID:
+// the EmailID if known, the Message-ID otherwise (which may be missing, or shared by copies).
+func (mi MessageInfo) StableID() string {
+	if mi.EmailID != "" {
+		return mi.EmailID
+	}
+	return mi.MessageID
+}
+
+// infoFetchItems returns the FETCH items of the MessageInfo, with the optional items
+// the server of c supports.
+func infoFetchItems(c Client) string {
+	ci, ok := As[ConnectInfoReporter](c)
+	if !ok {
+		return infoItems
+	}
+	info := ci.ConnectInfo()
+	items := infoItems
+	if info.Has("OBJECTID") {
+		items += " EMAILID THREADID"
+	}
+	if info.Has("SAVEDATE") {
+		items += " SAVEDATE"
+	} else if info.Server == "Dovecot" {
+		items += " X-SAVEDATE"
+	}
+	return items
+}
+
+// FetchInfo returns the MessageInfo of the messages, in the order of the UIDs;
+// the missing messages are skipped. Their body can be read with Open.
+//
+// SAVEDATE, EMAILID and THREADID are fetched only if the server advertises them.
+func FetchInfo(ctx context.Context, c Client, uids ...uint32) ([]MessageInfo, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	m, err := c.FetchArgs(ctx, infoFetchItems(c), uids...)
+	var mailbox string
+	if s, ok := As[SelectedStatuser](c); ok {
+		if st, ok := s.SelectedStatus(); ok {
+			mailbox = st.Name
+		}
+	}
+	infos := make([]MessageInfo, 0, len(uids))
+	for _, uid := range uids {
+		if args, ok := m[uid]; ok {
+			mi := messageInfo(uid, args)
+			mi.c, mi.mailbox = c, mailbox
+			infos = append(infos, mi)
+		}
+	}
+	return infos, err
+}
+
+// messageInfo returns the MessageInfo from the result of FetchArgs.
+func messageInfo(uid uint32, args map[string][]string) MessageInfo {
+	first := func(k string) string {
+		if vv := args[k]; len(vv) != 0 {
+			return vv[0]
+		}
+		return ""
+	}
+	mi := MessageInfo{
+		UID:       uid,
+		Subject:   first("ENVELOPE.SUBJECT"),
+		MessageID: first("ENVELOPE.MESSAGE-ID"),
+		EmailID:   first("EMAILID"),
+		ThreadID:  first("THREADID"),
+		From:      args["ENVELOPE.FROM"],
+		To:        args["ENVELOPE.TO"],
+		Flags:     args["FLAGS"],
+	}
+	mi.Size, _ = strconv.ParseInt(first("RFC822.SIZE"), 10, 64)
+	mi.InternalDate, _ = time.Parse(time.RFC3339, first("INTERNALDATE"))
+	mi.Date, _ = time.Parse(time.RFC3339, first("ENVELOPE.DATE"))
+	saveDate := first("SAVEDATE")
+	if saveDate == "" {
+		saveDate = first("X-SAVEDATE")
+	}
+	mi.SaveDate, _ = time.Parse(imap.DateTimeLayout, saveDate)
+	return mi
+}
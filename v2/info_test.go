@@ -0,0 +1,86 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+type infoClient struct {
+	Client
+	info ConnectInfo
+}
+
+func (c infoClient) ConnectInfo() ConnectInfo { return c.info }
+
+func TestMessageInfoObjectID(t *testing.T) {
+	for _, tc := range []struct {
+		Info ConnectInfo
+		Want string
+	}{
+		{ConnectInfo{}, infoItems},
+		{ConnectInfo{Capabilities: []string{"IMAP4rev1", "OBJECTID", "SAVEDATE"}}, infoItems + " EMAILID THREADID SAVEDATE"},
+		{ConnectInfo{Capabilities: []string{"IMAP4rev1"}, Server: "Dovecot"}, infoItems + " X-SAVEDATE"},
+	} {
+		if got := infoFetchItems(infoClient{info: tc.Info}); got != tc.Want {
+			t.Errorf("%+v: got %q, wanted %q", tc.Info, got, tc.Want)
+		}
+	}
+
+	mi := messageInfo(3, map[string][]string{
+		"ENVELOPE.MESSAGE-ID": {"<a@b>"},
+		"EMAILID":             {"M6d99ac3275bb4e"},
+		"THREADID":            {"T64b478a75b7ea9"},
+		"X-SAVEDATE":          {"17-Jul-1996 02:44:25 -0700"},
+	})
+	if mi.EmailID != "M6d99ac3275bb4e" || mi.ThreadID != "T64b478a75b7ea9" || mi.StableID() != mi.EmailID {
+		t.Errorf("got %+v", mi)
+	}
+	if want := time.Date(1996, 7, 17, 9, 44, 25, 0, time.UTC); !mi.SaveDate.Equal(want) {
+		t.Errorf("got SaveDate %s, wanted %s", mi.SaveDate, want)
+	}
+	if mi = messageInfo(3, map[string][]string{"ENVELOPE.MESSAGE-ID": {"<a@b>"}}); mi.StableID() != "<a@b>" || !mi.SaveDate.IsZero() {
+		t.Errorf("got %+v", mi)
+	}
+}
+
+func TestMessageInfoOpen(t *testing.T) {
+	ctx := context.Background()
+	c := &contentClient{boxes: map[string]map[uint32]string{"INBOX": {1: "Subject: a\r\n\r\na\r\n", 2: "Subject: b\r\n\r\nb\r\n"}}}
+	infos, err := ListInfo(ctx, c, "INBOX", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %+v", infos)
+	}
+	r, err := infos[1].Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Error(err)
+	}
+	if string(b) != "Subject: b\r\n\r\nb\r\n" {
+		t.Errorf("got %q", b)
+	}
+	// Closing before reading to the end frees the session.
+	if r, err = infos[0].Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if _, err = (MessageInfo{UID: 1}).Open(ctx); !errors.Is(err, ErrNoSession) {
+		t.Errorf("got %+v, wanted ErrNoSession", err)
+	}
+}
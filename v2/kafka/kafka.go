@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kafka publishes the messages to Kafka, as the DeliverFunc of a delivery loop:
+//
+//	cl, err := kgo.NewClient(kgo.SeedBrokers("localhost:9092"))
+//	if err != nil {
+//		return err
+//	}
+//	defer cl.Close()
+//	err = imapclient.DeliveryLoop(ctx, c, "INBOX", "", kafka.DeliverFunc(cl, "mails"), "", "", logger)
+package kafka
+
+import (
+	"context"
+
+	"github.com/tgulacsi/imapclient/v2"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Producer is the part of *kgo.Client used by Publisher.
+type Producer interface {
+	ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults
+}
+
+var _ Producer = (*kgo.Client)(nil)
+
+// Publisher is an imapclient.Publisher producing records with the Producer.
+//
+// Publish returns after the broker has acknowledged the record - with the acks
+// configured in the client, all in-sync replicas by default.
+type Publisher struct {
+	Producer Producer
+}
+
+var _ imapclient.Publisher = Publisher{}
+
+// Publish the data as a record to the topic, with the headers.
+//
+// The key of the record is the hash of the message (the imapclient.PublishHashHeader),
+// so the copies of the same message get into the same partition.
+func (p Publisher) Publish(ctx context.Context, topic string, headers map[string][]string, data []byte) error {
+	rec := kgo.Record{Topic: topic, Value: data}
+	if h := headers[imapclient.PublishHashHeader]; len(h) != 0 {
+		rec.Key = []byte(h[0])
+	}
+	for k, vv := range headers {
+		for _, v := range vv {
+			rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+		}
+	}
+	return p.Producer.ProduceSync(ctx, &rec).FirstErr()
+}
+
+// DeliverFunc returns the imapclient.DeliverFunc publishing the messages to the topic.
+func DeliverFunc(p Producer, topic string) imapclient.DeliverFunc {
+	return imapclient.PublishDeliverFunc(Publisher{Producer: p}, topic)
+}
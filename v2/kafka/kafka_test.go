@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tgulacsi/imapclient/v2"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type fakeProducer struct {
+	records []*kgo.Record
+	err     error
+}
+
+func (p *fakeProducer) ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults {
+	p.records = append(p.records, rs...)
+	var res kgo.ProduceResults
+	for _, r := range rs {
+		res = append(res, kgo.ProduceResult{Record: r, Err: p.err})
+	}
+	return res
+}
+
+func TestDeliverFunc(t *testing.T) {
+	ctx := context.Background()
+	const msg = "Message-ID: <1@x>\r\nSubject: a\r\n\r\nbody"
+	hsh := imapclient.NewHash()
+	hsh.Write([]byte(msg))
+	p := &fakeProducer{}
+	if err := DeliverFunc(p, "mails")(ctx, strings.NewReader(msg), 1, hsh.Array()); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.records) != 1 {
+		t.Fatalf("got %d records, wanted 1", len(p.records))
+	}
+	rec := p.records[0]
+	if rec.Topic != "mails" || string(rec.Value) != msg || string(rec.Key) != hsh.Array().String() {
+		t.Errorf("got %q %q key=%q", rec.Topic, rec.Value, rec.Key)
+	}
+	got := make(map[string]string)
+	for _, h := range rec.Headers {
+		got[h.Key] = string(h.Value)
+	}
+	if got[imapclient.PublishUIDHeader] != "1" || got["Message-Id"] != "<1@x>" {
+		t.Errorf("headers: %v", got)
+	}
+
+	p.err = errors.New("not acknowledged")
+	if err := DeliverFunc(p, "mails")(ctx, strings.NewReader(msg), 1, hsh.Array()); !errors.Is(err, p.err) {
+		t.Errorf("got %+v, wanted %v", err, p.err)
+	}
+}
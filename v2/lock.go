@@ -0,0 +1,349 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locker is a distributed lock, for running more instances of a worker,
+// with only one of them processing a mailbox at a time.
+//
+// With etcd (go.etcd.io/etcd/client/v3/concurrency):
+//
+//	func (l etcdLocker) Lock(ctx context.Context, name string) (context.Context, func(), error) {
+//		sess, err := concurrency.NewSession(l.cli, concurrency.WithTTL(10))
+//		if err != nil {
+//			return nil, nil, err
+//		}
+//		mu := concurrency.NewMutex(sess, "/imapclient/"+name)
+//		if err = mu.Lock(ctx); err != nil {
+//			sess.Close()
+//			return nil, nil, err
+//		}
+//		lockCtx, cancel := context.WithCancel(ctx)
+//		go func() { <-sess.Done(); cancel() }()
+//		return lockCtx, func() { mu.Unlock(context.Background()); sess.Close(); cancel() }, nil
+//	}
+//
+// For the lease-based stores (such as Redis, with SET NX PX), implement a Lease,
+// and use NewLeaseLocker.
+type Locker interface {
+	// Lock blocks till the lock of name is acquired, or ctx is done.
+	// The returned context is canceled when the lock is lost,
+	// unlock releases the lock.
+	Lock(ctx context.Context, name string) (lockCtx context.Context, unlock func(), err error)
+}
+
+// RunLocked runs f while holding the lock of name, till ctx is done.
+//
+// When the lock is lost, the context of f is canceled, and RunLocked tries
+// to acquire the lock again - meanwhile another instance takes over.
+// f should return when its context is canceled, as DeliveryLoop does:
+//
+//	imapclient.RunLocked(ctx, locker, "INBOX", func(ctx context.Context) error {
+//		return imapclient.DeliveryLoop(ctx, c, "INBOX", "", deliver, "", "", logger)
+//	}, logger)
+func RunLocked(ctx context.Context, locker Locker, name string, f func(context.Context) error, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("lock", name)
+	for {
+		lockCtx, unlock, err := locker.Lock(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Error("lock", "error", err)
+			if !sleepCtx(ctx, ShortSleep) {
+				return nil
+			}
+			continue
+		}
+		logger.Info("acquired")
+		err = f(lockCtx)
+		unlock()
+		if ctx.Err() != nil {
+			return err
+		}
+		if err == nil {
+			logger.Warn("lost")
+			continue
+		}
+		logger.Error("run", "error", err)
+		if !sleepCtx(ctx, ShortSleep) {
+			return nil
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	}
+}
+
+// Lease is a lock with a time limit, which must be renewed by its owner.
+type Lease interface {
+	// Acquire acquires the lease of name for owner for ttl, or renews it
+	// if owner holds it. Reports false if another owner holds it.
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// Release releases the lease, if owner holds it.
+	Release(ctx context.Context, name, owner string) error
+}
+
+// NewLeaseLocker returns a Locker which acquires the lease for ttl,
+// and renews it at every third of ttl.
+//
+// Lock polls the lease at every third of ttl while another owner holds it,
+// so the failover after the crash of the owner takes at most ttl plus a third of it.
+func NewLeaseLocker(lease Lease, owner string, ttl time.Duration, logger *slog.Logger) Locker {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &leaseLocker{lease: lease, owner: owner, ttl: ttl, logger: logger.With("owner", owner)}
+}
+
+type leaseLocker struct {
+	lease  Lease
+	logger *slog.Logger
+	owner  string
+	ttl    time.Duration
+}
+
+func (l *leaseLocker) Lock(ctx context.Context, name string) (context.Context, func(), error) {
+	period := l.ttl / 3
+	ticker := time.NewTicker(period)
+	for {
+		ok, err := l.lease.Acquire(ctx, name, l.owner, l.ttl)
+		if err != nil {
+			l.logger.Warn("acquire", "name", name, "error", err)
+		} else if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return nil, nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		defer cancel()
+		last := time.Now()
+		for {
+			select {
+			case <-lockCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ok, err := l.lease.Acquire(lockCtx, name, l.owner, l.ttl)
+			if err == nil && !ok {
+				l.logger.Error("lease taken over", "name", name)
+				return
+			} else if err == nil {
+				last = time.Now()
+			} else if time.Since(last) >= l.ttl-period {
+				// The lease may expire before the next try.
+				l.logger.Error("renew", "name", name, "error", err)
+				return
+			} else {
+				l.logger.Warn("renew", "name", name, "error", err)
+			}
+		}
+	}()
+	var once sync.Once
+	return lockCtx, func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := l.lease.Release(ctx, name, l.owner); err != nil {
+				l.logger.Warn("release", "name", name, "error", err)
+			}
+		})
+	}, nil
+}
+
+// LockKeywordPrefix is the prefix of the keyword the IMAP Lease marks its lock message with:
+// the keyword of the owner holding the lease.
+const LockKeywordPrefix = "$Lock-"
+
+// LockBeatKeyword is toggled on the lock message by each renewal of the IMAP Lease,
+// so its MODSEQ changes.
+const LockBeatKeyword = "$LockBeat"
+
+// NewIMAPLease returns a Lease which keeps its state on a lock message in the mailbox:
+// the owner of the lease is in a keyword of the message (one per owner),
+// which is changed with conditional STORE (CONDSTORE, RFC 7162) only.
+//
+// Each renewal toggles LockBeatKeyword, so the MODSEQ of the lock message changes:
+// the lease is expired when its MODSEQ has not changed for ttl, as seen by the other owners.
+// So the expiry does not depend on the clocks of the owners, and the number of the keywords
+// on the mailbox is bounded by the number of the owners.
+//
+// The Client must be connected, used only by the Lease,
+// and the server must support CONDSTORE.
+// The mailbox should not be a processed one, not to deliver the lock messages.
+func NewIMAPLease(c Client, mailbox string) Lease {
+	return &imapLease{c: c, mailbox: mailbox, now: time.Now, seen: make(map[string]leaseSeen)}
+}
+
+type imapLease struct {
+	c       Client
+	now     func() time.Time
+	seen    map[string]leaseSeen
+	mailbox string
+	mu      sync.Mutex
+}
+
+// leaseSeen is the MODSEQ of the lock message held by another owner, and when it was first seen.
+type leaseSeen struct {
+	since  time.Time
+	modSeq uint64
+}
+
+func lockSubject(name string) string { return "imapclient lock " + name }
+
+// lockMessage returns the UID of the lock message of name, creating it if needed.
+//
+// It is searched without the SearchWindow of the Client, which could hide it.
+func (l *imapLease) lockMessage(ctx context.Context, name string) (uint32, error) {
+	subject := lockSubject(name)
+	for i := 0; i < 2; i++ {
+		uids, err := listUnbounded(ctx, l.c, l.mailbox, subject, true)
+		if err != nil {
+			return 0, err
+		}
+		if len(uids) != 0 {
+			attrs, err := l.c.FetchArgs(ctx, "ENVELOPE", uids...)
+			if err != nil {
+				return 0, err
+			}
+			// The first exact match wins, if more were created concurrently.
+			slices.Sort(uids)
+			for _, uid := range uids {
+				if s := attrs[uid]["ENVELOPE.SUBJECT"]; len(s) != 0 && s[0] == subject {
+					return uid, nil
+				}
+			}
+		}
+		if i == 0 {
+			now := time.Now()
+			msg := "From: imapclient\r\nSubject: " + subject + "\r\nDate: " + now.Format(time.RFC1123Z) +
+				"\r\n\r\nThe flags of this message hold the lock of " + name + ".\r\n"
+			if err = l.c.WriteTo(ctx, l.mailbox, []byte(msg), now); err != nil {
+				return 0, fmt.Errorf("create lock message: %w", err)
+			}
+		}
+	}
+	return 0, fmt.Errorf("lock message %q not found", subject)
+}
+
+// lockKeyword returns the keyword of owner, holding the lease.
+func lockKeyword(owner string) string {
+	return LockKeywordPrefix + strings.TrimPrefix(ClaimKeyword(owner), ClaimKeywordPrefix)
+}
+
+func parseLockKeyword(kw string) (owner string, ok bool) {
+	if len(kw) <= len(LockKeywordPrefix) || !strings.EqualFold(kw[:len(LockKeywordPrefix)], LockKeywordPrefix) {
+		return "", false
+	}
+	return kw[len(LockKeywordPrefix):], true
+}
+
+func (l *imapLease) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cs, ok := As[condStorer](l.c)
+	if !ok {
+		return false, fmt.Errorf("%T: conditional STORE: %w", l.c, errors.ErrUnsupported)
+	}
+	uid, err := l.lockMessage(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	flags, modSeq, ok, err := cs.flagsModSeq(ctx, uid)
+	if err != nil {
+		return false, err
+	} else if !ok {
+		return false, fmt.Errorf("lock message %d disappeared", uid)
+	}
+	me, _ := parseLockKeyword(lockKeyword(owner))
+	var other, beat bool
+	keep := make([]string, 0, len(flags)+2)
+	for _, f := range flags {
+		if strings.EqualFold(f, LockBeatKeyword) {
+			beat = true
+		} else if o, ok := parseLockKeyword(f); !ok {
+			keep = append(keep, f)
+		} else if !strings.EqualFold(o, me) {
+			other = true
+		}
+	}
+	if other {
+		now := l.now()
+		if seen, ok := l.seen[name]; !ok || seen.modSeq != modSeq {
+			l.seen[name] = leaseSeen{since: now, modSeq: modSeq}
+			return false, nil
+		} else if now.Sub(seen.since) < ttl {
+			return false, nil
+		}
+		// Not renewed for ttl: expired.
+	}
+	delete(l.seen, name)
+	// The other owners' keywords are dropped, and the beat is toggled in the same STORE.
+	keep = append(keep, lockKeyword(owner))
+	if !beat {
+		keep = append(keep, LockBeatKeyword)
+	}
+	return cs.replaceUnchangedSince(ctx, uid, modSeq, keep)
+}
+
+func (l *imapLease) Release(ctx context.Context, name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cs, ok := As[condStorer](l.c)
+	if !ok {
+		return fmt.Errorf("%T: conditional STORE: %w", l.c, errors.ErrUnsupported)
+	}
+	uid, err := l.lockMessage(ctx, name)
+	if err != nil {
+		return err
+	}
+	flags, _, _, err := cs.flagsModSeq(ctx, uid)
+	if err != nil {
+		return err
+	}
+	me, _ := parseLockKeyword(lockKeyword(owner))
+	for _, f := range flags {
+		if o, ok := parseLockKeyword(f); ok && strings.EqualFold(o, me) {
+			if _, err := cs.storeUnchangedSince(ctx, uid, 0, f, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
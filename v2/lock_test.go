@@ -0,0 +1,103 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leaseClient is a fakeClient, whose message 1 is the lock message of "x".
+type leaseClient struct {
+	*fakeClient
+}
+
+func (c leaseClient) FetchArgs(ctx context.Context, what string, uids ...uint32) (map[uint32]map[string][]string, error) {
+	return map[uint32]map[string][]string{1: {"ENVELOPE.SUBJECT": {lockSubject("x")}}}, nil
+}
+
+func TestIMAPLease(t *testing.T) {
+	ctx := context.Background()
+	mb := newFakeMailbox(1)
+	mb.setFlag(1, `\Seen`, true)
+	now := time.Now()
+	clock := func() time.Time { return now }
+	// The SearchWindow of the Clients would hide the lock message.
+	a := NewIMAPLease(leaseClient{&fakeClient{mb: mb, window: SearchWindow{MinUID: 10}}}, "Locks")
+	b := NewIMAPLease(leaseClient{&fakeClient{mb: mb, window: SearchWindow{MinUID: 10}}}, "Locks")
+	a.(*imapLease).now, b.(*imapLease).now = clock, clock
+	lockFlags := func() []string {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		var kws []string
+		for _, f := range mb.flags[1] {
+			if strings.HasPrefix(f, LockKeywordPrefix) {
+				kws = append(kws, f)
+			}
+		}
+		return kws
+	}
+	// keywords collects all the keywords ever set, to check that they are bounded.
+	keywords := make(map[string]struct{})
+	collect := func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		for _, f := range mb.flags[1] {
+			keywords[f] = struct{}{}
+		}
+	}
+
+	if ok, err := a.Acquire(ctx, "x", "a", time.Hour); err != nil || !ok {
+		t.Fatalf("a: %t, %+v", ok, err)
+	}
+	collect()
+	if ok, err := b.Acquire(ctx, "x", "b", time.Hour); err != nil || ok {
+		t.Fatalf("b: %t, %+v", ok, err)
+	}
+	// The renewals toggle the beat, in one STORE each.
+	for i := 0; i < 5; i++ {
+		last := mb.last
+		now = now.Add(20 * time.Minute)
+		if ok, err := a.Acquire(ctx, "x", "a", time.Hour); err != nil || !ok {
+			t.Fatalf("a renew: %t, %+v", ok, err)
+		}
+		collect()
+		if mb.last != last+1 {
+			t.Errorf("renewed with %d STOREs", mb.last-last)
+		}
+		// b sees the changed MODSEQ, so the lease is not expired.
+		if ok, err := b.Acquire(ctx, "x", "b", time.Hour); err != nil || ok {
+			t.Fatalf("b while renewed: %t, %+v", ok, err)
+		}
+	}
+	if kws := lockFlags(); !slices.Equal(kws, []string{LockKeywordPrefix + "a"}) {
+		t.Errorf("renewed: got %q", kws)
+	}
+	if len(keywords) != 3 {
+		t.Errorf("keywords used: %q", keywords)
+	}
+	if flags := mb.flags[1]; flags[0] != `\Seen` {
+		t.Errorf("lost the other flags: %q", flags)
+	}
+
+	// a is not renewed for the ttl.
+	now = now.Add(time.Hour)
+	if ok, err := b.Acquire(ctx, "x", "b", time.Hour); err != nil || !ok {
+		t.Fatalf("b after expiry: %t, %+v", ok, err)
+	}
+	if kws := lockFlags(); !slices.Equal(kws, []string{LockKeywordPrefix + "b"}) {
+		t.Errorf("b: got %q", kws)
+	}
+
+	if err := b.Release(ctx, "x", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.Acquire(ctx, "x", "a", time.Hour); err != nil || !ok {
+		t.Fatalf("a after release: %t, %+v", ok, err)
+	}
+}
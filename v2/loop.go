@@ -13,6 +13,7 @@ import (
 	"hash"
 	"io"
 	"log/slog"
+	"slices"
 	"strconv"
 	"time"
 
@@ -36,15 +37,108 @@ var (
 // If deliver did not returned error, the message is marked as Seen, and if outbox
 // is not empty, then moved to outbox.
 // Except when the error is ErrSkip - then the message is left there as is.
+// deliver can return a *Result (see MoveTo, RetryLater, RejectTo) to choose the Action per message.
+//
+// Marking and moving are retried (see PostDeliveryRetries); a message marked but not moved
+// (flagged with DeliveredKeyword) is delivered again. See WithOutcome for the final state of the messages.
 //
 // deliver is called with the message, UID and hsh.
-func DeliveryLoop(ctx context.Context, c Client, inbox, pattern string, deliver DeliverFunc, outbox, errbox string, logger *slog.Logger) error {
+func DeliveryLoop(ctx context.Context, c Client, inbox, pattern string, deliver DeliverFunc, outbox, errbox string, logger *slog.Logger, opts ...LoopOption) error {
+	o := newLoopOptions(opts)
+	return loop(ctx, o.client(c, logger), inbox, pattern, o.apply(deliver.buffered()), outbox, errbox, logger, o.hooks(outbox, errbox))
+}
+
+// LoopOption is an option of DeliveryLoop and its variants.
+type LoopOption func(*loopOptions)
+
+type loopOptions struct {
+	rules      *Rules
+	snoozeBox  string
+	deliveryID func(uint32, HashArray) string
+	scanner    Scanner
+	decrypters []Decrypter
+	quarantine string
+	partition  string
+	progress   func(uid uint32, read, total int64)
+	maxSize    int64
+	oversize   OversizePolicy
+
+	createMailboxes bool
+	dryRun          bool
+	readOnly        *readOnlyDelivery
+	outcome         func(context.Context, MessageOutcome)
+	control         *LoopControl
+	processing      string
+	parseErrors     *parseErrors
+}
+
+func newLoopOptions(opts []LoopOption) loopOptions {
+	var o loopOptions
+	for _, f := range opts {
+		f(&o)
+	}
+	return o
+}
+
+// apply wraps deliver according to the options.
+func (o loopOptions) apply(deliver readDeliverer) readDeliverer {
+	// First, as it hides the optional interfaces of the Client from the wrapped deliver.
+	if o.progress != nil {
+		deliver = deliver.progressReporting(o.progress)
+	}
+	if o.maxSize > 0 {
+		deliver = deliver.sizeLimited(o.maxSize, o.oversize)
+	}
+	// After sizeLimited, so the headers-only messages are not scanned nor decrypted;
+	// the decrypted message is scanned.
+	if o.scanner != nil {
+		deliver = deliver.scanned(o.scanner, o.quarantine)
+	}
+	if len(o.decrypters) != 0 {
+		deliver = deliver.decrypted(o.decrypters)
+	}
+	if o.snoozeBox != "" {
+		deliver = deliver.snoozing(o.snoozeBox)
+	}
+	if o.deliveryID != nil {
+		deliver = deliver.annotated(o.deliveryID)
+	}
+	if o.partition != "" {
+		deliver = deliver.partitioned(o.partition)
+	}
+	if o.rules != nil {
+		deliver = deliver.routed(o.rules)
+	}
+	return deliver
+}
+
+// roundHook is called at the start of each round of the DeliveryLoop, after Connect.
+type roundHook func(ctx context.Context, c Client, inbox string) error
+
+// hooks returns the loopHooks of the options, for the loop moving to outbox and errbox.
+func (o loopOptions) hooks(outbox, errbox string) loopHooks {
+	hooks := loopHooks{outcome: o.outcome, control: o.control, processing: o.processing,
+		redelivered: make(map[uint32]int)}
+	if o.createMailboxes {
+		hooks.round = append(hooks.round, creator(outbox, errbox, o.snoozeBox, o.quarantine, o.processing))
+	}
+	if o.snoozeBox != "" {
+		hooks.round = append(hooks.round, waker(o.snoozeBox))
+	}
+	return hooks
+}
+
+func loop(ctx context.Context, c Client, inbox, pattern string, deliver readDeliverer, outbox, errbox string, logger *slog.Logger, hooks loopHooks) error {
 	if inbox == "" {
 		inbox = "INBOX"
 	}
 	for {
+		if !hooks.control.begin(ctx) {
+			return nil
+		}
 		// nosemgrep: trailofbits.go.invalid-usage-of-modified-variable.invalid-usage-of-modified-variable
-		n, err := one(ctx, c, inbox, pattern, deliver, outbox, errbox, logger)
+		n, err := one(ctx, c, inbox, pattern, deliver, outbox, errbox, logger, hooks)
+		hooks.control.end(err)
 		if err != nil {
 			logger.Error("DeliveryLoop one round", "count", n, "error", err)
 		} else {
@@ -64,6 +158,10 @@ func DeliveryLoop(ctx context.Context, c Client, inbox, pattern string, deliver
 		delay := time.NewTimer(dur)
 		select {
 		case <-delay.C:
+		case <-hooks.control.woken():
+			if !delay.Stop() {
+				<-delay.C
+			}
 		case <-ctx.Done():
 			if !delay.Stop() {
 				<-delay.C
@@ -91,11 +189,12 @@ func MkDeliverFunc(ctx context.Context, deliver DeliverFunc) DeliverFunc {
 
 // DeliverOne does one round of message reading and delivery. Does not loop.
 // Returns the number of messages delivered.
-func DeliverOne(ctx context.Context, c Client, inbox, pattern string, deliver DeliverFunc, outbox, errbox string, logger *slog.Logger) (int, error) {
+func DeliverOne(ctx context.Context, c Client, inbox, pattern string, deliver DeliverFunc, outbox, errbox string, logger *slog.Logger, opts ...LoopOption) (int, error) {
 	if inbox == "" {
 		inbox = "INBOX"
 	}
-	return one(ctx, c, inbox, pattern, deliver, outbox, errbox, logger)
+	o := newLoopOptions(opts)
+	return one(ctx, o.client(c, logger), inbox, pattern, o.apply(deliver.buffered()), outbox, errbox, logger, o.hooks(outbox, errbox))
 }
 
 // DeliverFunc is the type for message delivery.
@@ -103,58 +202,191 @@ func DeliverOne(ctx context.Context, c Client, inbox, pattern string, deliver De
 // r is the message data, uid is the IMAP server sent message UID, hsh is the message's hash.
 type DeliverFunc func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error
 
-func one(ctx context.Context, c Client, inbox, pattern string, deliver DeliverFunc, outbox, errbox string, logger *slog.Logger) (int, error) {
+// StreamDeliverFunc is the type for streaming message delivery.
+//
+// r is the message data, as it is read from the server - without buffering,
+// uid is the IMAP server sent message UID,
+// hsh is the message's hash, computed on the fly: it is complete only after r has been read till io.EOF.
+// If deliver returns nil without reading r till io.EOF, the rest is read for the hash after it returns.
+type StreamDeliverFunc func(ctx context.Context, r io.Reader, uid uint32, hsh *Hash) error
+
+// StreamDeliveryLoop is like DeliveryLoop, but the messages are not buffered:
+// deliver reads them directly from the FETCH response.
+//
+// Use this for pipelines that can consume the messages without seeking.
+func StreamDeliveryLoop(ctx context.Context, c Client, inbox, pattern string, deliver StreamDeliverFunc, outbox, errbox string, logger *slog.Logger, opts ...LoopOption) error {
+	o := newLoopOptions(opts)
+	return loop(ctx, o.client(c, logger), inbox, pattern, o.apply(deliver.streaming()), outbox, errbox, logger, o.hooks(outbox, errbox))
+}
+
+// StreamDeliverOne is like DeliverOne, but with the streaming semantics of StreamDeliveryLoop.
+func StreamDeliverOne(ctx context.Context, c Client, inbox, pattern string, deliver StreamDeliverFunc, outbox, errbox string, logger *slog.Logger, opts ...LoopOption) (int, error) {
+	if inbox == "" {
+		inbox = "INBOX"
+	}
+	o := newLoopOptions(opts)
+	return one(ctx, o.client(c, logger), inbox, pattern, o.apply(deliver.streaming()), outbox, errbox, logger, o.hooks(outbox, errbox))
+}
+
+// readDeliverer reads the message and delivers it.
+//
+// readErr is returned if reading the message failed, deliverErr if delivering it.
+type readDeliverer func(ctx context.Context, c Client, uid uint32, hsh *Hash) (readErr, deliverErr error)
+
+// buffered reads the whole message into a temp.MemorySlurper before calling deliver.
+func (deliver DeliverFunc) buffered() readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		body := temp.NewMemorySlurper(strconv.FormatUint(uint64(uid), 10))
+		defer body.Close()
+		if _, err := c.ReadTo(ctx, io.MultiWriter(body, hsh), uid); err != nil {
+			return err, nil
+		}
+		return nil, deliver(ctx, body, uid, hsh.Array())
+	}
+}
+
+// errStreamClosed is used to stop the reading of the message when StreamDeliverFunc returns.
+var errStreamClosed = errors.New("stream closed")
+
+// streaming calls deliver with the message as it is read from the server.
+func (deliver StreamDeliverFunc) streaming() readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := c.ReadTo(ctx, pw, uid)
+			pw.CloseWithError(err)
+			done <- err
+		}()
+		err := deliver(ctx, io.TeeReader(pr, hsh), uid, hsh)
+		if err == nil {
+			// Hash the rest of the delivered message, so the settling uses the hash of the whole.
+			// The read error is returned by done.
+			io.Copy(hsh, pr)
+		}
+		// Unblock the reader, if deliver hasn't consumed the message completely.
+		pr.CloseWithError(errStreamClosed)
+		if readErr := <-done; readErr != nil && !errors.Is(readErr, errStreamClosed) {
+			return readErr, nil
+		}
+		return nil, err
+	}
+}
+
+func one(ctx context.Context, c Client, inbox, pattern string, deliver readDeliverer, outbox, errbox string, logger *slog.Logger, hooks loopHooks) (int, error) {
 	logger = logger.With("inbox", inbox)
 	if err := c.Connect(ctx); err != nil {
 		logger.Error("Connecting", "error", err)
 		return 0, fmt.Errorf("connect: %w", err)
 	}
 	defer c.Close(ctx, true)
+	for _, h := range hooks.round {
+		if err := h(ctx, c, inbox); err != nil {
+			logger.Error("round hook", "error", err)
+		}
+	}
 
-	uids, err := c.List(ctx, inbox, pattern, outbox != "" && errbox != "")
+	all := outbox != "" && errbox != ""
+	uids, err := c.List(ctx, inbox, pattern, all)
 	logger.Info("List", "uids", uids, "error", err)
 	if err != nil {
 		return 0, fmt.Errorf("list %v/%v: %w", c, inbox, err)
 	}
+	if outbox != "" && !all {
+		// all lists the Seen messages, too
+		if redo, err := journaled(ctx, c, inbox, pattern, uids); err != nil {
+			logger.Warn("list journaled", "error", err)
+		} else if redo = hooks.capRedeliveries(redo, logger); len(redo) != 0 {
+			logger.Info("deliver again", "uids", redo)
+			uids = append(uids, redo...)
+			slices.Sort(uids)
+		}
+	}
+	if hooks.processing != "" {
+		if uids, err = claimByMove(ctx, c, hooks.processing, uids, logger); err != nil {
+			return 0, err
+		}
+		// the messages not finished are returned to the inbox
+		outbox, errbox = nvl(outbox, inbox), nvl(errbox, inbox)
+	}
 
 	var n int
+	var parts partitions
 	hsh := NewHash()
 	for _, uid := range uids {
 		if err = ctx.Err(); err != nil {
 			return n, err
 		}
+		if hooks.control.stopped() {
+			logger.Info("paused")
+			break
+		}
 		logger := logger.With("uid", uid)
 		hsh.Reset()
-		body := temp.NewMemorySlurper(strconv.FormatUint(uint64(uid), 10))
-		if _, err = c.ReadTo(ctx, io.MultiWriter(body, hsh), uid); err != nil {
-			body.Close()
-			logger.Error("Read", "error", err)
+		var readErr error
+		if readErr, err = deliver(ctx, c, uid, hsh); readErr != nil {
+			logger.Error("Read", "error", readErr)
 			continue
 		}
-
-		err = deliver(ctx, body, uid, hsh.Array())
-		body.Close()
-		if err != nil {
-			logger.Error("deliver", "error", err)
-			if errbox != "" && !errors.Is(err, ErrSkip) {
-				if err = c.Move(ctx, uid, errbox); err != nil {
-					logger.Error("move to", "errbox", errbox, "error", err)
+		res := resultOf(err)
+		switch res.Action {
+		case Retry, Skip, Snoozed:
+			logger.Info("deliver", "action", res.Action, "error", err)
+			out := MessageOutcome{UID: uid, Action: res.Action, Err: err}
+			if res.Action == Skip && hooks.processing != "" {
+				if err := moveStep(ctx, c, logger, uid, inbox, func() error { return c.Move(ctx, uid, inbox) }); err != nil {
+					logger.Error("move back", "inbox", inbox, "error", err)
+				} else {
+					out.Mailbox = inbox
 				}
 			}
+			hooks.report(ctx, out)
+			continue
+		case Handled:
+			logger.Info("deliver", "action", res.Action, "error", err)
+			out := MessageOutcome{UID: uid, Action: Handled, Mailbox: res.Mailbox, Seen: res.seen}
+			if res.Mailbox == "" && !res.deleted && hooks.processing != "" {
+				if err := moveStep(ctx, c, logger, uid, inbox, func() error { return c.Move(ctx, uid, inbox) }); err != nil {
+					logger.Error("move back", "inbox", inbox, "error", err)
+				} else {
+					out.Mailbox = inbox
+				}
+			}
+			hooks.report(ctx, out)
+			continue
+		case Reject:
+			logger.Error("deliver", "error", err)
+			box := nvl(res.Mailbox, errbox)
+			out := reject(ctx, c, uid, box, res.seen, err, logger)
+			if box != "" && out.Mailbox == "" {
+				logger.Error("move to", "errbox", box, "error", out.Err)
+			}
+			hooks.report(ctx, out)
 			continue
 		}
 		n++
-
-		if err = c.Mark(ctx, uid, true); err != nil {
-			logger.Error("mark seen", "error", err)
+		if dc, ok := As[deliveryCounter](c); ok {
+			dc.CountDelivered()
 		}
 
-		if outbox != "" {
-			if err = c.Move(ctx, uid, outbox); err != nil {
-				logger.Error("move to", "outbox", outbox, "error", err)
+		box := nvl(res.Mailbox, outbox)
+		if box != "" && res.partition != "" {
+			if parts == nil {
+				parts = make(partitions)
+			}
+			p, err := parts.mailbox(ctx, c, box, res.partition, time.Now())
+			if err != nil {
+				logger.Error("partition", "outbox", box, "error", err)
+				hooks.report(ctx, MessageOutcome{UID: uid, Action: Delivered, Err: err})
 				continue
 			}
+			box = p
+		}
+		out := settle(ctx, c, uid, box, res.headers, logger)
+		if out.Err != nil {
+			logger.Error("settle", "outbox", box, "seen", out.Seen, "error", out.Err)
 		}
+		hooks.report(ctx, out)
 	}
 
 	return n, nil
@@ -0,0 +1,42 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestStreamingPartialRead(t *testing.T) {
+	ctx := context.Background()
+	c := &fakeClient{mb: newFakeMailbox()}
+	c.mb.add(1, "a long enough subject")
+	var want bytes.Buffer
+	if _, err := c.ReadTo(ctx, &want, 1); err != nil {
+		t.Fatal(err)
+	}
+	wantHash := NewHash()
+	wantHash.Write(want.Bytes())
+
+	for name, n := range map[string]int64{"partial": 4, "none": 0, "whole": -1} {
+		deliver := StreamDeliverFunc(func(ctx context.Context, r io.Reader, uid uint32, hsh *Hash) error {
+			if n < 0 {
+				_, err := io.Copy(io.Discard, r)
+				return err
+			}
+			_, err := io.CopyN(io.Discard, r, n)
+			return err
+		}).streaming()
+		hsh := NewHash()
+		if readErr, err := deliver(ctx, c, 1, hsh); readErr != nil || err != nil {
+			t.Fatalf("%s: readErr=%+v err=%+v", name, readErr, err)
+		}
+		if hsh.Array() != wantHash.Array() {
+			t.Errorf("%s: the hash is not of the whole message", name)
+		}
+	}
+}
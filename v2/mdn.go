@@ -0,0 +1,243 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// MDN sends Message Disposition Notifications (RFC 8098 read receipts)
+// for the delivered messages which request one with Disposition-Notification-To.
+//
+// As the notifications are sent automatically, the messages whose
+// Disposition-Notification-To differs from their Return-Path are not answered
+// (unless AllowMismatch), nor the automatic messages and the notifications themselves.
+type MDN struct {
+	// Sender sends the notifications. Required.
+	Sender Sender
+	// Client to append the sent notifications to SentMailbox.
+	Client Client
+	// Match selects the messages to send notifications for. All, if nil.
+	Match  func(mail.Header) bool
+	Logger *slog.Logger
+	// From is the address of the notifications.
+	From string
+	// ReportingUA is the Reporting-UA field, "imapclient" if empty.
+	ReportingUA string
+	// Disposition is the disposition type, "processed" if empty - "displayed" is the read receipt proper.
+	Disposition string
+	// SentMailbox is where the copies of the sent notifications are appended, if not empty.
+	SentMailbox string
+	// AllowMismatch allows notifications to a Disposition-Notification-To
+	// which differs from the Return-Path.
+	AllowMismatch bool
+}
+
+// Wrap returns a DeliverFunc which sends the requested notifications for the successfully delivered messages.
+// The errors of the notification are only logged.
+func (n *MDN) Wrap(deliver DeliverFunc) DeliverFunc {
+	return func(ctx context.Context, msg io.ReadSeeker, uid uint32, hsh HashArray) error {
+		if err := deliver(ctx, msg, uid, hsh); err != nil {
+			return err
+		}
+		if _, err := msg.Seek(0, io.SeekStart); err != nil {
+			return nil
+		}
+		if err := n.Notify(ctx, msg, uid); err != nil {
+			n.logger().Error("mdn", "uid", uid, "error", err)
+		}
+		return nil
+	}
+}
+
+func (n *MDN) logger() *slog.Logger {
+	if n.Logger != nil {
+		return n.Logger
+	}
+	return slog.Default()
+}
+
+// Notify sends the notification for the message, if it requested one and the policy allows.
+func (n *MDN) Notify(ctx context.Context, msg io.Reader, uid uint32) error {
+	raw, err := readRawHeader(bufio.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	to := m.Header.Get("Disposition-Notification-To")
+	if to == "" {
+		return nil
+	}
+	if n.Match != nil && !n.Match(m.Header) {
+		return nil
+	}
+	if reason := mdnForbidden(m.Header); reason != "" {
+		n.logger().Debug("no mdn", "uid", uid, "reason", reason)
+		return nil
+	}
+	rcpts, err := mail.ParseAddressList(to)
+	if err != nil {
+		return err
+	}
+	if len(rcpts) != 1 {
+		n.logger().Debug("no mdn", "uid", uid, "reason", "multiple addresses", "to", to)
+		return nil
+	}
+	rcpt := rcpts[0]
+	if !n.AllowMismatch {
+		rp := strings.Trim(strings.TrimSpace(m.Header.Get("Return-Path")), "<>")
+		if !strings.EqualFold(rp, rcpt.Address) {
+			n.logger().Debug("no mdn", "uid", uid, "reason", "Return-Path mismatch", "to", rcpt.Address, "return-path", rp)
+			return nil
+		}
+	}
+	if strings.EqualFold(rcpt.Address, n.From) {
+		return nil
+	}
+	if n.Sender == nil {
+		return errors.New("no Sender")
+	}
+
+	report, err := n.compose(rcpt, m.Header, raw)
+	if err != nil {
+		return err
+	}
+	if err = n.Sender.Send(ctx, "", []string{rcpt.Address}, report); err != nil {
+		return err
+	}
+	if n.Client != nil && n.SentMailbox != "" {
+		if err = n.Client.WriteTo(ctx, n.SentMailbox, report, time.Now()); err != nil {
+			n.logger().Warn("append mdn", "mailbox", n.SentMailbox, "error", err)
+		}
+	}
+	return nil
+}
+
+// readRawHeader returns the header of the message as is (with CRLF line endings),
+// with the empty line closing it.
+func readRawHeader(br *bufio.Reader) ([]byte, error) {
+	var raw []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		raw = append(append(raw, line...), "\r\n"...)
+		if len(line) == 0 {
+			return raw, nil
+		}
+		if errors.Is(err, io.EOF) { // no body
+			return append(raw, "\r\n"...), nil
+		} else if err != nil {
+			return raw, err
+		}
+	}
+}
+
+// mdnForbidden returns the reason why no notification can be sent for the message, or "".
+func mdnForbidden(hdr mail.Header) string {
+	if mt, params, _ := mime.ParseMediaType(hdr.Get("Content-Type")); mt == "multipart/report" {
+		return "report: " + params["report-type"]
+	}
+	if rp, ok := hdr["Return-Path"]; ok && len(rp) != 0 && strings.TrimSpace(rp[0]) == "<>" {
+		return "bounce"
+	}
+	// RFC 8098 section 2.2: the required options must be understood - none is.
+	for _, opt := range strings.Split(hdr.Get("Disposition-Notification-Options"), ";") {
+		_, v, _ := strings.Cut(opt, "=")
+		if importance, _, _ := strings.Cut(v, ","); strings.EqualFold(strings.TrimSpace(importance), "required") {
+			return "required option " + strings.TrimSpace(opt)
+		}
+	}
+	return ""
+}
+
+// compose the multipart/report notification, with the human readable part,
+// the message/disposition-notification and the headers of the original message (raw, in their order).
+func (n *MDN) compose(rcpt *mail.Address, orig mail.Header, raw []byte) ([]byte, error) {
+	ua := nvl(n.ReportingUA, "imapclient")
+	disposition := nvl(n.Disposition, "processed")
+	origID := orig.Get("Message-ID")
+
+	var buf bytes.Buffer
+	hdr := func(k, v string) {
+		if v != "" {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	mw := multipart.NewWriter(&buf)
+	hdr("From", n.From)
+	hdr("To", rcpt.String())
+	hdr("Subject", mime.QEncoding.Encode("utf-8", "Disposition notification: "+decodeHeader(orig.Get("Subject"))))
+	hdr("Date", time.Now().Format(time.RFC1123Z))
+	hdr("Message-ID", newMessageID(n.From))
+	hdr("In-Reply-To", origID)
+	hdr("References", strings.TrimSpace(orig.Get("References")+" "+origID))
+	hdr("Auto-Submitted", "auto-replied")
+	hdr("MIME-Version", "1.0")
+	hdr("Content-Type", `multipart/report; report-type=disposition-notification; boundary="`+mw.Boundary()+`"`)
+	buf.WriteString("\r\n")
+
+	var final string
+	if a, err := mail.ParseAddress(n.From); err == nil {
+		final = a.Address
+	}
+	var text, fields strings.Builder
+	text.WriteString("The message")
+	if s := orig.Get("Subject"); s != "" {
+		text.WriteString(" \"" + decodeHeader(s) + "\"")
+	}
+	if d := orig.Get("Date"); d != "" {
+		text.WriteString(" sent at " + d)
+	}
+	text.WriteString(" to " + nvl(final, "the recipient") + " has been " + disposition + ".\r\n" +
+		"This is no guarantee that the message has been read or understood.\r\n")
+
+	field := func(k, v string) {
+		if v != "" {
+			fields.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	field("Reporting-UA", ua)
+	field("Original-Recipient", orig.Get("Original-Recipient"))
+	if final != "" {
+		field("Final-Recipient", "rfc822;"+final)
+	}
+	field("Original-Message-ID", origID)
+	field("Disposition", "automatic-action/MDN-sent-automatically; "+disposition)
+
+	for _, part := range []struct {
+		typ  string
+		body []byte
+	}{
+		{"text/plain; charset=utf-8", []byte(text.String())},
+		{"message/disposition-notification", []byte(fields.String())},
+		{"text/rfc822-headers", bytes.TrimSuffix(raw, []byte("\r\n"))},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.typ}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
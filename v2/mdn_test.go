@@ -0,0 +1,98 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMDN(t *testing.T) {
+	const header = "Return-Path: <a@example.com>\r\n" +
+		"From: A <a@example.com>\r\n" +
+		"To: me@example.com\r\n" +
+		"Subject: question\r\n" +
+		"X-Z: last but\r\n  folded\r\n" +
+		"Message-ID: <1@example.com>\r\n" +
+		"Disposition-Notification-To: a@example.com\r\n"
+	ctx := context.Background()
+	var sent []string
+	n := &MDN{
+		From: "me@example.com",
+		Sender: senderFunc(func(ctx context.Context, from string, to []string, msg []byte) error {
+			if from != "" || len(to) != 1 || to[0] != "a@example.com" {
+				t.Errorf("send from %q to %q", from, to)
+			}
+			sent = append(sent, string(msg))
+			return nil
+		}),
+	}
+	for name, tc := range map[string]struct {
+		Msg  string
+		Sent bool
+	}{
+		"requested":   {header + "\r\nbody\r\n", true},
+		"LF":          {strings.ReplaceAll(header, "\r\n", "\n") + "\nbody\n", true},
+		"no body":     {header, true},
+		"not asked":   {strings.Replace(header, "Disposition-Notification-To", "X-DNT", 1) + "\r\n", false},
+		"mismatch":    {strings.Replace(header, "Return-Path: <a@", "Return-Path: <b@", 1) + "\r\n", false},
+		"bounce":      {strings.Replace(header, "<a@example.com>\r\n", "<>\r\n", 1) + "\r\n", false},
+		"required":    {header + "Disposition-Notification-Options: signed-receipt-protocol=required,pkcs7-signature\r\n\r\n", false},
+		"own address": {strings.ReplaceAll(header, "a@example.com", "me@example.com") + "\r\n", false},
+	} {
+		sent = sent[:0]
+		if err := n.Notify(ctx, strings.NewReader(tc.Msg), 1); err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if len(sent) != 0 != tc.Sent {
+			t.Errorf("%s: sent %d, wanted %t", name, len(sent), tc.Sent)
+			continue
+		}
+		if !tc.Sent {
+			continue
+		}
+
+		m, err := mail.ReadMessage(strings.NewReader(sent[0]))
+		if err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if got := m.Header.Get("In-Reply-To"); got != "<1@example.com>" {
+			t.Errorf("%s: In-Reply-To %q", name, got)
+		}
+		mt, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+		if mt != "multipart/report" || params["report-type"] != "disposition-notification" {
+			t.Fatalf("%s: Content-Type %q", name, m.Header.Get("Content-Type"))
+		}
+		mr := multipart.NewReader(m.Body, params["boundary"])
+		var parts []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			b, err := io.ReadAll(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, p.Header.Get("Content-Type")+"\n"+string(b))
+		}
+		if len(parts) != 3 {
+			t.Fatalf("%s: got %d parts", name, len(parts))
+		}
+		if !strings.Contains(parts[1], "Original-Message-ID: <1@example.com>\r\n") ||
+			!strings.Contains(parts[1], "Disposition: automatic-action/MDN-sent-automatically; processed\r\n") {
+			t.Errorf("%s: notification %q", name, parts[1])
+		}
+		// The headers of the original, in their order.
+		if want := "text/rfc822-headers\n" + header; parts[2] != want {
+			t.Errorf("%s: got headers\n%q, wanted\n%q", name, parts[2], want)
+		}
+	}
+}
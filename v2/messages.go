@@ -0,0 +1,67 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.23
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// messagesPageLen is the number of messages fetched at once by Messages.
+const messagesPageLen = 100
+
+// Criteria selects the messages of Messages, as the pattern and all of List.
+type Criteria struct {
+	// Pattern is searched in the Subject, if not empty.
+	Pattern string
+	// All lists the seen messages, too.
+	All bool
+}
+
+// errStopIteration stops the search when the loop body of Messages breaks.
+var errStopIteration = errors.New("stop iteration")
+
+// Messages returns an iterator over the MessageInfo of the messages of mbox matching the criteria,
+// searched (see SearchFunc) and fetched lazily, in pages.
+//
+// Breaking out of the loop stops the search: no more pages are fetched.
+// An error is yielded with a zero MessageInfo, and ends the iteration.
+//
+//	for mi, err := range imapclient.Messages(ctx, c, "INBOX", imapclient.Criteria{}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(mi.UID, mi.Subject)
+//	}
+func Messages(ctx context.Context, c Client, mbox string, crit Criteria) iter.Seq2[MessageInfo, error] {
+	return func(yield func(MessageInfo, error) bool) {
+		page := make([]uint32, 0, messagesPageLen)
+		flush := func() error {
+			infos, err := FetchInfo(ctx, c, page...)
+			page = page[:0]
+			for _, mi := range infos {
+				if !yield(mi, nil) {
+					return errStopIteration
+				}
+			}
+			return err
+		}
+		err := SearchFunc(ctx, c, mbox, crit.Pattern, crit.All, func(uid uint32) error {
+			if page = append(page, uid); len(page) < messagesPageLen {
+				return nil
+			}
+			return flush()
+		})
+		if err == nil && len(page) != 0 {
+			err = flush()
+		}
+		if err != nil && !errors.Is(err, errStopIteration) {
+			yield(MessageInfo{}, err)
+		}
+	}
+}
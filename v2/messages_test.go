@@ -0,0 +1,116 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.23
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// pageClient is a fakeClient which can fetch the subject and the size, counting the fetches.
+type pageClient struct {
+	*fakeClient
+	fetches  *int
+	fetchErr error
+}
+
+func (c pageClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	*c.fetches++
+	if c.fetchErr != nil {
+		return nil, c.fetchErr
+	}
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		if _, ok := c.mb.flags[uid]; ok {
+			subject := c.mb.subject(uid)
+			m[uid] = map[string][]string{
+				"ENVELOPE.SUBJECT": {subject},
+				"RFC822.SIZE":      {strconv.Itoa(len(subject))},
+			}
+		}
+	}
+	return m, nil
+}
+
+func TestMessages(t *testing.T) {
+	ctx := context.Background()
+	const n = 2*messagesPageLen + 10
+	mb := newFakeMailbox()
+	for uid := uint32(1); uid <= n; uid++ {
+		var flags []string
+		if uid%2 == 0 {
+			flags = append(flags, `\Seen`)
+		}
+		mb.add(uid, "msg"+strconv.Itoa(int(uid)), flags...)
+	}
+	var fetches int
+	c := pageClient{fakeClient: &fakeClient{mb: mb}, fetches: &fetches}
+
+	var uids []uint32
+	for mi, err := range Messages(ctx, c, "INBOX", Criteria{All: true}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "msg" + strconv.Itoa(int(mi.UID)); mi.Subject != want || mi.Size != int64(len(want)) {
+			t.Errorf("%d: got %q (%d), wanted %q", mi.UID, mi.Subject, mi.Size, want)
+		}
+		uids = append(uids, mi.UID)
+	}
+	if len(uids) != n || !slices.IsSorted(uids) {
+		t.Errorf("got %d messages, wanted %d in order", len(uids), n)
+	}
+	if fetches != 3 {
+		t.Errorf("got %d fetches, wanted 3 pages", fetches)
+	}
+
+	// Only the unseen ones, breaking in the first page: no more pages are fetched.
+	fetches, uids = 0, uids[:0]
+	for mi, err := range Messages(ctx, c, "INBOX", Criteria{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, mi.UID)
+		if len(uids) == 3 {
+			r, err := mi.Open(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(b) != "Subject: msg5\r\n\r\n" {
+				t.Errorf("Open: got %q, %+v", b, err)
+			}
+			break
+		}
+	}
+	if want := []uint32{1, 3, 5}; !slices.Equal(uids, want) {
+		t.Errorf("got %v, wanted %v", uids, want)
+	}
+	if fetches != 1 {
+		t.Errorf("got %d fetches after break, wanted 1", fetches)
+	}
+
+	// The error ends the iteration.
+	errFetch := errors.New("fetch")
+	c.fetchErr = errFetch
+	var got []error
+	for mi, err := range Messages(ctx, c, "INBOX", Criteria{}) {
+		if mi.UID != 0 {
+			t.Errorf("got %d with the error", mi.UID)
+		}
+		got = append(got, err)
+	}
+	if len(got) != 1 || !errors.Is(got[0], errFetch) {
+		t.Errorf("got %v, wanted the fetch error once", got)
+	}
+}
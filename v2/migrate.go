@@ -0,0 +1,252 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MigrationProgress is reported by Migration after each message and folder.
+type MigrationProgress struct {
+	// Folder is the source folder being copied.
+	Folder string
+	// FoldersDone of Folders are copied.
+	FoldersDone, Folders int
+	// Copied and Skipped of Messages have been processed, Bytes of TotalBytes copied -
+	// not counting the ones copied before the resume.
+	Copied, Skipped, Messages int
+	Bytes, TotalBytes         int64
+	Elapsed                   time.Duration
+	// ETA is the estimated remaining time, from the speed so far - zero if unknown.
+	ETA time.Duration
+}
+
+// Migration copies the folders of Src into Dst, with the INTERNALDATE and the flags of the messages.
+//
+// The high-water mark of each folder is stored in State after each message,
+// so an interrupted migration resumes where it stopped. The mark is the UID of the last copied message
+// if the source reports its UIDVALIDITY (see SelectedStatuser), otherwise its INTERNALDATE and StableID
+// (the o365 UIDs are not stable between the sessions): in that case the messages are copied in date order.
+type Migration struct {
+	Src, Dst Client
+	// Folders are the source folders, all the folders of Src if empty.
+	Folders []string
+	// FolderMap maps the portable paths of the source folders to the destination ones.
+	FolderMap FolderMap
+	// State stores the high-water marks, nothing is stored if nil.
+	State Checkpointer
+	// Name is the prefix of the checkpoint names, "migrate" if empty.
+	Name string
+	// Progress is called after each message and folder, if not nil.
+	Progress func(MigrationProgress)
+	Logger   *slog.Logger
+	// DryRun only logs the changes of Dst (see DryRun), and does not store the high-water marks.
+	DryRun bool
+}
+
+// migrationFolder is a source folder with the messages to be copied.
+type migrationFolder struct {
+	Name, Target string
+	Infos        []MessageInfo
+	UIDValidity  uint32
+}
+
+// Run copies the messages not copied yet, and returns the final progress.
+//
+// The messages larger than the APPENDLIMIT of the destination are skipped.
+func (m *Migration) Run(ctx context.Context) (MigrationProgress, error) {
+	var p MigrationProgress
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	dst := m.Dst
+	if m.DryRun {
+		dst = DryRun(dst, logger)
+	}
+	start := time.Now()
+	folders := m.Folders
+	if len(folders) == 0 {
+		var err error
+		if folders, err = m.Src.Mailboxes(ctx, ""); err != nil {
+			return p, fmt.Errorf("list source folders: %w", err)
+		}
+	}
+	srcDelim := Delimiter(ctx, m.Src)
+
+	// List everything first, for the totals of the progress.
+	todo := make([]migrationFolder, 0, len(folders))
+	for _, name := range folders {
+		f, err := m.listFolder(ctx, name)
+		if err != nil {
+			return p, err
+		}
+		path, ok := m.FolderMap.Map(strings.Join(SplitFolderPath(srcDelim, name), PathSeparator))
+		if !ok {
+			continue
+		}
+		f.Target = path
+		todo = append(todo, f)
+		p.Messages += len(f.Infos)
+		for _, mi := range f.Infos {
+			p.TotalBytes += mi.Size
+		}
+	}
+	p.Folders = len(todo)
+
+	report := func() {
+		if m.Progress == nil {
+			return
+		}
+		p.Elapsed = time.Since(start)
+		p.ETA = 0
+		if p.Bytes > 0 && p.TotalBytes > p.Bytes {
+			p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TotalBytes-p.Bytes) / float64(p.Bytes))
+		}
+		m.Progress(p)
+	}
+	fa, _ := As[FlagAppender](dst)
+	var buf bytes.Buffer
+	for _, f := range todo {
+		p.Folder = f.Name
+		target, err := EnsureFolderPath(ctx, dst, f.Target)
+		if err != nil {
+			return p, fmt.Errorf("create %q: %w", f.Target, err)
+		}
+		if len(f.Infos) != 0 {
+			// ListInfo selected another folder meanwhile.
+			if err := m.Src.Select(ctx, f.Name); err != nil {
+				return p, fmt.Errorf("select %q: %w", f.Name, err)
+			}
+		}
+		for _, mi := range f.Infos {
+			if err := CheckAppendSize(ctx, dst, target, mi.Size); errors.Is(err, ErrTooLarge) {
+				logger.Warn("skip", "folder", f.Name, "uid", mi.UID, "messageID", mi.MessageID, "error", err)
+				p.Skipped++
+			} else if err != nil {
+				return p, err
+			} else {
+				buf.Reset()
+				if _, err := m.Src.ReadTo(ctx, &buf, mi.UID); err != nil {
+					return p, fmt.Errorf("read %q/%d: %w", f.Name, mi.UID, err)
+				}
+				if fa != nil {
+					err = fa.AppendFlags(ctx, target, buf.Bytes(), mi.InternalDate, mi.Flags)
+				} else {
+					err = dst.WriteTo(ctx, target, buf.Bytes(), mi.InternalDate)
+				}
+				if err != nil {
+					return p, fmt.Errorf("append %q/%d to %q: %w", f.Name, mi.UID, target, err)
+				}
+				p.Copied++
+				p.Bytes += mi.Size
+			}
+			if m.State != nil && !m.DryRun {
+				if err := m.State.SetCheckpoint(ctx, m.checkpointName(f.Name), migrationMark(f.UIDValidity, mi)); err != nil {
+					return p, fmt.Errorf("checkpoint %q: %w", f.Name, err)
+				}
+			}
+			report()
+		}
+		p.FoldersDone++
+		report()
+	}
+	return p, nil
+}
+
+func (m *Migration) checkpointName(folder string) string {
+	if m.Name == "" {
+		return "migrate/" + folder
+	}
+	return m.Name + "/" + folder
+}
+
+// listFolder lists the messages of the folder which are after its high-water mark, in copy order.
+func (m *Migration) listFolder(ctx context.Context, name string) (migrationFolder, error) {
+	f := migrationFolder{Name: name}
+	infos, err := ListInfo(ctx, m.Src, name, "", true)
+	if err != nil {
+		return f, fmt.Errorf("list %q: %w", name, err)
+	}
+	if ss, ok := As[SelectedStatuser](m.Src); ok {
+		if st, ok := ss.SelectedStatus(); ok {
+			f.UIDValidity = st.UIDValidity
+		}
+	}
+	if f.UIDValidity != 0 {
+		slices.SortFunc(infos, func(a, b MessageInfo) int { return cmp.Compare(a.UID, b.UID) })
+	} else {
+		slices.SortFunc(infos, compareByDate)
+	}
+	f.Infos = infos
+	if m.State == nil {
+		return f, nil
+	}
+	mark, err := m.State.Checkpoint(ctx, m.checkpointName(name))
+	if err != nil || mark == "" {
+		return f, err
+	}
+	if i, ok := afterMark(infos, f.UIDValidity, mark); ok {
+		f.Infos = infos[i:]
+	}
+	return f, nil
+}
+
+// migrationMark returns the high-water mark after mi: "uid:UIDVALIDITY:UID",
+// or "date:INTERNALDATE StableID" if UIDVALIDITY is unknown.
+func migrationMark(uidValidity uint32, mi MessageInfo) string {
+	if uidValidity != 0 {
+		return "uid:" + strconv.FormatUint(uint64(uidValidity), 10) + ":" + strconv.FormatUint(uint64(mi.UID), 10)
+	}
+	return "date:" + mi.InternalDate.UTC().Format(time.RFC3339Nano) + " " + mi.StableID()
+}
+
+// afterMark returns the index of the first message after the mark.
+// It reports false for an unusable mark (as of a changed UIDVALIDITY), so everything is copied again.
+func afterMark(infos []MessageInfo, uidValidity uint32, mark string) (int, bool) {
+	if s, ok := strings.CutPrefix(mark, "uid:"); ok {
+		v, u, _ := strings.Cut(s, ":")
+		if uidValidity == 0 || v != strconv.FormatUint(uint64(uidValidity), 10) {
+			return 0, false
+		}
+		last, err := strconv.ParseUint(u, 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		i, _ := slices.BinarySearchFunc(infos, uint32(last)+1, func(mi MessageInfo, uid uint32) int { return cmp.Compare(mi.UID, uid) })
+		return i, true
+	}
+	if s, ok := strings.CutPrefix(mark, "date:"); ok && uidValidity == 0 {
+		ds, id, _ := strings.Cut(s, " ")
+		d, err := time.Parse(time.RFC3339Nano, ds)
+		if err != nil {
+			return 0, false
+		}
+		last := MessageInfo{InternalDate: d, MessageID: id}
+		for i, mi := range infos {
+			if compareByDate(mi, last) > 0 {
+				return i, true
+			}
+		}
+		return len(infos), true
+	}
+	return 0, false
+}
+
+func compareByDate(a, b MessageInfo) int {
+	if c := a.InternalDate.Compare(b.InternalDate); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.StableID(), b.StableID())
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type memCheckpointer map[string]string
+
+func (m memCheckpointer) Checkpoint(ctx context.Context, name string) (string, error) {
+	return m[name], nil
+}
+func (m memCheckpointer) SetCheckpoint(ctx context.Context, name, value string) error {
+	m[name] = value
+	return nil
+}
+
+// failingClient fails the appends after the limit.
+type failingClient struct {
+	*contentClient
+	limit int
+}
+
+var errInterrupted = errors.New("interrupted")
+
+func (c failingClient) AppendFlags(ctx context.Context, mbox string, msg []byte, date time.Time, flags []string) error {
+	if len(c.appended) >= c.limit {
+		return errInterrupted
+	}
+	return c.contentClient.AppendFlags(ctx, mbox, msg, date, flags)
+}
+
+func TestMigrationResume(t *testing.T) {
+	ctx := context.Background()
+	src := &contentClient{boxes: map[string]map[uint32]string{
+		"INBOX": {1: "Subject: 1\r\n\r\n", 2: "Subject: 2\r\n\r\n", 3: "Subject: 3\r\n\r\n"},
+		"Sent":  {1: "Subject: s\r\n\r\n"},
+	}}
+	dst := &contentClient{boxes: map[string]map[uint32]string{"INBOX": {}}}
+	state := make(memCheckpointer)
+	var last MigrationProgress
+	m := Migration{
+		Src: src, Dst: failingClient{contentClient: dst, limit: 2},
+		Folders: []string{"INBOX", "Sent"}, FolderMap: FolderMap{"Sent": "Old/Sent"},
+		State:    state,
+		Progress: func(p MigrationProgress) { last = p },
+	}
+	if _, err := m.Run(ctx); !errors.Is(err, errInterrupted) {
+		t.Fatalf("got %+v, wanted interruption", err)
+	}
+	if last.Copied != 2 || last.Messages != 4 || last.Folders != 2 || last.FoldersDone != 0 {
+		t.Errorf("got %+v", last)
+	}
+
+	m.Dst = failingClient{contentClient: dst, limit: 100}
+	p, err := m.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Copied != 2 || p.Messages != 2 || p.FoldersDone != 2 {
+		t.Errorf("resumed: got %+v", p)
+	}
+	if len(dst.boxes["INBOX"]) != 3 || len(dst.boxes["Old/Sent"]) != 1 {
+		t.Errorf("got %v", dst.boxes)
+	}
+
+	// Nothing is left.
+	if p, err = m.Run(ctx); err != nil || p.Messages != 0 {
+		t.Errorf("got %+v, %+v", p, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package msgauth
+
+import (
+	"errors"
+	"strings"
+)
+
+// AuthResult is a result of an authentication method in an Authentication-Results header field.
+type AuthResult struct {
+	// Props are the properties, such as "smtp.mailfrom" or "header.d".
+	Props map[string]string
+	// Method is the authentication method: spf, dkim, dmarc, arc...
+	Method string
+	// Result is pass, fail, softfail, neutral, none, temperror, permerror or policy.
+	Result string
+	// Reason is the optional reason of the result.
+	Reason string
+}
+
+// AuthenticationResults is a parsed Authentication-Results header field (RFC 8601).
+type AuthenticationResults struct {
+	// AuthServID identifies the MTA which added the header field.
+	AuthServID string
+	Results    []AuthResult
+}
+
+// Result returns the first result of the method, "" if there is none.
+func (ar AuthenticationResults) Result(method string) string {
+	for _, r := range ar.Results {
+		if strings.EqualFold(r.Method, method) {
+			return r.Result
+		}
+	}
+	return ""
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results header field, such as
+//
+//	mx.example.com; spf=pass smtp.mailfrom=example.net; dkim=pass (good signature) header.d=example.net
+func ParseAuthenticationResults(value string) (AuthenticationResults, error) {
+	var ar AuthenticationResults
+	parts := splitQuoted(stripComments(value), ';')
+	ar.AuthServID, _, _ = strings.Cut(strings.TrimSpace(parts[0]), " ") // drop the version
+	if ar.AuthServID == "" {
+		return ar, errors.New("missing authserv-id")
+	}
+	for _, p := range parts[1:] {
+		fields := splitQuoted(strings.TrimSpace(p), ' ')
+		if len(fields) == 0 || fields[0] == "" || strings.EqualFold(fields[0], "none") {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			return ar, errors.New("missing result in " + p)
+		}
+		method, _, _ = strings.Cut(method, "/") // drop the version
+		r := AuthResult{Method: strings.ToLower(method), Result: strings.ToLower(unquote(result))}
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok || k == "" {
+				continue
+			}
+			if strings.EqualFold(k, "reason") {
+				r.Reason = unquote(v)
+				continue
+			}
+			if r.Props == nil {
+				r.Props = make(map[string]string)
+			}
+			r.Props[strings.ToLower(k)] = unquote(v)
+		}
+		ar.Results = append(ar.Results, r)
+	}
+	return ar, nil
+}
+
+// stripComments removes the (possibly nested) comments in parentheses, outside of the quoted strings.
+func stripComments(s string) string {
+	var buf strings.Builder
+	depth, quoted, escaped := 0, false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"' && depth == 0:
+			quoted = !quoted
+		case r == '(' && !quoted:
+			depth++
+			continue
+		case r == ')' && !quoted && depth > 0:
+			depth--
+			buf.WriteByte(' ')
+			continue
+		}
+		if depth == 0 {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// splitQuoted splits s at sep, outside of the quoted strings, dropping the empty parts for ' '.
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	var buf strings.Builder
+	quoted := false
+	flush := func() {
+		if sep != ' ' || buf.Len() != 0 {
+			parts = append(parts, buf.String())
+		}
+		buf.Reset()
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == sep || sep == ' ' && (r == '\t' || r == '\r' || r == '\n')):
+			flush()
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	flush()
+	return parts
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strings.ReplaceAll(s[1:len(s)-1], `\"`, `"`)
+	}
+	return s
+}
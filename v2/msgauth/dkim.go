@@ -0,0 +1,121 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package msgauth verifies the DKIM signatures (RFC 6376) of the fetched messages,
+// and parses the Authentication-Results (RFC 8601) header fields added by the MTAs,
+// so the delivery pipelines can reject the spoofed mail before processing it.
+package msgauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// Resolver is used for looking up the DKIM keys.
+var Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+} = net.DefaultResolver
+
+// ErrNoSignature is returned when the message has no DKIM-Signature.
+var ErrNoSignature = errors.New("no DKIM signature")
+
+// Verification is the result of the verification of a DKIM-Signature.
+type Verification struct {
+	// Err is nil if the signature is valid - see dkim.IsPermFail and dkim.IsTempFail
+	// of github.com/emersion/go-msgauth/dkim.
+	Err error
+	// Domain (d=) of the signature.
+	Domain string
+	// Identifier is the agent or user identifier (i=), "@"+Domain by default.
+	Identifier string
+	// HeaderKeys are the signed header fields (h=).
+	HeaderKeys []string
+}
+
+// Valid reports whether the signature is valid.
+func (v Verification) Valid() bool { return v.Err == nil }
+
+// VerifyDKIM verifies all the DKIM signatures of the message, with go-msgauth.
+//
+// It returns ErrNoSignature if there is no DKIM-Signature; the results of the individual
+// signatures are in the Err of the Verifications.
+func VerifyDKIM(ctx context.Context, msg []byte) ([]Verification, error) {
+	if bytes.Contains(msg, []byte("\n")) && !bytes.Contains(msg, []byte("\r\n")) {
+		msg = bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+	}
+	vv, err := dkim.VerifyWithOptions(bytes.NewReader(msg), &dkim.VerifyOptions{
+		LookupTXT: func(name string) ([]string, error) { return Resolver.LookupTXT(ctx, name) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("verify DKIM: %w", err)
+	}
+	if len(vv) == 0 {
+		return nil, ErrNoSignature
+	}
+	res := make([]Verification, len(vv))
+	for i, v := range vv {
+		res[i] = Verification{Err: v.Err, Domain: v.Domain, Identifier: v.Identifier, HeaderKeys: v.HeaderKeys}
+	}
+	return res, nil
+}
+
+// headerField is a raw header field, with its CRLF.
+type headerField struct {
+	key, raw string
+}
+
+// value returns the raw value, after the colon.
+func (f headerField) value() string {
+	_, v, _ := strings.Cut(f.raw, ":")
+	return v
+}
+
+// splitMessage splits the message into the raw header fields and the body, with CRLF line endings.
+func splitMessage(msg []byte) ([]headerField, []byte, error) {
+	if bytes.Contains(msg, []byte("\n")) && !bytes.Contains(msg, []byte("\r\n")) {
+		msg = bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+	}
+	var fields []headerField
+	for len(msg) != 0 {
+		if bytes.HasPrefix(msg, []byte("\r\n")) {
+			return fields, msg[2:], nil
+		}
+		// A field ends at the CRLF not followed by WSP.
+		end := 0
+		for {
+			i := bytes.Index(msg[end:], []byte("\r\n"))
+			if i < 0 {
+				end = len(msg)
+				break
+			}
+			end += i + 2
+			if end >= len(msg) || (msg[end] != ' ' && msg[end] != '\t') {
+				break
+			}
+		}
+		raw := string(msg[:end])
+		msg = msg[end:]
+		k, _, ok := strings.Cut(raw, ":")
+		if !ok {
+			return fields, nil, fmt.Errorf("malformed header field %q", raw)
+		}
+		fields = append(fields, headerField{key: strings.TrimSpace(k), raw: raw})
+	}
+	return fields, nil, nil
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, x := range ss {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
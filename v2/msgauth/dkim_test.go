@@ -0,0 +1,121 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package msgauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if s, ok := r[name]; ok {
+		return []string{s}, nil
+	}
+	return nil, errors.New("not found: " + name)
+}
+
+// sign signs the message with the key, as the selector of domain.
+func sign(t *testing.T, msg, domain, identifier, selector string, key crypto.Signer) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := dkim.Sign(&buf, strings.NewReader(msg), &dkim.SignOptions{
+		Domain: domain, Identifier: identifier, Selector: selector, Signer: key,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             []string{"From", "To", "Subject"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakPub, err := x509.MarshalPKIXPublicKey(&weak.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := Resolver
+	defer func() { Resolver = old }()
+	Resolver = fakeResolver{
+		"sel._domainkey.example.com":  "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+		"weak._domainkey.example.com": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(weakPub),
+	}
+
+	header := "From: Joe <joe@example.com>\r\nTo:  you@example.org\r\nSubject: Test\r\n"
+	body := "Hello,  \r\nWorld\r\n\r\n"
+	signed := sign(t, header+"\r\n"+body, "example.com", "", "sel", priv)
+
+	ctx := context.Background()
+	vv, err := VerifyDKIM(ctx, []byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vv) != 1 || !vv[0].Valid() || vv[0].Domain != "example.com" {
+		t.Fatalf("got %+v", vv)
+	}
+
+	for name, msg := range map[string]string{
+		"modified body":   signed + "P.S.\r\n",
+		"modified header": strings.Replace(signed, "Test", "Spoof", 1),
+		"short RSA key":   sign(t, header+"\r\n"+body, "example.com", "", "weak", weak),
+		"i= not in d=":    sign(t, header+"\r\n"+body, "example.com", "joe@example.net", "sel", priv),
+	} {
+		if vv, err := VerifyDKIM(ctx, []byte(msg)); err != nil || len(vv) != 1 || vv[0].Valid() || dkim.IsTempFail(vv[0].Err) {
+			t.Errorf("%s: got %+v, %+v", name, vv, err)
+		}
+	}
+	if _, err = VerifyDKIM(ctx, []byte(header+"\r\n"+body)); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("no signature: got %+v", err)
+	}
+
+	p := Policy{RequireDKIM: true, Aligned: true}
+	if _, err = p.Check(ctx, []byte(signed)); err != nil {
+		t.Errorf("aligned: %+v", err)
+	}
+	if _, err = p.Check(ctx, []byte(strings.Replace(signed, "joe@example.com", "joe@example.net", 1))); err == nil {
+		t.Error("not aligned, wanted error")
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	ar, err := ParseAuthenticationResults(` mx.example.com 1; spf=pass smtp.mailfrom=example.net;
+	 dkim=fail (bad "signature") reason="body hash" header.d=example.net; dmarc=none`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AuthServID != "mx.example.com" || len(ar.Results) != 3 {
+		t.Fatalf("got %+v", ar)
+	}
+	if got := ar.Results[0].Props["smtp.mailfrom"]; got != "example.net" {
+		t.Errorf("smtp.mailfrom: got %q", got)
+	}
+	if r := ar.Results[1]; r.Result != "fail" || r.Reason != "body hash" || r.Props["header.d"] != "example.net" {
+		t.Errorf("dkim: got %+v", r)
+	}
+	if got := ar.Result("dmarc"); got != "none" {
+		t.Errorf("dmarc: got %q", got)
+	}
+}
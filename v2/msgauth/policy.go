@@ -0,0 +1,126 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package msgauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// ErrUnauthenticated is the cause of the rejection by the Policy.
+var ErrUnauthenticated = errors.New("unauthenticated message")
+
+// Policy is a DeliveryLoop middleware which rejects the messages failing the authentication checks.
+type Policy struct {
+	// TrustedAuthServIDs are the authserv-ids of the own MTAs, whose Authentication-Results are trusted.
+	// The Authentication-Results added by others are ignored, as they can be forged.
+	TrustedAuthServIDs []string
+	// Mailbox is where the rejected messages are moved, the errbox if empty.
+	Mailbox string
+	// RequireDKIM rejects the messages without a valid DKIM signature.
+	RequireDKIM bool
+	// Aligned requires the valid DKIM signature to be from the domain of the From address (or its parent).
+	Aligned bool
+	// RejectFail rejects the messages with spf, dkim or dmarc=fail in a trusted Authentication-Results.
+	RejectFail bool
+}
+
+type resultsKey struct{}
+
+// Results is the outcome of the checks of a message, available in the wrapped DeliverFunc with FromContext.
+type Results struct {
+	// DKIM are the verifications of the DKIM signatures.
+	DKIM []Verification
+	// AuthenticationResults are the trusted Authentication-Results header fields.
+	AuthenticationResults []AuthenticationResults
+}
+
+// FromContext returns the Results of the checks of the message, in the DeliverFunc wrapped by a Policy.
+func FromContext(ctx context.Context) (Results, bool) {
+	r, ok := ctx.Value(resultsKey{}).(Results)
+	return r, ok
+}
+
+// Wrap returns a DeliverFunc which checks the message before delivering it,
+// and rejects it with ErrUnauthenticated if the Policy is not met.
+func (p Policy) Wrap(deliver imapclient.DeliverFunc) imapclient.DeliverFunc {
+	return func(ctx context.Context, msg io.ReadSeeker, uid uint32, hsh imapclient.HashArray) error {
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return imapclient.RetryLater(err)
+		}
+		res, err := p.Check(ctx, b)
+		if err != nil {
+			return imapclient.RejectTo(p.Mailbox, fmt.Errorf("%w: %w", ErrUnauthenticated, err))
+		}
+		return deliver(context.WithValue(ctx, resultsKey{}, res), bytes.NewReader(b), uid, hsh)
+	}
+}
+
+// Check checks the message, and returns an error if the Policy is not met.
+func (p Policy) Check(ctx context.Context, msg []byte) (Results, error) {
+	var res Results
+	fields, _, err := splitMessage(msg)
+	if err != nil {
+		return res, err
+	}
+	for _, f := range fields {
+		if !strings.EqualFold(f.key, "Authentication-Results") {
+			continue
+		}
+		ar, err := ParseAuthenticationResults(f.value())
+		if err != nil || !containsFold(p.TrustedAuthServIDs, ar.AuthServID) {
+			continue
+		}
+		res.AuthenticationResults = append(res.AuthenticationResults, ar)
+		if p.RejectFail {
+			for _, m := range []string{"dmarc", "dkim", "spf"} {
+				if ar.Result(m) == "fail" {
+					return res, fmt.Errorf("%s: %s=fail", ar.AuthServID, m)
+				}
+			}
+		}
+	}
+
+	if !p.RequireDKIM {
+		return res, nil
+	}
+	if res.DKIM, err = VerifyDKIM(ctx, msg); err != nil {
+		return res, err
+	}
+	var fromDomain string
+	if p.Aligned {
+		for _, f := range fields {
+			if strings.EqualFold(f.key, "From") {
+				if a, err := mail.ParseAddress(strings.TrimSpace(f.value())); err == nil {
+					_, fromDomain, _ = strings.Cut(strings.ToLower(a.Address), "@")
+				}
+				break
+			}
+		}
+		if fromDomain == "" {
+			return res, errors.New("no From address")
+		}
+	}
+	var errs []error
+	for _, v := range res.DKIM {
+		if !v.Valid() {
+			errs = append(errs, fmt.Errorf("%s: %w", v.Domain, v.Err))
+			continue
+		}
+		if !p.Aligned || v.Domain == fromDomain || strings.HasSuffix(fromDomain, "."+v.Domain) {
+			return res, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: not aligned with %s", v.Domain, fromDomain))
+	}
+	return res, fmt.Errorf("no valid DKIM signature: %w", errors.Join(errs...))
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// MessageIDFinder is implemented by the Clients which can look up the messages by their Message-ID.
+type MessageIDFinder interface {
+	// FindByMessageID returns the UIDs of the messages in mbox (INBOX if empty) with the given Message-ID.
+	FindByMessageID(ctx context.Context, mbox, messageID string) ([]uint32, error)
+}
+
+var _ MessageIDFinder = (*imapClient)(nil)
+
+// ErrNoMessageIDFinder is returned by FindByMessageID if the Client is not a MessageIDFinder.
+var ErrNoMessageIDFinder = errors.New("the client cannot look up by Message-ID")
+
+// FindByMessageID returns the UIDs of the messages in mbox (INBOX if empty) with the given Message-ID,
+// for correlating with the external systems (bounces, tickets).
+func FindByMessageID(ctx context.Context, c Client, mbox, messageID string) ([]uint32, error) {
+	f, ok := As[MessageIDFinder](c)
+	if !ok {
+		return nil, fmt.Errorf("%T: %w", c, ErrNoMessageIDFinder)
+	}
+	return f.FindByMessageID(ctx, mbox, messageID)
+}
+
+// NormalizeMessageID returns the Message-ID in angle brackets, without the surrounding whitespace.
+func NormalizeMessageID(messageID string) string {
+	messageID = strings.TrimSpace(messageID)
+	if messageID == "" {
+		return ""
+	}
+	return "<" + strings.TrimSuffix(strings.TrimPrefix(messageID, "<"), ">") + ">"
+}
+
+// FindByMessageID returns the UIDs of the not deleted messages in mbox with the given Message-ID,
+// using SEARCH HEADER Message-ID.
+func (c *imapClient) FindByMessageID(ctx context.Context, mbox, messageID string) ([]uint32, error) {
+	if messageID = NormalizeMessageID(messageID); messageID == "" {
+		return nil, errors.New("empty Message-ID")
+	}
+	if err := c.Select(ctx, nvl(mbox, "INBOX")); err != nil {
+		return nil, fmt.Errorf("SELECT %q: %w", mbox, err)
+	}
+	crit := imap.NewSearchCriteria()
+	crit.WithoutFlags = append(crit.WithoutFlags, imap.DeletedFlag)
+	crit.Header.Set("Message-Id", messageID)
+	start := time.Now()
+	uids, err := c.c.UidSearch(crit)
+	if err = c.countCommand(start, err); err != nil {
+		return nil, fmt.Errorf("search Message-ID %s: %w", messageID, err)
+	}
+	return uids, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nats publishes the messages to NATS JetStream, as the DeliverFunc of a delivery loop:
+//
+//	nc, err := nats.Connect(nats.DefaultURL)
+//	if err != nil {
+//		return err
+//	}
+//	defer nc.Close()
+//	js, err := jetstream.New(nc)
+//	if err != nil {
+//		return err
+//	}
+//	err = imapclient.DeliveryLoop(ctx, c, "INBOX", "", imapnats.DeliverFunc(js, "mails.inbox"), "", "", logger)
+package nats
+
+import (
+	"context"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// JetStream is the part of jetstream.JetStream used by Publisher.
+type JetStream interface {
+	PublishMsg(ctx context.Context, msg *natsgo.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+var _ JetStream = jetstream.JetStream(nil)
+
+// Publisher is an imapclient.Publisher publishing to JetStream.
+//
+// Publish returns after the stream has acknowledged the message.
+type Publisher struct {
+	JetStream JetStream
+}
+
+var _ imapclient.Publisher = Publisher{}
+
+// Publish the data to the subject, with the headers.
+//
+// The Nats-Msg-Id is the hash of the message (the imapclient.PublishHashHeader),
+// so the stream drops the copies of the same message within its duplicate window.
+func (p Publisher) Publish(ctx context.Context, subject string, headers map[string][]string, data []byte) error {
+	var opts []jetstream.PublishOpt
+	if h := headers[imapclient.PublishHashHeader]; len(h) != 0 {
+		opts = append(opts, jetstream.WithMsgID(h[0]))
+	}
+	_, err := p.JetStream.PublishMsg(ctx, &natsgo.Msg{Subject: subject, Header: natsgo.Header(headers), Data: data}, opts...)
+	return err
+}
+
+// DeliverFunc returns the imapclient.DeliverFunc publishing the messages to the subject.
+func DeliverFunc(js JetStream, subject string) imapclient.DeliverFunc {
+	return imapclient.PublishDeliverFunc(Publisher{JetStream: js}, subject)
+}
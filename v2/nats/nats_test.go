@@ -0,0 +1,60 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package nats
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+type fakeJetStream struct {
+	msgs []*natsgo.Msg
+	opts int
+	err  error
+}
+
+func (js *fakeJetStream) PublishMsg(ctx context.Context, msg *natsgo.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.msgs = append(js.msgs, msg)
+	js.opts += len(opts)
+	if js.err != nil {
+		return nil, js.err
+	}
+	return &jetstream.PubAck{Stream: "MAILS", Sequence: uint64(len(js.msgs))}, nil
+}
+
+func TestDeliverFunc(t *testing.T) {
+	ctx := context.Background()
+	const msg = "Message-ID: <1@x>\r\nSubject: a\r\n\r\nbody"
+	hsh := imapclient.NewHash()
+	hsh.Write([]byte(msg))
+	js := &fakeJetStream{}
+	if err := DeliverFunc(js, "mails.inbox")(ctx, strings.NewReader(msg), 1, hsh.Array()); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.msgs) != 1 {
+		t.Fatalf("got %d messages, wanted 1", len(js.msgs))
+	}
+	m := js.msgs[0]
+	if m.Subject != "mails.inbox" || string(m.Data) != msg {
+		t.Errorf("got %q %q", m.Subject, m.Data)
+	}
+	if m.Header.Get(imapclient.PublishUIDHeader) != "1" || m.Header.Get("Subject") != "a" {
+		t.Errorf("headers: %v", m.Header)
+	}
+	if js.opts != 1 {
+		t.Errorf("got %d options, wanted the message ID", js.opts)
+	}
+
+	js.err = errors.New("no responders")
+	if err := DeliverFunc(js, "mails.inbox")(ctx, strings.NewReader(msg), 1, hsh.Array()); !errors.Is(err, js.err) {
+		t.Errorf("got %+v, wanted %v", err, js.err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"io"
+)
+
+// NormalizeSetter is implemented by the Clients which can normalize the messages read by ReadTo.
+type NormalizeSetter interface {
+	// SetNormalize turns the normalization of ReadTo on or off, see NewNormalizeWriter.
+	SetNormalize(bool)
+}
+
+var _ NormalizeSetter = (*imapClient)(nil)
+
+// SetNormalize turns the normalization of ReadTo on or off, see NewNormalizeWriter.
+func (c *imapClient) SetNormalize(normalize bool) { c.normalize = normalize }
+
+// maxHeldLine is the length after which a line is written out without waiting for its end.
+const maxHeldLine = 8 << 10
+
+// NewNormalizeWriter returns a writer which repairs the messages some servers return
+// with broken line endings, before they reach the (strict) MIME parsers:
+//
+//   - bare CR and bare LF line endings are converted to CRLF,
+//   - the mbox "From " separator line at the start of the message is dropped,
+//   - the mbox quoting of ">From " at the start of the lines is removed.
+//
+// Close must be called to write the last, unterminated line.
+func NewNormalizeWriter(w io.Writer) io.WriteCloser {
+	return &normalizeWriter{w: w, first: true}
+}
+
+type normalizeWriter struct {
+	w    io.Writer
+	line []byte
+	// cr is set after a CR, to swallow the LF of a CRLF.
+	cr bool
+	// first is set until the end of the first line.
+	first bool
+	// partial is set when the beginning of the current line has already been written.
+	partial bool
+}
+
+func (nw *normalizeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) != 0 {
+		if nw.cr {
+			nw.cr = false
+			if p[0] == '\n' {
+				p = p[1:]
+				continue
+			}
+		}
+		i := bytes.IndexAny(p, "\r\n")
+		if i < 0 {
+			nw.line = append(nw.line, p...)
+			if len(nw.line) > maxHeldLine {
+				if err := nw.flush(false); err != nil {
+					return 0, err
+				}
+			}
+			break
+		}
+		nw.line = append(nw.line, p[:i]...)
+		nw.cr = p[i] == '\r'
+		p = p[i+1:]
+		if err := nw.flush(true); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// flush writes out the held line, with CRLF if eol.
+func (nw *normalizeWriter) flush(eol bool) error {
+	line := nw.line
+	nw.line = nw.line[:0]
+	if !nw.partial {
+		if nw.first && bytes.HasPrefix(line, []byte("From ")) {
+			if eol {
+				nw.first = false
+				return nil
+			}
+			// A too long separator line: drop the rest of it, too.
+			nw.partial = true
+			return nil
+		}
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			line = line[1:]
+		}
+	} else if nw.first { // the rest of the dropped separator line
+		if eol {
+			nw.first, nw.partial = false, false
+		}
+		return nil
+	}
+	nw.first, nw.partial = false, !eol
+	if eol {
+		line = append(line, '\r', '\n')
+	}
+	_, err := nw.w.Write(line)
+	if eol {
+		nw.line = line[:0]
+	}
+	return err
+}
+
+// Close writes the last, unterminated line.
+func (nw *normalizeWriter) Close() error {
+	if len(nw.line) == 0 {
+		return nil
+	}
+	return nw.flush(false)
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeWriter(t *testing.T) {
+	for _, tc := range []struct{ In, Want string }{
+		{"a\r\nb\r\n", "a\r\nb\r\n"},
+		{"a\nb\rc\r\n\nd", "a\r\nb\r\nc\r\n\r\nd"},
+		{"From a@b.c Mon Jan  1 00:00:00 2024\nSubject: x\n\n>From me\n", "Subject: x\r\n\r\nFrom me\r\n"},
+		{"Subject: x\r\n\r\nFrom me\r\n", "Subject: x\r\n\r\nFrom me\r\n"},
+	} {
+		// Write byte-by-byte to test the line endings split between the Writes.
+		for _, step := range []int{1, len(tc.In)} {
+			var buf strings.Builder
+			w := NewNormalizeWriter(&buf)
+			for i := 0; i < len(tc.In); i += step {
+				if _, err := w.Write([]byte(tc.In[i:min(i+step, len(tc.In))])); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.Want {
+				t.Errorf("%q (step=%d): got %q, wanted %q", tc.In, step, got, tc.Want)
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// MultiWatcher watches more mailboxes, and emits their Events to its subscribers.
+//
+// If the server supports NOTIFY (RFC 5465), one connection receives the changes of all the mailboxes,
+// and only the changed mailboxes are listed; otherwise each mailbox is watched by its own Watcher,
+// with its own Client (so its own IDLE connection).
+type MultiWatcher struct {
+	subscribers
+	newClient func() Client
+	logger    *slog.Logger
+	mailboxes []string
+	interval  time.Duration
+}
+
+// NewMultiWatcher returns a MultiWatcher for the mailboxes, listing them at least every interval
+// (ShortSleep if zero). newClient is called for the NOTIFY connection, then for each mailbox
+// if the server does not support NOTIFY.
+func NewMultiWatcher(newClient func() Client, mailboxes []string, interval time.Duration, logger *slog.Logger) *MultiWatcher {
+	if interval <= 0 {
+		interval = ShortSleep
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &MultiWatcher{
+		newClient: newClient, mailboxes: slices.Clone(mailboxes), interval: interval,
+		logger:      logger,
+		subscribers: subscribers{subs: make(map[int]func(context.Context, Event))},
+	}
+}
+
+// notifier is implemented by the Clients which can wait for the changes of more mailboxes.
+type notifier interface {
+	// notify waits for a change of the mailboxes, at most for timeout, and returns the changed ones.
+	notify(ctx context.Context, mailboxes []string, timeout time.Duration) ([]string, error)
+}
+
+// Run watches the mailboxes till the context is canceled.
+func (mw *MultiWatcher) Run(ctx context.Context) error {
+	c := mw.newClient()
+	for {
+		err := c.Connect(ctx)
+		if err == nil {
+			break
+		}
+		mw.logger.Error("connect", "error", err)
+		if !sleepCtx(ctx, LongSleep) {
+			return nil
+		}
+	}
+	n, ok := As[notifier](c)
+	if ci, isCI := As[ConnectInfoReporter](c); !isCI || !ci.ConnectInfo().Has("NOTIFY") {
+		ok = false
+	}
+	if !ok {
+		c.Close(ctx, false)
+		mw.logger.Info("NOTIFY is not supported, watching the mailboxes one by one")
+		return mw.runEach(ctx)
+	}
+	return mw.runNotify(ctx, c, n)
+}
+
+// runEach runs a Watcher for each mailbox.
+func (mw *MultiWatcher) runEach(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, mbox := range mw.mailboxes {
+		w := NewWatcher(mw.newClient(), mbox, mw.interval, mw.logger)
+		w.SubscribeFunc(mw.emit)
+		wg.Add(1)
+		go func() { defer wg.Done(); w.Run(ctx) }()
+	}
+	wg.Wait()
+	return nil
+}
+
+// runNotify lists the mailboxes reported as changed by NOTIFY, all of them at least every interval.
+func (mw *MultiWatcher) runNotify(ctx context.Context, c Client, n notifier) error {
+	watchers := make(map[string]*Watcher, len(mw.mailboxes))
+	for _, mbox := range mw.mailboxes {
+		w := NewWatcher(c, mbox, mw.interval, mw.logger)
+		w.SubscribeFunc(mw.emit)
+		watchers[mbox] = w
+	}
+	connected := true
+	defer func() {
+		if connected {
+			c.Close(context.Background(), false)
+		}
+	}()
+	changed := mw.mailboxes
+	for {
+		if !connected {
+			if err := c.Connect(ctx); err != nil {
+				mw.logger.Error("connect", "error", err)
+				if !sleepCtx(ctx, LongSleep) {
+					return nil
+				}
+				continue
+			}
+			connected, changed = true, mw.mailboxes
+		}
+		var err error
+		for _, mbox := range changed {
+			if err = watchers[mbox].poll(ctx); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			if changed, err = n.notify(ctx, mw.mailboxes, mw.interval); err == nil && len(changed) == 0 {
+				changed = mw.mailboxes
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			mw.logger.Error("notify", "error", err)
+			c.Close(ctx, false)
+			connected = false
+			if !sleepCtx(ctx, LongSleep) {
+				return nil
+			}
+		}
+	}
+}
+
+var _ notifier = (*imapClient)(nil)
+
+// notify sets NOTIFY for the mailboxes (once per connection), then waits for a change with IDLE.
+func (c *imapClient) notify(ctx context.Context, mailboxes []string, timeout time.Duration) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// names maps the names on the server to the mailboxes.
+	names := make(map[string]string, len(mailboxes))
+	args := make([]interface{}, 0, len(mailboxes))
+	for _, mbox := range mailboxes {
+		name := c.mailbox(ctx, mbox)
+		names[imap.CanonicalMailboxName(name)] = mbox
+		enc, _ := utf7.Encoding.NewEncoder().String(name)
+		args = append(args, imap.FormatMailboxName(enc))
+	}
+	if !slices.Equal(c.notified, mailboxes) {
+		events := []interface{}{imap.RawString("MessageNew"), imap.RawString("MessageExpunge")}
+		cmd := &imap.Command{Name: "NOTIFY", Arguments: []interface{}{
+			imap.RawString("SET"),
+			[]interface{}{imap.RawString("SELECTED"), append(events, imap.RawString("FlagChange"))},
+			[]interface{}{imap.RawString("MAILBOXES"), args, events},
+		}}
+		start := time.Now()
+		status, err := c.c.Execute(cmd, nil)
+		if err == nil {
+			err = status.Err()
+		}
+		if err = c.countCommand(start, err); err != nil {
+			return nil, err
+		}
+		c.notified = slices.Clone(mailboxes)
+	}
+
+	updates := make(chan client.Update, 8)
+	defer c.updates.set(updates)()
+	statuses := make(chan string, 8)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	h := &notifyHandler{Idle: &responses.Idle{Stop: stop, RepliesCh: make(chan []byte, 10)}, statuses: statuses}
+	go func() {
+		status, err := c.c.Execute(&commands.Idle{}, h)
+		if err == nil {
+			err = status.Err()
+		}
+		done <- err
+	}()
+
+	changed := make(map[string]struct{})
+	add := func(name string) {
+		if mbox, ok := names[imap.CanonicalMailboxName(name)]; ok {
+			changed[mbox] = struct{}{}
+		}
+	}
+	selected := func() {
+		if c.status != nil {
+			add(c.status.Name)
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case name := <-statuses:
+		add(name)
+	case <-updates:
+		selected()
+	case <-timer.C:
+	case <-ctx.Done():
+	case err := <-done:
+		return nil, err
+	}
+	close(stop)
+	for {
+		select {
+		case name := <-statuses:
+			add(name)
+		case <-updates:
+			selected()
+		case err := <-done:
+			result := make([]string, 0, len(changed))
+			for _, mbox := range mailboxes {
+				if _, ok := changed[mbox]; ok {
+					result = append(result, mbox)
+				}
+			}
+			return result, err
+		}
+	}
+}
+
+// notifyHandler handles the IDLE, and the STATUS responses of the changed mailboxes, sent by NOTIFY.
+type notifyHandler struct {
+	*responses.Idle
+	statuses chan<- string
+}
+
+func (h *notifyHandler) Handle(resp imap.Resp) error {
+	if err := h.Idle.Handle(resp); err != responses.ErrUnhandled {
+		return err
+	}
+	var st responses.Status
+	if err := st.Handle(resp); err != nil {
+		return err
+	}
+	h.statuses <- st.Mailbox.Name
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// notifyClient watches the fakeMailboxes, reporting the changes sent to its channel with notify.
+type notifyClient struct {
+	Client
+	boxes   map[string]*fakeMailbox
+	changes chan []string
+	lists   atomic.Int32
+	caps    []string
+}
+
+func (c *notifyClient) Connect(context.Context) error     { return nil }
+func (c *notifyClient) Close(context.Context, bool) error { return nil }
+func (c *notifyClient) ConnectInfo() ConnectInfo          { return ConnectInfo{Capabilities: c.caps} }
+func (c *notifyClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	c.lists.Add(1)
+	return (&fakeClient{mb: c.boxes[mbox]}).List(ctx, mbox, pattern, all)
+}
+func (c *notifyClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	return nil, nil
+}
+func (c *notifyClient) notify(ctx context.Context, mailboxes []string, timeout time.Duration) ([]string, error) {
+	select {
+	case changed := <-c.changes:
+		return changed, nil
+	case <-ctx.Done():
+		return nil, nil
+	}
+}
+
+func TestMultiWatcherNotify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	boxes := map[string]*fakeMailbox{"INBOX": newFakeMailbox(1), "Work": newFakeMailbox(1, 2)}
+	var clients atomic.Int32
+	c := &notifyClient{boxes: boxes, changes: make(chan []string), caps: []string{"IMAP4rev1", "NOTIFY"}}
+	mw := NewMultiWatcher(func() Client { clients.Add(1); return c }, []string{"INBOX", "Work"}, time.Hour, nil)
+	events, unsubscribe := mw.Subscribe(1)
+	defer unsubscribe()
+	done := make(chan error, 1)
+	go func() { done <- mw.Run(ctx) }()
+
+	// The first listing of every mailbox establishes the state.
+	c.changes <- nil
+	boxes["Work"].mu.Lock()
+	boxes["Work"].flags[3] = nil
+	boxes["Work"].mu.Unlock()
+	lists := c.lists.Load()
+	c.changes <- []string{"Work"}
+	select {
+	case e := <-events:
+		if e.Mailbox != "Work" || e.UID != 3 || e.Type != NewMessage {
+			t.Errorf("got %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatal("no event")
+	}
+	if got := c.lists.Load() - lists; got != 1 {
+		t.Errorf("listed %d mailboxes, wanted only the changed one", got)
+	}
+	cancel()
+	<-done
+	if n := clients.Load(); n != 1 {
+		t.Errorf("got %d clients, wanted 1", n)
+	}
+}
+
+// recordConn records what the client sent.
+type recordConn struct {
+	net.Conn
+	mu   sync.Mutex
+	sent strings.Builder
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.sent.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+func (c *recordConn) count(s string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strings.Count(c.sent.String(), s)
+}
+
+func TestIMAPNotify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cConn, sConn := net.Pipe()
+	rc := &recordConn{Conn: sConn}
+	scriptServer(rc, nil, "* OK [CAPABILITY IMAP4rev1 IDLE NOTIFY] ready\r\n", map[string]string{
+		"IDLE": "+ idling\r\n* STATUS Work (MESSAGES 3)\r\n",
+	})
+	c := NewClientConn(cConn, "username", "password").(*imapClient)
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	for i := 0; i < 2; i++ {
+		changed, err := c.notify(ctx, []string{"INBOX", "Work"}, time.Minute)
+		if err != nil {
+			t.Fatalf("%d. %+v", i, err)
+		}
+		if len(changed) != 1 || changed[0] != "Work" {
+			t.Errorf("%d. got %q", i, changed)
+		}
+	}
+	if n := rc.count(" NOTIFY SET "); n != 1 {
+		t.Errorf("NOTIFY SET sent %d times, wanted once per connection", n)
+	}
+	if n := rc.count(" IDLE\r\n"); n != 2 {
+		t.Errorf("IDLE sent %d times", n)
+	}
+}
@@ -13,6 +13,9 @@ import (
 	"log/slog"
 	"mime"
 	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,12 +25,19 @@ import (
 )
 
 var _ = imapclient.Client((*oClient)(nil))
+var _ imapclient.Snoozer = (*oClient)(nil)
+var _ imapclient.AppendLimiter = (*oClient)(nil)
+var _ imapclient.MailboxManager = (*oClient)(nil)
+var _ imapclient.SearchWindowSetter = (*oClient)(nil)
 
 type oClient struct {
 	*client
-	u2s      map[uint32]string
-	s2u      map[string]uint32
+	u2s map[uint32]string
+	s2u map[string]uint32
+	// folders maps the lowercased names (and IDs) of the top-level folders to their IDs.
+	folders  map[string]string
 	selected string
+	window   imapclient.SearchWindow
 	mu       sync.Mutex
 }
 
@@ -42,10 +52,29 @@ func NewIMAPClient(c *client) imapclient.Client {
 var ErrNotSupported = errors.New("not supported")
 
 func (c *oClient) Watch(context.Context) ([]uint32, error)      { return nil, ErrNotSupported }
-func (c *oClient) Connect(context.Context) error                { return nil }
+func (c *oClient) Connect(context.Context) error                { c.CountConnect(); return nil }
 func (c *oClient) Close(ctx context.Context, commit bool) error { return nil }
 func (c *oClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
-	ids, err := c.client.List(ctx, mbox, pattern, all)
+	c.mu.Lock()
+	w := c.window
+	c.mu.Unlock()
+	since := w.Start(time.Now())
+	opts := []ListOption{WithSelect(FieldID)}
+	if pattern != "" {
+		// $search cannot be combined with $filter and $orderby: Since is applied here.
+		opts = append(opts, WithSelect(FieldReceived))
+	} else {
+		if !since.IsZero() {
+			opts = append(opts, WithFilter("ReceivedDateTime ge "+since.UTC().Format(time.RFC3339)))
+		}
+		if w.Max > 0 {
+			opts = append(opts, WithTop(w.Max), WithOrderBy("ReceivedDateTime desc"))
+		}
+	}
+	ids, err := c.client.List(ctx, c.folderID(ctx, mbox), pattern, all, opts...)
+	if pattern != "" && !since.IsZero() {
+		ids = slices.DeleteFunc(ids, func(msg Message) bool { return msg.Received != nil && msg.Received.Before(since) })
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	uids := make([]uint32, len(ids))
@@ -60,7 +89,31 @@ func (c *oClient) List(ctx context.Context, mbox, pattern string, all bool) ([]u
 		c.s2u[s] = u
 		uids[i] = u
 	}
-	return uids, err
+	return w.Filter(uids), err
+}
+
+// FindByMessageID returns the UIDs of the messages in mbox (Inbox if empty) with the given Message-ID.
+func (c *oClient) FindByMessageID(ctx context.Context, mbox, messageID string) ([]uint32, error) {
+	messageID = imapclient.NormalizeMessageID(messageID)
+	msgs, err := c.client.List(ctx, c.folderID(ctx, nvl(mbox, "Inbox")), "", true, WithSelect(FieldID),
+		WithFilter("InternetMessageId eq '"+strings.ReplaceAll(messageID, "'", "''")+"'"))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	uids := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		u := c.s2u[msg.ID]
+		if u == 0 {
+			for u = uint32(len(c.u2s) + 1); c.u2s[u] != ""; u++ {
+			}
+			c.u2s[u] = msg.ID
+			c.s2u[msg.ID] = u
+		}
+		uids = append(uids, u)
+	}
+	return uids, nil
 }
 func (c *oClient) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64, error) {
 	s, err := c.uidToStr(msgID)
@@ -73,14 +126,14 @@ func (c *oClient) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64,
 	}
 	var n int64
 	hdr := [][2]string{
-		{"From", rcpt(msg.Sender)},
+		// The consumer mailboxes may return no Sender.
+		{"From", rcpt(nvl(msg.Sender, msg.From))},
 		{"Categories", strings.Join(msg.Categories, ", ")},
 		{"Change-Key", msg.ChangeKey},
 		{"Conversation-Id", msg.ConversationID},
 		{"Id", msg.ID},
 		{"Importance", string(msg.Importance)},
 		{"Inference-Classification", string(msg.InferenceClassification)},
-		//{"Delivery-Receipt-Requested", msg.IsDeliveryReceiptRequested},
 		{"Subject", msg.Subject},
 		{"Web-Link", msg.WebLink},
 	}
@@ -105,12 +158,23 @@ func (c *oClient) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64,
 	A("Cc", msg.Cc)
 	A("Reply-To", msg.ReplyTo)
 	A("To", msg.To)
+	if msg.IsReadReceiptRequested {
+		hdr = append(hdr, [2]string{"Disposition-Notification-To", rcpt(nvl(msg.From, msg.Sender))})
+		// The envelope sender is not known, the sending account is the closest to it -
+		// imapclient.MDN checks it against Disposition-Notification-To.
+		if s := nvl(msg.Sender, msg.From); s != nil {
+			hdr = append(hdr, [2]string{"Return-Path", "<" + s.EmailAddress.Address + ">"})
+		}
+	}
+	if msg.IsDeliveryReceiptRequested {
+		hdr = append(hdr, [2]string{"Return-Receipt-To", rcpt(nvl(msg.From, msg.Sender))})
+	}
 
 	for _, kv := range hdr {
-		i, _ := fmt.Fprintf(w, `%s: %s\n`, kv[0], kv[1])
+		i, _ := fmt.Fprintf(w, "%s: %s\r\n", kv[0], kv[1])
 		n += int64(i)
 	}
-	i, err := io.WriteString(w, msg.Body.Content)
+	i, err := io.WriteString(w, "\r\n"+msg.Body.Content)
 	return n + int64(i), err
 }
 func rcpt(r *Recipient) string {
@@ -137,7 +201,173 @@ func (c *oClient) Move(ctx context.Context, msgID uint32, mbox string) error {
 	if err != nil {
 		return err
 	}
-	return c.client.Move(ctx, s, mbox)
+	return c.client.Move(ctx, s, c.folderID(ctx, mbox))
+}
+
+// folderID returns the ID of the top-level folder named mbox (case insensitively),
+// or of the folder of the path ("a/b/c", see FolderByPath).
+// The well-known folder names, the IDs and the unknown names are returned as is,
+// except the well-known names a consumer mailbox does not know, which are looked up by their display names.
+func (c *oClient) folderID(ctx context.Context, mbox string) string {
+	if mbox == "" {
+		return mbox
+	}
+	if imapclient.IsWellKnownFolder(mbox) {
+		if c.consumer {
+			for _, name := range consumerFolderNames[strings.ToLower(mbox)] {
+				if id, ok := c.topFolderID(ctx, name); ok {
+					return id
+				}
+			}
+		}
+		return mbox
+	}
+	c.mu.Lock()
+	id, ok := c.folders[strings.ToLower(mbox)]
+	c.mu.Unlock()
+	if ok {
+		return id
+	}
+	if strings.Contains(mbox, imapclient.PathSeparator) {
+		f, err := c.client.FolderByPath(ctx, mbox)
+		if err != nil {
+			c.logger.Warn("FolderByPath", "path", mbox, "error", err)
+			return mbox
+		}
+		c.mu.Lock()
+		if c.folders == nil {
+			c.folders = make(map[string]string)
+		}
+		c.folders[strings.ToLower(mbox)] = f.ID
+		c.mu.Unlock()
+		return f.ID
+	}
+	if id, ok := c.topFolderID(ctx, mbox); ok {
+		return id
+	}
+	return mbox
+}
+
+// topFolderID returns the ID of the top-level folder named mbox (case insensitively), listing the folders if needed.
+func (c *oClient) topFolderID(ctx context.Context, mbox string) (string, bool) {
+	c.mu.Lock()
+	id, ok := c.folders[strings.ToLower(mbox)]
+	c.mu.Unlock()
+	if ok {
+		return id, ok
+	}
+	folders, err := c.client.ListFolders(ctx, "")
+	if err != nil {
+		c.logger.Warn("ListFolders", "error", err)
+		return "", false
+	}
+	m := make(map[string]string, 2*len(folders))
+	for _, f := range folders {
+		m[strings.ToLower(f.Name)] = f.ID
+		m[strings.ToLower(f.ID)] = f.ID
+	}
+	c.mu.Lock()
+	c.folders = m
+	c.mu.Unlock()
+	id, ok = m[strings.ToLower(mbox)]
+	return id, ok
+}
+
+// CreateMailbox creates the top-level folder named mbox,
+// or the last folder of the path ("a/b/c") under its existing parent.
+func (c *oClient) CreateMailbox(ctx context.Context, mbox string) error {
+	var parent string
+	if i := strings.LastIndex(mbox, imapclient.PathSeparator); i >= 0 {
+		f, err := c.client.FolderByPath(ctx, mbox[:i])
+		if err != nil {
+			return err
+		}
+		parent, mbox = f.ID, mbox[i+1:]
+	}
+	err := c.client.CreateFolder(ctx, parent, mbox)
+	c.forgetFolders()
+	return err
+}
+
+// DeleteMailbox deletes the folder (by name or ID).
+func (c *oClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	err := c.client.DeleteFolder(ctx, c.folderID(ctx, mbox))
+	c.forgetFolders()
+	return err
+}
+
+// RenameMailbox renames the folder (by name or ID) to newName.
+func (c *oClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	err := c.client.RenameFolder(ctx, c.folderID(ctx, mbox), newName)
+	c.forgetFolders()
+	return err
+}
+
+func (c *oClient) forgetFolders() { c.mu.Lock(); c.folders = nil; c.mu.Unlock() }
+
+// psInternetHeaders is the namespace of the internet header extended properties.
+const psInternetHeaders = "00020386-0000-0000-C000-000000000046"
+
+// Annotate sets the headers as internet header extended properties of the message, and moves it to mbox.
+func (c *oClient) Annotate(ctx context.Context, msgID uint32, mbox string, headers [][2]string) error {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return err
+	}
+	props := make(map[string]string, len(headers))
+	for _, kv := range headers {
+		props[PropertyName(PropertyString, psInternetHeaders, kv[0])] = kv[1]
+	}
+	if err = c.client.SetExtendedProperties(ctx, s, props, nil); err != nil {
+		return fmt.Errorf("annotate %d: %w", msgID, err)
+	}
+	return c.client.Move(ctx, s, c.folderID(ctx, mbox))
+}
+
+// snoozeProp is the extended property holding the wake-up time of the snoozed messages.
+var snoozeProp = PropertyName(PropertyString, psPublicStrings, "SnoozedUntil")
+
+// psPublicStrings is the namespace of the public string named properties.
+const psPublicStrings = "00020329-0000-0000-C000-000000000046"
+
+// Snooze records the wake-up time in an extended property of the message, and moves it to mbox.
+func (c *oClient) Snooze(ctx context.Context, msgID uint32, mbox string, until time.Time) error {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return err
+	}
+	if err = c.client.SetExtendedProperties(ctx, s, map[string]string{
+		snoozeProp: until.UTC().Format(time.RFC3339),
+	}, nil); err != nil {
+		return fmt.Errorf("snooze %d: %w", msgID, err)
+	}
+	return c.client.Move(ctx, s, c.folderID(ctx, mbox))
+}
+
+// Wake moves the messages of mbox due at now back to inbox.
+func (c *oClient) Wake(ctx context.Context, mbox, inbox string, now time.Time) (int, error) {
+	msgs, err := c.client.List(ctx, c.folderID(ctx, mbox), "", true, WithSelect(FieldID), WithExtendedProperties(snoozeProp), WithAllPages())
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var errs []error
+	for _, msg := range msgs {
+		for _, p := range msg.SingleValueExtendedProperties {
+			if !strings.EqualFold(p.PropertyID, snoozeProp) {
+				continue
+			}
+			if until, err := time.Parse(time.RFC3339, p.Value); err == nil && !until.After(now) {
+				if err = c.client.Move(ctx, msg.ID, c.folderID(ctx, inbox)); err != nil {
+					errs = append(errs, err)
+				} else {
+					n++
+				}
+			}
+			break
+		}
+	}
+	return n, errors.Join(errs...)
 }
 
 func (c *oClient) uidToStr(msgID uint32) (string, error) {
@@ -151,14 +381,201 @@ func (c *oClient) uidToStr(msgID uint32) (string, error) {
 }
 func (c *oClient) SetLogMask(mask imapclient.LogMask) imapclient.LogMask { return false }
 func (c *oClient) SetLogger(lgr *slog.Logger)                            { c.logger = lgr }
+func (c *oClient) SetSearchWindow(w imapclient.SearchWindow) {
+	c.mu.Lock()
+	c.window = w
+	c.mu.Unlock()
+}
 func (c *oClient) Select(ctx context.Context, mbox string) error {
 	c.mu.Lock()
 	c.selected = mbox
 	c.mu.Unlock()
 	return nil
 }
+
+// sizeProp is PidTagMessageSize, the size of the message in bytes.
+var sizeProp = PropertyTag(PropertyInteger, 0x0E08)
+
+// FetchArgs returns the metadata of the messages: UID, RFC822.SIZE, INTERNALDATE, FLAGS
+// and the ENVELOPE fields (as imapclient.FetchArgs), the bodies are not supported.
 func (c *oClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
-	return nil, ErrNotImplemented
+	if what == "" {
+		what = "RFC822.SIZE INTERNALDATE ENVELOPE"
+	}
+	values := url.Values{"$select": {selectQuery([]Field{
+		FieldReceived, FieldSent, FieldSubject, FieldFrom, FieldTo, FieldCc,
+		FieldBcc, FieldReplyTo, FieldSender, FieldIsRead, FieldIsDraft, FieldFlag,
+	})}}
+	items := strings.Fields(strings.ToUpper(what))
+	if slices.Contains(items, "RFC822.SIZE") {
+		values.Set("$expand", strings.Join(expandExtendedProperties([]string{sizeProp}), ","))
+	}
+	result := make(map[uint32]map[string][]string, len(msgIDs))
+	var errs []error
+	for _, msgID := range msgIDs {
+		s, err := c.uidToStr(msgID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var msg Message
+		if err = c.client.getJSON(ctx, "/messages/"+s+"?"+values.Encode(), &msg); err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", msgID, err))
+			continue
+		}
+		m := make(map[string][]string)
+		result[msgID] = m
+		for _, item := range items {
+			switch item {
+			case "UID":
+				m[item] = []string{strconv.FormatUint(uint64(msgID), 10)}
+			case "RFC822.SIZE":
+				for _, p := range msg.SingleValueExtendedProperties {
+					if strings.EqualFold(p.PropertyID, sizeProp) {
+						m[item] = []string{p.Value}
+					}
+				}
+			case "INTERNALDATE":
+				if msg.Received != nil {
+					m[item] = []string{msg.Received.Format(time.RFC3339)}
+				}
+			case "FLAGS":
+				var flags []string
+				if msg.IsRead {
+					flags = append(flags, `\Seen`)
+				}
+				if msg.IsDraft {
+					flags = append(flags, `\Draft`)
+				}
+				if msg.Flag.Flagged() {
+					flags = append(flags, `\Flagged`)
+				}
+				m[item] = flags
+			case "ENVELOPE":
+				if msg.Sent != nil {
+					m["ENVELOPE.DATE"] = []string{msg.Sent.Format(time.RFC3339)}
+				}
+				m["ENVELOPE.SUBJECT"] = []string{msg.Subject}
+				addrs := func(rcpts ...Recipient) []string {
+					ss := make([]string, 0, len(rcpts))
+					for _, r := range rcpts {
+						if s := rcpt(&r); s != "" {
+							ss = append(ss, s)
+						}
+					}
+					return ss
+				}
+				if msg.From != nil {
+					m["ENVELOPE.FROM"] = addrs(*msg.From)
+				}
+				if msg.Sender != nil {
+					m["ENVELOPE.SENDER"] = addrs(*msg.Sender)
+				}
+				m["ENVELOPE.REPLY-TO"] = addrs(msg.ReplyTo...)
+				m["ENVELOPE.TO"] = addrs(msg.To...)
+				m["ENVELOPE.CC"] = addrs(msg.Cc...)
+				m["ENVELOPE.BCC"] = addrs(msg.Bcc...)
+			}
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// Reply replies to the sender of the message, with the comment as body.
+func (c *oClient) Reply(ctx context.Context, msgID uint32, comment string) error {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return err
+	}
+	return c.client.Reply(ctx, s, comment)
+}
+
+// ReplyMessage replies to the sender of the message with the subject and body (HTML if html),
+// setting the headers (such as Auto-Submitted) as internet header extended properties.
+func (c *oClient) ReplyMessage(ctx context.Context, msgID uint32, subject, body string, html bool, headers [][2]string) error {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return err
+	}
+	draft, err := c.client.CreateReplyDraft(ctx, s, "")
+	if err != nil {
+		return err
+	}
+	upd := Message{Subject: subject, Body: ItemBody{ContentType: "Text", Content: body}}
+	if html {
+		upd.Body.ContentType = "HTML"
+	}
+	for _, kv := range headers {
+		upd.SingleValueExtendedProperties = append(upd.SingleValueExtendedProperties,
+			SingleValueLegacyExtendedProperty{PropertyID: PropertyName(PropertyString, psInternetHeaders, kv[0]), Value: kv[1]})
+	}
+	if err = c.client.sendJSON(ctx, "PATCH", "/messages/"+draft.ID, upd, nil); err == nil {
+		err = c.client.SendDraft(ctx, draft.ID)
+	}
+	if err != nil {
+		if delErr := c.client.Delete(ctx, draft.ID); delErr != nil {
+			c.logger.Warn("delete reply draft", "id", draft.ID, "error", delErr)
+		}
+	}
+	return err
+}
+
+// UniqueBody returns the part of the body which is unique to the message in its conversation, as plain text.
+func (c *oClient) UniqueBody(ctx context.Context, msgID uint32) (string, error) {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return "", err
+	}
+	b, err := c.client.UniqueBody(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	return b.Text(), nil
+}
+
+// InlineHTML returns the HTML body of the message with the cid: references resolved
+// to its inline attachments - see imapclient.ReplaceCIDs.
+func (c *oClient) InlineHTML(ctx context.Context, msgID uint32, urlFor func(contentID string) string) (string, map[string]imapclient.InlinePart, error) {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return "", nil, err
+	}
+	msg, err := c.client.Get(ctx, s, FieldBody, FieldHasAttachments)
+	if err != nil {
+		return "", nil, err
+	}
+	parts := make(map[string]imapclient.InlinePart)
+	if msg.HasAttachments {
+		atts, err := c.client.Attachments(ctx, s)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, a := range atts {
+			if cid := strings.Trim(a.ContentID, "<>"); cid != "" && a.IsInline {
+				parts[cid] = imapclient.InlinePart{ContentType: a.ContentType, Data: a.ContentBytes}
+			}
+		}
+	}
+	if !strings.EqualFold(msg.Body.ContentType, "HTML") {
+		return "", parts, nil
+	}
+	return imapclient.ReplaceCIDs(msg.Body.Content, parts, urlFor), parts, nil
+}
+
+// CalendarEvents returns the event of the meeting request, cancellation or response message.
+func (c *oClient) CalendarEvents(ctx context.Context, msgID uint32) ([]imapclient.CalendarEvent, error) {
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return nil, err
+	}
+	msg, ev, err := c.client.messageEvent(ctx, s)
+	if err != nil {
+		if errors.Is(err, ErrNotEventMessage) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []imapclient.CalendarEvent{ev.CalendarEvent(msg.MeetingMessageType)}, nil
 }
 func (c *oClient) Mark(ctx context.Context, msgID uint32, seen bool) error {
 	s, err := c.uidToStr(msgID)
@@ -169,6 +586,35 @@ func (c *oClient) Mark(ctx context.Context, msgID uint32, seen bool) error {
 		"IsRead": seen,
 	})
 }
+
+var _ imapclient.Flagger = (*oClient)(nil)
+
+// SetFlags sets \Seen (as IsRead) and \Flagged (as the follow-up flag), the other flags are not supported.
+func (c *oClient) SetFlags(ctx context.Context, msgID uint32, add bool, flags ...string) error {
+	upd := make(map[string]interface{}, 2)
+	for _, f := range flags {
+		switch {
+		case strings.EqualFold(f, `\Seen`):
+			upd["IsRead"] = add
+		case strings.EqualFold(f, `\Flagged`):
+			status := "NotFlagged"
+			if add {
+				status = "Flagged"
+			}
+			upd["Flag"] = FollowupFlag{FlagStatus: status}
+		default:
+			return fmt.Errorf("set %s: %w", f, ErrNotSupported)
+		}
+	}
+	if len(upd) == 0 {
+		return nil
+	}
+	s, err := c.uidToStr(msgID)
+	if err != nil {
+		return err
+	}
+	return c.client.Update(ctx, s, upd)
+}
 func (c *oClient) Mailboxes(ctx context.Context, root string) ([]string, error) {
 	folders, err := c.client.ListFolders(ctx, root)
 	names := make([]string, len(folders))
@@ -178,7 +624,15 @@ func (c *oClient) Mailboxes(ctx context.Context, root string) ([]string, error)
 	return names, err
 }
 
+// AppendLimit returns the message size limit of the mailbox, see MaxMessageSize.
+func (c *oClient) AppendLimit(ctx context.Context, mbox string) (int64, error) {
+	return c.maxMessageSize, nil
+}
+
 func (c *oClient) WriteTo(ctx context.Context, mbox string, p []byte, date time.Time) error {
+	if err := imapclient.CheckAppendSize(ctx, c, mbox, int64(len(p))); err != nil {
+		return err
+	}
 	m, err := message.Read(bytes.NewReader(p))
 	if err != nil {
 		return err
@@ -196,6 +650,9 @@ func (c *oClient) WriteTo(ctx context.Context, mbox string, p []byte, date time.
 		Subject: m.Header.Get("Subject"),
 		ID:      m.Header.Get("Message-ID"),
 		ReplyTo: replyTo,
+
+		IsReadReceiptRequested:     m.Header.Get("Disposition-Notification-To") != "",
+		IsDeliveryReceiptRequested: m.Header.Get("Return-Receipt-To") != "",
 	}
 	var buf bytes.Buffer
 	m.Walk(func(path []int, ent *message.Entity, err error) error {
@@ -207,7 +664,7 @@ func (c *oClient) WriteTo(ctx context.Context, mbox string, p []byte, date time.
 		}
 		buf.Reset()
 		if _, err = io.Copy(&buf, ent.Body); err != nil && c.logger != nil {
-			c.logger.Error("read body: %w", err)
+			c.logger.Error("read body", "error", err)
 		}
 		if msg.Body.Content == "" {
 			msg.Body.Content = buf.String()
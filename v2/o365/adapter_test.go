@@ -0,0 +1,187 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+type senderFunc func(ctx context.Context, from string, to []string, msg []byte) error
+
+func (f senderFunc) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	return f(ctx, from, to, msg)
+}
+
+func TestReadToMDN(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2.0/me/messages/id1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, `{"Id":"id1","Subject":"question","IsReadReceiptRequested":true,
+"From":{"EmailAddress":{"Name":"A","Address":"a@example.com"}},
+"Sender":{"EmailAddress":{"Name":"A","Address":"a@example.com"}},
+"Body":{"ContentType":"Text","Content":"body"}}`)
+	}))
+	defer srv.Close()
+	c := NewIMAPClient(testClient(srv)).(*oClient)
+	c.u2s[1], c.s2u["id1"] = "id1", 1
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if _, err := c.ReadTo(ctx, &buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%q: %+v", buf.String(), err)
+	}
+	if got := m.Header.Get("Return-Path"); got != "<a@example.com>" {
+		t.Errorf("Return-Path: got %q", got)
+	}
+	if b, _ := io.ReadAll(m.Body); string(b) != "body" {
+		t.Errorf("body: got %q", b)
+	}
+
+	var sent int
+	n := imapclient.MDN{From: "me@example.com",
+		Sender: senderFunc(func(ctx context.Context, from string, to []string, msg []byte) error {
+			sent++
+			return nil
+		})}
+	if err = n.Notify(ctx, bytes.NewReader(buf.Bytes()), 1); err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 {
+		t.Errorf("sent %d notifications, wanted 1", sent)
+	}
+}
+
+func TestListSearchWindow(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		io.WriteString(w, `{"value":[
+{"Id":"old","ReceivedDateTime":"2024-01-01T00:00:00Z"},
+{"Id":"new","ReceivedDateTime":"2024-03-01T00:00:00Z"},
+{"Id":"read","ReceivedDateTime":"2024-03-01T00:00:00Z","IsRead":true}]}`)
+	}))
+	defer srv.Close()
+	c := imapclient.NewCircuitBreaker(NewIMAPClient(testClient(srv)), 1, 0)
+	ws, ok := imapclient.As[imapclient.SearchWindowSetter](c)
+	if !ok {
+		t.Fatal("not a SearchWindowSetter")
+	}
+	ws.SetSearchWindow(imapclient.SearchWindow{Since: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)})
+	ctx := context.Background()
+
+	uids, err := c.List(ctx, "Inbox", "invoice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("$search") == "" || query.Has("$filter") || query.Has("$orderby") {
+		t.Errorf("search with %v", query)
+	}
+	if len(uids) != 1 {
+		t.Errorf("search: got %v, wanted only the new unread one", uids)
+	}
+
+	if _, err = c.List(ctx, "Inbox", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if f := query.Get("$filter"); !strings.Contains(f, "IsRead eq false") || !strings.Contains(f, "ReceivedDateTime ge 2024-02-01T00:00:00Z") {
+		t.Errorf("filter: got %q", f)
+	}
+}
+
+func TestWakePages(t *testing.T) {
+	var srvURL string
+	var moved []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prop := func(id, until string) string {
+			return `{"Id":"` + id + `","SingleValueExtendedProperties":[{"PropertyId":"` + snoozeProp + `","Value":"` + until + `"}]}`
+		}
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/move"):
+			moved = append(moved, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v2.0/me/messages/"), "/move"))
+			io.WriteString(w, `{}`)
+		case strings.HasSuffix(r.URL.Path, "/MailFolders"):
+			io.WriteString(w, `{"value":[{"Id":"snz","DisplayName":"Snoozed"}]}`)
+		case r.URL.Query().Get("page") == "2":
+			io.WriteString(w, `{"value":[`+prop("due2", "2024-01-01T00:00:00Z")+`]}`)
+		case strings.HasSuffix(r.URL.Path, "/MailFolders/snz/messages"):
+			io.WriteString(w, `{"value":[`+prop("due1", "2024-01-01T00:00:00Z")+`,`+prop("later", "2024-12-01T00:00:00Z")+`],
+"@odata.nextLink":"`+srvURL+r.URL.Path+`?page=2"}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+	c, ok := imapclient.As[imapclient.Snoozer](NewIMAPClient(testClient(srv)))
+	if !ok {
+		t.Fatal("not a Snoozer")
+	}
+	n, err := c.Wake(context.Background(), "Snoozed", "INBOX", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || strings.Join(moved, ",") != "due1,due2" {
+		t.Errorf("woke %d: %v, wanted due1,due2", n, moved)
+	}
+}
+
+func TestFetchArgsSize(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2.0/me/messages/id1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = r.URL.Query()
+		io.WriteString(w, `{"Id":"id1","Subject":"big","IsRead":true,"ReceivedDateTime":"2024-03-01T00:00:00Z",
+"From":{"EmailAddress":{"Name":"A","Address":"a@example.com"}},
+"SingleValueExtendedProperties":[{"PropertyId":"`+sizeProp+`","Value":"2048"}]}`)
+	}))
+	defer srv.Close()
+	c := NewIMAPClient(testClient(srv)).(*oClient)
+	c.u2s[1], c.s2u["id1"] = "id1", 1
+	ctx := context.Background()
+
+	size, err := imapclient.Size(ctx, c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 2048 || !strings.Contains(query.Get("$expand"), sizeProp) {
+		t.Errorf("got %d, wanted 2048 (query %v)", size, query)
+	}
+	infos, err := imapclient.FetchInfo(ctx, c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("got %d infos, wanted 1", len(infos))
+	}
+	mi := infos[0]
+	if mi.Size != 2048 || mi.Subject != "big" || len(mi.Flags) != 1 || mi.Flags[0] != `\Seen` ||
+		!mi.InternalDate.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || len(mi.From) != 1 {
+		t.Errorf("got %+v", mi)
+	}
+	if _, err = imapclient.Size(ctx, c, 2); err == nil {
+		t.Error("unknown message: no error")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/oauth2"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// The kinds of the authentication errors, usable with errors.Is on an *AuthError.
+var (
+	// ErrLoginRequired means that the stored token cannot be refreshed: the interactive login has to be re-run.
+	ErrLoginRequired = errors.New("login required")
+	// ErrConsentRequired means that the user has not consented (or declined) the permissions of the application.
+	ErrConsentRequired = errors.New("consent required")
+	// ErrAdminConsentRequired means that a tenant administrator has to consent the permissions of the application.
+	ErrAdminConsentRequired = errors.New("admin consent required")
+	// ErrInvalidClient means a wrong configuration of the application: its ID, secret or tenant.
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrAccountBlocked means that the account is disabled, locked, or blocked by a policy.
+	ErrAccountBlocked = errors.New("account blocked")
+)
+
+// AuthError is a failed authentication or authorization, with a hint on how to remedy it.
+//
+// It matches (with errors.Is) its Kind and imapclient.ErrAuth, too.
+type AuthError struct {
+	// Err is the original error.
+	Err error
+	// Kind is one of ErrLoginRequired, ErrConsentRequired, ErrAdminConsentRequired, ErrInvalidClient and ErrAccountBlocked.
+	Kind error
+	// Code is the AADSTS error code (such as "AADSTS70008"), or the OData error code.
+	Code string
+	// Hint tells what to do.
+	Hint string
+}
+
+func (e *AuthError) Error() string {
+	s := e.Kind.Error()
+	if e.Code != "" {
+		s += " [" + e.Code + "]"
+	}
+	return s + ": " + e.Err.Error() + " - " + e.Hint
+}
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// Is reports whether target is the Kind of the error, or imapclient.ErrAuth.
+func (e *AuthError) Is(target error) bool { return target == e.Kind || target == imapclient.ErrAuth }
+
+// authHints are the remedies of the kinds of the authentication errors.
+var authHints = map[error]string{
+	ErrLoginRequired:        "re-run the interactive login to get a new token",
+	ErrConsentRequired:      "re-run the interactive login and accept the requested permissions",
+	ErrAdminConsentRequired: "ask a tenant administrator to grant admin consent to the application",
+	ErrInvalidClient:        "check the client ID, the client secret (it may have expired) and the tenant ID",
+	ErrAccountBlocked:       "the account is disabled, locked or blocked by a conditional access policy - contact the tenant administrator",
+}
+
+// aadstsKinds maps the AADSTS error codes to the kinds of the authentication errors.
+var aadstsKinds = map[int]error{
+	50076:   ErrLoginRequired, // MFA required
+	50078:   ErrLoginRequired, // MFA expired
+	50079:   ErrLoginRequired, // MFA enrollment required
+	50173:   ErrLoginRequired, // the grant has expired due to a password change
+	54005:   ErrLoginRequired, // the authorization code was already redeemed
+	70000:   ErrLoginRequired, // invalid grant
+	70008:   ErrLoginRequired, // the refresh token or the authorization code has expired
+	700082:  ErrLoginRequired, // the refresh token has expired due to inactivity
+	700084:  ErrLoginRequired, // the refresh token of a single page application has expired
+	65001:   ErrConsentRequired,
+	65004:   ErrConsentRequired, // the user declined to consent
+	90094:   ErrAdminConsentRequired,
+	90099:   ErrAdminConsentRequired,
+	700016:  ErrInvalidClient,  // the application was not found in the tenant
+	7000215: ErrInvalidClient,  // invalid client secret
+	7000222: ErrInvalidClient,  // the client secret has expired
+	90002:   ErrInvalidClient,  // the tenant was not found
+	50034:   ErrAccountBlocked, // the user account does not exist
+	50053:   ErrAccountBlocked, // the account is locked
+	50057:   ErrAccountBlocked, // the account is disabled
+	53003:   ErrAccountBlocked, // blocked by conditional access
+}
+
+var rAADSTS = regexp.MustCompile(`AADSTS([0-9]+)`)
+
+// authError returns err as an *AuthError, if it is an authentication error (a failed token request
+// or an unauthorized response); other errors are returned as is.
+//
+// A token request failed with a server error or throttling (5xx, 429) is transient, not an authentication error.
+func authError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ae *AuthError
+	if errors.As(err, &ae) {
+		return err
+	}
+	var kind error
+	var code string
+	var re *oauth2.RetrieveError
+	var oe *O365Error
+	if errors.As(err, &re) {
+		if re.Response != nil && (re.Response.StatusCode >= 500 || re.Response.StatusCode == http.StatusTooManyRequests) {
+			return err
+		}
+		kind = ErrLoginRequired
+		var data struct {
+			Codes []int `json:"error_codes"`
+		}
+		_ = json.Unmarshal(re.Body, &data)
+		codes := data.Codes
+		if len(codes) == 0 {
+			if m := rAADSTS.FindStringSubmatch(re.ErrorDescription + " " + string(re.Body)); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				codes = append(codes, n)
+			}
+		}
+		for _, n := range codes {
+			if k, ok := aadstsKinds[n]; ok {
+				kind, code = k, "AADSTS"+strconv.Itoa(n)
+				break
+			}
+		}
+		if code == "" {
+			switch re.ErrorCode {
+			case "invalid_client", "unauthorized_client":
+				kind = ErrInvalidClient
+			case "consent_required":
+				kind = ErrConsentRequired
+			}
+			code = re.ErrorCode
+		}
+	} else if errors.As(err, &oe) && (oe.StatusCode == http.StatusUnauthorized || oe.Code == ErrorInvalidAuthenticationToken) {
+		kind, code = ErrLoginRequired, oe.Code
+	} else {
+		return err
+	}
+	return &AuthError{Err: err, Kind: kind, Code: code, Hint: authHints[kind]}
+}
+
+// ValidateAuth checks the authentication before the real work: gets a token (refreshing it if needed)
+// and makes a cheap request with it.
+//
+// The failures are returned as *AuthError, telling what to do.
+func (c *client) ValidateAuth(ctx context.Context) error {
+	c.tsMu.RLock()
+	ts := c.TokenSource
+	c.tsMu.RUnlock()
+	if ts == nil {
+		return &AuthError{Err: errors.New("no TokenSource"), Kind: ErrLoginRequired, Hint: authHints[ErrLoginRequired]}
+	}
+	if _, err := ts.Token(); err != nil {
+		return authError(fmt.Errorf("token: %w", err))
+	}
+	return c.getJSON(ctx, "/MailFolders/Inbox?$select=Id", &struct{}{})
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+type errTokenSource struct{ err error }
+
+func (ts errTokenSource) Token() (*oauth2.Token, error) { return nil, ts.err }
+
+// countingTokenSource returns the same token, counting the calls.
+type countingTokenSource struct {
+	tok *oauth2.Token
+	n   atomic.Int32
+}
+
+func (ts *countingTokenSource) Token() (*oauth2.Token, error) { ts.n.Add(1); return ts.tok, nil }
+
+func TestTokenSourceShared(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer srv.Close()
+	c := testClient(srv)
+	ts := &countingTokenSource{tok: &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}}
+	c.SetTokenSource(ts)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.getJSON(ctx, "/MailFolders/Inbox", &struct{}{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := ts.n.Load(); n != 1 {
+		t.Errorf("Token called %d times, wanted once", n)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	for i, tc := range []struct {
+		err  error
+		kind error
+		code string
+	}{
+		{&oauth2.RetrieveError{ErrorCode: "invalid_grant", Body: []byte(`{"error":"invalid_grant","error_codes":[700082]}`)},
+			ErrLoginRequired, "AADSTS700082"},
+		{&oauth2.RetrieveError{ErrorCode: "invalid_client", ErrorDescription: "AADSTS7000222: The provided client secret keys are expired."},
+			ErrInvalidClient, "AADSTS7000222"},
+		{&oauth2.RetrieveError{ErrorCode: "invalid_grant", Body: []byte(`{"error_codes":[65001]}`)},
+			ErrConsentRequired, "AADSTS65001"},
+		{&oauth2.RetrieveError{ErrorCode: "invalid_client"}, ErrInvalidClient, "invalid_client"},
+		{&O365Error{StatusCode: http.StatusUnauthorized, Code: ErrorInvalidAuthenticationToken}, ErrLoginRequired, ErrorInvalidAuthenticationToken},
+		{&O365Error{StatusCode: http.StatusNotFound, Code: ErrorItemNotFound}, nil, ""},
+		{&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}, ErrorCode: "temporarily_unavailable"}, nil, ""},
+		{&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusTooManyRequests}}, nil, ""},
+		{&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}, ErrorCode: "invalid_grant"}, ErrLoginRequired, "invalid_grant"},
+	} {
+		err := authError(tc.err)
+		var ae *AuthError
+		if tc.kind == nil {
+			if errors.As(err, &ae) {
+				t.Errorf("%d. got %+v, wanted no AuthError", i, err)
+			}
+			continue
+		}
+		if !errors.As(err, &ae) || !errors.Is(err, tc.kind) || ae.Code != tc.code || ae.Hint == "" {
+			t.Errorf("%d. got %+v, wanted %v [%s]", i, err, tc.kind, tc.code)
+		} else if !errors.Is(err, imapclient.ErrAuth) || !errors.Is(err, tc.err) {
+			t.Errorf("%d. %+v does not match ErrAuth and the original error", i, err)
+		}
+	}
+
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"code":"InvalidAuthenticationToken","message":"Access token has expired."}}`)
+	}))
+	defer srv.Close()
+	c := testClient(srv)
+	if err := c.ValidateAuth(ctx); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("unauthorized: got %+v", err)
+	}
+	c.SetTokenSource(errTokenSource{&oauth2.RetrieveError{ErrorCode: "invalid_grant", Body: []byte(`{"error_codes":[90094]}`)}})
+	if err := c.ValidateAuth(ctx); !errors.Is(err, ErrAdminConsentRequired) {
+		t.Errorf("token: got %+v", err)
+	}
+}
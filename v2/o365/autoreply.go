@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import "context"
+
+// AutomaticRepliesStatus is the status of the automatic replies.
+type AutomaticRepliesStatus string
+
+// The possible values of AutomaticRepliesStatus.
+const (
+	AutomaticRepliesDisabled      = AutomaticRepliesStatus("Disabled")
+	AutomaticRepliesAlwaysEnabled = AutomaticRepliesStatus("AlwaysEnabled")
+	AutomaticRepliesScheduled     = AutomaticRepliesStatus("Scheduled")
+)
+
+// ExternalAudienceScope is the set of external senders the automatic reply is sent to.
+type ExternalAudienceScope string
+
+// The possible values of ExternalAudienceScope.
+const (
+	ExternalAudienceNone         = ExternalAudienceScope("None")
+	ExternalAudienceContactsOnly = ExternalAudienceScope("ContactsOnly")
+	ExternalAudienceAll          = ExternalAudienceScope("All")
+)
+
+// AutomaticRepliesSetting is the configuration of the mailbox's automatic replies (out of office).
+type AutomaticRepliesSetting struct {
+	// Whether the automatic replies are disabled, always enabled or scheduled.
+	Status AutomaticRepliesStatus `json:",omitempty"`
+	// The set of audience external to the signed-in user's organization who will receive the ExternalReplyMessage.
+	ExternalAudience ExternalAudienceScope `json:",omitempty"`
+	// The date and time that automatic replies are set to begin, if Status is Scheduled.
+	ScheduledStartDateTime *DateTimeTimeZone `json:",omitempty"`
+	// The date and time that automatic replies are set to end, if Status is Scheduled.
+	ScheduledEndDateTime *DateTimeTimeZone `json:",omitempty"`
+	// The automatic reply to send to the audience internal to the signed-in user's organization.
+	InternalReplyMessage string `json:",omitempty"`
+	// The automatic reply to send to the specified external audience.
+	ExternalReplyMessage string `json:",omitempty"`
+}
+
+const automaticRepliesPath = "/MailboxSettings/AutomaticRepliesSetting"
+
+// GetAutomaticRepliesSetting returns the automatic replies setting of the mailbox.
+func (c *client) GetAutomaticRepliesSetting(ctx context.Context) (AutomaticRepliesSetting, error) {
+	var ars AutomaticRepliesSetting
+	err := c.getJSON(ctx, automaticRepliesPath, &ars)
+	return ars, err
+}
+
+// SetAutomaticRepliesSetting sets the automatic replies setting of the mailbox.
+func (c *client) SetAutomaticRepliesSetting(ctx context.Context, ars AutomaticRepliesSetting) error {
+	return c.sendJSON(ctx, "PATCH", "/MailboxSettings",
+		struct{ AutomaticRepliesSetting AutomaticRepliesSetting }{ars},
+		nil)
+}
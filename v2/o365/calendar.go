@@ -0,0 +1,231 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// MeetingMessageType is the type of an EventMessage.
+type MeetingMessageType string
+
+// The possible values of MeetingMessageType.
+const (
+	MeetingNone                = MeetingMessageType("None")
+	MeetingRequest             = MeetingMessageType("MeetingRequest")
+	MeetingCancelled           = MeetingMessageType("MeetingCancelled")
+	MeetingAccepted            = MeetingMessageType("MeetingAccepted")
+	MeetingTentativelyAccepted = MeetingMessageType("MeetingTenativelyAccepted") // sic
+	MeetingDeclined            = MeetingMessageType("MeetingDeclined")
+)
+
+// eventMessageType is the @odata.type of the meeting request/response messages.
+const eventMessageType = "#Microsoft.OutlookServices.EventMessage"
+
+// IsEventMessage reports whether the message is a meeting request, cancellation or response.
+func (m Message) IsEventMessage() bool {
+	return m.ODataType == eventMessageType || m.MeetingMessageType != "" && m.MeetingMessageType != MeetingNone
+}
+
+// IsMeetingRequest reports whether the message is a meeting invitation.
+func (m Message) IsMeetingRequest() bool { return m.MeetingMessageType == MeetingRequest }
+
+// DateTimeTimeZone is a point in time in the given time zone.
+type DateTimeTimeZone struct {
+	// The date and time, in the "2006-01-02T15:04:05.0000000" form.
+	DateTime string `json:",omitempty"`
+	// The name of the time zone, such as "UTC" or "Pacific Standard Time".
+	TimeZone string `json:",omitempty"`
+}
+
+// Time parses DateTime in TimeZone.
+func (d DateTimeTimeZone) Time() (time.Time, error) {
+	loc := time.UTC
+	if d.TimeZone != "" && d.TimeZone != "UTC" {
+		var err error
+		if loc, err = imapclient.LoadLocation(d.TimeZone); err != nil {
+			loc = time.UTC
+		}
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05.9999999", d.DateTime, loc)
+}
+
+// Location of an event.
+type Location struct {
+	DisplayName string `json:",omitempty"`
+}
+
+// ResponseStatus is the response of an attendee or the organizer for a meeting.
+type ResponseStatus struct {
+	// None, Organizer, TentativelyAccepted, Accepted, Declined or NotResponded.
+	Response string    `json:",omitempty"`
+	Time     time.Time `json:",omitempty"`
+}
+
+// Attendee of an event.
+type Attendee struct {
+	EmailAddress EmailAddress   `json:",omitempty"`
+	Status       ResponseStatus `json:",omitempty"`
+	// Required, Optional or Resource.
+	Type string `json:",omitempty"`
+}
+
+// https://msdn.microsoft.com/en-us/office/office365/api/complex-types-for-mail-contacts-calendar#EventResource
+type Event struct {
+	// The unique identifier of the event.
+	ID string `json:"Id,omitempty"`
+	// A unique identifier that is shared by all instances of an event across different calendars.
+	ICalUID string `json:"iCalUId,omitempty"`
+	// The text of the event's subject line.
+	Subject string `json:",omitempty"`
+	// The body of the message associated with the event.
+	Body *ItemBody `json:",omitempty"`
+	// The start time of the event.
+	Start *DateTimeTimeZone `json:",omitempty"`
+	// The date and time that the event ends.
+	End *DateTimeTimeZone `json:",omitempty"`
+	// The location of the event.
+	Location *Location `json:",omitempty"`
+	// The organizer of the event.
+	Organizer *Recipient `json:",omitempty"`
+	// The collection of attendees for the event.
+	Attendees []Attendee `json:",omitempty"`
+	// Indicates the type of response sent in response to an event message.
+	ResponseStatus *ResponseStatus `json:",omitempty"`
+	// The status to show: Free, Tentative, Busy, Oof, WorkingElsewhere or Unknown.
+	ShowAs string `json:",omitempty"`
+	// The event type: SingleInstance, Occurrence, Exception or SeriesMaster.
+	Type string `json:",omitempty"`
+	// Set to true if the event lasts all day.
+	IsAllDay bool `json:",omitempty"`
+	// Set to true if the event has been canceled.
+	IsCancelled bool `json:",omitempty"`
+	// Set to true if the sender would like a response when the event is accepted or declined.
+	ResponseRequested bool `json:",omitempty"`
+}
+
+// ErrNotEventMessage is returned by GetMessageEvent if the message is not an EventMessage.
+var ErrNotEventMessage = errors.New("not an event message")
+
+// GetMessageEvent returns the event associated with the meeting request (or response) message.
+func (c *client) GetMessageEvent(ctx context.Context, msgID string) (Event, error) {
+	_, ev, err := c.messageEvent(ctx, msgID)
+	return ev, err
+}
+
+// messageEvent returns the message with its event.
+func (c *client) messageEvent(ctx context.Context, msgID string) (Message, Event, error) {
+	var msg struct {
+		Message
+		Event *Event `json:",omitempty"`
+	}
+	if err := c.getJSON(ctx,
+		"/messages/"+url.PathEscape(msgID)+"?"+url.Values{
+			"$expand": {"Microsoft.OutlookServices.EventMessage/Event"},
+		}.Encode(),
+		&msg,
+	); err != nil {
+		return msg.Message, Event{}, err
+	}
+	if msg.Event == nil {
+		return msg.Message, Event{}, ErrNotEventMessage
+	}
+	return msg.Message, *msg.Event, nil
+}
+
+// CalendarEvent returns the event as an imapclient.CalendarEvent, with the method of the meeting message type.
+func (ev Event) CalendarEvent(typ MeetingMessageType) imapclient.CalendarEvent {
+	ce := imapclient.CalendarEvent{
+		UID: ev.ICalUID, Summary: ev.Subject, AllDay: ev.IsAllDay,
+		Status: "CONFIRMED",
+	}
+	switch typ {
+	case MeetingRequest:
+		ce.Method = "REQUEST"
+	case MeetingCancelled:
+		ce.Method = "CANCEL"
+	case MeetingAccepted, MeetingTentativelyAccepted, MeetingDeclined:
+		ce.Method = "REPLY"
+	}
+	if ev.IsCancelled {
+		ce.Status = "CANCELLED"
+	}
+	if ev.Body != nil {
+		ce.Description = ev.Body.Text()
+	}
+	if ev.Location != nil {
+		ce.Location = ev.Location.DisplayName
+	}
+	if ev.Start != nil {
+		ce.Start, _ = ev.Start.Time()
+	}
+	if ev.End != nil {
+		ce.End, _ = ev.End.Time()
+	}
+	if ev.Organizer != nil {
+		ce.Organizer = imapclient.CalendarAttendee{
+			Email: ev.Organizer.EmailAddress.Address, Name: ev.Organizer.EmailAddress.Name, Role: "CHAIR",
+		}
+	}
+	for _, a := range ev.Attendees {
+		role := "REQ-PARTICIPANT"
+		switch a.Type {
+		case "Optional":
+			role = "OPT-PARTICIPANT"
+		case "Resource":
+			role = "NON-PARTICIPANT"
+		}
+		ce.Attendees = append(ce.Attendees, imapclient.CalendarAttendee{
+			Email: a.EmailAddress.Address, Name: a.EmailAddress.Name, Role: role,
+			PartStat: partStats[a.Status.Response], RSVP: ev.ResponseRequested,
+		})
+	}
+	return ce
+}
+
+// partStats maps the responses to the iCalendar PARTSTATs.
+var partStats = map[string]string{
+	"": "NEEDS-ACTION", "None": "NEEDS-ACTION", "NotResponded": "NEEDS-ACTION",
+	"Organizer": "ACCEPTED", "Accepted": "ACCEPTED",
+	"TentativelyAccepted": "TENTATIVE", "Declined": "DECLINED",
+}
+
+// GetEvent returns the event.
+func (c *client) GetEvent(ctx context.Context, eventID string) (Event, error) {
+	var ev Event
+	err := c.getJSON(ctx, "/events/"+url.PathEscape(eventID), &ev)
+	return ev, err
+}
+
+// AcceptEvent accepts the meeting invitation.
+//
+// If sendResponse is true, a response with the optional comment is sent to the organizer.
+func (c *client) AcceptEvent(ctx context.Context, eventID, comment string, sendResponse bool) error {
+	return c.respondEvent(ctx, eventID, "accept", comment, sendResponse)
+}
+
+// DeclineEvent declines the meeting invitation.
+func (c *client) DeclineEvent(ctx context.Context, eventID, comment string, sendResponse bool) error {
+	return c.respondEvent(ctx, eventID, "decline", comment, sendResponse)
+}
+
+// TentativelyAcceptEvent tentatively accepts the meeting invitation.
+func (c *client) TentativelyAcceptEvent(ctx context.Context, eventID, comment string, sendResponse bool) error {
+	return c.respondEvent(ctx, eventID, "tentativelyaccept", comment, sendResponse)
+}
+
+func (c *client) respondEvent(ctx context.Context, eventID, action, comment string, sendResponse bool) error {
+	return c.sendJSON(ctx, "POST", "/events/"+url.PathEscape(eventID)+"/"+action,
+		struct {
+			Comment      string `json:",omitempty"`
+			SendResponse bool
+		}{Comment: comment, SendResponse: sendResponse},
+		nil)
+}
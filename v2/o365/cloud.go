@@ -0,0 +1,134 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Cloud is a deployment of Office 365 - the global service or a national (sovereign) cloud,
+// with its own hostnames for both the REST API and the OAuth2 login.
+type Cloud struct {
+	// Name of the cloud, such as "global" or "usgovhigh".
+	Name string
+	// Resource is the root URL of the Outlook service, such as "https://outlook.office.com":
+	// the REST API is under it, and the scopes are prefixed with it.
+	Resource string
+	// Login is the root URL of the Azure AD authority, such as "https://login.microsoftonline.com".
+	Login string
+}
+
+// The known clouds.
+var (
+	CloudGlobal    = Cloud{Name: "global", Resource: "https://outlook.office.com", Login: "https://login.microsoftonline.com"}
+	CloudUSGovHigh = Cloud{Name: "usgovhigh", Resource: "https://outlook.office365.us", Login: "https://login.microsoftonline.us"}
+	CloudUSGovDoD  = Cloud{Name: "usgovdod", Resource: "https://outlook-dod.office365.us", Login: "https://login.microsoftonline.us"}
+	CloudChina     = Cloud{Name: "china", Resource: "https://partner.outlook.cn", Login: "https://login.chinacloudapi.cn"}
+	CloudGermany   = Cloud{Name: "germany", Resource: "https://outlook.office.de", Login: "https://login.microsoftonline.de"}
+
+	Clouds = []Cloud{CloudGlobal, CloudUSGovHigh, CloudUSGovDoD, CloudChina, CloudGermany}
+)
+
+// ErrUnknownCloud is returned by LookupCloud for an unknown name.
+var ErrUnknownCloud = errors.New("unknown cloud")
+
+// LookupCloud returns the known cloud with the given name (case-insensitive, empty means global).
+func LookupCloud(name string) (Cloud, error) {
+	if name == "" {
+		return CloudGlobal, nil
+	}
+	for _, cl := range Clouds {
+		if strings.EqualFold(cl.Name, name) {
+			return cl, nil
+		}
+	}
+	return Cloud{}, fmt.Errorf("%q: %w", name, ErrUnknownCloud)
+}
+
+// WithCloud sets the cloud the client talks to (CloudGlobal by default).
+// NewClient panics if the cloud is not valid, see Cloud.Validate and ValidateOptions.
+func WithCloud(cloud Cloud) ClientOption { return func(o *clientOptions) { o.Cloud = cloud } }
+
+// Validate checks that both URLs are https roots, and that they belong together:
+// the API of a known cloud accepts the tokens of its own login only.
+func (cl Cloud) Validate() error {
+	resource, err := hostOf(cl.Resource)
+	if err != nil {
+		return fmt.Errorf("%s: Resource: %w", cl.Name, err)
+	}
+	login, err := hostOf(cl.Login)
+	if err != nil {
+		return fmt.Errorf("%s: Login: %w", cl.Name, err)
+	}
+	var loginKnown bool
+	for _, k := range Clouds {
+		kResource, _ := hostOf(k.Resource)
+		kLogin, _ := hostOf(k.Login)
+		if resource == kResource {
+			if login != kLogin {
+				return fmt.Errorf("%s: the API of %s (%s) needs the login %s, not %s", cl.Name, k.Name, resource, kLogin, login)
+			}
+			return nil
+		}
+		loginKnown = loginKnown || login == kLogin
+	}
+	if loginKnown {
+		return fmt.Errorf("%s: the login %s belongs to a known cloud, not to %s", cl.Name, login, resource)
+	}
+	return nil
+}
+
+// hostOf returns the host of the https root URL s.
+func hostOf(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("empty")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return "", fmt.Errorf("%q: not a https root URL", s)
+	}
+	return strings.ToLower(u.Host), nil
+}
+
+// APIURL returns the base URL of the REST API.
+func (cl Cloud) APIURL() string { return strings.TrimSuffix(cl.Resource, "/") + "/api/v2.0" }
+
+// Scope returns the scope of the permission (such as "mail.read") in this cloud.
+func (cl Cloud) Scope(permission string) string {
+	return strings.TrimSuffix(cl.Resource, "/") + "/" + permission
+}
+
+// Authority returns the Azure AD authority of the tenant ("common" if empty).
+func (cl Cloud) Authority(tenantID string) string {
+	if tenantID == "" {
+		tenantID = "common"
+	}
+	return strings.TrimSuffix(cl.Login, "/") + "/" + url.PathEscape(tenantID)
+}
+
+// Endpoint returns the OAuth2 endpoint of the tenant ("common" if empty).
+func (cl Cloud) Endpoint(tenantID string) oauth2.Endpoint {
+	a := cl.Authority(tenantID)
+	return oauth2.Endpoint{
+		AuthURL:  a + "/oauth2/v2.0/authorize",
+		TokenURL: a + "/oauth2/v2.0/token",
+	}
+}
+
+// orGlobal returns CloudGlobal for the zero Cloud.
+func (cl Cloud) orGlobal() Cloud {
+	if cl.Resource == "" && cl.Login == "" {
+		return CloudGlobal
+	}
+	return cl
+}
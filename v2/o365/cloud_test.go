@@ -0,0 +1,57 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCloud(t *testing.T) {
+	for _, cl := range Clouds {
+		if err := cl.Validate(); err != nil {
+			t.Errorf("%s: %+v", cl.Name, err)
+		}
+	}
+	for i, cl := range []Cloud{
+		{Name: "mixed", Resource: CloudUSGovHigh.Resource, Login: CloudGlobal.Login},
+		{Name: "custom", Resource: "https://outlook.example.com", Login: CloudChina.Login},
+		{Name: "http", Resource: "http://outlook.example.com", Login: "https://login.example.com"},
+		{Name: "path", Resource: "https://outlook.example.com/api", Login: "https://login.example.com"},
+		{Name: "empty", Resource: "https://outlook.example.com"},
+	} {
+		if err := cl.Validate(); err == nil {
+			t.Errorf("%d. %s: no error", i, cl.Name)
+		}
+	}
+	if err := (Cloud{Name: "custom", Resource: "https://outlook.example.com", Login: "https://login.example.com"}).Validate(); err != nil {
+		t.Errorf("custom: %+v", err)
+	}
+
+	cl, err := LookupCloud("USGovDoD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cl.APIURL(); got != "https://outlook-dod.office365.us/api/v2.0" {
+		t.Errorf("APIURL: got %q", got)
+	}
+	if got := cl.Endpoint("t1").TokenURL; got != "https://login.microsoftonline.us/t1/oauth2/v2.0/token" {
+		t.Errorf("TokenURL: got %q", got)
+	}
+	if _, err := LookupCloud("mars"); !errors.Is(err, ErrUnknownCloud) {
+		t.Errorf("mars: got %+v", err)
+	}
+
+	c := NewClient("id", "secret", "", WithCloud(CloudChina), TenantID("t2"))
+	if got := c.URLFor("/messages"); got != "https://partner.outlook.cn/api/v2.0/me/messages" {
+		t.Errorf("URLFor: got %q", got)
+	}
+	if got := c.Config.Scopes[0]; got != "https://partner.outlook.cn/mail.readwrite" {
+		t.Errorf("scope: got %q", got)
+	}
+	if got := NewConfidentialTokenSource(c.Config, "t2").authority(); got != "https://login.chinacloudapi.cn/t2" {
+		t.Errorf("authority: got %q", got)
+	}
+}
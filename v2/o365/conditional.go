@@ -0,0 +1,84 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrConflict is the 412 Precondition Failed returned for a conditional request
+// when the item has been changed since its ChangeKey was read:
+// errors.Is(err, ErrConflict) reports such errors.
+var ErrConflict = &O365Error{StatusCode: http.StatusPreconditionFailed}
+
+// ifMatch returns the If-Match header for the ChangeKey.
+func ifMatch(changeKey string) http.Header {
+	if changeKey == "" {
+		return nil
+	}
+	return http.Header{"If-Match": {`W/"` + changeKey + `"`}}
+}
+
+// UpdateIfMatch updates the message only if its ChangeKey is still changeKey,
+// and returns ErrConflict if not.
+func (c *client) UpdateIfMatch(ctx context.Context, msgID, changeKey string, upd map[string]interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(upd); err != nil {
+		return fmt.Errorf("%#v: %w", upd, err)
+	}
+	body, err := c.pHeader(ctx, "PATCH", "/messages/"+msgID, &buf, ifMatch(changeKey))
+	if body != nil {
+		body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("%#v: %w", upd, err)
+	}
+	return nil
+}
+
+// MoveIfMatch moves the message only if its ChangeKey is still changeKey,
+// and returns ErrConflict if not.
+func (c *client) MoveIfMatch(ctx context.Context, msgID, changeKey, destinationID string) error {
+	return c.actionIfMatch(ctx, msgID, "move", changeKey, jsonObj("DestinationId", destinationID))
+}
+
+// CopyIfMatch copies the message only if its ChangeKey is still changeKey,
+// and returns ErrConflict if not.
+func (c *client) CopyIfMatch(ctx context.Context, msgID, changeKey, destinationID string) error {
+	return c.actionIfMatch(ctx, msgID, "copy", changeKey, jsonObj("DestinationId", destinationID))
+}
+
+func (c *client) actionIfMatch(ctx context.Context, msgID, action, changeKey string, body []byte) error {
+	rc, err := c.pHeader(ctx, "POST", "/messages/"+msgID+"/"+action, bytes.NewReader(body), ifMatch(changeKey))
+	if rc != nil {
+		rc.Close()
+	}
+	return err
+}
+
+// UpdateFunc updates the message with the changes computed by fn from its current state,
+// conditionally on its ChangeKey: on conflict the message is refetched and fn is called again,
+// at most retries times.
+func (c *client) UpdateFunc(ctx context.Context, msgID string, retries int, fn func(Message) (map[string]interface{}, error)) error {
+	for i := 0; ; i++ {
+		msg, err := c.Get(ctx, msgID)
+		if err != nil {
+			return err
+		}
+		upd, err := fn(msg)
+		if err != nil || len(upd) == 0 {
+			return err
+		}
+		if err = c.UpdateIfMatch(ctx, msgID, msg.ChangeKey, upd); err == nil || !errors.Is(err, ErrConflict) || i >= retries {
+			return err
+		}
+		c.logger.Debug("update conflict", "msgID", msgID, "changeKey", msg.ChangeKey, "try", i+1)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpdateFunc(t *testing.T) {
+	// The ChangeKey of m1 is its version, bumped by each update - and by the conflicts
+	// before the next requests, simulating concurrent changes.
+	var (
+		mu         sync.Mutex
+		version    int
+		categories []string
+		conflicts  = 1
+		patches    int
+	)
+	changeKey := func() string { return fmt.Sprintf("ck%d", version) }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(r.URL.Path, "/api/v2.0/me/messages/m1") {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":"ErrorItemNotFound","message":"`+r.URL.Path+`"}}`)
+			return
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(Message{ID: "m1", ChangeKey: changeKey(), Categories: categories})
+			return
+		}
+		if r.Method == "PATCH" {
+			patches++
+		}
+		if conflicts > 0 {
+			conflicts--
+			version++
+		}
+		if got, want := r.Header.Get("If-Match"), `W/"`+changeKey()+`"`; got != want {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			io.WriteString(w, `{"error":{"code":"ErrorIrresolvableConflict","message":"`+got+` != `+want+`"}}`)
+			return
+		}
+		var upd struct{ Categories []string }
+		if err := json.NewDecoder(r.Body).Decode(&upd); err != nil && r.Method == "PATCH" {
+			t.Errorf("%s: %+v", r.Method, err)
+		}
+		categories = upd.Categories
+		version++
+		io.WriteString(w, `{}`)
+	}))
+	defer srv.Close()
+	c := testClient(srv)
+	ctx := context.Background()
+
+	addCategory := func(msg Message) (map[string]interface{}, error) {
+		return map[string]interface{}{"Categories": append(msg.Categories, "done")}, nil
+	}
+	if err := c.UpdateFunc(ctx, "m1", 2, addCategory); err != nil {
+		t.Fatal(err)
+	}
+	if patches != 2 || len(categories) != 1 || categories[0] != "done" {
+		t.Errorf("got %d patches, categories %q", patches, categories)
+	}
+
+	mu.Lock()
+	conflicts = 5
+	mu.Unlock()
+	if err := c.UpdateFunc(ctx, "m1", 2, addCategory); !errors.Is(err, ErrConflict) {
+		t.Errorf("out of retries: got %+v, wanted ErrConflict", err)
+	}
+	if err := c.MoveIfMatch(ctx, "m1", "stale", "archive"); !errors.Is(err, ErrConflict) {
+		t.Errorf("move: got %+v, wanted ErrConflict", err)
+	}
+}
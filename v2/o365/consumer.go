@@ -0,0 +1,46 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"errors"
+	"strings"
+)
+
+// ConsumersTenant is the tenant of the personal Microsoft accounts (outlook.com, hotmail.com, live.com).
+const ConsumersTenant = "consumers"
+
+// Consumer marks the client as accessing a personal (outlook.com) mailbox, instead of a work or school one:
+// the consumers tenant is used (unless TenantID is "common"),
+// and the quirks of the consumer mailboxes are handled (see consumerFolderNames).
+//
+// The consumer accounts exist in the global cloud only, and cannot impersonate other users:
+// NewClient panics if Consumer is combined with another cloud or with Impersonate, see ValidateOptions.
+func Consumer(consumer bool) ClientOption { return func(o *clientOptions) { o.Consumer = consumer } }
+
+// checkConsumer returns the tenant of a consumer client, or an error if the options do not fit a consumer account.
+func checkConsumer(opts clientOptions) (string, error) {
+	if cl := opts.Cloud.orGlobal(); cl.Resource != CloudGlobal.Resource {
+		return "", errors.New("consumer accounts exist in the global cloud only, not in " + cl.Name)
+	}
+	if opts.Impersonate != "" && opts.Impersonate != "me" {
+		return "", errors.New("consumer accounts cannot impersonate " + opts.Impersonate)
+	}
+	switch tenant := strings.ToLower(opts.TenantID); tenant {
+	case "", ConsumersTenant:
+		return ConsumersTenant, nil
+	case "common":
+		return tenant, nil
+	default:
+		return "", errors.New("consumer accounts need the consumers or common tenant, not " + opts.TenantID)
+	}
+}
+
+// consumerFolderNames are the display names of the well-known folders which the consumer mailboxes
+// do not resolve by their well-known names.
+var consumerFolderNames = map[string][]string{
+	"archive":   {"Archive"},
+	"junkemail": {"Junk Email", "Junk"},
+}
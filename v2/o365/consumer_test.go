@@ -0,0 +1,57 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsumer(t *testing.T) {
+	for i, tc := range []struct {
+		opts   clientOptions
+		tenant string
+		ok     bool
+	}{
+		{clientOptions{}, ConsumersTenant, true},
+		{clientOptions{TenantID: "Common", Impersonate: "me"}, "common", true},
+		{clientOptions{TenantID: "contoso.onmicrosoft.com"}, "", false},
+		{clientOptions{Impersonate: "a@b.c"}, "", false},
+		{clientOptions{Cloud: CloudUSGovHigh}, "", false},
+	} {
+		tenant, err := checkConsumer(tc.opts)
+		if (err == nil) != tc.ok || tenant != tc.tenant {
+			t.Errorf("%d. got %q, %+v", i, tenant, err)
+		}
+	}
+	if err := ValidateOptions("id", "secret", Consumer(true), Impersonate("a@b.c")); err == nil {
+		t.Error("consumer impersonating: no error")
+	}
+	if err := ValidateOptions("id", "secret", Consumer(true), Impersonate("me")); err != nil {
+		t.Errorf("consumer as me: %+v", err)
+	}
+	if got := NewClient("id", "secret", "", Consumer(true)).Config.Endpoint.TokenURL; got != "https://login.microsoftonline.com/consumers/oauth2/v2.0/token" {
+		t.Errorf("TokenURL: got %q", got)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"value":[{"Id":"inbox-id","DisplayName":"Inbox"},{"Id":"junk-id","DisplayName":"Junk Email"}]}`)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	c := &oClient{client: testClient(srv)}
+	if got := c.folderID(ctx, "JunkEmail"); got != "JunkEmail" {
+		t.Errorf("work account: got %q", got)
+	}
+	c.consumer = true
+	for mbox, want := range map[string]string{"JunkEmail": "junk-id", "Archive": "Archive", "Inbox": "Inbox"} {
+		if got := c.folderID(ctx, mbox); got != want {
+			t.Errorf("%s: got %q, wanted %q", mbox, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// https://msdn.microsoft.com/en-us/office/office365/api/complex-types-for-mail-contacts-calendar#ContactResource
+type Contact struct {
+	// The contact's unique identifier.
+	ID string `json:"Id,omitempty"`
+	// The contact's display name.
+	DisplayName string `json:",omitempty"`
+	// The contact's given name.
+	GivenName string `json:",omitempty"`
+	// The contact's surname.
+	Surname string `json:",omitempty"`
+	// The name of the contact's company.
+	CompanyName string `json:",omitempty"`
+	// The contact's email addresses.
+	EmailAddresses []EmailAddress `json:",omitempty"`
+}
+
+// ListContacts returns the contacts of the mailbox, filtered by the OData filter expression (if not empty).
+func (c *client) ListContacts(ctx context.Context, filter string) ([]Contact, error) {
+	params := url.Values{
+		"$select": {"Id,DisplayName,GivenName,Surname,CompanyName,EmailAddresses"},
+		"$top":    {"100"},
+	}
+	if filter != "" {
+		params.Set("$filter", filter)
+	}
+	var resp struct {
+		Value []Contact `json:"value"`
+	}
+	err := c.getJSON(ctx, "/contacts?"+params.Encode(), &resp)
+	return resp.Value, err
+}
+
+// SearchContacts returns the contacts whose display name, given name or surname starts with prefix.
+func (c *client) SearchContacts(ctx context.Context, prefix string) ([]Contact, error) {
+	q := quoteOData(prefix)
+	return c.ListContacts(ctx,
+		"startswith(DisplayName,"+q+") or startswith(GivenName,"+q+") or startswith(Surname,"+q+")")
+}
+
+// ResolveName resolves the name to SMTP addresses, the way Outlook does:
+// a full address is returned as is, otherwise the contacts with exactly this display name
+// are used, and if there's none, the contacts whose name starts with name.
+//
+// The result is empty if name cannot be resolved.
+func (c *client) ResolveName(ctx context.Context, name string) ([]EmailAddress, error) {
+	name = strings.TrimSpace(name)
+	if addr, err := mail.ParseAddress(name); err == nil {
+		return []EmailAddress{{Name: addr.Name, Address: addr.Address}}, nil
+	}
+	contacts, err := c.ListContacts(ctx, "DisplayName eq "+quoteOData(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		if contacts, err = c.SearchContacts(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	var addrs []EmailAddress
+	for _, ct := range contacts {
+		for _, a := range ct.EmailAddresses {
+			if a.Address == "" {
+				continue
+			}
+			if a.Name == "" {
+				a.Name = ct.DisplayName
+			}
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
+
+// quoteOData returns s as an OData string literal.
+func quoteOData(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
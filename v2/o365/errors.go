@@ -0,0 +1,116 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Some well-known error codes returned by the service.
+const (
+	ErrorItemNotFound                  = "ErrorItemNotFound"
+	ErrorAccessDenied                  = "ErrorAccessDenied"
+	ErrorInvalidIDMalformed            = "ErrorInvalidIdMalformed"
+	ErrorMailboxNotEnabledForRESTAPI   = "MailboxNotEnabledForRESTAPI"
+	ErrorMailboxNotSupportedForRESTAPI = "MailboxNotSupportedForRESTAPI"
+	ErrorQuotaExceeded                 = "ErrorQuotaExceeded"
+	ErrorInvalidRequest                = "ErrorInvalidRequest"
+	ErrorFolderExists                  = "ErrorFolderExists"
+	ErrorApplicationThrottled          = "ApplicationThrottled"
+	ErrorInvalidAuthenticationToken    = "InvalidAuthenticationToken"
+	ErrorTooManyConcurrentConnections  = "ErrorTooManyConcurrentConnectionsOpened"
+)
+
+// maxErrorBodySize is the maximum number of bytes read from an error response.
+const maxErrorBodySize = 64 << 10
+
+// O365Error is the parsed OData error response.
+type O365Error struct {
+	// Date of the error, as returned in innerError.
+	Date time.Time
+	// Method and Path of the request.
+	Method, Path string
+	// Status is the HTTP status line, StatusCode is its code.
+	Status string
+	// Code is the OData error code, such as ErrorItemNotFound.
+	Code string
+	// Message is the human-readable error message.
+	Message string
+	// RequestID is the request-id from innerError, needed for support requests.
+	RequestID  string
+	StatusCode int
+}
+
+func (e *O365Error) Error() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s %q: %s", e.Method, e.Path, e.Status)
+	if e.Code != "" {
+		buf.WriteString(" [" + e.Code + "]")
+	}
+	if e.Message != "" {
+		buf.WriteString(" " + e.Message)
+	}
+	if e.RequestID != "" {
+		buf.WriteString(" (request-id=" + e.RequestID + ")")
+	}
+	return buf.String()
+}
+
+// Is reports whether target is an *O365Error with the same Code (if set)
+// and StatusCode (if set), so errors.Is(err, &O365Error{Code: ErrorItemNotFound}) works.
+func (e *O365Error) Is(target error) bool {
+	var t *O365Error
+	if !errors.As(target, &t) || t == nil {
+		return false
+	}
+	return (t.Code == "" || t.Code == e.Code) &&
+		(t.StatusCode == 0 || t.StatusCode == e.StatusCode)
+}
+
+// HasErrorCode reports whether err is an *O365Error with the given code.
+func HasErrorCode(err error, code string) bool {
+	var oe *O365Error
+	return errors.As(err, &oe) && oe.Code == code
+}
+
+// newO365Error reads (at most maxErrorBodySize bytes of) the body of the response,
+// and parses it as an OData error.
+func newO365Error(method, path string, resp *http.Response, body io.Reader) *O365Error {
+	e := O365Error{
+		Method: method, Path: path,
+		Status: resp.Status, StatusCode: resp.StatusCode,
+	}
+	b, _ := io.ReadAll(io.LimitReader(body, maxErrorBodySize))
+	var data struct {
+		Error struct {
+			Code       string `json:"code"`
+			Message    string `json:"message"`
+			InnerError struct {
+				RequestID       string `json:"request-id"`
+				ClientRequestID string `json:"client-request-id"`
+				Date            string `json:"date"`
+			} `json:"innerError"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil || data.Error.Code == "" {
+		e.Message = strings.TrimSpace(string(b))
+	} else {
+		e.Code, e.Message = data.Error.Code, data.Error.Message
+		e.RequestID = data.Error.InnerError.RequestID
+		if s := data.Error.InnerError.Date; s != "" {
+			e.Date, _ = time.Parse(time.RFC3339, s)
+		}
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("request-id")
+	}
+	return &e
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PropertyType is the MAPI type of an extended property.
+type PropertyType string
+
+const (
+	PropertyBinary          = PropertyType("Binary")
+	PropertyBoolean         = PropertyType("Boolean")
+	PropertyCLSID           = PropertyType("CLSID")
+	PropertyCurrency        = PropertyType("Currency")
+	PropertyDouble          = PropertyType("Double")
+	PropertyFloat           = PropertyType("Float")
+	PropertyInteger         = PropertyType("Integer")
+	PropertyLong            = PropertyType("Long")
+	PropertyShort           = PropertyType("Short")
+	PropertyString          = PropertyType("String")
+	PropertySystemTime      = PropertyType("SystemTime")
+	PropertyBinaryArray     = PropertyType("BinaryArray")
+	PropertyIntegerArray    = PropertyType("IntegerArray")
+	PropertyLongArray       = PropertyType("LongArray")
+	PropertyStringArray     = PropertyType("StringArray")
+	PropertySystemTimeArray = PropertyType("SystemTimeArray")
+)
+
+// PropertyTag returns the property ID of a MAPI property identified by its tag,
+// such as PropertyTag(PropertyString, 0x007D) for PidTagTransportMessageHeaders.
+func PropertyTag(typ PropertyType, tag uint16) string {
+	return fmt.Sprintf("%s 0x%04x", typ, tag)
+}
+
+// PropertyName returns the property ID of a named property, identified by
+// the namespace GUID and its name: "String {guid} Name name".
+func PropertyName(typ PropertyType, guid, name string) string {
+	return string(typ) + " {" + strings.Trim(guid, "{}") + "} Name " + name
+}
+
+// PropertyNameID returns the property ID of a named property, identified by
+// the namespace GUID and its numeric ID: "Integer {guid} Id 0x8233".
+func PropertyNameID(typ PropertyType, guid string, id uint16) string {
+	return fmt.Sprintf("%s {%s} Id 0x%04x", typ, strings.Trim(guid, "{}"), id)
+}
+
+// WithExtendedProperties expands the given extended properties of the listed messages,
+// into SingleValueExtendedProperties and MultiValueExtendedProperties.
+func WithExtendedProperties(propIDs ...string) ListOption {
+	return func(o *listOptions) { o.Expand = append(o.Expand, expandExtendedProperties(propIDs)...) }
+}
+
+func expandExtendedProperties(propIDs []string) []string {
+	if len(propIDs) == 0 {
+		return nil
+	}
+	var buf strings.Builder
+	for i, id := range propIDs {
+		if i != 0 {
+			buf.WriteString(" or ")
+		}
+		buf.WriteString("PropertyId eq '" + strings.ReplaceAll(id, "'", "''") + "'")
+	}
+	filter := "($filter=" + buf.String() + ")"
+	return []string{
+		"SingleValueExtendedProperties" + filter,
+		"MultiValueExtendedProperties" + filter,
+	}
+}
+
+// GetExtendedProperties returns the requested extended properties of the message,
+// the single-valued ones in single, the multi-valued ones in multi, keyed by the property ID.
+//
+// Note that the returned property IDs may be normalized by the service (for example the tags are lowercased).
+func (c *client) GetExtendedProperties(ctx context.Context, msgID string, propIDs ...string) (single map[string]string, multi map[string][]string, err error) {
+	values := url.Values{"$select": {"Id"}}
+	if expand := expandExtendedProperties(propIDs); len(expand) != 0 {
+		values.Set("$expand", strings.Join(expand, ","))
+	}
+	var msg Message
+	if err = c.getJSON(ctx, "/messages/"+msgID+"?"+values.Encode(), &msg); err != nil {
+		return nil, nil, err
+	}
+	single = make(map[string]string, len(msg.SingleValueExtendedProperties))
+	for _, p := range msg.SingleValueExtendedProperties {
+		single[p.PropertyID] = p.Value
+	}
+	multi = make(map[string][]string, len(msg.MultiValueExtendedProperties))
+	for _, p := range msg.MultiValueExtendedProperties {
+		multi[p.PropertyID] = p.Value
+	}
+	return single, multi, nil
+}
+
+// SetExtendedProperties creates or updates the given extended properties of the message.
+func (c *client) SetExtendedProperties(ctx context.Context, msgID string, single map[string]string, multi map[string][]string) error {
+	var upd struct {
+		Single []SingleValueLegacyExtendedProperty `json:"SingleValueExtendedProperties,omitempty"`
+		Multi  []MultiValueLegacyExtendedProperty  `json:"MultiValueExtendedProperties,omitempty"`
+	}
+	for k, v := range single {
+		upd.Single = append(upd.Single, SingleValueLegacyExtendedProperty{PropertyID: k, Value: v})
+	}
+	for k, v := range multi {
+		upd.Multi = append(upd.Multi, MultiValueLegacyExtendedProperty{PropertyID: k, Value: v})
+	}
+	if len(upd.Single) == 0 && len(upd.Multi) == 0 {
+		return nil
+	}
+	return c.sendJSON(ctx, "PATCH", "/messages/"+msgID, upd, nil)
+}
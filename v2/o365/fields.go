@@ -0,0 +1,60 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import "strings"
+
+// Field is a property of the Message, to be selected with $select.
+type Field string
+
+const (
+	FieldID                         = Field("Id")
+	FieldChangeKey                  = Field("ChangeKey")
+	FieldCreated                    = Field("CreatedDateTime")
+	FieldLastModified               = Field("LastModifiedDateTime")
+	FieldReceived                   = Field("ReceivedDateTime")
+	FieldSent                       = Field("SentDateTime")
+	FieldFrom                       = Field("From")
+	FieldSender                     = Field("Sender")
+	FieldTo                         = Field("ToRecipients")
+	FieldCc                         = Field("CcRecipients")
+	FieldBcc                        = Field("BccRecipients")
+	FieldReplyTo                    = Field("ReplyTo")
+	FieldSubject                    = Field("Subject")
+	FieldBody                       = Field("Body")
+	FieldBodyPreview                = Field("BodyPreview")
+	FieldUniqueBody                 = Field("UniqueBody")
+	FieldImportance                 = Field("Importance")
+	FieldInferenceClassification    = Field("InferenceClassification")
+	FieldConversationID             = Field("ConversationId")
+	FieldParentFolderID             = Field("ParentFolderId")
+	FieldInternetMessageID          = Field("InternetMessageId")
+	FieldCategories                 = Field("Categories")
+	FieldHasAttachments             = Field("HasAttachments")
+	FieldIsDeliveryReceiptRequested = Field("IsDeliveryReceiptRequested")
+	FieldIsReadReceiptRequested     = Field("IsReadReceiptRequested")
+	FieldIsDraft                    = Field("IsDraft")
+	FieldIsRead                     = Field("IsRead")
+	FieldWebLink                    = Field("WebLink")
+	FieldFlag                       = Field("Flag")
+)
+
+// DefaultListFields are the fields List selects without WithSelect.
+var DefaultListFields = []Field{FieldSender, FieldSubject}
+
+// WithSelect selects only the given fields of the listed messages (the Id is always returned),
+// instead of DefaultListFields.
+func WithSelect(fields ...Field) ListOption {
+	return func(o *listOptions) { o.Select = append(o.Select, fields...) }
+}
+
+// selectQuery returns the $select value of the fields.
+func selectQuery(fields []Field) string {
+	ss := make([]string, len(fields))
+	for i, f := range fields {
+		ss[i] = string(f)
+	}
+	return strings.Join(ss, ",")
+}
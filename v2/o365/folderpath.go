@@ -0,0 +1,136 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// msgFolderRoot is the well-known name of the root of the folder hierarchy.
+const msgFolderRoot = "msgfolderroot"
+
+// GetFolder returns the folder.
+func (c *client) GetFolder(ctx context.Context, folderID string) (Folder, error) {
+	var f Folder
+	body, err := c.get(ctx, "/MailFolders/"+folderID)
+	if err != nil {
+		return f, err
+	}
+	defer body.Close()
+	err = json.NewDecoder(body).Decode(&f)
+	return f, err
+}
+
+// childFolder returns the child folder of parent named name (case insensitively).
+func (c *client) childFolder(ctx context.Context, parent, name string) (Folder, bool, error) {
+	body, err := c.get(ctx, "/MailFolders/"+parent+"/childfolders?"+url.Values{
+		"$filter": {"DisplayName eq '" + strings.ReplaceAll(name, "'", "''") + "'"},
+	}.Encode())
+	if err != nil {
+		return Folder{}, false, err
+	}
+	defer body.Close()
+	var resp struct {
+		Value []Folder `json:"value"`
+	}
+	if err = json.NewDecoder(body).Decode(&resp); err != nil || len(resp.Value) == 0 {
+		return Folder{}, false, err
+	}
+	return resp.Value[0], true, nil
+}
+
+// FolderByPath returns the folder of the path ("a/b/c"), walking down from the root
+// (or from the folder whose well-known name or ID is the first element, such as "Inbox/b/c").
+func (c *client) FolderByPath(ctx context.Context, path string) (Folder, error) {
+	return c.folderByPath(ctx, path, false)
+}
+
+// EnsureFolderPath returns the folder of the path as FolderByPath,
+// creating the missing folders on the way.
+func (c *client) EnsureFolderPath(ctx context.Context, path string) (Folder, error) {
+	return c.folderByPath(ctx, path, true)
+}
+
+func (c *client) folderByPath(ctx context.Context, path string, create bool) (Folder, error) {
+	names := imapclient.SplitFolderPath(imapclient.PathSeparator, path)
+	if len(names) == 0 {
+		return Folder{}, fmt.Errorf("empty path %q", path)
+	}
+	f := Folder{ID: msgFolderRoot}
+	if imapclient.IsWellKnownFolder(names[0]) {
+		var err error
+		if f, err = c.GetFolder(ctx, names[0]); err != nil {
+			return f, err
+		}
+		names = names[1:]
+	}
+	for i, nm := range names {
+		child, found, err := c.childFolder(ctx, f.ID, nm)
+		if err != nil {
+			return child, fmt.Errorf("%s: %w", nm, err)
+		}
+		if !found && i == 0 && f.ID == msgFolderRoot {
+			// the first element may be the ID of the folder
+			if child, err = c.GetFolder(ctx, nm); err == nil {
+				found = true
+			}
+		}
+		if !found {
+			if !create {
+				return child, fmt.Errorf("%s: folder %q not found", path, nm)
+			}
+			if err = c.sendJSON(ctx, "POST", "/MailFolders/"+f.ID+"/childfolders",
+				struct{ DisplayName string }{DisplayName: nm}, &child); err != nil {
+				return child, fmt.Errorf("create %q: %w", nm, err)
+			}
+		}
+		f = child
+	}
+	return f, nil
+}
+
+// FolderPath returns the path ("a/b/c") of the folder, walking up its parents to the root.
+func (c *client) FolderPath(ctx context.Context, folderID string) (string, error) {
+	root, err := c.GetFolder(ctx, msgFolderRoot)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for id := folderID; id != "" && id != root.ID; {
+		f, err := c.GetFolder(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, f.Name)
+		id = f.ParentID
+	}
+	slices.Reverse(names)
+	return imapclient.JoinFolderPath(imapclient.PathSeparator, names...), nil
+}
+
+// Delimiter returns imapclient.PathSeparator: the folder paths are resolved to IDs by the adapter.
+func (c *oClient) Delimiter(context.Context) (string, error) { return imapclient.PathSeparator, nil }
+
+// EnsureFolderPath creates the missing folders of the path, and returns the ID of the last one.
+func (c *oClient) EnsureFolderPath(ctx context.Context, path string) (string, error) {
+	f, err := c.client.EnsureFolderPath(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	if c.folders == nil {
+		c.folders = make(map[string]string)
+	}
+	c.folders[strings.ToLower(path)] = f.ID
+	c.mu.Unlock()
+	return f.ID, nil
+}
@@ -10,7 +10,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"net/textproto"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -29,14 +29,20 @@ type graphMailClient struct {
 	folders map[string]graph.Folder
 	u2s     map[uint32]string
 	s2u     map[string]uint32
+	// u2f holds the folder ID of the message, needed for moving it.
+	u2f map[uint32]string
+	// u2r holds the receive time of the listed messages, for the INTERNALDATE of FetchArgs.
+	u2r map[uint32]time.Time
 
 	logger *slog.Logger
 
+	window imapclient.SearchWindow
+	imapclient.StatsCounter
 	seq uint32
 }
 
 func NewGraphMailClient(ctx context.Context, clientID, clientSecret, tenantID, userID string) (*graphMailClient, error) {
-	gmc, err := graph.NewGraphMailClient(ctx, tenantID, clientID, clientSecret)
+	gmc, _, err := graph.NewGraphMailClient(ctx, tenantID, clientID, clientSecret, "")
 	if err != nil {
 		return nil, err
 	}
@@ -67,11 +73,15 @@ func NewGraphMailClient(ctx context.Context, clientID, clientSecret, tenantID, u
 }
 
 var _ imapclient.Client = (*graphMailClient)(nil)
+var _ imapclient.MailboxManager = (*graphMailClient)(nil)
+var _ imapclient.SearchWindowSetter = (*graphMailClient)(nil)
 
 func (g *graphMailClient) init(ctx context.Context, mbox string) error {
 	if g.u2s == nil {
 		g.u2s = make(map[uint32]string)
 		g.s2u = make(map[string]uint32)
+		g.u2f = make(map[uint32]string)
+		g.u2r = make(map[uint32]time.Time)
 	}
 	if g.folders == nil {
 		g.folders = make(map[string]graph.Folder)
@@ -134,6 +144,7 @@ func (g *graphMailClient) init(ctx context.Context, mbox string) error {
 }
 func (g *graphMailClient) SetLogger(lgr *slog.Logger)                       { g.logger = lgr }
 func (g *graphMailClient) SetLogMask(imapclient.LogMask) imapclient.LogMask { return false }
+func (g *graphMailClient) SetSearchWindow(w imapclient.SearchWindow)        { g.window = w }
 func (g *graphMailClient) Close(ctx context.Context, commit bool) error     { return ErrNotSupported }
 func (g *graphMailClient) Mailboxes(ctx context.Context, root string) ([]string, error) {
 	if err := g.init(ctx, root); err != nil {
@@ -147,6 +158,41 @@ func (g *graphMailClient) Mailboxes(ctx context.Context, root string) ([]string,
 	}
 	return folders, nil
 }
+func (g *graphMailClient) CreateMailbox(ctx context.Context, mbox string) error {
+	start := time.Now()
+	_, err := g.GraphMailClient.CreateFolder(ctx, g.userID, mbox)
+	g.CountCommand(start, err)
+	g.folders = nil
+	return err
+}
+func (g *graphMailClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	if err := g.init(ctx, mbox); err != nil {
+		return err
+	}
+	mID, err := g.m2s(mbox)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = g.GraphMailClient.DeleteFolder(ctx, g.userID, mID)
+	g.CountCommand(start, err)
+	g.folders = nil
+	return err
+}
+func (g *graphMailClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	if err := g.init(ctx, mbox); err != nil {
+		return err
+	}
+	mID, err := g.m2s(mbox)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = g.GraphMailClient.RenameFolder(ctx, g.userID, mID, newName)
+	g.CountCommand(start, err)
+	g.folders = nil
+	return err
+}
 func (g *graphMailClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
 	m := make(map[uint32]map[string][]string, len(msgIDs))
 	var wantMIME bool
@@ -179,23 +225,22 @@ func (g *graphMailClient) FetchArgs(ctx context.Context, what string, msgIDs ...
 				continue
 			}
 
-			m[mID] = hdrs
-			if strings.Contains(what, "RFC822.SIZE") {
-				m[mID]["RFC822.SIZE"] = []string{"0"}
+			m[mID] = decodeHeaderFields(hdrs)
+		}
+		if strings.Contains(what, "INTERNALDATE") {
+			if received, ok := g.u2r[mID]; ok {
+				m[mID]["INTERNALDATE"] = []string{received.Format(time.RFC3339)}
+				continue
 			}
-			if strings.Contains(what, "RFC822.HEADER") {
-				var buf strings.Builder
-				for k, vv := range hdrs {
-					k = textproto.CanonicalMIMEHeaderKey(k)
-					for _, v := range vv {
-						buf.WriteString(k)
-						buf.WriteString(":\t")
-						buf.WriteString(v)
-						buf.WriteString("\r\n")
-					}
+			// Not listed by List or FindByMessageID.
+			msg, err := g.GraphMailClient.GetMessage(ctx, g.userID, s, odata.Query{Select: []string{"receivedDateTime"}})
+			if err != nil {
+				g.logger.Error("GetMessage", "msgID", s, "error", err)
+				if firstErr == nil {
+					firstErr = err
 				}
-				buf.WriteString("\r\n")
-				m[mID]["RFC822.HEADER"] = []string{buf.String()}
+			} else if !msg.Received.IsZero() {
+				m[mID]["INTERNALDATE"] = []string{msg.Received.Format(time.RFC3339)}
 			}
 		}
 	}
@@ -204,6 +249,39 @@ func (g *graphMailClient) FetchArgs(ctx context.Context, what string, msgIDs ...
 	}
 	return m, nil
 }
+
+// decodeHeaderFields returns the raw internetMessageHeaders decoded as the other fields,
+// with the charsets of the encoded words under the "<field>.CHARSET" keys.
+func decodeHeaderFields(hdrs map[string][]string) map[string][]string {
+	m := make(map[string][]string, len(hdrs))
+	for k, vv := range hdrs {
+		decoded := make([]string, len(vv))
+		var charsets []string
+		for i, v := range vv {
+			var charset string
+			decoded[i], charset, _ = imapclient.DecodeHeader(v)
+			if charset != "" && !slices.Contains(charsets, charset) {
+				charsets = append(charsets, charset)
+			}
+		}
+		m[k] = decoded
+		if len(charsets) != 0 {
+			m[k+".CHARSET"] = charsets
+		}
+	}
+	return m
+}
+
+// UniqueBody returns the part of the body which is unique to the message in its conversation, as plain text.
+func (g *graphMailClient) UniqueBody(ctx context.Context, msgID uint32) (string, error) {
+	start := time.Now()
+	msg, err := g.GraphMailClient.GetMessage(ctx, g.userID, g.u2s[msgID], odata.Query{Select: []string{"uniqueBody"}})
+	g.CountCommand(start, err)
+	if err != nil {
+		return "", err
+	}
+	return ItemBody{ContentType: msg.UniqueBody.ContentType, Content: msg.UniqueBody.Content}.Text(), nil
+}
 func (g *graphMailClient) Peek(ctx context.Context, w io.Writer, msgID uint32, what string) (int64, error) {
 	return 0, ErrNotImplemented
 }
@@ -211,11 +289,13 @@ func (g *graphMailClient) Delete(ctx context.Context, msgID uint32) error {
 	if err := g.init(ctx, ""); err != nil {
 		return err
 	}
-	mID, err := g.m2s("deleted items")
+	mID, err := g.m2s(imapclient.DeletedItems)
 	if err != nil {
 		return err
 	}
-	_, err = g.GraphMailClient.MoveMessage(ctx, g.userID, g.u2s[msgID], mID)
+	start := time.Now()
+	_, err = g.GraphMailClient.MoveMessage(ctx, g.userID, g.u2f[msgID], g.u2s[msgID], mID)
+	g.CountCommand(start, err)
 	return err
 }
 func (g *graphMailClient) Select(ctx context.Context, mbox string) error {
@@ -228,6 +308,7 @@ func (g *graphMailClient) WriteTo(ctx context.Context, mbox string, msg []byte,
 	return ErrNotImplemented
 }
 func (g *graphMailClient) Connect(ctx context.Context) error {
+	g.CountConnect()
 	return g.init(ctx, "")
 }
 func (g *graphMailClient) Move(ctx context.Context, msgID uint32, mbox string) error {
@@ -238,7 +319,9 @@ func (g *graphMailClient) Move(ctx context.Context, msgID uint32, mbox string) e
 	if err != nil {
 		return nil
 	}
-	_, err = g.GraphMailClient.MoveMessage(ctx, g.userID, g.u2s[msgID], mID)
+	start := time.Now()
+	_, err = g.GraphMailClient.MoveMessage(ctx, g.userID, g.u2f[msgID], g.u2s[msgID], mID)
+	g.CountCommand(start, err)
 	return err
 }
 func (g *graphMailClient) Mark(ctx context.Context, msgID uint32, seen bool) error {
@@ -249,11 +332,17 @@ func (g *graphMailClient) Mark(ctx context.Context, msgID uint32, seen bool) err
 	} else {
 		buf.WriteString(`false}`)
 	}
+	start := time.Now()
 	_, err := g.GraphMailClient.UpdateMessage(ctx, g.userID, g.u2s[msgID], json.RawMessage(buf.String()))
+	g.CountCommand(start, err)
 	return err
 }
 func (g *graphMailClient) m2s(mbox string) (string, error) {
 	mbox = strings.ToLower(mbox)
+	// Graph accepts the well-known folder names in place of the folder ID.
+	if imapclient.IsWellKnownFolder(mbox) {
+		return mbox, nil
+	}
 	if mf, ok := g.folders[mbox]; ok {
 		return mf.ID, nil
 	}
@@ -286,11 +375,20 @@ func (g *graphMailClient) List(ctx context.Context, mbox, pattern string, all bo
 		g.logger.Error("m2s", "mbox", mbox, "error", err)
 		return nil, err
 	}
-	query := odata.Query{Filter: "isRead eq false"}
+	query := odata.Query{Filter: "isRead eq false", Select: []string{"id", "receivedDateTime"}}
 	if pattern != "" {
 		query.Filter += " and contains(subject, " + strings.ReplaceAll(strconv.Quote(pattern), `"`, "'") + ")"
 	}
+	if since := g.window.Start(time.Now()); !since.IsZero() {
+		query.Filter += " and receivedDateTime ge " + since.UTC().Format(time.RFC3339)
+	}
+	if g.window.Max > 0 {
+		query.Top = g.window.Max
+		query.OrderBy = odata.OrderBy{Field: "receivedDateTime", Direction: odata.Descending}
+	}
+	start := time.Now()
 	msgs, err := g.GraphMailClient.ListMessages(ctx, g.userID, mID, query)
+	g.CountCommand(start, err)
 	if err != nil {
 		g.logger.Error("folder", "id", mID, "name", mbox, "query", query, "error", err)
 		return nil, err
@@ -306,11 +404,111 @@ func (g *graphMailClient) List(ctx context.Context, mbox, pattern string, all bo
 		if !ok {
 			u = atomic.AddUint32(&g.seq, 1)
 			g.u2s[u] = s
+			g.s2u[s] = u
+		}
+		g.u2f[u] = mID
+		if !m.Received.IsZero() {
+			g.u2r[u] = m.Received
+		}
+		ids = append(ids, u)
+	}
+	return g.window.Filter(ids), nil
+}
+
+// FindByMessageID returns the UIDs of the messages in mbox (inbox if empty) with the given Message-ID.
+func (g *graphMailClient) FindByMessageID(ctx context.Context, mbox, messageID string) ([]uint32, error) {
+	mbox = nvl(mbox, "inbox")
+	if err := g.init(ctx, mbox); err != nil {
+		return nil, err
+	}
+	mID, err := g.m2s(mbox)
+	if err != nil {
+		return nil, err
+	}
+	messageID = imapclient.NormalizeMessageID(messageID)
+	query := odata.Query{
+		Filter: "internetMessageId eq '" + strings.ReplaceAll(messageID, "'", "''") + "'",
+		Select: []string{"id", "receivedDateTime"},
+	}
+	start := time.Now()
+	msgs, err := g.GraphMailClient.ListMessages(ctx, g.userID, mID, query)
+	g.CountCommand(start, err)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint32, 0, len(msgs))
+	for _, m := range msgs {
+		u, ok := g.s2u[m.ID]
+		if !ok {
+			u = atomic.AddUint32(&g.seq, 1)
+			g.u2s[u] = m.ID
+			g.s2u[m.ID] = u
+		}
+		g.u2f[u] = mID
+		if !m.Received.IsZero() {
+			g.u2r[u] = m.Received
 		}
 		ids = append(ids, u)
 	}
 	return ids, nil
 }
 func (g *graphMailClient) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64, error) {
-	return g.GraphMailClient.GetMIMEMessage(ctx, w, g.userID, g.u2s[msgID])
+	start := time.Now()
+	n, err := g.GraphMailClient.GetMIMEMessage(ctx, w, g.userID, g.u2s[msgID])
+	g.CountCommand(start, err)
+	g.CountFetched(n)
+	return n, err
+}
+
+// Delimiter returns imapclient.PathSeparator, as the folders are looked up by their paths.
+func (g *graphMailClient) Delimiter(context.Context) (string, error) {
+	return imapclient.PathSeparator, nil
+}
+
+// EnsureFolderPath creates the missing folders of the path ("a/b/c"),
+// and returns the path, usable as mbox.
+func (g *graphMailClient) EnsureFolderPath(ctx context.Context, path string) (string, error) {
+	names := imapclient.SplitFolderPath(imapclient.PathSeparator, path)
+	if len(names) == 0 {
+		return "", fmt.Errorf("empty path %q", path)
+	}
+	if err := g.init(ctx, ""); err != nil {
+		return "", err
+	}
+	add := func(key string, f graph.Folder) {
+		g.folders[strings.ToLower(key)] = f
+		g.folders["{"+f.ID+"}"] = f
+	}
+	parentID, err := g.m2s(names[0])
+	if err == nil {
+		err = g.init(ctx, names[0])
+	} else {
+		start := time.Now()
+		var f graph.Folder
+		f, err = g.GraphMailClient.CreateFolder(ctx, g.userID, names[0])
+		g.CountCommand(start, err)
+		if err == nil {
+			add(names[0], f)
+			parentID = f.ID
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	for i := 1; i < len(names); i++ {
+		key := strings.ToLower(imapclient.JoinFolderPath(imapclient.PathSeparator, names[:i+1]...))
+		if f, ok := g.folders[key]; ok {
+			parentID = f.ID
+			continue
+		}
+		start := time.Now()
+		f, err := g.GraphMailClient.CreateChildFolder(ctx, g.userID, parentID, names[i])
+		g.CountCommand(start, err)
+		if err != nil {
+			return "", fmt.Errorf("create %q: %w", key, err)
+		}
+		add(key, f)
+		parentID = f.ID
+	}
+	return imapclient.JoinFolderPath(imapclient.PathSeparator, names...), nil
 }
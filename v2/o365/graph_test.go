@@ -0,0 +1,28 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecodeHeaderFields(t *testing.T) {
+	raw := "=?iso-8859-2?Q?=C1rv=ED?="
+	hdrs := map[string][]string{"SUBJECT": {raw, "plain"}, "FROM": {"a@b.c"}}
+	m := decodeHeaderFields(hdrs)
+	if got := m["SUBJECT"]; !slices.Equal(got, []string{"Árví", "plain"}) {
+		t.Errorf("SUBJECT: got %q", got)
+	}
+	if got := m["SUBJECT.CHARSET"]; !slices.Equal(got, []string{"iso-8859-2"}) {
+		t.Errorf("SUBJECT.CHARSET: got %q", got)
+	}
+	if _, ok := m["FROM.CHARSET"]; ok || !slices.Equal(m["FROM"], []string{"a@b.c"}) {
+		t.Errorf("FROM: got %q", m)
+	}
+	if hdrs["SUBJECT"][0] != raw || len(hdrs) != 2 {
+		t.Errorf("the raw headers are modified: %q", hdrs)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+)
+
+// The possible values of InferenceClassificationType.
+const (
+	Focused = InferenceClassificationType("Focused")
+	Other   = InferenceClassificationType("Other")
+)
+
+// WithInferenceClassification filters the messages by their inference classification,
+// to process only the Focused (or the Other) inbox.
+func WithInferenceClassification(ict InferenceClassificationType) ListOption {
+	return WithFilter("InferenceClassification eq '" + string(ict) + "'")
+}
+
+// InferenceClassificationOverride is a user override for how incoming messages
+// from a specific sender should always be classified.
+type InferenceClassificationOverride struct {
+	// The unique identifier of the override.
+	ID string `json:"Id,omitempty"`
+	// Specifies how incoming messages from a specific sender should always be classified as.
+	ClassifyAs InferenceClassificationType `json:",omitempty"`
+	// The email address information of the sender for whom the override is created.
+	SenderEmailAddress EmailAddress `json:",omitempty"`
+}
+
+const inferenceOverridesPath = "/InferenceClassification/Overrides"
+
+// ListInferenceClassificationOverrides returns the inference classification overrides of the mailbox.
+func (c *client) ListInferenceClassificationOverrides(ctx context.Context) ([]InferenceClassificationOverride, error) {
+	var resp struct {
+		Value []InferenceClassificationOverride `json:"value"`
+	}
+	err := c.getJSON(ctx, inferenceOverridesPath, &resp)
+	return resp.Value, err
+}
+
+// CreateInferenceClassificationOverride creates an override for the sender,
+// to always classify their messages as classifyAs.
+func (c *client) CreateInferenceClassificationOverride(ctx context.Context, sender EmailAddress, classifyAs InferenceClassificationType) (InferenceClassificationOverride, error) {
+	o := InferenceClassificationOverride{ClassifyAs: classifyAs, SenderEmailAddress: sender}
+	err := c.sendJSON(ctx, "POST", inferenceOverridesPath, o, &o)
+	return o, err
+}
+
+// UpdateInferenceClassificationOverride changes the classification of the override.
+func (c *client) UpdateInferenceClassificationOverride(ctx context.Context, overrideID string, classifyAs InferenceClassificationType) error {
+	body, err := c.p(ctx, "PATCH", inferenceOverridePath(overrideID),
+		bytes.NewReader(jsonObj("ClassifyAs", string(classifyAs))))
+	if body != nil {
+		body.Close()
+	}
+	return err
+}
+
+// DeleteInferenceClassificationOverride deletes the override.
+func (c *client) DeleteInferenceClassificationOverride(ctx context.Context, overrideID string) error {
+	return c.delete(ctx, inferenceOverridePath(overrideID))
+}
+
+func inferenceOverridePath(overrideID string) string {
+	return inferenceOverridesPath + "('" + url.PathEscape(overrideID) + "')"
+}
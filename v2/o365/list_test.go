@@ -0,0 +1,25 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeValues(t *testing.T) {
+	const resp = `{"@odata.context":"x","value":[{"Id":"a","Subject":"A"},{"Id":"b","Subject":"B"}],"@odata.nextLink":"next"}`
+	var ids []string
+	next, err := decodeValues(strings.NewReader(resp), func(msg Message) error {
+		ids = append(ids, msg.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "next" || strings.Join(ids, ",") != "a,b" {
+		t.Errorf("got %q, %q", ids, next)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/browser"
+	"golang.org/x/oauth2"
+)
+
+// DefaultLoginTimeout is the deadline of Login, if LoginOptions.Timeout is zero.
+const DefaultLoginTimeout = 5 * time.Minute
+
+// LoginOptions control the local callback server of Login.
+type LoginOptions struct {
+	// Open presents the authorization URL to the user - browser.OpenURL if nil.
+	Open func(authURL string) error
+	// Addr is the address the callback server listens on, "127.0.0.1:0" (a random port) if empty.
+	Addr string
+	// Path is the path of the redirect URL, "/" if empty.
+	Path string
+	// SuccessHTML is the page shown after a successful login, a short notice if empty.
+	SuccessHTML string
+	// Timeout is the deadline of the whole login, DefaultLoginTimeout if zero.
+	Timeout time.Duration
+}
+
+const loginSuccessHTML = `<!DOCTYPE html><html><body><p>Login succeeded, you can close this window.</p></body></html>`
+
+// ErrLoginTimeout is returned by Login when the user did not finish the login in time.
+var ErrLoginTimeout = errors.New("login timed out")
+
+// Login runs the interactive (authorization code with PKCE) login: starts a callback server
+// on a local port, opens the authorization URL, waits for the redirect, exchanges the code for a token,
+// then stops the server.
+//
+// The redirect URL is http://127.0.0.1:port/path (the listened address), which must be registered
+// for the application (Azure AD accepts any port for the loopback redirect URLs).
+// The loopback IP is used instead of localhost, which may be resolved to ::1 by the browser.
+// The obtained token is used by the client from then on, and returned to be saved.
+func (c *client) Login(ctx context.Context, opts LoginOptions) (*oauth2.Token, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLoginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := opts.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %q: %w", addr, err)
+	}
+	path := "/" + strings.TrimPrefix(opts.Path, "/")
+	conf := *c.Config
+	conf.RedirectURL = "http://" + redirectHost(ln.Addr()) + path
+
+	var b [24]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	state := base64.RawURLEncoding.EncodeToString(b[:])
+	verifier := oauth2.GenerateVerifier()
+	successHTML := opts.SuccessHTML
+	if successHTML == "" {
+		successHTML = loginSuccessHTML
+	}
+
+	type result struct {
+		tok *oauth2.Token
+		err error
+	}
+	resultCh := make(chan result, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+		var res result
+		if e := q.Get("error"); e != "" {
+			res.err = authError(&oauth2.RetrieveError{ErrorCode: e, ErrorDescription: q.Get("error_description")})
+		} else {
+			res.tok, res.err = conf.Exchange(ctx, q.Get("code"), oauth2.VerifierOption(verifier))
+			res.err = authError(res.err)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if res.err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "<!DOCTYPE html><html><body><p>Login failed: "+html.EscapeString(res.err.Error())+"</p></body></html>")
+		} else {
+			io.WriteString(w, successHTML)
+		}
+		select {
+		case resultCh <- res:
+		default: // a late duplicate
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	defer func() {
+		shutCtx, shutCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		srv.Shutdown(shutCtx)
+		shutCancel()
+	}()
+
+	authURL := conf.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	open := opts.Open
+	if open == nil {
+		open = browser.OpenURL
+	}
+	c.logger.Info("Login", "url", authURL, "redirect", conf.RedirectURL)
+	if err = open(authURL); err != nil {
+		return nil, fmt.Errorf("open %q: %w", authURL, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", ErrLoginTimeout, timeout)
+		}
+		return nil, ctx.Err()
+	case res := <-resultCh:
+		if res.err != nil {
+			return nil, res.err
+		}
+		c.SetTokenSource(conf.TokenSource(context.Background(), res.tok))
+		return res.tok, nil
+	}
+}
+
+// redirectHost returns the host:port of the redirect URL for the listener address:
+// 127.0.0.1 for the unspecified IPs.
+func redirectHost(addr net.Addr) string {
+	host, port, _ := net.SplitHostPort(addr.String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
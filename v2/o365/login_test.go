@@ -0,0 +1,90 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "the-code" || r.Form.Get("code_verifier") == "" {
+			t.Errorf("token request: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"at","refresh_token":"rt","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer srv.Close()
+	c := &client{logger: slog.Default(), Config: &oauth2.Config{
+		ClientID: "id", RedirectURL: "http://localhost:8123",
+		Endpoint: oauth2.Endpoint{AuthURL: srv.URL + "/authorize", TokenURL: srv.URL + "/token"},
+	}}
+	ctx := context.Background()
+
+	var page string
+	tok, err := c.Login(ctx, LoginOptions{
+		Path:        "cb",
+		SuccessHTML: "welcome",
+		Open: func(authURL string) error {
+			u, err := url.Parse(authURL)
+			if err != nil {
+				return err
+			}
+			q := u.Query()
+			if q.Get("code_challenge") == "" {
+				t.Errorf("no PKCE challenge in %q", authURL)
+			}
+			redirect := q.Get("redirect_uri")
+			if !strings.HasPrefix(redirect, "http://127.0.0.1:") || !strings.HasSuffix(redirect, "/cb") {
+				t.Errorf("redirect_uri=%q", redirect)
+			}
+			// A wrong state is refused.
+			if resp, err := http.Get(redirect + "?code=the-code&state=x"); err != nil {
+				return err
+			} else if resp.Body.Close(); resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("wrong state: got %s", resp.Status)
+			}
+			resp, err := http.Get(redirect + "?code=the-code&state=" + url.QueryEscape(q.Get("state")))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			page = string(b)
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "at" || tok.RefreshToken != "rt" || page != "welcome" {
+		t.Errorf("got %#v, page %q", tok, page)
+	}
+	if got, _ := c.TokenSource.Token(); got == nil || got.AccessToken != "at" {
+		t.Errorf("TokenSource gives %#v", got)
+	}
+	if c.Config.RedirectURL != "http://localhost:8123" {
+		t.Errorf("RedirectURL changed to %q", c.Config.RedirectURL)
+	}
+
+	if _, err = c.Login(ctx, LoginOptions{
+		Timeout: 50 * time.Millisecond,
+		Open:    func(string) error { return nil },
+	}); !errors.Is(err, ErrLoginTimeout) {
+		t.Errorf("timeout: got %+v", err)
+	}
+}
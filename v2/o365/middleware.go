@@ -0,0 +1,170 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Middleware wraps a http.RoundTripper with some extra behaviour.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is a func implementing http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// WithMiddleware appends the middlewares to the client's HTTP transport chain.
+//
+// The first middleware is the outermost: it sees the request first and the response last.
+// The innermost transport is the one adding the OAuth2 token.
+func WithMiddleware(mw ...Middleware) ClientOption {
+	return func(o *clientOptions) { o.Middlewares = append(o.Middlewares, mw...) }
+}
+
+// chain wraps rt with the middlewares.
+func chain(rt http.RoundTripper, mws []Middleware) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		rt = mws[i](rt)
+	}
+	return rt
+}
+
+// HeaderMiddleware sets the given header on every request,
+// for example "Prefer", `outlook.timezone="Central Europe Standard Time"`.
+func HeaderMiddleware(key, value string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Add(key, value)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// LoggingMiddleware logs each request's method, URL, response status and duration.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			dur := time.Since(start)
+			if err != nil {
+				logger.Error("http", "method", req.Method, "url", req.URL.String(), "dur", dur.String(), "error", err)
+			} else {
+				logger.Info("http", "method", req.Method, "url", req.URL.String(), "status", resp.Status, "dur", dur.String())
+			}
+			return resp, err
+		})
+	}
+}
+
+// RateLimitMiddleware waits for the limiter before each request.
+func RateLimitMiddleware(limiter *rate.Limiter) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if err := limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// RetryMiddleware retries the request at most maxRetries times on network errors,
+// and on 429 Too Many Requests, 502 Bad Gateway, 503 Service Unavailable and 504 Gateway Timeout
+// responses.
+//
+// Only the idempotent requests (GET, HEAD, PUT, DELETE) are retried so. The others (such as sendMail,
+// reply or move, which are POSTs) may have been executed by the server when the error occurs,
+// so they are retried only on 429 and 503 responses, or on errors before the request was written.
+//
+// The wait between the tries is the Retry-After header's value if present,
+// or backoff doubled on each try.
+//
+// Requests with a body are only retried if the body can be rewound (req.GetBody is set).
+func RetryMiddleware(maxRetries int, backoff time.Duration) Middleware {
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			wait := backoff
+			for i := 0; ; i++ {
+				var wrote atomic.Bool
+				resp, err := next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(),
+					&httptrace.ClientTrace{WroteRequest: func(httptrace.WroteRequestInfo) { wrote.Store(true) }})))
+				if i >= maxRetries || !shouldRetry(req.Method, wrote.Load(), resp, err) ||
+					req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+					return resp, err
+				}
+				d := wait
+				wait *= 2
+				if resp != nil {
+					if s := resp.Header.Get("Retry-After"); s != "" {
+						if secs, err := strconv.Atoi(s); err == nil && secs >= 0 {
+							d = time.Duration(secs) * time.Second
+						}
+					}
+					io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodySize))
+					resp.Body.Close()
+				}
+				if req.GetBody != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req = req.Clone(ctx)
+					req.Body = body
+				}
+				timer := time.NewTimer(d)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		})
+	}
+}
+
+// shouldRetry reports whether the request of method can be retried after resp or err.
+// wrote reports whether the request has been written.
+func shouldRetry(method string, wrote bool, resp *http.Response, err error) bool {
+	idempotent := method == http.MethodGet || method == http.MethodHead ||
+		method == http.MethodPut || method == http.MethodDelete
+	if err != nil {
+		if !idempotent {
+			// The server could not have executed it.
+			return !wrote
+		}
+		var nerr net.Error
+		return errors.As(err, &nerr) && nerr.Timeout() ||
+			errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// The request was not processed.
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
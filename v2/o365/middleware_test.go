@@ -0,0 +1,88 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryMiddleware(t *testing.T) {
+	var status atomic.Int32
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	cl := &http.Client{Transport: RetryMiddleware(2, time.Millisecond)(http.DefaultTransport)}
+
+	for _, tc := range []struct {
+		Method string
+		Status int
+		Tries  int32
+	}{
+		{"GET", http.StatusBadGateway, 3},
+		{"DELETE", http.StatusGatewayTimeout, 3},
+		{"POST", http.StatusBadGateway, 1},
+		{"POST", http.StatusGatewayTimeout, 1},
+		{"POST", http.StatusServiceUnavailable, 3},
+		{"POST", http.StatusTooManyRequests, 3},
+		{"POST", http.StatusOK, 1},
+	} {
+		status.Store(int32(tc.Status))
+		served.Store(0)
+		req, _ := http.NewRequest(tc.Method, srv.URL, strings.NewReader("body"))
+		resp, err := cl.Do(req)
+		if err != nil {
+			t.Fatalf("%s %d: %+v", tc.Method, tc.Status, err)
+		}
+		resp.Body.Close()
+		if got := served.Load(); got != tc.Tries {
+			t.Errorf("%s %d: tried %d times, wanted %d", tc.Method, tc.Status, got, tc.Tries)
+		}
+	}
+
+	// The POST is retried if it could not be written.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	var tries atomic.Int32
+	cl.Transport = RetryMiddleware(2, time.Millisecond)(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		tries.Add(1)
+		return http.DefaultTransport.RoundTrip(req)
+	}))
+	req, _ := http.NewRequest("POST", "http://"+addr, strings.NewReader("body"))
+	if _, err := cl.Do(req); err == nil {
+		t.Fatal("wanted connection error")
+	}
+	if got := tries.Load(); got != 3 {
+		t.Errorf("unwritten POST: tried %d times, wanted 3", got)
+	}
+
+	// The POST is not retried if the connection is lost after it was written,
+	// as the server may have executed it.
+	tries.Store(0)
+	drop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer drop.Close()
+	req, _ = http.NewRequest("POST", drop.URL, strings.NewReader("body"))
+	if _, err := cl.Do(req); err == nil {
+		t.Fatal("wanted connection error")
+	}
+	if got := tries.Load(); got != 1 {
+		t.Errorf("written POST: tried %d times, wanted 1", got)
+	}
+}
@@ -7,7 +7,10 @@ package o365
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,23 +18,64 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/tgulacsi/imapclient/v2"
 	"github.com/tgulacsi/oauth2client"
 )
 
-const baseURL = "https://outlook.office.com/api/v2.0"
-
 type client struct {
 	*oauth2.Config
 	oauth2.TokenSource
-	logger *slog.Logger
-	Me     string
+	logger      *slog.Logger
+	Me          string
+	cloud       Cloud
+	consumer    bool // a personal (outlook.com) mailbox, see Consumer
+	prefer      []string
+	middlewares []Middleware
+	timeout     time.Duration
+	// maxMessageSize is the size limit of the mailbox, see MaxMessageSize.
+	maxMessageSize int64
+	tsMu           sync.RWMutex
+	imapclient.StatsCounter
+
+	wireBytes, decodedBytes atomic.Int64
+}
+
+// SetTokenSource replaces the TokenSource: the requests in flight complete
+// with the old token, the next ones use the new TokenSource.
+//
+// The TokenSource is wrapped by oauth2.ReuseTokenSource, so the concurrent requests
+// share its cached token.
+func (c *client) SetTokenSource(ts oauth2.TokenSource) {
+	if ts != nil {
+		ts = oauth2.ReuseTokenSource(nil, ts)
+	}
+	c.tsMu.Lock()
+	c.TokenSource = ts
+	c.tsMu.Unlock()
+}
+
+// TransferStats is the statistics of the received response bodies.
+type TransferStats struct {
+	// WireBytes is the number of bytes received, as transferred (maybe compressed).
+	WireBytes int64
+	// DecodedBytes is the number of bytes after decompression.
+	DecodedBytes int64
+}
+
+// TransferStats returns the transfer statistics of the client.
+func (c *client) TransferStats() TransferStats {
+	return TransferStats{WireBytes: c.wireBytes.Load(), DecodedBytes: c.decodedBytes.Load()}
 }
 
 type clientOptions struct {
@@ -39,6 +83,13 @@ type clientOptions struct {
 	TLSCertFile, TLSKeyFile string
 	Impersonate             string
 	TenantID                string
+	Cloud                   Cloud
+	Consumer                bool
+	BodyContentType         string
+	TimeZone                string
+	Middlewares             []Middleware
+	RequestTimeout          time.Duration
+	MaxMessageSize          int64
 	ReadOnly                bool
 }
 type ClientOption func(*clientOptions)
@@ -51,17 +102,69 @@ func TLS(certFile, keyFile string) ClientOption {
 }
 func Impersonate(email string) ClientOption { return func(o *clientOptions) { o.Impersonate = email } }
 
-func NewClient(clientID, clientSecret, redirectURL string, options ...ClientOption) *client {
+// BodyContentType requests the message bodies in the given format ("text" or "html")
+// with the Prefer: outlook.body-content-type header on all GETs.
+func BodyContentType(typ string) ClientOption {
+	return func(o *clientOptions) { o.BodyContentType = typ }
+}
+
+// TimeZone requests the date-time fields in the given time zone (such as "Central Europe Standard Time")
+// with the Prefer: outlook.timezone header on all GETs.
+func TimeZone(tz string) ClientOption { return func(o *clientOptions) { o.TimeZone = tz } }
+
+// RequestTimeout limits the duration of each request, including the reading of its response body.
+func RequestTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) { o.RequestTimeout = d }
+}
+
+// DefaultMaxMessageSize is the default message size limit of Exchange Online.
+const DefaultMaxMessageSize = 35 << 20
+
+// MaxMessageSize sets the message size limit of the mailbox (its MaxSendSize),
+// if it differs from DefaultMaxMessageSize: the Graph API does not tell it.
+// The larger messages are refused by WriteTo with an imapclient.TooLargeError, without uploading them.
+func MaxMessageSize(size int64) ClientOption {
+	return func(o *clientOptions) { o.MaxMessageSize = size }
+}
+
+// ValidateOptions checks the arguments of NewClient, which panics on the same errors:
+// the missing clientID or clientSecret, an invalid Cloud, and the options not fitting a Consumer account.
+func ValidateOptions(clientID, clientSecret string, options ...ClientOption) error {
+	_, err := newClientOptions(clientID, clientSecret, options)
+	return err
+}
+
+// newClientOptions returns the checked options.
+func newClientOptions(clientID, clientSecret string, options []ClientOption) (clientOptions, error) {
+	opts := clientOptions{MaxMessageSize: DefaultMaxMessageSize}
 	if clientID == "" || clientSecret == "" {
-		panic("clientID and clientSecret is a must!")
+		return opts, errors.New("clientID and clientSecret is a must")
 	}
-	if redirectURL == "" {
-		redirectURL = "http://localhost:8123"
-	}
-	var opts clientOptions
 	for _, f := range options {
 		f(&opts)
 	}
+	if err := opts.Cloud.orGlobal().Validate(); err != nil {
+		return opts, err
+	}
+	if opts.Consumer {
+		tenant, err := checkConsumer(opts)
+		if err != nil {
+			return opts, err
+		}
+		opts.TenantID = tenant
+	}
+	return opts, nil
+}
+
+// NewClient returns a new client - it panics on invalid arguments, see ValidateOptions.
+func NewClient(clientID, clientSecret, redirectURL string, options ...ClientOption) *client {
+	opts, err := newClientOptions(clientID, clientSecret, options)
+	if err != nil {
+		panic(err)
+	}
+	if redirectURL == "" {
+		redirectURL = "http://localhost:8123"
+	}
 	var sWrite string
 	if !opts.ReadOnly {
 		sWrite = "write"
@@ -70,18 +173,18 @@ func NewClient(clientID, clientSecret, redirectURL string, options ...ClientOpti
 		redirectURL = "https" + redirectURL[4:]
 	}
 
+	cloud := opts.Cloud.orGlobal()
+	scopes := []string{cloud.Scope("mail.read" + sWrite), "offline_access"}
+	if opts.Consumer {
+		scopes = append(scopes, "openid")
+	}
+
 	conf := &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURL:  redirectURL,
-		Scopes: []string{
-			"https://outlook.office.com/mail.read" + sWrite,
-			"offline_access",
-		},
-		Endpoint: oauth2client.AzureV2Endpoint,
-	}
-	if opts.TenantID != "" {
-		conf.Endpoint = oauth2client.AzureV2TenantEndpoint(opts.TenantID)
+		Scopes:       scopes,
+		Endpoint:     cloud.Endpoint(opts.TenantID),
 	}
 
 	tokensFile := opts.TokensFile
@@ -91,40 +194,73 @@ func NewClient(clientID, clientSecret, redirectURL string, options ...ClientOpti
 	if opts.Impersonate == "" {
 		opts.Impersonate = "me"
 	}
+	var prefer []string
+	if opts.BodyContentType != "" {
+		prefer = append(prefer, `outlook.body-content-type="`+opts.BodyContentType+`"`)
+	}
+	if opts.TimeZone != "" {
+		prefer = append(prefer, `outlook.timezone="`+opts.TimeZone+`"`)
+	}
 
 	return &client{
 		Config:      conf,
 		Me:          opts.Impersonate,
-		TokenSource: oauth2client.NewTokenSource(conf, tokensFile, opts.TLSCertFile, opts.TLSKeyFile),
+		cloud:       cloud,
+		consumer:    opts.Consumer,
+		TokenSource: oauth2.ReuseTokenSource(nil, oauth2client.NewTokenSource(conf, tokensFile, opts.TLSCertFile, opts.TLSKeyFile)),
 		logger:      slog.Default(),
+		prefer:      prefer,
+		middlewares: opts.Middlewares,
+		timeout:     opts.RequestTimeout,
+
+		maxMessageSize: opts.MaxMessageSize,
 	}
 }
 
 type confidentialTokenSource struct {
 	clientID, clientSecret, tenantID string
+	login                            string
+	// mu guards Client and clientOK.
+	mu sync.Mutex
 	confidential.Client
 	Scopes   []string
 	clientOK bool
 }
 
+// NewConfidentialTokenSource returns a TokenSource acquiring the tokens with the client credentials.
+// The authority is of the cloud of conf's Endpoint (the global cloud if it is not set).
 func NewConfidentialTokenSource(conf *oauth2.Config, tenantID string) *confidentialTokenSource {
 	return &confidentialTokenSource{
 		clientID: conf.ClientID, clientSecret: conf.ClientSecret,
 		tenantID: tenantID,
 		Scopes:   conf.Scopes,
+		login:    loginOf(conf.Endpoint.TokenURL),
+	}
+}
+
+// loginOf returns the root of the login URL (the Azure AD authority host) of the token URL.
+func loginOf(tokenURL string) string {
+	u, err := url.Parse(tokenURL)
+	if err != nil || u.Host == "" {
+		return CloudGlobal.Login
 	}
+	return u.Scheme + "://" + u.Host
+}
+
+// authority returns the Azure AD authority of the tenant.
+func (cts *confidentialTokenSource) authority() string {
+	return Cloud{Login: cts.login}.Authority(cts.tenantID)
 }
 
 func (cts *confidentialTokenSource) Token() (*oauth2.Token, error) {
+	cts.mu.Lock()
+	defer cts.mu.Unlock()
 	if !cts.clientOK {
 		cred, err := confidential.NewCredFromSecret(cts.clientSecret)
 		if err != nil {
 			return nil, fmt.Errorf("could not create a cred from a secret: %w", err)
 		}
-		cts.Client, err = confidential.New(
-			"https://login.microsoftonline.com/"+url.PathEscape(cts.tenantID),
-			cts.clientID, cred,
-		)
+		cts.Client, err = confidential.New(cts.authority(), cts.clientID, cred)
 		if err != nil {
 			return nil, fmt.Errorf("app: %w", err)
 		}
@@ -155,6 +291,12 @@ type Attachment struct {
 	Name string `json:",omitempty"`
 	// The length of the attachment in bytes.
 	Size int32 `json:",omitempty"`
+	// The ID of the attachment in the Exchange store.
+	ID string `json:"Id,omitempty"`
+	// The Content-ID of the inline attachment, referenced by the cid: URLs of the HTML body.
+	ContentID string `json:"ContentId,omitempty"`
+	// The contents of the file attachment.
+	ContentBytes []byte `json:",omitempty"`
 	// true if the attachment is an inline attachment; otherwise, false.
 	IsInline bool `json:",omitempty"`
 }
@@ -175,6 +317,15 @@ type ItemBody struct {
 	Content string `json:",omitempty"`
 }
 
+// Text returns the content as plain text, converting HTML with imapclient.HTMLToText.
+func (b ItemBody) Text() string {
+	if !strings.EqualFold(b.ContentType, "HTML") {
+		return b.Content
+	}
+	s, _ := imapclient.HTMLToText(strings.NewReader(b.Content))
+	return s
+}
+
 type Importance string
 type InferenceClassificationType string
 type SingleValueLegacyExtendedProperty struct {
@@ -207,10 +358,10 @@ type Message struct {
 	Received *time.Time `json:"ReceivedDateTime,omitempty"`
 	// A collection of multi-value extended properties of type MultiValueLegacyExtendedProperty. This is a navigation property. Find more information about extended properties.
 	// WF-
-	MultiValueExtendedProperties *MultiValueLegacyExtendedProperty `json:",omitempty"`
+	MultiValueExtendedProperties []MultiValueLegacyExtendedProperty `json:",omitempty"`
 	// A collection of single-value extended properties of type SingleValueLegacyExtendedProperty. This is a navigation property. Find more information about extended properties.
 	// WF-
-	SingleValueExtendedProperties *SingleValueLegacyExtendedProperty `json:",omitempty"`
+	SingleValueExtendedProperties []SingleValueLegacyExtendedProperty `json:",omitempty"`
 	// The mailbox owner and sender of the message.
 	// WFS
 	From *Recipient `json:",omitempty"`
@@ -291,67 +442,269 @@ type Message struct {
 	// Indicates whether the message has been read.
 	// WF-
 	IsRead bool `json:",omitempty"`
+	// The follow-up flag of the message.
+	// WF-
+	Flag *FollowupFlag `json:",omitempty"`
 	// Indicates whether a read receipt is requested for the message.
 	// WF-
 	IsReadReceiptRequested bool `json:",omitempty"`
+
+	// The OData type of the message, "#Microsoft.OutlookServices.EventMessage" for meeting messages.
+	// ---
+	ODataType string `json:"@odata.type,omitempty"`
+	// The type of the EventMessage: None, MeetingRequest, MeetingCancelled, MeetingAccepted, MeetingTenativelyAccepted, MeetingDeclined.
+	// -F-
+	MeetingMessageType MeetingMessageType `json:",omitempty"`
 }
 
-func (c *client) List(ctx context.Context, mbox, pattern string, all bool) ([]Message, error) {
+// FollowupFlag is the follow-up flag of a Message.
+type FollowupFlag struct {
+	// FlagStatus is NotFlagged, Complete or Flagged.
+	FlagStatus string `json:",omitempty"`
+}
+
+// Flagged reports whether the message is flagged for follow-up (mapped to the \Flagged IMAP flag).
+func (f *FollowupFlag) Flagged() bool { return f != nil && f.FlagStatus == "Flagged" }
+
+type listOptions struct {
+	OrderBy  string
+	Filters  []string
+	Select   []Field
+	Expand   []string
+	Top      int
+	Skip     int
+	AllPages bool
+}
+
+// ListOption modifies the query of List.
+type ListOption func(*listOptions)
+
+// WithFilter adds an OData $filter expression to the query.
+// More filters are joined with "and".
+func WithFilter(filter string) ListOption {
+	return func(o *listOptions) { o.Filters = append(o.Filters, filter) }
+}
+
+// WithTop limits the number of the listed messages.
+func WithTop(n int) ListOption {
+	return func(o *listOptions) { o.Top = n }
+}
+
+// WithSkip skips the first n messages - for paging with WithTop.
+func WithSkip(n int) ListOption {
+	return func(o *listOptions) { o.Skip = n }
+}
+
+// WithOrderBy sets the OData $orderby expression, such as "ReceivedDateTime desc".
+func WithOrderBy(orderBy string) ListOption {
+	return func(o *listOptions) { o.OrderBy = orderBy }
+}
+
+// WithAllPages follows the "@odata.nextLink" of the responses, listing all the pages,
+// not just the first one.
+func WithAllPages() ListOption {
+	return func(o *listOptions) { o.AllPages = true }
+}
+
+// List the messages in mbox (all folders if empty),
+// only the unread ones if all is false, with subject matching pattern (if not empty).
+func (c *client) List(ctx context.Context, mbox, pattern string, all bool, options ...ListOption) ([]Message, error) {
+	var msgs []Message
+	err := c.ListFunc(ctx, mbox, pattern, all, func(msg Message) error {
+		msgs = append(msgs, msg)
+		return nil
+	}, options...)
+	return msgs, err
+}
+
+// ListFunc is like List, but calls fn with each message as it is decoded from the response,
+// without holding the whole page in memory.
+//
+// If fn returns an error, the listing stops and that error is returned.
+func (c *client) ListFunc(ctx context.Context, mbox, pattern string, all bool, fn func(Message) error, options ...ListOption) error {
+	_, err := c.listFunc(ctx, mbox, pattern, all, fn, options)
+	return err
+}
+
+// listFunc is ListFunc, returning the number of the messages in the response -
+// including the read ones of a search, which are not passed to fn.
+func (c *client) listFunc(ctx context.Context, mbox, pattern string, all bool, fn func(Message) error, options []ListOption) (int, error) {
+	s, err := listPath(mbox, pattern, all, options)
+	if err != nil {
+		return 0, err
+	}
+	var opts listOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	var n int
+	for u := c.URLFor(s); u != ""; {
+		body, err := c.doURL(ctx, "GET", u, s, nil, nil)
+		if err != nil {
+			c.logger.Error("List", "path", s, "error", err)
+			return n, err
+		}
+		c.logger.Debug("List", "path", s, "url", u)
+		u, err = decodeValues(body, func(msg Message) error {
+			n++
+			if pattern != "" && !all && msg.IsRead {
+				return nil
+			}
+			return fn(msg)
+		})
+		body.Close()
+		if err != nil {
+			c.logger.Error("decode", "path", s, "error", err)
+			return n, err
+		}
+		if !opts.AllPages {
+			break
+		}
+	}
+	return n, nil
+}
+
+// listPath returns the path and query of the messages list.
+//
+// The service rejects $search together with $filter or $orderby, so with a pattern
+// the read messages are skipped by listFunc, and the other filters and the order are not supported.
+func listPath(mbox, pattern string, all bool, options []ListOption) (string, error) {
 	path := "/messages"
 	if mbox != "" {
 		path = "/MailFolders/" + mbox + "/messages"
 	}
+	var opts listOptions
+	for _, o := range options {
+		o(&opts)
+	}
 
-	values := url.Values{
-		"$select": {"Sender,Subject"},
+	fields := opts.Select
+	if len(fields) == 0 {
+		fields = DefaultListFields
 	}
+	values := url.Values{}
+	filters := opts.Filters
 	if pattern != "" {
+		if len(filters) != 0 || opts.OrderBy != "" {
+			return "", fmt.Errorf("search %q with $filter or $orderby: %w", pattern, ErrNotSupported)
+		}
 		values.Set("$search", `"subject:`+pattern+`"`)
+		if !all && !slices.Contains(fields, FieldIsRead) {
+			fields = append(slices.Clip(fields), FieldIsRead)
+		}
+	} else if !all {
+		filters = append([]string{"IsRead eq false"}, filters...)
+	}
+	values.Set("$select", selectQuery(fields))
+	if len(filters) != 0 {
+		values.Set("$filter", strings.Join(filters, " and "))
+	}
+	if len(opts.Expand) != 0 {
+		values.Set("$expand", strings.Join(opts.Expand, ","))
+	}
+	if opts.Top > 0 {
+		values.Set("$top", strconv.Itoa(opts.Top))
+	}
+	if opts.Skip > 0 {
+		values.Set("$skip", strconv.Itoa(opts.Skip))
 	}
-	if !all {
-		values.Set("$filter", "IsRead eq false")
+	if opts.OrderBy != "" {
+		values.Set("$orderby", opts.OrderBy)
 	}
+	return path + "?" + values.Encode(), nil
+}
 
-	s := path + "?" + values.Encode()
-	body, err := c.get(ctx, s)
-	if err != nil {
-		c.logger.Error("List", "path", s, "error", err)
-		return nil, err
+// decodeValues decodes the "value" array of an OData collection response element by element,
+// calling fn with each, and returns the "@odata.nextLink".
+func decodeValues[T any](r io.Reader, fn func(T) error) (nextLink string, err error) {
+	dec := json.NewDecoder(r)
+	if err = expectDelim(dec, '{'); err != nil {
+		return "", err
 	}
-	c.logger.Debug("List", "path", s)
-	defer func() {
-		io.Copy(io.Discard, body)
-		body.Close()
-	}()
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nextLink, err
+		}
+		switch key, _ := tok.(string); key {
+		case "value":
+			if err = expectDelim(dec, '['); err != nil {
+				return nextLink, err
+			}
+			for dec.More() {
+				var v T
+				if err = dec.Decode(&v); err != nil {
+					return nextLink, err
+				}
+				if err = fn(v); err != nil {
+					return nextLink, err
+				}
+			}
+			if err = expectDelim(dec, ']'); err != nil {
+				return nextLink, err
+			}
+		case "@odata.nextLink":
+			if err = dec.Decode(&nextLink); err != nil {
+				return nextLink, err
+			}
+		default:
+			var skip json.RawMessage
+			if err = dec.Decode(&skip); err != nil {
+				return nextLink, err
+			}
+		}
+	}
+	return nextLink, expectDelim(dec, '}')
+}
 
-	type listResponse struct {
-		Value []Message `json:"value"`
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
 	}
-	var resp listResponse
-	var buf bytes.Buffer
-	if err = json.NewDecoder(io.TeeReader(body, &buf)).Decode(&resp); err != nil {
-		b, _ := io.ReadAll(io.MultiReader(bytes.NewReader(buf.Bytes()), body))
-		c.logger.Error("decode", "listResponse", string(b))
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("got %v, wanted %q", tok, want)
 	}
-	c.logger.Debug("List", "resp", resp)
-	return resp.Value, nil
+	return nil
 }
 
-func (c *client) Get(ctx context.Context, msgID string) (Message, error) {
+// Get returns the message - only the given fields, if any, all of them otherwise.
+func (c *client) Get(ctx context.Context, msgID string, fields ...Field) (Message, error) {
 	path := "/messages/" + msgID
+	if len(fields) != 0 {
+		path += "?" + url.Values{"$select": {selectQuery(fields)}}.Encode()
+	}
 	var msg Message
 	body, err := c.get(ctx, path)
 	if err != nil {
 		return msg, err
 	}
-	defer func() {
-		io.Copy(io.Discard, body)
-		body.Close()
-	}()
+	defer body.Close()
 	err = json.NewDecoder(body).Decode(&msg)
 	return msg, err
 }
 
+// UniqueBody returns the part of the body which is unique to the message in its conversation.
+func (c *client) UniqueBody(ctx context.Context, msgID string) (ItemBody, error) {
+	var msg Message
+	if err := c.getJSON(ctx, "/messages/"+msgID+"?$select="+string(FieldUniqueBody), &msg); err != nil {
+		return ItemBody{}, err
+	}
+	if msg.UniqueBody == nil {
+		return ItemBody{}, nil
+	}
+	return *msg.UniqueBody, nil
+}
+
+// Attachments returns the attachments of the message, with their contents.
+func (c *client) Attachments(ctx context.Context, msgID string) ([]Attachment, error) {
+	var resp struct {
+		Value []Attachment `json:"value"`
+	}
+	err := c.getJSON(ctx, "/messages/"+msgID+"/attachments", &resp)
+	return resp.Value, err
+}
+
 func (c *client) Send(ctx context.Context, msg Message) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(struct {
@@ -371,27 +724,69 @@ func (c *client) post(ctx context.Context, path string, body io.Reader) error {
 	return err
 }
 func (c *client) p(ctx context.Context, method, path string, body io.Reader) (io.ReadCloser, error) {
+	return c.pHeader(ctx, method, path, body, nil)
+}
+
+// pHeader is p with the additional request headers.
+func (c *client) pHeader(ctx context.Context, method, path string, body io.Reader, header http.Header) (io.ReadCloser, error) {
 	if method == "" {
 		method = "POST"
 	}
-	var buf bytes.Buffer
-	req, err := http.NewRequest(method, c.URLFor(path), io.TeeReader(body, &buf))
-	req.Header.Set("Content-Type", "application/json")
+	buf := getBuffer()
+	if body != nil {
+		if _, err := buf.ReadFrom(body); err != nil {
+			putBuffer(buf)
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if header == nil {
+		header = make(http.Header, 1)
+	}
+	header.Set("Content-Type", "application/json")
+	rc, err := c.do(ctx, method, path, buf.Bytes(), header)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", path, err)
+		// The transport may still be reading buf, so it is not returned to the pool.
+		c.logger.Error(method, "path", path, "request", buf.String(), "error", err)
+		return rc, err
 	}
-	resp, err := oauth2.NewClient(ctx, c.TokenSource).Do(req)
+	return &releasingBody{ReadCloser: rc, buf: buf}, nil
+}
+
+// getJSON GETs the path and decodes the JSON response into dest.
+func (c *client) getJSON(ctx context.Context, path string, dest interface{}) error {
+	body, err := c.get(ctx, path)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", buf.String(), err)
+		return err
 	}
-	if resp.StatusCode > 299 {
-		defer resp.Body.Close()
-		io.Copy(&buf, body)
-		io.WriteString(&buf, "\n\n")
-		io.Copy(&buf, resp.Body)
-		return nil, fmt.Errorf("POST %q: %s\n%s", path, resp.Status, buf.Bytes())
+	defer body.Close()
+	if err = json.NewDecoder(body).Decode(dest); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
 	}
-	return resp.Body, nil
+	return nil
+}
+
+// sendJSON sends src as JSON with the given method to path,
+// and decodes the response into dest, if it is not nil.
+func (c *client) sendJSON(ctx context.Context, method, path string, src, dest interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if src != nil {
+		if err := json.NewEncoder(buf).Encode(src); err != nil {
+			return fmt.Errorf("encode %#v: %w", src, err)
+		}
+	}
+	body, err := c.p(ctx, method, path, buf)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if dest == nil {
+		return nil
+	}
+	if err = json.NewDecoder(body).Decode(dest); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
 }
 
 func (c *client) Delete(ctx context.Context, msgID string) error {
@@ -405,6 +800,17 @@ func (c *client) Copy(ctx context.Context, msgID, destinationID string) error {
 	return c.post(ctx, "/messages/"+msgID+"/copy", bytes.NewReader(jsonObj("DestinationId", destinationID)))
 }
 
+// Reply replies to the sender of the message, with the comment as body.
+func (c *client) Reply(ctx context.Context, msgID, comment string) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(struct {
+		Comment string
+	}{Comment: comment}); err != nil {
+		return err
+	}
+	return c.post(ctx, "/messages/"+msgID+"/reply", bytes.NewReader(buf.Bytes()))
+}
+
 func (c *client) Update(ctx context.Context, msgID string, upd map[string]interface{}) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(upd); err != nil {
@@ -435,15 +841,10 @@ func (c *client) ListFolders(ctx context.Context, parent string) ([]Folder, erro
 		path += "/" + parent + "/childfolders"
 	}
 	body, err := c.get(ctx, path)
-	if body != nil {
-		defer func() {
-			io.Copy(io.Discard, body)
-			body.Close()
-		}()
-	}
 	if err != nil {
 		return nil, err
 	}
+	defer body.Close()
 
 	type folderList struct {
 		Value []Folder `json:"value"`
@@ -453,12 +854,21 @@ func (c *client) ListFolders(ctx context.Context, parent string) ([]Folder, erro
 	return resp.Value, err
 }
 
+// CreateFolder creates the folder under parent - at the top level if parent is empty.
 func (c *client) CreateFolder(ctx context.Context, parent, folder string) error {
-	return c.post(ctx, "/MailFolders/"+parent+"/childfolders", bytes.NewReader(jsonObj("DisplayName", folder)))
+	path := "/MailFolders"
+	if parent != "" {
+		path += "/" + parent + "/childfolders"
+	}
+	return c.post(ctx, path, bytes.NewReader(jsonObj("DisplayName", folder)))
 }
 
 func (c *client) RenameFolder(ctx context.Context, folderID, newName string) error {
-	return c.post(ctx, "/MailFolders/"+folderID, bytes.NewReader(jsonObj("DisplayName", newName)))
+	body, err := c.p(ctx, "PATCH", "/MailFolders/"+folderID, bytes.NewReader(jsonObj("DisplayName", newName)))
+	if body != nil {
+		body.Close()
+	}
+	return err
 }
 func (c *client) MoveFolder(ctx context.Context, folderID, destinationID string) error {
 	return c.post(ctx, "/MailFolders/"+folderID+"/move", bytes.NewReader(jsonObj("DestinationId", destinationID)))
@@ -471,31 +881,219 @@ func (c *client) DeleteFolder(ctx context.Context, folderID string) error {
 	return c.delete(ctx, "/MailFolders/"+folderID)
 }
 
-func (c *client) URLFor(path string) string { return baseURL + "/" + c.Me + path }
+func (c *client) URLFor(path string) string { return c.cloud.orGlobal().APIURL() + "/" + c.Me + path }
+
+// httpClient returns an OAuth2-authenticated *http.Client, wrapped by the configured middlewares.
+//
+// The transport uses the (ReuseTokenSource-wrapped) TokenSource of the client directly,
+// so the concurrent requests share one cached token.
+func (c *client) httpClient(ctx context.Context) *http.Client {
+	c.tsMu.RLock()
+	ts := c.TokenSource
+	c.tsMu.RUnlock()
+	base := http.DefaultTransport
+	if hc, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && hc.Transport != nil {
+		base = hc.Transport
+	}
+	cl := &http.Client{Transport: base}
+	if ts != nil {
+		cl.Transport = &oauth2.Transport{Source: ts, Base: base}
+	}
+	if len(c.middlewares) != 0 {
+		cl.Transport = chain(cl.Transport, c.middlewares)
+	}
+	cl.Transport = c.countRequests(cl.Transport)
+	return cl
+}
 func (c *client) get(ctx context.Context, path string) (io.ReadCloser, error) {
-	URL := c.URLFor(path)
-	c.logger.Debug("get", "url", URL)
-	resp, err := oauth2.NewClient(ctx, c.TokenSource).Get(URL)
-	c.logger.Info("get", "resp", resp, "error", err)
+	c.logger.Debug("get", "path", path)
+	return c.do(ctx, "GET", path, nil, nil)
+}
+
+// maxDrainSize is the maximum number of bytes read from the rest of a response body on Close,
+// to be able to reuse the connection.
+const maxDrainSize = 256 << 10
+
+// do sends the request, and returns the (decompressed) response body, which must be closed.
+//
+// The error responses are returned as *O365Error, with their bodies read and closed;
+// the authentication failures as *AuthError, wrapping them.
+// Closing the body drains it (at most maxDrainSize bytes), so the connection can be reused.
+// The body (nil for none) is sent from a bytes.Reader, rewindable for RetryMiddleware.
+func (c *client) do(ctx context.Context, method, path string, body []byte, header http.Header) (io.ReadCloser, error) {
+	return c.doURL(ctx, method, c.URLFor(path), path, body, header)
+}
+
+// doURL is do with the full URL of the request - path is used only in the errors.
+func (c *client) doURL(ctx context.Context, method, u, path string, body []byte, header http.Header) (io.ReadCloser, error) {
+	ctx, cancel := c.requestContext(ctx)
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("%s: %w", path, err)
 	}
-	return resp.Body, nil
-}
-
-func (c *client) delete(ctx context.Context, path string) error {
-	req, err := http.NewRequest("DELETE", c.URLFor(path), nil)
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if method == "GET" {
+		for _, p := range c.prefer {
+			req.Header.Add("Prefer", p)
+		}
+	}
+	resp, err := c.httpClient(ctx).Do(req)
 	if err != nil {
-		return fmt.Errorf("%s: %w", path, err)
+		cancel()
+		return nil, authError(fmt.Errorf("%s %q: %w", method, path, err))
 	}
-	resp, err := oauth2.NewClient(ctx, c.TokenSource).Do(req)
+	resp.Body = &cancelBody{ReadCloser: resp.Body, ctx: ctx, cancel: cancel}
+	respBody, err := c.decodeBody(resp)
 	if err != nil {
-		return fmt.Errorf("%s: %w", req.URL.String(), err)
+		drainClose(resp.Body)
+		return nil, fmt.Errorf("%s %q: %w", method, path, err)
 	}
 	if resp.StatusCode > 299 {
-		return fmt.Errorf("DELETE %q: %s", path, resp.Status)
+		defer drainClose(respBody)
+		return nil, authError(newO365Error(method, path, resp, respBody))
 	}
-	return nil
+	return drainingBody{respBody}, nil
+}
+
+// drainingBody drains the body on Close.
+type drainingBody struct{ io.ReadCloser }
+
+func (b drainingBody) Close() error { return drainClose(b.ReadCloser) }
+
+// drainClose reads at most maxDrainSize bytes from the rest of rc, and closes it.
+func drainClose(rc io.ReadCloser) error {
+	_, _ = io.CopyN(io.Discard, rc, maxDrainSize)
+	return rc.Close()
+}
+
+// requestContext returns the context of a request, limited by the RequestTimeout.
+func (c *client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cancelBody is a response body which reports the error of its context
+// (instead of a "use of closed connection" when the context is done while reading),
+// and releases the context when closed.
+type cancelBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.ctx.Err() != nil {
+		err = fmt.Errorf("%w: %w", b.ctx.Err(), err)
+	}
+	return n, err
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// acceptEncoding is sent as Accept-Encoding.
+//
+// As we set it explicitly, the http.Transport won't decompress the response transparently,
+// so decodeBody must do it - but this way we can count the bytes on the wire.
+const acceptEncoding = "gzip, deflate"
+
+// decodeBody returns the decompressed body of the response, counting the transferred bytes.
+func (c *client) decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	wire := &countingReader{Reader: resp.Body, n: &c.wireBytes, count: c.CountFetched}
+	var r io.Reader = wire
+	var closer io.Closer
+	switch enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		r, closer = zr, zr
+	case "deflate":
+		zr, err := zlib.NewReader(wire)
+		if err != nil {
+			return nil, fmt.Errorf("deflate: %w", err)
+		}
+		r, closer = zr, zr
+	default:
+		return nil, fmt.Errorf("unknown Content-Encoding %q", enc)
+	}
+	return decodedBody{
+		Reader: &countingReader{Reader: r, n: &c.decodedBytes},
+		body:   resp.Body, decoder: closer,
+	}, nil
+}
+
+type decodedBody struct {
+	io.Reader
+	body    io.Closer
+	decoder io.Closer
+}
+
+func (d decodedBody) Close() error {
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+	return d.body.Close()
+}
+
+type countingReader struct {
+	io.Reader
+	n     *atomic.Int64
+	count func(int64)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n.Add(int64(n))
+	if cr.count != nil {
+		cr.count(int64(n))
+	}
+	return n, err
+}
+
+// countRequests wraps rt to count the requests in the Stats:
+// the uploaded bytes and the error status codes, too.
+func (c *client) countRequests(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := rt.RoundTrip(req)
+		if err == nil && resp.StatusCode > 299 {
+			c.CountCommand(start, errors.New(resp.Status))
+		} else {
+			c.CountCommand(start, err)
+		}
+		if err == nil && req.ContentLength > 0 {
+			c.CountUploaded(req.ContentLength)
+		}
+		return resp, err
+	})
+}
+
+func (c *client) delete(ctx context.Context, path string) error {
+	rc, err := c.do(ctx, "DELETE", path, nil, nil)
+	if rc != nil {
+		rc.Close()
+	}
+	return err
 }
 
 func jsonObj(key, value string) []byte {
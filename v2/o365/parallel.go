@@ -0,0 +1,105 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Parallel configures ListParallel.
+type Parallel struct {
+	// Concurrency is the number of pages fetched at once, 4 if zero.
+	Concurrency int
+	// PageSize is the $top of a page, 100 if zero.
+	PageSize int
+	// Ordered calls fn with the pages in order - otherwise as they arrive.
+	Ordered bool
+}
+
+// ListParallel lists all the messages of mbox like ListFunc, fetching pages with $skip/$top
+// windows concurrently - for the initial scan of big folders.
+//
+// fn is not called concurrently. As the pages are windows over the current state of the folder,
+// the messages added or removed during the scan may be missed or seen twice:
+// use a stable order (WithOrderBy) and deduplicate by ID if it matters.
+func (c *client) ListParallel(ctx context.Context, mbox, pattern string, all bool, par Parallel, fn func(Message) error, options ...ListOption) error {
+	concurrency, pageSize := par.Concurrency, par.PageSize
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	options = options[:len(options):len(options)]
+
+	grp, grpCtx := errgroup.WithContext(ctx)
+	var (
+		mu      sync.Mutex
+		next    int           // the next page to fetch
+		last    = math.MaxInt // the first not full page
+		emitted int           // the next page to emit, if Ordered
+		pending = make(map[int][]Message)
+	)
+	emit := func(msgs []Message) error {
+		for _, msg := range msgs {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for range concurrency {
+		grp.Go(func() error {
+			for {
+				mu.Lock()
+				k := next
+				if k > last {
+					mu.Unlock()
+					return nil
+				}
+				next++
+				mu.Unlock()
+
+				var msgs []Message
+				n, err := c.listFunc(grpCtx, mbox, pattern, all, func(msg Message) error {
+					msgs = append(msgs, msg)
+					return nil
+				}, append(options, WithTop(pageSize), WithSkip(k*pageSize)))
+				if err != nil {
+					return err
+				}
+
+				mu.Lock()
+				if n < pageSize && k < last {
+					last = k
+				}
+				if k > last { // fetched past the end
+				} else if !par.Ordered {
+					err = emit(msgs)
+				} else {
+					pending[k] = msgs
+					for err == nil {
+						page, ok := pending[emitted]
+						if !ok {
+							break
+						}
+						delete(pending, emitted)
+						emitted++
+						err = emit(page)
+					}
+				}
+				mu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+		})
+	}
+	return grp.Wait()
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity above which a request buffer is not returned to the pool.
+const maxPooledBufferSize = 1 << 20
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer { return bufferPool.Get().(*bytes.Buffer) }
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// releasingBody returns the buffer of the request body to the pool when the response body is closed -
+// the transport may read the request body till then.
+type releasingBody struct {
+	io.ReadCloser
+	buf *bytes.Buffer
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.buf != nil {
+		putBuffer(b.buf)
+		b.buf = nil
+	}
+	return err
+}
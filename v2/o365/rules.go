@@ -0,0 +1,123 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"net/url"
+)
+
+// MessageRule is a rule that applies to messages in the Inbox of the user.
+type MessageRule struct {
+	// Conditions are the conditions that when fulfilled, will trigger the actions of the rule.
+	Conditions *MessageRulePredicates `json:",omitempty"`
+	// Exceptions are the exception conditions for the rule.
+	Exceptions *MessageRulePredicates `json:",omitempty"`
+	// Actions to be taken on a message when the conditions are fulfilled.
+	Actions *MessageRuleActions `json:",omitempty"`
+	// The unique identifier of the rule.
+	ID string `json:"Id,omitempty"`
+	// The display name of the rule.
+	DisplayName string `json:",omitempty"`
+	// Indicates the order in which the rule is executed, among other rules.
+	Sequence int32 `json:",omitempty"`
+	// Indicates whether the rule is enabled to be applied to messages.
+	// A pointer, so UpdateRule leaves it unchanged if nil.
+	IsEnabled *bool `json:",omitempty"`
+	// Indicates whether the rule is in an error condition. Read-only.
+	HasError bool `json:",omitempty"`
+	// Indicates if the rule is read-only and cannot be modified or deleted by the rules API.
+	IsReadOnly bool `json:",omitempty"`
+}
+
+// MessageRulePredicates are the conditions and exceptions of a MessageRule.
+type MessageRulePredicates struct {
+	// Represents the strings that should appear in the body of an incoming message.
+	BodyContains []string `json:",omitempty"`
+	// Represents the strings that should appear in the body or subject of an incoming message.
+	BodyOrSubjectContains []string `json:",omitempty"`
+	// Represents the categories that an incoming message should be labeled with.
+	Categories []string `json:",omitempty"`
+	// Represents the specific sender email addresses of an incoming message.
+	FromAddresses []Recipient `json:",omitempty"`
+	// Represents the strings that appear in the headers of an incoming message.
+	HeaderContains []string `json:",omitempty"`
+	// Represents the strings that should appear in the toRecipients or ccRecipients properties of an incoming message.
+	RecipientContains []string `json:",omitempty"`
+	// Represents the strings that should appear in the from property of an incoming message.
+	SenderContains []string `json:",omitempty"`
+	// Represents the email addresses that an incoming message must have been sent to.
+	SentToAddresses []Recipient `json:",omitempty"`
+	// Represents the strings that appear in the subject of an incoming message.
+	SubjectContains []string `json:",omitempty"`
+	// The importance that is stamped on an incoming message.
+	Importance Importance `json:",omitempty"`
+	// Indicates whether an incoming message must have attachments.
+	HasAttachments bool `json:",omitempty"`
+	// Indicates whether an incoming message must be an automatic reply.
+	IsAutomaticReply bool `json:",omitempty"`
+	// Indicates whether an incoming message must be a meeting request.
+	IsMeetingRequest bool `json:",omitempty"`
+	// Indicates whether the owner of the mailbox must be the only recipient.
+	SentOnlyToMe bool `json:",omitempty"`
+}
+
+// MessageRuleActions are the actions of a MessageRule.
+type MessageRuleActions struct {
+	// A list of categories to be assigned to a message.
+	AssignCategories []string `json:",omitempty"`
+	// The email addresses of the recipients to which a message should be forwarded as an attachment.
+	ForwardAsAttachmentTo []Recipient `json:",omitempty"`
+	// The email addresses of the recipients to which a message should be forwarded.
+	ForwardTo []Recipient `json:",omitempty"`
+	// The email addresses to which a message should be redirected.
+	RedirectTo []Recipient `json:",omitempty"`
+	// The ID of a folder that a message is to be copied to.
+	CopyToFolder string `json:",omitempty"`
+	// The ID of the folder that a message will be moved to.
+	MoveToFolder string `json:",omitempty"`
+	// Sets the importance of the message.
+	MarkImportance Importance `json:",omitempty"`
+	// Indicates whether a message should be moved to the Deleted Items folder.
+	Delete bool `json:",omitempty"`
+	// Indicates whether a message should be marked as read.
+	MarkAsRead bool `json:",omitempty"`
+	// Indicates whether a message should be permanently deleted and not saved to the Deleted Items folder.
+	PermanentDelete bool `json:",omitempty"`
+	// Indicates whether subsequent rules should be evaluated.
+	StopProcessingRules bool `json:",omitempty"`
+}
+
+const rulesPath = "/MailFolders/Inbox/MessageRules"
+
+// ListRules returns the rules defined for the Inbox.
+func (c *client) ListRules(ctx context.Context) ([]MessageRule, error) {
+	var resp struct {
+		Value []MessageRule `json:"value"`
+	}
+	err := c.getJSON(ctx, rulesPath, &resp)
+	return resp.Value, err
+}
+
+// CreateRule creates the rule, and returns it as created (with ID).
+func (c *client) CreateRule(ctx context.Context, rule MessageRule) (MessageRule, error) {
+	var created MessageRule
+	err := c.sendJSON(ctx, "POST", rulesPath, rule, &created)
+	return created, err
+}
+
+// UpdateRule updates the rule identified by ruleID, and returns the updated rule.
+// Only the set (non-zero) fields of rule are changed.
+func (c *client) UpdateRule(ctx context.Context, ruleID string, rule MessageRule) (MessageRule, error) {
+	rule.ID = ""
+	var updated MessageRule
+	err := c.sendJSON(ctx, "PATCH", rulesPath+"/"+url.PathEscape(ruleID), rule, &updated)
+	return updated, err
+}
+
+// DeleteRule deletes the rule.
+func (c *client) DeleteRule(ctx context.Context, ruleID string) error {
+	return c.delete(ctx, rulesPath+"/"+url.PathEscape(ruleID))
+}
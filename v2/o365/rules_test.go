@@ -0,0 +1,55 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpdateRule(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" || r.URL.Path != "/api/v2.0/me/MailFolders/Inbox/MessageRules/r1" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":"ErrorItemNotFound","message":"`+r.URL.Path+`"}}`)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, body)
+		io.WriteString(w, `{"Id":"r1","DisplayName":"renamed","IsEnabled":true}`)
+	}))
+	defer srv.Close()
+	c := testClient(srv)
+	ctx := context.Background()
+
+	updated, err := c.UpdateRule(ctx, "r1", MessageRule{DisplayName: "renamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.IsEnabled == nil || !*updated.IsEnabled {
+		t.Errorf("got %+v", updated)
+	}
+	disabled := false
+	if _, err = c.UpdateRule(ctx, "r1", MessageRule{IsEnabled: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("got %d requests", len(bodies))
+	}
+	if _, ok := bodies[0]["IsEnabled"]; ok || bodies[0]["DisplayName"] != "renamed" {
+		t.Errorf("rename: sent %v", bodies[0])
+	}
+	if v, ok := bodies[1]["IsEnabled"]; !ok || v != false || len(bodies[1]) != 1 {
+		t.Errorf("disable: sent %v", bodies[1])
+	}
+}
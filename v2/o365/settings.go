@@ -0,0 +1,131 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Settings is the sub-client of the mailbox settings and the user photos - the sender info
+// to be rendered along a message.
+//
+// The methods take the user (an e-mail address or ID) to query; empty means the mailbox of the client.
+type Settings struct{ c *client }
+
+// Settings returns the sub-client of the mailbox settings and the user photos.
+func (c *client) Settings() Settings { return Settings{c: c} }
+
+// LocaleInfo is a language and country/region.
+type LocaleInfo struct {
+	// The locale, such as "en-US".
+	Locale string `json:",omitempty"`
+	// The name of the locale in natural language, such as "English (United States)".
+	DisplayName string `json:",omitempty"`
+}
+
+// WorkingHours are the days of the week and the hours in a time zone the user works.
+type WorkingHours struct {
+	// The days of the week the user works, such as "monday".
+	DaysOfWeek []string `json:",omitempty"`
+	// The time of the day the user starts working, in the "15:04:05.0000000" form.
+	StartTime string `json:",omitempty"`
+	// The time of the day the user stops working, in the "15:04:05.0000000" form.
+	EndTime string `json:",omitempty"`
+	// The time zone the working hours apply to.
+	TimeZone struct {
+		// The name of the time zone, such as "Pacific Standard Time".
+		Name string `json:",omitempty"`
+	} `json:",omitempty"`
+}
+
+// MailboxSettings are the settings of the user's mailbox.
+type MailboxSettings struct {
+	// The configuration of the automatic replies, see GetAutomaticRepliesSetting.
+	AutomaticRepliesSetting *AutomaticRepliesSetting `json:",omitempty"`
+	// The default time zone of the mailbox, such as "Pacific Standard Time".
+	TimeZone string `json:",omitempty"`
+	// The preferred language of the user.
+	Language *LocaleInfo `json:",omitempty"`
+	// The days and hours the user works.
+	WorkingHours *WorkingHours `json:",omitempty"`
+}
+
+// GetMailboxSettings returns the time zone, the language and the working hours of the user's mailbox.
+func (s Settings) GetMailboxSettings(ctx context.Context, user string) (MailboxSettings, error) {
+	var ms MailboxSettings
+	err := s.getJSON(ctx, user, "/MailboxSettings", &ms)
+	return ms, err
+}
+
+// maxPhotoSize is the maximum size of a user photo.
+const maxPhotoSize = 4 << 20
+
+// Photo is a profile photo of a user.
+type Photo struct {
+	// The MIME type of Data, such as "image/jpeg".
+	ContentType string `json:"@odata.mediaContentType,omitempty"`
+	// The ID of the size, such as "96X96".
+	ID     string `json:"Id,omitempty"`
+	Width  int    `json:",omitempty"`
+	Height int    `json:",omitempty"`
+	// The image itself.
+	Data []byte `json:"-"`
+}
+
+// GetPhoto returns the photo of the user, in the given size (such as "48x48", "96x96" or "240x240"),
+// or the largest available if size is empty.
+//
+// A user without a photo returns an ErrorItemNotFound *O365Error.
+func (s Settings) GetPhoto(ctx context.Context, user, size string) (Photo, error) {
+	path := "/photo"
+	if size != "" {
+		path = "/photos/" + url.PathEscape(size)
+	}
+	var p Photo
+	if err := s.getJSON(ctx, user, path, &p); err != nil {
+		return p, err
+	}
+	path += "/$value"
+	body, err := s.c.doURL(ctx, "GET", s.userURL(user, path), path, nil, nil)
+	if err != nil {
+		return p, err
+	}
+	defer body.Close()
+	if p.Data, err = io.ReadAll(io.LimitReader(body, maxPhotoSize+1)); err != nil {
+		return p, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(p.Data) > maxPhotoSize {
+		return p, fmt.Errorf("%s: photo is bigger than %d bytes", path, maxPhotoSize)
+	}
+	return p, nil
+}
+
+// userURL returns the URL of path of the user - the mailbox of the client if user is empty.
+func (s Settings) userURL(user, path string) string {
+	if user == "" {
+		return s.c.URLFor(path)
+	}
+	return s.c.cloud.orGlobal().APIURL() + "/users/" + url.PathEscape(user) + path
+}
+
+// getJSON GETs the path of the user and decodes the JSON response into dest.
+func (s Settings) getJSON(ctx context.Context, user, path string, dest interface{}) error {
+	if user == "" {
+		return s.c.getJSON(ctx, path, dest)
+	}
+	body, err := s.c.doURL(ctx, "GET", s.userURL(user, path), path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err = json.NewDecoder(body).Decode(dest); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestSettings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2.0/me/MailboxSettings":
+			io.WriteString(w, `{"TimeZone":"Central Europe Standard Time","Language":{"Locale":"hu-HU"},
+"WorkingHours":{"DaysOfWeek":["monday","friday"],"StartTime":"08:00:00.0000000","TimeZone":{"Name":"UTC"}}}`)
+		case "/api/v2.0/users/a@b.c/photos/48x48":
+			io.WriteString(w, `{"@odata.mediaContentType":"image/png","Id":"48X48","Width":48,"Height":48}`)
+		case "/api/v2.0/users/a@b.c/photos/48x48/$value":
+			io.WriteString(w, "PNG")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":"ErrorItemNotFound","message":"`+r.URL.Path+`"}}`)
+		}
+	}))
+	defer srv.Close()
+	c := testClient(srv)
+	ctx := context.Background()
+
+	ms, err := c.Settings().GetMailboxSettings(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.TimeZone != "Central Europe Standard Time" || ms.Language == nil || ms.Language.Locale != "hu-HU" ||
+		ms.WorkingHours == nil || len(ms.WorkingHours.DaysOfWeek) != 2 || ms.WorkingHours.TimeZone.Name != "UTC" {
+		t.Errorf("got %+v", ms)
+	}
+
+	p, err := c.Settings().GetPhoto(ctx, "a@b.c", "48x48")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ContentType != "image/png" || p.Width != 48 || string(p.Data) != "PNG" {
+		t.Errorf("got %+v", p)
+	}
+	if _, err = c.Settings().GetPhoto(ctx, "", ""); !HasErrorCode(err, ErrorItemNotFound) {
+		t.Errorf("no photo: got %+v", err)
+	}
+}
+
+// testClient returns a client whose requests are sent to srv.
+func testClient(srv *httptest.Server) *client {
+	srvURL, _ := url.Parse(srv.URL)
+	// The requests are authorized with a static token.
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	return &client{logger: slog.Default(), Me: "me", TokenSource: ts, middlewares: []Middleware{
+		func(rt http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req.URL.Scheme, req.URL.Host = srvURL.Scheme, srvURL.Host
+				return rt.RoundTrip(req)
+			})
+		},
+	}}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// ConversationFields are the fields ListConversation selects without WithSelect.
+var ConversationFields = []Field{
+	FieldConversationID, FieldParentFolderID, FieldInternetMessageID,
+	FieldFrom, FieldTo, FieldCc, FieldSubject, FieldReceived, FieldUniqueBody,
+}
+
+// conversationPageSize is the $top of a page of ListConversation.
+const conversationPageSize = 100
+
+// ListConversation returns all the messages of the conversation, from all the folders
+// (Inbox, Sent Items, archives...), ordered by their receive time - the full context of a thread
+// when a new reply arrives.
+//
+// The ConversationFields are selected, unless options has WithSelect.
+func (c *client) ListConversation(ctx context.Context, conversationID string, options ...ListOption) ([]Message, error) {
+	opts := make([]ListOption, 0, len(options)+4)
+	opts = append(opts, WithFilter("ConversationId eq "+quoteOData(conversationID)))
+	var lo listOptions
+	for _, o := range options {
+		o(&lo)
+	}
+	if len(lo.Select) == 0 {
+		opts = append(opts, WithSelect(ConversationFields...))
+	}
+	opts = append(opts, options...)
+
+	// $orderby with a $filter on another property is rejected as inefficient, so sort here.
+	var msgs []Message
+	for skip := 0; ; skip += conversationPageSize {
+		n := len(msgs)
+		if err := c.ListFunc(ctx, "", "", true, func(msg Message) error {
+			msgs = append(msgs, msg)
+			return nil
+		}, append(opts, WithTop(conversationPageSize), WithSkip(skip))...); err != nil {
+			return msgs, err
+		}
+		if len(msgs)-n < conversationPageSize {
+			break
+		}
+	}
+	received := func(msg Message) time.Time {
+		if msg.Received == nil {
+			return time.Time{}
+		}
+		return *msg.Received
+	}
+	slices.SortStableFunc(msgs, func(a, b Message) int { return received(a).Compare(received(b)) })
+	return msgs, nil
+}
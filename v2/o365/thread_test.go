@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListConversation(t *testing.T) {
+	const total = conversationPageSize + conversationPageSize/2
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var skips []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if f := q.Get("$filter"); f != "ConversationId eq 'conv'" {
+			t.Errorf("filter: got %q", f)
+		}
+		if q.Has("$orderby") {
+			t.Errorf("$orderby: %q", q.Get("$orderby"))
+		}
+		skip, _ := strconv.Atoi(q.Get("$skip"))
+		top, _ := strconv.Atoi(q.Get("$top"))
+		skips = append(skips, skip)
+		// Newest first, to be sorted by ListConversation.
+		var page struct {
+			Value []Message `json:"value"`
+		}
+		for i := skip; i < total && i < skip+top; i++ {
+			received := base.Add(time.Duration(total-i) * time.Minute)
+			page.Value = append(page.Value, Message{ID: strconv.Itoa(total - i), Received: &received})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer srv.Close()
+
+	msgs, err := testClient(srv).ListConversation(context.Background(), "conv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skips) != 2 || skips[0] != 0 || skips[1] != conversationPageSize {
+		t.Errorf("got $skip %v", skips)
+	}
+	if len(msgs) != total {
+		t.Fatalf("got %d messages, wanted %d", len(msgs), total)
+	}
+	for i, msg := range msgs {
+		if msg.ID != strconv.Itoa(i+1) {
+			t.Errorf("%d. got %q", i, msg.ID)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxSimpleAttachmentSize is the size above which the attachments are uploaded in an upload session.
+	MaxSimpleAttachmentSize = 3 << 20
+	// uploadChunkSize is the size of an uploaded chunk: it must be a multiple of 320 KiB.
+	uploadChunkSize = 10 * 320 << 10
+	// uploadRetries is the number of retries of a failed chunk.
+	uploadRetries = 3
+)
+
+// UploadProgress is called after each uploaded chunk with the number of uploaded and total bytes.
+type UploadProgress func(uploaded, total int64)
+
+// UploadSession is an attachment upload session, see CreateUploadSession.
+type UploadSession struct {
+	Expiration         time.Time `json:"ExpirationDateTime"`
+	UploadURL          string    `json:"UploadUrl"`
+	NextExpectedRanges []string  `json:"NextExpectedRanges"`
+}
+
+// CreateDraft creates a draft message, returned with its ID.
+func (c *client) CreateDraft(ctx context.Context, msg Message) (Message, error) {
+	var draft Message
+	err := c.sendJSON(ctx, "POST", "/messages", msg, &draft)
+	return draft, err
+}
+
+// CreateReplyDraft creates a draft reply to the message, with the comment as body.
+func (c *client) CreateReplyDraft(ctx context.Context, msgID, comment string) (Message, error) {
+	var draft Message
+	err := c.sendJSON(ctx, "POST", "/messages/"+msgID+"/createreply",
+		struct{ Comment string }{Comment: comment}, &draft)
+	return draft, err
+}
+
+// SendDraft sends the draft message.
+func (c *client) SendDraft(ctx context.Context, draftID string) error {
+	return c.post(ctx, "/messages/"+draftID+"/send", nil)
+}
+
+// Attach attaches the size bytes read from r to the draft message:
+// the small ones in one request, the larger ones than MaxSimpleAttachmentSize in an upload session.
+//
+// progress is called after each uploaded chunk, if not nil.
+func (c *client) Attach(ctx context.Context, draftID string, att Attachment, r io.ReaderAt, size int64, progress UploadProgress) error {
+	if size <= MaxSimpleAttachmentSize {
+		b := make([]byte, size)
+		if _, err := r.ReadAt(b, 0); err != nil && !(errors.Is(err, io.EOF) && len(b) == int(size)) {
+			return err
+		}
+		att.ContentBytes, att.Size = b, int32(size)
+		if err := c.sendJSON(ctx, "POST", "/messages/"+draftID+"/attachments", struct {
+			Type string `json:"@odata.type"`
+			Attachment
+		}{Type: "#Microsoft.OutlookServices.FileAttachment", Attachment: att}, nil); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(size, size)
+		}
+		return nil
+	}
+	sess, err := c.CreateUploadSession(ctx, draftID, att, size)
+	if err != nil {
+		return err
+	}
+	return c.Upload(ctx, sess, r, size, progress)
+}
+
+// CreateUploadSession creates an upload session for an attachment of the given size of the draft message.
+func (c *client) CreateUploadSession(ctx context.Context, draftID string, att Attachment, size int64) (UploadSession, error) {
+	type attachmentItem struct {
+		AttachmentType string
+		Name           string
+		ContentType    string `json:",omitempty"`
+		ContentID      string `json:"ContentId,omitempty"`
+		Size           int64
+		IsInline       bool `json:",omitempty"`
+	}
+	var sess UploadSession
+	err := c.sendJSON(ctx, "POST", "/messages/"+draftID+"/attachments/createUploadSession",
+		struct{ AttachmentItem attachmentItem }{AttachmentItem: attachmentItem{
+			AttachmentType: "file", Name: att.Name, ContentType: att.ContentType,
+			ContentID: att.ContentID, Size: size, IsInline: att.IsInline,
+		}}, &sess)
+	if err == nil && sess.UploadURL == "" {
+		err = errors.New("createUploadSession: no UploadUrl")
+	}
+	return sess, err
+}
+
+// Upload uploads the size bytes read from r in chunks to the session.
+//
+// A failed chunk is retried, continuing from the range the service expects next,
+// so an interrupted upload can be resumed with the same UploadSession.
+func (c *client) Upload(ctx context.Context, sess UploadSession, r io.ReaderAt, size int64, progress UploadProgress) error {
+	// The UploadUrl is pre-authenticated: sending the token is an error.
+	cl := &http.Client{Transport: c.countRequests(chain(http.DefaultTransport, c.middlewares))}
+	start := max(0, rangeStart(sess.NextExpectedRanges))
+	buf := make([]byte, uploadChunkSize)
+	var retries int
+	for start < size {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-start)], start)
+		if err != nil && !(errors.Is(err, io.EOF) && start+int64(n) == size) {
+			return fmt.Errorf("read at %d: %w", start, err)
+		}
+		next, done, err := c.putChunk(ctx, cl, sess.UploadURL, buf[:n], start, size)
+		if err != nil {
+			if retries++; retries > uploadRetries || ctx.Err() != nil {
+				return err
+			}
+			c.logger.Warn("upload chunk", "start", start, "retry", retries, "error", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(retries) * time.Second):
+			}
+			// ask the service where to continue
+			if next, err = c.uploadStatus(ctx, cl, sess.UploadURL); err != nil {
+				c.logger.Warn("upload status", "error", err)
+				continue
+			}
+		} else {
+			retries = 0
+		}
+		if done {
+			start = size
+		} else if next >= 0 {
+			start = next
+		}
+		if progress != nil {
+			progress(start, size)
+		}
+	}
+	return nil
+}
+
+// putChunk PUTs the chunk starting at start, and returns the start of the next expected range,
+// or done if the upload is complete.
+func (c *client) putChunk(ctx context.Context, cl *http.Client, uploadURL string, chunk []byte, start, size int64) (next int64, done bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return -1, false, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+int64(len(chunk))-1, size))
+	resp, err := cl.Do(req)
+	if err != nil {
+		return -1, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		io.Copy(io.Discard, resp.Body)
+		return size, true, nil
+	case http.StatusOK, http.StatusAccepted:
+		var sess UploadSession
+		if err = json.NewDecoder(resp.Body).Decode(&sess); err != nil || len(sess.NextExpectedRanges) == 0 {
+			return start + int64(len(chunk)), false, nil
+		}
+		return rangeStart(sess.NextExpectedRanges), false, nil
+	}
+	return -1, false, newO365Error("PUT", "uploadSession", resp, resp.Body)
+}
+
+// uploadStatus returns the start of the next expected range of the upload session.
+func (c *client) uploadStatus(ctx context.Context, cl *http.Client, uploadURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uploadURL, nil)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return -1, newO365Error("GET", "uploadSession", resp, resp.Body)
+	}
+	var sess UploadSession
+	if err = json.NewDecoder(resp.Body).Decode(&sess); err != nil {
+		return -1, err
+	}
+	return rangeStart(sess.NextExpectedRanges), nil
+}
+
+// rangeStart returns the start of the first range ("12345-" or "12345-67890"), -1 if there is none.
+func rangeStart(ranges []string) int64 {
+	if len(ranges) == 0 {
+		return -1
+	}
+	s, _, _ := strings.Cut(ranges[0], "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
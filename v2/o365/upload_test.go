@@ -0,0 +1,75 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package o365
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), (uploadChunkSize*5/2)/16)
+	var mu sync.Mutex
+	var got []byte
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == "GET" {
+			fmt.Fprintf(w, `{"NextExpectedRanges":["%d-"]}`, len(got))
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Error("Authorization sent to the pre-authenticated URL")
+		}
+		var start, end, total int
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r.Body)
+		if start != len(got) || end-start+1 != len(b) {
+			t.Errorf("got range %d-%d (%d bytes), wanted start %d", start, end, len(b), len(got))
+		}
+		if start > 0 && !failed { // fail the second chunk once
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		got = append(got, b...)
+		if len(got) == total {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Write([]byte(`{"NextExpectedRanges":["` + strconv.Itoa(len(got)) + `-"]}`))
+	}))
+	defer srv.Close()
+
+	c := &client{logger: slog.Default()}
+	var progress []int64
+	if err := c.Upload(context.Background(), UploadSession{UploadURL: srv.URL}, bytes.NewReader(data), int64(len(data)),
+		func(uploaded, total int64) { progress = append(progress, uploaded) },
+	); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("uploaded %d bytes, wanted %d", len(got), len(data))
+	}
+	if !failed {
+		t.Error("no retry")
+	}
+	t.Log("progress:", strings.Trim(fmt.Sprint(progress), "[]"))
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(data)) {
+		t.Errorf("progress: %v", progress)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultPartitionLayout partitions the outbox by year and month, as "Outbox/2024/06".
+const DefaultPartitionLayout = "2006/01"
+
+// WithPartitionedOutbox moves the delivered messages into the date-partitioned subfolders
+// of the outbox (or of the Mailbox of the Result), created on demand with EnsureFolderPath.
+//
+// layout is the time.Format layout of the subfolder path, with "/" separating the levels
+// (DefaultPartitionLayout if empty); the time is the time of the delivery.
+func WithPartitionedOutbox(layout string) LoopOption {
+	if layout == "" {
+		layout = DefaultPartitionLayout
+	}
+	return func(o *loopOptions) { o.partition = layout }
+}
+
+// partitioned sets the partition layout in the Result of the delivered messages.
+func (deliver readDeliverer) partitioned(layout string) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		readErr, err := deliver(ctx, c, uid, hsh)
+		if readErr != nil {
+			return readErr, err
+		}
+		res := resultOf(err)
+		if res.Action != Delivered {
+			return nil, err
+		}
+		res.partition = layout
+		return nil, &res
+	}
+}
+
+// partitions resolves the partitions of the mailboxes, creating each only once.
+type partitions map[string]string
+
+// mailbox returns the partition of mbox for now, creating it if needed.
+func (ps partitions) mailbox(ctx context.Context, c Client, mbox, layout string, now time.Time) (string, error) {
+	path := mbox + PathSeparator + now.Format(layout)
+	if p, ok := ps[path]; ok {
+		return p, nil
+	}
+	p, err := EnsureFolderPath(ctx, c, path)
+	if err != nil {
+		return "", err
+	}
+	ps[path] = p
+	return p, nil
+}
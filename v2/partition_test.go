@@ -0,0 +1,78 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mailboxClient is a fakeClient which can list and create its mailboxes, recording the creations.
+type mailboxClient struct {
+	*fakeClient
+	created *[]string
+}
+
+func (c mailboxClient) Mailboxes(ctx context.Context, root string) ([]string, error) {
+	names := make([]string, 0, len(c.boxes))
+	for nm := range c.boxes {
+		names = append(names, nm)
+	}
+	return names, nil
+}
+func (c mailboxClient) CreateMailbox(ctx context.Context, mbox string) error {
+	*c.created = append(*c.created, mbox)
+	c.box(mbox)
+	return nil
+}
+func (c mailboxClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	return errors.ErrUnsupported
+}
+func (c mailboxClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	return errors.ErrUnsupported
+}
+
+func TestPartitionedOutbox(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	inbox := newFakeMailbox()
+	for uid, subject := range []string{"a", "b", "bad"} {
+		inbox.add(uint32(uid+1), subject)
+	}
+	var created []string
+	c := mailboxClient{
+		fakeClient: &fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox, "Done": newFakeMailbox()}},
+		created:    &created,
+	}
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		if uid == 3 {
+			return errors.New("bad")
+		}
+		return nil
+	}
+	// The partition is of the time of the delivery.
+	before := time.Now()
+	if _, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "Err", logger, WithPartitionedOutbox("")); err != nil {
+		t.Fatal(err)
+	}
+	want := "Done/" + before.Format(DefaultPartitionLayout)
+	if now := "Done/" + time.Now().Format(DefaultPartitionLayout); now != want {
+		t.Skip("month changed during the test")
+	}
+	// Each level of the partition is created only once, for all the messages.
+	if wantCreated := []string{"Done/" + before.Format("2006"), want}; !slices.Equal(created, wantCreated) {
+		t.Errorf("created %q, wanted %q", created, wantCreated)
+	}
+	for mbox, want := range map[string][]string{want: {"a", "b"}, "Done": {}, "Err": {"bad"}} {
+		if got := c.box(mbox).sortedSubjects(); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, wanted %v", mbox, got, want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// PeekSetter is implemented by the Clients which can read the messages without marking them \Seen.
+type PeekSetter interface {
+	// SetPeek sets whether the bodies are fetched with BODY.PEEK (the default),
+	// or with BODY (and RFC822), which marks the message \Seen on most servers.
+	SetPeek(bool)
+}
+
+var _ PeekSetter = (*imapClient)(nil)
+
+// SetPeek sets whether the bodies are fetched with BODY.PEEK (the default).
+func (c *imapClient) SetPeek(peek bool) { c.noPeek = !peek }
+
+// peekItem returns the BODY.PEEK form of the body fetch item, and the name
+// of its section in the response - or an empty section for the other items.
+func peekItem(item string) (peek, section string) {
+	switch upper := strings.ToUpper(item); {
+	case upper == "RFC822":
+		return "BODY.PEEK[]", "BODY[]"
+	case upper == "RFC822.TEXT":
+		return "BODY.PEEK[TEXT]", "BODY[TEXT]"
+	case strings.HasPrefix(upper, "BODY["):
+		return "BODY.PEEK" + item[4:], item
+	}
+	return item, ""
+}
+
+// PartialPeeker is implemented by the Clients which can read a part of a message section.
+type PartialPeeker interface {
+	// PeekPartial writes at most length bytes of the section (as in Peek) of the message to w,
+	// starting at offset - only those bytes are transferred.
+	PeekPartial(ctx context.Context, w io.Writer, msgID uint32, what string, offset, length int64) (int64, error)
+}
+
+var _ PartialPeeker = (*imapClient)(nil)
+
+// PeekPartial reads the <offset.length> partial of the section (RFC 3501 6.4.5).
+func (c *imapClient) PeekPartial(ctx context.Context, w io.Writer, msgID uint32, what string, offset, length int64) (int64, error) {
+	return c.peekSection(ctx, w, msgID, &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.PartSpecifier(what)},
+		Peek:         !c.noPeek,
+		Partial:      []int{int(offset), int(length)},
+	})
+}
@@ -0,0 +1,33 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"testing"
+)
+
+// BenchmarkMessageBuffer compares a new buffer with a pooled one for each message,
+// written as the literals of the FETCH responses are (with WriteTo), as in Annotate.
+func BenchmarkMessageBuffer(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256<<10/16)
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var buf bytes.Buffer
+			buf.WriteString("X-Processed-At: now\r\n")
+			bytes.NewBuffer(data).WriteTo(&buf)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			buf := getBuffer()
+			buf.WriteString("X-Processed-At: now\r\n")
+			bytes.NewBuffer(data).WriteTo(buf)
+			putBuffer(buf)
+		}
+	})
+}
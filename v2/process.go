@@ -0,0 +1,101 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultParseAttempts is the number of rounds ProcessUnseen tries to parse a message,
+// if not set by WithParseErrors.
+const DefaultParseAttempts = 3
+
+// parseFailureTTL is the time after the last failure the count of a message is forgotten,
+// so the messages deleted (or moved) meanwhile are not counted forever.
+const parseFailureTTL = 24 * time.Hour
+
+type parseErrors struct {
+	mailbox     string
+	maxAttempts int
+}
+
+// WithParseErrors sets what ProcessUnseen does with the messages failing to parse:
+// they are retried in the next rounds, and after maxAttempts failures rejected -
+// moved to mailbox, or if it is empty, marked Seen and left in their mailbox,
+// so they are not listed again.
+//
+// The default is DefaultParseAttempts, without mailbox.
+func WithParseErrors(maxAttempts int, mailbox string) LoopOption {
+	return func(o *loopOptions) { o.parseErrors = &parseErrors{maxAttempts: maxAttempts, mailbox: mailbox} }
+}
+
+// parseFailures counts the failed parse attempts of ProcessUnseen, by mailbox and message hash.
+var parseFailures = struct {
+	m map[string]parseFailure
+	sync.Mutex
+}{m: make(map[string]parseFailure)}
+
+type parseFailure struct {
+	last  time.Time
+	count int
+}
+
+// failedParse counts the failure of key, and returns the number of failures so far.
+// If it reaches maxAttempts, the count is forgotten, as the message is rejected.
+func failedParse(key string, maxAttempts int) int {
+	parseFailures.Lock()
+	defer parseFailures.Unlock()
+	now := time.Now()
+	for k, f := range parseFailures.m {
+		if now.Sub(f.last) > parseFailureTTL {
+			delete(parseFailures.m, k)
+		}
+	}
+	f := parseFailures.m[key]
+	f.count++
+	if f.count >= maxAttempts {
+		delete(parseFailures.m, key)
+	} else {
+		f.last = now
+		parseFailures.m[key] = f
+	}
+	return f.count
+}
+
+// ProcessUnseen does one round of processing the unseen messages of mbox:
+// each message is parsed with parse, then handled by handle,
+// and if both succeed, marked Seen and moved to the Archive folder.
+//
+// A parse error leaves the message as is, to be retried in the next round,
+// till it is rejected as set by WithParseErrors (the failures are counted in memory).
+// handle can return a *Result (see MoveTo, RetryLater, RejectTo) or ErrSkip, as a DeliverFunc.
+//
+// Returns the number of messages handled.
+func ProcessUnseen[T any](ctx context.Context, c Client, mbox string, parse func(io.Reader) (T, error), handle func(T) error, opts ...LoopOption) (int, error) {
+	pe := parseErrors{maxAttempts: DefaultParseAttempts}
+	if o := newLoopOptions(opts); o.parseErrors != nil {
+		pe = *o.parseErrors
+	}
+	return DeliverOne(ctx, c, mbox, "", func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		key := mbox + "\x00" + hsh.String()
+		v, err := parse(r)
+		if err == nil {
+			parseFailures.Lock()
+			delete(parseFailures.m, key)
+			parseFailures.Unlock()
+			return handle(v)
+		}
+		err = fmt.Errorf("parse %d: %w", uid, err)
+		if failedParse(key, pe.maxAttempts) < pe.maxAttempts {
+			return RetryLater(err)
+		}
+		return &Result{Action: Reject, Mailbox: pe.mailbox, Err: err, seen: pe.mailbox == ""}
+	}, Archive, "", slog.Default(), opts...)
+}
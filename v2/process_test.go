@@ -0,0 +1,79 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessUnseenParseError(t *testing.T) {
+	ctx := context.Background()
+	parse := func(r io.Reader) (string, error) {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		subject := strings.TrimSpace(strings.TrimPrefix(line, "Subject: "))
+		if subject == "bad" {
+			return "", errors.New("bad")
+		}
+		return subject, nil
+	}
+
+	for _, tc := range []struct {
+		Name      string
+		Mailbox   string
+		Opts      []LoopOption
+		Attempts  int
+		WantInbox []string
+	}{
+		{Name: "default", Attempts: DefaultParseAttempts, WantInbox: []string{"bad"}},
+		{Name: "mailbox", Mailbox: JunkEmail, Opts: []LoopOption{WithParseErrors(2, JunkEmail)}, Attempts: 2},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			inbox := newFakeMailbox()
+			for uid, subject := range []string{"ok", "bad"} {
+				inbox.add(uint32(uid+1), subject)
+			}
+			c := &fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}}
+			var handled []string
+			handle := func(s string) error { handled = append(handled, s); return nil }
+
+			for i := 1; i <= tc.Attempts+1; i++ {
+				if _, err := ProcessUnseen(ctx, c, "INBOX", parse, handle, tc.Opts...); err != nil {
+					t.Fatal(err)
+				}
+				want := []string{"bad"}
+				if i >= tc.Attempts {
+					want = tc.WantInbox
+				}
+				if got := c.box("INBOX").sortedSubjects(); !slices.Equal(got, want) {
+					t.Errorf("%d. INBOX: got %v, wanted %v", i, got, want)
+				}
+			}
+			if !slices.Equal(handled, []string{"ok"}) {
+				t.Errorf("handled %v", handled)
+			}
+			if got := c.box(Archive).sortedSubjects(); !slices.Equal(got, []string{"ok"}) {
+				t.Errorf("%s: got %v", Archive, got)
+			}
+			if tc.Mailbox != "" {
+				if got := c.box(tc.Mailbox).sortedSubjects(); !slices.Equal(got, []string{"bad"}) {
+					t.Errorf("%s: got %v", tc.Mailbox, got)
+				}
+			} else if !slices.Contains(inbox.flags[2], `\Seen`) {
+				t.Errorf("rejected message is not Seen: %v", inbox.flags[2])
+			}
+			parseFailures.Lock()
+			defer parseFailures.Unlock()
+			if len(parseFailures.m) != 0 {
+				t.Errorf("failures left: %v", parseFailures.m)
+			}
+		})
+	}
+}
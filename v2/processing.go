@@ -0,0 +1,41 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// WithProcessingFolder makes the loop claim the listed messages by moving them into mailbox
+// (a per-worker folder, such as "Processing/worker-1") before delivering them:
+// the MOVE is atomic, so another poller cannot grab them, and the messages in flight are visible
+// in the mailbox itself.
+//
+// The messages are delivered from mailbox, and moved from there to the outbox or errbox;
+// the delivered messages without an outbox, the rejected ones without an errbox, the skipped ones
+// and the ones Handled in place (such as marked by a Rule) are moved back to the inbox.
+// The messages to be retried (and the ones left by a crash) stay in mailbox,
+// and are delivered again in the next round, by this worker.
+func WithProcessingFolder(mailbox string) LoopOption {
+	return func(o *loopOptions) { o.processing = mailbox }
+}
+
+// claimByMove moves the messages to the processing mailbox, and returns all the messages of it
+// (the ones left there by an earlier round, too), with it selected.
+func claimByMove(ctx context.Context, c Client, processing string, uids []uint32, logger *slog.Logger) ([]uint32, error) {
+	if len(uids) != 0 {
+		if err := MoveSet(ctx, c, NewSeqSet(uids...), processing); err != nil {
+			return nil, fmt.Errorf("move to %q: %w", processing, err)
+		}
+	}
+	claimed, err := c.List(ctx, processing, "", true)
+	logger.Info("List", "processing", processing, "uids", claimed, "error", err)
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", processing, err)
+	}
+	return claimed, nil
+}
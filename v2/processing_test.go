@@ -0,0 +1,66 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessingFolder(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	inbox := newFakeMailbox()
+	for uid, subject := range []string{"ok", "bad", "skip", "later"} {
+		inbox.add(uint32(uid+1), subject)
+	}
+	c := &fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}}
+	var inFlight []string
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		inFlight = append(inFlight, c.selected)
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		switch strings.TrimSpace(strings.TrimPrefix(line, "Subject: ")) {
+		case "bad":
+			return errors.New("bad")
+		case "skip":
+			return ErrSkip
+		case "later":
+			return RetryLater(errors.New("later"))
+		}
+		return nil
+	}
+	n, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "", logger, WithProcessingFolder("Processing/w1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d delivered", n)
+	}
+	if len(inFlight) != 4 || slices.ContainsFunc(inFlight, func(s string) bool { return s != "Processing/w1" }) {
+		t.Errorf("delivered from %v", inFlight)
+	}
+	for mbox, want := range map[string][]string{
+		"INBOX":         {"bad", "skip"},
+		"Done":          {"ok"},
+		"Processing/w1": {"later"},
+	} {
+		if got := c.box(mbox).sortedSubjects(); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, wanted %v", mbox, got, want)
+		}
+	}
+	if uids, _ := c.List(ctx, "Done", "", false); len(uids) != 0 {
+		t.Errorf("ok is not seen")
+	}
+	if uids, _ := c.List(ctx, "INBOX", "", false); len(uids) != 2 {
+		t.Errorf("INBOX: got %d unseen, wanted bad and skip", len(uids))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Progress is called with the number of bytes read so far and the size of the message
+// (-1 if it is unknown).
+type Progress func(read, total int64)
+
+// Size returns the RFC822.SIZE of the message, before fetching it.
+func Size(ctx context.Context, c Client, uid uint32) (int64, error) {
+	m, err := c.FetchArgs(ctx, "RFC822.SIZE", uid)
+	if err != nil {
+		return -1, err
+	}
+	if vv := m[uid]["RFC822.SIZE"]; len(vv) != 0 {
+		return strconv.ParseInt(vv[0], 10, 64)
+	}
+	return -1, fmt.Errorf("%d: no RFC822.SIZE", uid)
+}
+
+// ListInfo lists the messages as List, and returns their MessageInfo (with Size).
+func ListInfo(ctx context.Context, c Client, mbox, pattern string, all bool) ([]MessageInfo, error) {
+	uids, err := c.List(ctx, mbox, pattern, all)
+	if err != nil {
+		return nil, err
+	}
+	return FetchInfo(ctx, c, uids...)
+}
+
+// ReadToProgress reads the message into w as ReadTo, calling progress with the bytes written so far,
+// and the size of the message - -1 if it couldn't be determined.
+func ReadToProgress(ctx context.Context, c Client, w io.Writer, uid uint32, progress Progress) (int64, error) {
+	total, err := Size(ctx, c, uid)
+	if err != nil {
+		total = -1
+	}
+	return c.ReadTo(ctx, &progressWriter{w: w, total: total, progress: progress}, uid)
+}
+
+// WithProgress calls progress while reading each message in the DeliveryLoop.
+func WithProgress(progress func(uid uint32, read, total int64)) LoopOption {
+	return func(o *loopOptions) { o.progress = progress }
+}
+
+// progressReporting reports the progress of the reading of the messages.
+func (deliver readDeliverer) progressReporting(progress func(uid uint32, read, total int64)) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		return deliver(ctx, progressClient{Client: c, progress: progress}, uid, hsh)
+	}
+}
+
+// progressClient reports the progress of ReadTo.
+type progressClient struct {
+	Client
+	progress func(uid uint32, read, total int64)
+}
+
+func (c progressClient) ReadTo(ctx context.Context, w io.Writer, uid uint32) (int64, error) {
+	return ReadToProgress(ctx, c.Client, w, uid, func(read, total int64) { c.progress(uid, read, total) })
+}
+
+type progressWriter struct {
+	w        io.Writer
+	progress Progress
+	n, total int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.n += int64(n)
+	if pw.progress != nil {
+		pw.progress(pw.n, pw.total)
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestReadToProgress(t *testing.T) {
+	ctx := context.Background()
+	mb := newFakeMailbox()
+	mb.add(1, "small")
+	const body = "Subject: small\r\n\r\n"
+	for name, tc := range map[string]struct {
+		Client Client
+		Total  int64
+	}{
+		"size":    {Client: sizeClient{&fakeClient{mb: mb}}, Total: 10},
+		"unknown": {Client: watchClient{fakeClient: &fakeClient{mb: mb}, flagsErr: errors.ErrUnsupported}, Total: -1},
+	} {
+		if size, err := Size(ctx, tc.Client, 1); size != tc.Total || (err == nil) != (tc.Total >= 0) {
+			t.Errorf("%s: Size: got %d, %+v, wanted %d", name, size, err, tc.Total)
+		}
+		var buf strings.Builder
+		var calls int
+		var read, total int64
+		n, err := ReadToProgress(ctx, tc.Client, &buf, 1, func(r, tot int64) { calls, read, total = calls+1, r, tot })
+		if err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if buf.String() != body || n != int64(len(body)) {
+			t.Errorf("%s: got %q (%d), wanted %q", name, buf.String(), n, body)
+		}
+		if calls == 0 || read != n || total != tc.Total {
+			t.Errorf("%s: got %d calls, last %d/%d, wanted %d/%d", name, calls, read, total, n, tc.Total)
+		}
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	inbox := newFakeMailbox()
+	for uid, subject := range []string{"a", "big"} {
+		inbox.add(uint32(uid+1), subject)
+	}
+	c := sizeClient{&fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}}}
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error { return nil }
+	got := make(map[uint32][2]int64)
+	progress := func(uid uint32, read, total int64) { got[uid] = [2]int64{read, total} }
+	if _, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "Err", logger, WithProgress(progress)); err != nil {
+		t.Fatal(err)
+	}
+	for uid, want := range map[uint32][2]int64{
+		1: {int64(len("Subject: a\r\n\r\n")), 10},
+		2: {int64(len("Subject: big\r\n\r\n")), 1000},
+	} {
+		if got[uid] != want {
+			t.Errorf("%d: got %v, wanted %v", uid, got[uid], want)
+		}
+	}
+}
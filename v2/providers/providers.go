@@ -0,0 +1,244 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package providers contains the settings of the well-known mail providers,
+// and the autodetection of the IMAP server of an email address.
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// Quirk is a deviation of the provider from the standards, or a requirement of it.
+type Quirk uint32
+
+const (
+	// AppPassword means the account password is not accepted, an app password (or OAuth) is needed.
+	AppPassword = Quirk(1 << iota)
+	// LabelsAsFolders means the folders are labels (Gmail): a message can be in more folders,
+	// and removing it from a folder does not delete it.
+	LabelsAsFolders
+	// ShortIdle means the server drops the IDLE connection in less than the RFC's 29 minutes,
+	// so IDLE should be restarted in every few minutes.
+	ShortIdle
+	// OAuthOnly means basic authentication is disabled, only XOAUTH2 / OAUTHBEARER works.
+	OAuthOnly
+)
+
+// Has reports whether q has all the quirks of o.
+func (q Quirk) Has(o Quirk) bool { return q&o == o }
+
+// OAuth holds the OAuth2 settings of the provider, for the oauth2.Config.
+type OAuth struct {
+	Endpoint oauth2.Endpoint
+	Scopes   []string
+}
+
+// Preset is the IMAP settings of a provider.
+type Preset struct {
+	// OAuth settings, if the provider supports it.
+	OAuth *OAuth
+	// Name of the provider.
+	Name string
+	// Domains are the email domains of the provider.
+	Domains []string
+	// MXSuffixes are the suffixes of the MX records of the provider, for the custom domains it hosts.
+	MXSuffixes []string
+	// AuthMechanisms are the supported authentication mechanisms, in preference order.
+	AuthMechanisms []string
+	// Server address, without the user name and password.
+	Server imapclient.ServerAddress
+	Quirks Quirk
+}
+
+// Presets are the known providers.
+var Presets = []Preset{
+	{
+		Name:           "Gmail",
+		Domains:        []string{"gmail.com", "googlemail.com"},
+		MXSuffixes:     []string{".google.com", ".googlemail.com"},
+		Server:         imapclient.ServerAddress{Host: "imap.gmail.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"XOAUTH2", "OAUTHBEARER", "PLAIN"},
+		OAuth: &OAuth{
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+				TokenURL: "https://oauth2.googleapis.com/token",
+			},
+			Scopes: []string{"https://mail.google.com/"},
+		},
+		Quirks: AppPassword | LabelsAsFolders,
+	},
+	{
+		Name:           "Outlook.com",
+		Domains:        []string{"outlook.com", "hotmail.com", "live.com", "msn.com"},
+		MXSuffixes:     []string{".protection.outlook.com"},
+		Server:         imapclient.ServerAddress{Host: "outlook.office365.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"XOAUTH2"},
+		OAuth: &OAuth{
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+				TokenURL: "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+			},
+			Scopes: []string{"https://outlook.office.com/IMAP.AccessAsUser.All", "offline_access"},
+		},
+		Quirks: OAuthOnly | ShortIdle,
+	},
+	{
+		Name:           "Yahoo",
+		Domains:        []string{"yahoo.com", "ymail.com", "rocketmail.com"},
+		MXSuffixes:     []string{".yahoodns.net"},
+		Server:         imapclient.ServerAddress{Host: "imap.mail.yahoo.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"XOAUTH2", "PLAIN", "LOGIN"},
+		OAuth: &OAuth{
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://api.login.yahoo.com/oauth2/request_auth",
+				TokenURL: "https://api.login.yahoo.com/oauth2/get_token",
+			},
+			Scopes: []string{"mail-w"},
+		},
+		Quirks: AppPassword,
+	},
+	{
+		Name:           "AOL",
+		Domains:        []string{"aol.com"},
+		Server:         imapclient.ServerAddress{Host: "imap.aol.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"PLAIN", "LOGIN"},
+		Quirks:         AppPassword,
+	},
+	{
+		Name:           "Yandex",
+		Domains:        []string{"yandex.com", "yandex.ru", "ya.ru"},
+		MXSuffixes:     []string{".yandex.net", ".yandex.ru"},
+		Server:         imapclient.ServerAddress{Host: "imap.yandex.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"XOAUTH2", "PLAIN", "LOGIN"},
+		OAuth: &OAuth{
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://oauth.yandex.com/authorize",
+				TokenURL: "https://oauth.yandex.com/token",
+			},
+			Scopes: []string{"mail:imap_full"},
+		},
+		Quirks: AppPassword,
+	},
+	{
+		Name:           "iCloud",
+		Domains:        []string{"icloud.com", "me.com", "mac.com"},
+		MXSuffixes:     []string{".mail.icloud.com"},
+		Server:         imapclient.ServerAddress{Host: "imap.mail.me.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"PLAIN", "LOGIN"},
+		Quirks:         AppPassword,
+	},
+	{
+		Name:           "Fastmail",
+		Domains:        []string{"fastmail.com", "fastmail.fm"},
+		MXSuffixes:     []string{".messagingengine.com"},
+		Server:         imapclient.ServerAddress{Host: "imap.fastmail.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"PLAIN", "LOGIN"},
+		Quirks:         AppPassword,
+	},
+	{
+		Name:           "GMX",
+		Domains:        []string{"gmx.com", "gmx.net", "gmx.de"},
+		MXSuffixes:     []string{".gmx.net"},
+		Server:         imapclient.ServerAddress{Host: "imap.gmx.com", Port: 993, TLSPolicy: imapclient.ForceTLS},
+		AuthMechanisms: []string{"PLAIN", "LOGIN"},
+	},
+}
+
+// Resolver is used for the DNS lookups.
+var Resolver = net.DefaultResolver
+
+// ErrNotFound is returned when no IMAP server is found for the address.
+var ErrNotFound = errors.New("no IMAP server found")
+
+// Lookup returns the settings for the email address: from the Presets by the domain,
+// then from the DNS SRV records (RFC 6186), then from the Presets by the MX records.
+//
+// The Preset found by SRV has only the Name (the domain) and the Server filled.
+func Lookup(ctx context.Context, address string) (Preset, error) {
+	_, domain, ok := strings.Cut(address, "@")
+	if !ok || domain == "" {
+		return Preset{}, fmt.Errorf("%q: no domain", address)
+	}
+	domain = strings.ToLower(domain)
+	for _, p := range Presets {
+		for _, d := range p.Domains {
+			if d == domain {
+				return p, nil
+			}
+		}
+	}
+
+	// RFC 6186: prefer implicit TLS.
+	for _, service := range []string{"imaps", "imap"} {
+		_, addrs, err := Resolver.LookupSRV(ctx, service, "tcp", domain)
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+		// The records are sorted by priority and randomized by weight.
+		srv := addrs[0]
+		if srv.Target == "." { // the service is decidedly not available
+			continue
+		}
+		p := Preset{Name: domain, Server: imapclient.ServerAddress{
+			Host: strings.TrimSuffix(srv.Target, "."), Port: uint32(srv.Port),
+			TLSPolicy: imapclient.ForceTLS,
+		}}
+		if service == "imap" {
+			p.Server.TLSPolicy = imapclient.MaybeTLS
+		}
+		// Providers with their own SRV records may still have quirks.
+		if q, ok := byHost(p.Server.Host); ok {
+			p.Quirks, p.OAuth, p.AuthMechanisms = q.Quirks, q.OAuth, q.AuthMechanisms
+		}
+		return p, nil
+	}
+
+	mxs, err := Resolver.LookupMX(ctx, domain)
+	if err != nil {
+		return Preset{}, fmt.Errorf("%q: %w: %w", domain, ErrNotFound, err)
+	}
+	for _, mx := range mxs {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		for _, p := range Presets {
+			for _, suffix := range p.MXSuffixes {
+				if strings.HasSuffix(host, suffix) {
+					return p, nil
+				}
+			}
+		}
+	}
+	return Preset{}, fmt.Errorf("%q: %w", domain, ErrNotFound)
+}
+
+func byHost(host string) (Preset, bool) {
+	for _, p := range Presets {
+		if strings.EqualFold(p.Server.Host, host) {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
+
+// NewClientForAddress returns a new (not connected) Client for the email address,
+// with the settings found by Lookup.
+//
+// The password is the app password or the OAuth2 access token, according to the Quirks.
+func NewClientForAddress(ctx context.Context, address, password string) (imapclient.Client, Preset, error) {
+	p, err := Lookup(ctx, address)
+	if err != nil {
+		return nil, p, err
+	}
+	sa := p.Server
+	sa.Username = address
+	return imapclient.FromServerAddress(sa.WithPassword(password)), p, nil
+}
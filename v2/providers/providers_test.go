@@ -0,0 +1,130 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package providers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// fakeDNS answers the SRV and MX queries from the records of the fully qualified names,
+// with NXDOMAIN for the others.
+type fakeDNS struct {
+	srv map[string]dnsmessage.SRVResource
+	mx  map[string]dnsmessage.MXResource
+}
+
+// resolver returns a Resolver using the fakeDNS over a (stream) pipe.
+func (d fakeDNS) resolver() *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: func(context.Context, string, string) (net.Conn, error) {
+		cConn, sConn := net.Pipe()
+		go d.serve(sConn)
+		return cConn, nil
+	}}
+}
+
+func (d fakeDNS) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(b); err != nil || len(msg.Questions) == 0 {
+			return
+		}
+		q := msg.Questions[0]
+		msg.Response, msg.Authoritative, msg.RCode = true, true, dnsmessage.RCodeNameError
+		hdr := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60}
+		name := strings.ToLower(q.Name.String())
+		switch q.Type {
+		case dnsmessage.TypeSRV:
+			if r, ok := d.srv[name]; ok {
+				msg.RCode, msg.Answers = dnsmessage.RCodeSuccess, []dnsmessage.Resource{{Header: hdr, Body: &r}}
+			}
+		case dnsmessage.TypeMX:
+			if r, ok := d.mx[name]; ok {
+				msg.RCode, msg.Answers = dnsmessage.RCodeSuccess, []dnsmessage.Resource{{Header: hdr, Body: &r}}
+			}
+		}
+		msg.Additionals = nil
+		b, err := msg.Pack()
+		if err != nil {
+			return
+		}
+		binary.BigEndian.PutUint16(length[:], uint16(len(b)))
+		if _, err = conn.Write(append(length[:], b...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+	old := Resolver
+	t.Cleanup(func() { Resolver = old })
+	Resolver = fakeDNS{
+		srv: map[string]dnsmessage.SRVResource{
+			"_imaps._tcp.example.org.":  {Target: dnsmessage.MustNewName("imap.example.org."), Port: 993},
+			"_imap._tcp.example.net.":   {Target: dnsmessage.MustNewName("imap.example.net."), Port: 143},
+			"_imaps._tcp.corp.example.": {Target: dnsmessage.MustNewName("outlook.office365.com."), Port: 993},
+		},
+		mx: map[string]dnsmessage.MXResource{
+			"custom.example.": {MX: dnsmessage.MustNewName("aspmx.l.google.com."), Pref: 1},
+			"other.example.":  {MX: dnsmessage.MustNewName("mx.other.example."), Pref: 1},
+		},
+	}.resolver()
+
+	for address, tc := range map[string]struct {
+		Err      error
+		Name     string
+		Host     string
+		Quirks   Quirk
+		MaybeTLS bool
+	}{
+		"a@Yandex.RU":        {Name: "Yandex", Host: "imap.yandex.com", Quirks: AppPassword},
+		"a@hotmail.com":      {Name: "Outlook.com", Host: "outlook.office365.com", Quirks: OAuthOnly | ShortIdle},
+		"a@ymail.com":        {Name: "Yahoo", Host: "imap.mail.yahoo.com", Quirks: AppPassword},
+		"a@example.org":      {Name: "example.org", Host: "imap.example.org"},
+		"a@example.net":      {Name: "example.net", Host: "imap.example.net", MaybeTLS: true},
+		"a@corp.example":     {Name: "corp.example", Host: "outlook.office365.com", Quirks: OAuthOnly | ShortIdle},
+		"a@custom.example":   {Name: "Gmail", Host: "imap.gmail.com", Quirks: AppPassword | LabelsAsFolders},
+		"a@other.example":    {Err: ErrNotFound},
+		"a@nowhere.example.": {Err: ErrNotFound},
+	} {
+		p, err := Lookup(ctx, address)
+		if tc.Err != nil {
+			if !errors.Is(err, tc.Err) {
+				t.Errorf("%s: got %+v, wanted %v", address, err, tc.Err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", address, err)
+			continue
+		}
+		wantPolicy := imapclient.ForceTLS
+		if tc.MaybeTLS {
+			wantPolicy = imapclient.MaybeTLS
+		}
+		if p.Name != tc.Name || p.Server.Host != tc.Host || p.Server.TLSPolicy != wantPolicy || p.Quirks != tc.Quirks {
+			t.Errorf("%s: got %+v, wanted %+v", address, p, tc)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// Publisher publishes the data with the headers to the topic (Kafka) or subject (NATS),
+// and returns only after the broker has acknowledged it.
+//
+// The kafka and nats subpackages implement it for Kafka (github.com/twmb/franz-go)
+// and NATS JetStream (github.com/nats-io/nats.go/jetstream).
+type Publisher interface {
+	Publish(ctx context.Context, topic string, headers map[string][]string, data []byte) error
+}
+
+// PublisherFunc is a func implementing Publisher.
+type PublisherFunc func(ctx context.Context, topic string, headers map[string][]string, data []byte) error
+
+func (f PublisherFunc) Publish(ctx context.Context, topic string, headers map[string][]string, data []byte) error {
+	return f(ctx, topic, headers, data)
+}
+
+// The metadata headers set by PublishDeliverFunc, besides the Message-Id, Subject, From and Date
+// header fields of the message.
+const (
+	PublishUIDHeader  = "X-Imapclient-Uid"
+	PublishHashHeader = "X-Imapclient-Hash"
+)
+
+// PublishDeliverFunc returns a DeliverFunc which publishes the raw message to the topic,
+// with the metadata headers.
+//
+// The returned error is the Publisher's, so DeliveryLoop moves the message to the errbox
+// if the broker did not acknowledge it.
+func PublishDeliverFunc(pub Publisher, topic string) DeliverFunc {
+	return func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+		headers := map[string][]string{
+			PublishUIDHeader:  {strconv.FormatUint(uint64(uid), 10)},
+			PublishHashHeader: {hsh.String()},
+		}
+		if hdr, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(data))); err == nil || hdr.Len() != 0 {
+			for _, k := range []string{"Message-Id", "Subject", "From", "Date"} {
+				if v := hdr.Get(k); v != "" {
+					headers[k] = []string{decodeHeader(v)}
+				}
+			}
+		}
+		if err := pub.Publish(ctx, topic, headers, data); err != nil {
+			return fmt.Errorf("publish %d to %q: %w", uid, topic, err)
+		}
+		return nil
+	}
+}
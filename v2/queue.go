@@ -0,0 +1,220 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/renameio/v2"
+)
+
+// Queue is a disk-backed queue between fetching and delivering the messages.
+//
+// Its Enqueue method is a DeliverFunc: use it in DeliveryLoop, and the message is
+// marked as seen (and moved to the outbox) as soon as it is persisted.
+// Consume runs the real DeliverFunc on the queued messages, with a pool of workers.
+//
+// The messages are stored in files like in a maildir: "new" holds the queued messages,
+// "cur" the ones under delivery, and "failed" the ones whose delivery failed.
+// On restart, the messages left in "cur" are queued again, so each message
+// is delivered at least once.
+type Queue struct {
+	logger *slog.Logger
+	notify chan struct{}
+	dir    string
+}
+
+const (
+	queueNew    = "new"
+	queueCur    = "cur"
+	queueFailed = "failed"
+)
+
+// NewQueue returns a Queue in the given directory, creating it if needed.
+func NewQueue(dir string, logger *slog.Logger) (*Queue, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	for _, sub := range []string{queueNew, queueCur, queueFailed} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			return nil, err
+		}
+	}
+	q := Queue{dir: dir, logger: logger.With("queue", dir), notify: make(chan struct{}, 1)}
+	// Requeue the messages whose delivery was interrupted.
+	des, err := os.ReadDir(filepath.Join(dir, queueCur))
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range des {
+		if err := os.Rename(q.path(queueCur, de.Name()), q.path(queueNew, de.Name())); err != nil {
+			return nil, err
+		}
+		q.logger.Info("requeued", "name", de.Name())
+	}
+	return &q, nil
+}
+
+func (q *Queue) path(sub, name string) string { return filepath.Join(q.dir, sub, name) }
+
+// queueName is the file name for the message: its hash and UID.
+func queueName(uid uint32, hsh HashArray) string {
+	return hsh.String() + "." + strconv.FormatUint(uint64(uid), 10)
+}
+
+func parseQueueName(name string) (uint32, HashArray, error) {
+	var hsh HashArray
+	s, u, ok := strings.Cut(name, ".")
+	if !ok {
+		return 0, hsh, fmt.Errorf("bad queue file name %q", name)
+	}
+	uid, err := strconv.ParseUint(u, 10, 32)
+	if err != nil {
+		return 0, hsh, fmt.Errorf("%q: %w", name, err)
+	}
+	if n, err := base64.URLEncoding.Decode(hsh[:], []byte(s)); err != nil || n != len(hsh) {
+		return 0, hsh, fmt.Errorf("bad hash in %q: %w", name, err)
+	}
+	return uint32(uid), hsh, nil
+}
+
+// Enqueue persists the message into the queue. The same message (with the same hash and UID)
+// is not queued again while it waits in the queue or is under delivery -
+// after it has been delivered (or failed), it is queued again.
+func (q *Queue) Enqueue(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+	name := queueName(uid, hsh)
+	fn := q.path(queueNew, name)
+	// "new" first, as Consume moves the message from there to "cur".
+	for _, sub := range []string{queueNew, queueCur} {
+		if _, err := os.Stat(q.path(sub, name)); err == nil {
+			q.logger.Debug("already queued", "name", name, "in", sub)
+			return nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	// The temp file is in the root, not to be seen by Consume.
+	fh, err := renameio.TempFile(q.dir, fn)
+	if err != nil {
+		return err
+	}
+	defer fh.Cleanup()
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(fh, r); err != nil {
+		return err
+	}
+	if err := fh.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Consume delivers the queued messages with the given number of workers, till the context is canceled.
+//
+// A successfully delivered message is removed from the queue, one returned ErrSkip for
+// is queued again, any other error moves the message into the "failed" directory.
+func (q *Queue) Consume(ctx context.Context, workers int, deliver DeliverFunc) error {
+	if workers < 1 {
+		workers = 1
+	}
+	names := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				q.deliver(ctx, name, deliver)
+			}
+		}()
+	}
+	defer func() { close(names); wg.Wait() }()
+
+	for {
+		des, err := os.ReadDir(q.path(queueNew, ""))
+		if err != nil {
+			return err
+		}
+		for _, de := range des {
+			name := de.Name()
+			// Claim the message - only one Consume can succeed.
+			if err := os.Rename(q.path(queueNew, name), q.path(queueCur, name)); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					q.logger.Error("claim", "name", name, "error", err)
+				}
+				continue
+			}
+			select {
+			case names <- name:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		// Wait for Enqueue, not to spin on the messages requeued due to ErrSkip.
+		timer := time.NewTimer(ShortSleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-q.notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (q *Queue) deliver(ctx context.Context, name string, deliver DeliverFunc) {
+	logger := q.logger.With("name", name)
+	fn := q.path(queueCur, name)
+	uid, hsh, err := parseQueueName(name)
+	if err != nil {
+		logger.Error("parse", "error", err)
+		q.move(logger, fn, queueFailed, name)
+		return
+	}
+	fh, err := os.Open(fn)
+	if err != nil {
+		logger.Error("open", "error", err)
+		return
+	}
+	err = deliver(ctx, fh, uid, hsh)
+	fh.Close()
+	switch {
+	case err == nil:
+		if err = os.Remove(fn); err != nil {
+			logger.Error("remove", "error", err)
+		}
+	case errors.Is(err, ErrSkip) || ctx.Err() != nil:
+		logger.Info("requeue", "error", err)
+		q.move(logger, fn, queueNew, name)
+	default:
+		logger.Error("deliver", "error", err)
+		q.move(logger, fn, queueFailed, name)
+	}
+}
+
+func (q *Queue) move(logger *slog.Logger, fn, sub, name string) {
+	if err := os.Rename(fn, q.path(sub, name)); err != nil {
+		logger.Error("move", "to", sub, "error", err)
+	}
+}
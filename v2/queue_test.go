@@ -0,0 +1,66 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueueEnqueueOnce(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	q, err := NewQueue(dir, slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsh := NewHash()
+	hsh.Write([]byte("a"))
+	name := queueName(1, hsh.Array())
+	count := func(sub string) int {
+		t.Helper()
+		des, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(des)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := q.Enqueue(ctx, strings.NewReader("a"), 1, hsh.Array()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(queueNew); n != 1 {
+		t.Errorf("queued %d, wanted 1", n)
+	}
+
+	// Under delivery.
+	if err := os.Rename(q.path(queueNew, name), q.path(queueCur, name)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, strings.NewReader("a"), 1, hsh.Array()); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(queueNew); n != 0 {
+		t.Errorf("queued %d while under delivery, wanted 0", n)
+	}
+
+	// Delivered.
+	if err := os.Remove(q.path(queueCur, name)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, strings.NewReader("a"), 1, hsh.Array()); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(queueNew); n != 1 {
+		t.Errorf("queued %d after delivery, wanted 1", n)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+// ServerQuirk is a workaround of a deviation of an IMAP server from the standards,
+// enabled automatically by Connect for the servers recognized by the registered QuirkEntries.
+type ServerQuirk uint32
+
+const (
+	// QuirkSmallUIDSets splits the UID sets of MoveSet, MarkSet and DeleteSet into
+	// commands of at most smallUIDSetRanges ranges, as the server rejects the long command lines.
+	QuirkSmallUIDSets = ServerQuirk(1 << iota)
+	// QuirkNoPipelining ignores the LITERAL+ and LITERAL- capabilities,
+	// so the literals are sent only after the continuation request of the server.
+	// It is recognized by the greeting only, as go-imap decides at the start.
+	QuirkNoPipelining
+	// QuirkReselectAfterMove selects the mailbox again after MOVE,
+	// as the server reports the expunged messages late (or not at all).
+	QuirkReselectAfterMove
+)
+
+// smallUIDSetRanges is the maximal number of ranges in a command, with QuirkSmallUIDSets.
+const smallUIDSetRanges = 64
+
+// Has reports whether q has all the quirks of o.
+func (q ServerQuirk) Has(o ServerQuirk) bool { return q&o == o }
+
+// QuirkEntry recognizes a server by its greeting or capability, and lists its quirks.
+type QuirkEntry struct {
+	// Greeting matches the greeting of the server, if not nil.
+	Greeting *regexp.Regexp
+	// Name of the server, such as "Dovecot".
+	Name string
+	// Capability is advertised only by this server (such as X-GM-EXT-1 by Gmail), if not empty.
+	Capability string
+	Quirks     ServerQuirk
+}
+
+// match reports whether the entry recognizes the server of ci.
+func (e QuirkEntry) match(ci ConnectInfo) bool {
+	return e.Greeting != nil && e.Greeting.MatchString(ci.Greeting) ||
+		e.Capability != "" && ci.Has(e.Capability)
+}
+
+var (
+	quirksMu      sync.RWMutex
+	quirkRegistry = []QuirkEntry{
+		{Name: "Exchange", Greeting: regexp.MustCompile(`Microsoft Exchange`),
+			Quirks: QuirkSmallUIDSets | QuirkReselectAfterMove},
+		{Name: "Gmail", Greeting: regexp.MustCompile(`\bGimap\b`), Capability: "X-GM-EXT-1",
+			Quirks: QuirkSmallUIDSets},
+		{Name: "Dovecot", Greeting: regexp.MustCompile(`\bDovecot\b`)},
+		{Name: "Courier", Greeting: regexp.MustCompile(`Courier-IMAP`),
+			Quirks: QuirkNoPipelining},
+		{Name: "Zimbra", Greeting: regexp.MustCompile(`\bZimbra\b`),
+			Quirks: QuirkNoPipelining | QuirkReselectAfterMove},
+	}
+)
+
+// RegisterQuirks registers the entry before the already registered ones,
+// so it takes precedence: the first matching entry is used.
+// It applies from the next Connect.
+func RegisterQuirks(e QuirkEntry) {
+	quirksMu.Lock()
+	quirkRegistry = slices.Insert(quirkRegistry, 0, e)
+	quirksMu.Unlock()
+}
+
+// lookupQuirks returns the name and quirks of the first entry matching the server of ci.
+func lookupQuirks(ci ConnectInfo) (string, ServerQuirk) {
+	quirksMu.RLock()
+	defer quirksMu.RUnlock()
+	for _, e := range quirkRegistry {
+		if e.match(ci) {
+			return e.Name, e.Quirks
+		}
+	}
+	return "", 0
+}
+
+// noPipeliningFilter returns the filter of the responses of guardConn, which removes
+// the LITERAL+ and LITERAL- capabilities (of the CAPABILITY responses and response codes),
+// if the greeting shows a server with QuirkNoPipelining.
+//
+// go-imap enables the non-synchronizing literals by the capabilities of the greeting,
+// or of the CAPABILITY command it sends when the greeting has none (as Zimbra's) - which
+// can be reached only here.
+func noPipeliningFilter() func(*imapparse.Response) {
+	var greeted, noPipelining bool
+	return func(resp *imapparse.Response) {
+		if !greeted {
+			greeted = true
+			_, quirks := lookupQuirks(ConnectInfo{Greeting: strings.TrimRight(resp.String(), "\r\n")})
+			noPipelining = quirks.Has(QuirkNoPipelining)
+		}
+		if !noPipelining {
+			return
+		}
+		dropLiteralPlus := func(vv []imapparse.Value) []imapparse.Value {
+			if len(vv) == 0 || vv[0].Kind != imapparse.Atom || !strings.EqualFold(vv[0].Text, "CAPABILITY") {
+				return vv
+			}
+			return slices.DeleteFunc(vv, func(v imapparse.Value) bool {
+				return v.Kind == imapparse.Atom && (strings.EqualFold(v.Text, "LITERAL+") || strings.EqualFold(v.Text, "LITERAL-"))
+			})
+		}
+		resp.Code = dropLiteralPlus(resp.Code)
+		if resp.Tag == "*" && resp.Status == "" {
+			resp.Fields = dropLiteralPlus(resp.Fields)
+		}
+	}
+}
+
+// uidSets returns the set split as required by the quirks of the server.
+func (c *imapClient) uidSets(set SeqSet) []SeqSet {
+	if !c.info.Quirks.Has(QuirkSmallUIDSets) || len(set.ranges) <= smallUIDSetRanges {
+		return []SeqSet{set}
+	}
+	sets := make([]SeqSet, 0, (len(set.ranges)+smallUIDSetRanges-1)/smallUIDSetRanges)
+	for rr := set.ranges; len(rr) != 0; {
+		n := min(len(rr), smallUIDSetRanges)
+		sets = append(sets, SeqSet{ranges: rr[:n:n]})
+		rr = rr[n:]
+	}
+	return sets
+}
+
+// reselectAfterMove selects the selected mailbox again, if the server has QuirkReselectAfterMove.
+func (c *imapClient) reselectAfterMove() error {
+	if !c.info.Quirks.Has(QuirkReselectAfterMove) || c.status == nil {
+		return nil
+	}
+	start := time.Now()
+	status, err := c.c.Select(c.status.Name, c.readOnly)
+	if err = c.countCommand(start, err); err != nil {
+		return fmt.Errorf("SELECT %q: %w", c.status.Name, err)
+	}
+	c.status = status
+	return nil
+}
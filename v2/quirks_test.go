@@ -0,0 +1,95 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/tgulacsi/imapclient/v2/imapparse"
+)
+
+func TestLookupQuirks(t *testing.T) {
+	defer func(registry []QuirkEntry) { quirkRegistry = registry }(slices.Clone(quirkRegistry))
+	RegisterQuirks(QuirkEntry{Name: "Own", Greeting: regexp.MustCompile(`own-imapd`), Quirks: QuirkSmallUIDSets})
+	RegisterQuirks(QuirkEntry{Name: "Dovecot-old", Greeting: regexp.MustCompile(`Dovecot 1\.`), Quirks: QuirkNoPipelining})
+
+	for _, tc := range []struct {
+		Info   ConnectInfo
+		Name   string
+		Quirks ServerQuirk
+	}{
+		{ConnectInfo{Greeting: "* OK The Microsoft Exchange IMAP4 service is ready."}, "Exchange", QuirkSmallUIDSets | QuirkReselectAfterMove},
+		{ConnectInfo{Greeting: "* OK Gimap ready for requests from 192.0.2.1"}, "Gmail", QuirkSmallUIDSets},
+		{ConnectInfo{Greeting: "* OK ready", Capabilities: []string{"IMAP4rev1", "X-GM-EXT-1"}}, "Gmail", QuirkSmallUIDSets},
+		{ConnectInfo{Greeting: "* OK [CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready."}, "Dovecot", 0},
+		{ConnectInfo{Greeting: "* OK Dovecot 1.2 ready."}, "Dovecot-old", QuirkNoPipelining},
+		{ConnectInfo{Greeting: "* OK own-imapd ready"}, "Own", QuirkSmallUIDSets},
+		{ConnectInfo{Greeting: "* OK unknown"}, "", 0},
+	} {
+		name, quirks := lookupQuirks(tc.Info)
+		if name != tc.Name || quirks != tc.Quirks {
+			t.Errorf("%q: got %q/%b, wanted %q/%b", tc.Info.Greeting, name, quirks, tc.Name, tc.Quirks)
+		}
+	}
+}
+
+func TestNoPipeliningFilter(t *testing.T) {
+	for _, tc := range []struct {
+		In, Want string
+	}{
+		{"* OK [CAPABILITY IMAP4rev1 LITERAL+ IDLE] Courier-IMAP ready.\r\n",
+			"* OK [CAPABILITY IMAP4rev1 IDLE] Courier-IMAP ready.\r\n"},
+		// No capabilities in the greeting: go-imap asks for them.
+		{"* OK Zimbra IMAP4rev1 server ready\r\n* CAPABILITY IMAP4rev1 literal- IDLE LITERAL+\r\n" +
+			"A1 OK [CAPABILITY IMAP4rev1 LITERAL+] done\r\n",
+			"* OK Zimbra IMAP4rev1 server ready\r\n* CAPABILITY IMAP4rev1 IDLE\r\n" +
+				"A1 OK [CAPABILITY IMAP4rev1] done\r\n"},
+		{"* OK [CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready.\r\n* CAPABILITY IMAP4rev1 LITERAL+\r\n",
+			"* OK [CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready.\r\n* CAPABILITY IMAP4rev1 LITERAL+\r\n"},
+	} {
+		cConn, sConn := net.Pipe()
+		go func() {
+			sConn.Write([]byte(tc.In))
+			sConn.Close()
+		}()
+		gc := newGuardConn(cConn, imapparse.Limits{}, imapparse.Lenient, nil, noPipeliningFilter())
+		b, err := io.ReadAll(gc)
+		gc.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("%q: %+v", tc.In, err)
+		}
+		if got := string(b); got != tc.Want {
+			t.Errorf("got %q, wanted %q", got, tc.Want)
+		}
+	}
+}
+
+func TestUIDSets(t *testing.T) {
+	var set SeqSet
+	for i := uint32(0); i < 150; i++ {
+		set.AddNum(2*i + 1)
+	}
+	c := &imapClient{}
+	if got := c.uidSets(set); len(got) != 1 {
+		t.Errorf("without quirk: got %d sets", len(got))
+	}
+	c.info.Quirks = QuirkSmallUIDSets
+	var all SeqSet
+	got := c.uidSets(set)
+	for _, part := range got {
+		if len(part.ranges) > smallUIDSetRanges {
+			t.Errorf("%s has %d ranges", part, len(part.ranges))
+		}
+		all.ranges = append(all.ranges, part.ranges...)
+	}
+	if len(got) != 3 || all.String() != set.String() {
+		t.Errorf("got %d sets %v", len(got), got)
+	}
+}
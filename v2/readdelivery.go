@@ -0,0 +1,124 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithReadOnlyDelivery makes the loop leave the source mailbox intact (as for a shared audit mailbox):
+// the messages are not marked Seen, not moved and not deleted, no mailbox is created,
+// and the processed ones are remembered in the store, under the scope and the name of the inbox.
+//
+// A message is delivered if it has not been recorded yet (regardless of its \Seen flag),
+// and recorded after a successful delivery, or a rejection (which would move it to errbox).
+// The snoozed and the retried messages are delivered again in the next round.
+//
+// The messages are keyed by UIDVALIDITY and UID, or if the server does not report its UIDVALIDITY,
+// by INTERNALDATE and StableID - so a changed UIDVALIDITY means a redelivery of everything.
+//
+// The Client is switched to EXAMINE if it is a ReadOnlySetter (also through a CircuitBreaker).
+// The optional interfaces changing the mailboxes are not passed through (see As).
+// It is not usable with ExactlyOnceDeliveryLoop, as that needs to store its claims.
+func WithReadOnlyDelivery(store DedupStore, scope string) LoopOption {
+	return func(o *loopOptions) { o.readOnly = &readOnlyDelivery{store: store, scope: scope} }
+}
+
+type readOnlyDelivery struct {
+	store DedupStore
+	scope string
+}
+
+// readDeliveryClient is a Client which keeps the state of the delivery in a DedupStore, not on the server.
+type readDeliveryClient struct {
+	Client
+	*readOnlyDelivery
+	snoozeBox string
+	// mailbox and keys are of the last List.
+	mailbox string
+	keys    map[uint32]string
+}
+
+func (rd *readOnlyDelivery) client(c Client, snoozeBox string) Client {
+	if ros, ok := As[ReadOnlySetter](c); ok {
+		ros.SetReadOnly(true)
+	}
+	return &readDeliveryClient{Client: c, readOnlyDelivery: rd, snoozeBox: snoozeBox}
+}
+
+func (c *readDeliveryClient) scopeOf(mbox string) string { return c.scope + "/" + mbox }
+
+// List returns all the not yet recorded messages of mbox - all, as the \Seen flag is not ours.
+func (c *readDeliveryClient) List(ctx context.Context, mbox, pattern string, _ bool) ([]uint32, error) {
+	uids, err := c.Client.List(ctx, mbox, pattern, true)
+	if err != nil || len(uids) == 0 {
+		return uids, err
+	}
+	var uidValidity uint32
+	if s, ok := As[SelectedStatuser](c.Client); ok {
+		if st, ok := s.SelectedStatus(); ok {
+			uidValidity = st.UIDValidity
+		}
+	}
+	infos := make([]MessageInfo, 0, len(uids))
+	if uidValidity != 0 {
+		for _, uid := range uids {
+			infos = append(infos, MessageInfo{UID: uid})
+		}
+	} else if infos, err = FetchInfo(ctx, c.Client, uids...); err != nil {
+		return nil, fmt.Errorf("fetch info of %s: %w", mbox, err)
+	}
+
+	c.mailbox, c.keys = mbox, make(map[uint32]string, len(infos))
+	uids = uids[:0]
+	for _, mi := range infos {
+		key := migrationMark(uidValidity, mi)
+		if seen, err := c.store.Has(ctx, c.scopeOf(mbox), key); err != nil {
+			return uids, fmt.Errorf("dedup %s: %w", key, err)
+		} else if !seen {
+			c.keys[mi.UID] = key
+			uids = append(uids, mi.UID)
+		}
+	}
+	return uids, nil
+}
+
+// processed records the message as processed.
+func (c *readDeliveryClient) processed(ctx context.Context, uid uint32) error {
+	key, ok := c.keys[uid]
+	if !ok {
+		return nil
+	}
+	delete(c.keys, uid)
+	_, err := c.store.Seen(ctx, c.scopeOf(c.mailbox), key)
+	return err
+}
+
+func (c *readDeliveryClient) Mark(ctx context.Context, msgID uint32, seen bool) error {
+	if !seen {
+		return nil
+	}
+	return c.processed(ctx, msgID)
+}
+func (c *readDeliveryClient) Move(ctx context.Context, msgID uint32, mbox string) error {
+	if mbox == c.snoozeBox {
+		return nil
+	}
+	return c.processed(ctx, msgID)
+}
+func (c *readDeliveryClient) Delete(ctx context.Context, msgID uint32) error { return nil }
+func (c *readDeliveryClient) CreateMailbox(ctx context.Context, mbox string) error {
+	return nil
+}
+func (c *readDeliveryClient) DeleteMailbox(ctx context.Context, mbox string) error {
+	return fmt.Errorf("delete %q: %w", mbox, ErrReadOnly)
+}
+func (c *readDeliveryClient) RenameMailbox(ctx context.Context, mbox, newName string) error {
+	return fmt.Errorf("rename %q: %w", mbox, ErrReadOnly)
+}
+func (c *readDeliveryClient) Close(ctx context.Context, commit bool) error {
+	return c.Client.Close(ctx, false)
+}
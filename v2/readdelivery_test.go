@@ -0,0 +1,71 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+type memDedup map[string]struct{}
+
+func (m memDedup) Seen(ctx context.Context, scope, key string) (bool, error) {
+	_, ok := m[scope+"\x00"+key]
+	m[scope+"\x00"+key] = struct{}{}
+	return ok, nil
+}
+func (m memDedup) Has(ctx context.Context, scope, key string) (bool, error) {
+	_, ok := m[scope+"\x00"+key]
+	return ok, nil
+}
+
+// auditClient panics on any modification, as its embedded Client is nil.
+type auditClient struct{ *contentClient }
+
+func (c auditClient) Connect(context.Context) error     { return nil }
+func (c auditClient) Close(context.Context, bool) error { return nil }
+
+func TestReadOnlyDelivery(t *testing.T) {
+	ctx := context.Background()
+	c := auditClient{&contentClient{boxes: map[string]map[uint32]string{"INBOX": {
+		1: "Subject: 1\r\n\r\n", 2: "Subject: 2\r\n\r\n", 3: "Subject: 3\r\n\r\n",
+	}}}}
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	store := make(memDedup)
+	var delivered []uint32
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		switch uid {
+		case 2:
+			return errors.New("rejected")
+		case 3:
+			if len(delivered) < 2 {
+				delivered = append(delivered, uid)
+				return RetryLater(errors.New("later"))
+			}
+		}
+		delivered = append(delivered, uid)
+		return nil
+	}
+	for i, want := range []int{1, 1, 0} {
+		n, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Archive", "Error", logger, WithReadOnlyDelivery(store, "audit"))
+		if err != nil {
+			t.Fatalf("%d. %+v", i, err)
+		}
+		if n != want {
+			t.Errorf("%d. got %d delivered, wanted %d", i, n, want)
+		}
+	}
+	// 1 is delivered, 2 is rejected (both recorded), 3 is retried.
+	if len(delivered) != 3 || delivered[0] != 1 || delivered[1] != 3 || delivered[2] != 3 {
+		t.Errorf("got %v", delivered)
+	}
+	if len(store) != 3 {
+		t.Errorf("store: %v", store)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"errors"
+)
+
+// ErrReadOnly is returned by the modifying methods of a read-only Client.
+var ErrReadOnly = errors.New("read-only")
+
+// ReadOnlySetter is implemented by the Clients which can open the mailboxes read-only.
+type ReadOnlySetter interface {
+	// SetReadOnly makes Select (and List) open the mailboxes with EXAMINE instead of SELECT,
+	// so no flag (not even \Seen) can be changed: the modifying methods return ErrReadOnly.
+	SetReadOnly(bool)
+}
+
+// SelectedStatuser is implemented by the Clients which can tell the status of the selected mailbox.
+type SelectedStatuser interface {
+	// SelectedStatus returns the status of the selected mailbox, false if there is none.
+	SelectedStatus() (SelectedStatus, bool)
+}
+
+// SelectedStatus is the status of the selected mailbox.
+type SelectedStatus struct {
+	Name string
+	// Messages, Recent and Unseen are the number of the messages,
+	// UIDNext and UIDValidity are as in RFC 3501.
+	Messages, Recent, Unseen, UIDNext, UIDValidity uint32
+	// ReadOnly is true if the mailbox has been opened with EXAMINE,
+	// or the server allows only read access.
+	ReadOnly bool
+	// PermanentFlags are the flags which can be changed permanently;
+	// `\*` means that new keywords can be created, too.
+	PermanentFlags []string
+}
+
+var (
+	_ ReadOnlySetter   = (*imapClient)(nil)
+	_ SelectedStatuser = (*imapClient)(nil)
+)
+
+// SetReadOnly makes Select use EXAMINE - it applies from the next Select.
+func (c *imapClient) SetReadOnly(readOnly bool) { c.readOnly = readOnly }
+
+// SelectedStatus returns the status of the selected mailbox.
+func (c *imapClient) SelectedStatus() (SelectedStatus, bool) {
+	st := c.status
+	if st == nil {
+		return SelectedStatus{}, false
+	}
+	return SelectedStatus{
+		Name: st.Name, ReadOnly: st.ReadOnly,
+		Messages: st.Messages, Recent: st.Recent, Unseen: st.Unseen,
+		UIDNext: st.UidNext, UIDValidity: st.UidValidity,
+		PermanentFlags: st.PermanentFlags,
+	}, true
+}
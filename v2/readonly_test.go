@@ -0,0 +1,29 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyModify(t *testing.T) {
+	ctx := context.Background()
+	// no connection: the methods must return before sending anything
+	c := &imapClient{readOnly: true}
+	for name, f := range map[string]func() error{
+		"Annotate":      func() error { return c.Annotate(ctx, 1, "Archive", [][2]string{{"X-A", "b"}}) },
+		"CreateMailbox": func() error { return c.CreateMailbox(ctx, "a") },
+		"DeleteMailbox": func() error { return c.DeleteMailbox(ctx, "a") },
+		"RenameMailbox": func() error { return c.RenameMailbox(ctx, "a", "b") },
+		"AppendFlags":   func() error { return c.AppendFlags(ctx, "a", []byte("x"), time.Time{}, nil) },
+	} {
+		if err := f(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %+v, wanted ErrReadOnly", name, err)
+		}
+	}
+}
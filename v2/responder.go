@@ -0,0 +1,319 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Sender sends a message - SMTPForwarder implements it.
+type Sender interface {
+	Send(ctx context.Context, from string, to []string, msg []byte) error
+}
+
+// Send sends the message through the relay. It implements Sender.
+func (f *SMTPForwarder) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	return f.send(ctx, from, to, msg)
+}
+
+// replier is implemented by the Clients which can reply to a message themselves (o365).
+type replier interface {
+	// ReplyMessage replies to the sender of the message with the subject and body (HTML if html),
+	// and the headers.
+	ReplyMessage(ctx context.Context, msgID uint32, subject, body string, html bool, headers [][2]string) error
+}
+
+// ResponderData is the data of the Responder templates.
+type ResponderData struct {
+	Header    mail.Header
+	From      string
+	To        string
+	Subject   string
+	MessageID string
+	Date      time.Time
+	UID       uint32
+}
+
+// Responder sends automatic replies (RFC 3834) to the delivered messages.
+//
+// It does not reply to automatic messages (Auto-Submitted, Precedence: bulk, mailing lists,
+// bounces), to its own address, and replies at most once per Interval to the same sender.
+type Responder struct {
+	// Sender sends the replies. If nil, the Client is used if it can reply (o365).
+	Sender Sender
+	// Client to reply with (when Sender is nil), and to append the sent replies to SentMailbox.
+	Client Client
+	// Match selects the messages to reply to. All, if nil.
+	Match func(mail.Header) bool
+	// Subject of the reply, "Re: {{.Subject}}" if nil.
+	Subject *texttemplate.Template
+	// Text is the plain text body of the reply. Required.
+	Text *texttemplate.Template
+	// HTML is the optional HTML alternative of the body.
+	HTML   *htmltemplate.Template
+	Logger *slog.Logger
+	last   map[string]time.Time
+	// From is the address of the replies.
+	From string
+	// SentMailbox is where the copies of the sent replies are appended, if not empty.
+	SentMailbox string
+	// Interval is the minimal time between two replies to the same sender, 24h if zero.
+	Interval time.Duration
+	mu       sync.Mutex
+}
+
+var defaultResponderSubject = texttemplate.Must(texttemplate.New("subject").Parse("Re: {{.Subject}}"))
+
+// Wrap returns a DeliverFunc which replies to the successfully delivered messages.
+// The errors of the reply are only logged.
+func (r *Responder) Wrap(deliver DeliverFunc) DeliverFunc {
+	return func(ctx context.Context, msg io.ReadSeeker, uid uint32, hsh HashArray) error {
+		if err := deliver(ctx, msg, uid, hsh); err != nil {
+			return err
+		}
+		if _, err := msg.Seek(0, io.SeekStart); err != nil {
+			return nil
+		}
+		if err := r.Respond(ctx, msg, uid); err != nil {
+			r.logger().Error("auto-reply", "uid", uid, "error", err)
+		}
+		return nil
+	}
+}
+
+func (r *Responder) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+// Respond replies to the message, if it should be replied to.
+func (r *Responder) Respond(ctx context.Context, msg io.Reader, uid uint32) error {
+	m, err := mail.ReadMessage(msg)
+	if err != nil {
+		return err
+	}
+	if r.Match != nil && !r.Match(m.Header) {
+		return nil
+	}
+	if reason := autoReplyForbidden(m.Header); reason != "" {
+		r.logger().Debug("no auto-reply", "uid", uid, "reason", reason)
+		return nil
+	}
+	to := m.Header.Get("Reply-To")
+	if to == "" {
+		to = m.Header.Get("From")
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(rcpt.Address, r.From) {
+		return nil
+	}
+	if !r.allowed(rcpt.Address) {
+		r.logger().Debug("no auto-reply", "uid", uid, "reason", "rate limit", "to", rcpt.Address)
+		return nil
+	}
+
+	data := ResponderData{
+		Header: m.Header, UID: uid,
+		From: m.Header.Get("From"), To: m.Header.Get("To"),
+		Subject:   decodeHeader(m.Header.Get("Subject")),
+		MessageID: m.Header.Get("Message-ID"),
+	}
+	data.Date, _ = m.Header.Date()
+	subjTmpl := r.Subject
+	if subjTmpl == nil {
+		subjTmpl = defaultResponderSubject
+	}
+	var subj strings.Builder
+	if err = subjTmpl.Execute(&subj, data); err != nil {
+		return err
+	}
+	var text, html bytes.Buffer
+	if err = r.Text.Execute(&text, data); err != nil {
+		return err
+	}
+	if r.HTML != nil {
+		if err = r.HTML.Execute(&html, data); err != nil {
+			return err
+		}
+	}
+
+	if r.Sender == nil {
+		rp, ok := As[replier](r.Client)
+		if !ok {
+			return errors.New("no Sender, and the Client cannot reply")
+		}
+		body := text.String()
+		if r.HTML != nil {
+			body = html.String()
+		}
+		if err = rp.ReplyMessage(ctx, uid, subj.String(), body, r.HTML != nil, autoReplyHeaders); err != nil {
+			return err
+		}
+		r.record(rcpt.Address)
+		return nil
+	}
+
+	reply, err := r.compose(rcpt, data, subj.String(), text.Bytes(), html.Bytes())
+	if err != nil {
+		return err
+	}
+	if err = r.Sender.Send(ctx, r.From, []string{rcpt.Address}, reply); err != nil {
+		return err
+	}
+	r.record(rcpt.Address)
+	if r.Client != nil && r.SentMailbox != "" {
+		if err = r.Client.WriteTo(ctx, r.SentMailbox, reply, time.Now()); err != nil {
+			r.logger().Warn("append reply", "mailbox", r.SentMailbox, "error", err)
+		}
+	}
+	return nil
+}
+
+func (r *Responder) interval() time.Duration {
+	if r.Interval <= 0 {
+		return 24 * time.Hour
+	}
+	return r.Interval
+}
+
+// allowed reports whether the address can be replied to now.
+func (r *Responder) allowed(address string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.last[strings.ToLower(address)]
+	return !ok || time.Since(t) >= r.interval()
+}
+
+// record records the reply sent to the address.
+func (r *Responder) record(address string) {
+	interval := r.interval()
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last == nil {
+		r.last = make(map[string]time.Time)
+	}
+	for k, t := range r.last { // forget the old ones
+		if now.Sub(t) >= interval {
+			delete(r.last, k)
+		}
+	}
+	r.last[strings.ToLower(address)] = now
+}
+
+// autoReplyHeaders mark the replies as automatic (RFC 3834), and suppress the auto-replies of Exchange to them.
+var autoReplyHeaders = [][2]string{{"Auto-Submitted", "auto-replied"}, {"X-Auto-Response-Suppress", "All"}}
+
+// autoReplyForbidden returns the reason why the message must not be replied to automatically
+// (RFC 3834 section 2), or "".
+func autoReplyForbidden(hdr mail.Header) string {
+	if v := strings.ToLower(strings.TrimSpace(hdr.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return "Auto-Submitted: " + v
+	}
+	switch v := strings.ToLower(strings.TrimSpace(hdr.Get("Precedence"))); v {
+	case "bulk", "list", "junk":
+		return "Precedence: " + v
+	}
+	for _, k := range []string{"List-Id", "List-Unsubscribe", "X-Auto-Response-Suppress", "X-Autoreply", "X-Autorespond"} {
+		if hdr.Get(k) != "" {
+			return k
+		}
+	}
+	if rp, ok := hdr["Return-Path"]; ok && len(rp) != 0 && strings.TrimSpace(rp[0]) == "<>" {
+		return "bounce"
+	}
+	if a, err := mail.ParseAddress(hdr.Get("From")); err == nil {
+		local, _, _ := strings.Cut(strings.ToLower(a.Address), "@")
+		switch local {
+		case "mailer-daemon", "postmaster", "noreply", "no-reply", "donotreply", "do-not-reply":
+			return "From: " + a.Address
+		}
+	}
+	return ""
+}
+
+// compose the reply message, with the In-Reply-To, References and Auto-Submitted headers.
+// The reply is multipart/alternative if html is not empty.
+func (r *Responder) compose(rcpt *mail.Address, data ResponderData, subj string, text, html []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	hdr := func(k, v string) {
+		if v != "" {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	hdr("From", r.From)
+	hdr("To", rcpt.String())
+	hdr("Subject", mime.QEncoding.Encode("utf-8", subj))
+	hdr("Date", time.Now().Format(time.RFC1123Z))
+	hdr("Message-ID", newMessageID(r.From))
+	hdr("In-Reply-To", data.MessageID)
+	hdr("References", strings.TrimSpace(data.Header.Get("References")+" "+data.MessageID))
+	for _, kv := range autoReplyHeaders {
+		hdr(kv[0], kv[1])
+	}
+	hdr("MIME-Version", "1.0")
+
+	if len(html) == 0 {
+		hdr("Content-Type", "text/plain; charset=utf-8")
+		hdr("Content-Transfer-Encoding", "8bit")
+		buf.WriteString("\r\n")
+		buf.Write(text)
+		return buf.Bytes(), nil
+	}
+	mw := multipart.NewWriter(&buf)
+	hdr("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct {
+		typ  string
+		body []byte
+	}{{"text/plain", text}, {"text/html", html}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newMessageID(from string) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	domain := "localhost"
+	if a, err := mail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(a.Address, "@"); ok {
+			domain = d
+		}
+	}
+	return "<" + hex.EncodeToString(b[:]) + "@" + domain + ">"
+}
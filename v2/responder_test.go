@@ -0,0 +1,92 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+)
+
+type senderFunc func(ctx context.Context, from string, to []string, msg []byte) error
+
+func (f senderFunc) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	return f(ctx, from, to, msg)
+}
+
+// replyClient records the replies of ReplyMessage.
+type replyClient struct {
+	Client
+	replies []string
+	headers [][2]string
+}
+
+func (c *replyClient) ReplyMessage(ctx context.Context, msgID uint32, subject, body string, html bool, headers [][2]string) error {
+	c.replies = append(c.replies, subject+"|"+body)
+	c.headers = headers
+	return nil
+}
+
+const responderMsg = "From: a@example.com\r\nTo: me@example.com\r\nSubject: question\r\nMessage-ID: <1@example.com>\r\n\r\nbody\r\n"
+
+func TestResponderSendFailure(t *testing.T) {
+	ctx := context.Background()
+	var sent []string
+	fail := true
+	r := &Responder{
+		From: "me@example.com",
+		Text: texttemplate.Must(texttemplate.New("text").Parse("Got {{.Subject}}")),
+		Sender: senderFunc(func(ctx context.Context, from string, to []string, msg []byte) error {
+			if fail {
+				return errors.New("send failed")
+			}
+			sent = append(sent, string(msg))
+			return nil
+		}),
+	}
+	if err := r.Respond(ctx, strings.NewReader(responderMsg), 1); err == nil {
+		t.Fatal("wanted the error of the Sender")
+	}
+	// The failed send does not count for the Interval.
+	fail = false
+	for i := 0; i < 2; i++ {
+		if err := r.Respond(ctx, strings.NewReader(responderMsg), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sent) != 1 {
+		t.Fatalf("sent %d replies, wanted 1", len(sent))
+	}
+	for _, want := range []string{"Subject: Re: question\r\n", "Auto-Submitted: auto-replied\r\n",
+		"X-Auto-Response-Suppress: All\r\n", "In-Reply-To: <1@example.com>\r\n", "\r\n\r\nGot question"} {
+		if !strings.Contains(sent[0], want) {
+			t.Errorf("no %q in\n%s", want, sent[0])
+		}
+	}
+}
+
+func TestResponderReplier(t *testing.T) {
+	ctx := context.Background()
+	c := &replyClient{}
+	r := &Responder{
+		Client:  NewCircuitBreaker(c, 1, 0),
+		From:    "me@example.com",
+		Subject: texttemplate.Must(texttemplate.New("subject").Parse("Auto: {{.Subject}}")),
+		Text:    texttemplate.Must(texttemplate.New("text").Parse("Got {{.Subject}}")),
+		HTML:    htmltemplate.Must(htmltemplate.New("html").Parse("<p>Got {{.Subject}}</p>")),
+	}
+	if err := r.Respond(ctx, strings.NewReader(responderMsg), 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.replies) != 1 || c.replies[0] != "Auto: question|<p>Got question</p>" {
+		t.Errorf("got %q", c.replies)
+	}
+	if len(c.headers) != 2 || c.headers[0] != [2]string{"Auto-Submitted", "auto-replied"} {
+		t.Errorf("got headers %q", c.headers)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// ErrCorrupt is returned by Restore for a message file which differs from the manifest.
+var ErrCorrupt = errors.New("corrupt")
+
+// FlagAppender is implemented by the Clients which can set the flags of the appended message.
+type FlagAppender interface {
+	// AppendFlags appends the message as WriteTo, with the flags set.
+	AppendFlags(ctx context.Context, mbox string, msg []byte, date time.Time, flags []string) error
+}
+
+var _ FlagAppender = (*imapClient)(nil)
+
+// appendableFlags returns the flags without \Recent, which can be set only by the server,
+// and \Deleted, which would make the next expunge remove the appended message.
+func appendableFlags(flags []string) []string {
+	if len(flags) == 0 {
+		return nil
+	}
+	res := make([]string, 0, len(flags))
+	for _, f := range flags {
+		if !strings.EqualFold(f, imap.RecentFlag) && !strings.EqualFold(f, imap.DeletedFlag) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// setAppendedFlags sets the \Seen and \Flagged flags of the message appended (without flags) to mbox,
+// found by its Message-ID: with SetFlags if c is a Flagger, otherwise only \Seen with Mark.
+func setAppendedFlags(ctx context.Context, c Client, mbox, messageID string, flags []string) error {
+	var set []string
+	for _, f := range flags {
+		if strings.EqualFold(f, imap.SeenFlag) || strings.EqualFold(f, imap.FlaggedFlag) {
+			set = append(set, f)
+		}
+	}
+	fl, isFlagger := As[Flagger](c)
+	if !isFlagger {
+		set = slices.DeleteFunc(set, func(f string) bool { return !strings.EqualFold(f, imap.SeenFlag) })
+	}
+	if len(set) == 0 || messageID == "" {
+		return nil
+	}
+	uids, err := FindByMessageID(ctx, c, mbox, messageID)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if isFlagger {
+			err = fl.SetFlags(ctx, uid, true, set...)
+		} else {
+			err = c.Mark(ctx, uid, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FolderMap maps the portable folder paths ("a/b/c") of the backup to the ones of the target.
+//
+// A key maps the folder with the same path, and its subfolders (unless they have
+// a more specific key): {"Old": "Archive/Old"} maps "Old/2019" to "Archive/Old/2019".
+// The folders mapped to "" are not restored.
+type FolderMap map[string]string
+
+// Map returns the mapped path, the path itself if no key matches it.
+func (fm FolderMap) Map(path string) (string, bool) {
+	for prefix := path; ; {
+		if to, ok := fm[prefix]; ok {
+			if to == "" {
+				return "", false
+			}
+			return to + path[len(prefix):], true
+		}
+		i := strings.LastIndex(prefix, PathSeparator)
+		if i < 0 {
+			return path, true
+		}
+		prefix = prefix[:i]
+	}
+}
+
+// RestoreOptions are the options of Restore.
+type RestoreOptions struct {
+	// Folders maps the folders of the backup.
+	Folders FolderMap
+	// SkipExisting skips the messages which are already in the target folder,
+	// with the same Message-ID, or (for the ones without Message-ID) the same content.
+	SkipExisting bool
+}
+
+// Restore appends the messages of the backup in dir (written by Backup) into c,
+// with their original INTERNALDATE and flags (except \Recent and \Deleted),
+// creating the (mapped) folders if needed.
+//
+// If c is not a FlagAppender, only \Seen and \Flagged are restored, after the append
+// (see Flagger and MessageIDFinder) - so not for the messages without Message-ID.
+//
+// It returns the number of the restored and the skipped messages.
+func Restore(ctx context.Context, c Client, dir string, opts RestoreOptions) (restored, skipped int, err error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	srcDelim := m.Delimiter
+	if srcDelim == "" {
+		srcDelim = PathSeparator
+	}
+	// targets are the target mailboxes of the mailboxes of the backup, "" for the skipped ones.
+	targets := make(map[string]string)
+	// existing are the Message-IDs and hashes of the messages in the target mailboxes.
+	existing := make(map[string]map[string]struct{})
+	fa, _ := As[FlagAppender](c)
+	for _, e := range m.Entries {
+		target, ok := targets[e.Mailbox]
+		if !ok {
+			path, ok := opts.Folders.Map(strings.Join(SplitFolderPath(srcDelim, e.Mailbox), PathSeparator))
+			if ok {
+				if target, err = EnsureFolderPath(ctx, c, path); err != nil {
+					return restored, skipped, fmt.Errorf("create %q: %w", path, err)
+				}
+				if opts.SkipExisting {
+					if existing[target], err = existingKeys(ctx, c, target); err != nil {
+						return restored, skipped, err
+					}
+				}
+			}
+			targets[e.Mailbox] = target
+		}
+		if target == "" {
+			skipped++
+			continue
+		}
+		if keys := existing[target]; keys != nil {
+			if _, ok := keys[e.MessageID]; ok && e.MessageID != "" {
+				skipped++
+				continue
+			}
+			if _, ok := keys[e.SHA256]; ok {
+				skipped++
+				continue
+			}
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(e.Path)))
+		if err != nil {
+			return restored, skipped, err
+		}
+		if sha256Hex(b) != e.SHA256 {
+			return restored, skipped, fmt.Errorf("%s: %w", e.Path, ErrCorrupt)
+		}
+		if fa != nil {
+			err = fa.AppendFlags(ctx, target, b, e.Date, appendableFlags(e.Flags))
+		} else if err = c.WriteTo(ctx, target, b, e.Date); err == nil {
+			err = setAppendedFlags(ctx, c, target, e.MessageID, e.Flags)
+		}
+		if err != nil {
+			return restored, skipped, fmt.Errorf("append %s to %q: %w", e.Path, target, err)
+		}
+		restored++
+	}
+	return restored, skipped, nil
+}
+
+// existingKeys returns the Message-IDs of the messages in mbox, and the hashes of the ones without Message-ID.
+func existingKeys(ctx context.Context, c Client, mbox string) (map[string]struct{}, error) {
+	infos, err := ListInfo(ctx, c, mbox, "", true)
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", mbox, err)
+	}
+	keys := make(map[string]struct{}, len(infos))
+	var buf bytes.Buffer
+	for _, mi := range infos {
+		if mi.MessageID != "" {
+			keys[mi.MessageID] = struct{}{}
+			continue
+		}
+		buf.Reset()
+		if _, err := c.ReadTo(ctx, &buf, mi.UID); err != nil {
+			return nil, fmt.Errorf("read %q/%d: %w", mbox, mi.UID, err)
+		}
+		keys[sha256Hex(buf.Bytes())] = struct{}{}
+	}
+	return keys, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Action is what the DeliveryLoop does with the message after deliver.
+type Action uint8
+
+const (
+	// Delivered marks the message Seen, and moves it to the Mailbox of the Result (or the outbox).
+	Delivered = Action(iota)
+	// Retry leaves the message as is, to be delivered again in the next round.
+	Retry
+	// Reject moves the message to the Mailbox of the Result (or the errbox).
+	Reject
+	// Skip leaves the message as is, unread - as ErrSkip.
+	Skip
+	// Snoozed moves the message to the snooze mailbox till Until - see SnoozeUntil.
+	Snoozed
+	// Handled means the message has already been moved to the Mailbox of the Result (or marked, deleted)
+	// before the delivery - for example by a Rule -, so the DeliveryLoop leaves it alone.
+	Handled
+)
+
+func (a Action) String() string {
+	switch a {
+	case Delivered:
+		return "delivered"
+	case Retry:
+		return "retry"
+	case Reject:
+		return "reject"
+	case Skip:
+		return "skip"
+	case Snoozed:
+		return "snoozed"
+	case Handled:
+		return "handled"
+	}
+	return fmt.Sprintf("Action(%d)", uint8(a))
+}
+
+// Result is a typed result of a DeliverFunc, returned as its error
+// to tell the DeliveryLoop the Action to take with the message.
+//
+// A nil error is the same as Delivered, a plain error as Reject, ErrSkip as Skip.
+type Result struct {
+	// Err is the cause of Retry, Reject or Skip.
+	Err error
+	// Mailbox overrides the outbox for Delivered, the errbox for Reject;
+	// for Handled it is where the message has been moved to.
+	Mailbox string
+	// Until is the wake-up time of Snoozed.
+	Until time.Time
+	// headers are added to the archived copy of the delivered message, see WithAnnotatedArchive.
+	headers [][2]string
+	// partition is the layout of the partition of the Mailbox, see WithPartitionedOutbox.
+	partition string
+	Action    Action
+	// seen marks the rejected message Seen (see WithParseErrors), or tells the Handled one is marked Seen.
+	seen bool
+	// deleted tells the Handled message is deleted.
+	deleted bool
+}
+
+// MoveTo returns the Result for a delivered message, to be moved to the mailbox instead of the outbox -
+// for example to route the invoices and the orders to different folders.
+func MoveTo(mailbox string) error { return &Result{Action: Delivered, Mailbox: mailbox} }
+
+// RetryLater returns the Result for a message to be retried later, left as is.
+func RetryLater(err error) error { return &Result{Action: Retry, Err: err} }
+
+// RejectTo returns the Result for a rejected message, to be moved to the mailbox instead of the errbox.
+func RejectTo(mailbox string, err error) error {
+	return &Result{Action: Reject, Mailbox: mailbox, Err: err}
+}
+
+func (r *Result) Error() string {
+	s := r.Action.String()
+	if r.Mailbox != "" {
+		s += " to " + r.Mailbox
+	}
+	if r.Action == Snoozed {
+		s += " till " + r.Until.Format(time.RFC3339)
+	}
+	if r.Err != nil {
+		s += ": " + r.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns Err - and ErrSkip for Retry, Skip and Snoozed, as the message is left in the inbox.
+func (r *Result) Unwrap() []error {
+	errs := make([]error, 0, 2)
+	if r.Err != nil {
+		errs = append(errs, r.Err)
+	}
+	if r.Action == Retry || r.Action == Skip || r.Action == Snoozed {
+		errs = append(errs, ErrSkip)
+	}
+	return errs
+}
+
+// resultOf returns the Result of the error returned by the DeliverFunc.
+func resultOf(err error) Result {
+	if err == nil {
+		return Result{Action: Delivered}
+	}
+	var r *Result
+	if errors.As(err, &r) {
+		return *r
+	}
+	if errors.Is(err, ErrSkip) {
+		return Result{Action: Skip, Err: err}
+	}
+	return Result{Action: Reject, Err: err}
+}
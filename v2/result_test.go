@@ -0,0 +1,83 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResultActions(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	inbox := newFakeMailbox()
+	for uid, subject := range []string{"ok", "invoice", "later", "spam", "bad", "skip"} {
+		inbox.add(uint32(uid+1), subject)
+	}
+	c := &fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}}
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		switch strings.TrimSpace(strings.TrimPrefix(line, "Subject: ")) {
+		case "invoice":
+			return MoveTo("Invoices")
+		case "later":
+			return RetryLater(errors.New("later"))
+		case "spam":
+			return RejectTo("Spam", errors.New("spam"))
+		case "bad":
+			return errors.New("bad")
+		case "skip":
+			return ErrSkip
+		}
+		return nil
+	}
+	n, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "Err", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d delivered, wanted 2", n)
+	}
+	for mbox, want := range map[string][]string{
+		"INBOX":    {"later", "skip"},
+		"Done":     {"ok"},
+		"Invoices": {"invoice"},
+		"Spam":     {"spam"},
+		"Err":      {"bad"},
+	} {
+		if got := c.box(mbox).sortedSubjects(); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, wanted %v", mbox, got, want)
+		}
+	}
+	if uids, _ := c.List(ctx, "INBOX", "", false); len(uids) != 2 {
+		t.Errorf("INBOX: got %d unseen, wanted later and skip", len(uids))
+	}
+
+	for _, tc := range []struct {
+		Err  error
+		Want Action
+		Skip bool
+	}{
+		{Err: nil, Want: Delivered},
+		{Err: MoveTo("x"), Want: Delivered},
+		{Err: RetryLater(io.EOF), Want: Retry, Skip: true},
+		{Err: RejectTo("x", io.EOF), Want: Reject},
+		{Err: io.EOF, Want: Reject},
+		{Err: ErrSkip, Want: Skip, Skip: true},
+	} {
+		if got := resultOf(tc.Err); got.Action != tc.Want {
+			t.Errorf("%v: got %v, wanted %v", tc.Err, got.Action, tc.Want)
+		}
+		if tc.Err != nil && errors.Is(tc.Err, ErrSkip) != tc.Skip {
+			t.Errorf("%v: Is ErrSkip=%t", tc.Err, !tc.Skip)
+		}
+	}
+}
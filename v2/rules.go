@@ -0,0 +1,269 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/emersion/go-imap"
+)
+
+// RuleAction is the action of a matching Rule.
+type RuleAction string
+
+const (
+	// RuleDeliver delivers the message, with the Tag of the Rule - see RuleTag.
+	RuleDeliver = RuleAction("deliver")
+	// RuleMove moves the message to the Mailbox of the Rule, without delivering it.
+	RuleMove = RuleAction("move")
+	// RuleMark marks the message Seen, without delivering it.
+	RuleMark = RuleAction("mark")
+	// RuleDrop deletes the message, without delivering it.
+	RuleDrop = RuleAction("drop")
+)
+
+// Rule is a declarative routing rule: if all the given conditions match, the Action is taken.
+//
+// The From, To, Subject and Header conditions are regular expressions,
+// matched against the decoded header values.
+type Rule struct {
+	Header  map[string]string `toml:"header" yaml:"header" json:"header,omitempty"`
+	Name    string            `toml:"name" yaml:"name" json:"name"`
+	From    string            `toml:"from" yaml:"from" json:"from,omitempty"`
+	To      string            `toml:"to" yaml:"to" json:"to,omitempty"`
+	Subject string            `toml:"subject" yaml:"subject" json:"subject,omitempty"`
+	Action  RuleAction        `toml:"action" yaml:"action" json:"action"`
+	// Tag is passed to the DeliverFunc with RuleDeliver.
+	Tag string `toml:"tag" yaml:"tag" json:"tag,omitempty"`
+	// Mailbox is the destination of RuleMove.
+	Mailbox string `toml:"mailbox" yaml:"mailbox" json:"mailbox,omitempty"`
+	// Flags must all be set, NoFlags must all be unset on the message.
+	Flags   []string `toml:"flags" yaml:"flags" json:"flags,omitempty"`
+	NoFlags []string `toml:"no_flags" yaml:"no_flags" json:"no_flags,omitempty"`
+	// MinSize and MaxSize bound the size of the message, if not zero.
+	MinSize int64 `toml:"min_size" yaml:"min_size" json:"min_size,omitempty"`
+	MaxSize int64 `toml:"max_size" yaml:"max_size" json:"max_size,omitempty"`
+}
+
+type compiledRule struct {
+	Rule
+	header                 map[string]*regexp.Regexp
+	from, to, subject      *regexp.Regexp
+	needsHeader, needsMeta bool
+}
+
+// Rules is a hot-reloadable list of Rules, evaluated in order: the first matching Rule wins,
+// the messages not matching any Rule are delivered.
+//
+// It is safe for concurrent use: Set can be called while a DeliveryLoop uses it.
+type Rules struct {
+	rules atomic.Pointer[[]compiledRule]
+}
+
+// NewRules returns the compiled Rules.
+func NewRules(rules []Rule) (*Rules, error) {
+	var rs Rules
+	if err := rs.Set(rules); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// Set replaces the rules, if all of them are valid.
+func (rs *Rules) Set(rules []Rule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	var errs []error
+	for i, r := range rules {
+		cr, err := r.compile()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d %q: %w", i, r.Name, err))
+			continue
+		}
+		compiled = append(compiled, cr)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	rs.rules.Store(&compiled)
+	return nil
+}
+
+func (r Rule) compile() (compiledRule, error) {
+	cr := compiledRule{Rule: r}
+	switch r.Action {
+	case RuleDeliver, RuleMark, RuleDrop:
+	case RuleMove:
+		if r.Mailbox == "" {
+			return cr, errors.New("move needs a mailbox")
+		}
+	default:
+		return cr, fmt.Errorf("unknown action %q", r.Action)
+	}
+	var err error
+	compile := func(s string) *regexp.Regexp {
+		if s == "" || err != nil {
+			return nil
+		}
+		var rx *regexp.Regexp
+		if rx, err = regexp.Compile(s); err != nil {
+			err = fmt.Errorf("%q: %w", s, err)
+		}
+		return rx
+	}
+	cr.from, cr.to, cr.subject = compile(r.From), compile(r.To), compile(r.Subject)
+	if len(r.Header) != 0 {
+		cr.header = make(map[string]*regexp.Regexp, len(r.Header))
+		for k, v := range r.Header {
+			cr.header[textproto.CanonicalMIMEHeaderKey(k)] = compile(v)
+		}
+	}
+	cr.needsHeader = cr.from != nil || cr.to != nil || cr.subject != nil || len(cr.header) != 0
+	cr.needsMeta = len(r.Flags) != 0 || len(r.NoFlags) != 0 || r.MinSize != 0 || r.MaxSize != 0
+	return cr, err
+}
+
+// ruleMessage is the data of the message the rules are matched against.
+type ruleMessage struct {
+	header textproto.MIMEHeader
+	flags  []string
+	size   int64
+}
+
+func (cr compiledRule) match(m ruleMessage) bool {
+	matchHeader := func(rx *regexp.Regexp, key string) bool {
+		if rx == nil {
+			return true
+		}
+		for _, v := range m.header.Values(key) {
+			if rx.MatchString(decodeHeader(v)) {
+				return true
+			}
+		}
+		return false
+	}
+	if !matchHeader(cr.from, "From") || !matchHeader(cr.to, "To") || !matchHeader(cr.subject, "Subject") {
+		return false
+	}
+	for k, rx := range cr.header {
+		if !matchHeader(rx, k) {
+			return false
+		}
+	}
+	hasFlag := func(flag string) bool {
+		for _, f := range m.flags {
+			if strings.EqualFold(f, flag) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, f := range cr.Flags {
+		if !hasFlag(f) {
+			return false
+		}
+	}
+	for _, f := range cr.NoFlags {
+		if hasFlag(f) {
+			return false
+		}
+	}
+	return (cr.MinSize == 0 || m.size >= cr.MinSize) && (cr.MaxSize == 0 || m.size <= cr.MaxSize)
+}
+
+// Match returns the first Rule matching the message, fetching only what the Rules need.
+func (rs *Rules) Match(ctx context.Context, c Client, uid uint32) (Rule, bool, error) {
+	p := rs.rules.Load()
+	if p == nil || len(*p) == 0 {
+		return Rule{}, false, nil
+	}
+	rules := *p
+	var needsHeader, needsMeta bool
+	for _, cr := range rules {
+		needsHeader = needsHeader || cr.needsHeader
+		needsMeta = needsMeta || cr.needsMeta
+	}
+	var m ruleMessage
+	if needsHeader {
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if _, err := c.Peek(ctx, buf, uid, "HEADER"); err != nil {
+			return Rule{}, false, fmt.Errorf("read header of %d: %w", uid, err)
+		}
+		buf.WriteString("\r\n") // ReadMIMEHeader needs the empty line
+		var err error
+		if m.header, err = textproto.NewReader(bufio.NewReader(buf)).ReadMIMEHeader(); err != nil && len(m.header) == 0 {
+			return Rule{}, false, fmt.Errorf("parse header of %d: %w", uid, err)
+		}
+	}
+	if needsMeta {
+		args, err := c.FetchArgs(ctx, string(imap.FetchRFC822Size)+" "+string(imap.FetchFlags), uid)
+		if err != nil {
+			return Rule{}, false, fmt.Errorf("fetch flags of %d: %w", uid, err)
+		}
+		m.flags = args[uid][string(imap.FetchFlags)]
+		if ss := args[uid][string(imap.FetchRFC822Size)]; len(ss) != 0 {
+			m.size, _ = strconv.ParseInt(ss[0], 10, 64)
+		}
+	}
+	for _, cr := range rules {
+		if cr.match(m) {
+			return cr.Rule, true, nil
+		}
+	}
+	return Rule{}, false, nil
+}
+
+type ruleTagKey struct{}
+
+// RuleTag returns the Tag of the RuleDeliver Rule the message matched, in the DeliverFunc.
+func RuleTag(ctx context.Context) string {
+	s, _ := ctx.Value(ruleTagKey{}).(string)
+	return s
+}
+
+// WithRules evaluates the Rules before the delivery of each message.
+func WithRules(rs *Rules) LoopOption {
+	return func(o *loopOptions) { o.rules = rs }
+}
+
+// routed applies the Rules before delivering the message.
+func (deliver readDeliverer) routed(rs *Rules) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		r, ok, err := rs.Match(ctx, c, uid)
+		if err != nil {
+			return err, nil
+		}
+		if !ok {
+			return deliver(ctx, c, uid, hsh)
+		}
+		applied := fmt.Errorf("rule %q: %s", r.Name, r.Action)
+		res := Result{Action: Handled, Err: applied}
+		switch r.Action {
+		case RuleMove:
+			err, res.Mailbox = c.Move(ctx, uid, r.Mailbox), r.Mailbox
+		case RuleMark:
+			err, res.seen = c.Mark(ctx, uid, true), true
+		case RuleDrop:
+			err, res.deleted = c.Delete(ctx, uid), true
+		default:
+			if r.Tag != "" {
+				ctx = context.WithValue(ctx, ruleTagKey{}, r.Tag)
+			}
+			return deliver(ctx, c, uid, hsh)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", applied, err), nil
+		}
+		return nil, &res
+	}
+}
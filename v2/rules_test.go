@@ -0,0 +1,110 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/textproto"
+	"slices"
+	"testing"
+)
+
+func TestRulesMatch(t *testing.T) {
+	rs, err := NewRules([]Rule{
+		{Name: "big", MinSize: 1 << 20, Action: RuleMove, Mailbox: "Big"},
+		{Name: "invoice", Subject: "(?i)invoice", NoFlags: []string{`\Flagged`}, Action: RuleDeliver, Tag: "invoice"},
+		{Name: "list", Header: map[string]string{"list-id": "announce"}, Action: RuleMark},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewRules([]Rule{{Name: "bad", Action: RuleMove}}); err == nil {
+		t.Error("move without mailbox: wanted error")
+	}
+	for _, tc := range []struct {
+		Want    string
+		Subject string
+		ListID  string
+		Flags   []string
+		Size    int64
+	}{
+		{Want: "big", Subject: "Invoice", Size: 2 << 20},
+		{Want: "invoice", Subject: "=?UTF-8?Q?Your_Invoice?="},
+		{Want: "", Subject: "Invoice", Flags: []string{`\Flagged`}},
+		{Want: "list", ListID: "<announce.example.com>"},
+		{Want: ""},
+	} {
+		m := ruleMessage{header: textproto.MIMEHeader{}, flags: tc.Flags, size: tc.Size}
+		if tc.Subject != "" {
+			m.header.Set("Subject", tc.Subject)
+		}
+		if tc.ListID != "" {
+			m.header.Set("List-Id", tc.ListID)
+		}
+		var got string
+		for _, cr := range *rs.rules.Load() {
+			if cr.match(m) {
+				got = cr.Name
+				break
+			}
+		}
+		if got != tc.Want {
+			t.Errorf("%+v: got %q, wanted %q", tc, got, tc.Want)
+		}
+	}
+}
+
+func TestRulesProcessingFolder(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	rs, err := NewRules([]Rule{
+		{Name: "big", Subject: "big", Action: RuleMove, Mailbox: "Big"},
+		{Name: "list", Subject: "list", Action: RuleMark},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox := newFakeMailbox()
+	for uid, subject := range []string{"big", "list", "ok"} {
+		inbox.add(uint32(uid+1), subject)
+	}
+	c := &fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}}
+	var outcomes []MessageOutcome
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error { return nil }
+	n, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "", logger,
+		WithRules(rs), WithProcessingFolder("Processing/w1"),
+		WithOutcome(func(_ context.Context, out MessageOutcome) { outcomes = append(outcomes, out) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d delivered", n)
+	}
+	for mbox, want := range map[string][]string{
+		"INBOX":         {"list"},
+		"Big":           {"big"},
+		"Done":          {"ok"},
+		"Processing/w1": {},
+	} {
+		if got := c.box(mbox).sortedSubjects(); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, wanted %v", mbox, got, want)
+		}
+	}
+	if uids, _ := c.List(ctx, "INBOX", "", false); len(uids) != 0 {
+		t.Errorf("list is not seen")
+	}
+	var handled int
+	for _, out := range outcomes {
+		if out.Action == Handled {
+			handled++
+		}
+	}
+	if handled != 2 {
+		t.Errorf("got %d handled in %+v", handled, outcomes)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultS3KeyTemplate is the default S3Archiver.KeyTemplate.
+const DefaultS3KeyTemplate = `{{.Date.Format "2006/01/02"}}/{{.Mailbox}}/{{.Hash}}.eml`
+
+// S3Archiver writes the messages to S3-compatible object storage.
+type S3Archiver struct {
+	// HTTPClient is used for the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Endpoint of the service, such as https://s3.eu-central-1.amazonaws.com.
+	// The objects are addressed path-style: Endpoint/Bucket/key.
+	Endpoint, Region, Bucket string
+	// The credentials. SessionToken is needed for temporary credentials only.
+	AccessKeyID, SecretAccessKey, SessionToken string
+	// KeyTemplate is the text/template of the object key, executed with KeyData.
+	// DefaultS3KeyTemplate is used if empty.
+	KeyTemplate string
+	// Mailbox is passed to the KeyTemplate.
+	Mailbox string
+	// ServerSideEncryption is "AES256" or "aws:kms" (with KMSKeyID), if set.
+	ServerSideEncryption, KMSKeyID string
+	// Gzip the messages, and append ".gz" to the key.
+	Gzip bool
+}
+
+// DeliverFunc returns the DeliverFunc writing the messages into the bucket.
+//
+// An upload failure is returned as error, so DeliveryLoop moves the message to the errbox.
+func (a *S3Archiver) DeliverFunc() (DeliverFunc, error) {
+	tpl, err := template.New("key").Parse(nvl(a.KeyTemplate, DefaultS3KeyTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("parse %q: %w", a.KeyTemplate, err)
+	}
+	return func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+		var buf strings.Builder
+		if err := tpl.Execute(&buf, newKeyData(bytes.NewReader(data), a.Mailbox, uid, hsh)); err != nil {
+			return fmt.Errorf("key template: %w", err)
+		}
+		key := buf.String()
+		contentType := "message/rfc822"
+		if a.Gzip {
+			var zb bytes.Buffer
+			zw := gzip.NewWriter(&zb)
+			if _, err := zw.Write(data); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			data, key, contentType = zb.Bytes(), key+".gz", "application/gzip"
+		}
+		if err := a.put(ctx, key, contentType, data); err != nil {
+			return fmt.Errorf("put %q: %w", key, err)
+		}
+		return nil
+	}, nil
+}
+
+// put uploads the data with a PutObject request, signed with AWS Signature Version 4.
+func (a *S3Archiver) put(ctx context.Context, key, contentType string, data []byte) error {
+	u, err := url.Parse(a.Endpoint)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + a.Bucket + "/" + key
+	// sent as signed
+	u.RawPath = awsURIEncode(u.Path)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", contentType)
+	if a.ServerSideEncryption != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", a.ServerSideEncryption)
+		if a.KMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", a.KMSKeyID)
+		}
+	}
+	a.sign(req, data, time.Now().UTC())
+
+	cl := a.HTTPClient
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+func (a *S3Archiver) sign(req *http.Request, data []byte, now time.Time) {
+	payloadHash := sha256.Sum256(data)
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	signed := []string{"host"}
+	for k := range req.Header {
+		switch k = strings.ToLower(k); {
+		case k == "content-type", k == "date", strings.HasPrefix(k, "x-amz-"):
+			signed = append(signed, k)
+		}
+	}
+	sort.Strings(signed)
+	var canonHeaders strings.Builder
+	for _, k := range signed {
+		v := req.Header.Get(k)
+		if k == "host" {
+			v = req.URL.Host
+		}
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonRequest := strings.Join([]string{
+		req.Method, awsURIEncode(req.URL.Path), req.URL.RawQuery,
+		canonHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	day := now.Format("20060102")
+	scope := day + "/" + a.Region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	mac := func(key []byte, s string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(s))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+a.SecretAccessKey), day)
+	key = mac(key, a.Region)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+a.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+hex.EncodeToString(mac(key, stringToSign)))
+}
+
+// awsURIEncode encodes the path by the rules of AWS Signature Version 4:
+// every byte except the unreserved characters (A-Z, a-z, 0-9, '-', '.', '_', '~') and '/' is percent-encoded.
+func awsURIEncode(path string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var buf strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch b := path[i]; {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '.', b == '_', b == '~', b == '/':
+			buf.WriteByte(b)
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(hexDigits[b>>4])
+			buf.WriteByte(hexDigits[b&15])
+		}
+	}
+	return buf.String()
+}
+
+func nvl[T comparable](a T, b ...T) T {
+	var z T
+	if a != z {
+		return a
+	}
+	for _, a := range b {
+		if a != z {
+			return a
+		}
+	}
+	return a
+}
@@ -0,0 +1,269 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scanner scans the messages for malware - Clamd and ICAP implement it.
+type Scanner interface {
+	// Scan returns the name of the found malware signature, or "" if the message is clean.
+	Scan(ctx context.Context, r io.Reader) (signature string, err error)
+}
+
+// InfectedError is the cause of the quarantine of an infected message.
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string { return "infected: " + e.Signature }
+
+// WithScanner scans the messages while reading them, before they are delivered:
+// the infected ones are moved to the quarantine mailbox (the errbox if empty),
+// with an *InfectedError holding the name of the signature.
+//
+// If the scan fails, the message is left as is, to be retried in the next round.
+func WithScanner(s Scanner, quarantine string) LoopOption {
+	return func(o *loopOptions) { o.scanner, o.quarantine = s, quarantine }
+}
+
+// scanned quarantines the infected messages.
+func (deliver readDeliverer) scanned(s Scanner, quarantine string) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		readErr, err := deliver(ctx, scanning{Client: c, scanner: s}, uid, hsh)
+		var ie *InfectedError
+		if readErr != nil && errors.As(readErr, &ie) {
+			return nil, RejectTo(quarantine, ie)
+		}
+		return readErr, err
+	}
+}
+
+// scanning is a Client whose ReadTo streams the message to the Scanner,
+// and returns it only if it is clean.
+type scanning struct {
+	Client
+	scanner Scanner
+}
+
+func (s scanning) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64, error) {
+	pr, pw := io.Pipe()
+	type result struct {
+		err       error
+		signature string
+	}
+	done := make(chan result, 1)
+	go func() {
+		sig, err := s.scanner.Scan(ctx, pr)
+		pr.CloseWithError(errStreamClosed)
+		done <- result{signature: sig, err: err}
+	}()
+	buf := getBuffer()
+	defer putBuffer(buf)
+	_, err := s.Client.ReadTo(ctx, io.MultiWriter(buf, pw), msgID)
+	pw.CloseWithError(err)
+	res := <-done
+	if res.err != nil {
+		return 0, fmt.Errorf("scan %d: %w", msgID, res.err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if res.signature != "" {
+		return 0, &InfectedError{Signature: res.signature}
+	}
+	return buf.WriteTo(w)
+}
+
+// Clamd is a Scanner using the INSTREAM command of clamd.
+type Clamd struct {
+	// Network is "tcp" (the default) or "unix".
+	Network string
+	// Addr is the address of clamd, such as "localhost:3310" or "/run/clamav/clamd.ctl".
+	Addr string
+	// Timeout of the scan, if not zero.
+	Timeout time.Duration
+}
+
+var _ Scanner = Clamd{}
+
+// clamdChunkSize is the size of the INSTREAM chunks.
+const clamdChunkSize = 64 << 10
+
+// Scan the stream with clamd.
+func (cd Clamd) Scan(ctx context.Context, r io.Reader) (string, error) {
+	conn, err := dialScanner(ctx, nvl(cd.Network, "tcp"), cd.Addr, cd.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	bw := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err = bw.WriteString("zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	buf := make([]byte, clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n != 0 {
+			if err = binary.Write(bw, binary.BigEndian, uint32(n)); err == nil {
+				_, err = bw.Write(buf[:n])
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err = bw.Write([]byte{0, 0, 0, 0}); err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && resp == "" {
+		return "", err
+	}
+	// stream: OK | stream: Eicar-Signature FOUND | ... ERROR
+	resp = strings.TrimSpace(strings.TrimSuffix(resp, "\x00"))
+	_, resp, _ = strings.Cut(resp, ": ")
+	switch {
+	case resp == "OK":
+		return "", nil
+	case strings.HasSuffix(resp, " FOUND"):
+		return strings.TrimSuffix(resp, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", resp)
+	}
+}
+
+// ICAP is a Scanner using the RESPMOD method of an ICAP (RFC 3507) server.
+type ICAP struct {
+	// URL of the service, such as "icap://localhost:1344/avscan".
+	URL string
+	// Timeout of the scan, if not zero.
+	Timeout time.Duration
+}
+
+var _ Scanner = ICAP{}
+
+// icapThreatHeaders are the header fields of the found threat, used by the different ICAP servers.
+var icapThreatHeaders = []string{"X-Infection-Found", "X-Virus-Id", "X-Violations-Found"}
+
+// Scan the stream with the ICAP server.
+func (ic ICAP) Scan(ctx context.Context, r io.Reader) (string, error) {
+	u, err := url.Parse(ic.URL)
+	if err != nil {
+		return "", err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	conn, err := dialScanner(ctx, "tcp", addr, ic.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	const httpHeader = "HTTP/1.1 200 OK\r\nContent-Type: message/rfc822\r\n\r\n"
+	bw := bufio.NewWriterSize(conn, 64<<10)
+	fmt.Fprintf(bw, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s",
+		ic.URL, u.Host, len(httpHeader), httpHeader)
+	buf := make([]byte, 32<<10)
+	for {
+		n, readErr := r.Read(buf)
+		if n != 0 {
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(buf[:n])
+			if _, err = bw.WriteString("\r\n"); err != nil {
+				return "", err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err = bw.WriteString("0\r\n\r\n"); err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	tr := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tr.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	// ICAP/1.0 204 No Content
+	_, status, _ = strings.Cut(status, " ")
+	code, _ := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+	hdr, err := tr.ReadMIMEHeader()
+	if err != nil && len(hdr) == 0 {
+		return "", err
+	}
+	var sig string
+	for _, k := range icapThreatHeaders {
+		if v := hdr.Get(k); v != "" {
+			sig = icapThreat(v)
+			break
+		}
+	}
+	switch {
+	case code == 204:
+		return "", nil
+	case code == 200 || code == 403: // modified or blocked
+		return nvl(sig, "unknown"), nil
+	default:
+		return "", fmt.Errorf("icap: %s", status)
+	}
+}
+
+// icapThreat returns the threat of "Type=0; Resolution=2; Threat=EICAR;", or the value itself.
+func icapThreat(v string) string {
+	for _, kv := range strings.Split(v, ";") {
+		if k, t, ok := strings.Cut(strings.TrimSpace(kv), "="); ok && strings.EqualFold(k, "Threat") {
+			return strings.TrimSpace(t)
+		}
+	}
+	return strings.TrimSpace(v)
+}
+
+// dialScanner connects to the scanner, with the deadline of the context or the timeout.
+func dialScanner(ctx context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	return conn, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClamd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			br := bufio.NewReader(conn)
+			if cmd, err := br.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+				conn.Close()
+				continue
+			}
+			var data bytes.Buffer
+			for {
+				var n uint32
+				if err := binary.Read(br, binary.BigEndian, &n); err != nil || n == 0 {
+					break
+				}
+				io.CopyN(&data, br, int64(n))
+			}
+			resp := "stream: OK\x00"
+			if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+				resp = "stream: Eicar-Signature FOUND\x00"
+			}
+			conn.Write([]byte(resp))
+			conn.Close()
+		}
+	}()
+
+	ctx := context.Background()
+	cd := Clamd{Addr: ln.Addr().String()}
+	for _, tc := range []struct{ msg, want string }{
+		{"Subject: clean\r\n\r\nHello\r\n", ""},
+		{"Subject: dirty\r\n\r\n" + strings.Repeat("x", clamdChunkSize) + "EICAR\r\n", "Eicar-Signature"},
+	} {
+		got, err := cd.Scan(ctx, strings.NewReader(tc.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("got %q, wanted %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+// searchBatchLen is the number of UIDs searched at once by SearchFunc.
+const searchBatchLen = 10_000
+
+// searchFuncer is implemented by the Clients which can stream the search results.
+type searchFuncer interface {
+	SearchFunc(ctx context.Context, mbox, pattern string, all bool, fn func(uid uint32) error) error
+}
+
+var _ searchFuncer = (*imapClient)(nil)
+
+// SearchFunc calls fn with the UIDs List would return, in ascending order,
+// without holding all of them in memory - for sweeps of huge mailboxes.
+//
+// If fn returns an error, the search stops and that error is returned.
+// For the Clients which cannot stream the results, List is called.
+func SearchFunc(ctx context.Context, c Client, mbox, pattern string, all bool, fn func(uid uint32) error) error {
+	if s, ok := As[searchFuncer](c); ok {
+		return s.SearchFunc(ctx, mbox, pattern, all, fn)
+	}
+	return listFunc(ctx, c, mbox, pattern, all, fn)
+}
+
+func listFunc(ctx context.Context, c Client, mbox, pattern string, all bool, fn func(uid uint32) error) error {
+	uids, err := c.List(ctx, mbox, pattern, all)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if err := fn(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchFunc searches with ESEARCH PARTIAL (RFC 9394) ranges if the server supports it,
+// in UID windows otherwise.
+//
+// The SearchWindow's Max needs all the results, so then it is the same as List.
+func (c *imapClient) SearchFunc(ctx context.Context, mbox, pattern string, all bool, fn func(uid uint32) error) error {
+	if c.window.Max > 0 {
+		return listFunc(ctx, c, mbox, pattern, all, fn)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.Select(ctx, mbox); err != nil {
+		return fmt.Errorf("SELECT %q: %w", mbox, err)
+	}
+	crit := listCriteria(pattern, all, c.window)
+	if ok, _ := c.c.Support("PARTIAL"); ok {
+		return c.searchPartial(ctx, crit, fn)
+	}
+	return c.searchWindows(ctx, crit, fn)
+}
+
+// searchWindows searches the UID ranges of searchBatchLen till UIDNEXT one by one.
+func (c *imapClient) searchWindows(ctx context.Context, crit *imap.SearchCriteria, fn func(uint32) error) error {
+	var uidNext uint32
+	if c.status != nil {
+		uidNext = c.status.UidNext
+	}
+	lo := c.window.MinUID + 1
+	for {
+		cr := *crit
+		cr.Uid = new(imap.SeqSet)
+		last := uidNext == 0 || uint64(lo)+searchBatchLen >= uint64(uidNext)
+		if last {
+			cr.Uid.AddRange(lo, 0)
+		} else {
+			cr.Uid.AddRange(lo, lo+searchBatchLen-1)
+		}
+		start := time.Now()
+		uids, err := c.c.UidSearch(&cr)
+		c.CountCommand(start, err)
+		if err != nil {
+			return fmt.Errorf("UID SEARCH %s: %w", cr.Uid, err)
+		}
+		for _, uid := range uids {
+			// UID n:* matches the last message even if its UID is less than n.
+			if uid < lo {
+				continue
+			}
+			if err := fn(uid); err != nil {
+				return err
+			}
+		}
+		if last {
+			return nil
+		}
+		lo += searchBatchLen
+	}
+}
+
+// searchPartial pages through the results with UID SEARCH RETURN (PARTIAL lo:hi).
+func (c *imapClient) searchPartial(ctx context.Context, crit *imap.SearchCriteria, fn func(uint32) error) error {
+	charset := make([]interface{}, 0, 2)
+	for _, f := range crit.Format() {
+		if s, ok := f.(string); ok && strings.IndexFunc(s, func(r rune) bool { return r >= 0x80 }) >= 0 {
+			charset = append(charset, imap.RawString("CHARSET"), imap.RawString("UTF-8"))
+			break
+		}
+	}
+	for lo := uint32(1); ; lo += searchBatchLen {
+		args := append([]interface{}{
+			imap.RawString("SEARCH"), imap.RawString("RETURN"),
+			[]interface{}{imap.RawString("PARTIAL"), imap.RawString(strconv.FormatUint(uint64(lo), 10) + ":" +
+				strconv.FormatUint(uint64(lo+searchBatchLen-1), 10))},
+		}, charset...)
+		args = append(args, crit.Format()...)
+		var resp partialSearch
+		if err := c.withTimeout(ctx, func() error {
+			start := time.Now()
+			status, err := c.c.Execute(&imap.Command{Name: "UID", Arguments: args}, &resp)
+			if err == nil {
+				err = status.Err()
+			}
+			return c.countCommand(start, err)
+		}); err != nil {
+			return fmt.Errorf("UID SEARCH RETURN (PARTIAL): %w", err)
+		}
+		if resp.set.IsEmpty() {
+			return nil
+		}
+		var err error
+		resp.set.Each(func(uid uint32) bool {
+			err = fn(uid)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		if resp.set.Len() < searchBatchLen {
+			return nil
+		}
+	}
+}
+
+// partialSearch handles the ESEARCH response of a PARTIAL search:
+//
+//   - ESEARCH (TAG "A1") UID PARTIAL (1:10000 200:250,300)
+type partialSearch struct {
+	set SeqSet
+}
+
+func (r *partialSearch) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "ESEARCH" {
+		return responses.ErrUnhandled
+	}
+	for i := 0; i < len(fields)-1; i++ {
+		if s, _ := fields[i].(string); !strings.EqualFold(s, "PARTIAL") {
+			continue
+		}
+		part, ok := fields[i+1].([]interface{})
+		if !ok || len(part) != 2 {
+			return errors.New("bad PARTIAL in ESEARCH response")
+		}
+		s, _ := part[1].(string)
+		if s == "" { // NIL
+			return nil
+		}
+		set, err := ParseSeqSet(s)
+		if err != nil {
+			return fmt.Errorf("ESEARCH PARTIAL %q: %w", s, err)
+		}
+		r.set = set
+		return nil
+	}
+	return nil
+}
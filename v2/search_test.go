@@ -0,0 +1,57 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSearchFuncPartial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	scriptServer(sConn, nil, "* OK [CAPABILITY IMAP4rev1 ESEARCH PARTIAL] ready\r\n", map[string]string{
+		"CAPABILITY": "* CAPABILITY IMAP4rev1 ESEARCH PARTIAL\r\nTAG OK done\r\n",
+		"SELECT":     "* 7 EXISTS\r\n* OK [UIDVALIDITY 1] UIDs valid\r\nTAG OK [READ-WRITE] done\r\n",
+		"UID SEARCH": "* ESEARCH (TAG \"TAG\") UID PARTIAL (1:10000 3,5:7,9)\r\nTAG OK done\r\n",
+	})
+	c := NewClientConn(cConn, "username", "password")
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+
+	var got []uint32
+	if err := SearchFunc(ctx, c, "INBOX", "", true, func(uid uint32) error {
+		got = append(got, uid)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint32{3, 5, 6, 7, 9}; !slices.Equal(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	errStop := errors.New("stop")
+	got = got[:0]
+	if err := SearchFunc(ctx, c, "INBOX", "", true, func(uid uint32) error {
+		got = append(got, uid)
+		if uid == 5 {
+			return errStop
+		}
+		return nil
+	}); !errors.Is(err, errStop) {
+		t.Errorf("got %+v, wanted the error of fn", err)
+	}
+	if want := []uint32{3, 5}; !slices.Equal(got, want) {
+		t.Errorf("stopped: got %v, wanted %v", got, want)
+	}
+}
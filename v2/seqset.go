@@ -0,0 +1,367 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// SeqSet is a set of UIDs (or sequence numbers), as "1:5,8,10:*" in IMAP.
+//
+// The zero value is an empty set. The ranges are kept sorted and merged.
+type SeqSet struct {
+	ranges []seqRange
+}
+
+// seqRange is an inclusive range, 0 is "*", the largest number in use.
+type seqRange struct {
+	Start, Stop uint32
+}
+
+// start returns the Start of the range, with "*" as the maximal uint32.
+func (r seqRange) start() uint32 {
+	if r.Start == 0 {
+		return ^uint32(0)
+	}
+	return r.Start
+}
+
+// stop returns the Stop of the range, with "*" as the maximal uint32.
+func (r seqRange) stop() uint32 {
+	if r.Stop == 0 {
+		return ^uint32(0)
+	}
+	return r.Stop
+}
+
+// NewSeqSet returns the SeqSet of the numbers.
+func NewSeqSet(nums ...uint32) SeqSet {
+	var s SeqSet
+	s.AddNum(nums...)
+	return s
+}
+
+// ParseSeqSet parses an IMAP sequence-set, such as "1:5,8,10:*".
+func ParseSeqSet(text string) (SeqSet, error) {
+	var s SeqSet
+	if text == "" {
+		return s, nil
+	}
+	parse := func(t string) (uint32, error) {
+		if t == "*" {
+			return 0, nil
+		}
+		n, err := strconv.ParseUint(t, 10, 32)
+		if err == nil && n == 0 {
+			err = errors.New("zero")
+		}
+		return uint32(n), err
+	}
+	for _, part := range strings.Split(text, ",") {
+		a, b, isRange := strings.Cut(part, ":")
+		start, err := parse(a)
+		if err != nil {
+			return SeqSet{}, fmt.Errorf("%q: %w", part, err)
+		}
+		stop := start
+		if isRange {
+			if stop, err = parse(b); err != nil {
+				return SeqSet{}, fmt.Errorf("%q: %w", part, err)
+			}
+		}
+		s.AddRange(start, stop)
+	}
+	return s, nil
+}
+
+// String returns the IMAP form of the set, such as "1:5,8,10:*".
+func (s SeqSet) String() string {
+	var buf strings.Builder
+	format := func(n uint32) {
+		if n == 0 {
+			buf.WriteByte('*')
+		} else {
+			buf.WriteString(strconv.FormatUint(uint64(n), 10))
+		}
+	}
+	for i, r := range s.ranges {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		format(r.Start)
+		if r.Stop != r.Start {
+			buf.WriteByte(':')
+			format(r.Stop)
+		}
+	}
+	return buf.String()
+}
+
+// IsEmpty reports whether the set is empty.
+func (s SeqSet) IsEmpty() bool { return len(s.ranges) == 0 }
+
+// IsFinite reports whether the set has no "*" range, so it can be iterated.
+func (s SeqSet) IsFinite() bool {
+	return len(s.ranges) == 0 || s.ranges[len(s.ranges)-1].Stop != 0
+}
+
+// Len returns the number of elements of a finite set, -1 for an infinite one.
+func (s SeqSet) Len() int {
+	if !s.IsFinite() {
+		return -1
+	}
+	var n int
+	for _, r := range s.ranges {
+		n += int(r.Stop-r.Start) + 1
+	}
+	return n
+}
+
+// Contains reports whether the set contains n.
+func (s SeqSet) Contains(n uint32) bool {
+	_, found := slices.BinarySearchFunc(s.ranges, n, func(r seqRange, n uint32) int {
+		switch {
+		case r.stop() < n:
+			return -1
+		case r.start() > n:
+			return 1
+		}
+		return 0
+	})
+	return found
+}
+
+// AddNum adds the numbers to the set.
+func (s *SeqSet) AddNum(nums ...uint32) {
+	for _, n := range nums {
+		if n != 0 {
+			s.AddRange(n, n)
+		}
+	}
+}
+
+// AddRange adds the inclusive range to the set - 0 means "*".
+func (s *SeqSet) AddRange(start, stop uint32) {
+	if start == 0 || (stop != 0 && stop < start) {
+		start, stop = stop, start
+	}
+	r := seqRange{Start: start, Stop: stop}
+	i, _ := slices.BinarySearchFunc(s.ranges, r, func(a, b seqRange) int {
+		return cmp.Compare(a.start(), b.start())
+	})
+	s.ranges = slices.Insert(s.ranges, i, r)
+	// merge the overlapping or adjacent ranges
+	merged := s.ranges[:0]
+	for _, r := range s.ranges {
+		if n := len(merged); n != 0 {
+			if last := &merged[n-1]; last.Stop == 0 || r.start() <= last.Stop+1 {
+				if last.Stop != 0 && (r.Stop == 0 || r.Stop > last.Stop) {
+					last.Stop = r.Stop
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	s.ranges = merged
+}
+
+// RemoveNum removes the numbers from the set.
+func (s *SeqSet) RemoveNum(nums ...uint32) {
+	for _, n := range nums {
+		if n != 0 {
+			s.RemoveRange(n, n)
+		}
+	}
+}
+
+// RemoveRange removes the inclusive range from the set - 0 means "*".
+func (s *SeqSet) RemoveRange(start, stop uint32) {
+	if start == 0 || (stop != 0 && stop < start) {
+		start, stop = stop, start
+	}
+	rem := seqRange{Start: start, Stop: stop}
+	ranges := make([]seqRange, 0, len(s.ranges)+1)
+	for _, r := range s.ranges {
+		if r.stop() < rem.start() || r.start() > rem.stop() { // no overlap
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.start() < rem.start() {
+			ranges = append(ranges, seqRange{Start: r.Start, Stop: rem.start() - 1})
+		}
+		if r.stop() > rem.stop() {
+			ranges = append(ranges, seqRange{Start: rem.Stop + 1, Stop: r.Stop})
+		}
+	}
+	s.ranges = ranges
+}
+
+// Each calls fn with each number of the finite set in ascending order, till fn returns false.
+// An infinite set is iterated till its last finite number.
+func (s SeqSet) Each(fn func(n uint32) bool) {
+	for _, r := range s.ranges {
+		if r.Stop == 0 {
+			return
+		}
+		for n := r.Start; ; n++ {
+			if !fn(n) {
+				return
+			}
+			if n == r.Stop {
+				break
+			}
+		}
+	}
+}
+
+// Slice returns the numbers of the finite set, in ascending order.
+func (s SeqSet) Slice() []uint32 {
+	nums := make([]uint32, 0, max(0, s.Len()))
+	s.Each(func(n uint32) bool { nums = append(nums, n); return true })
+	return nums
+}
+
+// imapSeqSet returns the set as a go-imap *SeqSet.
+func (s SeqSet) imapSeqSet() *imap.SeqSet {
+	set := new(imap.SeqSet)
+	for _, r := range s.ranges {
+		set.AddRange(r.Start, r.Stop)
+	}
+	return set
+}
+
+// bulker is implemented by the Clients which can handle a set of messages with one command.
+type bulker interface {
+	MoveSet(ctx context.Context, set SeqSet, mbox string) error
+	MarkSet(ctx context.Context, set SeqSet, seen bool) error
+	DeleteSet(ctx context.Context, set SeqSet) error
+}
+
+var _ bulker = (*imapClient)(nil)
+
+// MoveSet moves the messages of the set to mbox, with one command if the Client supports it,
+// one by one otherwise (then the set must be finite).
+func MoveSet(ctx context.Context, c Client, set SeqSet, mbox string) error {
+	if b, ok := As[bulker](c); ok {
+		return b.MoveSet(ctx, set, mbox)
+	}
+	return eachUID(set, func(uid uint32) error { return c.Move(ctx, uid, mbox) })
+}
+
+// MarkSet marks the messages of the set seen/unseen, as MoveSet.
+func MarkSet(ctx context.Context, c Client, set SeqSet, seen bool) error {
+	if b, ok := As[bulker](c); ok {
+		return b.MarkSet(ctx, set, seen)
+	}
+	return eachUID(set, func(uid uint32) error { return c.Mark(ctx, uid, seen) })
+}
+
+// DeleteSet deletes the messages of the set, as MoveSet.
+func DeleteSet(ctx context.Context, c Client, set SeqSet) error {
+	if b, ok := As[bulker](c); ok {
+		return b.DeleteSet(ctx, set)
+	}
+	return eachUID(set, func(uid uint32) error { return c.Delete(ctx, uid) })
+}
+
+func eachUID(set SeqSet, f func(uint32) error) error {
+	if !set.IsFinite() {
+		return fmt.Errorf("%s: %w", set, errors.ErrUnsupported)
+	}
+	var errs []error
+	set.Each(func(uid uint32) bool {
+		if err := f(uid); err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", uid, err))
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// MoveSet moves the messages of the set to mbox with one UID MOVE (or UID COPY and delete),
+// or more with QuirkSmallUIDSets.
+func (c *imapClient) MoveSet(ctx context.Context, set SeqSet, mbox string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("move %s: %w", set, ErrReadOnly)
+	}
+	if set.IsEmpty() {
+		return nil
+	}
+	mbox = c.mailbox(ctx, mbox)
+	c.ensureCreated(mbox)
+	move, _ := c.c.Support("MOVE")
+	for _, part := range c.uidSets(set) {
+		iset := part.imapSeqSet()
+		if move {
+			if err := c.countCommand(time.Now(), c.c.UidMove(iset, mbox)); err != nil {
+				c.dropFolders()
+				return fmt.Errorf("move %s: %w", mbox, err)
+			}
+		} else if err := c.countCommand(time.Now(), c.c.UidCopy(iset, mbox)); err != nil {
+			c.dropFolders()
+			return fmt.Errorf("copy %s: %w", mbox, err)
+		}
+	}
+	if move {
+		return c.reselectAfterMove()
+	}
+	return c.DeleteSet(ctx, set)
+}
+
+// MarkSet marks the messages of the set seen/unseen with one UID STORE.
+func (c *imapClient) MarkSet(ctx context.Context, set SeqSet, seen bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("mark %s: %w", set, ErrReadOnly)
+	}
+	if set.IsEmpty() {
+		return nil
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if !seen {
+		item = imap.FormatFlagsOp(imap.RemoveFlags, true)
+	}
+	for _, part := range c.uidSets(set) {
+		if err := c.countCommand(time.Now(), c.c.UidStore(part.imapSeqSet(), item, []interface{}{imap.SeenFlag}, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSet marks the messages of the set deleted with one UID STORE.
+func (c *imapClient) DeleteSet(ctx context.Context, set SeqSet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return fmt.Errorf("delete %s: %w", set, ErrReadOnly)
+	}
+	if set.IsEmpty() {
+		return nil
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	for _, part := range c.uidSets(set) {
+		if err := c.countCommand(time.Now(), c.c.UidStore(part.imapSeqSet(), item, []interface{}{imap.DeletedFlag}, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
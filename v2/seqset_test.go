@@ -0,0 +1,50 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSeqSet(t *testing.T) {
+	for _, tc := range []struct {
+		In, Want string
+	}{
+		{"", ""},
+		{"1", "1"},
+		{"3,1,2", "1:3"},
+		{"1:5,8,10:*", "1:5,8,10:*"},
+		{"10:*,1:5,6,12", "1:6,10:*"},
+		{"5:1", "1:5"},
+		{"*", "*"},
+		{"1,*", "1,*"},
+	} {
+		s, err := ParseSeqSet(tc.In)
+		if err != nil {
+			t.Fatalf("%q: %+v", tc.In, err)
+		}
+		if got := s.String(); got != tc.Want {
+			t.Errorf("%q: got %q, wanted %q", tc.In, got, tc.Want)
+		}
+	}
+
+	s, _ := ParseSeqSet("1:10,20:*")
+	s.RemoveRange(3, 4)
+	s.RemoveNum(10, 25)
+	if got, want := s.String(), "1:2,5:9,20:24,26:*"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if !s.Contains(7) || s.Contains(3) || !s.Contains(1000) || s.Contains(25) {
+		t.Errorf("Contains: %s", s)
+	}
+	s.RemoveRange(20, 0)
+	if got, want := fmt.Sprint(s.Slice()), "[1 2 5 6 7 8 9]"; got != want || s.Len() != 7 {
+		t.Errorf("got %s (%d), wanted %s", got, s.Len(), want)
+	}
+	if _, err := ParseSeqSet("1:x"); err == nil {
+		t.Error("wanted error for 1:x")
+	}
+}
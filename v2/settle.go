@@ -0,0 +1,233 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// DeliveredKeyword is set together with \Seen (in one STORE) on the delivered messages
+// before moving them to the outbox, so the MOVE carries both.
+// A message left in the inbox with it (after a crash or a failed move) is delivered again in the next round,
+// instead of staying there as Seen.
+const DeliveredKeyword = "$Delivered"
+
+// MaxRedeliveries is the number of times a message left in the inbox with DeliveredKeyword
+// (because its move keeps failing) is delivered again; after that it is left there, with an error logged.
+var MaxRedeliveries = 3
+
+// PostDeliveryRetries is the number of attempts of each post-delivery step (marking Seen, moving),
+// waiting ShortSleep more before each retry.
+var PostDeliveryRetries = 3
+
+// MessageOutcome is the final state of a message processed by the loop.
+type MessageOutcome struct {
+	// Err is the error of deliver (for Retry, Reject and Skip), or of the failed post-delivery step.
+	Err error
+	// Mailbox is where the message has been moved to, "" if it is still in the inbox.
+	Mailbox string
+	UID     uint32
+	Action  Action
+	// Seen reports whether the message has been marked Seen.
+	Seen bool
+}
+
+// WithOutcome calls f with the final state of each message processed by the loop,
+// after the post-delivery steps (marking Seen, moving) have been executed - or have failed.
+//
+// A delivered message with an Err is still in the inbox, and will be delivered again.
+func WithOutcome(f func(context.Context, MessageOutcome)) LoopOption {
+	return func(o *loopOptions) { o.outcome = f }
+}
+
+// loopHooks are called by the loop.
+type loopHooks struct {
+	// round is called at the start of each round.
+	round []roundHook
+	// outcome is called with the final state of each message.
+	outcome func(context.Context, MessageOutcome)
+	// control pauses and drains the loop.
+	control *LoopControl
+	// processing is the mailbox the messages are moved to before delivery, see WithProcessingFolder.
+	processing string
+	// redelivered counts the redeliveries of the journaled messages, see MaxRedeliveries.
+	redelivered map[uint32]int
+}
+
+func (h loopHooks) report(ctx context.Context, out MessageOutcome) {
+	if h.outcome != nil {
+		h.outcome(ctx, out)
+	}
+}
+
+// settle executes the post-delivery steps of the delivered message: marks it Seen
+// (with DeliveredKeyword if it is to be moved), and moves it to box, if not empty.
+func settle(ctx context.Context, c Client, uid uint32, box string, headers [][2]string, logger *slog.Logger) MessageOutcome {
+	out := MessageOutcome{UID: uid, Action: Delivered}
+	journal := box != "" && canJournal(c)
+	if out.Err = retryStep(ctx, logger, "mark seen", func() error {
+		if journal {
+			return SetFlags(ctx, c, uid, true, imap.SeenFlag, DeliveredKeyword)
+		}
+		return c.Mark(ctx, uid, true)
+	}, nil); out.Err != nil {
+		return out
+	}
+	out.Seen = true
+	if box == "" {
+		return out
+	}
+	if out.Err = moveStep(ctx, c, logger, uid, box, func() error {
+		return archive(ctx, c, uid, box, headers)
+	}); out.Err == nil {
+		out.Mailbox = box
+	}
+	return out
+}
+
+// reject moves the rejected message to box, if not empty - marking it Seen before, if seen is true.
+func reject(ctx context.Context, c Client, uid uint32, box string, seen bool, deliverErr error, logger *slog.Logger) MessageOutcome {
+	out := MessageOutcome{UID: uid, Action: Reject, Err: deliverErr}
+	if seen {
+		if err := retryStep(ctx, logger, "mark seen", func() error { return c.Mark(ctx, uid, true) }, nil); err != nil {
+			out.Err = errors.Join(deliverErr, err)
+			return out
+		}
+		out.Seen = true
+	}
+	if box == "" {
+		return out
+	}
+	if err := moveStep(ctx, c, logger, uid, box, func() error { return c.Move(ctx, uid, box) }); err != nil {
+		out.Err = errors.Join(deliverErr, err)
+	} else {
+		out.Mailbox = box
+	}
+	return out
+}
+
+// moveStep moves the message with retries, checking before each retry whether the move has already happened.
+func moveStep(ctx context.Context, c Client, logger *slog.Logger, uid uint32, box string, move func() error) error {
+	return retryStep(ctx, logger.With("mailbox", box), "move", move, func() (bool, error) {
+		return moved(ctx, c, uid)
+	})
+}
+
+// retryStep calls do till it succeeds, at most PostDeliveryRetries times.
+// done is called before each retry, to tell whether the previous, failed attempt has been executed after all.
+func retryStep(ctx context.Context, logger *slog.Logger, name string, do func() error, done func() (bool, error)) error {
+	var err error
+	for i := 0; i < max(1, PostDeliveryRetries); i++ {
+		if i != 0 {
+			if !sleepCtx(ctx, time.Duration(i)*ShortSleep) {
+				return errors.Join(err, ctx.Err())
+			}
+			if done != nil {
+				if ok, doneErr := done(); doneErr != nil {
+					logger.Warn(name+" check", "error", doneErr)
+				} else if ok {
+					return nil
+				}
+			}
+		}
+		if err = do(); err == nil {
+			return nil
+		}
+		logger.Warn(name, "attempt", i+1, "error", err)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// moved reports whether the message has left the selected mailbox - only the response of the move has been lost.
+// A message flagged \Deleted has been copied, only its expunge failed: that is retried here.
+func moved(ctx context.Context, c Client, uid uint32) (bool, error) {
+	m, err := c.FetchArgs(ctx, "FLAGS", uid)
+	if err != nil {
+		return false, err
+	}
+	args, ok := m[uid]
+	if !ok {
+		return true, nil
+	}
+	if slices.Contains(args["FLAGS"], imap.DeletedFlag) {
+		return true, c.Delete(ctx, uid)
+	}
+	return false, nil
+}
+
+// canJournal reports whether the delivered messages can be journaled with DeliveredKeyword:
+// the client sets flags, and the selected mailbox accepts new keywords (its PERMANENTFLAGS has `\*`).
+// The clients without SelectedStatus (such as o365) never journal.
+func canJournal(c Client) bool {
+	if _, ok := As[Flagger](c); !ok {
+		return false
+	}
+	ss, ok := As[SelectedStatuser](c)
+	if !ok {
+		return false
+	}
+	st, ok := ss.SelectedStatus()
+	return ok && slices.Contains(st.PermanentFlags, `\*`)
+}
+
+// keywordLister is implemented by the Clients which can search for a keyword on the server.
+type keywordLister interface {
+	// listKeyword lists the messages of mbox matching pattern with the keyword (the Seen ones, too),
+	// bounded by the SearchWindow.
+	listKeyword(ctx context.Context, mbox, pattern, keyword string) ([]uint32, error)
+}
+
+var _ keywordLister = (*imapClient)(nil)
+
+func (c *imapClient) listKeyword(ctx context.Context, mbox, pattern, keyword string) ([]uint32, error) {
+	crit := listCriteria(pattern, true, c.window)
+	crit.WithFlags = append(crit.WithFlags, keyword)
+	return c.list(ctx, mbox, crit, c.window)
+}
+
+// journaled returns the messages left in the inbox with DeliveredKeyword (but not listed as unseen),
+// to be delivered again.
+//
+// They are searched for on the server (UID SEARCH KEYWORD), as the inbox has been selected by List.
+func journaled(ctx context.Context, c Client, inbox, pattern string, unseen []uint32) ([]uint32, error) {
+	kl, ok := As[keywordLister](c)
+	if !ok || !canJournal(c) {
+		return nil, nil
+	}
+	uids, err := kl.listKeyword(ctx, inbox, pattern, DeliveredKeyword)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(uids, func(uid uint32) bool { return slices.Contains(unseen, uid) }), nil
+}
+
+// capRedeliveries drops the messages from redo which have already been delivered again MaxRedeliveries times.
+func (h loopHooks) capRedeliveries(redo []uint32, logger *slog.Logger) []uint32 {
+	if h.redelivered == nil {
+		return redo
+	}
+	for uid := range h.redelivered {
+		if !slices.Contains(redo, uid) {
+			delete(h.redelivered, uid)
+		}
+	}
+	return slices.DeleteFunc(redo, func(uid uint32) bool {
+		h.redelivered[uid]++
+		if h.redelivered[uid] <= MaxRedeliveries {
+			return false
+		}
+		if h.redelivered[uid] == MaxRedeliveries+1 {
+			logger.Error("the message cannot be moved, giving up redelivering it", "uid", uid, "redeliveries", MaxRedeliveries)
+		}
+		return true
+	})
+}
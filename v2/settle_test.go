@@ -0,0 +1,165 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// settleClient keeps the flags of the messages per mailbox; its moves fail (after executing them) failMoves times.
+type settleClient struct {
+	Client
+	boxes     map[string]map[uint32][]string
+	failMoves int
+	// permanent are the PERMANENTFLAGS of the mailboxes.
+	permanent       []string
+	keywordSearches int
+	// fetched are the UIDs passed to FetchArgs.
+	fetched []uint32
+}
+
+func (c *settleClient) SelectedStatus() (SelectedStatus, bool) {
+	return SelectedStatus{Name: "INBOX", PermanentFlags: c.permanent}, true
+}
+func (c *settleClient) Connect(context.Context) error     { return nil }
+func (c *settleClient) Close(context.Context, bool) error { return nil }
+func (c *settleClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	var uids []uint32
+	for uid, flags := range c.boxes[mbox] {
+		if all || !slices.Contains(flags, `\Seen`) {
+			uids = append(uids, uid)
+		}
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+func (c *settleClient) listKeyword(ctx context.Context, mbox, pattern, keyword string) ([]uint32, error) {
+	c.keywordSearches++
+	var uids []uint32
+	for uid, flags := range c.boxes[mbox] {
+		if slices.Contains(flags, keyword) {
+			uids = append(uids, uid)
+		}
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+func (c *settleClient) ReadTo(ctx context.Context, w io.Writer, uid uint32) (int64, error) {
+	n, err := fmt.Fprintf(w, "Subject: %d\r\n\r\n", uid)
+	return int64(n), err
+}
+func (c *settleClient) FetchArgs(ctx context.Context, what string, uids ...uint32) (map[uint32]map[string][]string, error) {
+	c.fetched = append(c.fetched, uids...)
+	m := make(map[uint32]map[string][]string)
+	for _, uid := range uids {
+		if flags, ok := c.boxes["INBOX"][uid]; ok {
+			m[uid] = map[string][]string{"FLAGS": flags}
+		}
+	}
+	return m, nil
+}
+func (c *settleClient) Mark(ctx context.Context, uid uint32, seen bool) error {
+	return c.SetFlags(ctx, uid, seen, `\Seen`)
+}
+func (c *settleClient) SetFlags(ctx context.Context, uid uint32, add bool, flags ...string) error {
+	c.boxes["INBOX"][uid] = append(c.boxes["INBOX"][uid], flags...)
+	return nil
+}
+func (c *settleClient) Move(ctx context.Context, uid uint32, mbox string) error {
+	if c.boxes[mbox] == nil {
+		c.boxes[mbox] = make(map[uint32][]string)
+	}
+	c.boxes[mbox][uid] = c.boxes["INBOX"][uid]
+	delete(c.boxes["INBOX"], uid)
+	if c.failMoves > 0 {
+		c.failMoves--
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func TestSettle(t *testing.T) {
+	old := ShortSleep
+	ShortSleep = time.Millisecond
+	t.Cleanup(func() { ShortSleep = old })
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	c := &settleClient{failMoves: 1, permanent: []string{`\Seen`, `\*`}, boxes: map[string]map[uint32][]string{"INBOX": {
+		1: nil,
+		// left by a crash between the STORE and the MOVE
+		2: {`\Seen`, DeliveredKeyword},
+		// read by a human
+		3: {`\Seen`},
+	}}}
+	var outcomes []MessageOutcome
+	var delivered []uint32
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		delivered = append(delivered, uid)
+		return nil
+	}
+	n, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Archive", "", logger,
+		WithOutcome(func(ctx context.Context, out MessageOutcome) { outcomes = append(outcomes, out) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !slices.Equal(delivered, []uint32{1, 2}) {
+		t.Errorf("got %d delivered: %v", n, delivered)
+	}
+	if len(c.boxes["INBOX"]) != 1 || len(c.boxes["Archive"]) != 2 ||
+		!slices.Equal(c.boxes["Archive"][1], []string{`\Seen`, DeliveredKeyword}) {
+		t.Errorf("got %v", c.boxes)
+	}
+	// The journaled message is searched for on the server, not by fetching the flags of all.
+	if c.keywordSearches != 1 || slices.Contains(c.fetched, 3) {
+		t.Errorf("got %d keyword searches, fetched %v", c.keywordSearches, c.fetched)
+	}
+	// The failed, but executed move is not retried.
+	if len(outcomes) != 2 {
+		t.Fatalf("got %+v", outcomes)
+	}
+	for _, out := range outcomes {
+		if out.Err != nil || out.Action != Delivered || !out.Seen || out.Mailbox != "Archive" {
+			t.Errorf("got %+v", out)
+		}
+	}
+}
+
+func TestSettleNoKeywords(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	c := &settleClient{permanent: []string{`\Seen`, `\Deleted`}, boxes: map[string]map[uint32][]string{"INBOX": {1: nil}}}
+	deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error { return nil }
+	if _, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Archive", "", logger); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.boxes["Archive"][1]; !slices.Equal(got, []string{`\Seen`}) {
+		t.Errorf("got %v, wanted only \\Seen", got)
+	}
+}
+
+func TestCapRedeliveries(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	h := loopHooks{redelivered: make(map[uint32]int)}
+	for i := 0; i < MaxRedeliveries; i++ {
+		if got := h.capRedeliveries([]uint32{1, 2}, logger); !slices.Equal(got, []uint32{1, 2}) {
+			t.Fatalf("%d. got %v", i, got)
+		}
+	}
+	if got := h.capRedeliveries([]uint32{1, 3}, logger); !slices.Equal(got, []uint32{3}) {
+		t.Errorf("got %v, wanted [3]", got)
+	}
+	if _, ok := h.redelivered[2]; ok {
+		t.Error("the count of the moved message is kept")
+	}
+}
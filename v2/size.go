@@ -0,0 +1,83 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+)
+
+// OversizePolicy is what the DeliveryLoop does with the messages larger than the MaxMessageSize.
+type OversizePolicy uint8
+
+const (
+	// OversizeSkip leaves the message as is, unread.
+	OversizeSkip = OversizePolicy(iota)
+	// OversizeHeaders delivers only the header of the message,
+	// with the TruncatedHeader added, holding the original size.
+	OversizeHeaders
+	// OversizeErrbox moves the message to the errbox (leaves it as is, if there's no errbox), unread.
+	OversizeErrbox
+)
+
+// TruncatedHeader is added to the messages delivered headers-only by OversizeHeaders,
+// with the original size of the message as value.
+const TruncatedHeader = "X-Imapclient-Truncated"
+
+// ErrTooLarge is returned for the messages larger than the MaxMessageSize.
+var ErrTooLarge = errors.New("message too large")
+
+// WithMaxMessageSize limits the size of the messages read by the DeliveryLoop:
+// the larger messages are handled according to the policy.
+// The messages whose size the Client does not report (RFC822.SIZE) are delivered as usual.
+func WithMaxMessageSize(maxSize int64, policy OversizePolicy) LoopOption {
+	return func(o *loopOptions) { o.maxSize, o.oversize = maxSize, policy }
+}
+
+// sizeLimited checks the size of the message before delivering it.
+func (deliver readDeliverer) sizeLimited(maxSize int64, policy OversizePolicy) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		m, err := c.FetchArgs(ctx, string(imap.FetchRFC822Size), uid)
+		if err != nil {
+			return fmt.Errorf("fetch size of %d: %w", uid, err), nil
+		}
+		var size int64
+		if ss := m[uid][string(imap.FetchRFC822Size)]; len(ss) != 0 {
+			size, _ = strconv.ParseInt(ss[0], 10, 64)
+		}
+		if size <= maxSize {
+			return deliver(ctx, c, uid, hsh)
+		}
+		err = fmt.Errorf("%d: size %d > %d: %w", uid, size, maxSize, ErrTooLarge)
+		switch policy {
+		case OversizeHeaders:
+			return deliver(ctx, headersOnly{Client: c, size: size}, uid, hsh)
+		case OversizeErrbox:
+			return nil, err
+		default:
+			return nil, fmt.Errorf("%w: %w", err, ErrSkip)
+		}
+	}
+}
+
+// headersOnly is a Client whose ReadTo returns only the header of the message, with TruncatedHeader.
+type headersOnly struct {
+	Client
+	size int64
+}
+
+func (h headersOnly) ReadTo(ctx context.Context, w io.Writer, msgID uint32) (int64, error) {
+	n, err := fmt.Fprintf(w, "%s: %d\r\n", TruncatedHeader, h.size)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := h.Client.Peek(ctx, w, msgID, "HEADER")
+	return int64(n) + m, err
+}
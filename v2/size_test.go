@@ -0,0 +1,79 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sizeClient is a fakeClient reporting the size of the messages as 1000 for "big", 10 for the others.
+type sizeClient struct {
+	*fakeClient
+}
+
+func (c sizeClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		size := 10
+		if c.mb.subject(uid) == "big" {
+			size = 1000
+		}
+		m[uid] = map[string][]string{"RFC822.SIZE": {strconv.Itoa(size)}}
+	}
+	return m, nil
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for name, tc := range map[string]struct {
+		Policy    OversizePolicy
+		Delivered []string
+		Boxes     map[string][]string
+	}{
+		"skip": {Policy: OversizeSkip, Delivered: []string{"small"},
+			Boxes: map[string][]string{"INBOX": {"big"}, "Done": {"small"}}},
+		"headers": {Policy: OversizeHeaders, Delivered: []string{TruncatedHeader + ": 1000", "small"},
+			Boxes: map[string][]string{"INBOX": {}, "Done": {"big", "small"}}},
+		"errbox": {Policy: OversizeErrbox, Delivered: []string{"small"},
+			Boxes: map[string][]string{"INBOX": {}, "Done": {"small"}, "Err": {"big"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			inbox := newFakeMailbox()
+			for uid, subject := range []string{"big", "small"} {
+				inbox.add(uint32(uid+1), subject)
+			}
+			c := sizeClient{&fakeClient{nextUID: 100, boxes: map[string]*fakeMailbox{"INBOX": inbox}}}
+			var delivered []string
+			deliver := func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+				b, err := io.ReadAll(r)
+				first, _, _ := strings.Cut(string(b), "\r\n")
+				delivered = append(delivered, strings.TrimPrefix(first, "Subject: "))
+				return err
+			}
+			if _, err := DeliverOne(ctx, c, "INBOX", "", deliver, "Done", "Err", logger,
+				WithMaxMessageSize(100, tc.Policy)); err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(delivered)
+			if !slices.Equal(delivered, tc.Delivered) {
+				t.Errorf("delivered %q, wanted %q", delivered, tc.Delivered)
+			}
+			for mbox, want := range tc.Boxes {
+				if got := c.box(mbox).sortedSubjects(); !slices.Equal(got, want) {
+					t.Errorf("%s: got %v, wanted %v", mbox, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	mtextproto "github.com/emersion/go-message/textproto"
+)
+
+// SMTPForwarder re-injects the messages into an SMTP server, like fetchmail.
+type SMTPForwarder struct {
+	// Auth is used if the server supports AUTH.
+	Auth smtp.Auth
+	// TLSConfig is used for STARTTLS and ImplicitTLS. ServerName is set from Addr if empty.
+	TLSConfig *tls.Config
+	// Rewrite the recipients, if not nil - for example to map the addresses to local ones.
+	// Returning no recipients skips the message (ErrSkip).
+	Rewrite func(rcpts []string) []string
+	// Addr of the relay, as host:port.
+	Addr string
+	// Hostname for EHLO, "localhost" if empty.
+	Hostname string
+	// From is the envelope sender. The address of the From header field is used if empty.
+	From string
+	// Mailbox is recorded in the trace headers.
+	Mailbox string
+	// To are the envelope recipients. The addresses of the To, Cc and Bcc header fields are used if empty.
+	// The Bcc header field is removed from the message.
+	To []string
+	// ImplicitTLS connects with TLS (port 465). Otherwise, STARTTLS is used if the server supports it.
+	ImplicitTLS bool
+	// RequireTLS refuses to send on a connection without TLS.
+	RequireTLS bool
+	// TraceHeaders prepends a Received header field and the PublishUIDHeader and PublishHashHeader
+	// header fields to the message.
+	TraceHeaders bool
+}
+
+// Deliver sends the message. It is a DeliverFunc.
+//
+// The temporary (4xx) errors of the server are returned as ErrSkip, so DeliveryLoop leaves
+// the message in the inbox to be retried; permanent errors move it to the errbox.
+func (f *SMTPForwarder) Deliver(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read message: %w", err)
+	}
+	br := bufio.NewReader(bytes.NewReader(data))
+	hdr, err := mtextproto.ReadHeader(br)
+	if err != nil && hdr.Len() == 0 {
+		return fmt.Errorf("read header: %w", err)
+	}
+	headerOK := err == nil
+
+	from := f.From
+	if from == "" {
+		if a, err := mail.ParseAddress(hdr.Get("From")); err == nil {
+			from = a.Address
+		}
+	}
+	rcpts := f.To
+	if len(rcpts) == 0 {
+		for _, k := range []string{"To", "Cc", "Bcc"} {
+			if v := hdr.Get(k); v != "" {
+				aa, _ := mail.ParseAddressList(v)
+				for _, a := range aa {
+					rcpts = append(rcpts, a.Address)
+				}
+			}
+		}
+	}
+	if f.Rewrite != nil {
+		rcpts = f.Rewrite(rcpts)
+	}
+	if len(rcpts) == 0 {
+		return fmt.Errorf("%d: no recipients: %w", uid, ErrSkip)
+	}
+	// The Bcc recipients must not see each other.
+	if hdr.Has("Bcc") {
+		if !headerOK {
+			return fmt.Errorf("%d: cannot remove Bcc from the malformed header", uid)
+		}
+		hdr.Del("Bcc")
+		var buf bytes.Buffer
+		if err := mtextproto.WriteHeader(&buf, hdr); err != nil {
+			return fmt.Errorf("write header: %w", err)
+		}
+		if _, err := br.WriteTo(&buf); err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+		data = buf.Bytes()
+	}
+
+	if f.TraceHeaders {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "Received: from %s by %s with imapclient id %s;\r\n\t%s\r\n",
+			nvl(f.Mailbox, "imap"), nvl(f.Hostname, "localhost"), hsh.String(),
+			time.Now().Format(time.RFC1123Z))
+		fmt.Fprintf(&buf, "%s: %d\r\n%s: %s\r\n", PublishUIDHeader, uid, PublishHashHeader, hsh.String())
+		data = append(buf.Bytes(), data...)
+	}
+
+	if err := f.send(ctx, from, rcpts, data); err != nil {
+		var tpErr *textproto.Error
+		if errors.As(err, &tpErr) && tpErr.Code/100 == 4 {
+			return fmt.Errorf("%d: %w: %w", uid, err, ErrSkip)
+		}
+		return fmt.Errorf("%d: %w", uid, err)
+	}
+	return nil
+}
+
+func (f *SMTPForwarder) send(ctx context.Context, from string, rcpts []string, data []byte) error {
+	host, _, err := net.SplitHostPort(f.Addr)
+	if err != nil {
+		return fmt.Errorf("%q: %w", f.Addr, err)
+	}
+	tlsConfig := f.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	var conn net.Conn
+	if f.ImplicitTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", f.Addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", f.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect to %q: %w", f.Addr, err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err = c.Hello(nvl(f.Hostname, "localhost")); err != nil {
+		return err
+	}
+	if !f.ImplicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS: %w", err)
+			}
+		} else if f.RequireTLS {
+			return fmt.Errorf("%q does not support STARTTLS", f.Addr)
+		}
+	}
+	if f.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err = c.Auth(f.Auth); err != nil {
+				return fmt.Errorf("AUTH: %w", err)
+			}
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM:<%s>: %w", from, err)
+	}
+	for _, rcpt := range rcpts {
+		if err = c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("RCPT TO:<%s>: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer plays an SMTP server (without extensions) on a loopback port,
+// for one connection, answering RCPT TO with rcptResp if not empty.
+type smtpServer struct {
+	l    net.Listener
+	done chan struct{}
+	// rcpts and data are the recipients and the data of the accepted message, after done.
+	rcpts    []string
+	rcptResp string
+	data     string
+}
+
+func newSMTPServer(t *testing.T, rcptResp string) *smtpServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &smtpServer{l: l, rcptResp: rcptResp, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(conn)
+	}()
+	return s
+}
+
+func (s *smtpServer) Addr() string { return s.l.Addr().String() }
+
+func (s *smtpServer) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	write("220 localhost ESMTP")
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			write("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			write("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			if s.rcptResp != "" {
+				write(s.rcptResp)
+				continue
+			}
+			s.rcpts = append(s.rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			write("250 OK")
+		case cmd == "DATA":
+			write("354 go ahead")
+			var buf strings.Builder
+			for {
+				if line, err = br.ReadString('\n'); err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				buf.WriteString(line)
+			}
+			s.data = buf.String()
+			write("250 OK queued")
+		case cmd == "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("250 OK")
+		}
+	}
+}
+
+func TestSMTPForwarder(t *testing.T) {
+	const msg = "From: a@example.com\r\nTo: b@example.com\r\nCc: c@example.com\r\n" +
+		"Bcc: d@example.com\r\nSubject: hello\r\n\r\nbody\r\n"
+	hsh := NewHash()
+	hsh.Write([]byte(msg))
+	h := hsh.Array()
+
+	for name, tc := range map[string]struct {
+		Check     func(*testing.T, *smtpServer)
+		Forwarder SMTPForwarder
+		RcptResp  string
+		Skip      bool
+		Err       bool
+	}{
+		"header": {
+			Check: func(t *testing.T, s *smtpServer) {
+				if want := []string{"b@example.com", "c@example.com", "d@example.com"}; !slices.Equal(s.rcpts, want) {
+					t.Errorf("rcpts: got %v, wanted %v", s.rcpts, want)
+				}
+				if strings.Contains(s.data, "Bcc") || strings.Contains(s.data, "d@example.com") {
+					t.Errorf("Bcc is forwarded: %q", s.data)
+				}
+				if !strings.Contains(s.data, "Subject: hello\r\n\r\nbody\r\n") {
+					t.Errorf("data: got %q", s.data)
+				}
+			},
+		},
+		"rewrite": {
+			Forwarder: SMTPForwarder{To: []string{"x@example.com"}, Rewrite: func(rcpts []string) []string {
+				return append(rcpts, "y@example.com")
+			}},
+			Check: func(t *testing.T, s *smtpServer) {
+				if want := []string{"x@example.com", "y@example.com"}; !slices.Equal(s.rcpts, want) {
+					t.Errorf("rcpts: got %v, wanted %v", s.rcpts, want)
+				}
+			},
+		},
+		"trace": {
+			Forwarder: SMTPForwarder{Mailbox: "INBOX", TraceHeaders: true},
+			Check: func(t *testing.T, s *smtpServer) {
+				if !strings.HasPrefix(s.data, "Received: from INBOX by localhost with imapclient id "+h.String()) {
+					t.Errorf("no Received: %q", s.data)
+				}
+				for _, want := range []string{PublishUIDHeader + ": 7\r\n", PublishHashHeader + ": " + h.String()} {
+					if !strings.Contains(s.data, want) {
+						t.Errorf("no %q in %q", want, s.data)
+					}
+				}
+			},
+		},
+		"temporary":  {RcptResp: "451 try again later", Skip: true},
+		"permanent":  {RcptResp: "550 no such user", Err: true},
+		"requireTLS": {Forwarder: SMTPForwarder{RequireTLS: true}, Err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			s := newSMTPServer(t, tc.RcptResp)
+			f := tc.Forwarder
+			f.Addr = s.Addr()
+			err := f.Deliver(ctx, strings.NewReader(msg), 7, h)
+			<-s.done
+			if tc.Skip || tc.Err {
+				if err == nil || errors.Is(err, ErrSkip) != tc.Skip {
+					t.Fatalf("got %+v, wanted ErrSkip=%t", err, tc.Skip)
+				}
+				if s.data != "" {
+					t.Errorf("sent %q", s.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.Check(t, s)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// SnoozedKeywordPrefix is the prefix of the keyword recording the wake-up time (in Unix seconds)
+// of the snoozed messages on IMAP.
+const SnoozedKeywordPrefix = "$SnoozedUntil-"
+
+// Snoozer is implemented by the Clients which can snooze messages.
+type Snoozer interface {
+	// Snooze moves the message to mbox, recording the wake-up time.
+	Snooze(ctx context.Context, msgID uint32, mbox string, until time.Time) error
+	// Wake moves the messages of mbox due at now back to inbox, and returns their number.
+	Wake(ctx context.Context, mbox, inbox string, now time.Time) (int, error)
+}
+
+var _ Snoozer = (*imapClient)(nil)
+
+// SnoozeUntil returns the Result for a message to be snoozed till the given time:
+// with WithSnooze, it is moved to the snooze mailbox, and brought back to the inbox when due.
+// Without WithSnooze, it is left as is (as with RetryLater).
+func SnoozeUntil(until time.Time) error {
+	return &Result{Action: Snoozed, Until: until}
+}
+
+// WithSnooze moves the snoozed messages (see SnoozeUntil) to the mailbox,
+// and brings the due ones back to the inbox in each round.
+func WithSnooze(mailbox string) LoopOption {
+	return func(o *loopOptions) { o.snoozeBox = mailbox }
+}
+
+// snoozing snoozes the messages whose delivery returned Snoozed.
+func (deliver readDeliverer) snoozing(mbox string) readDeliverer {
+	return func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		readErr, err := deliver(ctx, c, uid, hsh)
+		if readErr != nil {
+			return readErr, err
+		}
+		res := resultOf(err)
+		if res.Action != Snoozed {
+			return nil, err
+		}
+		s, ok := As[Snoozer](c)
+		if !ok {
+			return nil, fmt.Errorf("%T: snooze: %w: %w", c, errors.ErrUnsupported, ErrSkip)
+		}
+		if err = s.Snooze(ctx, uid, mbox, res.Until); err != nil {
+			return nil, RetryLater(fmt.Errorf("snooze %d: %w", uid, err))
+		}
+		return nil, &Result{Action: Skip, Err: fmt.Errorf("snoozed till %s", res.Until.Format(time.RFC3339))}
+	}
+}
+
+// waker returns the roundHook which brings the due messages back from the mbox.
+func waker(mbox string) roundHook {
+	return func(ctx context.Context, c Client, inbox string) error {
+		s, ok := As[Snoozer](c)
+		if !ok {
+			return nil
+		}
+		_, err := s.Wake(ctx, mbox, inbox, time.Now())
+		return err
+	}
+}
+
+// keywordSnoozer is the part of the Client used for snoozing with keywords.
+type keywordSnoozer interface {
+	List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error)
+	FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error)
+	Move(ctx context.Context, msgID uint32, mbox string) error
+	storeUnchangedSince(ctx context.Context, uid uint32, modSeq uint64, keyword string, add bool) (bool, error)
+}
+
+// Snooze sets the SnoozedKeywordPrefix keyword, and moves the message to mbox.
+func (c *imapClient) Snooze(ctx context.Context, msgID uint32, mbox string, until time.Time) error {
+	return snoozeKeyword(ctx, c, msgID, mbox, until)
+}
+
+// Wake moves the messages of mbox whose SnoozedKeywordPrefix keyword is due back to inbox.
+func (c *imapClient) Wake(ctx context.Context, mbox, inbox string, now time.Time) (int, error) {
+	return wakeKeyword(ctx, c, mbox, inbox, now)
+}
+
+func snoozeKeyword(ctx context.Context, c keywordSnoozer, msgID uint32, mbox string, until time.Time) error {
+	kw := SnoozedKeywordPrefix + strconv.FormatInt(until.Unix(), 10)
+	if _, err := c.storeUnchangedSince(ctx, msgID, 0, kw, true); err != nil {
+		return fmt.Errorf("store %s: %w", kw, err)
+	}
+	return c.Move(ctx, msgID, mbox)
+}
+
+func wakeKeyword(ctx context.Context, c keywordSnoozer, mbox, inbox string, now time.Time) (int, error) {
+	uids, err := c.List(ctx, mbox, "", true)
+	if err != nil || len(uids) == 0 {
+		return 0, err
+	}
+	m, err := c.FetchArgs(ctx, string(imap.FetchFlags), uids...)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var errs []error
+	for uid, args := range m {
+		for _, f := range args[string(imap.FetchFlags)] {
+			s, ok := strings.CutPrefix(f, SnoozedKeywordPrefix)
+			if !ok {
+				continue
+			}
+			if until, err := strconv.ParseInt(s, 10, 64); err != nil || until > now.Unix() {
+				break
+			}
+			if _, err := c.storeUnchangedSince(ctx, uid, 0, f, false); err != nil {
+				errs = append(errs, err)
+			} else if err = c.Move(ctx, uid, inbox); err != nil {
+				errs = append(errs, err)
+			} else {
+				n++
+			}
+			break
+		}
+	}
+	return n, errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// snoozeClient is a fakeClient snoozing with keywords, as imapClient does.
+type snoozeClient struct{ *fakeClient }
+
+var _ Snoozer = snoozeClient{}
+
+func (c snoozeClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		m[uid] = map[string][]string{string(imap.FetchFlags): slices.Clone(c.mb.flags[uid])}
+	}
+	return m, nil
+}
+func (c snoozeClient) Snooze(ctx context.Context, msgID uint32, mbox string, until time.Time) error {
+	return snoozeKeyword(ctx, c, msgID, mbox, until)
+}
+func (c snoozeClient) Wake(ctx context.Context, mbox, inbox string, now time.Time) (int, error) {
+	return wakeKeyword(ctx, c, mbox, inbox, now)
+}
+
+func TestSnoozeWake(t *testing.T) {
+	ctx := context.Background()
+	inbox := newFakeMailbox()
+	inbox.add(1, "early")
+	inbox.add(2, "late")
+	c := snoozeClient{&fakeClient{boxes: map[string]*fakeMailbox{"INBOX": inbox}, nextUID: 10}}
+	now := time.Now()
+
+	deliver := readDeliverer(func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		return nil, SnoozeUntil(now.Add(time.Duration(uid) * time.Hour))
+	}).snoozing("Snoozed")
+	if _, err := c.List(ctx, "INBOX", "", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []uint32{1, 2} {
+		if readErr, err := deliver(ctx, c, uid, nil); readErr != nil {
+			t.Fatalf("%d: %+v", uid, readErr)
+		} else if res := resultOf(err); res.Action != Skip {
+			t.Errorf("%d: got %v, wanted Skip", uid, res)
+		}
+	}
+	snoozed := c.box("Snoozed")
+	if got := snoozed.sortedSubjects(); !slices.Equal(got, []string{"early", "late"}) {
+		t.Fatalf("snoozed: got %q", got)
+	}
+	for uid, flags := range snoozed.flags {
+		if !slices.ContainsFunc(flags, func(f string) bool { return strings.HasPrefix(f, SnoozedKeywordPrefix) }) {
+			t.Errorf("%d (%s): no %s keyword in %q", uid, snoozed.subject(uid), SnoozedKeywordPrefix, flags)
+		}
+	}
+
+	// None is due yet.
+	if err := waker("Snoozed")(ctx, c, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	if got := inbox.sortedSubjects(); len(got) != 0 {
+		t.Errorf("woke too early: %q", got)
+	}
+	// Only the first one is due.
+	if n, err := c.Wake(ctx, "Snoozed", "INBOX", now.Add(90*time.Minute)); err != nil || n != 1 {
+		t.Fatalf("woke %d: %+v", n, err)
+	}
+	if got := inbox.sortedSubjects(); !slices.Equal(got, []string{"early"}) {
+		t.Errorf("inbox: got %q", got)
+	}
+	for uid, flags := range inbox.flags {
+		if len(flags) != 0 {
+			t.Errorf("%d: the keyword is left: %q", uid, flags)
+		}
+	}
+	if got := snoozed.sortedSubjects(); !slices.Equal(got, []string{"late"}) {
+		t.Errorf("snoozed: got %q", got)
+	}
+}
+
+func TestSnoozeUnsupported(t *testing.T) {
+	deliver := readDeliverer(func(ctx context.Context, c Client, uid uint32, hsh *Hash) (error, error) {
+		return nil, SnoozeUntil(time.Now().Add(time.Hour))
+	}).snoozing("Snoozed")
+	_, err := deliver(context.Background(), &fakeClient{mb: newFakeMailbox(1)}, 1, nil)
+	if !errors.Is(err, errors.ErrUnsupported) || !errors.Is(err, ErrSkip) {
+		t.Errorf("got %+v, wanted ErrUnsupported and ErrSkip", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/google/renameio/v2"
+)
+
+// KeyData is the data the file name and object key templates are executed with.
+type KeyData struct {
+	// Date of the message, from its Date header field, or the time of the delivery.
+	Date time.Time
+	// Mailbox is the configured mailbox name.
+	Mailbox string
+	// Hash of the message.
+	Hash string
+	// UID of the message.
+	UID uint32
+}
+
+func newKeyData(r io.Reader, mailbox string, uid uint32, hsh HashArray) KeyData {
+	kd := KeyData{Mailbox: mailbox, Hash: hsh.String(), UID: uid, Date: time.Now()}
+	if hdr, err := textproto.ReadHeader(bufio.NewReader(r)); err == nil {
+		if d, err := mail.ParseDate(hdr.Get("Date")); err == nil {
+			kd.Date = d
+		}
+	}
+	return kd
+}
+
+// DefaultSpoolNameTemplate is the default Spool.NameTemplate.
+const DefaultSpoolNameTemplate = `{{.Date.Format "20060102T150405"}}.{{.UID}}.{{.Hash}}.eml`
+
+// Spool writes the messages into a directory, like a Maildir: the message is written
+// into Dir/tmp, and renamed into Dir/new when complete, so the readers of Dir/new
+// see complete messages only.
+type Spool struct {
+	// Dir is the root of the spool, with "tmp" and "new" subdirectories.
+	Dir string
+	// NameTemplate is the text/template of the file name, executed with KeyData.
+	// It may contain slashes, for subdirectories.
+	// DefaultSpoolNameTemplate is used if empty.
+	NameTemplate string
+	// Mailbox is passed to the NameTemplate.
+	Mailbox string
+}
+
+// DeliverFunc returns the DeliverFunc writing the messages into the spool,
+// creating the directories if needed.
+func (s *Spool) DeliverFunc() (DeliverFunc, error) {
+	tpl, err := template.New("name").Parse(nvl(s.NameTemplate, DefaultSpoolNameTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("parse %q: %w", s.NameTemplate, err)
+	}
+	tmpDir, newDir := filepath.Join(s.Dir, "tmp"), filepath.Join(s.Dir, "new")
+	for _, dir := range []string{tmpDir, newDir} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx context.Context, r io.ReadSeeker, uid uint32, hsh HashArray) error {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		kd := newKeyData(r, s.Mailbox, uid, hsh)
+		var buf strings.Builder
+		if err := tpl.Execute(&buf, kd); err != nil {
+			return fmt.Errorf("name template: %w", err)
+		}
+		fn := filepath.Join(newDir, filepath.Clean("/"+buf.String()))
+		if err := os.MkdirAll(filepath.Dir(fn), 0750); err != nil {
+			return err
+		}
+		fh, err := renameio.TempFile(tmpDir, fn)
+		if err != nil {
+			return err
+		}
+		defer fh.Cleanup()
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(fh, r); err != nil {
+			return fmt.Errorf("write %q: %w", fh.Name(), err)
+		}
+		if err := fh.CloseAtomicallyReplace(); err != nil {
+			return fmt.Errorf("rename to %q: %w", fn, err)
+		}
+		return nil
+	}, nil
+}
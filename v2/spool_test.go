@@ -0,0 +1,46 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	const msg = "Date: Tue, 02 Jan 2024 15:04:05 +0000\r\nSubject: hello\r\n\r\nbody\r\n"
+	hsh := NewHash()
+	hsh.Write([]byte(msg))
+	h := hsh.Array()
+
+	for tpl, want := range map[string]string{
+		"": "20240102T150405.7." + h.String() + ".eml",
+		`{{.Mailbox}}/{{.Date.Year}}/{{.UID}}.eml`: "INBOX/2024/7.eml",
+		`../../{{.UID}}.eml`:                       "7.eml",
+	} {
+		s := Spool{Dir: dir, NameTemplate: tpl, Mailbox: "INBOX"}
+		deliver, err := s.DeliverFunc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = deliver(ctx, strings.NewReader(msg), 7, h); err != nil {
+			t.Fatalf("%q: %+v", tpl, err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "new", want))
+		if err != nil {
+			t.Errorf("%q: %+v", tpl, err)
+		} else if string(b) != msg {
+			t.Errorf("%q: got %q", tpl, b)
+		}
+	}
+	if des, err := os.ReadDir(filepath.Join(dir, "tmp")); err != nil || len(des) != 0 {
+		t.Errorf("tmp: got %v, %+v", des, err)
+	}
+}
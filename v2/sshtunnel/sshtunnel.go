@@ -0,0 +1,224 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sshtunnel runs the IMAP sessions through an SSH bastion host,
+// using direct-tcpip channels.
+//
+//	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+//	...
+//	tun := sshtunnel.New(sshtunnel.Config{
+//		Addr: "bastion.example.com", User: "imap", UseAgent: true,
+//		HostKeyCallback: hostKeys,
+//	}, logger)
+//	defer tun.Close()
+//	c := imapclient.FromDialer(sa, tun.DialContext)
+package sshtunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// Config of the SSH connection to the bastion host.
+type Config struct {
+	// HostKeyCallback verifies the host key of the bastion - see knownhosts.New. Required.
+	HostKeyCallback ssh.HostKeyCallback
+	// Addr of the bastion host, the port defaults to 22.
+	Addr string
+	// User to log in as.
+	User string
+	// Signers are the private keys to authenticate with - see SignersFromFiles.
+	Signers []ssh.Signer
+	// UseAgent authenticates with the keys of the ssh-agent at $SSH_AUTH_SOCK, too.
+	UseAgent bool
+	// Timeout of establishing the SSH connection, defaults to 30s.
+	Timeout time.Duration
+}
+
+// Tunnel is an SSH connection to the bastion host, which is (re)established
+// when needed by DialContext - so the Client's reconnect logic works through it.
+type Tunnel struct {
+	logger *slog.Logger
+	client *ssh.Client
+	// done is closed when client is disconnected.
+	done  chan struct{}
+	agent net.Conn
+	cfg   Config
+	mu    sync.Mutex
+}
+
+// New returns a new Tunnel, connecting lazily.
+func New(cfg Config, logger *slog.Logger) *Tunnel {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		cfg.Addr = net.JoinHostPort(cfg.Addr, "22")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &Tunnel{cfg: cfg, logger: logger.With("bastion", cfg.Addr)}
+}
+
+// NewClient returns a new (not connected) Client, which connects to the server through the Tunnel.
+func (t *Tunnel) NewClient(sa imapclient.ServerAddress) imapclient.Client {
+	return imapclient.FromDialer(sa, t.DialContext)
+}
+
+// DialContext opens a direct-tcpip channel to addr through the bastion,
+// connecting (or reconnecting) to the bastion if needed.
+//
+// It is an imapclient.DialFunc.
+func (t *Tunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	cl, err := t.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := cl.DialContext(ctx, network, addr)
+	if err == nil {
+		return conn, nil
+	}
+	// The bastion refused to open the channel (e.g. the target port is closed):
+	// the SSH connection itself is alive, so keep it.
+	var oce *ssh.OpenChannelError
+	if errors.As(err, &oce) || ctx.Err() != nil {
+		return nil, fmt.Errorf("dial %s through %s: %w", addr, t.cfg.Addr, err)
+	}
+	// The connection may have been broken without us noticing, retry with a new one.
+	t.logger.Warn("dial through bastion", "addr", addr, "error", err)
+	t.reset(cl)
+	if cl, err = t.sshClient(ctx); err != nil {
+		return nil, err
+	}
+	if conn, err = cl.DialContext(ctx, network, addr); err != nil {
+		return nil, fmt.Errorf("dial %s through %s: %w", addr, t.cfg.Addr, err)
+	}
+	return conn, nil
+}
+
+// Close closes the SSH connection.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	if t.client != nil {
+		err = t.client.Close()
+		t.client = nil
+	}
+	if t.agent != nil {
+		t.agent.Close()
+		t.agent = nil
+	}
+	return err
+}
+
+func (t *Tunnel) sshClient(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		select {
+		case <-t.done:
+			t.logger.Info("bastion connection lost, reconnecting")
+			t.client.Close()
+			t.client = nil
+		default:
+			return t.client, nil
+		}
+	}
+	if t.cfg.HostKeyCallback == nil {
+		return nil, errors.New("HostKeyCallback is required")
+	}
+	methods := make([]ssh.AuthMethod, 0, 2)
+	if len(t.cfg.Signers) != 0 {
+		methods = append(methods, ssh.PublicKeys(t.cfg.Signers...))
+	}
+	if t.cfg.UseAgent {
+		if t.agent == nil {
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				return nil, errors.New("SSH_AUTH_SOCK is not set")
+			}
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "unix", sock)
+			if err != nil {
+				return nil, fmt.Errorf("connect to ssh-agent: %w", err)
+			}
+			t.agent = conn
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(t.agent).Signers))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no authentication method: no Signers and no UseAgent")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.cfg.Timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", t.cfg.Addr, err)
+	}
+	// ssh.NewClientConn does not take a context: the handshake is bounded by the deadline.
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.cfg.Addr, &ssh.ClientConfig{
+		User: t.cfg.User, Auth: methods,
+		HostKeyCallback: t.cfg.HostKeyCallback,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh %s: %w", t.cfg.Addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	t.client = ssh.NewClient(c, chans, reqs)
+	done := make(chan struct{})
+	t.done = done
+	go func(cl *ssh.Client) {
+		err := cl.Wait()
+		t.logger.Info("bastion connection closed", "error", err)
+		close(done)
+	}(t.client)
+	t.logger.Info("connected to bastion")
+	return t.client, nil
+}
+
+// reset drops cl, if that is the current SSH client.
+func (t *Tunnel) reset(cl *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == cl {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+// SignersFromFiles reads the (unencrypted) private keys from the files.
+func SignersFromFiles(paths ...string) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(paths))
+	for _, fn := range paths {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return signers, err
+		}
+		s, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return signers, fmt.Errorf("%s: %w", fn, err)
+		}
+		signers = append(signers, s)
+	}
+	return signers, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sshtunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// bastion is an SSH server accepting the key of the client, and opening the direct-tcpip channels.
+type bastion struct {
+	l     net.Listener
+	cfg   *ssh.ServerConfig
+	conns []*ssh.ServerConn
+	// accepted is the number of the SSH connections.
+	accepted atomic.Int32
+	mu       sync.Mutex
+}
+
+func newBastion(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) *bastion {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := &bastion{l: l, cfg: &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}}
+	b.cfg.AddHostKey(hostKey)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *bastion) serve(conn net.Conn) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, b.cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	b.accepted.Add(1)
+	b.mu.Lock()
+	b.conns = append(b.conns, sc)
+	b.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &target); err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		tc, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			tc.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() { io.Copy(ch, tc); ch.Close() }()
+		go func() { io.Copy(tc, ch); tc.Close() }()
+	}
+}
+
+// drop closes the SSH connections.
+func (b *bastion) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sc := range b.conns {
+		sc.Close()
+	}
+	b.conns = nil
+}
+
+// echoServer returns the address of a server echoing what it reads.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { defer conn.Close(); io.Copy(conn, conn) }()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTunnel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	hostKey := newSigner(t)
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client key is read from a file, as SignersFromFiles is the usual way.
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "id_ed25519")
+	if err = os.WriteFile(fn, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signers, err := SignersFromFiles(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBastion(t, hostKey, signers[0].PublicKey())
+	target := echoServer(t)
+
+	tun := New(Config{
+		Addr: b.l.Addr().String(), User: "imap", Signers: signers,
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	}, logger)
+	defer tun.Close()
+	echo := func() error {
+		conn, err := tun.DialContext(ctx, "tcp", target)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err = conn.Write([]byte("hello\n")); err != nil {
+			return err
+		}
+		got := make([]byte, 6)
+		if _, err = io.ReadFull(conn, got); err != nil {
+			return err
+		}
+		if string(got) != "hello\n" {
+			t.Errorf("echo: got %q", got)
+		}
+		return nil
+	}
+
+	if err = echo(); err != nil {
+		t.Fatal(err)
+	}
+	// A refused channel keeps the SSH connection.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	var oce *ssh.OpenChannelError
+	if _, err = tun.DialContext(ctx, "tcp", closed.Addr().String()); !errors.As(err, &oce) {
+		t.Errorf("closed port: got %+v, wanted OpenChannelError", err)
+	}
+	if err = echo(); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.accepted.Load(); n != 1 {
+		t.Errorf("got %d SSH connections, wanted 1", n)
+	}
+
+	// A lost SSH connection is reestablished.
+	b.drop()
+	if err = echo(); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.accepted.Load(); n != 2 {
+		t.Errorf("got %d SSH connections, wanted 2", n)
+	}
+
+	// An unknown host key is refused.
+	bad := New(Config{
+		Addr: b.l.Addr().String(), User: "imap", Signers: signers,
+		HostKeyCallback: ssh.FixedHostKey(newSigner(t).PublicKey()),
+	}, logger)
+	defer bad.Close()
+	if _, err = bad.DialContext(ctx, "tcp", target); err == nil {
+		t.Error("connected with an unknown host key")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"time"
+)
+
+// MessageRecord is the stored metadata of a message, as seen by the last sync.
+type MessageRecord struct {
+	Date time.Time
+	// Mailbox is the mailbox name, prefixed by the account if more accounts share the store.
+	Mailbox   string
+	MessageID string
+	// Hash is the content hash of the message (HashArray.String), if known.
+	Hash  string
+	Flags []string
+	Size  int64
+	// UIDValidity of the mailbox, when the UID was valid.
+	UIDValidity uint32
+	UID         uint32
+}
+
+// MessageStore stores the metadata of the synced messages,
+// so a sync needs to fetch only the changes.
+type MessageStore interface {
+	// PutMessages inserts or replaces the records, keyed by their Mailbox and UID.
+	PutMessages(ctx context.Context, records ...MessageRecord) error
+	// Messages returns the records of the mailbox, in UID order.
+	Messages(ctx context.Context, mailbox string) ([]MessageRecord, error)
+	// DeleteMessages deletes the records of the UIDs, or all of the mailbox if no UID is given.
+	DeleteMessages(ctx context.Context, mailbox string, uids ...uint32) error
+}
+
+// MessageReplacer is implemented by the MessageStores which can replace all the records
+// of a mailbox atomically.
+type MessageReplacer interface {
+	// ReplaceMessages deletes the records of the mailbox and inserts the given ones, in one transaction.
+	ReplaceMessages(ctx context.Context, mailbox string, records ...MessageRecord) error
+}
+
+// DedupStore remembers the keys (Message-IDs or hashes) of the already processed messages.
+type DedupStore interface {
+	// Seen records the key in scope, and reports whether it has been recorded before.
+	Seen(ctx context.Context, scope, key string) (bool, error)
+	// Has reports whether the key has been recorded in scope, without recording it.
+	Has(ctx context.Context, scope, key string) (bool, error)
+}
+
+// Checkpointer stores the position of the long-running jobs, so they can resume after a restart.
+type Checkpointer interface {
+	// Checkpoint returns the stored checkpoint of name, "" if there is none.
+	Checkpoint(ctx context.Context, name string) (string, error)
+	// SetCheckpoint stores the checkpoint of name, deleting it if value is "".
+	SetCheckpoint(ctx context.Context, name, value string) error
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stats are the counters of a Client, for exposing them without a metrics backend.
+type Stats struct {
+	// Since is the start of the counting: the first use of the Client, or the last ResetStats.
+	Since time.Time
+	// Errors counts the failed commands by the kind of the error, see ErrorKind.
+	Errors map[string]uint64
+	// Commands is the number of the commands (requests) issued.
+	Commands uint64
+	// CommandDuration is the total time spent in the commands, MaxCommandDuration is the longest one.
+	CommandDuration, MaxCommandDuration time.Duration
+	// BytesFetched is the size of the fetched message bodies, BytesUploaded of the appended messages.
+	BytesFetched, BytesUploaded int64
+	// Delivered is the number of messages delivered by DeliveryLoop.
+	Delivered uint64
+	// Reconnects is the number of Connects after the first one.
+	Reconnects uint64
+}
+
+// StatsReporter is implemented by the Clients which collect Stats.
+type StatsReporter interface {
+	Stats() Stats
+	ResetStats()
+}
+
+// StatsCounter collects the Stats - embed it to implement StatsReporter.
+//
+// The zero value is ready to use, and it is safe for concurrent use.
+type StatsCounter struct {
+	stats    Stats
+	connects uint64
+	mu       sync.Mutex
+}
+
+var _ StatsReporter = (*imapClient)(nil)
+
+// Stats returns a copy of the current counters.
+func (sc *StatsCounter) Stats() Stats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	s := sc.stats
+	s.Errors = maps.Clone(s.Errors)
+	return s
+}
+
+// ResetStats zeroes the counters.
+func (sc *StatsCounter) ResetStats() {
+	sc.mu.Lock()
+	sc.stats = Stats{Since: time.Now()}
+	sc.mu.Unlock()
+}
+
+// CountCommand counts a command started at start, which returned err.
+func (sc *StatsCounter) CountCommand(start time.Time, err error) {
+	dur := time.Since(start)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.init()
+	sc.stats.Commands++
+	sc.stats.CommandDuration += dur
+	if dur > sc.stats.MaxCommandDuration {
+		sc.stats.MaxCommandDuration = dur
+	}
+	if err != nil {
+		if sc.stats.Errors == nil {
+			sc.stats.Errors = make(map[string]uint64)
+		}
+		sc.stats.Errors[ErrorKind(err)]++
+	}
+}
+
+// CountFetched counts n fetched bytes.
+func (sc *StatsCounter) CountFetched(n int64) {
+	sc.mu.Lock()
+	sc.init()
+	sc.stats.BytesFetched += n
+	sc.mu.Unlock()
+}
+
+// CountUploaded counts n uploaded bytes.
+func (sc *StatsCounter) CountUploaded(n int64) {
+	sc.mu.Lock()
+	sc.init()
+	sc.stats.BytesUploaded += n
+	sc.mu.Unlock()
+}
+
+// CountDelivered counts a delivered message.
+func (sc *StatsCounter) CountDelivered() {
+	sc.mu.Lock()
+	sc.init()
+	sc.stats.Delivered++
+	sc.mu.Unlock()
+}
+
+// CountConnect counts a Connect, the ones after the first as Reconnects.
+func (sc *StatsCounter) CountConnect() {
+	sc.mu.Lock()
+	sc.init()
+	if sc.connects++; sc.connects > 1 {
+		sc.stats.Reconnects++
+	}
+	sc.mu.Unlock()
+}
+
+func (sc *StatsCounter) init() {
+	if sc.stats.Since.IsZero() {
+		sc.stats.Since = time.Now()
+	}
+}
+
+// ErrorKind returns the kind of the error for Stats.Errors:
+// "timeout", "canceled", "network", "skip" or "other".
+func ErrorKind(err error) string {
+	var ne net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrSkip):
+		return "skip"
+	case errors.As(err, &ne):
+		if ne.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
+
+// countCommand counts the command started at start, and returns its err.
+func (c *imapClient) countCommand(start time.Time, err error) error {
+	c.CountCommand(start, err)
+	return err
+}
+
+// deliveryCounter is implemented by the Clients embedding StatsCounter.
+type deliveryCounter interface {
+	CountDelivered()
+}
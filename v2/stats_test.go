@@ -0,0 +1,91 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStatsCounter(t *testing.T) {
+	var sc StatsCounter
+	start := time.Now().Add(-time.Second)
+	sc.CountConnect()
+	sc.CountConnect()
+	sc.CountCommand(start, nil)
+	sc.CountCommand(start, fmt.Errorf("select: %w", context.DeadlineExceeded))
+	sc.CountCommand(start, &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded})
+	sc.CountCommand(start, &net.OpError{Op: "read", Err: errors.New("connection reset")})
+	sc.CountCommand(start, errors.New("NO"))
+	sc.CountFetched(10)
+	sc.CountUploaded(20)
+	sc.CountDelivered()
+
+	s := sc.Stats()
+	if s.Since.IsZero() || s.Commands != 5 || s.Reconnects != 1 || s.Delivered != 1 ||
+		s.BytesFetched != 10 || s.BytesUploaded != 20 {
+		t.Errorf("got %+v", s)
+	}
+	if s.CommandDuration < 5*time.Second || s.MaxCommandDuration < time.Second {
+		t.Errorf("durations: got %s, max %s", s.CommandDuration, s.MaxCommandDuration)
+	}
+	want := map[string]uint64{"timeout": 2, "network": 1, "other": 1}
+	if fmt.Sprint(s.Errors) != fmt.Sprint(want) {
+		t.Errorf("errors: got %v, wanted %v", s.Errors, want)
+	}
+	s.Errors["other"] = 100
+	if sc.Stats().Errors["other"] != 1 {
+		t.Error("Stats returned the counters' map")
+	}
+
+	sc.ResetStats()
+	if s := sc.Stats(); s.Commands != 0 || len(s.Errors) != 0 || s.Since.IsZero() {
+		t.Errorf("after reset: got %+v", s)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cConn, sConn := net.Pipe()
+	scriptServer(sConn, nil, "* OK [CAPABILITY IMAP4rev1] ready\r\n", map[string]string{
+		"SELECT":     "* 1 EXISTS\r\n* OK [UIDVALIDITY 1] UIDs valid\r\nTAG OK [READ-WRITE] done\r\n",
+		"UID SEARCH": "* SEARCH 1\r\nTAG OK done\r\n",
+		"UID FETCH":  "* 1 FETCH (UID 1 BODY[] {7}\r\nhello\r\n)\r\nTAG OK done\r\n",
+		"UID STORE":  "TAG NO read only\r\n",
+	})
+	c := NewClientConn(cConn, "username", "password")
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx, false)
+	sr, ok := c.(StatsReporter)
+	if !ok {
+		t.Fatalf("%T is not a StatsReporter", c)
+	}
+	sr.ResetStats()
+
+	uids, err := c.List(ctx, "INBOX", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ReadTo(ctx, io.Discard, uids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Mark(ctx, uids[0], true); err == nil {
+		t.Error("Mark succeeded")
+	}
+	s := sr.Stats()
+	if s.Commands < 3 || s.BytesFetched != 7 || s.Errors["other"] != 1 {
+		t.Errorf("got %+v", s)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package store is an embeddable SQLite database for the persistent state
+// of the sync, dedup and checkpointing features, so they share one file
+// instead of ad-hoc ones:
+//
+//	db, err := store.Open(ctx, "state.db")
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//	if seen, err := db.Seen(ctx, "sync", mi.MessageID); err != nil || seen {
+//		return err
+//	}
+//
+// The schema is upgraded by Open, as recorded in the user_version pragma.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+var (
+	_ imapclient.MessageStore    = (*DB)(nil)
+	_ imapclient.MessageReplacer = (*DB)(nil)
+	_ imapclient.DedupStore      = (*DB)(nil)
+	_ imapclient.Checkpointer    = (*DB)(nil)
+)
+
+// migrations are the schema changes, in order; the user_version pragma
+// is the number of the applied ones. Only append to it!
+var migrations = []string{
+	`CREATE TABLE messages (
+  mailbox TEXT NOT NULL,
+  uid INTEGER NOT NULL,
+  uidvalidity INTEGER NOT NULL DEFAULT 0,
+  message_id TEXT NOT NULL DEFAULT '',
+  hash TEXT NOT NULL DEFAULT '',
+  flags TEXT NOT NULL DEFAULT '',
+  size INTEGER NOT NULL DEFAULT 0,
+  date INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (mailbox, uid)
+) WITHOUT ROWID;
+CREATE INDEX messages_message_id ON messages (message_id);
+CREATE TABLE seen (
+  scope TEXT NOT NULL,
+  key TEXT NOT NULL,
+  created INTEGER NOT NULL,
+  PRIMARY KEY (scope, key)
+) WITHOUT ROWID;
+CREATE TABLE checkpoints (
+  name TEXT PRIMARY KEY,
+  value TEXT NOT NULL,
+  updated INTEGER NOT NULL
+) WITHOUT ROWID;`,
+}
+
+// DB is the SQLite database, safe for concurrent use.
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens (creating if needed) the database at path, and upgrades its schema.
+func Open(ctx context.Context, path string) (*DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("open %q: %w", path, err)
+	}
+	// SQLite has one writer, so more connections only wait for each other.
+	db.SetMaxOpenConns(1)
+	s := &DB{db: db}
+	if err = s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate %q: %w", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *DB) Close() error { return s.db.Close() }
+
+// DB returns the underlying *sql.DB, for the tables of the caller.
+func (s *DB) DB() *sql.DB { return s.db }
+
+// Version returns the schema version of the database.
+func (s *DB) Version(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// ErrNewerSchema is returned by Open for a database created by a newer version of this package.
+var ErrNewerSchema = errors.New("database schema is newer than known")
+
+func (s *DB) migrate(ctx context.Context) error {
+	version, err := s.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("version %d > %d: %w", version, len(migrations), ErrNewerSchema)
+	}
+	for i := version; i < len(migrations); i++ {
+		if err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1))
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *DB) inTx(ctx context.Context, f func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// PutMessages inserts or replaces the records, in one transaction.
+func (s *DB) PutMessages(ctx context.Context, records ...MessageRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error { return putMessages(ctx, tx, records) })
+}
+
+// ReplaceMessages deletes the records of the mailbox and inserts the given ones, in one transaction.
+func (s *DB) ReplaceMessages(ctx context.Context, mailbox string, records ...MessageRecord) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE mailbox = ?", mailbox); err != nil {
+			return err
+		}
+		return putMessages(ctx, tx, records)
+	})
+}
+
+func putMessages(ctx context.Context, tx *sql.Tx, records []MessageRecord) error {
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR REPLACE INTO messages
+  (mailbox, uid, uidvalidity, message_id, hash, flags, size, date)
+  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		var date int64
+		if !r.Date.IsZero() {
+			date = r.Date.Unix()
+		}
+		if _, err := stmt.ExecContext(ctx,
+			r.Mailbox, r.UID, r.UIDValidity, r.MessageID, r.Hash,
+			strings.Join(r.Flags, " "), r.Size, date,
+		); err != nil {
+			return fmt.Errorf("%s/%d: %w", r.Mailbox, r.UID, err)
+		}
+	}
+	return nil
+}
+
+// MessageRecord is an alias of imapclient.MessageRecord.
+type MessageRecord = imapclient.MessageRecord
+
+// Messages returns the records of the mailbox, in UID order.
+func (s *DB) Messages(ctx context.Context, mailbox string) ([]MessageRecord, error) {
+	return s.messages(ctx, "mailbox = ?", mailbox)
+}
+
+// MessagesByID returns the records with the given Message-ID, in any mailbox.
+func (s *DB) MessagesByID(ctx context.Context, messageID string) ([]MessageRecord, error) {
+	return s.messages(ctx, "message_id = ?", messageID)
+}
+
+func (s *DB) messages(ctx context.Context, where string, args ...any) ([]MessageRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT mailbox, uid, uidvalidity, message_id, hash, flags, size, date
+  FROM messages WHERE `+where+` ORDER BY mailbox, uid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []MessageRecord
+	for rows.Next() {
+		var r MessageRecord
+		var flags string
+		var date int64
+		if err := rows.Scan(&r.Mailbox, &r.UID, &r.UIDValidity, &r.MessageID, &r.Hash, &flags, &r.Size, &date); err != nil {
+			return records, err
+		}
+		r.Flags = strings.Fields(flags)
+		if date != 0 {
+			r.Date = time.Unix(date, 0)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// DeleteMessages deletes the records of the UIDs, or all of the mailbox if no UID is given.
+func (s *DB) DeleteMessages(ctx context.Context, mailbox string, uids ...uint32) error {
+	if len(uids) == 0 {
+		_, err := s.db.ExecContext(ctx, "DELETE FROM messages WHERE mailbox = ?", mailbox)
+		return err
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, "DELETE FROM messages WHERE mailbox = ? AND uid = ?")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, uid := range uids {
+			if _, err := stmt.ExecContext(ctx, mailbox, uid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Seen records the key in scope, and reports whether it has been recorded before.
+func (s *DB) Seen(ctx context.Context, scope, key string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO seen (scope, key, created) VALUES (?, ?, ?)",
+		scope, key, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 0, err
+}
+
+// Has reports whether the key has been recorded in scope, without recording it.
+func (s *DB) Has(ctx context.Context, scope, key string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(0) FROM seen WHERE scope = ? AND key = ?", scope, key).Scan(&n)
+	return n != 0, err
+}
+
+// Forget deletes the keys of scope recorded before the given time, all of them if before is zero.
+func (s *DB) Forget(ctx context.Context, scope string, before time.Time) (int64, error) {
+	qry, args := "DELETE FROM seen WHERE scope = ?", []any{scope}
+	if !before.IsZero() {
+		qry, args = qry+" AND created < ?", append(args, before.Unix())
+	}
+	res, err := s.db.ExecContext(ctx, qry, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Checkpoint returns the stored checkpoint of name, "" if there is none.
+func (s *DB) Checkpoint(ctx context.Context, name string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM checkpoints WHERE name = ?", name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetCheckpoint stores the checkpoint of name, deleting it if value is "".
+func (s *DB) SetCheckpoint(ctx context.Context, name, value string) error {
+	var err error
+	if value == "" {
+		_, err = s.db.ExecContext(ctx, "DELETE FROM checkpoints WHERE name = ?", name)
+	} else {
+		_, err = s.db.ExecContext(ctx,
+			"INSERT OR REPLACE INTO checkpoints (name, value, updated) VALUES (?, ?, ?)",
+			name, value, time.Now().Unix())
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Version(ctx); err != nil || v != len(migrations) {
+		t.Fatalf("version: got %d, %+v", v, err)
+	}
+
+	date := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := db.PutMessages(ctx,
+		MessageRecord{Mailbox: "INBOX", UID: 2, UIDValidity: 9, MessageID: "<b@x>", Flags: []string{`\Seen`, `\Flagged`}, Size: 123, Date: date},
+		MessageRecord{Mailbox: "INBOX", UID: 1, UIDValidity: 9, MessageID: "<a@x>"},
+		MessageRecord{Mailbox: "Archive", UID: 1, MessageID: "<b@x>"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	records, err := db.Messages(ctx, "INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].UID != 1 || records[1].Size != 123 ||
+		!slices.Equal(records[1].Flags, []string{`\Seen`, `\Flagged`}) || !records[1].Date.Equal(date) {
+		t.Errorf("got %+v", records)
+	}
+	if records, err = db.MessagesByID(ctx, "<b@x>"); err != nil || len(records) != 2 {
+		t.Errorf("by id: got %+v, %+v", records, err)
+	}
+	if err = db.DeleteMessages(ctx, "INBOX", 1); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ = db.Messages(ctx, "INBOX"); len(records) != 1 || records[0].UID != 2 {
+		t.Errorf("after delete: got %+v", records)
+	}
+	if err = db.ReplaceMessages(ctx, "INBOX", MessageRecord{Mailbox: "INBOX", UID: 3, MessageID: "<c@x>"}); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ = db.Messages(ctx, "INBOX"); len(records) != 1 || records[0].UID != 3 {
+		t.Errorf("after replace: got %+v", records)
+	}
+	if records, _ = db.Messages(ctx, "Archive"); len(records) != 1 {
+		t.Errorf("replace changed the other mailbox: %+v", records)
+	}
+
+	if has, err := db.Has(ctx, "sync", "<a@x>"); err != nil || has {
+		t.Errorf("has before seen: got %t, %+v", has, err)
+	}
+	for i, want := range []bool{false, true} {
+		if seen, err := db.Seen(ctx, "sync", "<a@x>"); err != nil || seen != want {
+			t.Errorf("%d. seen: got %t, %+v", i, seen, err)
+		}
+	}
+	if has, err := db.Has(ctx, "sync", "<a@x>"); err != nil || !has {
+		t.Errorf("has after seen: got %t, %+v", has, err)
+	}
+	if seen, _ := db.Seen(ctx, "other", "<a@x>"); seen {
+		t.Error("scopes are not separated")
+	}
+	if n, err := db.Forget(ctx, "sync", time.Time{}); err != nil || n != 1 {
+		t.Errorf("forget: got %d, %+v", n, err)
+	}
+
+	if err = db.SetCheckpoint(ctx, "migrate/INBOX", "42"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Reopening does not migrate again.
+	if db, err = Open(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Checkpoint(ctx, "migrate/INBOX"); err != nil || v != "42" {
+		t.Errorf("checkpoint: got %q, %+v", v, err)
+	}
+	if err = db.SetCheckpoint(ctx, "migrate/INBOX", ""); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Checkpoint(ctx, "migrate/INBOX"); err != nil || v != "" {
+		t.Errorf("deleted checkpoint: got %q, %+v", v, err)
+	}
+
+	if _, err = db.DB().ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err = Open(ctx, path); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("newer schema: got %+v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SweepRule selects the messages of a mailbox to be deleted or archived.
+//
+// All the set conditions must match, and at least one must be set - see Validate.
+type SweepRule struct {
+	// Mailbox to sweep.
+	Mailbox string
+	// ArchiveTo is the mailbox the matching messages are moved to.
+	// If empty, the messages are deleted.
+	ArchiveTo string
+	// Flags the message must have, such as \Seen.
+	Flags []string
+	// WithoutFlags the message must not have.
+	WithoutFlags []string
+	// OlderThan matches the messages with internal date older than this.
+	OlderThan time.Duration
+	// LargerThan matches the messages bigger than this many bytes.
+	LargerThan uint32
+}
+
+func (r SweepRule) String() string {
+	var buf strings.Builder
+	buf.WriteString(r.Mailbox)
+	if r.OlderThan > 0 {
+		buf.WriteString(" older than " + r.OlderThan.String())
+	}
+	if r.LargerThan > 0 {
+		buf.WriteString(" larger than " + strconv.FormatUint(uint64(r.LargerThan), 10))
+	}
+	if len(r.Flags) != 0 {
+		buf.WriteString(" with " + strings.Join(r.Flags, " "))
+	}
+	if len(r.WithoutFlags) != 0 {
+		buf.WriteString(" without " + strings.Join(r.WithoutFlags, " "))
+	}
+	if r.ArchiveTo != "" {
+		buf.WriteString(" -> " + r.ArchiveTo)
+	}
+	return buf.String()
+}
+
+// ErrEmptySweepRule is returned by SweepRule.Validate for a rule without conditions,
+// which would match all the messages of the mailbox.
+var ErrEmptySweepRule = errors.New("sweep rule without conditions")
+
+// Validate reports whether the rule can be used: it has a Mailbox and at least one condition.
+func (r SweepRule) Validate() error {
+	if r.Mailbox == "" {
+		return errors.New("sweep rule without mailbox")
+	}
+	if r.OlderThan <= 0 && r.LargerThan == 0 && len(r.Flags) == 0 && len(r.WithoutFlags) == 0 {
+		return ErrEmptySweepRule
+	}
+	return nil
+}
+
+// match reports whether the message described by the FetchArgs result matches the rule.
+func (r SweepRule) match(now time.Time, attrs map[string][]string) bool {
+	if r.OlderThan > 0 {
+		a := attrs["INTERNALDATE"]
+		if len(a) == 0 {
+			return false
+		}
+		t, err := time.Parse(time.RFC3339, a[0])
+		if err != nil || now.Sub(t) < r.OlderThan {
+			return false
+		}
+	}
+	if r.LargerThan > 0 {
+		a := attrs["RFC822.SIZE"]
+		if len(a) == 0 {
+			return false
+		}
+		if size, err := strconv.ParseUint(a[0], 10, 32); err != nil || uint32(size) <= r.LargerThan {
+			return false
+		}
+	}
+	if len(r.Flags) != 0 || len(r.WithoutFlags) != 0 {
+		flags, ok := attrs["FLAGS"]
+		if !ok {
+			return false
+		}
+		has := func(f string) bool {
+			return slices.ContainsFunc(flags, func(s string) bool { return strings.EqualFold(s, f) })
+		}
+		for _, f := range r.Flags {
+			if !has(f) {
+				return false
+			}
+		}
+		for _, f := range r.WithoutFlags {
+			if has(f) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SweepStats are the counts of a sweep.
+type SweepStats struct {
+	// Matched messages - in dry-run mode, nothing else is counted.
+	Matched int
+	// Deleted and Moved (archived) messages.
+	Deleted, Moved int
+	// Errors of deleting or moving.
+	Errors int
+}
+
+func (s *SweepStats) add(o SweepStats) {
+	s.Matched += o.Matched
+	s.Deleted += o.Deleted
+	s.Moved += o.Moved
+	s.Errors += o.Errors
+}
+
+// Sweeper periodically deletes or archives the messages matching the rules,
+// for example the messages of the errbox older than 90 days.
+//
+// The Client is connected and closed for each sweep, just as DeliveryLoop does,
+// so they can share the same Client if not running concurrently.
+type Sweeper struct {
+	Client Client
+	Logger *slog.Logger
+	// OnSweep is called with the stats of each rule after it has been swept - for metrics.
+	OnSweep func(SweepRule, SweepStats)
+	Rules   []SweepRule
+	// Interval between the sweeps in Run, LongSleep if zero.
+	Interval time.Duration
+	// DryRun only counts (and logs) the matching messages.
+	DryRun bool
+}
+
+// Run sweeps periodically till the context is canceled.
+func (s *Sweeper) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = LongSleep
+	}
+	for {
+		if _, err := s.Sweep(ctx); err != nil {
+			s.logger().Error("sweep", "error", err)
+		}
+		delay := time.NewTimer(interval)
+		select {
+		case <-delay.C:
+		case <-ctx.Done():
+			delay.Stop()
+			return nil
+		}
+	}
+}
+
+// Sweep does one round of sweeping with all the rules, and returns the summarized stats.
+//
+// The invalid rules (see SweepRule.Validate) are skipped, returning their errors.
+func (s *Sweeper) Sweep(ctx context.Context) (SweepStats, error) {
+	var total SweepStats
+	var errs []error
+	for _, r := range s.Rules {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r, err))
+			continue
+		}
+		stats, err := s.sweep(ctx, r)
+		total.add(stats)
+		if s.OnSweep != nil {
+			s.OnSweep(r, stats)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r, err))
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+func (s *Sweeper) sweep(ctx context.Context, r SweepRule) (SweepStats, error) {
+	var stats SweepStats
+	logger := s.logger().With("rule", r.String(), "dryRun", s.DryRun)
+	c := s.Client
+	if err := c.Connect(ctx); err != nil {
+		return stats, fmt.Errorf("connect: %w", err)
+	}
+	defer func() { c.Close(ctx, !s.DryRun && stats.Deleted+stats.Moved != 0) }()
+
+	uids, err := c.List(ctx, r.Mailbox, "", true)
+	if err != nil {
+		return stats, fmt.Errorf("list %q: %w", r.Mailbox, err)
+	}
+	now := time.Now()
+	for len(uids) != 0 {
+		n := len(uids)
+		if n > fetchBatchLen {
+			n = fetchBatchLen
+		}
+		attrs, err := c.FetchArgs(ctx, "RFC822.SIZE INTERNALDATE FLAGS", uids[:n]...)
+		if err != nil {
+			return stats, fmt.Errorf("fetch: %w", err)
+		}
+		var matched SeqSet
+		for _, uid := range uids[:n] {
+			if a, ok := attrs[uid]; ok && r.match(now, a) {
+				matched.AddNum(uid)
+			}
+		}
+		uids = uids[n:]
+		if matched.IsEmpty() {
+			continue
+		}
+		k := matched.Len()
+		stats.Matched += k
+		logger.Debug("matched", "uids", matched.String())
+		if s.DryRun {
+			continue
+		}
+		if r.ArchiveTo != "" {
+			if err := MoveSet(ctx, c, matched, r.ArchiveTo); err != nil {
+				logger.Error("move", "uids", matched.String(), "error", err)
+				stats.Errors += k
+			} else {
+				stats.Moved += k
+			}
+		} else if err := DeleteSet(ctx, c, matched); err != nil {
+			logger.Error("delete", "uids", matched.String(), "error", err)
+			stats.Errors += k
+		} else {
+			stats.Deleted += k
+		}
+	}
+	logger.Info("swept", "matched", stats.Matched, "deleted", stats.Deleted, "moved", stats.Moved, "errors", stats.Errors)
+	return stats, nil
+}
+
+func (s *Sweeper) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
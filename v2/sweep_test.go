@@ -0,0 +1,44 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSweepRuleValidate(t *testing.T) {
+	for i, tc := range []struct {
+		Rule SweepRule
+		OK   bool
+	}{
+		{SweepRule{}, false},
+		{SweepRule{Mailbox: "Errors"}, false},
+		{SweepRule{Mailbox: "Errors", ArchiveTo: "Archive"}, false},
+		{SweepRule{OlderThan: time.Hour}, false},
+		{SweepRule{Mailbox: "Errors", OlderThan: time.Hour}, true},
+		{SweepRule{Mailbox: "Errors", LargerThan: 1 << 20}, true},
+		{SweepRule{Mailbox: "Errors", Flags: []string{`\Seen`}}, true},
+		{SweepRule{Mailbox: "Errors", WithoutFlags: []string{`\Flagged`}}, true},
+	} {
+		if err := tc.Rule.Validate(); (err == nil) != tc.OK {
+			t.Errorf("%d. %s: got %+v, wanted ok=%t", i, tc.Rule, err, tc.OK)
+		}
+	}
+}
+
+func TestSweepEmptyRule(t *testing.T) {
+	mb := newFakeMailbox(1, 2)
+	s := Sweeper{Client: &fakeClient{mb: mb}, Rules: []SweepRule{{Mailbox: "INBOX"}}}
+	stats, err := s.Sweep(context.Background())
+	if !errors.Is(err, ErrEmptySweepRule) {
+		t.Errorf("got %+v, wanted ErrEmptySweepRule", err)
+	}
+	if stats != (SweepStats{}) || len(mb.flags) != 2 {
+		t.Errorf("swept with the empty rule: %+v", stats)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MessageText returns the plain text body of the message:
+// the first text/plain part, or the first text/html part converted with HTMLToText.
+//
+// The transfer encodings (quoted-printable, base64) and the charsets are decoded.
+func MessageText(r io.Reader) (string, error) {
+	m, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return "", err
+	}
+	var plain, htmlText string
+	var hasPlain, hasHTML bool
+	err = m.Walk(func(_ []int, e *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) {
+			return err
+		}
+		if disp, _, _ := e.Header.ContentDisposition(); disp == "attachment" {
+			return nil
+		}
+		mediaType, _, _ := e.Header.ContentType()
+		switch {
+		case mediaType == "text/plain" && !hasPlain, mediaType == "" && !hasPlain:
+			b, err := io.ReadAll(e.Body)
+			if err != nil {
+				return err
+			}
+			plain, hasPlain = string(b), true
+		case mediaType == "text/html" && !hasHTML:
+			if htmlText, err = HTMLToText(e.Body); err != nil {
+				return err
+			}
+			hasHTML = true
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if hasPlain || !hasHTML {
+		return plain, nil
+	}
+	return htmlText, nil
+}
+
+// HTMLToText converts the HTML to plain text: the tags are stripped, the scripts and styles dropped,
+// the block elements separated by new lines, the links kept as "text <href>".
+func HTMLToText(r io.Reader) (string, error) {
+	var tc textConverter
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return tc.String(), err
+			}
+			return tc.String(), nil
+		case html.TextToken:
+			if tc.skip == 0 {
+				tc.text(string(z.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tc.start(z)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tc.end(atom.Lookup(name))
+		}
+	}
+}
+
+type textConverter struct {
+	buf strings.Builder
+	// hrefs is the stack of the open links.
+	hrefs []string
+	// linkText is the text of the innermost open link.
+	linkText strings.Builder
+	// skip, pre and list are the depth of the open script/style, pre and ol/ul elements.
+	skip, pre, list int
+	// newlines is the number of new lines at the end of buf, space is set if a space is pending.
+	newlines int
+	space    bool
+}
+
+func (tc *textConverter) String() string { return strings.TrimSpace(tc.buf.String()) + "\n" }
+
+func (tc *textConverter) text(s string) {
+	if tc.pre == 0 {
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			tc.space = tc.space || s != ""
+			return
+		}
+		lead, trail := s[0] != fields[0][0], s[len(s)-1] != fields[len(fields)-1][len(fields[len(fields)-1])-1]
+		s = strings.Join(fields, " ")
+		if lead {
+			tc.space = true
+		}
+		tc.write(s)
+		tc.space = trail
+		return
+	}
+	tc.write(s)
+}
+
+func (tc *textConverter) write(s string) {
+	if s == "" {
+		return
+	}
+	if tc.space && tc.newlines == 0 && tc.buf.Len() != 0 {
+		tc.buf.WriteByte(' ')
+	}
+	tc.space = false
+	if len(tc.hrefs) != 0 {
+		tc.linkText.WriteString(s)
+	}
+	tc.buf.WriteString(s)
+	if i := strings.LastIndexFunc(s, func(r rune) bool { return r != '\n' }); i < 0 {
+		tc.newlines += len(s)
+	} else {
+		tc.newlines = len(s) - i - 1
+	}
+}
+
+// newline ensures that there are at least n new lines at the end of the text.
+func (tc *textConverter) newline(n int) {
+	tc.space = false
+	if tc.buf.Len() == 0 {
+		return
+	}
+	for ; tc.newlines < n; tc.newlines++ {
+		tc.buf.WriteByte('\n')
+	}
+}
+
+func (tc *textConverter) start(z *html.Tokenizer) {
+	name, hasAttr := z.TagName()
+	attrs := make(map[string]string)
+	for hasAttr {
+		var k, v []byte
+		k, v, hasAttr = z.TagAttr()
+		attrs[string(k)] = string(v)
+	}
+	switch a := atom.Lookup(name); a {
+	case atom.Script, atom.Style, atom.Head, atom.Title, atom.Template:
+		tc.skip++
+	case atom.Br:
+		tc.buf.WriteByte('\n')
+		tc.newlines++
+		tc.space = false
+	case atom.P, atom.Div, atom.Table, atom.Blockquote, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		tc.newline(2)
+	case atom.Tr, atom.Dt, atom.Dd, atom.Section, atom.Article, atom.Header, atom.Footer:
+		tc.newline(1)
+	case atom.Hr:
+		tc.newline(1)
+		tc.write("----")
+		tc.newline(1)
+	case atom.Ul, atom.Ol:
+		tc.list++
+		tc.newline(1)
+	case atom.Li:
+		tc.newline(1)
+		tc.write(strings.Repeat("  ", max(tc.list-1, 0)) + "* ")
+	case atom.Td, atom.Th:
+		tc.space = true
+	case atom.Pre:
+		tc.pre++
+		tc.newline(2)
+	case atom.Img:
+		if alt := strings.TrimSpace(attrs["alt"]); alt != "" {
+			tc.write(alt)
+		}
+	case atom.A:
+		tc.hrefs = append(tc.hrefs, attrs["href"])
+		tc.linkText.Reset()
+	}
+}
+
+func (tc *textConverter) end(a atom.Atom) {
+	switch a {
+	case atom.Script, atom.Style, atom.Head, atom.Title, atom.Template:
+		tc.skip = max(tc.skip-1, 0)
+	case atom.P, atom.Div, atom.Table, atom.Blockquote, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		tc.newline(2)
+	case atom.Tr, atom.Li, atom.Dt, atom.Dd, atom.Section, atom.Article, atom.Header, atom.Footer:
+		tc.newline(1)
+	case atom.Ul, atom.Ol:
+		tc.list = max(tc.list-1, 0)
+		tc.newline(1)
+	case atom.Pre:
+		tc.pre = max(tc.pre-1, 0)
+		tc.newline(2)
+	case atom.A:
+		if len(tc.hrefs) == 0 {
+			return
+		}
+		href := tc.hrefs[len(tc.hrefs)-1]
+		tc.hrefs = tc.hrefs[:len(tc.hrefs)-1]
+		text := strings.TrimSpace(tc.linkText.String())
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+			return
+		}
+		if text != strings.TrimPrefix(href, "mailto:") && text != href {
+			tc.space = true
+			tc.write("<" + href + ">")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`<html><head><title>T</title><style>p{}</style></head><body><p>Hello,   <b>World</b>!</p><p>Bye</p></body></html>`,
+			"Hello, World!\n\nBye\n"},
+		{`See <a href="https://example.com/x">the docs</a> or <a href="mailto:a@example.com">a@example.com</a>.`,
+			"See the docs <https://example.com/x> or a@example.com.\n"},
+		{`<ul><li>one</li><li>two &amp; three</li></ul>line<br>break<script>alert(1)</script>`,
+			"* one\n* two & three\nline\nbreak\n"},
+		{`<pre>a  b
+  c</pre>`, "a  b\n  c\n"},
+	} {
+		got, err := HTMLToText(strings.NewReader(tc.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	const msg = "Subject: test\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html; charset=iso-8859-2\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		"<p>=E1rv=ED<br>t=FBr=F5</p>\r\n--b--\r\n"
+	got, err := MessageText(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "árví\ntűrő\n"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestStripQuoted(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"Thanks!\n\nOn Mon, 1 Jan 2024 at 12:00, Joe <joe@example.com> wrote:\n> Hi\n> there\n", "Thanks!"},
+		{"Yes.\r\n\r\n-----Original Message-----\r\nFrom: Joe\r\n", "Yes."},
+		{"Ok\n\nFrom: Joe <joe@example.com>\nSent: Monday\nTo: me\n\nHi", "Ok"},
+		{"Rendben.\n\n2024. jan. 1., hétfő 12:00 időpontban Joe <joe@example.com> ezt írta:\n> Szia", "Rendben."},
+		{"Inline\n> quoted\nanswer\n-- \nSig", "Inline\nanswer"},
+		{"Thanks!\nOn Mon, 1 Jan 2024, Joe Long Name\n<joe@example.com> wrote:\n> Hi", "Thanks!"},
+	} {
+		if got := StripQuoted(tc.in); got != tc.want {
+			t.Errorf("%q: got %q, wanted %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseInlineHTML(t *testing.T) {
+	const msg = "Subject: img\r\nMIME-Version: 1.0\r\nContent-Type: multipart/related; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<img src=\"cid:logo@x\"><img src=\"cid:missing\">\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-ID: <logo@x>\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8=\r\n--b--\r\n"
+	html, parts, err := ParseInlineHTML(strings.NewReader(msg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := parts["logo@x"]; p.ContentType != "image/png" || string(p.Data) != "hello" {
+		t.Errorf("parts: got %+v", parts)
+	}
+	if want := `<img src="data:image/png;base64,aGVsbG8="><img src="cid:missing">`; strings.TrimSpace(html) != want {
+		t.Errorf("got %q, wanted %q", html, want)
+	}
+}
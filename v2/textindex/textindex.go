@@ -0,0 +1,354 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package textindex is a simple client-side full-text index (an inverted index)
+// of the subjects and texts of the messages, so the offline tools can search
+// the synced mailboxes without server round-trips.
+package textindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+// subjectBoost is the weight of a term in the subject, relative to the body text.
+const subjectBoost = 3
+
+// updateBatchLen is the number of messages whose MessageInfo are fetched at once by Update.
+const updateBatchLen = 256
+
+// DocID identifies an indexed message.
+type DocID struct {
+	Mailbox string
+	// UIDValidity is of the mailbox at the time of the indexing, 0 if the Client does not report it.
+	UIDValidity uint32
+	UID         uint32
+}
+
+// Document is an indexed message.
+type Document struct {
+	Date    time.Time
+	Subject string
+	From    []string
+	// Text is the plain text body, indexed but not stored.
+	Text string
+	DocID
+}
+
+// Hit is a result of Search.
+type Hit struct {
+	Document
+	Score float64
+}
+
+// Index is an inverted index of Documents, safe for concurrent use.
+//
+// The zero value is not usable, use New or Load.
+type Index struct {
+	// Logger logs the messages skipped by Update, slog.Default() if nil.
+	Logger *slog.Logger
+
+	docs map[DocID]*Document
+	// postings maps the terms to the weighted term frequencies of the documents.
+	postings map[string]map[DocID]uint32
+	// terms are the terms of the documents, for Remove.
+	terms map[DocID][]string
+	mu    sync.RWMutex
+}
+
+// New returns an empty Index.
+func New() *Index {
+	return &Index{
+		docs:     make(map[DocID]*Document),
+		postings: make(map[string]map[DocID]uint32),
+		terms:    make(map[DocID][]string),
+	}
+}
+
+// Len returns the number of the indexed documents.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Has reports whether the document is indexed.
+func (idx *Index) Has(id DocID) bool {
+	idx.mu.RLock()
+	_, ok := idx.docs[id]
+	idx.mu.RUnlock()
+	return ok
+}
+
+// Add indexes the document, replacing the one with the same DocID.
+func (idx *Index) Add(doc Document) {
+	freqs := make(map[string]uint32)
+	for _, t := range Terms(doc.Subject) {
+		freqs[t] += subjectBoost
+	}
+	for _, from := range doc.From {
+		for _, t := range Terms(from) {
+			freqs[t]++
+		}
+	}
+	for _, t := range Terms(doc.Text) {
+		freqs[t]++
+	}
+	terms := make([]string, 0, len(freqs))
+	for t := range freqs {
+		terms = append(terms, t)
+	}
+	doc.Text = ""
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(doc.DocID)
+	idx.docs[doc.DocID] = &doc
+	idx.terms[doc.DocID] = terms
+	for t, n := range freqs {
+		p := idx.postings[t]
+		if p == nil {
+			p = make(map[DocID]uint32)
+			idx.postings[t] = p
+		}
+		p[doc.DocID] = n
+	}
+}
+
+// Remove removes the document from the index.
+func (idx *Index) Remove(id DocID) {
+	idx.mu.Lock()
+	idx.remove(id)
+	idx.mu.Unlock()
+}
+
+func (idx *Index) remove(id DocID) {
+	for _, t := range idx.terms[id] {
+		if p := idx.postings[t]; p != nil {
+			if delete(p, id); len(p) == 0 {
+				delete(idx.postings, t)
+			}
+		}
+	}
+	delete(idx.terms, id)
+	delete(idx.docs, id)
+}
+
+// Search returns the documents containing all the terms of the query (a term ending with "*"
+// matches as a prefix), at most limit (all if limit <= 0), ranked by TF-IDF, the newer first on ties.
+//
+// The terms are case and accent insensitive.
+func (idx *Index) Search(query string, limit int) []Hit {
+	var prefixes []bool
+	var qterms []string
+	for _, f := range strings.Fields(query) {
+		prefix := strings.HasSuffix(f, "*")
+		for _, t := range Terms(f) {
+			qterms, prefixes = append(qterms, t), append(prefixes, false)
+		}
+		if prefix && len(prefixes) != 0 {
+			prefixes[len(prefixes)-1] = true
+		}
+	}
+	if len(qterms) == 0 {
+		return nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	n := float64(len(idx.docs))
+	var scores map[DocID]float64
+	for i, qt := range qterms {
+		matched := make(map[DocID]float64)
+		add := func(p map[DocID]uint32) {
+			idf := math.Log(1 + n/float64(len(p)))
+			for id, tf := range p {
+				if scores == nil || scores[id] != 0 {
+					matched[id] += float64(tf) * idf
+				}
+			}
+		}
+		if !prefixes[i] {
+			add(idx.postings[qt])
+		} else {
+			for t, p := range idx.postings {
+				if strings.HasPrefix(t, qt) {
+					add(p)
+				}
+			}
+		}
+		for id, s := range matched {
+			matched[id] = s + scores[id]
+		}
+		if scores = matched; len(scores) == 0 {
+			return nil
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for id, s := range scores {
+		hits = append(hits, Hit{Document: *idx.docs[id], Score: s})
+	}
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return b.Date.Compare(a.Date)
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// Terms returns the terms of the text: the lowercased words without accents.
+func Terms(text string) []string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Update indexes the messages of mbox which are not in the index yet,
+// and removes the ones which are not in the mailbox anymore - all of them, if its UIDVALIDITY has changed.
+// The messages whose text cannot be parsed are logged and skipped.
+//
+// The texts are read with Peek and MessageText, so they do not become \Seen.
+func (idx *Index) Update(ctx context.Context, c imapclient.Client, mbox string) (added, removed int, err error) {
+	uids, err := c.List(ctx, mbox, "", true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("list %q: %w", mbox, err)
+	}
+	var uidValidity uint32
+	if s, ok := imapclient.As[imapclient.SelectedStatuser](c); ok {
+		if st, ok := s.SelectedStatus(); ok {
+			uidValidity = st.UIDValidity
+		}
+	}
+	present := make(map[uint32]struct{}, len(uids))
+	todo := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		present[uid] = struct{}{}
+		if !idx.Has(DocID{Mailbox: mbox, UIDValidity: uidValidity, UID: uid}) {
+			todo = append(todo, uid)
+		}
+	}
+	idx.mu.RLock()
+	var gone []DocID
+	for id := range idx.docs {
+		if id.Mailbox != mbox {
+			continue
+		}
+		if _, ok := present[id.UID]; !ok || id.UIDValidity != uidValidity {
+			gone = append(gone, id)
+		}
+	}
+	idx.mu.RUnlock()
+	for _, id := range gone {
+		idx.Remove(id)
+	}
+
+	logger := idx.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	var buf bytes.Buffer
+	for len(todo) != 0 {
+		n := min(len(todo), updateBatchLen)
+		infos, err := imapclient.FetchInfo(ctx, c, todo[:n]...)
+		if err != nil {
+			return added, len(gone), fmt.Errorf("fetch info: %w", err)
+		}
+		for _, mi := range infos {
+			buf.Reset()
+			if _, err := c.Peek(ctx, &buf, mi.UID, ""); err != nil {
+				return added, len(gone), fmt.Errorf("read %d: %w", mi.UID, err)
+			}
+			text, err := imapclient.MessageText(&buf)
+			if err != nil {
+				logger.Warn("skip unparsable message", "mailbox", mbox, "uid", mi.UID, "error", err)
+				continue
+			}
+			idx.Add(Document{
+				DocID: DocID{Mailbox: mbox, UIDValidity: uidValidity, UID: mi.UID},
+				Date:  mi.Date, Subject: mi.Subject, From: mi.From, Text: text,
+			})
+			added++
+		}
+		todo = todo[n:]
+	}
+	return added, len(gone), nil
+}
+
+// snapshot is the serialized form of the Index.
+type snapshot struct {
+	Docs     []Document
+	Postings map[string]map[DocID]uint32
+}
+
+// WriteTo writes the index to w, to be read by Load.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	idx.mu.RLock()
+	snap := snapshot{Docs: make([]Document, 0, len(idx.docs)), Postings: idx.postings}
+	for _, doc := range idx.docs {
+		snap.Docs = append(snap.Docs, *doc)
+	}
+	err := gob.NewEncoder(cw).Encode(snap)
+	idx.mu.RUnlock()
+	return cw.n, err
+}
+
+// Load reads the index written by WriteTo.
+func Load(r io.Reader) (*Index, error) {
+	var snap snapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, err
+	}
+	idx := New()
+	for i := range snap.Docs {
+		idx.docs[snap.Docs[i].DocID] = &snap.Docs[i]
+	}
+	if snap.Postings != nil {
+		idx.postings = snap.Postings
+	}
+	for t, p := range idx.postings {
+		for id := range p {
+			idx.terms[id] = append(idx.terms[id], t)
+		}
+	}
+	return idx, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package textindex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/imapclient/v2"
+)
+
+func TestSearch(t *testing.T) {
+	idx := New()
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	idx.Add(Document{DocID: DocID{Mailbox: "INBOX", UID: 1}, Date: day, Subject: "Számla január", Text: "A januári számlát csatoltuk."})
+	idx.Add(Document{DocID: DocID{Mailbox: "INBOX", UID: 2}, Date: day.AddDate(0, 1, 0), Subject: "Invoice February", Text: "Please find the invoice attached."})
+	idx.Add(Document{DocID: DocID{Mailbox: "Archive", UID: 1}, Date: day.AddDate(0, 2, 0), Subject: "Meeting", From: []string{"joe@example.com"}, Text: "About the invoice."})
+
+	check := func(query string, want ...DocID) {
+		t.Helper()
+		var got []DocID
+		for _, h := range idx.Search(query, 0) {
+			got = append(got, h.DocID)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: got %v, wanted %v", query, got, want)
+		}
+	}
+	check("invoice", DocID{Mailbox: "INBOX", UID: 2}, DocID{Mailbox: "Archive", UID: 1})
+	check("INVOICE attached", DocID{Mailbox: "INBOX", UID: 2})
+	check("szamla", DocID{Mailbox: "INBOX", UID: 1})
+	check("janu*", DocID{Mailbox: "INBOX", UID: 1})
+	check("joe", DocID{Mailbox: "Archive", UID: 1})
+	check("invoice nothing")
+	check("")
+
+	idx.Remove(DocID{Mailbox: "INBOX", UID: 2})
+	check("invoice", DocID{Mailbox: "Archive", UID: 1})
+
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if idx, err := Load(&buf); err != nil {
+		t.Fatal(err)
+	} else if hits := idx.Search("invoice", 1); idx.Len() != 2 || len(hits) != 1 || hits[0].Subject != "Meeting" {
+		t.Errorf("loaded: %d docs, hits %+v", idx.Len(), hits)
+	}
+}
+
+// fakeClient serves the messages of one mailbox.
+type fakeClient struct {
+	imapclient.Client
+	msgs        map[uint32]string
+	uidValidity uint32
+}
+
+func (c fakeClient) List(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	uids := make([]uint32, 0, len(c.msgs))
+	for uid := range c.msgs {
+		uids = append(uids, uid)
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+func (c fakeClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		m[uid] = map[string][]string{"ENVELOPE.SUBJECT": {fmt.Sprintf("subject %d", uid)}}
+	}
+	return m, nil
+}
+func (c fakeClient) Peek(ctx context.Context, w io.Writer, msgID uint32, what string) (int64, error) {
+	if raw, ok := strings.CutPrefix(c.msgs[msgID], "raw:"); ok {
+		n, err := io.WriteString(w, raw)
+		return int64(n), err
+	}
+	n, err := fmt.Fprintf(w, "Content-Type: text/plain\r\n\r\n%s\r\n", c.msgs[msgID])
+	return int64(n), err
+}
+func (c fakeClient) SelectedStatus() (imapclient.SelectedStatus, bool) {
+	return imapclient.SelectedStatus{UIDValidity: c.uidValidity}, c.uidValidity != 0
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	c := fakeClient{msgs: map[uint32]string{1: "hello world", 2: "goodbye world"}}
+	idx := New()
+	if added, removed, err := idx.Update(ctx, c, "INBOX"); err != nil || added != 2 || removed != 0 {
+		t.Fatalf("got %d, %d, %+v", added, removed, err)
+	}
+	delete(c.msgs, 1)
+	c.msgs[3] = "hello again"
+	if added, removed, err := idx.Update(ctx, c, "INBOX"); err != nil || added != 1 || removed != 1 {
+		t.Fatalf("got %d, %d, %+v", added, removed, err)
+	}
+	if hits := idx.Search("hello", 0); len(hits) != 1 || hits[0].UID != 3 || hits[0].Subject != "subject 3" {
+		t.Errorf("got %+v", hits)
+	}
+
+	// An unparsable message is skipped.
+	c.msgs[4] = "raw:no header\r\n\r\nbody\r\n"
+	if added, removed, err := idx.Update(ctx, c, "INBOX"); err != nil || added != 0 || removed != 0 {
+		t.Fatalf("unparsable: got %d, %d, %+v", added, removed, err)
+	}
+	delete(c.msgs, 4)
+
+	// A new UIDVALIDITY means new messages behind the same UIDs.
+	c.uidValidity = 7
+	c.msgs[2] = "renumbered"
+	if added, removed, err := idx.Update(ctx, c, "INBOX"); err != nil || added != 2 || removed != 2 {
+		t.Fatalf("uidvalidity: got %d, %d, %+v", added, removed, err)
+	}
+	if hits := idx.Search("goodbye", 0); len(hits) != 0 {
+		t.Errorf("stale: got %+v", hits)
+	}
+	if hits := idx.Search("renumbered", 0); len(hits) != 1 || hits[0].UIDValidity != 7 {
+		t.Errorf("renumbered: got %+v", hits)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+)
+
+// uniqueBodier is implemented by the Clients whose server knows the new content of a message (o365, Graph).
+type uniqueBodier interface {
+	UniqueBody(ctx context.Context, msgID uint32) (string, error)
+}
+
+// NewContent returns the latest contribution of the message to its thread, as plain text:
+// the UniqueBody for o365 and Graph, the MessageText without the quoted replies (see StripQuoted) for IMAP.
+func NewContent(ctx context.Context, c Client, msgID uint32) (string, error) {
+	if ub, ok := As[uniqueBodier](c); ok {
+		return ub.UniqueBody(ctx, msgID)
+	}
+	var buf bytes.Buffer
+	if _, err := c.Peek(ctx, &buf, msgID, ""); err != nil {
+		return "", err
+	}
+	text, err := MessageText(&buf)
+	if err != nil {
+		return "", err
+	}
+	return StripQuoted(text), nil
+}
+
+var (
+	// rReplyHeader matches the attribution lines of the common mail clients.
+	rReplyHeader = regexp.MustCompile(`(?i)^\s*(` +
+		`on\b.{0,200}\bwrote:|` + // On Mon, 1 Jan 2024, Joe <joe@example.com> wrote:
+		`am\b.{0,200}\bschrieb.{0,100}:|` + // Am 01.01.2024 um 12:00 schrieb Joe:
+		`le\b.{0,200}\ba écrit\s*:|` + // Le 1 janv. 2024, Joe a écrit :
+		`.{0,200}\sírta:|` + // 2024. jan. 1., hétfő 12:00 időpontban Joe ezt írta:
+		`-{2,}\s*(original message|eredeti üzenet|ursprüngliche nachricht|message d'origine)\s*-{2,}|` +
+		`_{10,}` + // Outlook separator
+		`)\s*$`)
+	// rOutlookHeader matches the first line of the header block Outlook puts before the quoted message.
+	rOutlookHeader = regexp.MustCompile(`(?i)^\s*\*?(from|feladó|von|de)\s*:\*?\s`)
+	// rOutlookHeaderNext matches the next line of that header block.
+	rOutlookHeaderNext = regexp.MustCompile(`(?i)^\s*\*?(sent|date|küldve|dátum|gesendet|envoyé|to|címzett|an|à)\s*:`)
+)
+
+// StripQuoted returns the text without the quoted replies and the signature:
+// everything after the first attribution line ("On ... wrote:", "-----Original Message-----",
+// Outlook's "From: ... Sent: ..." block) or signature separator ("-- "), and the ">" quoted lines.
+//
+// This is a heuristic, for the servers which do not provide the unique body of the messages.
+func StripQuoted(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if line == "-- " || rReplyHeader.MatchString(line) ||
+			rOutlookHeader.MatchString(line) && i+1 < len(lines) && rOutlookHeaderNext.MatchString(lines[i+1]) {
+			break
+		}
+		// An attribution line broken into two by the sender's client.
+		if i+1 < len(lines) && strings.HasSuffix(strings.TrimSpace(lines[i+1]), ":") &&
+			rReplyHeader.MatchString(line+" "+lines[i+1]) {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"sync"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// updateRelay passes the unilateral updates of the connection to the current receiver.
+//
+// go-imap reads its Client.Updates in the reader goroutine without synchronization,
+// so that is set only once, right after connecting, and the receivers are switched here.
+type updateRelay struct {
+	ch chan<- client.Update
+	mu sync.Mutex
+}
+
+// start sets the Updates of cl, and relays them till the connection ends.
+func (r *updateRelay) start(cl *client.Client) {
+	in := make(chan client.Update, 16)
+	cl.Updates = in
+	go func() {
+		for {
+			select {
+			case upd := <-in:
+				r.mu.Lock()
+				if r.ch != nil {
+					// Blocking would block the whole connection.
+					select {
+					case r.ch <- upd:
+					default:
+					}
+				}
+				r.mu.Unlock()
+			case <-cl.LoggedOut():
+				return
+			}
+		}
+	}()
+}
+
+// set makes ch the receiver of the updates, and returns the function which unsets it.
+// The updates without receiver are dropped.
+func (r *updateRelay) set(ch chan<- client.Update) func() {
+	r.mu.Lock()
+	r.ch = ch
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		if r.ch == ch {
+			r.ch = nil
+		}
+		r.mu.Unlock()
+	}
+}
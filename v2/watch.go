@@ -0,0 +1,267 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// EventType is the type of a mailbox change.
+type EventType uint8
+
+const (
+	// NewMessage is emitted for a message appeared in the mailbox.
+	NewMessage = EventType(iota + 1)
+	// Expunged is emitted for a message disappeared from the mailbox.
+	Expunged
+	// FlagsChanged is emitted when the flags of the message changed.
+	FlagsChanged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case NewMessage:
+		return "NewMessage"
+	case Expunged:
+		return "Expunged"
+	case FlagsChanged:
+		return "FlagsChanged"
+	default:
+		return fmt.Sprintf("EventType(%d)", uint8(t))
+	}
+}
+
+// Event is a change of the watched mailbox.
+type Event struct {
+	Mailbox string
+	// Flags of the message, for NewMessage and FlagsChanged - if the Client can fetch them.
+	Flags []string
+	UID   uint32
+	Type  EventType
+}
+
+// Watcher watches a mailbox, and emits Events to its subscribers.
+//
+// The events are computed from the differences of the consecutive listings of the mailbox,
+// which are done periodically, or right after a server notification when the Client supports IDLE.
+//
+// The first listing only establishes the state, it does not emit NewMessage events.
+//
+// The Watcher needs its own Client, as it keeps it connected.
+type Watcher struct {
+	subscribers
+	client   Client
+	logger   *slog.Logger
+	known    map[uint32][]string
+	mailbox  string
+	interval time.Duration
+	noFlags  bool
+}
+
+// subscribers are the subscribers of the Events of a Watcher.
+type subscribers struct {
+	subs   map[int]func(context.Context, Event)
+	nextID int
+	mu     sync.Mutex
+}
+
+// NewWatcher returns a Watcher for the mailbox, listing it at least every interval
+// (ShortSleep if zero).
+func NewWatcher(c Client, mailbox string, interval time.Duration, logger *slog.Logger) *Watcher {
+	if interval <= 0 {
+		interval = ShortSleep
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Watcher{
+		client: c, mailbox: mailbox, interval: interval,
+		logger:      logger.With("mailbox", mailbox),
+		subscribers: subscribers{subs: make(map[int]func(context.Context, Event))},
+	}
+}
+
+// Subscribe returns a channel for the events, with the given buffer size,
+// and a function to unsubscribe. The channel is not closed.
+//
+// The Watcher waits for the subscribers to receive the event - a slow subscriber
+// should use a big enough buffer.
+func (w *subscribers) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	quit := make(chan struct{})
+	unsubscribe := w.SubscribeFunc(func(ctx context.Context, e Event) {
+		select {
+		case ch <- e:
+		case <-quit:
+		case <-ctx.Done():
+		}
+	})
+	var once sync.Once
+	return ch, func() { once.Do(func() { close(quit); unsubscribe() }) }
+}
+
+// SubscribeFunc registers the function to be called for each event,
+// and returns a function to unsubscribe.
+func (w *subscribers) SubscribeFunc(f func(context.Context, Event)) func() {
+	w.mu.Lock()
+	id := w.nextID
+	w.nextID++
+	w.subs[id] = f
+	w.mu.Unlock()
+	return func() {
+		w.mu.Lock()
+		delete(w.subs, id)
+		w.mu.Unlock()
+	}
+}
+
+func (w *subscribers) emit(ctx context.Context, e Event) {
+	w.mu.Lock()
+	subs := make([]func(context.Context, Event), 0, len(w.subs))
+	for _, f := range w.subs {
+		subs = append(subs, f)
+	}
+	w.mu.Unlock()
+	for _, f := range subs {
+		f(ctx, e)
+	}
+}
+
+// idler is implemented by the Clients which can wait for server notifications.
+type idler interface {
+	idle(ctx context.Context, timeout time.Duration) error
+}
+
+// Run watches the mailbox till the context is canceled.
+func (w *Watcher) Run(ctx context.Context) error {
+	connected := false
+	defer func() {
+		if connected {
+			w.client.Close(context.Background(), false)
+		}
+	}()
+	for {
+		if !connected {
+			if err := w.client.Connect(ctx); err != nil {
+				w.logger.Error("connect", "error", err)
+				if !sleepCtx(ctx, LongSleep) {
+					return nil
+				}
+				continue
+			}
+			connected = true
+		}
+		if err := w.poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			w.logger.Error("poll", "error", err)
+			w.client.Close(ctx, false)
+			connected = false
+			if !sleepCtx(ctx, LongSleep) {
+				return nil
+			}
+			continue
+		}
+		if i, ok := As[idler](w.client); ok {
+			if err := i.idle(ctx, w.interval); err != nil && ctx.Err() == nil {
+				w.logger.Warn("idle", "error", err)
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		} else if !sleepCtx(ctx, w.interval) {
+			return nil
+		}
+	}
+}
+
+// poll lists the mailbox and emits the events for the differences.
+func (w *Watcher) poll(ctx context.Context) error {
+	uids, err := w.client.List(ctx, w.mailbox, "", true)
+	if err != nil {
+		return fmt.Errorf("list %q: %w", w.mailbox, err)
+	}
+	current := make(map[uint32][]string, len(uids))
+	for _, uid := range uids {
+		current[uid] = nil
+	}
+	if !w.noFlags {
+		for todo := uids; len(todo) != 0; {
+			n := min(len(todo), fetchBatchLen)
+			attrs, err := w.client.FetchArgs(ctx, "FLAGS", todo[:n]...)
+			if err != nil {
+				w.logger.Warn("FetchArgs FLAGS is not supported, no FlagsChanged events", "error", err)
+				w.noFlags = true
+				break
+			}
+			for _, uid := range todo[:n] {
+				current[uid] = attrs[uid]["FLAGS"]
+			}
+			todo = todo[n:]
+		}
+	}
+
+	first := w.known == nil
+	known := w.known
+	w.known = current
+	if first {
+		return nil
+	}
+	for _, uid := range uids {
+		flags := current[uid]
+		old, ok := known[uid]
+		if !ok {
+			w.emit(ctx, Event{Type: NewMessage, Mailbox: w.mailbox, UID: uid, Flags: flags})
+		} else if !w.noFlags && !slices.Equal(old, flags) {
+			w.emit(ctx, Event{Type: FlagsChanged, Mailbox: w.mailbox, UID: uid, Flags: flags})
+		}
+	}
+	for uid := range known {
+		if _, ok := current[uid]; !ok {
+			w.emit(ctx, Event{Type: Expunged, Mailbox: w.mailbox, UID: uid})
+		}
+	}
+	return ctx.Err()
+}
+
+var _ idler = (*imapClient)(nil)
+
+// idle waits for a server notification on the selected mailbox with IDLE,
+// at most for timeout.
+func (c *imapClient) idle(ctx context.Context, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	updates := make(chan client.Update, 8)
+	defer c.updates.set(updates)()
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- c.c.Idle(stop, nil) }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-updates:
+	case <-timer.C:
+	case <-ctx.Done():
+	case err := <-done:
+		return err
+	}
+	close(stop)
+	for {
+		select {
+		case <-updates: // not to block the reader till IDLE finishes
+		case err := <-done:
+			return err
+		}
+	}
+}
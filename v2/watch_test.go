@@ -0,0 +1,98 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+// watchClient is a fakeClient which can fetch the FLAGS, unless flagsErr is set.
+type watchClient struct {
+	*fakeClient
+	flagsErr error
+}
+
+func (c watchClient) FetchArgs(ctx context.Context, what string, msgIDs ...uint32) (map[uint32]map[string][]string, error) {
+	if c.flagsErr != nil {
+		return nil, c.flagsErr
+	}
+	c.mb.mu.Lock()
+	defer c.mb.mu.Unlock()
+	m := make(map[uint32]map[string][]string, len(msgIDs))
+	for _, uid := range msgIDs {
+		if flags, ok := c.mb.flags[uid]; ok {
+			m[uid] = map[string][]string{"FLAGS": slices.Clone(flags)}
+		}
+	}
+	return m, nil
+}
+
+func TestWatcher(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	for name, flagsErr := range map[string]error{"flags": nil, "noFlags": errors.ErrUnsupported} {
+		t.Run(name, func(t *testing.T) {
+			mb := newFakeMailbox(1, 2)
+			w := NewWatcher(watchClient{fakeClient: &fakeClient{mb: mb}, flagsErr: flagsErr}, "INBOX", 0, logger)
+			var got []Event
+			unsubscribeFunc := w.SubscribeFunc(func(_ context.Context, e Event) { got = append(got, e) })
+			ch, unsubscribe := w.Subscribe(8)
+
+			if err := w.poll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 0 {
+				t.Errorf("first listing: got %+v", got)
+			}
+
+			mb.add(3, "new", `\Recent`)
+			mb.setFlag(1, `\Seen`, true)
+			delete(mb.flags, 2)
+			if err := w.poll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			want := []Event{
+				{Type: FlagsChanged, Mailbox: "INBOX", UID: 1, Flags: []string{`\Seen`}},
+				{Type: NewMessage, Mailbox: "INBOX", UID: 3, Flags: []string{`\Recent`}},
+				{Type: Expunged, Mailbox: "INBOX", UID: 2},
+			}
+			if flagsErr != nil {
+				want = []Event{
+					{Type: NewMessage, Mailbox: "INBOX", UID: 3},
+					{Type: Expunged, Mailbox: "INBOX", UID: 2},
+				}
+			}
+			if !slices.EqualFunc(got, want, eventEqual) {
+				t.Errorf("got %+v, wanted %+v", got, want)
+			}
+			for i := range want {
+				if e := <-ch; !eventEqual(e, want[i]) {
+					t.Errorf("%d. channel: got %+v, wanted %+v", i, e, want[i])
+				}
+			}
+
+			unsubscribeFunc()
+			unsubscribe()
+			unsubscribe()
+			got = got[:0]
+			mb.add(4, "newer")
+			if err := w.poll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 0 || len(ch) != 0 {
+				t.Errorf("after unsubscribe: got %+v and %d", got, len(ch))
+			}
+		})
+	}
+}
+
+func eventEqual(a, b Event) bool {
+	return a.Type == b.Type && a.Mailbox == b.Mailbox && a.UID == b.UID && slices.Equal(a.Flags, b.Flags)
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader is the header of the HMAC-SHA256 signature of the webhook's body,
+// in "sha256=<hex>" format.
+const WebhookSignatureHeader = "X-Imapclient-Signature-256"
+
+// WebhookPayload is the JSON body POSTed by the Webhook.
+type WebhookPayload struct {
+	Date      time.Time `json:"date,omitempty"`
+	MessageID string    `json:"messageId,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Folder    string    `json:"folder"`
+	// FetchURL is the URL (with a token) to fetch the message, if Webhook.FetchURL is set.
+	FetchURL string   `json:"fetchUrl,omitempty"`
+	From     []string `json:"from,omitempty"`
+	UID      uint32   `json:"uid"`
+}
+
+// Webhook POSTs a WebhookPayload to the endpoints for each new message of a Watcher.
+type Webhook struct {
+	// Client is the HTTP client, http.DefaultClient if nil.
+	Client *http.Client
+	Logger *slog.Logger
+	// Filter selects the messages to notify about. All messages are selected if nil.
+	Filter func(WebhookPayload) bool
+	// FetchURL returns the URL (with a token) the receiver can fetch the message from.
+	FetchURL func(mailbox string, uid uint32) string
+	// URLs of the endpoints.
+	URLs []string
+	// Secret is the key for signing the body, see WebhookSignatureHeader.
+	Secret []byte
+	// MaxRetries is the number of retries for each endpoint, with exponential backoff from ShortSleep.
+	MaxRetries int
+}
+
+// Attach subscribes the webhook to the NewMessage events of the Watcher.
+//
+// The envelope of the message is fetched synchronously with the Watcher's Client,
+// the endpoints are called in the background.
+func (wh *Webhook) Attach(w *Watcher) (unsubscribe func()) {
+	return w.SubscribeFunc(func(ctx context.Context, e Event) {
+		if e.Type != NewMessage {
+			return
+		}
+		p, err := wh.payload(ctx, w.client, e)
+		if err != nil {
+			wh.logger().Error("fetch envelope", "mailbox", e.Mailbox, "uid", e.UID, "error", err)
+			return
+		}
+		if wh.Filter != nil && !wh.Filter(p) {
+			return
+		}
+		body, err := json.Marshal(p)
+		if err != nil {
+			wh.logger().Error("marshal", "payload", p, "error", err)
+			return
+		}
+		for _, u := range wh.URLs {
+			go func(u string) {
+				if err := wh.post(ctx, u, body); err != nil {
+					wh.logger().Error("webhook", "url", u, "uid", e.UID, "error", err)
+				}
+			}(u)
+		}
+	})
+}
+
+func (wh *Webhook) payload(ctx context.Context, c Client, e Event) (WebhookPayload, error) {
+	p := WebhookPayload{Folder: e.Mailbox, UID: e.UID}
+	if wh.FetchURL != nil {
+		p.FetchURL = wh.FetchURL(e.Mailbox, e.UID)
+	}
+	attrs, err := c.FetchArgs(ctx, "ENVELOPE", e.UID)
+	if err != nil {
+		return p, err
+	}
+	a := attrs[e.UID]
+	first := func(k string) string {
+		if v := a[k]; len(v) != 0 {
+			return v[0]
+		}
+		return ""
+	}
+	p.MessageID = first("ENVELOPE.MESSAGE-ID")
+	p.Subject = first("ENVELOPE.SUBJECT")
+	p.From = a["ENVELOPE.FROM"]
+	p.Date, _ = time.Parse(time.RFC3339, first("ENVELOPE.DATE"))
+	return p, nil
+}
+
+// post sends the body to the URL, retrying on network errors and 5xx and 429 responses.
+func (wh *Webhook) post(ctx context.Context, URL string, body []byte) error {
+	cl := wh.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	var sig string
+	if len(wh.Secret) != 0 {
+		mac := hmac.New(sha256.New, wh.Secret)
+		mac.Write(body)
+		sig = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	wait := ShortSleep
+	var err error
+	for i := 0; i <= wh.MaxRetries; i++ {
+		if i != 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Join(err, ctx.Err())
+			case <-timer.C:
+			}
+			wait *= 2
+		}
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, "POST", URL, bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if sig != "" {
+			req.Header.Set(WebhookSignatureHeader, sig)
+		}
+		var resp *http.Response
+		if resp, err = cl.Do(req); err != nil {
+			continue
+		}
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+	}
+	return err
+}
+
+func (wh *Webhook) logger() *slog.Logger {
+	if wh.Logger != nil {
+		return wh.Logger
+	}
+	return slog.Default()
+}
+
+// VerifyWebhookSignature reports whether the signature (the value of the WebhookSignatureHeader)
+// is valid for the body with the secret - for the receivers.
+func VerifyWebhookSignature(secret, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
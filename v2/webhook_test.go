@@ -0,0 +1,68 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookPost(t *testing.T) {
+	old := ShortSleep
+	ShortSleep = time.Millisecond
+	t.Cleanup(func() { ShortSleep = old })
+	ctx := context.Background()
+	secret := []byte("secret")
+	body, err := json.Marshal(WebhookPayload{Folder: "INBOX", UID: 1, Subject: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		Statuses []int
+		Calls    int32
+		OK       bool
+	}{
+		"ok":        {Statuses: []int{200}, Calls: 1, OK: true},
+		"retried":   {Statuses: []int{503, 429, 204}, Calls: 3, OK: true},
+		"exhausted": {Statuses: []int{500, 500, 500, 500}, Calls: 3},
+		"permanent": {Statuses: []int{400, 200}, Calls: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := calls.Add(1) - 1
+				b, _ := io.ReadAll(r.Body)
+				sig := r.Header.Get(WebhookSignatureHeader)
+				if !VerifyWebhookSignature(secret, b, sig) {
+					t.Errorf("bad signature %q of %q", sig, b)
+				}
+				if VerifyWebhookSignature([]byte("other"), b, sig) {
+					t.Errorf("signature %q verified with another secret", sig)
+				}
+				if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("got %s %q", r.Method, r.Header.Get("Content-Type"))
+				}
+				w.WriteHeader(tc.Statuses[i])
+			}))
+			defer srv.Close()
+
+			wh := Webhook{Client: srv.Client(), Secret: secret, MaxRetries: 2}
+			err := wh.post(ctx, srv.URL, body)
+			if (err == nil) != tc.OK {
+				t.Errorf("got %+v, wanted ok=%t", err, tc.OK)
+			}
+			if got := calls.Load(); got != tc.Calls {
+				t.Errorf("got %d calls, wanted %d", got, tc.Calls)
+			}
+		})
+	}
+}
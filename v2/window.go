@@ -0,0 +1,88 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// SearchWindow bounds the results of List, to keep the first run against
+// a huge mailbox tractable. The zero value means no bounds.
+type SearchWindow struct {
+	// Since lists only the messages received since this time.
+	// IMAP SEARCH SINCE has day granularity, so this is rounded down to the day there.
+	Since time.Time
+	// MaxAge lists only the messages received in this duration before the List -
+	// unlike Since, it moves with the time of each List.
+	MaxAge time.Duration
+	// MinUID lists only the messages with greater UID - a checkpoint.
+	// The o365 Clients assign the UIDs themselves, so there it is only valid within a session.
+	MinUID uint32
+	// Max lists at most this many of the newest messages.
+	Max int
+}
+
+// IsZero reports whether the window has no bounds.
+func (w SearchWindow) IsZero() bool {
+	return w.Since.IsZero() && w.MaxAge <= 0 && w.MinUID == 0 && w.Max <= 0
+}
+
+// Start returns the start of the window at now: the later of Since and now-MaxAge,
+// the zero time if neither is set.
+func (w SearchWindow) Start(now time.Time) time.Time {
+	if w.MaxAge > 0 {
+		if t := now.Add(-w.MaxAge); t.After(w.Since) {
+			return t
+		}
+	}
+	return w.Since
+}
+
+// Filter applies the MinUID and Max bounds to the UIDs, for the Clients
+// which cannot apply them on the server.
+func (w SearchWindow) Filter(uids []uint32) []uint32 {
+	if w.MinUID != 0 {
+		uids = slices.DeleteFunc(uids, func(uid uint32) bool { return uid <= w.MinUID })
+	}
+	if w.Max > 0 && len(uids) > w.Max {
+		// The greatest UIDs are the newest.
+		slices.Sort(uids)
+		uids = uids[len(uids)-w.Max:]
+	}
+	return uids
+}
+
+// SearchWindowSetter is implemented by the Clients which can bound their Lists with a SearchWindow.
+type SearchWindowSetter interface {
+	// SetSearchWindow sets the bounds of the subsequent Lists.
+	SetSearchWindow(SearchWindow)
+}
+
+var _ SearchWindowSetter = (*imapClient)(nil)
+
+// SetSearchWindow sets the bounds of the subsequent Lists.
+func (c *imapClient) SetSearchWindow(w SearchWindow) { c.window = w }
+
+// unboundedLister is implemented by the Clients which can List without their SearchWindow.
+type unboundedLister interface {
+	listUnbounded(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error)
+}
+
+var _ unboundedLister = (*imapClient)(nil)
+
+func (c *imapClient) listUnbounded(ctx context.Context, mbox, pattern string, all bool) ([]uint32, error) {
+	return c.list(ctx, mbox, listCriteria(pattern, all, SearchWindow{}), SearchWindow{})
+}
+
+// listUnbounded lists the messages as c.List, but without the SearchWindow of c,
+// for the messages which must be found regardless of their age, such as the lock messages.
+func listUnbounded(ctx context.Context, c Client, mbox, pattern string, all bool) ([]uint32, error) {
+	if ul, ok := As[unboundedLister](c); ok {
+		return ul.listUnbounded(ctx, mbox, pattern, all)
+	}
+	return c.List(ctx, mbox, pattern, all)
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchWindowStart(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	since := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	for i, tc := range []struct {
+		W    SearchWindow
+		Want time.Time
+	}{
+		{SearchWindow{}, time.Time{}},
+		{SearchWindow{Since: since}, since},
+		{SearchWindow{MaxAge: 24 * time.Hour}, now.Add(-24 * time.Hour)},
+		{SearchWindow{Since: since, MaxAge: 24 * time.Hour}, now.Add(-24 * time.Hour)},
+		{SearchWindow{Since: since, MaxAge: 90 * 24 * time.Hour}, since},
+	} {
+		if got := tc.W.Start(now); !got.Equal(tc.Want) {
+			t.Errorf("%d. got %v, wanted %v", i, got, tc.Want)
+		}
+		if tc.W.IsZero() != tc.Want.IsZero() {
+			t.Errorf("%d. IsZero=%t", i, tc.W.IsZero())
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadLocation(t *testing.T) {
+	for win, iana := range windowsZones {
+		if _, err := time.LoadLocation(iana); err != nil {
+			t.Errorf("%s: %+v", win, err)
+		}
+	}
+	at := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	for name, offset := range map[string]int{
+		"W. Europe Standard Time":      3600,
+		`"Pacific Standard Time"`:      -8 * 3600,
+		"Europe/Budapest":              3600,
+		"India Standard Time":          19800,
+		"Central Europe Standard Time": 3600,
+	} {
+		loc, err := LoadLocation(name)
+		if err != nil {
+			t.Errorf("%s: %+v", name, err)
+			continue
+		}
+		if _, got := at.In(loc).Zone(); got != offset {
+			t.Errorf("%s: got offset %d, wanted %d", name, got, offset)
+		}
+	}
+	if _, err := LoadLocation("Nowhere Standard Time"); err == nil {
+		t.Error("unknown name: no error")
+	}
+}
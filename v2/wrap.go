@@ -0,0 +1,67 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+// As returns c as T (an optional interface, such as Flagger or ConnectInfoReporter) -
+// or the first Client wrapped by c (such as by CircuitBreaker, DryRun or WithReadOnlyDelivery)
+// which implements T, if the wrappers pass T through.
+//
+// Use As instead of a type assertion, as the wrappers cannot implement the optional interfaces
+// conditionally - DryRun and WithReadOnlyDelivery do not pass through the ones changing the mailboxes.
+func As[T any](c Client) (T, bool) {
+	for c != nil {
+		if t, ok := c.(T); ok {
+			return t, true
+		}
+		w, ok := c.(wrapper)
+		if !ok {
+			break
+		}
+		c = w.unwrap((*T)(nil))
+	}
+	var zero T
+	return zero, false
+}
+
+// wrapper is implemented by the Client wrappers which pass through the optional interfaces
+// of the wrapped Client.
+type wrapper interface {
+	// unwrap returns the wrapped Client, or nil if the interface (given as a nil *T)
+	// must not be used through the wrapper.
+	unwrap(iface any) Client
+}
+
+var (
+	_ wrapper = (*CircuitBreaker)(nil)
+	_ wrapper = (*dryRunClient)(nil)
+	_ wrapper = (*readDeliveryClient)(nil)
+)
+
+// mutating reports whether the optional interface (given as a nil *T) changes the mailboxes
+// or sends messages.
+func mutating(iface any) bool {
+	switch iface.(type) {
+	case *Flagger, *bulker, *FlagAppender, *condStorer, *annotator,
+		*Snoozer, *folderPathEnsurer, *gmailer, *replier, *MailboxManager:
+		return true
+	}
+	return false
+}
+
+func (cb *CircuitBreaker) unwrap(any) Client { return cb.Client }
+
+func (c *dryRunClient) unwrap(iface any) Client {
+	if mutating(iface) {
+		return nil
+	}
+	return c.Client
+}
+
+func (c *readDeliveryClient) unwrap(iface any) Client {
+	if mutating(iface) {
+		return nil
+	}
+	return c.Client
+}
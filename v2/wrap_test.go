@@ -0,0 +1,95 @@
+// Copyright 2024 Tamás Gulácsi. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package imapclient
+
+import (
+	"context"
+	"testing"
+)
+
+// optionalClient implements some of the optional interfaces, its embedded Client is nil.
+type optionalClient struct {
+	Client
+	readOnly bool
+}
+
+func (c *optionalClient) SetReadOnly(readOnly bool)                               { c.readOnly = readOnly }
+func (c *optionalClient) ConnectInfo() ConnectInfo                                { return ConnectInfo{Addr: "inner"} }
+func (c *optionalClient) SetFlags(context.Context, uint32, bool, ...string) error { return nil }
+func (c *optionalClient) MoveSet(context.Context, SeqSet, string) error           { return nil }
+func (c *optionalClient) MarkSet(context.Context, SeqSet, bool) error             { return nil }
+func (c *optionalClient) DeleteSet(context.Context, SeqSet) error                 { return nil }
+func (c *optionalClient) ReplyMessage(context.Context, uint32, string, string, bool, [][2]string) error {
+	return nil
+}
+
+func TestAs(t *testing.T) {
+	inner := &optionalClient{}
+	breaker := NewCircuitBreaker(inner, 1, 0)
+	dry := DryRun(inner, nil)
+	rd := (&readOnlyDelivery{store: make(memDedup)}).client(inner, "")
+
+	// found reports whether As finds T in c, and whether it is the inner Client.
+	found := func(c Client, f func(Client) (any, bool)) (bool, bool) {
+		v, ok := f(c)
+		return ok, ok && v == any(inner)
+	}
+	flagger := func(c Client) (any, bool) { return As[Flagger](c) }
+	bulk := func(c Client) (any, bool) { return As[bulker](c) }
+	reply := func(c Client) (any, bool) { return As[replier](c) }
+	ros := func(c Client) (any, bool) { return As[ReadOnlySetter](c) }
+	cir := func(c Client) (any, bool) { return As[ConnectInfoReporter](c) }
+	appender := func(c Client) (any, bool) { return As[FlagAppender](c) }
+	manager := func(c Client) (any, bool) { return As[MailboxManager](c) }
+	for _, tc := range []struct {
+		Name   string
+		Client Client
+		Find   func(Client) (any, bool)
+		// Found: As finds it, Inner: in the wrapped Client.
+		Found, Inner bool
+	}{
+		{"breaker/Flagger", breaker, flagger, true, true},
+		{"breaker/bulker", breaker, bulk, true, true},
+		{"breaker/replier", breaker, reply, true, true},
+		{"breaker/ReadOnlySetter", breaker, ros, true, true},
+		{"breaker/ConnectInfoReporter", breaker, cir, true, true},
+		{"breaker/FlagAppender", breaker, appender, false, false},
+		{"breaker/MailboxManager", breaker, manager, false, false},
+
+		{"dryRun/Flagger", dry, flagger, true, false},
+		{"dryRun/bulker", dry, bulk, true, false},
+		{"dryRun/replier", dry, reply, false, false},
+		{"dryRun/ReadOnlySetter", dry, ros, true, true},
+		{"dryRun/ConnectInfoReporter", dry, cir, true, true},
+		{"dryRun/FlagAppender", dry, appender, true, false},
+		{"dryRun/MailboxManager", dry, manager, true, false},
+
+		{"readDelivery/Flagger", rd, flagger, false, false},
+		{"readDelivery/bulker", rd, bulk, false, false},
+		{"readDelivery/replier", rd, reply, false, false},
+		{"readDelivery/ReadOnlySetter", rd, ros, true, true},
+		{"readDelivery/ConnectInfoReporter", rd, cir, true, true},
+		{"readDelivery/MailboxManager", rd, manager, true, false},
+	} {
+		if ok, isInner := found(tc.Client, tc.Find); ok != tc.Found || isInner != tc.Inner {
+			t.Errorf("%s: got found=%t inner=%t, wanted %t, %t", tc.Name, ok, isInner, tc.Found, tc.Inner)
+		}
+	}
+
+	// The stack of a loop of a config.Manager account with WithReadOnlyDelivery and WithDryRun.
+	inner.readOnly = false
+	c := loopOptions{readOnly: &readOnlyDelivery{store: make(memDedup)}, dryRun: true}.client(breaker, nil)
+	if !inner.readOnly {
+		t.Error("WithReadOnlyDelivery through a CircuitBreaker did not switch to EXAMINE")
+	}
+	if ci, ok := As[ConnectInfoReporter](c); !ok || ci.ConnectInfo().Addr != "inner" {
+		t.Errorf("ConnectInfo through the stack: %v, %t", ci, ok)
+	}
+	if _, ok := As[Flagger](c); !ok {
+		t.Error("no Flagger of DryRun")
+	} else if _, ok := As[Flagger](c.(*dryRunClient).Client); ok {
+		t.Error("Flagger through WithReadOnlyDelivery")
+	}
+}